@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/vt"
+)
+
+// prefixKey is Ctrl-B, tmux's default prefix. Prefix then d detaches;
+// prefix twice sends a literal Ctrl-B.
+const prefixKey = 0x02
+
+func executeAttach(cmd *cli.Command) int {
+	colors := cmd.Colors
+	if colors == "" {
+		colors = terminalColors()
+	}
+	if err := attachSession(cmd.SocketPath, cmd.Target, colors, cmd.Delta, cmd.LocalEcho); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// terminalColors guesses this terminal's color depth from COLORTERM and
+// TERM, as programs without terminfo do. Windows consoles set neither and
+// show 24-bit color (conhost since Windows 10, Windows Terminal).
+func terminalColors() string {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return "truecolor"
+	}
+	term := os.Getenv("TERM")
+	switch {
+	case term == "" && runtime.GOOS == "windows", strings.HasSuffix(term, "-direct"):
+		return "truecolor"
+	case strings.Contains(term, "256color"):
+		return "256"
+	}
+	return "16"
+}
+
+// attachSession connects the terminal to the session until the user
+// detaches or the session exits. Output is converted for a terminal
+// with the given color depth, or with delta only the rows of the screen
+// that changed are sent. With predict, typing is shown before the pane
+// echoes it (see localEcho). target is the -t the daemon checks.
+func attachSession(socketPath, target, colors string, delta, predict bool) error {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return errors.New("attach requires a terminal")
+	}
+	conn, err := ipc.Connect(socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cols, rows := terminalSize()
+	if err := ipc.WriteMessage(conn, ipc.Request{
+		Action:   ipc.ActionAttach,
+		Client:   ipc.ClientName(),
+		Target:   target,
+		Compress: ipc.CompressionEnabled(),
+		Width:    cols,
+		Height:   rows,
+		Colors:   colors,
+		Delta:    delta,
+	}); err != nil {
+		return err
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+
+	restore, err := makeRaw()
+	if err != nil {
+		return err
+	}
+	write := func(data []byte) { os.Stdout.Write(data) }
+	var echo *localEcho
+	if predict && cols > 0 && rows > 0 {
+		echo = newLocalEcho(os.Stdout, cols, rows)
+		write = echo.output
+	}
+	write([]byte(resp.Output))
+
+	var detached atomic.Bool
+	go func() {
+		var f detachFilter
+		in := terminalInput()
+		buf := make([]byte, 4096)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				data, detach := f.feed(buf[:n])
+				if len(data) > 0 {
+					if echo != nil {
+						echo.input(data)
+					}
+					if ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionSendKeys, Data: data}) != nil {
+						return
+					}
+				}
+				if detach {
+					detached.Store(true)
+					conn.Close()
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	reason := "lost server"
+	for {
+		var ev ipc.Response
+		if err := ipc.ReadMessage(conn, &ev); err != nil {
+			break
+		}
+		if len(ev.Data) > 0 {
+			write(ev.Data)
+		}
+		if ev.Output != "" {
+			reason = ev.Output
+		}
+	}
+	if echo != nil {
+		echo.close()
+	}
+	// Stop any focus reports focus-events had the terminal send, and leave
+	// the keyboard protocol the pane's application may have set.
+	os.Stdout.WriteString("\x1b[?1004l" + vt.ResetKeyMode)
+	restore()
+	if detached.Load() {
+		reason = "detached"
+	}
+	fmt.Printf("\r\n[%s]\n", reason)
+	return nil
+}
+
+// detachFilter watches keyboard input for the detach key, passing
+// everything else through.
+type detachFilter struct {
+	prefixed bool
+}
+
+// feed returns the input to forward and whether the detach key was seen;
+// input after it is discarded.
+func (f *detachFilter) feed(in []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(in))
+	for _, b := range in {
+		if f.prefixed {
+			f.prefixed = false
+			switch b {
+			case 'd':
+				return out, true
+			case prefixKey:
+				out = append(out, prefixKey)
+			default:
+				out = append(out, prefixKey, b)
+			}
+			continue
+		}
+		if b == prefixKey {
+			f.prefixed = true
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
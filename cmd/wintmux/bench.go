@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+)
+
+// benchStages are the latencies bench measures for each probe, in the
+// order they are reported.
+var benchStages = []struct {
+	name string
+	help string
+}{
+	{"ipc", "a display-message round trip: the cost of any wintmux request"},
+	{"send", "send-keys of the probe until the daemon wrote it to the pane"},
+	{"echo", "from sending the probe until the daemon read its output"},
+	{"capture", "a capture-pane that shows the probe's output"},
+}
+
+// bench measures one session's latencies with probes: each is `echo`
+// of a nonce typed into the pane, whose output line a temporary watch
+// reports as soon as the daemon reads it.
+type bench struct {
+	socket  string
+	timeout time.Duration
+	watch   string
+	since   int64 // last watch event seen
+	pipes   bool  // the exec backend: lines end with a newline, not Enter, and are not drawn on a screen
+}
+
+// executeBench sends cmd.BenchCount probes one after the other and
+// prints percentiles of each stage. The pane must be at a shell prompt;
+// cmd.exe, PowerShell and POSIX shells all have echo. Echo time far above
+// ipc and send time is spent in the console and the application, not in
+// wintmux.
+func executeBench(cmd *cli.Command) int {
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	b := &bench{socket: cmd.SocketPath, timeout: cmd.Timeout, watch: "bench-" + run}
+	resp, err := b.request(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_backend}"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	b.pipes = resp.Output == "exec"
+	if _, err := b.request(ipc.Request{
+		Action:  ipc.ActionWatchAdd,
+		Name:    b.watch,
+		Pattern: `^\s*wtb-` + run + `-\d+\s*$`,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	defer b.request(ipc.Request{Action: ipc.ActionWatchRemove, Name: b.watch})
+
+	samples := make([][]time.Duration, len(benchStages))
+	lost := 0
+	for i := 1; i <= cmd.BenchCount; i++ {
+		times, err := b.probe(fmt.Sprintf("wtb-%s-%d", run, i))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: probe %d: %v\n", i, err)
+			lost++
+			if i == 1 || lost > cmd.BenchCount/2 {
+				fmt.Fprintf(os.Stderr, "wintmux: giving up: is the pane at a shell prompt?\n")
+				return 1
+			}
+			continue
+		}
+		for s, d := range times {
+			samples[s] = append(samples[s], d)
+		}
+	}
+
+	fmt.Printf("%d probes, %d lost (tcp: %s)\n", cmd.BenchCount, lost, ipc.DefaultTCP)
+	fmt.Printf("%-8s %9s %9s %9s %9s\n", "", "p50", "p90", "p99", "max")
+	for s, stage := range benchStages {
+		d := samples[s]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		fmt.Printf("%-8s %9s %9s %9s %9s  %s\n", stage.name,
+			benchMs(percentile(d, 0.5)), benchMs(percentile(d, 0.9)), benchMs(percentile(d, 0.99)), benchMs(percentile(d, 1)),
+			stage.help)
+	}
+	if lost > 0 {
+		return 1
+	}
+	return 0
+}
+
+// probe types `echo nonce` and times each stage.
+func (b *bench) probe(nonce string) ([]time.Duration, error) {
+	times := make([]time.Duration, len(benchStages))
+	start := time.Now()
+	if _, err := b.request(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_pid}"}); err != nil {
+		return nil, err
+	}
+	times[0] = time.Since(start)
+
+	req := ipc.Request{Action: ipc.ActionSendKeys, Text: "echo " + nonce, SendEnter: true}
+	if b.pipes {
+		req.Text, req.SendEnter = req.Text+"\n", false
+	}
+	start = time.Now()
+	if _, err := b.request(req); err != nil {
+		return nil, err
+	}
+	times[1] = time.Since(start)
+	if err := b.waitOutput(nonce); err != nil {
+		return nil, err
+	}
+	times[2] = time.Since(start)
+
+	capture := ipc.Request{Action: ipc.ActionCapture}
+	if b.pipes {
+		capture.Strip = "text"
+	}
+	start = time.Now()
+	resp, err := b.request(capture)
+	if err != nil {
+		return nil, err
+	}
+	if !regexp.MustCompile(`(?m)^\s*` + nonce + `\s*$`).MatchString(resp.Output) {
+		return nil, fmt.Errorf("output of %q is not in the capture", nonce)
+	}
+	times[3] = time.Since(start)
+	return times, nil
+}
+
+// waitOutput waits for the bench watch to report nonce's output line.
+// Reports of earlier, lost probes are skipped.
+func (b *bench) waitOutput(nonce string) error {
+	deadline := time.Now().Add(b.timeout)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return fmt.Errorf("no output within %v", b.timeout)
+		}
+		resp, err := ipc.SendRequestTimeout(b.socket, &ipc.Request{
+			Action:    ipc.ActionWaitEvent,
+			EventType: "watch",
+			Since:     b.since,
+			Format:    "#{event_seq}\t#{watch_name}\t#{watch_match}",
+			TimeoutMs: int(left / time.Millisecond),
+		}, left+5*time.Second)
+		if err != nil {
+			return err
+		}
+		if !resp.OK {
+			return fmt.Errorf("no output within %v", b.timeout)
+		}
+		found := false
+		for _, line := range strings.Split(resp.Output, "\n") {
+			f := strings.SplitN(line, "\t", 3)
+			if len(f) != 3 {
+				continue
+			}
+			if seq, err := strconv.ParseInt(f[0], 10, 64); err == nil && seq > b.since {
+				b.since = seq
+			}
+			if f[1] == b.watch && strings.TrimSpace(f[2]) == nonce {
+				found = true
+			}
+		}
+		if found {
+			return nil
+		}
+	}
+}
+
+// request sends req to the session, turning an error reply into an error.
+func (b *bench) request(req ipc.Request) (*ipc.Response, error) {
+	resp, err := ipc.SendRequest(b.socket, &req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// percentile returns the q-th quantile of sorted durations, by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// benchMs formats d in milliseconds.
+func benchMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64) + "ms"
+}
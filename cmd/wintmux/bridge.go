@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+)
+
+func executeBridge(cmd *cli.Command) int {
+	if err := bridgeSession(cmd.SocketPath, cmd.Target, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// bridgeSession relays raw bytes between in/out and the session's pane:
+// pane output is written to out as it arrives and whatever is read from
+// in is sent to the pane unchanged. Unlike attach it needs no console,
+// does no repaint and has no detach key, so another program can run it
+// as a subprocess and talk to the pane over its pipes. It returns when in
+// reaches EOF, leaving the session running, or when the pane exits.
+// target is the -t the daemon checks.
+func bridgeSession(socketPath, target string, in io.Reader, out io.Writer) error {
+	conn, err := ipc.Connect(socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := ipc.WriteMessage(conn, ipc.Request{
+		Action: ipc.ActionBridge,
+		Client: ipc.ClientName(),
+		Target: target,
+	}); err != nil {
+		return err
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+
+	var inputEnded atomic.Bool
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				data := append([]byte(nil), buf[:n]...)
+				if ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionSendKeys, Data: data}) != nil {
+					return
+				}
+			}
+			if err != nil {
+				// Closing the connection ends the output loop below.
+				inputEnded.Store(true)
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	reason := ""
+	for {
+		var ev ipc.Response
+		if err := ipc.ReadMessage(conn, &ev); err != nil {
+			break
+		}
+		if len(ev.Data) > 0 {
+			if _, err := out.Write(ev.Data); err != nil {
+				return err
+			}
+		}
+		if ev.Output != "" {
+			reason = ev.Output
+		}
+	}
+	switch {
+	case inputEnded.Load(), reason == "exited":
+		return nil
+	case reason == "":
+		return errors.New("lost server")
+	}
+	return errors.New(reason)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/registry"
+)
+
+// executeCaptureAll captures every pane of the -S session, or of every
+// running session with --all (or when no -S is given), with each pane's
+// position and cursor state. Sessions are asked concurrently, one request
+// each, so a dashboard refresh costs one round trip per session at most.
+func executeCaptureAll(cmd *cli.Command) int {
+	lines := 50
+	if cmd.StartLine < 0 {
+		lines = -cmd.StartLine
+	}
+	req := ipc.Request{
+		Action: ipc.ActionCaptureAll,
+		Lines:  lines,
+		Frame:  cmd.Frame,
+		Strip:  cmd.Strip,
+	}
+
+	var targets []ipc.Target
+	if cmd.AllClients || cmd.SocketPath == "" {
+		entries, err := registry.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+		for _, e := range entries {
+			targets = append(targets, ipc.Target{Session: e.Session, Socket: e.Socket})
+		}
+	} else {
+		socket := cmd.SocketPath
+		if abs, err := filepath.Abs(socket); err == nil {
+			socket = abs
+		}
+		targets = []ipc.Target{{Session: cmd.Target, Socket: socket}}
+	}
+
+	panes := ipc.GatherCaptures(targets, func(socket string) (*ipc.Response, error) {
+		r := req
+		return ipc.SendRequest(socket, &r)
+	})
+
+	status := 0
+	for _, p := range panes {
+		if p.Error != "" {
+			fmt.Fprintf(os.Stderr, "wintmux: %s: %s\n", p.Socket, p.Error)
+			status = 1
+		}
+	}
+	if cmd.CaptureFormat == "json" {
+		if panes == nil {
+			panes = []ipc.PaneCapture{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(panes)
+		return status
+	}
+	var out strings.Builder
+	for _, p := range panes {
+		if p.Error != "" {
+			continue
+		}
+		state := ""
+		if p.Alternate {
+			state += " alternate"
+		}
+		if p.Dead {
+			state += " dead"
+		}
+		fmt.Fprintf(&out, "== %s:%d.%d %s %dx%d cursor %d,%d%s (%s)\n",
+			p.Session, p.WindowIndex, p.PaneIndex, p.PaneID, p.Width, p.Height, p.CursorX, p.CursorY, state, p.Socket)
+		for _, line := range p.Lines {
+			out.WriteString(line + "\n")
+		}
+	}
+	if out.Len() > 0 {
+		printCapture(out.String(), cmd)
+	}
+	return status
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+)
+
+// executeCloneSession starts a session like the one on cmd.SocketPath:
+// in the same directory, with the same environment, backend, secret
+// references and options, running the default shell or, with --replay,
+// the source's startup command. A clone that cannot be given the
+// source's options is killed rather than left half-configured.
+func executeCloneSession(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{Action: ipc.ActionCloneInfo, Target: cmd.Target})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	s, err := ipc.ParseSettings(resp.Output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: invalid clone_info response: %v\n", err)
+		return 1
+	}
+
+	socket := cmd.CloneSocket
+	if socket == "" {
+		socket = filepath.Join(filepath.Dir(cmd.SocketPath), cmd.SessionName+".sock")
+	}
+	if sessionRunning(socket) {
+		fmt.Fprintf(os.Stderr, "wintmux: a session is already running on %s\n", socket)
+		return 1
+	}
+
+	command := ""
+	if cmd.Replay {
+		command = s.Command
+	}
+	var args []string
+	if s.Backend != "" {
+		args = append(args, "--backend", s.Backend)
+	}
+	for _, e := range s.SecretEnv {
+		args = append(args, "--secret-env", e)
+	}
+	restore := setEnviron(s.Env)
+	pid, err := spawnDaemon(socket, cmd.SessionName, s.Dir, command, args)
+	restore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: failed to create session: %v\n", err)
+		return 1
+	}
+	if err := waitForDaemon(socket, pid, cmd.StartupTimeout, cmd.StartupInterval); err != nil {
+		var se *startupError
+		if errors.As(err, &se) {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "wintmux: session created but %v\n", err)
+		return 1
+	}
+
+	if err := applyOptions(socket, s.Options); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v; killing the session\n", err)
+		ipc.SendRequest(socket, &ipc.Request{Action: ipc.ActionKillSession})
+		return 1
+	}
+
+	cmd.SocketPath = socket
+	if !cmd.Detached && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		return executeAttach(cmd)
+	}
+	return 0
+}
+
+// applyOptions sets options on the session at socket, in name order.
+func applyOptions(socket string, options map[string]string) error {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resp, err := ipc.SendRequest(socket, &ipc.Request{
+			Action: ipc.ActionSetOption,
+			Option: name,
+			Value:  options[name],
+		})
+		if err != nil {
+			return err
+		}
+		if !resp.OK {
+			return fmt.Errorf("option %s: %s", name, resp.Error)
+		}
+	}
+	return nil
+}
+
+// setEnviron makes env this process's environment, for the daemon
+// clone-session spawns to inherit, and returns a function that puts the
+// old one back. Entries without a name (Windows keeps each drive's
+// directory as =C:=C:\dir) are left alone.
+func setEnviron(env []string) (restore func()) {
+	old := os.Environ()
+	replace := func(from, to []string) {
+		keep := make(map[string]bool, len(to))
+		for _, e := range to {
+			if name, value, _ := strings.Cut(e, "="); name != "" {
+				keep[name] = true
+				os.Setenv(name, value)
+			}
+		}
+		for _, e := range from {
+			if name, _, _ := strings.Cut(e, "="); name != "" && !keep[name] {
+				os.Unsetenv(name)
+			}
+		}
+	}
+	replace(old, env)
+	return func() { replace(env, old) }
+}
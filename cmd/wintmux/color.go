@@ -0,0 +1,46 @@
+package main
+
+import "os"
+
+// colorOutput is whether wintmux colors its own output (list-sessions
+// health, status, selftest results; never a pane's output). main sets it
+// from --color with setColor.
+var colorOutput bool
+
+// setColor decides colorOutput from --color: "always", "never", or
+// "auto" (or empty), which colors a terminal unless NO_COLOR is set to
+// anything (see no-color.org) or TERM is dumb.
+func setColor(mode string) {
+	switch mode {
+	case "always":
+		enableColorOutput()
+		colorOutput = true
+	case "never":
+		colorOutput = false
+	default:
+		colorOutput = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" &&
+			isTerminal(os.Stdout) && enableColorOutput()
+	}
+}
+
+// paint wraps text in the SGR attributes sgr when output is colored.
+func paint(text, sgr string) string {
+	if !colorOutput || text == "" {
+		return text
+	}
+	return "\x1b[" + sgr + "m" + text + "\x1b[0m"
+}
+
+// paintState colors a session state word: green when all is well,
+// yellow while it winds down, red when it is gone.
+func paintState(state string) string {
+	switch state {
+	case "running":
+		return paint(state, "32")
+	case "draining", "child-exited":
+		return paint(state, "33")
+	case "stale", "stopped":
+		return paint(state, "31")
+	}
+	return state
+}
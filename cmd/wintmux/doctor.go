@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/pty"
+)
+
+// executeDoctor reports the terminal backend this machine provides and
+// which optional features (ConPTY flags, winpty) it supports. The enabled column shows
+// the flags of the -S session's pane, or without -S the flags
+// WINTMUX_CONPTY_FLAGS would give new sessions. Exits 1 if there is no
+// usable backend or the session cannot be asked.
+func executeDoctor(cmd *cli.Command) int {
+	sys := pty.Detect()
+	fmt.Printf("wintmux %s (%s/%s)\n", version, runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("os:      %s\n", sys.OS)
+	status := 0
+	if v := os.Getenv("WINTMUX_BACKEND"); v != "" && v != "auto" {
+		if err := pty.SetBackend(v); err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: WINTMUX_BACKEND: %v\n", err)
+			status = 1
+		}
+		fmt.Printf("backend: %s (WINTMUX_BACKEND; auto would pick %s)\n", v, sys.Backend)
+	} else {
+		fmt.Printf("backend: %s\n", sys.Backend)
+	}
+	if sys.Backend == "none" {
+		fmt.Fprintln(os.Stderr, "wintmux: no terminal backend: ConPTY needs Windows 10 1809 or later, and winpty is not installed")
+		status = 1
+	}
+
+	enabled, err := pty.ParseFlags(os.Getenv("WINTMUX_CONPTY_FLAGS"))
+	source := "WINTMUX_CONPTY_FLAGS"
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: WINTMUX_CONPTY_FLAGS: %v\n", err)
+		status = 1
+	}
+	if cmd.SocketPath != "" {
+		source = "session"
+		resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+			Action: ipc.ActionDisplay,
+			Format: "#{session_name} #{pane_backend} #{conpty_flags}",
+		})
+		if err == nil && !resp.OK {
+			err = fmt.Errorf("%s", resp.Error)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+		fields := strings.Fields(resp.Output)
+		if len(fields) == 3 {
+			fmt.Printf("session: %s (backend %s)\n", fields[0], fields[1])
+			enabled, _ = pty.ParseFlags(fields[2])
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "FEATURE\tSUPPORTED\tENABLED (%s)\tNOTE\n", source)
+	for _, f := range sys.Features {
+		on := "-"
+		if f.Flag != 0 {
+			on = yesNo(enabled&f.Flag != 0)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Name, yesNo(f.Supported), on, f.Note)
+	}
+	w.Flush()
+	if missing := enabled &^ sys.Supported(enabled); missing != 0 {
+		fmt.Fprintf(os.Stderr, "wintmux: enabled but not supported here: %s\n", missing)
+		status = 1
+	}
+	return status
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"wintmux/internal/screen"
+)
+
+// localEcho shows characters typed into an attached session before the
+// pane echoes them, as mosh does, so typing over a slow link does not
+// lag: they appear underlined at once and are taken back when the pane's
+// own output arrives. It feeds a screen exactly what the terminal is
+// sent, so it knows where the terminal's cursor is and what is around it.
+//
+// It is deliberately narrow. Only printable ASCII typed on the main
+// screen into blank cells that fit on the cursor's row is predicted;
+// anything else (Enter, Backspace, cursor keys, non-ASCII text) takes the
+// predictions back and ends them. Typing is only shown once the pane has
+// echoed a character of it, so what is typed at a password prompt, which
+// is not echoed, is never shown.
+type localEcho struct {
+	mu         sync.Mutex
+	out        io.Writer
+	screen     *screen.Screen
+	cols, rows int
+	pending    []byte // typed characters the pane has not echoed yet
+	x, y       int    // where pending[0] goes
+	shown      int    // how many of pending are on the terminal, from the first
+	confirmed  bool   // the pane echoes what is typed at this cursor
+	off        bool   // the terminal was resized: the screen no longer matches it
+}
+
+func newLocalEcho(out io.Writer, cols, rows int) *localEcho {
+	return &localEcho{out: out, screen: screen.New(cols, rows), cols: cols, rows: rows}
+}
+
+// input is called with keyboard input before it is sent to the pane.
+func (e *localEcho) input(data []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.off {
+		return
+	}
+	if cols, rows := terminalSize(); cols != e.cols || rows != e.rows {
+		e.reset()
+		e.off = true
+		return
+	}
+	if !printable(data) || !e.predictable() {
+		e.reset()
+		return
+	}
+	if len(e.pending) == 0 {
+		cur := e.screen.Cursor()
+		e.x, e.y = cur.X, cur.Y
+	}
+	for _, b := range data {
+		e.pending = append(e.pending, b)
+		if e.confirmed && e.shown == len(e.pending)-1 && e.fits(len(e.pending)) {
+			fmt.Fprintf(e.out, "\x1b[4m%c\x1b[24m", b)
+			e.shown++
+		}
+	}
+}
+
+// output writes pane output to the terminal, taking the predictions
+// shown back first, and shows those the pane has not echoed yet again
+// after it.
+func (e *localEcho) output(data []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hide()
+	e.out.Write(data)
+	e.screen.Write(data)
+	if e.off {
+		return
+	}
+	e.check()
+	if e.confirmed && len(e.pending) > 0 && e.predictable() && e.fits(len(e.pending)) {
+		fmt.Fprintf(e.out, "\x1b[4m%s\x1b[24m", e.pending)
+		e.shown = len(e.pending)
+	}
+}
+
+// check drops the predictions the pane has echoed: those now on the
+// screen with the cursor past them. If the cursor went elsewhere, the
+// pane did not echo the rest as predicted and they are dropped too.
+func (e *localEcho) check() {
+	cur := e.screen.Cursor()
+	n := 0
+	for n < len(e.pending) && e.screen.Cell(e.x+n, e.y) == rune(e.pending[n]) &&
+		(cur.Y > e.y || cur.Y == e.y && cur.X > e.x+n) {
+		n++
+	}
+	if n > 0 {
+		e.confirmed = true
+		e.pending = e.pending[n:]
+		e.x += n
+	}
+	if len(e.pending) > 0 && (cur.X != e.x || cur.Y != e.y) {
+		e.pending = nil
+		e.confirmed = false
+	}
+}
+
+// predictable reports whether the terminal is in a state to show
+// predictions in: between escape sequences, on the main screen, with the
+// cursor visible.
+func (e *localEcho) predictable() bool {
+	cur := e.screen.Cursor()
+	return e.screen.BetweenFrames() && !cur.Alternate && cur.Visible
+}
+
+// fits reports whether the first n predictions can be shown: their cells
+// are blank and they leave the last column free, so the terminal never
+// wraps.
+func (e *localEcho) fits(n int) bool {
+	if e.x+n >= e.cols {
+		return false
+	}
+	for i := e.shown; i < n; i++ {
+		if e.screen.Cell(e.x+i, e.y) != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// hide takes the predictions shown back, leaving the terminal's cursor
+// where the pane put it.
+func (e *localEcho) hide() {
+	if e.shown > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD\x1b[%dX", e.shown, e.shown)
+		e.shown = 0
+	}
+}
+
+// reset takes the predictions back and forgets them; typing is not
+// shown again until the pane echoes some.
+func (e *localEcho) reset() {
+	e.hide()
+	e.pending = nil
+	e.confirmed = false
+}
+
+// close takes the predictions back before the client leaves the terminal.
+func (e *localEcho) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reset()
+}
+
+func printable(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return len(data) > 0
+}
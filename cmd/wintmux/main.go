@@ -1,15 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"wintmux/internal/cli"
 	"wintmux/internal/daemon"
 	"wintmux/internal/ipc"
+	"wintmux/internal/pty"
+	"wintmux/internal/vt"
+	"wintmux/internal/winpath"
 )
 
 const version = "0.1.0"
@@ -18,7 +26,7 @@ func main() {
 	args := os.Args[1:]
 
 	if len(args) == 0 {
-		printUsage()
+		printUsage(os.Stderr)
 		os.Exit(1)
 	}
 
@@ -32,6 +40,10 @@ func main() {
 		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
 		os.Exit(1)
 	}
+	// Orchestrators hand over /mnt/c paths, C:\ paths and mixed
+	// separators whatever the platform.
+	cmd.SocketPath = winpath.Normalize(cmd.SocketPath)
+	setColor(cmd.Color)
 
 	if cmd.DaemonMode {
 		runDaemon(cmd)
@@ -43,68 +55,216 @@ func main() {
 
 func runDaemon(cmd *cli.Command) {
 	workdir := cmd.StartDir
-	if workdir == "" {
-		workdir, _ = os.Getwd()
+	spec, err := pty.ParseSpec(cmd.Backend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "daemon error: %v\n", err)
+		os.Exit(1)
 	}
-	if err := daemon.Run(cmd.SocketPath, cmd.SessionName, workdir, cmd.ShellCmd, 120, 40); err != nil {
+	if !spec.Remote() {
+		if workdir == "" {
+			workdir, _ = os.Getwd()
+		}
+		workdir = winpath.Normalize(workdir)
+	}
+	if err := daemon.Run(cmd.SocketPath, cmd.SessionName, workdir, cmd.ShellCmd, spec, 120, 40, listenAddrs(cmd), cmd.SecretEnv, cmd.TTL); err != nil {
 		fmt.Fprintf(os.Stderr, "daemon error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// listenAddrs turns new-session's --bind and --port into the addresses
+// the daemon listens on; nil means the daemon default.
+func listenAddrs(cmd *cli.Command) []string {
+	if len(cmd.Bind) == 0 && cmd.Port == 0 {
+		return nil
+	}
+	hosts := cmd.Bind
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.1"}
+	}
+	addrs := make([]string, len(hosts))
+	for i, h := range hosts {
+		addrs[i] = net.JoinHostPort(h, strconv.Itoa(cmd.Port))
+	}
+	return addrs
+}
+
+// daemonArgs passes the client's --bind, --port, --backend (which
+// --container, --ssh and --serial set), --secret-env and --ttl on to the
+// daemon it spawns. Secrets travel as references; the daemon fetches
+// them.
+func daemonArgs(cmd *cli.Command) []string {
+	var args []string
+	if cmd.Backend != "" {
+		args = append(args, "--backend", cmd.Backend)
+	}
+	if len(cmd.Bind) > 0 {
+		args = append(args, "--bind", strings.Join(cmd.Bind, ","))
+	}
+	if cmd.Port != 0 {
+		args = append(args, "--port", strconv.Itoa(cmd.Port))
+	}
+	for _, e := range cmd.SecretEnv {
+		args = append(args, "--secret-env", e)
+	}
+	if cmd.TTL > 0 {
+		args = append(args, "--ttl", cmd.TTL.String())
+	}
+	return args
+}
+
+// daemonArgv is the argument list spawnDaemon starts the daemon with,
+// after the executable's path. Every value is an argument of its own and
+// the command follows "--", so quotes, carets, spaces, a leading dash or
+// non-ASCII text reach the daemon exactly as the client was given them.
+func daemonArgv(socketPath, sessionName, workdir, command string, extra []string) []string {
+	args := []string{"--daemon", "-S", socketPath, "new-session", "-d", "-s", sessionName}
+	if workdir != "" {
+		args = append(args, "-c", workdir)
+	}
+	args = append(args, extra...)
+	if command != "" {
+		args = append(args, "--", command)
+	}
+	return args
+}
+
+// execute runs cmd with the handler its spec names.
 func execute(cmd *cli.Command) int {
-	switch cmd.Type {
-	case cli.CmdNewSession:
-		return executeNewSession(cmd)
-	case cli.CmdSendKeys:
-		return executeSendKeys(cmd)
-	case cli.CmdCapturePane:
-		return executeCapturePane(cmd)
-	case cli.CmdHasSession:
-		return executeHasSession(cmd)
-	case cli.CmdKillSession:
-		return executeKillSession(cmd)
-	case cli.CmdSetOption:
-		return executeSetOption(cmd)
-	case cli.CmdPipePane:
-		return executePipePane(cmd)
-	case cli.CmdAttach:
-		fmt.Fprintln(os.Stderr, "wintmux: attach not yet implemented")
-		return 1
-	default:
+	spec, ok := cli.LookupType(cmd.Type)
+	if !ok || spec.Run == nil {
 		fmt.Fprintln(os.Stderr, "wintmux: command not implemented")
 		return 1
 	}
+	return spec.Run(cmd)
+}
+
+// init gives every command spec its handler; package cli declares the
+// commands but cannot refer to the handlers here.
+func init() {
+	list := func(action ipc.Action) func(*cli.Command) int {
+		return func(cmd *cli.Command) int { return executeList(cmd, action) }
+	}
+	with := func(run func(*cli.Command, ipc.Action) int, action ipc.Action) func(*cli.Command) int {
+		return func(cmd *cli.Command) int { return run(cmd, action) }
+	}
+	for t, run := range map[cli.CommandType]func(*cli.Command) int{
+		cli.CmdNewSession:       executeNewSession,
+		cli.CmdCloneSession:     executeCloneSession,
+		cli.CmdSendKeys:         executeSendKeys,
+		cli.CmdSendText:         executeSendText,
+		cli.CmdRunPS:            executeRunPS,
+		cli.CmdCapturePane:      executeCapturePane,
+		cli.CmdCaptureAll:       executeCaptureAll,
+		cli.CmdHasSession:       executeHasSession,
+		cli.CmdKillSession:      executeKillSession,
+		cli.CmdSetOption:        executeSetOption,
+		cli.CmdPipePane:         with(executePipe, ipc.ActionPipePane),
+		cli.CmdPipeAdd:          with(executePipe, ipc.ActionPipeAdd),
+		cli.CmdPipeList:         list(ipc.ActionPipeList),
+		cli.CmdPipeRemove:       with(executePipe, ipc.ActionPipeRemove),
+		cli.CmdMirrorPane:       executeMirrorPane,
+		cli.CmdDisplayMessage:   executeDisplayMessage,
+		cli.CmdWaitPrompt:       executeWaitPrompt,
+		cli.CmdWaitStable:       executeWaitStable,
+		cli.CmdWaitEvent:        executeWaitEvent,
+		cli.CmdShowEnvironment:  executeShowEnvironment,
+		cli.CmdListClients:      list(ipc.ActionListClients),
+		cli.CmdListProcesses:    list(ipc.ActionListProcesses),
+		cli.CmdListCommands:     list(ipc.ActionListCommands),
+		cli.CmdListLinks:        list(ipc.ActionListLinks),
+		cli.CmdShowExits:        list(ipc.ActionShowExits),
+		cli.CmdHelp:             executeHelp,
+		cli.CmdCompletion:       executeCompletion,
+		cli.CmdLockClient:       with(executeClientControl, ipc.ActionLockClient),
+		cli.CmdUnlockClient:     with(executeClientControl, ipc.ActionUnlockClient),
+		cli.CmdSuspendClient:    with(executeClientControl, ipc.ActionSuspendClient),
+		cli.CmdServerAccess:     executeServerAccess,
+		cli.CmdRespawnPane:      executeRespawnPane,
+		cli.CmdSelectPane:       executeSelectPane,
+		cli.CmdShowInputHistory: executeShowInputHistory,
+		cli.CmdReplayInput:      executeReplayInput,
+		cli.CmdRecordKeys:       executeRecordKeys,
+		cli.CmdPlayKeys:         executePlayKeys,
+		cli.CmdRunScript:        executeRunScript,
+		cli.CmdWatchAdd:         with(executeWatch, ipc.ActionWatchAdd),
+		cli.CmdWatchList:        list(ipc.ActionWatchList),
+		cli.CmdWatchRemove:      with(executeWatch, ipc.ActionWatchRemove),
+		cli.CmdUp:               executeUp,
+		cli.CmdDown:             executeDown,
+		cli.CmdStatus:           executeStatus,
+		cli.CmdSchedule:         executeSchedule,
+		cli.CmdScheduleList:     list(ipc.ActionScheduleList),
+		cli.CmdScheduleRemove:   executeSchedule,
+		cli.CmdRedactAdd:        executeRedact,
+		cli.CmdRedactList:       list(ipc.ActionRedactList),
+		cli.CmdRedactRemove:     executeRedact,
+		cli.CmdCheckpoint:       with(executeCheckpoint, ipc.ActionCheckpoint),
+		cli.CmdDiffCheckpoint:   with(executeCheckpoint, ipc.ActionDiffCheckpoint),
+		cli.CmdBroker:           executeBroker,
+		cli.CmdListSessions:     executeListSessions,
+		cli.CmdMetrics:          executeMetrics,
+		cli.CmdBench:            executeBench,
+		cli.CmdStress:           executeStress,
+		cli.CmdSelftest:         executeSelftest,
+		cli.CmdDoctor:           executeDoctor,
+		cli.CmdAttach:           executeAttach,
+		cli.CmdBridge:           executeBridge,
+		cli.CmdExec:             executeExec,
+	} {
+		if spec, ok := cli.LookupType(t); ok {
+			spec.Run = run
+		}
+	}
 }
 
 func executeNewSession(cmd *cli.Command) int {
-	if err := spawnDaemon(cmd.SocketPath, cmd.SessionName, cmd.StartDir, cmd.ShellCmd); err != nil {
+	var setup *sessionSetup
+	if cmd.Template != "" {
+		var err error
+		if setup, err = loadTemplate(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+	}
+
+	restore := func() {}
+	if setup != nil {
+		restore = setup.setEnv()
+	}
+	pid, err := spawnDaemon(cmd.SocketPath, cmd.SessionName, cmd.StartDir, cmd.ShellCmd, daemonArgs(cmd))
+	restore()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "wintmux: failed to create session: %v\n", err)
 		return 1
 	}
 
-	// Poll until the daemon is reachable (up to 5 seconds).
-	for i := 0; i < 50; i++ {
-		time.Sleep(100 * time.Millisecond)
-		resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{Action: ipc.ActionPing})
-		if err == nil && resp.OK {
-			return 0
+	if err := waitForDaemon(cmd.SocketPath, pid, cmd.StartupTimeout, cmd.StartupInterval); err != nil {
+		var se *startupError
+		if errors.As(err, &se) {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
 		}
+		fmt.Fprintf(os.Stderr, "wintmux: session created but %v\n", err)
+		return 1
 	}
 
-	fmt.Fprintln(os.Stderr, "wintmux: session created but daemon not responding")
-	return 1
-}
+	// A session the template could not set up is killed rather than
+	// left half-configured.
+	if setup != nil {
+		if err := setup.apply(cmd.SocketPath); err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v; killing the session\n", err)
+			ipc.SendRequest(cmd.SocketPath, &ipc.Request{Action: ipc.ActionKillSession})
+			return 1
+		}
+	}
 
-// specialKeys is the set of tmux key names that should be sent through
-// the send_key action (interpreted) rather than send_keys (literal).
-var specialKeys = map[string]bool{
-	"Enter": true, "Escape": true, "BSpace": true,
-	"Tab": true, "Space": true,
-	"C-c": true, "C-d": true, "C-z": true,
-	"Up": true, "Down": true, "Left": true, "Right": true,
-	"Home": true, "End": true, "DC": true,
-	"PageUp": true, "PageDown": true,
+	// Without -d, attach as tmux does, unless there is no terminal to
+	// attach to (scripts and orchestrators omit -d too).
+	if !cmd.Detached && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		return executeAttach(cmd)
+	}
+	return 0
 }
 
 func executeSendKeys(cmd *cli.Command) int {
@@ -112,6 +272,7 @@ func executeSendKeys(cmd *cli.Command) int {
 		text := strings.Join(cmd.Keys, " ")
 		resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
 			Action:  ipc.ActionSendKeys,
+			Target:  cmd.Target,
 			Text:    text,
 			Literal: true,
 		})
@@ -128,10 +289,12 @@ func executeSendKeys(cmd *cli.Command) int {
 
 	for _, key := range cmd.Keys {
 		var req ipc.Request
-		if specialKeys[key] {
-			req = ipc.Request{Action: ipc.ActionSendKey, Key: key}
+		// Key names go through the send_key action (encoded by the
+		// daemon), anything else through send_keys (literal).
+		if vt.IsKeyName(key) {
+			req = ipc.Request{Action: ipc.ActionSendKey, Target: cmd.Target, Key: key}
 		} else {
-			req = ipc.Request{Action: ipc.ActionSendKeys, Text: key}
+			req = ipc.Request{Action: ipc.ActionSendKeys, Target: cmd.Target, Text: key}
 		}
 		resp, err := ipc.SendRequest(cmd.SocketPath, &req)
 		if err != nil {
@@ -146,6 +309,51 @@ func executeSendKeys(cmd *cli.Command) int {
 	return 0
 }
 
+// executeRunPS types a PowerShell command into the pane; "-" reads a
+// script from stdin.
+func executeRunPS(cmd *cli.Command) int {
+	command := strings.Join(cmd.Keys, " ")
+	if command == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+		command = strings.TrimRight(string(data), "\r\n")
+	}
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action:   ipc.ActionRunPS,
+		Target:   cmd.Target,
+		ShellCmd: command,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+func executeSendText(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: ipc.ActionSendText,
+		Target: cmd.Target,
+		Text:   strings.Join(cmd.Keys, " "),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
 func executeCapturePane(cmd *cli.Command) int {
 	lines := 50
 	if cmd.StartLine < 0 {
@@ -154,9 +362,16 @@ func executeCapturePane(cmd *cli.Command) int {
 
 	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
 		Action:    ipc.ActionCapture,
+		Target:    cmd.Target,
 		Lines:     lines,
 		Alternate: cmd.Alternate,
 		Join:      cmd.JoinLines,
+		Frame:     cmd.Frame,
+		Strip:     cmd.Strip,
+		Stream:    cmd.Stream,
+		LastCmd:   cmd.LastCmd,
+		CmdSeq:    cmd.CmdSeq,
+		Escapes:   cmd.Escapes,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
@@ -168,10 +383,7 @@ func executeCapturePane(cmd *cli.Command) int {
 	}
 
 	if cmd.Print {
-		fmt.Print(resp.Output)
-		if !strings.HasSuffix(resp.Output, "\n") {
-			fmt.Println()
-		}
+		printCapture(resp.Output, cmd)
 	}
 	return 0
 }
@@ -179,6 +391,7 @@ func executeCapturePane(cmd *cli.Command) int {
 func executeHasSession(cmd *cli.Command) int {
 	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
 		Action: ipc.ActionHasSession,
+		Target: cmd.Target,
 	})
 	if err != nil {
 		return 1
@@ -189,15 +402,30 @@ func executeHasSession(cmd *cli.Command) int {
 	return 1
 }
 
+// executeKillSession kills the session and, unless --no-wait, waits for
+// its daemon to flush its pipes, remove the control file and exit, so
+// the path can be reused at once. A session that is already gone counts
+// as killed.
 func executeKillSession(cmd *cli.Command) int {
+	info, infoErr := ipc.ReadControlFile(cmd.SocketPath)
 	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
 		Action: ipc.ActionKillSession,
+		Target: cmd.Target,
 	})
-	if err != nil {
+	// The daemon may exit before its reply is sent.
+	if err == nil && !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if cmd.NoWait || infoErr != nil {
 		return 0
 	}
-	if !resp.OK {
-		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = defaultKillTimeout
+	}
+	if err := waitForTeardown(cmd.SocketPath, info.PID, timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
 		return 1
 	}
 	return 0
@@ -206,8 +434,398 @@ func executeKillSession(cmd *cli.Command) int {
 func executeSetOption(cmd *cli.Command) int {
 	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
 		Action: ipc.ActionSetOption,
+		Target: cmd.Target,
 		Option: cmd.Option,
 		Value:  cmd.Value,
+		Window: cmd.WindowScope,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+// executePipe sends a pipe-pane, pipe-add or pipe-remove request and
+// prints the name of an added sink.
+func executePipe(cmd *cli.Command, action ipc.Action) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action:     action,
+		Target:     cmd.Target,
+		Name:       cmd.PipeName,
+		ShellCmd:   cmd.PipeCmd,
+		Clean:      cmd.PipeClean,
+		Timestamps: cmd.PipeTimestamps,
+		RotateSize: cmd.PipeRotateSize,
+		Keep:       cmd.PipeKeep,
+		Events:     cmd.PipeEvents,
+		MirrorTo:   cmd.MirrorTo,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+// executeMirrorPane adds a pipe sink that shows the session's output in
+// another session's pane. The target is made absolute here, since the
+// daemon's working directory is not the caller's.
+func executeMirrorPane(cmd *cli.Command) int {
+	abs, err := filepath.Abs(cmd.MirrorTo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	cmd.MirrorTo = abs
+	return executePipe(cmd, ipc.ActionPipeAdd)
+}
+
+// executeDisplayMessage expands a format in the daemon and prints it.
+// wintmux has no status line, so the result is always printed as if -p
+// had been given.
+func executeDisplayMessage(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: ipc.ActionDisplay,
+		Target: cmd.Target,
+		Format: cmd.Format,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	fmt.Println(resp.Output)
+	return 0
+}
+
+// executeWaitStable blocks until the pane's output has been quiet for the
+// requested window. Exit code 0 means stable, 1 means timeout or error.
+func executeWaitStable(cmd *cli.Command) int {
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	resp, err := ipc.SendRequestProgress(cmd.SocketPath, &ipc.Request{
+		Action:    ipc.ActionWaitStable,
+		Target:    cmd.Target,
+		QuietMs:   cmd.QuietMs,
+		TimeoutMs: int(timeout / time.Millisecond),
+	}, timeout+10*time.Second, progressPrinter(cmd))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+// executeShowEnvironment prints the environment of the pane process.
+func executeShowEnvironment(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: ipc.ActionShowEnv,
+		Target: cmd.Target,
+		Name:   cmd.EnvName,
+		PID:    cmd.EnvPID,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+// executeWaitPrompt waits for the pane's shell to show its prompt.
+func executeWaitPrompt(cmd *cli.Command) int {
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	resp, err := ipc.SendRequestProgress(cmd.SocketPath, &ipc.Request{
+		Action:    ipc.ActionWaitPrompt,
+		Target:    cmd.Target,
+		Shell:     cmd.Shell,
+		TimeoutMs: int(timeout / time.Millisecond),
+	}, timeout+10*time.Second, progressPrinter(cmd))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+// progressPrinter returns a callback that prints daemon status lines to
+// stderr if --progress was given, or nil.
+func progressPrinter(cmd *cli.Command) func(string) {
+	if !cmd.Progress {
+		return nil
+	}
+	return func(status string) {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", status)
+	}
+}
+
+// executeList runs one of the list-* queries and prints its lines.
+func executeList(cmd *cli.Command, action ipc.Action) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: action,
+		Target: cmd.Target,
+		Format: cmd.Format,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+// executeClientControl sends a lock/unlock/suspend request. For these
+// commands -t names a client (see list-clients), not a pane.
+func executeClientControl(cmd *cli.Command, action ipc.Action) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action:       action,
+		TargetClient: cmd.Target,
+		All:          cmd.AllClients,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+func executeServerAccess(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action:       ipc.ActionServerAccess,
+		Option:       cmd.AccessMode,
+		TargetClient: cmd.Target,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+// executeRespawnPane restarts the pane process. The caller's environment
+// is sent along so the daemon can refresh update-environment variables.
+func executeRespawnPane(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action:    ipc.ActionRespawn,
+		Target:    cmd.Target,
+		Kill:      cmd.Kill,
+		StartDir:  cmd.StartDir,
+		ShellCmd:  cmd.ShellCmd,
+		Env:       cmd.Env,
+		ClientEnv: os.Environ(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+// executeSelectPane selects the session's pane, marking it or clearing
+// the mark with -m or -M.
+func executeSelectPane(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: ipc.ActionSelectPane,
+		Target: cmd.Target,
+		Mark:   cmd.Mark,
+		Unmark: cmd.Unmark,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+// executeExec runs a command to completion in the session, prints its
+// output and exits with its exit status.
+func executeExec(cmd *cli.Command) int {
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	resp, err := ipc.SendRequestProgress(cmd.SocketPath, &ipc.Request{
+		Action:    ipc.ActionExec,
+		Target:    cmd.Target,
+		ShellCmd:  cmd.ShellCmd,
+		StartDir:  cmd.StartDir,
+		Env:       cmd.Env,
+		ClientEnv: os.Environ(),
+		InPane:    cmd.InPane,
+		Shell:     cmd.Shell,
+		TimeoutMs: int(timeout / time.Millisecond),
+	}, timeout+10*time.Second, progressPrinter(cmd))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	fmt.Print(resp.Output)
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return resp.ExitCode
+}
+
+func executeShowInputHistory(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: ipc.ActionInputHistory,
+		Target: cmd.Target,
+		Format: cmd.Format,
+		Start:  cmd.InputStart,
+		Count:  cmd.InputCount,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+// executeReplayInput writes recorded input back to the pane. With
+// --timing the daemon sleeps between events, so the request deadline is
+// extended to the replay timeout.
+func executeReplayInput(cmd *cli.Command) int {
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	resp, err := ipc.SendRequestProgress(cmd.SocketPath, &ipc.Request{
+		Action:    ipc.ActionReplayInput,
+		Target:    cmd.Target,
+		Start:     cmd.InputStart,
+		Count:     cmd.InputCount,
+		Timing:    cmd.Timing,
+		TimeoutMs: int(timeout / time.Millisecond),
+	}, timeout+10*time.Second, progressPrinter(cmd))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+func executeRecordKeys(cmd *cli.Command) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: ipc.ActionRecordKeys,
+		Target: cmd.Target,
+		Name:   cmd.MacroName,
+		Option: cmd.MacroMode,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+func executePlayKeys(cmd *cli.Command) int {
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	resp, err := ipc.SendRequestProgress(cmd.SocketPath, &ipc.Request{
+		Action:    ipc.ActionPlayKeys,
+		Target:    cmd.Target,
+		Name:      cmd.MacroName,
+		Count:     cmd.PlayCount,
+		Timing:    cmd.Timing,
+		TimeoutMs: int(timeout / time.Millisecond),
+	}, timeout+10*time.Second, progressPrinter(cmd))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	return 0
+}
+
+func executeCheckpoint(cmd *cli.Command, action ipc.Action) int {
+	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
+		Action: action,
+		Target: cmd.Target,
+		Name:   cmd.CheckpointName,
+		Option: cmd.CheckpointMode,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
@@ -217,13 +835,20 @@ func executeSetOption(cmd *cli.Command) int {
 		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
 		return 1
 	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
 	return 0
 }
 
-func executePipePane(cmd *cli.Command) int {
+func executeWatch(cmd *cli.Command, action ipc.Action) int {
 	resp, err := ipc.SendRequest(cmd.SocketPath, &ipc.Request{
-		Action:   ipc.ActionPipePane,
-		ShellCmd: cmd.PipeCmd,
+		Action:  action,
+		Target:  cmd.Target,
+		Name:    cmd.WatchName,
+		Pattern: cmd.Pattern,
+		Hook:    cmd.Hook,
+		Once:    cmd.Once,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
@@ -233,27 +858,143 @@ func executePipePane(cmd *cli.Command) int {
 		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
 		return 1
 	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
 	return 0
 }
 
-func printUsage() {
-	fmt.Fprintf(os.Stderr, `wintmux %s — Windows-native tmux-compatible session manager
+func executeRedact(cmd *cli.Command) int {
+	req := &ipc.Request{Action: ipc.ActionRedactRemove, Target: cmd.Target, Name: cmd.RedactName}
+	if cmd.Type == cli.CmdRedactAdd {
+		req = &ipc.Request{
+			Action:  ipc.ActionRedactAdd,
+			Target:  cmd.Target,
+			Name:    cmd.RedactName,
+			Pattern: cmd.Pattern,
+			Replace: cmd.Replace,
+		}
+	}
+	resp, err := ipc.SendRequest(cmd.SocketPath, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+func executeSchedule(cmd *cli.Command) int {
+	req := &ipc.Request{Action: ipc.ActionScheduleRemove, Target: cmd.Target, Name: cmd.ScheduleName}
+	if cmd.Type == cli.CmdSchedule {
+		req = &ipc.Request{
+			Action:     ipc.ActionScheduleAdd,
+			Target:     cmd.Target,
+			Name:       cmd.ScheduleName,
+			Args:       cmd.ScheduleArgs,
+			DelayMs:    int64(cmd.ScheduleIn / time.Millisecond),
+			IntervalMs: int64(cmd.ScheduleEvery / time.Millisecond),
+			Cron:       cmd.ScheduleCron,
+		}
+	}
+	resp, err := ipc.SendRequest(cmd.SocketPath, req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	if resp.Output != "" {
+		fmt.Println(resp.Output)
+	}
+	return 0
+}
+
+// executeWaitEvent blocks until the daemon reports an event (such as a
+// watch match) and prints it. Exit code 1 means timeout or error.
+func executeWaitEvent(cmd *cli.Command) int {
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	resp, err := ipc.SendRequestProgress(cmd.SocketPath, &ipc.Request{
+		Action:    ipc.ActionWaitEvent,
+		Target:    cmd.Target,
+		EventType: cmd.EventType,
+		Since:     cmd.Since,
+		Format:    cmd.Format,
+		TimeoutMs: int(timeout / time.Millisecond),
+	}, timeout+10*time.Second, progressPrinter(cmd))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "wintmux: %s\n", resp.Error)
+		return 1
+	}
+	fmt.Println(resp.Output)
+	return 0
+}
+
+// executeHelp prints the usage, or with a command its synopsis, aliases
+// and summary.
+func executeHelp(cmd *cli.Command) int {
+	if cmd.HelpCommand == "" {
+		printUsage(os.Stdout)
+		return 0
+	}
+	spec, _ := cli.LookupCommand(cmd.HelpCommand)
+	fmt.Printf("usage: wintmux %s\n", spec.Synopsis())
+	if len(spec.Aliases) > 0 {
+		fmt.Printf("aliases: %s\n", strings.Join(spec.Aliases, ", "))
+	}
+	fmt.Printf("\n%s\n", spec.Summary)
+	return 0
+}
+
+// executeCompletion prints the completion script for a shell.
+func executeCompletion(cmd *cli.Command) int {
+	script, err := cli.Completion(cmd.CompletionShell)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	fmt.Print(script)
+	return 0
+}
+
+// printUsage writes the usage, listing the commands from cli.Commands.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, `wintmux %s — Windows-native tmux-compatible session manager
 
 Usage:
-  wintmux [-S socket-path] command [flags]
+  wintmux [-S socket-path] [--color auto|always|never] command [flags]
 
 Commands:
-  new-session    Create a new session
-  send-keys      Send keys to a session
-  capture-pane   Capture pane output
-  has-session    Check if a session exists
-  kill-session   Kill a session
-  set-option     Set a session option
-  pipe-pane      Pipe pane output to a file
-  attach         Attach to a session (not yet implemented)
-
+`, version)
+	for _, spec := range cli.Commands {
+		lines := strings.Split(spec.Summary, "\n")
+		if len(spec.Name) <= 14 {
+			fmt.Fprintf(w, "  %-14s %s\n", spec.Name, lines[0])
+		} else {
+			fmt.Fprintf(w, "  %s  %s\n", spec.Name, lines[0])
+		}
+		for _, line := range lines[1:] {
+			fmt.Fprintf(w, "%17s%s\n", "", line)
+		}
+	}
+	fmt.Fprint(w, `
 Flags:
   -S path        Socket path (session identification)
   -V             Show version
-`, version)
+`)
 }
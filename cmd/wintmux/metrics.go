@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/registry"
+)
+
+// usageNames are the format variables a daemon reports its pane's
+// resource use in (see display-message).
+var usageNames = []string{"pane_cpu", "pane_cpu_time", "pane_memory", "pane_handles", "pane_processes", "pane_dead", "pane_stuck", "pane_quiet_ms"}
+
+// sessionUsage asks the daemon on socket for its usageNames in one
+// request. Resource variables are empty until the daemon has sampled.
+func sessionUsage(socket string) (map[string]string, error) {
+	refs := make([]string, len(usageNames))
+	for i, name := range usageNames {
+		refs[i] = "#{" + name + "}"
+	}
+	resp, err := ipc.SendRequestTimeout(socket, &ipc.Request{Action: ipc.ActionDisplay, Format: strings.Join(refs, "\t")}, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, errors.New(resp.Error)
+	}
+	values := strings.Split(resp.Output, "\t")
+	if len(values) != len(usageNames) {
+		return nil, fmt.Errorf("unexpected reply %q", resp.Output)
+	}
+	vars := make(map[string]string, len(usageNames))
+	for i, name := range usageNames {
+		vars[name] = values[i]
+	}
+	return vars, nil
+}
+
+// metric is one Prometheus gauge, taken from a format variable and
+// divided into the metric's unit.
+type metric struct {
+	name string
+	help string
+	from string
+	div  float64
+}
+
+var metrics = []metric{
+	{"wintmux_pane_cpu_ratio", "CPU used by the pane's processes over the last sample interval, in CPUs.", "pane_cpu", 100},
+	{"wintmux_pane_cpu_seconds", "CPU time consumed by the pane's live processes.", "pane_cpu_time", 1},
+	{"wintmux_pane_memory_bytes", "Resident memory (working set) of the pane's processes.", "pane_memory", 1},
+	{"wintmux_pane_handles", "Open handles (file descriptors off Windows) of the pane's processes.", "pane_handles", 1},
+	{"wintmux_pane_processes", "Processes in the pane's process tree.", "pane_processes", 1},
+	{"wintmux_pane_dead", "Whether the pane's process has exited.", "pane_dead", 1},
+	{"wintmux_pane_stuck", "Whether the pane looks hung (see stuck-after).", "pane_stuck", 1},
+	{"wintmux_pane_quiet_seconds", "Time since the pane last produced output.", "pane_quiet_ms", 1000},
+}
+
+// writeMetrics writes the Prometheus text format for entries, asking
+// every session at once. A session that does not answer has
+// wintmux_session_up 0 and no other samples.
+func writeMetrics(w *bytes.Buffer, entries []registry.Entry) {
+	usage := make([]map[string]string, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, socket string) {
+			defer wg.Done()
+			usage[i], _ = sessionUsage(socket)
+		}(i, e.Socket)
+	}
+	wg.Wait()
+
+	labels := make([]string, len(entries))
+	for i, e := range entries {
+		labels[i] = fmt.Sprintf(`{session="%s",socket="%s"}`, labelValue(e.Session), labelValue(e.Socket))
+	}
+	fmt.Fprintf(w, "# HELP wintmux_session_up Whether the session's daemon answered.\n# TYPE wintmux_session_up gauge\n")
+	for i := range entries {
+		up := 0
+		if usage[i] != nil {
+			up = 1
+		}
+		fmt.Fprintf(w, "wintmux_session_up%s %d\n", labels[i], up)
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for i := range entries {
+			v, err := strconv.ParseFloat(usage[i][m.from], 64)
+			if err != nil {
+				continue // not answered, or not sampled yet
+			}
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels[i], strconv.FormatFloat(v/m.div, 'f', -1, 64))
+		}
+	}
+}
+
+// labelValue escapes s for a Prometheus label value.
+func labelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// executeMetrics prints the metrics of the registeredSessions, as for a
+// node_exporter textfile, or with --listen serves them over HTTP at
+// /metrics until killed.
+func executeMetrics(cmd *cli.Command) int {
+	if cmd.MetricsListen == "" {
+		entries, _, err := registeredSessions(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+		var buf bytes.Buffer
+		writeMetrics(&buf, entries)
+		os.Stdout.Write(buf.Bytes())
+		return 0
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		entries, _, err := registeredSessions(cmd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		writeMetrics(&buf, entries)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+	fmt.Fprintf(os.Stderr, "wintmux: serving metrics on http://%s/metrics\n", cmd.MetricsListen)
+	srv := &http.Server{Addr: cmd.MetricsListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := srv.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/screen"
+	"wintmux/internal/vt"
+)
+
+// printCapture prints captured text in cmd's --encoding: as UTF-8
+// through printPaged, or encoded, never paged, for consumers that mangle
+// UTF-8 on stdout (PowerShell 5.1 pipelines, legacy tools).
+func printCapture(text string, cmd *cli.Command) {
+	if cmd.Encoding == "" || cmd.Encoding == "utf8" {
+		printPaged(text, cmd.NoPager)
+		return
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	os.Stdout.Write(encodeText(text, cmd.Encoding))
+}
+
+// encodeText encodes text as "base64" (of its UTF-8, on one line) or
+// "utf16" (UTF-16LE after a byte order mark).
+func encodeText(text, encoding string) []byte {
+	switch encoding {
+	case "base64":
+		return []byte(base64.StdEncoding.EncodeToString([]byte(text)) + "\n")
+	case "utf16":
+		b := []byte{0xff, 0xfe}
+		for _, u := range utf16.Encode([]rune(text)) {
+			b = binary.LittleEndian.AppendUint16(b, u)
+		}
+		return b
+	}
+	return []byte(text)
+}
+
+// printPaged prints captured text, through a built-in pager when it takes
+// more rows than the terminal has and both stdin and stdout are
+// terminals: Windows has no less to pipe a long capture into. With
+// noPager, or when either end is a file or pipe, the text is printed as
+// is.
+func printPaged(text string, noPager bool) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	lines := strings.SplitAfter(text, "\n")
+	lines = lines[:len(lines)-1]
+	cols, rows := terminalSize()
+	if noPager || cols < 1 || rows < 2 || !isTerminal(os.Stdin) || !isTerminal(os.Stdout) || textRows(lines, cols) < rows {
+		fmt.Print(text)
+		return
+	}
+	restore, err := makeRaw()
+	if err != nil {
+		fmt.Print(text)
+		return
+	}
+	defer restore()
+	page(os.Stdout, terminalInput(), lines, cols, rows)
+}
+
+// page shows lines a screen at a time, like more: space (or any other
+// key) shows the next screen, Enter, j or Down the next line, and q, Esc
+// or Ctrl-C stop. The terminal is in raw mode, so lines end in CR LF.
+func page(w io.Writer, in io.Reader, lines []string, cols, rows int) {
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	key := make([]byte, 16)
+	next, room := 0, rows-1
+	for {
+		for shown := 0; next < len(lines); shown++ {
+			n := lineRows(lines[next], cols)
+			if n > room && shown > 0 {
+				break
+			}
+			out.WriteString(strings.TrimSuffix(lines[next], "\n") + "\r\n")
+			room -= n
+			next++
+		}
+		if next == len(lines) {
+			return
+		}
+		fmt.Fprintf(out, "\x1b[7m--More--(%d%%)\x1b[m", 100*next/len(lines))
+		out.Flush()
+		n, err := in.Read(key)
+		out.WriteString("\r\x1b[K")
+		if err != nil {
+			return
+		}
+		switch string(key[:n]) {
+		case "q", "Q", "\x1b", "\x03":
+			return
+		case "\r", "\n", "j", "\x1b[B", "\x1bOB":
+			room = 1
+		default:
+			room = rows - 1
+		}
+	}
+}
+
+// textRows returns the terminal rows lines take when they wrap at cols.
+func textRows(lines []string, cols int) int {
+	n := 0
+	for _, line := range lines {
+		n += lineRows(line, cols)
+	}
+	return n
+}
+
+// lineRows returns the terminal rows a line takes when it wraps at cols;
+// escape sequences (-e hyperlinks) take none.
+func lineRows(line string, cols int) int {
+	w := screen.StringWidth(vt.Strip(strings.TrimSuffix(line, "\n")))
+	return max(1, (w+cols-1)/cols)
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+func samePath(a, b string) bool {
+	return a == b
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "strings"
+
+// samePath compares paths case-insensitively, as the file system does.
+func samePath(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/script"
+)
+
+// scriptCaptureLines is how much of the pane run-script captures for
+// expect and capture steps.
+const scriptCaptureLines = 2000
+
+// ipcPane drives a session over IPC for run-script; target is the -t
+// every request carries.
+type ipcPane struct {
+	socket string
+	target string
+}
+
+func (p ipcPane) do(req *ipc.Request, timeout time.Duration) (*ipc.Response, error) {
+	req.Target = p.target
+	resp, err := ipc.SendRequestTimeout(p.socket, req, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return resp, nil
+}
+
+func (p ipcPane) SendText(text string) error {
+	_, err := p.do(&ipc.Request{Action: ipc.ActionSendKeys, Text: text, Literal: true}, 10*time.Second)
+	return err
+}
+
+func (p ipcPane) SendKey(key string) error {
+	_, err := p.do(&ipc.Request{Action: ipc.ActionSendKey, Key: key}, 10*time.Second)
+	return err
+}
+
+func (p ipcPane) Capture() (string, error) {
+	resp, err := p.do(&ipc.Request{Action: ipc.ActionCapture, Lines: scriptCaptureLines, Join: true}, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	return resp.Output, nil
+}
+
+func (p ipcPane) WaitStable(quiet, timeout time.Duration) error {
+	_, err := p.do(&ipc.Request{
+		Action:    ipc.ActionWaitStable,
+		QuietMs:   int(quiet / time.Millisecond),
+		TimeoutMs: int(timeout / time.Millisecond),
+	}, timeout+10*time.Second)
+	return err
+}
+
+// executeRunScript runs a transcript file against the session. Exit code
+// 0 means every step succeeded; failures name the script line.
+func executeRunScript(cmd *cli.Command) int {
+	var src io.Reader = os.Stdin
+	name := "<stdin>"
+	if cmd.ScriptPath != "-" {
+		f, err := os.Open(cmd.ScriptPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		src, name = f, cmd.ScriptPath
+	}
+
+	steps, err := script.Parse(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %s: %v\n", name, err)
+		return 1
+	}
+	r := &script.Runner{Pane: ipcPane{socket: cmd.SocketPath, target: cmd.Target}}
+	if cmd.Verbose {
+		r.Trace = os.Stderr
+	}
+	if err := r.Run(steps); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
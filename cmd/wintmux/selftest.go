@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/proc"
+)
+
+// fixtureTitle is the first line the selftest fixture draws.
+const fixtureTitle = "wintmux selftest fixture"
+
+// selftest drives a throwaway session running the fixture program and
+// reports each check as it goes.
+type selftest struct {
+	socket  string
+	timeout time.Duration
+	verbose bool
+	pid     int
+}
+
+// executeSelftest runs a session end to end on this machine: start a
+// daemon running the fixture (this binary with selftest --fixture), drive
+// it with send-keys, check what capture-pane and display-message see, and
+// wait for the session to shut down once the fixture exits. It is meant
+// to tell a broken environment apart from a broken orchestration script.
+func executeSelftest(cmd *cli.Command) int {
+	if cmd.Fixture {
+		return runFixture()
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	dir, err := os.MkdirTemp("", "wintmux-selftest")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	s := &selftest{
+		socket:  filepath.Join(dir, "selftest.sock"),
+		timeout: cmd.Timeout,
+		verbose: cmd.Verbose,
+	}
+	if s.timeout <= 0 {
+		s.timeout = 10 * time.Second
+	}
+
+	fmt.Printf("wintmux %s selftest (%s/%s)\n", version, runtime.GOOS, runtime.GOARCH)
+	ok := s.check("start session", func() error {
+		command := `"` + exe + `" selftest --fixture`
+		pid, err := spawnDaemon(s.socket, "selftest", dir, command, nil)
+		if err != nil {
+			return err
+		}
+		s.pid = pid
+		return waitForDaemon(s.socket, pid, s.timeout, 0)
+	}) && s.check("fixture draws its screen", func() error {
+		return s.waitScreen(fixtureTitle)
+	}) && s.check("alternate screen", func() error {
+		resp, err := s.request(ipc.Request{Action: ipc.ActionDisplay, Format: "#{alternate_on}"})
+		if err != nil {
+			return err
+		}
+		if resp.Output != "1" {
+			return fmt.Errorf("alternate_on is %q, want 1", resp.Output)
+		}
+		return nil
+	}) && s.check("send-keys text and Enter", func() error {
+		if _, err := s.request(ipc.Request{Action: ipc.ActionSendKeys, Text: "hello selftest"}); err != nil {
+			return err
+		}
+		if _, err := s.request(ipc.Request{Action: ipc.ActionSendKey, Key: "Enter"}); err != nil {
+			return err
+		}
+		return s.waitScreen("got: hello selftest")
+	}) && s.check("send-keys special key", func() error {
+		if _, err := s.request(ipc.Request{Action: ipc.ActionSendKey, Key: "Up"}); err != nil {
+			return err
+		}
+		return s.waitScreen("key: Up")
+	}) && s.check("pane exits", func() error {
+		if _, err := s.request(ipc.Request{Action: ipc.ActionSendKeys, Text: "quit", SendEnter: true}); err != nil {
+			return err
+		}
+		return s.poll("has-session to report the session gone", func() bool {
+			resp, err := s.request(ipc.Request{Action: ipc.ActionHasSession})
+			return err != nil || !resp.Exists
+		})
+	}) && s.check("daemon shuts down", func() error {
+		// The daemon lingers for a grace period after the pane exits.
+		return s.pollFor(s.timeout+10*time.Second, "the daemon to exit and remove its control file", func() bool {
+			_, err := os.Stat(s.socket)
+			return !proc.Alive(s.pid) && errors.Is(err, os.ErrNotExist)
+		})
+	})
+
+	if !ok {
+		s.teardown()
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		fmt.Printf("note: could not remove %s: %v\n", dir, err)
+	}
+	if !ok {
+		fmt.Println("selftest " + paint("FAILED", "31"))
+		return 1
+	}
+	fmt.Println("selftest " + paint("passed", "32"))
+	return 0
+}
+
+// check runs one step and prints its outcome. On failure the pane is
+// shown so the user can see what the session was doing.
+func (s *selftest) check(name string, fn func() error) bool {
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Printf("%s %-26s %v (%v)\n", paint("FAIL", "31"), name, err, elapsed)
+		s.showPane()
+		return false
+	}
+	fmt.Printf("%s   %-26s %v\n", paint("ok", "32"), name, elapsed)
+	if s.verbose {
+		s.showPane()
+	}
+	return true
+}
+
+func (s *selftest) showPane() {
+	resp, err := s.request(ipc.Request{Action: ipc.ActionCapture})
+	if err != nil {
+		return
+	}
+	fmt.Println("     pane:")
+	for _, line := range strings.Split(strings.TrimRight(resp.Output, "\n"), "\n") {
+		fmt.Printf("     | %s\n", line)
+	}
+}
+
+// request sends req to the session, turning an error reply into an error.
+func (s *selftest) request(req ipc.Request) (*ipc.Response, error) {
+	resp, err := ipc.SendRequest(s.socket, &req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// waitScreen waits for the pane to show text.
+func (s *selftest) waitScreen(text string) error {
+	return s.poll(fmt.Sprintf("the pane to show %q", text), func() bool {
+		resp, err := s.request(ipc.Request{Action: ipc.ActionCapture})
+		return err == nil && strings.Contains(resp.Output, text)
+	})
+}
+
+func (s *selftest) poll(what string, cond func() bool) error {
+	return s.pollFor(s.timeout, what, cond)
+}
+
+func (s *selftest) pollFor(timeout time.Duration, what string, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// teardown stops whatever a failed run left behind.
+func (s *selftest) teardown() {
+	if s.pid == 0 {
+		return
+	}
+	ipc.SendRequestTimeout(s.socket, &ipc.Request{Action: ipc.ActionKillSession}, 2*time.Second)
+	if p, err := os.FindProcess(s.pid); err == nil {
+		p.Kill()
+	}
+	s.pollFor(2*time.Second, "daemon exit", func() bool { return !proc.Alive(s.pid) })
+}
+
+// runFixture is the program the selftest session runs: a small
+// full-screen app on the alternate screen that echoes the line being
+// typed, reports each submitted line and arrow key on a status line, and
+// exits on "quit". It reads stdin byte by byte, so it works under ConPTY
+// (in raw mode) and on the pipe-based fallback alike.
+func runFixture() int {
+	if restore, err := makeRaw(); err == nil {
+		defer restore()
+	}
+	fmt.Print("\x1b[?1049h\x1b[2J")
+	fixtureLine(1, fixtureTitle)
+	fixtureLine(2, `type a line and press Enter; arrow keys are reported; "quit" exits`)
+	fixtureLine(6, "ready")
+	fixtureLine(4, "> ")
+
+	var line []byte
+	esc := 0 // 1 after ESC, 2 inside a CSI or SS3 sequence
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		for _, b := range buf[:n] {
+			switch {
+			case esc == 1 && (b == '[' || b == 'O'):
+				esc = 2
+			case esc == 2:
+				if name, ok := map[byte]string{'A': "Up", 'B': "Down", 'C': "Right", 'D': "Left"}[b]; ok {
+					fixtureLine(6, "key: "+name)
+					fixtureLine(4, "> "+string(line))
+				}
+				if b >= 0x40 && b <= 0x7e {
+					esc = 0
+				}
+			case b == 0x1b:
+				esc = 1
+			case b == '\r' || b == '\n':
+				esc = 0
+				if len(line) == 0 {
+					continue
+				}
+				if string(line) == "quit" {
+					fmt.Print("\x1b[?1049l")
+					return 0
+				}
+				fixtureLine(6, "got: "+string(line))
+				line = line[:0]
+				fixtureLine(4, "> ")
+			case b == 0x03:
+				fmt.Print("\x1b[?1049l")
+				return 130
+			case b == 0x7f || b == 0x08:
+				esc = 0
+				if len(line) > 0 {
+					line = line[:len(line)-1]
+				}
+				fixtureLine(4, "> "+string(line))
+			case b >= 0x20:
+				esc = 0
+				line = append(line, b)
+				fmt.Printf("%c", b)
+			}
+		}
+		if err != nil {
+			return 0
+		}
+	}
+}
+
+// fixtureLine replaces screen row (1-based) with text, leaving the cursor
+// after it.
+func fixtureLine(row int, text string) {
+	fmt.Printf("\x1b[%d;1H\x1b[2K%s", row, text)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"wintmux/internal/broker"
+	"wintmux/internal/cli"
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+	"wintmux/internal/registry"
+)
+
+const defaultSessionFormat = "#{session_name}: #{socket_path} (pid #{daemon_pid}, created #{session_created_string}) [#{session_health}]"
+
+// registeredSessions returns running sessions from the per-user
+// registry: all of them with --all (or when no -S is given), otherwise
+// only the session on the -S socket.
+func registeredSessions(cmd *cli.Command) (entries []registry.Entry, all bool, err error) {
+	list, err := registry.List()
+	if err != nil {
+		return nil, false, err
+	}
+	entries, all = selectSessions(cmd, list)
+	return entries, all, nil
+}
+
+// selectSessions picks from list the entries registeredSessions would.
+func selectSessions(cmd *cli.Command, list []registry.Entry) (entries []registry.Entry, all bool) {
+	all = cmd.AllClients || cmd.SocketPath == ""
+	socket := cmd.SocketPath
+	if abs, err := filepath.Abs(socket); err == nil {
+		socket = abs
+	}
+	for _, e := range list {
+		if all || samePath(e.Socket, socket) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, all
+}
+
+// sessionHealth asks the daemon of a live registry entry how it is, as
+// its ping answer: running, draining or child-exited. A daemon that does
+// not answer is stale.
+func sessionHealth(e registry.Entry) string {
+	resp, err := ipc.SendRequestTimeout(e.Socket, &ipc.Request{Action: ipc.ActionPing}, 2*time.Second)
+	switch {
+	case err != nil || !resp.OK:
+		return "stale"
+	case resp.Output == "":
+		return "running" // a daemon from before health was reported
+	}
+	return resp.Output
+}
+
+// executeListSessions lists the registeredSessions with their health,
+// and once, as stale, those whose daemon died without cleaning up.
+func executeListSessions(cmd *cli.Command) int {
+	live, dead, err := registry.Scan()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	entries, all := selectSessions(cmd, live)
+	stale, _ := selectSessions(cmd, dead)
+	// Only the default format is colored; -F output is for scripts.
+	tmpl, paintHealth := cmd.Format, func(h string) string { return h }
+	if tmpl == "" {
+		tmpl, paintHealth = defaultSessionFormat, paintState
+	}
+
+	health := make([]string, len(entries), len(entries)+len(stale))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health[i] = sessionHealth(e)
+		}()
+	}
+	wg.Wait()
+	for range stale {
+		health = append(health, "stale")
+	}
+	rows := append(entries, stale...)
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return rows[order[i]].Session < rows[order[j]].Session })
+
+	for _, i := range order {
+		e := rows[i]
+		fmt.Println(format.Expand(tmpl, map[string]string{
+			"session_name":           e.Session,
+			"socket_path":            e.Socket,
+			"session_path":           e.Workdir,
+			"pane_start_command":     e.Command,
+			"daemon_pid":             strconv.Itoa(e.PID),
+			"session_created":        strconv.FormatInt(e.Started.Unix(), 10),
+			"session_created_string": e.Started.Format(time.ANSIC),
+			"session_health":         paintHealth(health[i]),
+		}))
+	}
+	if len(entries) == 0 && !all {
+		fmt.Fprintf(os.Stderr, "wintmux: no server running on %s\n", cmd.SocketPath)
+		return 1
+	}
+	return 0
+}
+
+// executeBroker runs a broker in the foreground. Without -S its control
+// file goes next to the session registry.
+func executeBroker(cmd *cli.Command) int {
+	path := cmd.SocketPath
+	if path == "" {
+		dir, err := registry.Dir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+			return 1
+		}
+		path = filepath.Join(filepath.Dir(dir), "broker.sock")
+	}
+	fmt.Fprintf(os.Stderr, "wintmux: broker listening, control file %s\n", path)
+	if err := broker.Run(path); err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	return 0
+}
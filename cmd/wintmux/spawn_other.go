@@ -10,31 +10,23 @@ import (
 
 // spawnDaemon launches the wintmux daemon as a background process on
 // Unix-like systems (used for development/testing on WSL2 and macOS).
-func spawnDaemon(socketPath, sessionName, workdir, command string) error {
+// extra holds further new-session flags for the daemon. It returns the
+// daemon's PID.
+func spawnDaemon(socketPath, sessionName, workdir, command string, extra []string) (int, error) {
 	exe, err := os.Executable()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	args := []string{
-		"--daemon",
-		"-S", socketPath,
-		"new-session", "-d",
-		"-s", sessionName,
-	}
-	if workdir != "" {
-		args = append(args, "-c", workdir)
-	}
-	if command != "" {
-		args = append(args, command)
-	}
-
-	cmd := exec.Command(exe, args...)
+	cmd := exec.Command(exe, daemonArgv(socketPath, sessionName, workdir, command, extra)...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 	cmd.Stdout = nil
 	cmd.Stderr = nil
 
-	return cmd.Start()
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	return cmd.Process.Pid, nil
 }
@@ -13,24 +13,30 @@ import (
 // spawnDaemon launches the wintmux daemon as a background process.
 // Uses CREATE_BREAKAWAY_FROM_JOB so the daemon survives when the
 // parent SSH session ends (OpenSSH uses Job Objects to kill children).
-func spawnDaemon(socketPath, sessionName, workdir, command string) error {
+// extra holds further new-session flags for the daemon. It returns the
+// daemon's PID.
+func spawnDaemon(socketPath, sessionName, workdir, command string, extra []string) (int, error) {
 	exe, err := os.Executable()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	parts := []string{exe, "--daemon", "-S", socketPath, "new-session", "-d", "-s", sessionName}
-	if workdir != "" {
-		parts = append(parts, "-c", workdir)
-	}
-	if command != "" {
-		parts = append(parts, command)
+	// Each argument is quoted as the daemon's runtime will split its
+	// command line again (CommandLineToArgvW rules), so none is broken
+	// at spaces or loses its quotes on the way.
+	parts := []string{syscall.EscapeArg(exe)}
+	for _, arg := range daemonArgv(socketPath, sessionName, workdir, command, extra) {
+		parts = append(parts, syscall.EscapeArg(arg))
 	}
 	cmdLine := strings.Join(parts, " ")
 
+	exePtr, err := syscall.UTF16PtrFromString(exe)
+	if err != nil {
+		return 0, fmt.Errorf("executable path: %w", err)
+	}
 	cmdLinePtr, err := syscall.UTF16PtrFromString(cmdLine)
 	if err != nil {
-		return fmt.Errorf("cmd line: %w", err)
+		return 0, fmt.Errorf("cmd line: %w", err)
 	}
 
 	var si syscall.StartupInfo
@@ -42,7 +48,7 @@ func spawnDaemon(socketPath, sessionName, workdir, command string) error {
 	// CREATE_BREAKAWAY_FROM_JOB (0x01000000): escape SSH's Job Object
 	const flags = 0x08000000 | 0x00000200 | 0x01000000
 	err = syscall.CreateProcess(
-		nil,
+		exePtr,
 		cmdLinePtr,
 		nil, nil,
 		false, // don't inherit handles
@@ -51,9 +57,9 @@ func spawnDaemon(socketPath, sessionName, workdir, command string) error {
 		&si, &pi,
 	)
 	if err != nil {
-		return fmt.Errorf("create process: %w", err)
+		return 0, fmt.Errorf("create process: %w", err)
 	}
 	syscall.CloseHandle(pi.Thread)
 	syscall.CloseHandle(pi.Process)
-	return nil
+	return int(pi.ProcessId), nil
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/proc"
+)
+
+// Startup probe defaults. Polling starts fast and backs off, so a quick
+// start is noticed quickly and a slow one is not hammered.
+const (
+	defaultStartupTimeout  = 5 * time.Second
+	defaultStartupInterval = 50 * time.Millisecond
+	maxStartupInterval     = time.Second
+)
+
+// startupError is the error a daemon recorded in its control file when
+// it could not start the session.
+type startupError struct {
+	pid int
+	msg string
+}
+
+func (e *startupError) Error() string {
+	return fmt.Sprintf("daemon (pid %d) failed to start: %s", e.pid, e.msg)
+}
+
+// waitForDaemon polls until the daemon spawned as pid answers ping on
+// socketPath, backing off under an ipc.RetryPolicy bounded by timeout. If
+// the daemon recorded a startup failure, that error is returned as a
+// *startupError and the control file is removed. Otherwise the error says
+// which stage it got stuck in: never wrote its control file, still
+// initializing the terminal, listening but not answering, or exited.
+func waitForDaemon(socketPath string, pid int, timeout, interval time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultStartupTimeout
+	}
+	if interval <= 0 {
+		interval = defaultStartupInterval
+	}
+	policy := ipc.RetryPolicy{Timeout: timeout, Initial: interval, Max: maxStartupInterval, Jitter: 0.1}
+	stage := "did not write its control file"
+
+	err := policy.Do(func() error {
+		// A control file left behind by an earlier daemon on the same
+		// path names another PID; ignore it.
+		if info, err := ipc.ReadControlFile(socketPath); err == nil && (pid == 0 || info.PID == pid) {
+			switch info.State {
+			case "failed":
+				os.Remove(socketPath)
+				return ipc.Permanent(&startupError{pid: info.PID, msg: info.Error})
+			case "starting":
+				stage = "is still initializing the terminal"
+			default:
+				if ipc.Ping(socketPath, 2*time.Second) == nil {
+					return nil
+				}
+				stage = "is not answering ping"
+			}
+		}
+		if pid > 0 && !proc.Alive(pid) {
+			// The failure may have been recorded just before exiting.
+			if info, err := ipc.ReadControlFile(socketPath); err == nil && info.PID == pid && info.State == "failed" {
+				os.Remove(socketPath)
+				return ipc.Permanent(&startupError{pid: pid, msg: info.Error})
+			}
+			return ipc.Permanent(fmt.Errorf("daemon (pid %d) exited during startup", pid))
+		}
+		return errors.New(stage)
+	})
+	var re *ipc.RetryError
+	if errors.As(err, &re) {
+		return fmt.Errorf("daemon (pid %d) %s after %v; raise --startup-timeout if the machine is slow", pid, stage, timeout)
+	}
+	return err
+}
+
+// defaultKillTimeout bounds how long kill-session waits for a session to
+// be torn down.
+const defaultKillTimeout = 10 * time.Second
+
+// waitForTeardown polls until daemon pid has removed its control file at
+// socketPath and exited. A control file the daemon left behind when it
+// died is stale and is removed; one naming another PID belongs to a new
+// session on the same path and is left alone.
+func waitForTeardown(socketPath string, pid int, timeout time.Duration) error {
+	policy := ipc.RetryPolicy{Timeout: timeout, Initial: 20 * time.Millisecond, Max: 200 * time.Millisecond}
+	err := policy.Do(func() error {
+		info, err := ipc.ReadControlFile(socketPath)
+		owned := err == nil && info.PID == pid
+		if proc.Alive(pid) {
+			return errors.New("still running")
+		}
+		if owned {
+			os.Remove(socketPath)
+		}
+		return nil
+	})
+	var re *ipc.RetryError
+	if errors.As(err, &re) {
+		return fmt.Errorf("session (daemon pid %d) still shutting down after %v", pid, timeout)
+	}
+	return err
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/proc"
+)
+
+// stressSession is one session of a stress run and what its daemon has
+// been seen to use.
+type stressSession struct {
+	name    string
+	socket  string
+	pid     int
+	startup time.Duration
+	err     error // why it stopped answering
+
+	first, last     proc.Usage // of the daemon process
+	firstAt, lastAt time.Time
+	maxMemory       uint64
+	maxHandles      int
+}
+
+// executeStress is a soak test: it starts cmd.StressSessions sessions
+// whose panes write cmd.StressRate lines a second, for
+// cmd.StressDuration, and samples every daemon's memory, CPU and
+// handles and the latency of a request to it every cmd.StressInterval.
+// It prints a line per sample and a summary, then kills the sessions.
+// Interrupting it ends the run early with the summary so far. Exits 1 if
+// a session failed to start or stopped answering.
+func executeStress(cmd *cli.Command) int {
+	if cmd.Fixture {
+		return runGenerator(cmd.StressRate)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	dir, err := os.MkdirTemp("", "wintmux-stress")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	fmt.Printf("wintmux %s stress: %d sessions, %d lines/s each, for %v\n", version, cmd.StressSessions, cmd.StressRate, cmd.StressDuration)
+	command := `"` + exe + `" stress --generator --rate ` + strconv.Itoa(cmd.StressRate)
+	sessions := make([]*stressSession, cmd.StressSessions)
+	var wg sync.WaitGroup
+	for i := range sessions {
+		s := &stressSession{name: fmt.Sprintf("stress-%d", i+1)}
+		s.socket = filepath.Join(dir, s.name+".sock")
+		sessions[i] = s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			if s.pid, s.err = spawnDaemon(s.socket, s.name, dir, command, nil); s.err == nil {
+				s.err = waitForDaemon(s.socket, s.pid, 30*time.Second, 0)
+			}
+			s.startup = time.Since(start)
+		}()
+	}
+	wg.Wait()
+	defer stopStress(sessions)
+
+	var startups []time.Duration
+	for _, s := range sessions {
+		if s.err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: %s: %v\n", s.name, s.err)
+			continue
+		}
+		startups = append(startups, s.startup)
+	}
+	if len(startups) == 0 {
+		return 1
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(cmd.StressInterval)
+	defer ticker.Stop()
+	end := time.After(cmd.StressDuration)
+
+	begin := time.Now()
+	var latencies []time.Duration
+	var sampled time.Time
+	sample := func() {
+		sampled = time.Now()
+		round := sampleStress(sessions)
+		latencies = append(latencies, round...)
+		var memory uint64
+		up := 0
+		for _, s := range sessions {
+			if s.err == nil {
+				up++
+				memory += s.last.Memory
+			}
+		}
+		sort.Slice(round, func(i, j int) bool { return round[i] < round[j] })
+		fmt.Printf("%8v  %d/%d up  daemons %s  request p50 %s p99 %s\n",
+			time.Since(begin).Round(time.Second), up, len(sessions), megabytes(memory),
+			benchMs(percentile(round, 0.5)), benchMs(percentile(round, 0.99)))
+	}
+	sample()
+run:
+	for {
+		select {
+		case <-ticker.C:
+			sample()
+		case <-end:
+			if time.Since(sampled) > cmd.StressInterval/2 {
+				sample()
+			}
+			break run
+		case <-interrupt:
+			fmt.Println("interrupted")
+			break run
+		}
+	}
+
+	return reportStress(sessions, startups, latencies)
+}
+
+// sampleStress measures each live session at once: a display-message
+// round trip and the resources its daemon holds. A session that does not
+// answer is failed. It returns the round-trip times.
+func sampleStress(sessions []*stressSession) []time.Duration {
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		if s.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			resp, err := ipc.SendRequestTimeout(s.socket, &ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_dead}"}, 10*time.Second)
+			if err == nil && (!resp.OK || resp.Output != "0") {
+				err = fmt.Errorf("pane died: %s", resp.Error)
+			}
+			if err != nil {
+				s.err = err
+				return
+			}
+			d := time.Since(start)
+			mu.Lock()
+			latencies = append(latencies, d)
+			mu.Unlock()
+
+			u, err := proc.ResourceUsage(s.pid)
+			if err != nil {
+				return // not available on this platform
+			}
+			if s.firstAt.IsZero() {
+				s.first, s.firstAt = u, time.Now()
+			}
+			s.last, s.lastAt = u, time.Now()
+			s.maxMemory = max(s.maxMemory, u.Memory)
+			s.maxHandles = max(s.maxHandles, u.Handles)
+		}()
+	}
+	wg.Wait()
+	return latencies
+}
+
+// reportStress prints the summary of a run.
+func reportStress(sessions []*stressSession, startups, latencies []time.Duration) int {
+	fmt.Printf("\n%-10s %9s %9s %9s %9s\n", "", "p50", "p90", "p99", "max")
+	for _, row := range []struct {
+		name string
+		d    []time.Duration
+	}{{"startup", startups}, {"request", latencies}} {
+		sort.Slice(row.d, func(i, j int) bool { return row.d[i] < row.d[j] })
+		fmt.Printf("%-10s %9s %9s %9s %9s\n", row.name,
+			benchMs(percentile(row.d, 0.5)), benchMs(percentile(row.d, 0.9)), benchMs(percentile(row.d, 0.99)), benchMs(percentile(row.d, 1)))
+	}
+
+	var sampled, failed []*stressSession
+	for _, s := range sessions {
+		if s.err != nil {
+			failed = append(failed, s)
+		} else if s.lastAt.After(s.firstAt) {
+			sampled = append(sampled, s)
+		}
+	}
+	if len(sampled) > 0 {
+		var first, last, peak uint64
+		var cpu, peakCPU float64
+		handles, peakHandles := 0, 0
+		for _, s := range sampled {
+			first += s.first.Memory
+			last += s.last.Memory
+			peak = max(peak, s.maxMemory)
+			c := 100 * float64(s.last.CPU-s.first.CPU) / float64(s.lastAt.Sub(s.firstAt))
+			cpu += c
+			peakCPU = max(peakCPU, c)
+			handles += s.last.Handles
+			peakHandles = max(peakHandles, s.maxHandles)
+		}
+		n := uint64(len(sampled))
+		fmt.Printf("\nper daemon (%d sampled):\n", n)
+		fmt.Printf("  memory   %s at start, %s at end on average, %s at most\n",
+			megabytes(first/n), megabytes(last/n), megabytes(peak))
+		fmt.Printf("  cpu      %.1f%% of a CPU on average, %.1f%% at most\n", cpu/float64(n), peakCPU)
+		fmt.Printf("  handles  %d at end on average, %d at most\n", handles/len(sampled), peakHandles)
+	}
+	if len(failed) == 0 {
+		return 0
+	}
+	names := make([]string, len(failed))
+	for i, s := range failed {
+		names[i] = s.name
+	}
+	fmt.Printf("\n%d sessions failed: %s\n", len(failed), strings.Join(names, ", "))
+	return 1
+}
+
+// stopStress kills the sessions of a run, and their daemons if they do
+// not go.
+func stopStress(sessions []*stressSession) {
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		if s.pid == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ipc.SendRequestTimeout(s.socket, &ipc.Request{Action: ipc.ActionKillSession}, 2*time.Second)
+			deadline := time.Now().Add(5 * time.Second)
+			for proc.Alive(s.pid) && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if p, err := os.FindProcess(s.pid); err == nil && proc.Alive(s.pid) {
+				p.Kill()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runGenerator is the program stress sessions run: it writes rate
+// numbered, colored lines a second, like a chatty build or agent log,
+// until it is killed.
+func runGenerator(rate int) int {
+	const filler = "the quick brown fox jumps over the lazy dog 0123456789"
+	start := time.Now()
+	written := 0
+	var buf strings.Builder
+	for tick := time.NewTicker(10 * time.Millisecond); ; <-tick.C {
+		due := int(time.Since(start).Seconds() * float64(rate))
+		buf.Reset()
+		for ; written < due; written++ {
+			fmt.Fprintf(&buf, "\x1b[3%dm%08d\x1b[0m %s\r\n", written%7+1, written, filler)
+		}
+		if _, err := os.Stdout.WriteString(buf.String()); err != nil {
+			return 0
+		}
+	}
+}
+
+// megabytes formats a memory size for the stress report.
+func megabytes(n uint64) string {
+	return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) + "MB"
+}
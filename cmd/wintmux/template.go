@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/ipc"
+	"wintmux/internal/secret"
+	"wintmux/internal/template"
+)
+
+// sessionSetup is what new-session --template adds: environment for the
+// daemon it spawns, and once the daemon is up the options to set and the
+// parsed commands to run.
+type sessionSetup struct {
+	name     string
+	tpl      *template.Template
+	env      map[string]string
+	commands []*cli.Command
+}
+
+// loadTemplate expands the template cmd names with its --var values and
+// the built-in ${session}, and fills in the command and working
+// directory new-session was not given. Setup commands are parsed now, so
+// a bad template fails before a session is created.
+func loadTemplate(cmd *cli.Command) (*sessionSetup, error) {
+	t, err := template.Load(cmd.Template)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{"session": cmd.SessionName}
+	for name, v := range cmd.TemplateVars {
+		vars[name] = v
+	}
+	if t, err = t.Expand(vars); err != nil {
+		return nil, fmt.Errorf("template %s: %v", cmd.Template, err)
+	}
+
+	if cmd.ShellCmd == "" && t.Command != "" {
+		if strings.HasPrefix(cmd.Backend, "serial:") {
+			return nil, fmt.Errorf("template %s has a command, but a serial port pane runs no command", cmd.Template)
+		}
+		cmd.ShellCmd = t.Command
+	}
+	if cmd.StartDir == "" {
+		cmd.StartDir = t.StartDir
+	}
+	setup := &sessionSetup{name: cmd.Template, tpl: t, env: make(map[string]string)}
+	for _, e := range t.Env {
+		name, value, ok := strings.Cut(e, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("template %s: invalid env entry %q (expected NAME=VALUE)", cmd.Template, e)
+		}
+		setup.env[name] = value
+	}
+
+	for _, e := range t.SecretEnv {
+		if _, _, err := secret.ParseEnv(e); err != nil {
+			return nil, fmt.Errorf("template %s: %v", cmd.Template, err)
+		}
+	}
+	cmd.SecretEnv = append(t.SecretEnv, cmd.SecretEnv...)
+
+	for _, args := range t.Commands {
+		c, err := cli.Parse(append([]string{"-S", cmd.SocketPath}, args...))
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %s: %v", cmd.Template, args[0], err)
+		}
+		if c.Type == cli.CmdAttach || c.Type == cli.CmdNewSession {
+			return nil, fmt.Errorf("template %s: %s cannot be run from a template", cmd.Template, args[0])
+		}
+		setup.commands = append(setup.commands, c)
+	}
+	return setup, nil
+}
+
+// setEnv adds the template's environment to this process's, for the
+// daemon (and so the pane) to inherit when spawned, and returns a
+// function that restores it, so that up does not carry one session's
+// environment into the next.
+func (s *sessionSetup) setEnv() (restore func()) {
+	old := make(map[string]*string, len(s.env))
+	for name, value := range s.env {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = &v
+		} else {
+			old[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, v := range old {
+			if v == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *v)
+			}
+		}
+	}
+}
+
+// apply sets the template's options on the session, then runs its
+// commands in order, stopping at the first that fails.
+func (s *sessionSetup) apply(socketPath string) error {
+	for _, name := range s.tpl.OptionNames() {
+		resp, err := ipc.SendRequest(socketPath, &ipc.Request{
+			Action: ipc.ActionSetOption,
+			Option: name,
+			Value:  s.tpl.Options[name],
+		})
+		if err != nil {
+			return err
+		}
+		if !resp.OK {
+			return fmt.Errorf("template %s: option %s: %s", s.name, name, resp.Error)
+		}
+	}
+	for i, c := range s.commands {
+		if execute(c) != 0 {
+			return fmt.Errorf("template %s: command %d (%s) failed", s.name, i+1, s.tpl.Commands[i][0])
+		}
+	}
+	return nil
+}
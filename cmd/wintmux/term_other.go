@@ -0,0 +1,27 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func enableColorOutput() bool { return true }
+
+func makeRaw() (func(), error) {
+	return nil, errors.New("raw terminal mode not supported on this platform")
+}
+
+func terminalSize() (cols, rows int) {
+	return 0, 0
+}
+
+func terminalInput() io.Reader {
+	return os.Stdin
+}
@@ -0,0 +1,139 @@
+//go:build windows
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const (
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableProcessedOutput           = 0x0001
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+	procReadConsoleW               = kernel32.NewProc("ReadConsoleW")
+)
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode) == nil
+}
+
+// enableColorOutput turns on VT processing for the console on stdout so
+// SGR sequences show as colors, and reports whether it could: consoles
+// before Windows 10 have none. It is left on, as shells set it anyway.
+func enableColorOutput() bool {
+	out := syscall.Handle(os.Stdout.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(out, &mode); err != nil {
+		return false
+	}
+	return setConsoleMode(out, mode|enableProcessedOutput|enableVirtualTerminalProcessing) == nil
+}
+
+// makeRaw switches the console to raw VT input and VT output processing,
+// so keys arrive as the escape sequences a ConPTY session expects, and
+// returns a function that restores the previous modes.
+func makeRaw() (func(), error) {
+	in := syscall.Handle(os.Stdin.Fd())
+	out := syscall.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := syscall.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	if err := syscall.GetConsoleMode(out, &outMode); err != nil {
+		return nil, err
+	}
+	raw := inMode&^(enableProcessedInput|enableLineInput|enableEchoInput) | enableVirtualTerminalInput
+	if err := setConsoleMode(in, raw); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(out, outMode|enableProcessedOutput|enableVirtualTerminalProcessing); err != nil {
+		setConsoleMode(in, inMode)
+		return nil, err
+	}
+	return func() {
+		setConsoleMode(in, inMode)
+		setConsoleMode(out, outMode)
+	}, nil
+}
+
+type coord struct{ X, Y int16 }
+
+type consoleScreenBufferInfo struct {
+	Size              coord
+	CursorPosition    coord
+	Attributes        uint16
+	Left, Top         int16
+	Right, Bottom     int16
+	MaximumWindowSize coord
+}
+
+// terminalSize returns the size of the console window on stdout, or zeros.
+func terminalSize() (cols, rows int) {
+	var info consoleScreenBufferInfo
+	r, _, _ := procGetConsoleScreenBufferInfo.Call(os.Stdout.Fd(), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 0, 0
+	}
+	return int(info.Right-info.Left) + 1, int(info.Bottom-info.Top) + 1
+}
+
+// terminalInput returns a reader for keyboard input from the console on
+// stdin, as UTF-8.
+func terminalInput() io.Reader {
+	return &consoleReader{h: syscall.Handle(os.Stdin.Fd())}
+}
+
+// consoleReader reads the console with ReadConsoleW. Text an IME commits
+// arrives as UTF-16 in one read, and is passed on whole; a surrogate pair
+// split between reads is joined. Go's own console reader would do the
+// conversion too, but it takes Ctrl-Z for end of file and drops it, and
+// Ctrl-Z must reach the pane (shells in WSL and MSYS2 suspend on it).
+type consoleReader struct {
+	h    syscall.Handle
+	buf  [1024]uint16
+	high uint16 // first half of a surrogate pair, from the last read
+}
+
+func (r *consoleReader) Read(p []byte) (int, error) {
+	// Each UTF-16 unit takes at most 3 bytes of UTF-8; keep room for a
+	// held-over high surrogate too.
+	n := min(len(p)/3-1, len(r.buf))
+	if n <= 0 {
+		return 0, io.ErrShortBuffer
+	}
+	var read uint32
+	if ok, _, err := procReadConsoleW.Call(uintptr(r.h), uintptr(unsafe.Pointer(&r.buf[0])), uintptr(n), uintptr(unsafe.Pointer(&read)), 0); ok == 0 {
+		return 0, err
+	}
+	units := r.buf[:read]
+	if r.high != 0 {
+		units = append([]uint16{r.high}, units...)
+		r.high = 0
+	}
+	if k := len(units); k > 0 && units[k-1] >= 0xd800 && units[k-1] < 0xdc00 {
+		r.high = units[k-1]
+		units = units[:k-1]
+	}
+	return copy(p, string(utf16.Decode(units))), nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"wintmux/internal/cli"
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+	"wintmux/internal/workspace"
+)
+
+const defaultWorkspaceFormat = "#{session_name} #{session_state} #{socket_path}"
+
+func loadWorkspace(cmd *cli.Command) (*workspace.Workspace, bool) {
+	path := cmd.WorkspaceFile
+	if path == "" {
+		path = workspace.DefaultFile
+	}
+	w, err := workspace.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wintmux: %v\n", err)
+		return nil, false
+	}
+	return w, true
+}
+
+// sessionRunning reports whether a daemon answers on socket.
+func sessionRunning(socket string) bool {
+	resp, err := ipc.SendRequestTimeout(socket, &ipc.Request{Action: ipc.ActionPing}, 2*time.Second)
+	return err == nil && resp.OK
+}
+
+// executeUp creates the workspace's sessions that are not running yet,
+// in order. Every session's arguments are checked before any is
+// created; a session that fails to start does not stop the rest.
+func executeUp(cmd *cli.Command) int {
+	w, ok := loadWorkspace(cmd)
+	if !ok {
+		return 1
+	}
+	creates := make([]*cli.Command, len(w.Sessions))
+	for i, s := range w.Sessions {
+		c, err := cli.Parse(s.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: session %s: %v\n", s.Name, err)
+			return 1
+		}
+		creates[i] = c
+	}
+
+	failed := 0
+	for i, s := range w.Sessions {
+		if sessionRunning(s.Socket) {
+			fmt.Printf("%s: running\n", s.Name)
+			continue
+		}
+		if executeNewSession(creates[i]) != 0 {
+			failed++
+			continue
+		}
+		fmt.Printf("%s: created\n", s.Name)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "wintmux: %d of %d sessions failed to start\n", failed, len(w.Sessions))
+		return 1
+	}
+	return 0
+}
+
+// executeDown kills the workspace's running sessions, last first.
+func executeDown(cmd *cli.Command) int {
+	w, ok := loadWorkspace(cmd)
+	if !ok {
+		return 1
+	}
+	status := 0
+	for i := len(w.Sessions) - 1; i >= 0; i-- {
+		s := w.Sessions[i]
+		if !sessionRunning(s.Socket) {
+			fmt.Printf("%s: not running\n", s.Name)
+			continue
+		}
+		resp, err := ipc.SendRequest(s.Socket, &ipc.Request{Action: ipc.ActionKillSession})
+		if err == nil && !resp.OK {
+			err = fmt.Errorf("%s", resp.Error)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wintmux: session %s: %v\n", s.Name, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: killed\n", s.Name)
+	}
+	return status
+}
+
+// executeStatus lists the workspace's sessions and whether each is
+// running; the exit code is 1 unless all are. A format that refers to
+// pane variables gets the resource use of running sessions too.
+func executeStatus(cmd *cli.Command) int {
+	w, ok := loadWorkspace(cmd)
+	if !ok {
+		return 1
+	}
+	tmpl := cmd.Format
+	if tmpl == "" {
+		tmpl = defaultWorkspaceFormat
+	}
+	colored := cmd.Format == "" // -F output is for scripts
+	withUsage := strings.Contains(tmpl, "pane_")
+	status := 0
+	for _, s := range w.Sessions {
+		vars := map[string]string{
+			"session_name":  s.Name,
+			"session_state": "running",
+			"socket_path":   s.Socket,
+		}
+		if !sessionRunning(s.Socket) {
+			vars["session_state"], status = "stopped", 1
+		} else if withUsage {
+			usage, _ := sessionUsage(s.Socket)
+			for name, v := range usage {
+				vars[name] = v
+			}
+		}
+		if colored {
+			vars["session_state"] = paintState(vars["session_state"])
+		}
+		fmt.Println(format.Expand(tmpl, vars))
+	}
+	return status
+}
@@ -0,0 +1,318 @@
+// Package broker multiplexes many session daemons behind one connection.
+// A controller keeps a single TCP connection to the broker and sends
+// requests tagged with a session (name or socket path) and an ID; the
+// broker forwards them over pooled daemon connections and replies with
+// the same ID. Subscribed connections also receive events from every
+// session as they happen.
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/registry"
+)
+
+// refreshInterval is how often the session list is reread from the
+// registry while anyone is subscribed.
+const refreshInterval = 2 * time.Second
+
+// requestTimeout bounds forwarded requests that do not set TimeoutMs.
+// Tests shorten it.
+var requestTimeout = 10 * time.Second
+
+// Broker serves controller connections.
+type Broker struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	daemons map[string]*daemonConn // pooled connections by socket path
+	subs    map[*controller]bool
+	pumps   map[string]bool // sockets with a running event pump
+	stop    chan struct{}
+}
+
+// New returns a broker accepting controllers on ln.
+func New(ln net.Listener) *Broker {
+	return &Broker{
+		listener: ln,
+		daemons:  make(map[string]*daemonConn),
+		subs:     make(map[*controller]bool),
+		pumps:    make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Serve accepts controllers until the listener is closed.
+func (b *Broker) Serve() error {
+	go b.pumpLoop()
+	defer close(b.stop)
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		ipc.DefaultTCP.Tune(conn)
+		go b.serveController(conn)
+	}
+}
+
+// controller is one connection from a controlling client. Responses and
+// events may be written concurrently, so writes are serialized.
+type controller struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	// Subscription filters, guarded by Broker.mu; empty matches all.
+	eventType string
+	session   string // socket path
+}
+
+// send writes resp to the controller, compressed if it asked for that.
+func (c *controller) send(resp ipc.Response, compress bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if compress {
+		return ipc.WriteMessageCompressed(c.conn, resp)
+	}
+	return ipc.WriteMessage(c.conn, resp)
+}
+
+func (b *Broker) serveController(conn net.Conn) {
+	c := &controller{conn: conn}
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, c)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		var req ipc.Request
+		// Controllers may stay idle indefinitely, but a frame, once
+		// started, must arrive promptly.
+		err := ipc.ReadMessageDeadline(conn, &req, 0)
+		if err == nil {
+			err = req.Validate()
+		} else if !errors.Is(err, ipc.ErrBadMessage) {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("broker: read request: %v", err)
+			}
+			return
+		}
+		if err != nil {
+			if c.send(ipc.Response{ID: req.ID, OK: false, Error: "bad request: " + err.Error()}, false) != nil {
+				return
+			}
+			continue
+		}
+		// Requests run concurrently so a blocking wait on one session
+		// does not hold up the others; IDs match responses to requests.
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("broker: panic handling %s: %v", req.Action, r)
+					conn.Close()
+				}
+			}()
+			resp := b.handle(c, req)
+			resp.ID = req.ID
+			if err := c.send(resp, req.Compress); err != nil {
+				log.Printf("broker: write response: %v", err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (b *Broker) handle(c *controller, req ipc.Request) ipc.Response {
+	switch req.Action {
+	case ipc.ActionBrokerSessions:
+		return listSessions()
+	case ipc.ActionSubscribe:
+		return b.subscribe(c, req)
+	case ipc.ActionPing:
+		if req.Session == "" {
+			return ipc.Response{OK: true}
+		}
+	case ipc.ActionCaptureAll:
+		if req.Session == "" {
+			return b.captureAll(req)
+		}
+	}
+
+	if req.Session == "" {
+		return ipc.Response{OK: false, Error: "no session specified"}
+	}
+	e, err := resolve(req.Session)
+	if err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	req.Session = ""
+	var onProgress func(string)
+	if req.Progress {
+		onProgress = func(status string) {
+			c.send(ipc.Response{ID: req.ID, OK: true, Progress: true, Output: status, Session: e.Session}, false)
+		}
+	}
+	resp, err := b.forward(e.Socket, req, onProgress)
+	if err != nil {
+		return ipc.Response{OK: false, Error: err.Error(), Session: e.Session}
+	}
+	resp.Session = e.Session
+	return *resp
+}
+
+// subscribe starts (or, called again, re-filters) the delivery of events
+// to c.
+func (b *Broker) subscribe(c *controller, req ipc.Request) ipc.Response {
+	socket := ""
+	if req.Session != "" {
+		e, err := resolve(req.Session)
+		if err != nil {
+			return ipc.Response{OK: false, Error: err.Error()}
+		}
+		socket = e.Socket
+	}
+	b.mu.Lock()
+	c.eventType, c.session = req.EventType, socket
+	b.subs[c] = true
+	b.mu.Unlock()
+	b.refreshPumps()
+	return ipc.Response{OK: true}
+}
+
+// captureAll captures every registered session in one request, so a
+// dashboard refreshes them all in a single round trip.
+func (b *Broker) captureAll(req ipc.Request) ipc.Response {
+	entries, err := registry.List()
+	if err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	targets := make([]ipc.Target, len(entries))
+	for i, e := range entries {
+		targets[i] = ipc.Target{Session: e.Session, Socket: e.Socket}
+	}
+	panes := ipc.GatherCaptures(targets, func(socket string) (*ipc.Response, error) {
+		return b.forward(socket, req, nil)
+	})
+	return ipc.Response{OK: true, Output: ipc.FormatCaptures(panes)}
+}
+
+func listSessions() ipc.Response {
+	entries, err := registry.List()
+	if err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, e.Session+"\t"+e.Socket)
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+// resolve finds a running session by socket path or by name. A name
+// shared by several sessions is an error; use the socket path instead.
+func resolve(target string) (registry.Entry, error) {
+	entries, err := registry.List()
+	if err != nil {
+		return registry.Entry{}, err
+	}
+	var found []registry.Entry
+	for _, e := range entries {
+		if e.Socket == target {
+			return e, nil
+		}
+		if e.Session == target {
+			found = append(found, e)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return registry.Entry{}, fmt.Errorf("session not found: %s", target)
+	case 1:
+		return found[0], nil
+	}
+	return registry.Entry{}, fmt.Errorf("session name %s is ambiguous (%d sessions); use its socket path", target, len(found))
+}
+
+// forward sends req to the daemon on socket. Blocking requests (those
+// with a timeout) get their own connection so they do not hold up the
+// pooled one; their progress frames, if wanted, go to onProgress.
+func (b *Broker) forward(socket string, req ipc.Request, onProgress func(string)) (*ipc.Response, error) {
+	if req.TimeoutMs > 0 {
+		return ipc.SendRequestProgress(socket, &req, time.Duration(req.TimeoutMs)*time.Millisecond+requestTimeout, onProgress)
+	}
+	// Only blocking requests report progress.
+	req.Progress = false
+	b.mu.Lock()
+	dc := b.daemons[socket]
+	if dc == nil {
+		dc = &daemonConn{socket: socket}
+		b.daemons[socket] = dc
+	}
+	b.mu.Unlock()
+	return dc.roundTrip(&req)
+}
+
+// daemonConn is a pooled, persistent connection to one daemon, used for
+// one request at a time.
+type daemonConn struct {
+	mu     sync.Mutex
+	socket string
+	conn   net.Conn
+}
+
+// roundTrip sends req and reads the response, redialing once if the
+// pooled connection has gone stale (daemons close idle connections). It
+// sends req again only when the daemon cannot have run it: the write
+// failed, or the pooled connection ended before any reply. After a
+// timeout or a broken reply the daemon may have, so a send-keys is never
+// typed twice.
+func (dc *daemonConn) roundTrip(req *ipc.Request) (*ipc.Response, error) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if req.Client == "" {
+		req.Client = ipc.ClientName()
+	}
+	// The broker decodes daemon replies itself; whether the controller
+	// gets a compressed reply depends on its own request.
+	req.Compress = ipc.CompressionEnabled()
+	for attempt := 0; ; attempt++ {
+		pooled := dc.conn != nil
+		if !pooled {
+			conn, err := ipc.ConnectRetry(dc.socket, ipc.DefaultRetry)
+			if err != nil {
+				return nil, err
+			}
+			dc.conn = conn
+		}
+		dc.conn.SetDeadline(time.Now().Add(requestTimeout))
+		var resp ipc.Response
+		err := ipc.WriteMessage(dc.conn, req)
+		retry := err != nil
+		if err == nil {
+			err = ipc.ReadMessage(dc.conn, &resp)
+			retry = pooled && errors.Is(err, io.EOF)
+		}
+		if err == nil {
+			return &resp, nil
+		}
+		dc.conn.Close()
+		dc.conn = nil
+		if !retry || attempt > 0 {
+			return nil, err
+		}
+	}
+}
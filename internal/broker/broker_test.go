@@ -0,0 +1,250 @@
+package broker
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/registry"
+)
+
+// fakeDaemon answers requests like a session daemon, several per
+// connection. capture_pane returns the session name, as does the one
+// pane capture_all reports; the first
+// wait_event returns one watch event and later ones time out.
+// send_keys "hang" is never answered, and send_keys "close" closes the
+// connection after its reply, as a daemon closes an idle one.
+type fakeDaemon struct {
+	name   string
+	socket string
+	conns  atomic.Int32 // connections that carried at least one request
+	polls  atomic.Int32
+	keys   atomic.Int32 // send_keys requests received
+}
+
+func startFakeDaemon(t *testing.T, name string) *fakeDaemon {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	pid := 10000 + port
+	socket := filepath.Join(t.TempDir(), name+".sock")
+	f := &fakeDaemon{name: name, socket: socket}
+	data, _ := json.Marshal(ipc.ControlInfo{Port: port, PID: pid})
+	if err := os.WriteFile(socket, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(registry.Entry{Session: name, Socket: socket, PID: pid, Port: port}); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeDaemon) serve(conn net.Conn) {
+	defer conn.Close()
+	for n := 0; ; n++ {
+		var req ipc.Request
+		if err := ipc.ReadMessage(conn, &req); err != nil {
+			return
+		}
+		if n == 0 {
+			f.conns.Add(1)
+		}
+		resp := ipc.Response{OK: true, ID: req.ID}
+		switch req.Action {
+		case ipc.ActionCapture:
+			resp.Output = "screen of " + f.name
+		case ipc.ActionCaptureAll:
+			resp.Output = ipc.FormatCaptures([]ipc.PaneCapture{{Session: f.name, Lines: []string{"screen of " + f.name}}})
+		case ipc.ActionWaitEvent:
+			if f.polls.Add(1) == 1 {
+				resp.Output = "1\twatch\terr: ERROR"
+			} else {
+				time.Sleep(50 * time.Millisecond)
+				resp = ipc.Response{OK: false, Error: "timed out", Output: "1", ID: req.ID}
+			}
+		case ipc.ActionSendKeys:
+			f.keys.Add(1)
+			if req.Text == "hang" {
+				time.Sleep(time.Second)
+				return
+			}
+		}
+		if err := ipc.WriteMessage(conn, resp); err != nil {
+			return
+		}
+		if req.Action == ipc.ActionSendKeys && req.Text == "close" {
+			return
+		}
+	}
+}
+
+func startBroker(t *testing.T) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go New(ln).Serve()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return conn
+}
+
+func TestForwardByName(t *testing.T) {
+	t.Setenv("WINTMUX_REGISTRY_DIR", t.TempDir())
+	a := startFakeDaemon(t, "alpha")
+	startFakeDaemon(t, "beta")
+	conn := startBroker(t)
+
+	reqs := []ipc.Request{
+		{Action: ipc.ActionCapture, ID: 1, Session: "alpha"},
+		{Action: ipc.ActionCapture, ID: 2, Session: "beta"},
+		{Action: ipc.ActionCapture, ID: 3, Session: "alpha"},
+		{Action: ipc.ActionCapture, ID: 4, Session: "gamma"},
+	}
+	for _, req := range reqs {
+		if err := ipc.WriteMessage(conn, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make(map[int64]ipc.Response)
+	for range reqs {
+		var resp ipc.Response
+		if err := ipc.ReadMessage(conn, &resp); err != nil {
+			t.Fatal(err)
+		}
+		got[resp.ID] = resp
+	}
+
+	for id, want := range map[int64]string{1: "screen of alpha", 2: "screen of beta", 3: "screen of alpha"} {
+		if !got[id].OK || got[id].Output != want {
+			t.Errorf("id %d: got %+v, want output %q", id, got[id], want)
+		}
+	}
+	if got[1].Session != "alpha" {
+		t.Errorf("expected session alpha on response 1, got %q", got[1].Session)
+	}
+	if got[4].OK {
+		t.Errorf("expected error for unknown session, got %+v", got[4])
+	}
+	if n := a.conns.Load(); n != 1 {
+		t.Errorf("expected alpha to be dialed once, got %d connections", n)
+	}
+}
+
+func TestCaptureAllSessions(t *testing.T) {
+	t.Setenv("WINTMUX_REGISTRY_DIR", t.TempDir())
+	startFakeDaemon(t, "alpha")
+	startFakeDaemon(t, "beta")
+	conn := startBroker(t)
+
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionCaptureAll, ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil {
+		t.Fatal(err)
+	}
+	panes, err := ipc.ParseCaptures(resp.Output)
+	if !resp.OK || err != nil {
+		t.Fatalf("capture_all: %+v %v", resp, err)
+	}
+	got := map[string]string{}
+	for _, p := range panes {
+		got[p.Session] = strings.Join(p.Lines, "\n")
+	}
+	if len(panes) != 2 || got["alpha"] != "screen of alpha" || got["beta"] != "screen of beta" {
+		t.Errorf("panes = %+v", panes)
+	}
+}
+
+func TestSubscribeReceivesEvents(t *testing.T) {
+	t.Setenv("WINTMUX_REGISTRY_DIR", t.TempDir())
+	startFakeDaemon(t, "alpha")
+	conn := startBroker(t)
+
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionSubscribe, ID: 7, EventType: "watch"}); err != nil {
+		t.Fatal(err)
+	}
+	var sawAck, sawEvent bool
+	for !(sawAck && sawEvent) {
+		var resp ipc.Response
+		if err := ipc.ReadMessage(conn, &resp); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case resp.Event:
+			if resp.Session != "alpha" || resp.Output != "1\twatch\terr: ERROR" {
+				t.Errorf("unexpected event %+v", resp)
+			}
+			sawEvent = true
+		case resp.ID == 7:
+			if !resp.OK {
+				t.Fatalf("subscribe failed: %s", resp.Error)
+			}
+			sawAck = true
+		}
+	}
+}
+
+func TestRoundTripRetry(t *testing.T) {
+	t.Setenv("WINTMUX_REGISTRY_DIR", t.TempDir())
+	f := startFakeDaemon(t, "alpha")
+	dc := &daemonConn{socket: f.socket}
+	t.Cleanup(func() {
+		if dc.conn != nil {
+			dc.conn.Close()
+		}
+	})
+
+	// A pooled connection the daemon has closed is redialed and the
+	// request sent again, since the daemon never read it.
+	if _, err := dc.roundTrip(&ipc.Request{Action: ipc.ActionSendKeys, Text: "close"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if resp, err := dc.roundTrip(&ipc.Request{Action: ipc.ActionSendKeys, Text: "x"}); err != nil || !resp.OK {
+		t.Fatalf("after the daemon closed the connection: %+v %v", resp, err)
+	}
+	if n := f.keys.Load(); n != 2 {
+		t.Errorf("daemon got %d send_keys, want 2", n)
+	}
+
+	// A request that times out may have run, so it is not sent again.
+	defer func(d time.Duration) { requestTimeout = d }(requestTimeout)
+	requestTimeout = 100 * time.Millisecond
+	if _, err := dc.roundTrip(&ipc.Request{Action: ipc.ActionSendKeys, Text: "hang"}); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := f.keys.Load(); n != 3 {
+		t.Errorf("daemon got %d send_keys, want 3: a timed-out request was sent again", n)
+	}
+}
@@ -0,0 +1,129 @@
+package broker
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/registry"
+)
+
+// eventFormat is the machine-readable form the broker asks daemons for,
+// so it can track sequence numbers and filter by type.
+const eventFormat = "#{event_seq}\t#{event_type}\t#{event_text}"
+
+// pumpWait is how long each wait_event long poll lasts.
+const pumpWait = 30 * time.Second
+
+// pumpLoop keeps one event pump running per live session while anyone is
+// subscribed, picking up sessions created after the subscription.
+func (b *Broker) pumpLoop() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.refreshPumps()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *Broker) refreshPumps() {
+	if !b.subscribed() {
+		return
+	}
+	entries, err := registry.List()
+	if err != nil {
+		log.Printf("broker: list sessions: %v", err)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range entries {
+		if !b.pumps[e.Socket] {
+			b.pumps[e.Socket] = true
+			go b.pump(e)
+		}
+	}
+}
+
+func (b *Broker) subscribed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
+
+// pump long-polls one daemon for events and fans them out. It stops when
+// the daemon goes away or nobody is subscribed any more.
+func (b *Broker) pump(e registry.Entry) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.pumps, e.Socket)
+		b.mu.Unlock()
+	}()
+
+	since := int64(-1)
+	for b.subscribed() {
+		resp, err := ipc.SendRequestTimeout(e.Socket, &ipc.Request{
+			Action:    ipc.ActionWaitEvent,
+			Since:     since,
+			Format:    eventFormat,
+			TimeoutMs: int(pumpWait / time.Millisecond),
+		}, pumpWait+requestTimeout)
+		if err != nil {
+			log.Printf("broker: events from %s: %v", e.Session, err)
+			return
+		}
+		if !resp.OK {
+			// A timed-out wait reports where to resume, so nothing
+			// emitted between two polls is missed.
+			if n, err := strconv.ParseInt(resp.Output, 10, 64); err == nil {
+				since = n
+			}
+			continue
+		}
+		for _, line := range strings.Split(resp.Output, "\n") {
+			seq, kind, ok := parseEvent(line)
+			if !ok {
+				continue
+			}
+			since = max(since, seq)
+			b.publish(e, kind, line)
+		}
+	}
+}
+
+func parseEvent(line string) (int64, string, bool) {
+	parts := strings.SplitN(line, "\t", 3)
+	if len(parts) < 2 {
+		return 0, "", false
+	}
+	seq, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return seq, parts[1], true
+}
+
+// publish delivers one event line to every matching subscriber.
+func (b *Broker) publish(e registry.Entry, kind, line string) {
+	b.mu.Lock()
+	var targets []*controller
+	for c := range b.subs {
+		if (c.eventType == "" || c.eventType == kind) && (c.session == "" || c.session == e.Socket) {
+			targets = append(targets, c)
+		}
+	}
+	b.mu.Unlock()
+
+	ev := ipc.Response{OK: true, Event: true, Session: e.Session, Output: line}
+	for _, c := range targets {
+		if err := c.send(ev, false); err != nil {
+			c.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"wintmux/internal/ipc"
+)
+
+// Run starts a broker on a loopback port, advertises it through a
+// control file at controlPath (the same format daemons use, so clients
+// connect with ipc.Connect), and serves until interrupted.
+func Run(controlPath string) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	info := ipc.ControlInfo{Port: ln.Addr().(*net.TCPAddr).Port, PID: os.Getpid()}
+	data, err := json.Marshal(info)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	os.MkdirAll(filepath.Dir(controlPath), 0755)
+	if err := os.WriteFile(controlPath, data, 0644); err != nil {
+		ln.Close()
+		return fmt.Errorf("write control file: %w", err)
+	}
+	defer os.Remove(controlPath)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		ln.Close()
+	}()
+
+	log.Printf("broker: port=%d control=%s", info.Port, controlPath)
+	return New(ln).Serve()
+}
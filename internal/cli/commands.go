@@ -0,0 +1,1158 @@
+package cli
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"wintmux/internal/pty"
+	"wintmux/internal/secret"
+	"wintmux/internal/units"
+	"wintmux/internal/vt"
+)
+
+// Flag is one flag a command accepts.
+type Flag struct {
+	Name   string // "-t", "--timeout"
+	Alias  string // another spelling, such as "--all" for "-a"; may be empty
+	Arg    string // what its value is, for usage and errors ("target"); empty for a switch
+	Hidden bool   // internal: left out of usage and completion
+	// Set stores the flag in the command, given its value ("" for a
+	// switch), or reports why the value is invalid.
+	Set func(cmd *Command, value string) error
+}
+
+// Spec describes one command: its names, the flags and arguments it
+// takes and how they are stored in a Command, and its line in the usage.
+// Parse, help and shell completion are all driven by the table of specs,
+// so adding a command is adding a Spec.
+//
+// Arguments that are not flags go to Arg one at a time, and flags may
+// follow them; or, with Rest, every argument from the first that is not
+// a flag goes to Rest at once, as a command line or pattern that may
+// contain flags of its own. "--" ends the flags of the commands whose
+// Args start with "[--]", and "-" is an argument to those whose Args end
+// in "| -". Any other unknown flag is an error unless Dashes says
+// arguments may start with "-".
+type Spec struct {
+	Name    string
+	Aliases []string
+	Type    CommandType
+	Summary string // the usage line; further lines continue it
+	Args    string // the arguments after the flags in the synopsis, e.g. "[name]"
+	Flags   []Flag
+
+	Arg    func(cmd *Command, arg string) error
+	Rest   func(cmd *Command, args []string) error
+	Dashes bool
+
+	Init  func(cmd *Command)       // sets defaults before parsing
+	Check func(cmd *Command) error // checks the flags and arguments together
+
+	// Run carries the command out and returns the exit code. The program
+	// sets it, as the handlers live there.
+	Run func(cmd *Command) int
+}
+
+// Commands is every command, in the order the usage lists them. It is
+// filled in by init, as schedule parses the command it is given.
+var Commands []*Spec
+
+// LookupCommand returns the command named name or one of its aliases.
+func LookupCommand(name string) (*Spec, bool) {
+	for _, s := range Commands {
+		if s.Name == name {
+			return s, true
+		}
+		for _, alias := range s.Aliases {
+			if alias == name {
+				return s, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// LookupType returns the command of type t.
+func LookupType(t CommandType) (*Spec, bool) {
+	for _, s := range Commands {
+		if s.Type == t {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// parse parses the arguments after the command name into cmd.
+func (s *Spec) parse(cmd *Command, args []string) (*Command, error) {
+	cmd.Type = s.Type
+	if s.Init != nil {
+		s.Init(cmd)
+	}
+	takesArgs := s.Arg != nil || s.Rest != nil
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if f := s.flag(arg); f != nil {
+			value := ""
+			if f.Arg != "" {
+				i++
+				if i >= len(args) {
+					return nil, fmt.Errorf("%s requires %s", arg, describeArg(f.Arg))
+				}
+				value = args[i]
+			}
+			if err := f.Set(cmd, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rest := args[i:]
+		switch {
+		case arg == "--" && strings.HasPrefix(s.Args, "[--]"):
+			rest = args[i+1:]
+		case strings.HasPrefix(arg, "-") && !s.Dashes && !(arg == "-" && strings.HasSuffix(s.Args, "| -")):
+			return nil, fmt.Errorf("unknown %s flag: %s", s.Name, arg)
+		case !takesArgs:
+			return nil, fmt.Errorf("unexpected %s argument: %s", s.Name, arg)
+		case s.Arg != nil:
+			rest = rest[:1]
+		}
+		if err := s.takeArgs(cmd, rest); err != nil {
+			return nil, err
+		}
+		if s.Rest != nil || arg == "--" {
+			break
+		}
+	}
+	if s.Check != nil {
+		if err := s.Check(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return cmd, nil
+}
+
+func (s *Spec) takeArgs(cmd *Command, args []string) error {
+	if s.Rest != nil {
+		if len(args) == 0 {
+			return nil
+		}
+		return s.Rest(cmd, args)
+	}
+	for _, arg := range args {
+		if err := s.Arg(cmd, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spec) flag(arg string) *Flag {
+	for i := range s.Flags {
+		if f := &s.Flags[i]; arg == f.Name || f.Alias != "" && arg == f.Alias {
+			return f
+		}
+	}
+	return nil
+}
+
+// Synopsis returns the command's usage line, as tmux's list-commands
+// shows it: its flags, then its arguments.
+func (s *Spec) Synopsis() string {
+	var b strings.Builder
+	b.WriteString(s.Name)
+	for _, f := range s.Flags {
+		if f.Hidden {
+			continue
+		}
+		name := f.Name
+		if f.Alias != "" {
+			name += " | " + f.Alias
+		}
+		if f.Arg != "" {
+			name += " " + f.Arg
+		}
+		b.WriteString(" [" + name + "]")
+	}
+	if s.Args != "" {
+		b.WriteString(" " + s.Args)
+	}
+	return b.String()
+}
+
+// describeArg turns a flag's argument into the object of "requires":
+// "a target", but "posix|cmd|powershell" and "NAME=VALUE" as they are.
+func describeArg(arg string) string {
+	if strings.ContainsAny(arg, "|=:") || strings.ToUpper(arg) == arg {
+		return arg
+	}
+	if strings.ContainsRune("aeiou", rune(arg[0])) {
+		return "an " + arg
+	}
+	return "a " + arg
+}
+
+// --- Flag constructors ---
+
+func boolFlag(name string, field func(*Command) *bool) Flag {
+	return Flag{Name: name, Set: func(cmd *Command, _ string) error {
+		*field(cmd) = true
+		return nil
+	}}
+}
+
+func stringFlag(name, arg string, field func(*Command) *string) Flag {
+	return Flag{Name: name, Arg: arg, Set: func(cmd *Command, v string) error {
+		*field(cmd) = v
+		return nil
+	}}
+}
+
+// intFlag takes a number from min to max.
+func intFlag(name, arg string, min, max int, field func(*Command) *int) Flag {
+	return Flag{Name: name, Arg: arg, Set: func(cmd *Command, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("invalid %s value %q", name, v)
+		}
+		*field(cmd) = n
+		return nil
+	}}
+}
+
+func durationFlag(name string, field func(*Command) *time.Duration) Flag {
+	return Flag{Name: name, Arg: "duration", Set: func(cmd *Command, v string) error {
+		d, err := parseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(cmd) = d
+		return nil
+	}}
+}
+
+// choiceFlag takes one of choices.
+func choiceFlag(name string, choices []string, field func(*Command) *string) Flag {
+	return Flag{Name: name, Arg: strings.Join(choices, "|"), Set: func(cmd *Command, v string) error {
+		for _, c := range choices {
+			if v == c {
+				*field(cmd) = v
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid %s %q (want %s)", name, v, orList(choices))
+	}}
+}
+
+// orList joins words as "a, b or c".
+func orList(words []string) string {
+	if len(words) == 1 {
+		return words[0]
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+}
+
+func targetFlag() Flag {
+	return stringFlag("-t", "target", func(c *Command) *string { return &c.Target })
+}
+
+func formatFlag() Flag {
+	return stringFlag("-F", "format", func(c *Command) *string { return &c.Format })
+}
+
+func timeoutFlag() Flag {
+	return durationFlag("--timeout", func(c *Command) *time.Duration { return &c.Timeout })
+}
+
+func progressFlag() Flag {
+	return boolFlag("--progress", func(c *Command) *bool { return &c.Progress })
+}
+
+func allFlag() Flag {
+	f := boolFlag("-a", func(c *Command) *bool { return &c.AllClients })
+	f.Alias = "--all"
+	return f
+}
+
+func shellFlag() Flag {
+	return choiceFlag("--shell", []string{"posix", "cmd", "powershell"}, func(c *Command) *string { return &c.Shell })
+}
+
+func envFlag() Flag {
+	return Flag{Name: "-e", Arg: "VARIABLE=value", Set: func(cmd *Command, v string) error {
+		if !strings.Contains(v, "=") {
+			return fmt.Errorf("-e requires VARIABLE=value")
+		}
+		cmd.Env = append(cmd.Env, v)
+		return nil
+	}}
+}
+
+func stripFlag() Flag {
+	return Flag{Name: "--strip", Arg: "profile", Set: func(cmd *Command, v string) error {
+		if _, err := vt.ParseProfile(v); err != nil {
+			return err
+		}
+		cmd.Strip = v
+		return nil
+	}}
+}
+
+func encodingFlag() Flag {
+	return choiceFlag("--encoding", []string{"utf8", "base64", "utf16"}, func(c *Command) *string { return &c.Encoding })
+}
+
+func startLineFlag(command string) Flag {
+	return Flag{Name: "-S", Arg: "start-line", Set: func(cmd *Command, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s start line %q: %w", command, v, err)
+		}
+		cmd.StartLine = n
+		return nil
+	}}
+}
+
+// --- Argument handlers ---
+
+// oneArg stores the command's single argument.
+func oneArg(field func(*Command) *string) func(*Command, string) error {
+	return func(cmd *Command, arg string) error {
+		if *field(cmd) != "" {
+			return fmt.Errorf("unexpected argument: %s", arg)
+		}
+		*field(cmd) = arg
+		return nil
+	}
+}
+
+// joinedArgs stores the arguments joined with spaces: a command line or
+// a pattern.
+func joinedArgs(field func(*Command) *string) func(*Command, []string) error {
+	return func(cmd *Command, args []string) error {
+		*field(cmd) = strings.Join(args, " ")
+		return nil
+	}
+}
+
+func appendKeys(cmd *Command, arg string) error {
+	cmd.Keys = append(cmd.Keys, arg)
+	return nil
+}
+
+// requires returns a Check that fails with msg while the field is empty.
+func requires(msg string, field func(*Command) *string) func(*Command) error {
+	return func(cmd *Command) error {
+		if *field(cmd) == "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return nil
+	}
+}
+
+// listCommand is a list-* command: [-t target] [-F format].
+func listCommand(name string, typ CommandType, summary string, aliases ...string) *Spec {
+	return &Spec{Name: name, Aliases: aliases, Type: typ, Summary: summary, Flags: []Flag{targetFlag(), formatFlag()}}
+}
+
+// removeCommand is a *-remove command taking the name of what to remove.
+func removeCommand(name string, typ CommandType, summary, what string, field func(*Command) *string) *Spec {
+	return &Spec{
+		Name: name, Type: typ, Summary: summary, Args: "name",
+		Flags: []Flag{targetFlag()},
+		Arg: func(cmd *Command, arg string) error {
+			*field(cmd) = arg
+			return nil
+		},
+		Check: requires(name+" requires "+what, field),
+	}
+}
+
+// backendFlag is one of new-session's backend flags: --backend takes a
+// whole spec and the others a target of scheme.
+func backendFlag(name, scheme, arg string) Flag {
+	return Flag{Name: name, Arg: arg, Set: func(cmd *Command, v string) error {
+		if cmd.Backend != "" {
+			return fmt.Errorf("only one of --backend, --container, --ssh and --serial can be given")
+		}
+		if scheme != "" {
+			v = scheme + ":" + v
+		}
+		if _, err := pty.ParseSpec(v); err != nil {
+			return err
+		}
+		cmd.Backend = v
+		return nil
+	}}
+}
+
+func init() {
+	Commands = []*Spec{
+		{
+			Name: "new-session", Type: CmdNewSession, Args: "[--] [shell-command]",
+			Summary: "Create a new session (--template NAME --var K=V to set it up from a template;\n" +
+				"--secret-env K=cred:TARGET to give the pane a stored secret)",
+			Flags: []Flag{
+				boolFlag("-d", func(c *Command) *bool { return &c.Detached }),
+				stringFlag("-s", "session-name", func(c *Command) *string { return &c.SessionName }),
+				stringFlag("-n", "window-name", func(c *Command) *string { return &c.WindowName }),
+				stringFlag("-c", "start-directory", func(c *Command) *string { return &c.StartDir }),
+				durationFlag("--startup-timeout", func(c *Command) *time.Duration { return &c.StartupTimeout }),
+				durationFlag("--startup-interval", func(c *Command) *time.Duration { return &c.StartupInterval }),
+				{Name: "--bind", Arg: "address", Set: func(cmd *Command, v string) error {
+					hosts, err := parseBind(v)
+					cmd.Bind = hosts
+					return err
+				}},
+				{Name: "--port", Arg: "port", Set: func(cmd *Command, v string) error {
+					port, err := parsePort(v)
+					cmd.Port = port
+					return err
+				}},
+				backendFlag("--backend", "", "SCHEME:TARGET"),
+				backendFlag("--container", "docker", "container"),
+				backendFlag("--ssh", "ssh", "host"),
+				backendFlag("--serial", "serial", "port"),
+				stringFlag("--template", "template", func(c *Command) *string { return &c.Template }),
+				{Name: "--var", Arg: "NAME=VALUE", Set: func(cmd *Command, v string) error {
+					name, value, ok := strings.Cut(v, "=")
+					if !ok || name == "" {
+						return fmt.Errorf("invalid --var %q (expected NAME=VALUE)", v)
+					}
+					if cmd.TemplateVars == nil {
+						cmd.TemplateVars = make(map[string]string)
+					}
+					cmd.TemplateVars[name] = value
+					return nil
+				}},
+				{Name: "--secret-env", Arg: "NAME=SOURCE:NAME", Set: func(cmd *Command, v string) error {
+					if _, _, err := secret.ParseEnv(v); err != nil {
+						return err
+					}
+					cmd.SecretEnv = append(cmd.SecretEnv, v)
+					return nil
+				}},
+				durationFlag("--ttl", func(c *Command) *time.Duration { return &c.TTL }),
+			},
+			Rest:   joinedArgs(func(c *Command) *string { return &c.ShellCmd }),
+			Dashes: true,
+			Check:  checkNewSession,
+		},
+		{
+			Name: "clone-session", Type: CmdCloneSession, Args: "name",
+			Summary: "Start a session like this one: same cwd, env, options (--replay its command)",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-d", func(c *Command) *bool { return &c.Detached }),
+				boolFlag("--replay", func(c *Command) *bool { return &c.Replay }),
+				stringFlag("--socket", "path", func(c *Command) *string { return &c.CloneSocket }),
+			},
+			Arg:   oneArg(func(c *Command) *string { return &c.SessionName }),
+			Check: requires("clone-session requires a name for the new session", func(c *Command) *string { return &c.SessionName }),
+		},
+		{
+			Name: "send-keys", Type: CmdSendKeys, Args: "[--] key ...",
+			Summary: "Send keys to a session",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-l", func(c *Command) *bool { return &c.Literal }),
+			},
+			Arg:    appendKeys,
+			Dashes: true,
+		},
+		{
+			Name: "send-text", Type: CmdSendText, Args: "[--] text ...",
+			Summary: "Send composed text (IME input) to a session as one write",
+			Flags:   []Flag{targetFlag()},
+			Arg:     appendKeys,
+			Dashes:  true,
+			Check:   requireKeys("send-text requires text"),
+		},
+		{
+			Name: "run-ps", Type: CmdRunPS, Args: "[--] command ... | -",
+			Summary: "Type a PowerShell command into the pane past PSReadLine quirks (- reads stdin)",
+			Flags:   []Flag{targetFlag()},
+			Arg:     appendKeys,
+			Dashes:  true,
+			Check:   requireKeys("run-ps requires a command"),
+		},
+		{
+			Name: "capture-pane", Type: CmdCapturePane,
+			Summary: "Capture pane output (--encoding base64|utf16 for legacy consumers)",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-p", func(c *Command) *bool { return &c.Print }),
+				boolFlag("-J", func(c *Command) *bool { return &c.JoinLines }),
+				boolFlag("-a", func(c *Command) *bool { return &c.Alternate }),
+				boolFlag("-e", func(c *Command) *bool { return &c.Escapes }),
+				startLineFlag("capture-pane"),
+				boolFlag("--frame", func(c *Command) *bool { return &c.Frame }),
+				boolFlag("--no-pager", func(c *Command) *bool { return &c.NoPager }),
+				encodingFlag(),
+				boolFlag("--last-command", func(c *Command) *bool { return &c.LastCmd }),
+				{Name: "--command", Arg: "number", Set: func(cmd *Command, v string) error {
+					n, err := strconv.Atoi(v)
+					if err != nil || n == 0 {
+						return fmt.Errorf("invalid command number %q", v)
+					}
+					cmd.CmdSeq = n
+					return nil
+				}},
+				stripFlag(),
+				choiceFlag("--stream", []string{"stdout", "stderr", "tag"}, func(c *Command) *string { return &c.Stream }),
+			},
+			Check: func(cmd *Command) error {
+				if cmd.Stream != "" && (cmd.Frame || cmd.Escapes || cmd.LastCmd || cmd.CmdSeq != 0) {
+					return fmt.Errorf("--stream captures the history and cannot be used with --frame, -e, --last-command or --command")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "capture-all", Type: CmdCaptureAll,
+			Summary: "Capture every pane with cursor state (--all sessions, --format json)",
+			Flags: []Flag{
+				targetFlag(),
+				allFlag(),
+				choiceFlag("--format", []string{"text", "json"}, func(c *Command) *string { return &c.CaptureFormat }),
+				startLineFlag("capture-all"),
+				boolFlag("--frame", func(c *Command) *bool { return &c.Frame }),
+				boolFlag("--no-pager", func(c *Command) *bool { return &c.NoPager }),
+				stripFlag(),
+				encodingFlag(),
+			},
+			Init: func(cmd *Command) { cmd.CaptureFormat = "text" },
+			Check: func(cmd *Command) error {
+				if cmd.Encoding != "" && cmd.CaptureFormat == "json" {
+					return fmt.Errorf("--encoding applies to text output, not --format json")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "has-session", Type: CmdHasSession,
+			Summary: "Check if a session exists",
+			Flags:   []Flag{targetFlag()},
+		},
+		{
+			Name: "kill-session", Type: CmdKillSession,
+			Summary: "Kill a session",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("--no-wait", func(c *Command) *bool { return &c.NoWait }),
+				timeoutFlag(),
+			},
+		},
+		{
+			Name: "set-option", Type: CmdSetOption, Args: "option [value]",
+			Summary: "Set a session option (-w: a window option)",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-w", func(c *Command) *bool { return &c.WindowScope }),
+			},
+			Arg: func(cmd *Command, arg string) error {
+				switch {
+				case cmd.Option == "":
+					cmd.Option = arg
+				case cmd.Value == "":
+					cmd.Value = arg
+				default:
+					return fmt.Errorf("unexpected argument: %s", arg)
+				}
+				return nil
+			},
+			Dashes: true,
+		},
+		pipeCommand("pipe-pane", CmdPipePane, "Pipe pane output to a file or command (--clean for readable text)"),
+		pipeCommand("pipe-add", CmdPipeAdd, "Add another output sink: a file, command or --events (-n name)"),
+		listCommand("pipe-list", CmdPipeList, "List output sinks"),
+		removeCommand("pipe-remove", CmdPipeRemove, "Remove an output sink by name", "a pipe name", func(c *Command) *string { return &c.PipeName }),
+		{
+			Name: "mirror-pane", Type: CmdMirrorPane, Args: "socket-path",
+			Summary: "Show this session's output in another session's pane (--clean)",
+			Flags: []Flag{
+				targetFlag(),
+				stringFlag("-n", "name", func(c *Command) *string { return &c.PipeName }),
+				boolFlag("--clean", func(c *Command) *bool { return &c.PipeClean }),
+				boolFlag("--timestamps", func(c *Command) *bool { return &c.PipeTimestamps }),
+			},
+			Arg:   oneArg(func(c *Command) *string { return &c.MirrorTo }),
+			Check: requires("mirror-pane requires the socket path of the session to mirror into", func(c *Command) *string { return &c.MirrorTo }),
+		},
+		{
+			Name: "display-message", Aliases: []string{"display"}, Type: CmdDisplayMessage, Args: "[format]",
+			Summary: "Print a format string (#{cursor_x}, #{alternate_on}, ...)",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-p", func(c *Command) *bool { return &c.Print }),
+			},
+			Rest:   joinedArgs(func(c *Command) *string { return &c.Format }),
+			Dashes: true,
+		},
+		{
+			Name: "wait-stable", Type: CmdWaitStable,
+			Summary: "Wait until pane output has been quiet for --quiet-ms",
+			Flags: []Flag{
+				targetFlag(),
+				intFlag("--quiet-ms", "milliseconds", 1, math.MaxInt, func(c *Command) *int { return &c.QuietMs }),
+				timeoutFlag(),
+				progressFlag(),
+			},
+		},
+		{
+			Name: "wait-for-prompt", Type: CmdWaitPrompt,
+			Summary: "Wait until the pane's shell is back at its prompt (cmd, PowerShell, POSIX)",
+			Flags:   []Flag{targetFlag(), shellFlag(), timeoutFlag(), progressFlag()},
+		},
+		listCommand("list-clients", CmdListClients, "List clients that have talked to the session", "lsc"),
+		listCommand("list-processes", CmdListProcesses, "List the pane's process tree (PID, name, CPU)"),
+		{
+			Name: "show-environment", Aliases: []string{"showenv"}, Type: CmdShowEnvironment, Args: "[name]",
+			Summary: "Print the environment the pane process (or --pid) actually has",
+			Flags: []Flag{
+				targetFlag(),
+				intFlag("--pid", "pid", 1, math.MaxInt, func(c *Command) *int { return &c.EnvPID }),
+			},
+			Arg: oneArg(func(c *Command) *string { return &c.EnvName }),
+		},
+		listCommand("list-commands-history", CmdListCommands, "List shell commands seen through OSC 133 marks"),
+		listCommand("list-links", CmdListLinks, "List OSC 8 hyperlinks visible in the pane"),
+		listCommand("show-exits", CmdShowExits, "List past pane process exits with exit codes and run times"),
+		clientCommand("lock-client", CmdLockClient, true, "Lock input from a client (-t) or take exclusive input (-a)", "lockc"),
+		clientCommand("unlock-client", CmdUnlockClient, true, "Release a client lock (-t) or exclusive input (-a)"),
+		clientCommand("suspend-client", CmdSuspendClient, false, "Reject all requests from a client until unlocked", "suspendc"),
+		{
+			Name: "respawn-pane", Aliases: []string{"respawnp"}, Type: CmdRespawnPane, Args: "[shell-command]",
+			Summary: "Restart the pane process (-k, -c dir, -e KEY=VAL)",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-k", func(c *Command) *bool { return &c.Kill }),
+				stringFlag("-c", "start-directory", func(c *Command) *string { return &c.StartDir }),
+				envFlag(),
+			},
+			Rest:   joinedArgs(func(c *Command) *string { return &c.ShellCmd }),
+			Dashes: true,
+		},
+		{
+			Name: "select-pane", Aliases: []string{"selectp"}, Type: CmdSelectPane,
+			Summary: "Select the pane; -m marks it as {marked}, -M clears the mark",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-m", func(c *Command) *bool { return &c.Mark }),
+				boolFlag("-M", func(c *Command) *bool { return &c.Unmark }),
+			},
+			Check: func(cmd *Command) error {
+				if cmd.Mark && cmd.Unmark {
+					return fmt.Errorf("-m cannot be used with -M")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "watch-add", Type: CmdWatchAdd, Args: "regexp",
+			Summary: "Watch output for a regexp; fire --hook CMD and events on match",
+			Flags: []Flag{
+				targetFlag(),
+				stringFlag("-n", "name", func(c *Command) *string { return &c.WatchName }),
+				stringFlag("--hook", "command", func(c *Command) *string { return &c.Hook }),
+				boolFlag("--once", func(c *Command) *bool { return &c.Once }),
+			},
+			Rest:   joinedArgs(func(c *Command) *string { return &c.Pattern }),
+			Dashes: true,
+			Check:  requires("watch-add requires a pattern", func(c *Command) *string { return &c.Pattern }),
+		},
+		listCommand("watch-list", CmdWatchList, "List watches with hit counts"),
+		removeCommand("watch-remove", CmdWatchRemove, "Remove a watch by name", "a watch name", func(c *Command) *string { return &c.WatchName }),
+		{
+			Name: "wait-event", Type: CmdWaitEvent,
+			Summary: "Wait for an event (--type watch, --since SEQ, --timeout)",
+			Flags: []Flag{
+				targetFlag(),
+				formatFlag(),
+				stringFlag("--type", "type", func(c *Command) *string { return &c.EventType }),
+				{Name: "--since", Arg: "sequence", Set: func(cmd *Command, v string) error {
+					n, err := strconv.ParseInt(v, 10, 64)
+					if err != nil || n < 0 {
+						return fmt.Errorf("invalid --since value %q", v)
+					}
+					cmd.Since = n
+					return nil
+				}},
+				progressFlag(),
+				timeoutFlag(),
+			},
+			Init: func(cmd *Command) { cmd.Since = -1 },
+		},
+		{
+			Name: "schedule", Type: CmdSchedule, Args: "[--] command ...",
+			Summary: "Run a wintmux command later (--in D), repeatedly (--every D) or by --cron",
+			Flags: []Flag{
+				targetFlag(),
+				stringFlag("-n", "name", func(c *Command) *string { return &c.ScheduleName }),
+				durationFlag("--in", func(c *Command) *time.Duration { return &c.ScheduleIn }),
+				durationFlag("--every", func(c *Command) *time.Duration { return &c.ScheduleEvery }),
+				stringFlag("--cron", "schedule", func(c *Command) *string { return &c.ScheduleCron }),
+			},
+			Rest: func(cmd *Command, args []string) error {
+				cmd.ScheduleArgs = args
+				return nil
+			},
+			Check: checkSchedule,
+		},
+		listCommand("schedule-list", CmdScheduleList, "List scheduled commands with their next run"),
+		removeCommand("schedule-remove", CmdScheduleRemove, "Remove a scheduled command by name", "a name", func(c *Command) *string { return &c.ScheduleName }),
+		{
+			Name: "redact-add", Type: CmdRedactAdd, Args: "[--] regexp",
+			Summary: "Mask a regexp in captures, pipes, input history and events (--replace TEXT)",
+			Flags: []Flag{
+				targetFlag(),
+				stringFlag("-n", "name", func(c *Command) *string { return &c.RedactName }),
+				stringFlag("--replace", "text", func(c *Command) *string { return &c.Replace }),
+			},
+			Rest:   joinedArgs(func(c *Command) *string { return &c.Pattern }),
+			Dashes: true,
+			Check:  requires("redact-add requires a pattern", func(c *Command) *string { return &c.Pattern }),
+		},
+		listCommand("redact-list", CmdRedactList, "List redaction rules"),
+		removeCommand("redact-remove", CmdRedactRemove, "Remove a redaction rule by name", "a name", func(c *Command) *string { return &c.RedactName }),
+		{
+			Name: "checkpoint", Type: CmdCheckpoint, Args: "[name]",
+			Summary: "Snapshot pane state under a name (-l lists, -d deletes)",
+			Flags: []Flag{
+				targetFlag(),
+				{Name: "-l", Set: func(cmd *Command, _ string) error { cmd.CheckpointMode = "list"; return nil }},
+				{Name: "-d", Set: func(cmd *Command, _ string) error { cmd.CheckpointMode = "delete"; return nil }},
+			},
+			Arg: oneArg(func(c *Command) *string { return &c.CheckpointName }),
+			Check: func(cmd *Command) error {
+				if cmd.CheckpointName == "" && cmd.CheckpointMode != "list" {
+					return fmt.Errorf("a checkpoint name is required")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "diff-checkpoint", Type: CmdDiffCheckpoint, Args: "name",
+			Summary: "Show input, output and screen changes since a checkpoint",
+			Flags:   []Flag{targetFlag()},
+			Arg:     oneArg(func(c *Command) *string { return &c.CheckpointName }),
+			Check:   requires("a checkpoint name is required", func(c *Command) *string { return &c.CheckpointName }),
+		},
+		{
+			Name: "run-script", Type: CmdRunScript, Args: "file | -",
+			Summary: "Run a send/expect transcript file against the pane",
+			Flags: []Flag{
+				targetFlag(),
+				boolFlag("-v", func(c *Command) *bool { return &c.Verbose }),
+			},
+			Arg: func(cmd *Command, arg string) error {
+				if cmd.ScriptPath != "" {
+					return fmt.Errorf("run-script takes one script file")
+				}
+				cmd.ScriptPath = arg
+				return nil
+			},
+			Check: requires("run-script requires a script file", func(c *Command) *string { return &c.ScriptPath }),
+		},
+		{
+			Name: "show-input-history", Type: CmdShowInputHistory,
+			Summary: "List input recorded while record-input is on",
+			Flags:   []Flag{targetFlag(), inputStartFlag(), inputCountFlag(), formatFlag()},
+		},
+		{
+			Name: "replay-input", Type: CmdReplayInput,
+			Summary: "Re-send recorded input (-s start, -n count, --timing)",
+			Flags: []Flag{
+				targetFlag(), inputStartFlag(), inputCountFlag(),
+				boolFlag("--timing", func(c *Command) *bool { return &c.Timing }),
+				progressFlag(),
+				timeoutFlag(),
+			},
+		},
+		{
+			Name: "record-keys", Type: CmdRecordKeys, Args: "start|stop|list|delete [name]",
+			Summary: "Record a keyboard macro (start|stop|list|delete NAME)",
+			Flags:   []Flag{targetFlag()},
+			Arg: func(cmd *Command, arg string) error {
+				if cmd.MacroMode != "" {
+					return oneArg(func(c *Command) *string { return &c.MacroName })(cmd, arg)
+				}
+				switch arg {
+				case "start", "stop", "list", "delete":
+					cmd.MacroMode = arg
+					return nil
+				}
+				return fmt.Errorf("unknown record-keys mode: %s", arg)
+			},
+			Check: func(cmd *Command) error {
+				switch {
+				case cmd.MacroMode == "":
+					return fmt.Errorf("record-keys requires start, stop, list or delete")
+				case cmd.MacroName == "" && (cmd.MacroMode == "start" || cmd.MacroMode == "delete"):
+					return fmt.Errorf("a macro name is required")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "play-keys", Type: CmdPlayKeys, Args: "name",
+			Summary: "Play a recorded macro (-N count, --timing)",
+			Flags: []Flag{
+				targetFlag(),
+				intFlag("-N", "count", 1, math.MaxInt, func(c *Command) *int { return &c.PlayCount }),
+				boolFlag("--timing", func(c *Command) *bool { return &c.Timing }),
+				progressFlag(),
+				timeoutFlag(),
+			},
+			Arg:   oneArg(func(c *Command) *string { return &c.MacroName }),
+			Check: requires("a macro name is required", func(c *Command) *string { return &c.MacroName }),
+		},
+		{
+			Name: "server-access", Type: CmdServerAccess, Args: "[client]",
+			Summary: "Mark a client read-only (-r), deny (-d) or allow (-a/-w); -l lists",
+			Flags: []Flag{
+				// As in tmux, -r and -w may be combined with -a; the last
+				// mode flag wins.
+				{Name: "-a", Set: func(cmd *Command, _ string) error {
+					if cmd.AccessMode == "" {
+						cmd.AccessMode = "add"
+					}
+					return nil
+				}},
+				accessFlag("-d", "deny"),
+				accessFlag("-l", "list"),
+				accessFlag("-r", "read-only"),
+				accessFlag("-w", "write"),
+			},
+			Arg: func(cmd *Command, arg string) error {
+				cmd.Target = arg
+				return nil
+			},
+			Check: func(cmd *Command) error {
+				if cmd.AccessMode == "" {
+					return fmt.Errorf("server-access requires one of -a, -d, -l, -r, -w")
+				}
+				if cmd.AccessMode != "list" && cmd.Target == "" {
+					return fmt.Errorf("server-access requires a client name")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "list-sessions", Aliases: []string{"ls"}, Type: CmdListSessions,
+			Summary: "List the -S session, or every running session with --all (ls)",
+			Flags:   []Flag{allFlag(), formatFlag()},
+		},
+		{
+			Name: "metrics", Type: CmdMetrics,
+			Summary: "Print Prometheus metrics of running sessions (--listen ADDR serves them)",
+			Flags: []Flag{
+				allFlag(),
+				{Name: "--listen", Arg: "HOST:PORT", Set: func(cmd *Command, v string) error {
+					if _, _, err := net.SplitHostPort(v); err != nil {
+						return fmt.Errorf("invalid --listen address %q (expected HOST:PORT)", v)
+					}
+					cmd.MetricsListen = v
+					return nil
+				}},
+			},
+		},
+		{
+			Name: "bench", Type: CmdBench,
+			Summary: "Measure input, output and capture latency with echo probes (-n count)",
+			Flags: []Flag{
+				targetFlag(),
+				intFlag("-n", "count", 1, 10000, func(c *Command) *int { return &c.BenchCount }),
+				timeoutFlag(),
+			},
+			Init: func(cmd *Command) {
+				cmd.BenchCount = 20
+				cmd.Timeout = 5 * time.Second
+			},
+		},
+		{
+			Name: "stress", Type: CmdStress,
+			Summary: "Soak test: N sessions of generated output; report daemon memory, CPU, latency",
+			Flags: []Flag{
+				intFlag("-n", "sessions", 1, 1000, func(c *Command) *int { return &c.StressSessions }),
+				durationFlag("--duration", func(c *Command) *time.Duration { return &c.StressDuration }),
+				intFlag("--rate", "lines", 1, 100000, func(c *Command) *int { return &c.StressRate }),
+				durationFlag("--interval", func(c *Command) *time.Duration { return &c.StressInterval }),
+				{Name: "--generator", Hidden: true, Set: func(cmd *Command, _ string) error { cmd.Fixture = true; return nil }},
+			},
+			Init: func(cmd *Command) {
+				cmd.StressSessions = 10
+				cmd.StressDuration = time.Minute
+				cmd.StressRate = 100
+				cmd.StressInterval = 5 * time.Second
+			},
+		},
+		workspaceCommand("up", CmdUp, "Create the sessions of a workspace file (default wintmux.json)"),
+		workspaceCommand("down", CmdDown, "Kill the sessions of a workspace file"),
+		workspaceCommand("status", CmdStatus, "Show whether each session of a workspace file is running", formatFlag()),
+		{
+			Name: "broker", Type: CmdBroker,
+			Summary: "Serve many sessions over one connection (runs in foreground)",
+		},
+		{
+			Name: "attach", Aliases: []string{"attach-session"}, Type: CmdAttach,
+			Summary: "Attach this terminal to a session (detach: Ctrl-B d; --colors truecolor|256|16, --delta, --local-echo)",
+			Flags: []Flag{
+				targetFlag(),
+				{Name: "--colors", Arg: "truecolor|256|16", Set: func(cmd *Command, v string) error {
+					depth, err := vt.ParseColors(v)
+					cmd.Colors = string(depth)
+					return err
+				}},
+				boolFlag("--delta", func(c *Command) *bool { return &c.Delta }),
+				boolFlag("--local-echo", func(c *Command) *bool { return &c.LocalEcho }),
+			},
+		},
+		{
+			Name: "exec", Type: CmdExec, Args: "[--] command",
+			Summary: "Run a command to completion; print its output, exit with its status (--in-pane)",
+			Flags: []Flag{
+				targetFlag(),
+				stringFlag("-c", "start-directory", func(c *Command) *string { return &c.StartDir }),
+				envFlag(),
+				{Name: "--in-pane", Alias: "-p", Set: func(cmd *Command, _ string) error { cmd.InPane = true; return nil }},
+				shellFlag(),
+				timeoutFlag(),
+				progressFlag(),
+			},
+			Rest: joinedArgs(func(c *Command) *string { return &c.ShellCmd }),
+			Check: func(cmd *Command) error {
+				if cmd.ShellCmd == "" {
+					return fmt.Errorf("exec requires a command")
+				}
+				if cmd.Shell != "" && !cmd.InPane {
+					return fmt.Errorf("--shell applies only with --in-pane")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "pipe", Type: CmdBridge,
+			Summary: "Bridge stdin/stdout to the pane as raw bytes (no console needed)",
+			Flags:   []Flag{targetFlag()},
+		},
+		{
+			Name: "selftest", Type: CmdSelftest,
+			Summary: "Check that sessions work on this machine (--timeout, -v)",
+			Flags: []Flag{
+				timeoutFlag(),
+				boolFlag("-v", func(c *Command) *bool { return &c.Verbose }),
+				{Name: "--fixture", Hidden: true, Set: func(cmd *Command, _ string) error { cmd.Fixture = true; return nil }},
+			},
+		},
+		{
+			Name: "doctor", Type: CmdDoctor,
+			Summary: "Report the terminal backend and ConPTY features (-S: the session's)",
+		},
+		{
+			Name: "help", Type: CmdHelp, Args: "[command]",
+			Summary: "Show the usage, or a command's flags",
+			Arg: func(cmd *Command, arg string) error {
+				if _, ok := LookupCommand(arg); !ok {
+					return fmt.Errorf("unknown command: %s", arg)
+				}
+				return oneArg(func(c *Command) *string { return &c.HelpCommand })(cmd, arg)
+			},
+		},
+		{
+			Name: "completion", Type: CmdCompletion, Args: "bash|powershell",
+			Summary: "Print a shell completion script for wintmux's commands and flags",
+			Arg: func(cmd *Command, arg string) error {
+				if _, ok := completionScripts[arg]; !ok {
+					return fmt.Errorf("unknown shell %q (want bash or powershell)", arg)
+				}
+				return oneArg(func(c *Command) *string { return &c.CompletionShell })(cmd, arg)
+			},
+			Check: requires("completion requires bash or powershell", func(c *Command) *string { return &c.CompletionShell }),
+		},
+	}
+}
+
+func requireKeys(msg string) func(*Command) error {
+	return func(cmd *Command) error {
+		if len(cmd.Keys) == 0 {
+			return fmt.Errorf("%s", msg)
+		}
+		return nil
+	}
+}
+
+func inputStartFlag() Flag {
+	return intFlag("-s", "start", 0, math.MaxInt, func(c *Command) *int { return &c.InputStart })
+}
+
+func inputCountFlag() Flag {
+	return intFlag("-n", "count", 0, math.MaxInt, func(c *Command) *int { return &c.InputCount })
+}
+
+func accessFlag(name, mode string) Flag {
+	return Flag{Name: name, Set: func(cmd *Command, _ string) error {
+		cmd.AccessMode = mode
+		return nil
+	}}
+}
+
+// clientCommand is a client control command: -t target-client, and -a
+// for all other clients if allowAll.
+func clientCommand(name string, typ CommandType, allowAll bool, summary string, aliases ...string) *Spec {
+	s := &Spec{
+		Name: name, Aliases: aliases, Type: typ, Summary: summary,
+		Flags: []Flag{stringFlag("-t", "target-client", func(c *Command) *string { return &c.Target })},
+	}
+	if allowAll {
+		s.Flags = append(s.Flags, boolFlag("-a", func(c *Command) *bool { return &c.AllClients }))
+	} else {
+		s.Check = requires(name+" requires -t target-client", func(c *Command) *string { return &c.Target })
+	}
+	return s
+}
+
+// pipeCommand is pipe-pane or pipe-add, which take the same sink flags;
+// pipe-add also takes a name and --events, which pipe-pane rejects.
+func pipeCommand(name string, typ CommandType, summary string) *Spec {
+	add := typ == CmdPipeAdd
+	return &Spec{
+		Name: name, Type: typ, Summary: summary, Args: "[command]",
+		Flags: []Flag{
+			targetFlag(),
+			boolFlag("--clean", func(c *Command) *bool { return &c.PipeClean }),
+			boolFlag("--timestamps", func(c *Command) *bool { return &c.PipeTimestamps }),
+			{Name: "--rotate-size", Arg: "size", Set: func(cmd *Command, v string) error {
+				n, err := units.ParseSize(v)
+				if err != nil || n == 0 {
+					return fmt.Errorf("invalid --rotate-size value %q", v)
+				}
+				cmd.PipeRotateSize = int64(n)
+				return nil
+			}},
+			intFlag("--keep", "count", 1, math.MaxInt, func(c *Command) *int { return &c.PipeKeep }),
+			{Name: "-n", Arg: "name", Hidden: !add, Set: func(cmd *Command, v string) error {
+				if !add {
+					return fmt.Errorf("unknown %s flag: -n", name)
+				}
+				cmd.PipeName = v
+				return nil
+			}},
+			{Name: "--events", Hidden: !add, Set: func(cmd *Command, _ string) error {
+				if !add {
+					return fmt.Errorf("unknown %s flag: --events", name)
+				}
+				cmd.PipeEvents = true
+				return nil
+			}},
+		},
+		Rest:   joinedArgs(func(c *Command) *string { return &c.PipeCmd }),
+		Dashes: true,
+		Check:  checkPipe,
+	}
+}
+
+// workspaceCommand is up, down or status: an optional workspace file.
+func workspaceCommand(name string, typ CommandType, summary string, flags ...Flag) *Spec {
+	return &Spec{
+		Name: name, Type: typ, Summary: summary, Args: "[file]", Flags: flags,
+		Arg: oneArg(func(c *Command) *string { return &c.WorkspaceFile }),
+	}
+}
+
+func checkPipe(cmd *Command) error {
+	if cmd.PipeKeep > 0 && cmd.PipeRotateSize == 0 {
+		return fmt.Errorf("--keep requires --rotate-size")
+	}
+	if cmd.Type == CmdPipeAdd {
+		switch {
+		case cmd.PipeEvents && cmd.PipeCmd != "":
+			return fmt.Errorf("--events takes no command")
+		case !cmd.PipeEvents && cmd.PipeCmd == "":
+			return fmt.Errorf("pipe-add requires a command or --events")
+		case cmd.PipeEvents && cmd.PipeRotateSize > 0:
+			return fmt.Errorf("--rotate-size cannot be used with --events")
+		}
+	}
+	return nil
+}
+
+// checkNewSession checks new-session's flags together and fills in the
+// defaults the environment gives.
+func checkNewSession(cmd *Command) error {
+	if strings.HasPrefix(cmd.Backend, "serial:") && cmd.ShellCmd != "" {
+		return fmt.Errorf("a serial port pane runs no command")
+	}
+	if cmd.TemplateVars != nil && cmd.Template == "" {
+		return fmt.Errorf("--var requires --template")
+	}
+	if len(cmd.SecretEnv) > 0 && cmd.Backend != "" {
+		// The daemon refuses these too; a remote pane's environment is
+		// passed on its ssh or docker command line.
+		if spec, _ := pty.ParseSpec(cmd.Backend); spec.Remote() {
+			return fmt.Errorf("--secret-env cannot be used with a %s pane", spec.Scheme)
+		}
+	}
+	if cmd.StartupTimeout == 0 {
+		if v := os.Getenv("WINTMUX_STARTUP_TIMEOUT"); v != "" {
+			d, err := parseDuration(v)
+			if err != nil {
+				return fmt.Errorf("WINTMUX_STARTUP_TIMEOUT: %w", err)
+			}
+			cmd.StartupTimeout = d
+		}
+	}
+	if cmd.Bind == nil {
+		if v := os.Getenv("WINTMUX_BIND"); v != "" {
+			hosts, err := parseBind(v)
+			if err != nil {
+				return fmt.Errorf("WINTMUX_BIND: %w", err)
+			}
+			cmd.Bind = hosts
+		}
+	}
+	if cmd.Port == 0 {
+		if v := os.Getenv("WINTMUX_PORT"); v != "" {
+			port, err := parsePort(v)
+			if err != nil {
+				return fmt.Errorf("WINTMUX_PORT: %w", err)
+			}
+			cmd.Port = port
+		}
+	}
+	return nil
+}
+
+// checkSchedule checks when a scheduled command runs, and the command
+// itself, given as separate arguments or as one quoted string, so
+// mistakes show up now rather than when it runs.
+func checkSchedule(cmd *Command) error {
+	switch {
+	case cmd.ScheduleCron != "" && (cmd.ScheduleIn > 0 || cmd.ScheduleEvery > 0):
+		return fmt.Errorf("--cron cannot be combined with --in or --every")
+	case cmd.ScheduleCron == "" && cmd.ScheduleIn == 0 && cmd.ScheduleEvery == 0:
+		return fmt.Errorf("schedule requires --in, --every or --cron")
+	}
+	if len(cmd.ScheduleArgs) == 1 {
+		words, err := splitWords(cmd.ScheduleArgs[0])
+		if err != nil {
+			return err
+		}
+		cmd.ScheduleArgs = words
+	}
+	if len(cmd.ScheduleArgs) == 0 {
+		return fmt.Errorf("schedule requires a command")
+	}
+	scheduled, err := Parse(cmd.ScheduleArgs)
+	if err != nil {
+		return fmt.Errorf("scheduled command: %v", err)
+	}
+	if scheduled.Type == CmdAttach {
+		return fmt.Errorf("attach cannot be scheduled")
+	}
+	return nil
+}
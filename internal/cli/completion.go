@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+)
+
+// globalFlags are the flags before the command that completion offers.
+var globalFlags = []string{"-S", "--color", "-V"}
+
+// completionScripts writes a completion script for each shell from
+// Commands, so it offers exactly the commands and flags Parse takes.
+var completionScripts = map[string]func(b *strings.Builder){
+	"bash":       bashCompletion,
+	"powershell": powershellCompletion,
+}
+
+// Completion returns the completion script for shell, bash or powershell.
+func Completion(shell string) (string, error) {
+	write, ok := completionScripts[shell]
+	if !ok {
+		return "", fmt.Errorf("unknown shell %q (want bash or powershell)", shell)
+	}
+	var b strings.Builder
+	write(&b)
+	return b.String(), nil
+}
+
+// completionWords returns the command names and aliases, and for each
+// name the flags it takes, hidden ones left out.
+func completionWords() (names []string, flags map[string][]string) {
+	flags = make(map[string][]string)
+	for _, s := range Commands {
+		var fs []string
+		for _, f := range s.Flags {
+			if f.Hidden {
+				continue
+			}
+			fs = append(fs, f.Name)
+			if f.Alias != "" {
+				fs = append(fs, f.Alias)
+			}
+		}
+		for _, name := range append([]string{s.Name}, s.Aliases...) {
+			names = append(names, name)
+			flags[name] = fs
+		}
+	}
+	return names, flags
+}
+
+func bashCompletion(b *strings.Builder) {
+	names, flags := completionWords()
+	b.WriteString(`# wintmux completion for bash: source <(wintmux completion bash)
+_wintmux() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd= i words
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		-S | --color) ((i++)) ;;
+		-*) ;;
+		*)
+			cmd=${COMP_WORDS[i]}
+			break
+			;;
+		esac
+	done
+	case $cmd in
+`)
+	fmt.Fprintf(b, "\t'') words=%q ;;\n", strings.Join(append(names, globalFlags...), " "))
+	for _, name := range names {
+		fmt.Fprintf(b, "\t%s) words=%q ;;\n", name, strings.Join(flags[name], " "))
+	}
+	b.WriteString(`	esac
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _wintmux wintmux wintmux.exe
+`)
+}
+
+func powershellCompletion(b *strings.Builder) {
+	names, flags := completionWords()
+	b.WriteString(`# wintmux completion for PowerShell:
+#   wintmux completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName wintmux, wintmux.exe -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $flags = @{
+`)
+	for _, name := range names {
+		fmt.Fprintf(b, "        '%s' = @(%s)\n", name, psList(flags[name]))
+	}
+	fmt.Fprintf(b, "    }\n    $commands = @(%s)\n", psList(append(names, globalFlags...)))
+	b.WriteString(`    $words = @($commandAst.CommandElements | Select-Object -Skip 1 |
+        Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+    $cmd = $null
+    for ($i = 0; $i -lt $words.Count; $i++) {
+        if ($words[$i] -eq '-S' -or $words[$i] -eq '--color') { $i++; continue }
+        if ($words[$i].StartsWith('-')) { continue }
+        $cmd = $words[$i]
+        break
+    }
+    $candidates = if ($cmd) { $flags[$cmd] } else { $commands }
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`)
+}
+
+// psList writes words as the items of a PowerShell array.
+func psList(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = "'" + w + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
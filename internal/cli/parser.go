@@ -2,8 +2,10 @@ package cli
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // CommandType identifies which tmux subcommand was parsed.
@@ -19,6 +21,59 @@ const (
 	CmdPipePane
 	CmdAttach
 	CmdListSessions
+	CmdDisplayMessage
+	CmdWaitStable
+	CmdListClients
+	CmdLockClient
+	CmdUnlockClient
+	CmdSuspendClient
+	CmdServerAccess
+	CmdListProcesses
+	CmdRespawnPane
+	CmdShowInputHistory
+	CmdReplayInput
+	CmdRunScript
+	CmdCheckpoint
+	CmdDiffCheckpoint
+	CmdWatchAdd
+	CmdWatchList
+	CmdWatchRemove
+	CmdPipeAdd
+	CmdPipeList
+	CmdPipeRemove
+	CmdMirrorPane
+	CmdCaptureAll
+	CmdWaitEvent
+	CmdBroker
+	CmdSelftest
+	CmdDoctor
+	CmdBridge
+	CmdExec
+	CmdListCommands
+	CmdListLinks
+	CmdSendText
+	CmdRecordKeys
+	CmdPlayKeys
+	CmdSchedule
+	CmdScheduleList
+	CmdScheduleRemove
+	CmdUp
+	CmdDown
+	CmdStatus
+	CmdRedactAdd
+	CmdRedactList
+	CmdRedactRemove
+	CmdMetrics
+	CmdBench
+	CmdStress
+	CmdRunPS
+	CmdWaitPrompt
+	CmdShowEnvironment
+	CmdCloneSession
+	CmdShowExits
+	CmdHelp
+	CmdCompletion
+	CmdSelectPane
 )
 
 // Command holds all parsed arguments for a single wintmux invocation.
@@ -34,6 +89,36 @@ type Command struct {
 	StartDir    string
 	ShellCmd    string
 
+	// new-session startup probe: how long to wait for the daemon and the
+	// first poll interval (doubling up to 1s). Zero uses the defaults, or
+	// WINTMUX_STARTUP_TIMEOUT for the timeout.
+	StartupTimeout  time.Duration
+	StartupInterval time.Duration
+
+	// new-session: loopback addresses (--bind, default 127.0.0.1) and
+	// fixed port (--port, default any) for the daemon to listen on;
+	// WINTMUX_BIND and WINTMUX_PORT supply defaults
+	Bind []string
+	Port int
+
+	// new-session --backend: the pane's terminal as a pty.Spec in text
+	// form (SCHEME[:TARGET][?OPTIONS]); --container NAME, --ssh HOST and
+	// --serial PORT are short for docker:NAME, ssh:HOST and serial:PORT
+	Backend string
+
+	// new-session --template: template name or file, and NAME=VALUE
+	// variables (--var) substituted into it
+	Template     string
+	TemplateVars map[string]string
+
+	// new-session --secret-env: NAME=SOURCE:NAME entries naming secrets
+	// the daemon fetches into the pane's environment
+	SecretEnv []string
+
+	// new-session --ttl: how long the session may live before the daemon
+	// ends it; zero for no limit
+	TTL time.Duration
+
 	// send-keys flags
 	Target  string
 	Keys    []string
@@ -44,16 +129,178 @@ type Command struct {
 	JoinLines bool
 	Alternate bool
 	StartLine int
+	Frame     bool   // wintmux extension: wait for a frame boundary
+	Strip     string // wintmux extension: capture history with a strip profile
+	Stream    string // wintmux extension: capture history of stdout, stderr, or tagged
+	LastCmd   bool   // wintmux extension: output of the last shell command
+	CmdSeq    int    // wintmux extension: output of shell command N (-N: N-th last)
+	Escapes   bool   // -e: keep OSC 8 hyperlinks
+	NoPager   bool   // wintmux extension: print a capture taller than the terminal without paging
 
 	// set-option fields
-	Option string
-	Value  string
+	Option      string
+	Value       string
+	WindowScope bool // -w: a window option
+
+	// pipe-pane / pipe-add fields: command, whether to write cleaned text
+	// (--clean) instead of raw output, line timestamps (--timestamps), log
+	// rotation (--rotate-size bytes, --keep old files), and for pipe-add
+	// and pipe-remove the sink name (-n) and event sink (--events)
+	PipeCmd        string
+	PipeClean      bool
+	PipeTimestamps bool
+	PipeRotateSize int64
+	PipeKeep       int
+	PipeName       string
+	PipeEvents     bool
+
+	// mirror-pane: socket path of the session to show output in
+	MirrorTo string
+
+	// attach --colors: the terminal's color depth (truecolor, 256 or 16);
+	// empty to guess it from COLORTERM and TERM
+	Colors string
+
+	// attach --local-echo: show typing before the pane echoes it
+	LocalEcho bool
+
+	// attach --delta: be sent the screen rows that changed, not raw output
+	Delta bool
+
+	// capture-all output format: "text" or "json"
+	CaptureFormat string
+
+	// capture-pane / capture-all --encoding: "utf8", "base64" or "utf16"
+	// (UTF-16LE with a BOM); empty for utf8
+	Encoding string
+
+	// display-message / list-clients / list-processes format (-F)
+	Format string
+
+	// respawn-pane: kill an active process first (-k), -e overrides
+	// (also exec)
+	Kill bool
+	Env  []string
+
+	// select-pane: mark the pane (-m) or clear the mark (-M)
+	Mark   bool
+	Unmark bool
+
+	// exec: type the command into the pane's shell (--in-pane) instead
+	// of running it in a temporary pane, whose syntax is Shell (posix,
+	// cmd or powershell; default: guessed from the pane command);
+	// wait-for-prompt --shell uses Shell too
+	InPane bool
+	Shell  string
+
+	// lock-client / unlock-client: apply to all other clients (-a);
+	// list-sessions: list every registered daemon (-a / --all)
+	AllClients bool
+
+	// server-access mode: add, write, read-only, deny or list
+	AccessMode string
+
+	// wait-stable fields (Timeout is shared with replay-input and
+	// kill-session)
+	QuietMs int
+	Timeout time.Duration
+
+	// kill-session: return once the daemon has the request, without
+	// waiting for the session to be torn down (--no-wait)
+	NoWait bool
+
+	// wait-stable / wait-event / replay-input: print daemon status lines
+	// to stderr while waiting (--progress)
+	Progress bool
+
+	// show-input-history / replay-input: first event (-s), event count
+	// (-n) and whether to reproduce the recorded pauses (--timing)
+	InputStart int
+	InputCount int
+	Timing     bool
+
+	// checkpoint / diff-checkpoint: checkpoint name; CheckpointMode is
+	// "list" (-l) or "delete" (-d) instead of taking a checkpoint
+	CheckpointName string
+	CheckpointMode string
+
+	// record-keys / play-keys: macro name; MacroMode is start, stop, list
+	// or delete, and play-keys plays the macro PlayCount times (-N)
+	MacroName string
+	MacroMode string
+	PlayCount int
+
+	// watch-add / watch-remove: watch name (-n), regular expression,
+	// hook command and one-shot flag
+	WatchName string
+	Pattern   string
+	Hook      string
+	Once      bool
+
+	// schedule / schedule-remove: name (-n), wintmux command to run, and
+	// when: a delay (--in), a repeat interval (--every) or a cron schedule
+	ScheduleName  string
+	ScheduleArgs  []string
+	ScheduleIn    time.Duration
+	ScheduleEvery time.Duration
+	ScheduleCron  string
+
+	// redact-add / redact-remove: rule name (-n), regular expression
+	// (Pattern) and the text matches become (--replace)
+	RedactName string
+	Replace    string
+
+	// metrics --listen: address to serve Prometheus metrics on
+	MetricsListen string
+
+	// bench: number of probes to send (-n); Timeout bounds each
+	BenchCount int
+
+	// stress: sessions to create (-n), how long to run (--duration), lines
+	// per second each pane writes (--rate) and how often to sample
+	// (--interval)
+	StressSessions int
+	StressDuration time.Duration
+	StressRate     int
+	StressInterval time.Duration
+
+	// up / down / status: workspace file; empty for wintmux.json
+	WorkspaceFile string
+
+	// wait-event: event type filter and sequence number to start after
+	// (-1: only events from now on)
+	EventType string
+	Since     int64
+
+	// show-environment: the variable to show (empty for all) and the
+	// process in the pane to read (--pid; 0 for the pane process)
+	EnvName string
+	EnvPID  int
+
+	// clone-session: the new session's control file (--socket; empty
+	// for NAME.sock beside the source's) and whether to rerun the
+	// source's startup command (--replay) rather than start a shell
+	CloneSocket string
+	Replay      bool
 
-	// pipe-pane field
-	PipeCmd string
+	// run-script: transcript file ("-" for stdin) and step tracing (-v)
+	ScriptPath string
+	Verbose    bool
+
+	// help: the command to describe; empty for the usage.
+	// completion: the shell to write a completion script for
+	HelpCommand     string
+	CompletionShell string
+
+	// --color: whether wintmux's own output is colored: "auto" (or empty),
+	// "always" or "never"
+	Color string
 
 	// internal: daemon mode
 	DaemonMode bool
+	// internal: run the selftest fixture program (selftest --fixture) or
+	// the stress output generator (stress --generator)
+	Fixture bool
 }
 
 // Parse converts a tmux-style argument list into a Command struct.
@@ -82,7 +329,23 @@ func Parse(args []string) (*Command, error) {
 		case "-u":
 			// tmux -u enables UTF-8 mode; wintmux is always UTF-8 -- silently ignore.
 			i++
+		case "--color":
+			i++
+			if i >= len(args) {
+				return nil, fmt.Errorf("--color requires auto, always or never")
+			}
+			if err := setColorMode(cmd, args[i]); err != nil {
+				return nil, err
+			}
+			i++
 		default:
+			if mode, ok := strings.CutPrefix(args[i], "--color="); ok {
+				if err := setColorMode(cmd, mode); err != nil {
+					return nil, err
+				}
+				i++
+				continue
+			}
 			goto parseCommand
 		}
 	}
@@ -100,236 +363,109 @@ parseCommand:
 	i++
 	remaining := args[i:]
 
-	switch subcommand {
-	case "new-session":
-		return parseNewSession(cmd, remaining)
-	case "send-keys":
-		return parseSendKeys(cmd, remaining)
-	case "capture-pane":
-		return parseCapturePane(cmd, remaining)
-	case "has-session":
-		return parseHasSession(cmd, remaining)
-	case "kill-session":
-		return parseKillSession(cmd, remaining)
-	case "set-option":
-		return parseSetOption(cmd, remaining)
-	case "pipe-pane":
-		return parsePipePane(cmd, remaining)
-	case "attach", "attach-session":
-		return parseAttach(cmd, remaining)
-	case "list-sessions", "ls":
-		cmd.Type = CmdListSessions
-		return cmd, nil
-	default:
+	spec, ok := LookupCommand(subcommand)
+	if !ok {
 		return nil, fmt.Errorf("unknown command: %s", subcommand)
 	}
+	return spec.parse(cmd, remaining)
 }
 
-func parseNewSession(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdNewSession
-	i := 0
-	for i < len(args) {
-		switch args[i] {
-		case "-d":
-			cmd.Detached = true
-			i++
-		case "-s":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-s requires a session name")
-			}
-			cmd.SessionName = args[i]
-			i++
-		case "-n":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-n requires a window name")
-			}
-			cmd.WindowName = args[i]
-			i++
-		case "-c":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-c requires a directory")
-			}
-			cmd.StartDir = args[i]
-			i++
-		default:
-			cmd.ShellCmd = strings.Join(args[i:], " ")
-			i = len(args)
+// parseBind parses a comma-separated list of loopback IP addresses, such
+// as "::1" or "::1,127.0.0.1". The IPC channel is unauthenticated, so
+// other addresses are refused.
+func parseBind(s string) ([]string, error) {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		h = strings.Trim(strings.TrimSpace(h), "[]")
+		ip := net.ParseIP(h)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid bind address %q: expected an IP address such as 127.0.0.1 or ::1", h)
 		}
-	}
-	return cmd, nil
-}
-
-func parseSendKeys(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdSendKeys
-	i := 0
-	pastOptions := false
-
-	for i < len(args) {
-		if pastOptions {
-			cmd.Keys = append(cmd.Keys, args[i])
-			i++
-			continue
-		}
-		switch args[i] {
-		case "-t":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-t requires a target")
-			}
-			cmd.Target = args[i]
-			i++
-		case "-l":
-			cmd.Literal = true
-			i++
-		case "--":
-			pastOptions = true
-			i++
-		default:
-			cmd.Keys = append(cmd.Keys, args[i])
-			i++
+		if !ip.IsLoopback() {
+			return nil, fmt.Errorf("bind address %s is not a loopback address", h)
 		}
+		hosts = append(hosts, ip.String())
 	}
-	return cmd, nil
+	return hosts, nil
 }
 
-func parseCapturePane(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdCapturePane
-	i := 0
-	for i < len(args) {
-		switch args[i] {
-		case "-p":
-			cmd.Print = true
-			i++
-		case "-J":
-			cmd.JoinLines = true
-			i++
-		case "-a":
-			cmd.Alternate = true
-			i++
-		case "-t":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-t requires a target")
-			}
-			cmd.Target = args[i]
-			i++
-		case "-S":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("capture-pane -S requires a line number")
-			}
-			n, err := strconv.Atoi(args[i])
-			if err != nil {
-				return nil, fmt.Errorf("invalid start line %q: %w", args[i], err)
-			}
-			cmd.StartLine = n
-			i++
-		default:
-			return nil, fmt.Errorf("unknown capture-pane flag: %s", args[i])
-		}
+func parsePort(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
 	}
-	return cmd, nil
+	return n, nil
 }
 
-func parseHasSession(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdHasSession
-	for i := 0; i < len(args); {
-		switch args[i] {
-		case "-t":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-t requires a target")
-			}
-			cmd.Target = args[i]
-			i++
-		default:
-			return nil, fmt.Errorf("unknown has-session flag: %s", args[i])
-		}
+// setColorMode sets cmd.Color from a --color value.
+func setColorMode(cmd *Command, mode string) error {
+	switch mode {
+	case "auto", "always", "never":
+		cmd.Color = mode
+		return nil
 	}
-	return cmd, nil
+	return fmt.Errorf("invalid --color %q (want auto, always or never)", mode)
 }
 
-func parseKillSession(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdKillSession
-	for i := 0; i < len(args); {
-		switch args[i] {
-		case "-t":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-t requires a target")
-			}
-			cmd.Target = args[i]
-			i++
-		default:
-			return nil, fmt.Errorf("unknown kill-session flag: %s", args[i])
-		}
+// parseDuration accepts Go duration syntax ("30s", "1m30s") or a bare
+// integer number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return time.Duration(n) * time.Second, nil
 	}
-	return cmd, nil
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
 }
 
-func parseSetOption(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdSetOption
-	i := 0
-	for i < len(args) {
-		switch args[i] {
-		case "-t":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-t requires a target")
+// splitWords splits a command line into words at unquoted white space.
+// Single quotes keep text as it is; within double quotes and outside
+// quotes a backslash escapes the next character.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
 			}
-			cmd.Target = args[i]
-			i++
-		default:
-			if i+1 < len(args) {
-				cmd.Option = args[i]
-				cmd.Value = args[i+1]
-				i += 2
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
 			} else {
-				cmd.Option = args[i]
-				i++
+				word.WriteRune(r)
 			}
-		}
-	}
-	return cmd, nil
-}
-
-func parsePipePane(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdPipePane
-	i := 0
-	for i < len(args) {
-		switch args[i] {
-		case "-t":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-t requires a target")
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
 			}
-			cmd.Target = args[i]
-			i++
 		default:
-			cmd.PipeCmd = strings.Join(args[i:], " ")
-			i = len(args)
+			word.WriteRune(r)
+			inWord = true
 		}
 	}
-	return cmd, nil
-}
-
-func parseAttach(cmd *Command, args []string) (*Command, error) {
-	cmd.Type = CmdAttach
-	for i := 0; i < len(args); {
-		switch args[i] {
-		case "-t":
-			i++
-			if i >= len(args) {
-				return nil, fmt.Errorf("-t requires a target")
-			}
-			cmd.Target = args[i]
-			i++
-		default:
-			return nil, fmt.Errorf("unknown attach flag: %s", args[i])
-		}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
 	}
-	return cmd, nil
+	return words, nil
 }
@@ -3,6 +3,7 @@ package cli
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseNewSession(t *testing.T) {
@@ -51,6 +52,88 @@ func TestParseSendKeysLiteral(t *testing.T) {
 	}
 }
 
+func TestParseSendText(t *testing.T) {
+	cmd, err := Parse([]string{"-S", "/tmp/s.sock", "send-text", "-t", "sess", "--", "-你好", "世界"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdSendText || cmd.Target != "sess" || strings.Join(cmd.Keys, " ") != "-你好 世界" {
+		t.Errorf("got %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("send-text -t sess")); err == nil {
+		t.Error("expected error without text")
+	}
+}
+
+func TestParseRunPS(t *testing.T) {
+	cmd, err := Parse([]string{"-S", "/tmp/s.sock", "run-ps", "-t", "sess", "Get-Process", "-Name", "pwsh"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdRunPS || cmd.Target != "sess" || strings.Join(cmd.Keys, " ") != "Get-Process -Name pwsh" {
+		t.Errorf("got %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("run-ps -t sess")); err == nil {
+		t.Error("expected error without a command")
+	}
+}
+
+func TestParseWaitPrompt(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock wait-for-prompt --shell cmd --timeout 5s --progress"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdWaitPrompt || cmd.Shell != "cmd" || cmd.Timeout != 5*time.Second || !cmd.Progress {
+		t.Errorf("got %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("wait-for-prompt --shell fish")); err == nil {
+		t.Error("expected error for an unknown shell")
+	}
+}
+
+func TestParseShowEnvironment(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock showenv -t sess --pid 4242 API_URL"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdShowEnvironment || cmd.EnvPID != 4242 || cmd.EnvName != "API_URL" {
+		t.Errorf("got %+v", cmd)
+	}
+	for _, args := range []string{"show-environment --pid x", "show-environment A B", "show-environment -g"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseNewSessionTTL(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock new-session -d -s agent --ttl 2h -- claude"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.TTL != 2*time.Hour || cmd.ShellCmd != "claude" {
+		t.Errorf("got TTL %v, command %q", cmd.TTL, cmd.ShellCmd)
+	}
+	if _, err := Parse(strings.Fields("new-session --ttl soon")); err == nil {
+		t.Error("expected error for --ttl soon")
+	}
+}
+
+func TestParseCloneSession(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock clone-session -t sess -d --replay --socket /tmp/w2.sock worker-2"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdCloneSession || cmd.SessionName != "worker-2" || !cmd.Replay || !cmd.Detached || cmd.CloneSocket != "/tmp/w2.sock" {
+		t.Errorf("got %+v", cmd)
+	}
+	for _, args := range []string{"clone-session", "clone-session a b", "clone-session --socket", "clone-session -x a"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
 func TestParseSendKeysEnter(t *testing.T) {
 	args := strings.Fields("-S /tmp/s.sock send-keys -t sess:0.0 Enter")
 	cmd, err := Parse(args)
@@ -102,6 +185,44 @@ func TestParseCapturePaneAlternate(t *testing.T) {
 	}
 }
 
+func TestParseCapturePaneFrame(t *testing.T) {
+	args := strings.Fields("-S /tmp/s.sock capture-pane -p --frame -t sess:0.0")
+	cmd, err := Parse(args)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if !cmd.Frame {
+		t.Error("expected frame=true")
+	}
+}
+
+func TestParseCapturePaneStream(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock capture-pane -p --stream stderr --strip raw"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Stream != "stderr" || cmd.Strip != "raw" {
+		t.Errorf("stream = %q, strip = %q", cmd.Stream, cmd.Strip)
+	}
+	for _, args := range []string{"capture-pane --stream", "capture-pane --stream 2", "capture-pane --stream tag --frame", "capture-pane --stream stdout --last-command"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseCaptureNoPager(t *testing.T) {
+	for _, args := range []string{"capture-pane -p --no-pager", "capture-all --no-pager"} {
+		cmd, err := Parse(strings.Fields(args))
+		if err != nil {
+			t.Fatalf("%s: %v", args, err)
+		}
+		if !cmd.NoPager {
+			t.Errorf("%s: expected NoPager", args)
+		}
+	}
+}
+
 func TestParseHasSession(t *testing.T) {
 	args := strings.Fields("-S /tmp/s.sock has-session -t mysession")
 	cmd, err := Parse(args)
@@ -128,6 +249,14 @@ func TestParseKillSession(t *testing.T) {
 	if cmd.Target != "mysession" {
 		t.Errorf("expected target mysession, got %s", cmd.Target)
 	}
+
+	cmd, err = Parse(strings.Fields("kill-session --timeout 30s"))
+	if err != nil || cmd.Timeout != 30*time.Second || cmd.NoWait {
+		t.Errorf("--timeout: %+v, %v", cmd, err)
+	}
+	if cmd, err = Parse(strings.Fields("kill-session --no-wait")); err != nil || !cmd.NoWait {
+		t.Errorf("--no-wait: %+v, %v", cmd, err)
+	}
 }
 
 func TestParseSetOption(t *testing.T) {
@@ -148,6 +277,14 @@ func TestParseSetOption(t *testing.T) {
 	if cmd.Value != "50000" {
 		t.Errorf("expected value 50000, got %s", cmd.Value)
 	}
+	if cmd.WindowScope {
+		t.Error("window scope without -w")
+	}
+
+	cmd, err = Parse(strings.Fields("set-option -w monitor-activity on"))
+	if err != nil || !cmd.WindowScope || cmd.Option != "monitor-activity" || cmd.Value != "on" {
+		t.Errorf("set-option -w: %+v, %v", cmd, err)
+	}
 }
 
 func TestParsePipePane(t *testing.T) {
@@ -164,6 +301,135 @@ func TestParsePipePane(t *testing.T) {
 	}
 }
 
+func TestParsePipePaneClean(t *testing.T) {
+	cmd, err := Parse([]string{"pipe-pane", "--clean", "--timestamps", "-t", "sess", "cat >> /tmp/log"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if !cmd.PipeClean || !cmd.PipeTimestamps || cmd.PipeCmd != "cat >> /tmp/log" {
+		t.Errorf("unexpected clean=%v timestamps=%v cmd=%q", cmd.PipeClean, cmd.PipeTimestamps, cmd.PipeCmd)
+	}
+}
+
+func TestParsePipePaneRotate(t *testing.T) {
+	cmd, err := Parse(strings.Fields("pipe-pane --rotate-size 50MB --keep 3 cat >> /tmp/log"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.PipeRotateSize != 50<<20 || cmd.PipeKeep != 3 {
+		t.Errorf("unexpected rotate-size=%d keep=%d", cmd.PipeRotateSize, cmd.PipeKeep)
+	}
+	for _, args := range []string{
+		"pipe-pane --rotate-size big cat >> /tmp/log",
+		"pipe-pane --rotate-size 0 cat >> /tmp/log",
+		"pipe-pane --rotate-size 1MB --keep 0 cat >> /tmp/log",
+		"pipe-pane --keep 2 cat >> /tmp/log",
+	} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestParsePipeAdd(t *testing.T) {
+	cmd, err := Parse(strings.Fields("pipe-add -t sess -n errs --clean grep ERROR"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdPipeAdd || cmd.PipeName != "errs" || !cmd.PipeClean || cmd.PipeCmd != "grep ERROR" {
+		t.Errorf("unexpected type=%d name=%q clean=%v cmd=%q", cmd.Type, cmd.PipeName, cmd.PipeClean, cmd.PipeCmd)
+	}
+	cmd, err = Parse(strings.Fields("pipe-add --events"))
+	if err != nil || !cmd.PipeEvents {
+		t.Errorf("--events: %v %+v", err, cmd)
+	}
+	cmd, err = Parse(strings.Fields("pipe-remove -t sess errs"))
+	if err != nil || cmd.Type != CmdPipeRemove || cmd.PipeName != "errs" {
+		t.Errorf("pipe-remove: %v %+v", err, cmd)
+	}
+	for _, args := range []string{
+		"pipe-add",
+		"pipe-add --events cat >> /tmp/log",
+		"pipe-add --events --rotate-size 1MB",
+		"pipe-pane -n name cat >> /tmp/log",
+		"pipe-pane --events",
+		"pipe-remove",
+	} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestParseMirrorPane(t *testing.T) {
+	cmd, err := Parse(strings.Fields("mirror-pane -t agent1 -n view --clean /tmp/monitor.sock"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdMirrorPane || cmd.MirrorTo != "/tmp/monitor.sock" || cmd.PipeName != "view" || !cmd.PipeClean {
+		t.Errorf("unexpected type=%d to=%q name=%q clean=%v", cmd.Type, cmd.MirrorTo, cmd.PipeName, cmd.PipeClean)
+	}
+	for _, args := range []string{"mirror-pane", "mirror-pane a b", "mirror-pane --events a"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestParseCaptureAll(t *testing.T) {
+	cmd, err := Parse(strings.Fields("capture-all -t sess --format json -S -100 --strip text"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdCaptureAll || cmd.CaptureFormat != "json" || cmd.StartLine != -100 || cmd.Strip != "text" {
+		t.Errorf("unexpected type=%d format=%q start=%d strip=%q", cmd.Type, cmd.CaptureFormat, cmd.StartLine, cmd.Strip)
+	}
+	if cmd, _ := Parse([]string{"capture-all", "--all"}); cmd == nil || !cmd.AllClients || cmd.CaptureFormat != "text" {
+		t.Errorf("--all: %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("capture-all --format yaml")); err == nil {
+		t.Error("expected error for --format yaml")
+	}
+	if cmd, err := Parse(strings.Fields("capture-all --encoding utf16")); err != nil || cmd.Encoding != "utf16" {
+		t.Errorf("--encoding utf16: %+v, %v", cmd, err)
+	}
+	if _, err := Parse(strings.Fields("capture-all --format json --encoding base64")); err == nil {
+		t.Error("expected error for --encoding with --format json")
+	}
+}
+
+func TestParseCaptureEncoding(t *testing.T) {
+	cmd, err := Parse(strings.Fields("capture-pane -p -t s --encoding base64"))
+	if err != nil || cmd.Encoding != "base64" || !cmd.Print {
+		t.Errorf("--encoding base64: %+v, %v", cmd, err)
+	}
+	if _, err := Parse(strings.Fields("capture-pane -p --encoding latin1")); err == nil {
+		t.Error("expected error for --encoding latin1")
+	}
+	if _, err := Parse(strings.Fields("capture-pane -p --encoding")); err == nil {
+		t.Error("expected error for --encoding without a value")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for args, want := range map[string]string{
+		"ls":                     "",
+		"--color never ls":       "never",
+		"--color=always -S s ls": "always",
+		"-S s --color=auto ls":   "auto",
+	} {
+		cmd, err := Parse(strings.Fields(args))
+		if err != nil || cmd.Color != want || cmd.Type != CmdListSessions {
+			t.Errorf("%s: %+v, %v", args, cmd, err)
+		}
+	}
+	for _, args := range []string{"--color sometimes ls", "--color=", "--color"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("%s: expected an error", args)
+		}
+	}
+}
+
 func TestParseAttach(t *testing.T) {
 	args := strings.Fields("-S /tmp/s.sock attach -t mysession")
 	cmd, err := Parse(args)
@@ -176,6 +442,54 @@ func TestParseAttach(t *testing.T) {
 	if cmd.Target != "mysession" {
 		t.Errorf("expected target mysession, got %s", cmd.Target)
 	}
+	if cmd.Colors != "" {
+		t.Errorf("expected no color depth, got %q", cmd.Colors)
+	}
+
+	cmd, err = Parse(strings.Fields("attach -t mysession --colors 24bit"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Colors != "truecolor" {
+		t.Errorf("expected truecolor, got %q", cmd.Colors)
+	}
+	if _, err := Parse(strings.Fields("attach --colors 88")); err == nil {
+		t.Error("expected error for unsupported color depth")
+	}
+	if cmd, err := Parse(strings.Fields("attach --local-echo -t mysession")); err != nil || !cmd.LocalEcho || cmd.Target != "mysession" {
+		t.Errorf("--local-echo: %+v, %v", cmd, err)
+	}
+	if cmd, err := Parse(strings.Fields("attach --delta")); err != nil || !cmd.Delta || cmd.LocalEcho {
+		t.Errorf("--delta: %+v, %v", cmd, err)
+	}
+}
+
+func TestParseBridge(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock pipe -t mysession"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdBridge || cmd.Target != "mysession" {
+		t.Errorf("got type %d target %q", cmd.Type, cmd.Target)
+	}
+	if _, err := Parse(strings.Fields("pipe -x")); err == nil {
+		t.Error("expected error for unknown flag")
+	}
+}
+
+func TestParseExec(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock exec -t build --in-pane --shell cmd --timeout 2m -e CI=1 -- dir /b -a"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdExec || !cmd.InPane || cmd.Shell != "cmd" || cmd.Timeout != 2*time.Minute || len(cmd.Env) != 1 || cmd.ShellCmd != "dir /b -a" {
+		t.Errorf("got %+v", cmd)
+	}
+	for _, bad := range []string{"exec", "exec --", "exec --shell fish -p ls", "exec --shell cmd dir", "exec -x ls"} {
+		if _, err := Parse(strings.Fields(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
 }
 
 func TestParseListSessions(t *testing.T) {
@@ -200,6 +514,67 @@ func TestParseLsAlias(t *testing.T) {
 	}
 }
 
+func TestParseListSessionsAll(t *testing.T) {
+	cmd, err := Parse(strings.Fields("ls --all -F #{session_name}"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdListSessions || !cmd.AllClients || cmd.Format != "#{session_name}" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+}
+
+func TestParseMetrics(t *testing.T) {
+	cmd, err := Parse(strings.Fields("metrics --all --listen 127.0.0.1:9464"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdMetrics || !cmd.AllClients || cmd.MetricsListen != "127.0.0.1:9464" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	for _, args := range []string{"metrics --listen", "metrics --listen 9464", "metrics -F x"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseBench(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock bench -t agent -n 50 --timeout 2s"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdBench || cmd.Target != "agent" || cmd.BenchCount != 50 || cmd.Timeout != 2*time.Second {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if cmd, _ := Parse([]string{"bench"}); cmd.BenchCount != 20 || cmd.Timeout != 5*time.Second {
+		t.Errorf("defaults: %+v", cmd)
+	}
+	for _, args := range []string{"bench -n", "bench -n 0", "bench -n x", "bench --timeout", "bench -p"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseStress(t *testing.T) {
+	cmd, err := Parse(strings.Fields("stress -n 50 --duration 10m --rate 1000 --interval 30s"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdStress || cmd.StressSessions != 50 || cmd.StressDuration != 10*time.Minute || cmd.StressRate != 1000 || cmd.StressInterval != 30*time.Second {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if cmd, _ := Parse([]string{"stress"}); cmd.StressSessions != 10 || cmd.StressDuration != time.Minute || cmd.StressRate != 100 {
+		t.Errorf("defaults: %+v", cmd)
+	}
+	for _, args := range []string{"stress -n", "stress -n 0", "stress -n 5000", "stress --rate x", "stress --duration", "stress -t x"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
 func TestParseNoCommand(t *testing.T) {
 	_, err := Parse([]string{})
 	if err == nil {
@@ -405,3 +780,720 @@ func TestParseCAMPipePane(t *testing.T) {
 		t.Errorf("expected %q, got %q", expected, cmd.PipeCmd)
 	}
 }
+
+func TestParseDisplayMessage(t *testing.T) {
+	args := []string{"-S", "/tmp/s.sock", "display-message", "-p", "-t", "sess:0.0", "#{cursor_x},#{cursor_y}"}
+	cmd, err := Parse(args)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdDisplayMessage {
+		t.Errorf("expected CmdDisplayMessage, got %d", cmd.Type)
+	}
+	if !cmd.Print {
+		t.Error("expected print=true")
+	}
+	if cmd.Target != "sess:0.0" {
+		t.Errorf("expected target sess:0.0, got %s", cmd.Target)
+	}
+	if cmd.Format != "#{cursor_x},#{cursor_y}" {
+		t.Errorf("unexpected format %q", cmd.Format)
+	}
+}
+
+func TestParseDisplayAlias(t *testing.T) {
+	cmd, err := Parse([]string{"display", "-p", "#{alternate_on}"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdDisplayMessage || cmd.Format != "#{alternate_on}" {
+		t.Errorf("unexpected parse: type=%d format=%q", cmd.Type, cmd.Format)
+	}
+}
+
+func TestParseWaitStable(t *testing.T) {
+	args := strings.Fields("-S /tmp/s.sock wait-stable -t sess --quiet-ms 500 --timeout 30s")
+	cmd, err := Parse(args)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdWaitStable {
+		t.Errorf("expected CmdWaitStable, got %d", cmd.Type)
+	}
+	if cmd.QuietMs != 500 {
+		t.Errorf("expected quiet 500ms, got %d", cmd.QuietMs)
+	}
+	if cmd.Timeout != 30*time.Second {
+		t.Errorf("expected timeout 30s, got %v", cmd.Timeout)
+	}
+}
+
+func TestParseWaitStableBareSeconds(t *testing.T) {
+	cmd, err := Parse(strings.Fields("wait-stable --timeout 5"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Timeout != 5*time.Second {
+		t.Errorf("expected timeout 5s, got %v", cmd.Timeout)
+	}
+}
+
+func TestParseWaitStableInvalid(t *testing.T) {
+	if _, err := Parse(strings.Fields("wait-stable --quiet-ms soon")); err == nil {
+		t.Error("expected error for non-numeric --quiet-ms")
+	}
+	if _, err := Parse(strings.Fields("wait-stable --timeout -3s")); err == nil {
+		t.Error("expected error for negative timeout")
+	}
+}
+
+func TestParseListClients(t *testing.T) {
+	args := []string{"-S", "/tmp/s.sock", "list-clients", "-t", "sess", "-F", "#{client_name} #{client_activity}"}
+	cmd, err := Parse(args)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdListClients {
+		t.Errorf("expected CmdListClients, got %d", cmd.Type)
+	}
+	if cmd.Format != "#{client_name} #{client_activity}" {
+		t.Errorf("unexpected format %q", cmd.Format)
+	}
+}
+
+func TestParseLscAlias(t *testing.T) {
+	cmd, err := Parse([]string{"lsc"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdListClients {
+		t.Errorf("expected CmdListClients, got %d", cmd.Type)
+	}
+}
+
+func TestParseLockClient(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock lock-client -t pid:42"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdLockClient || cmd.Target != "pid:42" || cmd.AllClients {
+		t.Errorf("unexpected parse: %+v", cmd)
+	}
+
+	cmd, err = Parse(strings.Fields("-S /tmp/s.sock lock-client -a"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if !cmd.AllClients {
+		t.Error("expected -a to set AllClients")
+	}
+}
+
+func TestParseSuspendClientRequiresTarget(t *testing.T) {
+	if _, err := Parse(strings.Fields("suspend-client")); err == nil {
+		t.Error("expected error without -t")
+	}
+	if _, err := Parse(strings.Fields("suspend-client -a -t x")); err == nil {
+		t.Error("expected -a to be rejected for suspend-client")
+	}
+	cmd, err := Parse(strings.Fields("suspend-client -t bot"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdSuspendClient || cmd.Target != "bot" {
+		t.Errorf("unexpected parse: %+v", cmd)
+	}
+}
+
+func TestParseServerAccess(t *testing.T) {
+	tests := []struct {
+		args string
+		mode string
+	}{
+		{"server-access -a bot", "add"},
+		{"server-access -a -r bot", "read-only"},
+		{"server-access -r -a bot", "read-only"},
+		{"server-access -d bot", "deny"},
+		{"server-access -w bot", "write"},
+	}
+	for _, tt := range tests {
+		cmd, err := Parse(strings.Fields(tt.args))
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.args, err)
+		}
+		if cmd.Type != CmdServerAccess || cmd.AccessMode != tt.mode || cmd.Target != "bot" {
+			t.Errorf("Parse(%q): type=%d mode=%q target=%q", tt.args, cmd.Type, cmd.AccessMode, cmd.Target)
+		}
+	}
+
+	cmd, err := Parse(strings.Fields("server-access -l"))
+	if err != nil || cmd.AccessMode != "list" {
+		t.Errorf("server-access -l: mode=%v err=%v", cmd, err)
+	}
+	if _, err := Parse(strings.Fields("server-access -d")); err == nil {
+		t.Error("expected error for -d without a client")
+	}
+	if _, err := Parse(strings.Fields("server-access bot")); err == nil {
+		t.Error("expected error without a mode flag")
+	}
+}
+
+func TestParseListProcesses(t *testing.T) {
+	cmd, err := Parse([]string{"-S", "/tmp/s.sock", "list-processes", "-t", "sess:0.0", "-F", "#{process_pid}"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdListProcesses {
+		t.Errorf("expected CmdListProcesses, got %d", cmd.Type)
+	}
+	if cmd.Target != "sess:0.0" || cmd.Format != "#{process_pid}" {
+		t.Errorf("unexpected parse: target=%q format=%q", cmd.Target, cmd.Format)
+	}
+}
+
+func TestParseRespawnPane(t *testing.T) {
+	args := []string{"-S", "/tmp/s.sock", "respawn-pane", "-k", "-t", "sess:0.0",
+		"-e", "API_KEY=new", "-e", "MODE=ci", "-c", "/work", "claude", "--resume"}
+	cmd, err := Parse(args)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdRespawnPane {
+		t.Errorf("expected CmdRespawnPane, got %d", cmd.Type)
+	}
+	if !cmd.Kill {
+		t.Error("expected kill=true")
+	}
+	if len(cmd.Env) != 2 || cmd.Env[0] != "API_KEY=new" || cmd.Env[1] != "MODE=ci" {
+		t.Errorf("unexpected env %v", cmd.Env)
+	}
+	if cmd.StartDir != "/work" {
+		t.Errorf("unexpected dir %q", cmd.StartDir)
+	}
+	if cmd.ShellCmd != "claude --resume" {
+		t.Errorf("unexpected command %q", cmd.ShellCmd)
+	}
+}
+
+func TestParseRespawnPaneBadEnv(t *testing.T) {
+	if _, err := Parse(strings.Fields("respawn-pane -e NOVALUE")); err == nil {
+		t.Error("expected error for -e without '='")
+	}
+}
+
+func TestParseSelectPane(t *testing.T) {
+	cmd, err := Parse(strings.Fields("selectp -t sess:0.0 -m"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdSelectPane || !cmd.Mark || cmd.Unmark || cmd.Target != "sess:0.0" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("select-pane -m -M")); err == nil {
+		t.Error("expected error for -m with -M")
+	}
+}
+
+func TestParseShowInputHistory(t *testing.T) {
+	cmd, err := Parse(strings.Fields("show-input-history -t sess:0.0 -s 5 -n 10 -F #{input_data}"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdShowInputHistory {
+		t.Errorf("expected CmdShowInputHistory, got %d", cmd.Type)
+	}
+	if cmd.InputStart != 5 || cmd.InputCount != 10 {
+		t.Errorf("unexpected range %d+%d", cmd.InputStart, cmd.InputCount)
+	}
+	if cmd.Format != "#{input_data}" {
+		t.Errorf("unexpected format %q", cmd.Format)
+	}
+}
+
+func TestParseReplayInput(t *testing.T) {
+	cmd, err := Parse(strings.Fields("replay-input -t sess -n 3 --timing --timeout 2m"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdReplayInput {
+		t.Errorf("expected CmdReplayInput, got %d", cmd.Type)
+	}
+	if !cmd.Timing || cmd.InputCount != 3 || cmd.Timeout != 2*time.Minute {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("replay-input -F x")); err == nil {
+		t.Error("expected error for -F on replay-input")
+	}
+}
+
+func TestParseMacros(t *testing.T) {
+	cmd, err := Parse(strings.Fields("record-keys start -t sess greet"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdRecordKeys || cmd.MacroMode != "start" || cmd.MacroName != "greet" || cmd.Target != "sess" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	cmd, err = Parse(strings.Fields("play-keys -t sess -N 5 --timing greet"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdPlayKeys || cmd.PlayCount != 5 || !cmd.Timing || cmd.MacroName != "greet" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	for _, args := range []string{"record-keys", "record-keys begin x", "record-keys start", "record-keys delete", "play-keys", "play-keys -N 0 greet"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseRunScript(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/s.sock run-script -t sess -v login.wts"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdRunScript || cmd.ScriptPath != "login.wts" || !cmd.Verbose {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("run-script -t sess")); err == nil {
+		t.Error("expected error without a script file")
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	cmd, err := Parse(strings.Fields("checkpoint -t sess:0.0 step3"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdCheckpoint || cmd.CheckpointName != "step3" || cmd.CheckpointMode != "" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	cmd, err = Parse(strings.Fields("checkpoint -l"))
+	if err != nil || cmd.CheckpointMode != "list" {
+		t.Errorf("checkpoint -l: %+v, %v", cmd, err)
+	}
+	cmd, err = Parse(strings.Fields("diff-checkpoint -t sess step3"))
+	if err != nil || cmd.Type != CmdDiffCheckpoint || cmd.CheckpointName != "step3" {
+		t.Errorf("diff-checkpoint: %+v, %v", cmd, err)
+	}
+	for _, bad := range []string{"checkpoint", "diff-checkpoint -l", "checkpoint a b"} {
+		if _, err := Parse(strings.Fields(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestParseWatchAdd(t *testing.T) {
+	args := []string{"watch-add", "-t", "sess", "-n", "errors", "--hook", "notify.cmd", "--once", "ERROR|panic"}
+	cmd, err := Parse(args)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdWatchAdd {
+		t.Errorf("expected CmdWatchAdd, got %d", cmd.Type)
+	}
+	if cmd.WatchName != "errors" || cmd.Hook != "notify.cmd" || !cmd.Once || cmd.Pattern != "ERROR|panic" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("watch-add -n x")); err == nil {
+		t.Error("expected error without a pattern")
+	}
+}
+
+func TestParseNewSessionTemplate(t *testing.T) {
+	cmd, err := Parse(strings.Fields("new-session -d -s a7 --template agent --var agent=a7 --var repo=api=v2"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Template != "agent" || cmd.TemplateVars["agent"] != "a7" || cmd.TemplateVars["repo"] != "api=v2" || cmd.ShellCmd != "" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	for _, args := range []string{"new-session --var a=b", "new-session --template t --var =b", "new-session --template t --var a"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseNewSessionSecretEnv(t *testing.T) {
+	cmd, err := Parse(strings.Fields("new-session -d -s a1 --secret-env API_KEY=cred:agents/api --secret-env TOKEN=file:/run/token"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if strings.Join(cmd.SecretEnv, " ") != "API_KEY=cred:agents/api TOKEN=file:/run/token" {
+		t.Errorf("secret env = %q", cmd.SecretEnv)
+	}
+	for _, args := range []string{
+		"new-session --secret-env",
+		"new-session --secret-env API_KEY=sk-123",
+		"new-session --secret-env =cred:x",
+		"new-session --ssh host --secret-env API_KEY=cred:x",
+	} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseWorkspace(t *testing.T) {
+	cmd, err := Parse(strings.Fields("status -F #{session_name} matrix.json"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdStatus || cmd.WorkspaceFile != "matrix.json" || cmd.Format != "#{session_name}" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	cmd, err = Parse([]string{"down"})
+	if err != nil || cmd.Type != CmdDown || cmd.WorkspaceFile != "" {
+		t.Errorf("down: %+v %v", cmd, err)
+	}
+	for _, args := range []string{"up a.json b.json", "up -F x", "down --force"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	cmd, err := Parse([]string{"schedule", "-t", "sess", "-n", "nudge", "--in", "10m", "--every", "1h", `send-keys -t sess "continue please" Enter`})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdSchedule || cmd.ScheduleName != "nudge" || cmd.ScheduleIn != 10*time.Minute || cmd.ScheduleEvery != time.Hour {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if got := strings.Join(cmd.ScheduleArgs, "|"); got != "send-keys|-t|sess|continue please|Enter" {
+		t.Errorf("args = %q", got)
+	}
+	cmd, err = Parse([]string{"schedule", "--cron", "*/5 * * * *", "--", "send-keys", "-l", "it's"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.ScheduleCron != "*/5 * * * *" || strings.Join(cmd.ScheduleArgs, "|") != "send-keys|-l|it's" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	for _, args := range [][]string{
+		{"schedule", "send-keys", "x"},
+		{"schedule", "--in", "1m"},
+		{"schedule", "--in", "1m", "--cron", "@daily", "ping"},
+		{"schedule", "--in", "1m", "bogus-command"},
+		{"schedule", "--in", "1m", "attach"},
+		{"schedule", "--in", "1m", `send-keys "unterminated`},
+		{"schedule-remove"},
+	} {
+		if _, err := Parse(args); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestParseRedact(t *testing.T) {
+	cmd, err := Parse([]string{"redact-add", "-t", "sess", "-n", "keys", "--replace", "<key>", "sk-[A-Za-z0-9]+"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdRedactAdd || cmd.RedactName != "keys" || cmd.Replace != "<key>" || cmd.Pattern != "sk-[A-Za-z0-9]+" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	cmd, err = Parse([]string{"redact-add", "--", "-----BEGIN"})
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Pattern != "-----BEGIN" {
+		t.Errorf("pattern = %q", cmd.Pattern)
+	}
+	cmd, err = Parse([]string{"redact-remove", "keys"})
+	if err != nil || cmd.Type != CmdRedactRemove || cmd.RedactName != "keys" {
+		t.Errorf("redact-remove = %+v, %v", cmd, err)
+	}
+	for _, args := range [][]string{
+		{"redact-add"},
+		{"redact-add", "--replace"},
+		{"redact-remove"},
+	} {
+		if _, err := Parse(args); err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	words, err := splitWords(`send-keys  'a "b"' "c \"d\"" e\ f ''`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(words, "|"); got != `send-keys|a "b"|c "d"|e f|` {
+		t.Errorf("words = %q", got)
+	}
+}
+
+func TestParseWaitEvent(t *testing.T) {
+	cmd, err := Parse(strings.Fields("wait-event --type watch --timeout 5"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdWaitEvent || cmd.EventType != "watch" || cmd.Since != -1 || cmd.Timeout != 5*time.Second {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	cmd, err = Parse(strings.Fields("wait-event --since 42"))
+	if err != nil || cmd.Since != 42 {
+		t.Errorf("--since: %+v, %v", cmd, err)
+	}
+}
+
+func TestParseCapturePaneStrip(t *testing.T) {
+	cmd, err := Parse(strings.Fields("capture-pane -p -t sess -S -100 --strip sgr"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Strip != "sgr" || cmd.StartLine != -100 {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+	if _, err := Parse(strings.Fields("capture-pane --strip fancy")); err == nil {
+		t.Error("expected error for unknown profile")
+	}
+	if cmd, err := Parse(strings.Fields("capture-pane -p --last-command")); err != nil || !cmd.LastCmd {
+		t.Errorf("--last-command: %+v, %v", cmd, err)
+	}
+	if cmd, err := Parse(strings.Fields("capture-pane -p --command -2")); err != nil || cmd.CmdSeq != -2 {
+		t.Errorf("--command: %+v, %v", cmd, err)
+	}
+	if _, err := Parse(strings.Fields("capture-pane --command 0")); err == nil {
+		t.Error("expected error for --command 0")
+	}
+	if cmd, err := Parse(strings.Fields("list-commands-history -t s -F #{command_seq}")); err != nil || cmd.Type != CmdListCommands || cmd.Format != "#{command_seq}" {
+		t.Errorf("list-commands-history: %+v, %v", cmd, err)
+	}
+	if cmd, err := Parse(strings.Fields("capture-pane -p -e -t s")); err != nil || !cmd.Escapes {
+		t.Errorf("-e: %+v, %v", cmd, err)
+	}
+	if cmd, err := Parse(strings.Fields("list-links -t s")); err != nil || cmd.Type != CmdListLinks || cmd.Target != "s" {
+		t.Errorf("list-links: %+v, %v", cmd, err)
+	}
+	if cmd, err := Parse(strings.Fields("show-exits -t s -F #{exit_code}")); err != nil || cmd.Type != CmdShowExits || cmd.Format != "#{exit_code}" {
+		t.Errorf("show-exits: %+v, %v", cmd, err)
+	}
+}
+
+func TestParseBroker(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S /tmp/broker.sock broker"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdBroker || cmd.SocketPath != "/tmp/broker.sock" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+}
+
+func TestParseNewSessionStartupProbe(t *testing.T) {
+	cmd, err := Parse(strings.Fields("new-session -d -s s --startup-timeout 30s --startup-interval 200ms cmd"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.StartupTimeout != 30*time.Second || cmd.StartupInterval != 200*time.Millisecond {
+		t.Errorf("unexpected probe settings %v/%v", cmd.StartupTimeout, cmd.StartupInterval)
+	}
+	if cmd.ShellCmd != "cmd" {
+		t.Errorf("unexpected command %q", cmd.ShellCmd)
+	}
+}
+
+func TestParseNewSessionStartupTimeoutEnv(t *testing.T) {
+	t.Setenv("WINTMUX_STARTUP_TIMEOUT", "20")
+	cmd, err := Parse(strings.Fields("new-session -d -s s"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.StartupTimeout != 20*time.Second {
+		t.Errorf("expected 20s from environment, got %v", cmd.StartupTimeout)
+	}
+	cmd, _ = Parse(strings.Fields("new-session --startup-timeout 2s"))
+	if cmd.StartupTimeout != 2*time.Second {
+		t.Errorf("flag should override environment, got %v", cmd.StartupTimeout)
+	}
+}
+
+func TestParseProgressFlag(t *testing.T) {
+	for _, args := range []string{
+		"wait-stable --progress --timeout 10s",
+		"wait-event --type watch --progress",
+		"replay-input -s 2 --progress",
+	} {
+		cmd, err := Parse(strings.Fields(args))
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", args, err)
+		}
+		if !cmd.Progress {
+			t.Errorf("Parse(%q): expected Progress", args)
+		}
+	}
+	if _, err := Parse(strings.Fields("show-input-history --progress")); err == nil {
+		t.Error("expected --progress to be rejected for show-input-history")
+	}
+}
+
+func TestParseNewSessionListen(t *testing.T) {
+	cmd, err := Parse(strings.Fields("new-session -d -s s --bind ::1,127.0.0.1 --port 7000 cmd"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(cmd.Bind) != 2 || cmd.Bind[0] != "::1" || cmd.Bind[1] != "127.0.0.1" || cmd.Port != 7000 {
+		t.Errorf("unexpected bind %q port %d", cmd.Bind, cmd.Port)
+	}
+	if cmd.ShellCmd != "cmd" {
+		t.Errorf("unexpected command %q", cmd.ShellCmd)
+	}
+
+	for _, bad := range []string{
+		"new-session --bind 0.0.0.0",
+		"new-session --bind 192.168.1.5",
+		"new-session --bind localhost",
+		"new-session --port 0",
+		"new-session --port 70000",
+	} {
+		if _, err := Parse(strings.Fields(bad)); err == nil {
+			t.Errorf("Parse(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseNewSessionListenEnv(t *testing.T) {
+	t.Setenv("WINTMUX_BIND", "[::1]")
+	t.Setenv("WINTMUX_PORT", "7001")
+	cmd, err := Parse(strings.Fields("new-session -d"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(cmd.Bind) != 1 || cmd.Bind[0] != "::1" || cmd.Port != 7001 {
+		t.Errorf("expected defaults from environment, got %q %d", cmd.Bind, cmd.Port)
+	}
+}
+
+func TestParseNewSessionBackend(t *testing.T) {
+	for args, want := range map[string]string{
+		"new-session -d -s agent --container build01 -c C:\\src python agent.py": "docker:build01",
+		"new-session -d -s build --ssh ci@buildbox -- make -j8 test":             "ssh:ci@buildbox",
+		"new-session -d -s board --serial COM3:115200":                           "serial:COM3:115200",
+		"new-session -d --backend ssh:ci@buildbox?port=2222 top":                 "ssh:ci@buildbox?port=2222",
+	} {
+		cmd, err := Parse(strings.Fields(args))
+		if err != nil {
+			t.Errorf("Parse(%q): %v", args, err)
+			continue
+		}
+		if cmd.Backend != want {
+			t.Errorf("Parse(%q): backend %q, want %q", args, cmd.Backend, want)
+		}
+	}
+	cmd, _ := Parse(strings.Fields("new-session --ssh ci@buildbox -- make -j8 test"))
+	if cmd.ShellCmd != "make -j8 test" {
+		t.Errorf("command after -- = %q", cmd.ShellCmd)
+	}
+
+	for _, bad := range []string{
+		"new-session --container",
+		"new-session --ssh h --container c",
+		"new-session --serial COM3:fast",
+		"new-session --serial COM3 cmd",
+		"new-session --backend telnet:host",
+		"new-session --backend ssh:host?user=me",
+	} {
+		if _, err := Parse(strings.Fields(bad)); err == nil {
+			t.Errorf("Parse(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseSelftest(t *testing.T) {
+	cmd, err := Parse(strings.Fields("selftest --timeout 20s -v"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdSelftest {
+		t.Errorf("expected CmdSelftest, got %d", cmd.Type)
+	}
+	if cmd.Timeout != 20*time.Second || !cmd.Verbose || cmd.Fixture {
+		t.Errorf("unexpected flags: timeout=%v verbose=%v fixture=%v", cmd.Timeout, cmd.Verbose, cmd.Fixture)
+	}
+	if _, err := Parse(strings.Fields("selftest --keep")); err == nil {
+		t.Error("expected error for unknown selftest flag")
+	}
+}
+
+func TestParseDoctor(t *testing.T) {
+	cmd, err := Parse(strings.Fields("-S s.sock doctor"))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmd.Type != CmdDoctor || cmd.SocketPath != "s.sock" {
+		t.Errorf("unexpected command: type=%d socket=%q", cmd.Type, cmd.SocketPath)
+	}
+	if _, err := Parse(strings.Fields("doctor --fix")); err == nil {
+		t.Error("expected error for unknown doctor flag")
+	}
+}
+
+func TestParseHelp(t *testing.T) {
+	cmd, err := Parse([]string{"help"})
+	if err != nil || cmd.Type != CmdHelp || cmd.HelpCommand != "" {
+		t.Fatalf("help: %+v, %v", cmd, err)
+	}
+	cmd, err = Parse(strings.Fields("help capture-pane"))
+	if err != nil || cmd.HelpCommand != "capture-pane" {
+		t.Fatalf("help capture-pane: %+v, %v", cmd, err)
+	}
+	if _, err := Parse(strings.Fields("help frobnicate")); err == nil {
+		t.Error("expected error for help on an unknown command")
+	}
+	if _, err := Parse(strings.Fields("help a b")); err == nil {
+		t.Error("expected error for help on two commands")
+	}
+}
+
+func TestParseCompletion(t *testing.T) {
+	cmd, err := Parse(strings.Fields("completion bash"))
+	if err != nil || cmd.Type != CmdCompletion || cmd.CompletionShell != "bash" {
+		t.Fatalf("completion bash: %+v, %v", cmd, err)
+	}
+	for _, args := range []string{"completion", "completion zsh"} {
+		if _, err := Parse(strings.Fields(args)); err == nil {
+			t.Errorf("%s: expected error", args)
+		}
+	}
+}
+
+func TestSynopsis(t *testing.T) {
+	spec, ok := LookupCommand("respawnp")
+	if !ok || spec.Name != "respawn-pane" {
+		t.Fatalf("LookupCommand(respawnp) = %v, %v", spec, ok)
+	}
+	want := "respawn-pane [-t target] [-k] [-c start-directory] [-e VARIABLE=value] [shell-command]"
+	if got := spec.Synopsis(); got != want {
+		t.Errorf("Synopsis() = %q, want %q", got, want)
+	}
+	spec, _ = LookupCommand("stress")
+	if strings.Contains(spec.Synopsis(), "--generator") {
+		t.Errorf("hidden flag in synopsis: %q", spec.Synopsis())
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	for shell := range completionScripts {
+		script, err := Completion(shell)
+		if err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		for _, word := range []string{"new-session", "respawnp", "--startup-timeout", "--color"} {
+			if !strings.Contains(script, word) {
+				t.Errorf("%s completion lacks %s", shell, word)
+			}
+		}
+		if strings.Contains(script, "--generator") || strings.Contains(script, "--fixture") {
+			t.Errorf("%s completion offers a hidden flag", shell)
+		}
+	}
+	if _, err := Completion("zsh"); err == nil {
+		t.Error("expected error for an unknown shell")
+	}
+}
@@ -0,0 +1,139 @@
+// Package codepage transcodes output in legacy Windows code pages to
+// UTF-8. Legacy console programs write in the OEM code page (cp850 in
+// Western Europe, GBK and Shift-JIS in East Asia), which otherwise shows
+// up as mojibake in captures.
+package codepage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// table maps a single- or double-byte code page to Unicode. single holds
+// the runes for bytes 0x80-0xFF, with U+0000 marking a lead byte; lead
+// holds, for each lead byte, the runes for trail bytes 0x40-0xFE. Invalid
+// bytes map to U+FFFD.
+type table struct {
+	single string
+	lead   map[byte]string
+
+	once     sync.Once
+	singleRs []rune
+	leadRs   map[byte][]rune
+}
+
+const firstTrail, lastTrail = 0x40, 0xFE
+
+func (t *table) init() {
+	t.once.Do(func() {
+		t.singleRs = []rune(t.single)
+		t.leadRs = make(map[byte][]rune, len(t.lead))
+		for b, row := range t.lead {
+			t.leadRs[b] = []rune(row)
+		}
+	})
+}
+
+// names maps every accepted encoding name to its table; nil means UTF-8.
+var names = map[string]*table{
+	"utf-8":     nil,
+	"utf8":      nil,
+	"cp850":     cp850,
+	"ibm850":    cp850,
+	"gbk":       cp936,
+	"cp936":     cp936,
+	"shift-jis": cp932,
+	"shift_jis": cp932,
+	"sjis":      cp932,
+	"cp932":     cp932,
+}
+
+// Names returns the accepted encoding names, sorted.
+func Names() []string {
+	var list []string
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Decoder converts a byte stream in one code page to UTF-8. A double-byte
+// character split across two Decode calls is kept until the rest arrives.
+// A Decoder is not safe for concurrent use.
+type Decoder struct {
+	t       *table
+	pending int // lead byte left over from the last call, or -1
+}
+
+// NewDecoder returns a decoder for the named encoding (case-insensitive),
+// or nil for UTF-8, which needs no transcoding.
+func NewDecoder(name string) (*Decoder, error) {
+	t, ok := names[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	if t == nil {
+		return nil, nil
+	}
+	t.init()
+	return &Decoder{t: t, pending: -1}, nil
+}
+
+// Decode returns p converted to UTF-8. ASCII passes through unchanged, so
+// escape sequences are unaffected.
+func (d *Decoder) Decode(p []byte) []byte {
+	out := make([]byte, 0, len(p)+len(p)/2)
+	i := 0
+	if d.pending >= 0 && len(p) > 0 {
+		lead := byte(d.pending)
+		d.pending = -1
+		r, used := d.pair(lead, p[0])
+		out = utf8.AppendRune(out, r)
+		i = used - 1
+	}
+	for i < len(p) {
+		b := p[i]
+		if b < 0x80 {
+			out = append(out, b)
+			i++
+			continue
+		}
+		r := d.t.singleRs[b-0x80]
+		if r != 0 {
+			out = utf8.AppendRune(out, r)
+			i++
+			continue
+		}
+		if i+1 == len(p) {
+			d.pending = int(b)
+			break
+		}
+		r, used := d.pair(b, p[i+1])
+		out = utf8.AppendRune(out, r)
+		i += used
+	}
+	return out
+}
+
+// pair decodes lead followed by trail and reports how many bytes it
+// consumed: 2, or 1 if trail is not a valid trail byte, leaving it (often
+// ASCII after a stray lead byte) to be decoded on its own.
+func (d *Decoder) pair(lead, trail byte) (rune, int) {
+	if trail < firstTrail || trail > lastTrail {
+		return utf8.RuneError, 1
+	}
+	return d.t.leadRs[lead][trail-firstTrail], 2
+}
+
+// Flush returns what is left of an incomplete character, as U+FFFD.
+func (d *Decoder) Flush() []byte {
+	if d.pending < 0 {
+		return nil
+	}
+	d.pending = -1
+	return []byte(string(utf8.RuneError))
+}
@@ -0,0 +1,59 @@
+package codepage
+
+import "testing"
+
+func decodeAll(t *testing.T, name string, chunks ...string) string {
+	t.Helper()
+	d, err := NewDecoder(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for _, c := range chunks {
+		out = append(out, d.Decode([]byte(c))...)
+	}
+	return string(append(out, d.Flush()...))
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name, enc, in, want string
+	}{
+		{"cp850 accents", "cp850", "caf\x82 \x9c5", "café £5"},
+		{"cp850 box drawing", "cp850", "\xc9\xcd\xbb", "╔═╗"},
+		{"gbk", "gbk", "\xc4\xe3\xba\xc3, world", "你好, world"},
+		{"gbk euro", "cp936", "\x80", "€"},
+		{"shift-jis", "shift-jis", "\x82\xb1\x82\xf1", "こん"},
+		{"shift-jis half-width katakana", "sjis", "\xb1", "ｱ"},
+		{"shift-jis ASCII trail byte", "cp932", "\x95\x5c", "表"},
+		{"escape sequences pass through", "gbk", "\x1b[31m\xc4\xe3\x1b[0m", "\x1b[31m你\x1b[0m"},
+		{"stray lead byte before ASCII", "gbk", "\xc4\n", "�\n"},
+		{"incomplete at end", "gbk", "ok\xc4", "ok�"},
+	}
+	for _, tt := range tests {
+		if got := decodeAll(t, tt.enc, tt.in); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeSplitAcrossCalls(t *testing.T) {
+	if got := decodeAll(t, "gbk", "a\xc4", "\xe3b"); got != "a你b" {
+		t.Errorf("got %q, want %q", got, "a你b")
+	}
+	if got := decodeAll(t, "gbk", "\xc4", "", "\xe3"); got != "你" {
+		t.Errorf("empty chunk: got %q", got)
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	if d, err := NewDecoder("UTF-8"); d != nil || err != nil {
+		t.Errorf("UTF-8: got %v, %v; want no decoder", d, err)
+	}
+	if _, err := NewDecoder("Shift-JIS"); err != nil {
+		t.Errorf("names should be case-insensitive: %v", err)
+	}
+	if _, err := NewDecoder("ebcdic"); err == nil {
+		t.Error("expected error for unknown encoding")
+	}
+}
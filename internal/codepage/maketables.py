@@ -0,0 +1,69 @@
+#!/usr/bin/env python3
+"""Generates tables.go from Python's codecs.
+
+Run from this directory: python3 maketables.py > tables.go && gofmt -w tables.go
+"""
+
+import codecs
+
+# Go name, Python codec, single-byte overrides (Windows additions the
+# Python codec lacks).
+PAGES = [
+    ("cp850", "cp850", {}),
+    ("cp932", "cp932", {}),
+    ("cp936", "gbk", {0x80: "€"}),
+]
+
+TRAIL = range(0x40, 0xFF)
+
+
+def lit(chars):
+    """Go string literal for chars; non-printable runes are escaped."""
+    out = []
+    for c in chars:
+        o = ord(c)
+        if c == '"' or c == "\\":
+            out.append("\\" + c)
+        elif c.isprintable() and not 0xE000 <= o <= 0xF8FF:
+            out.append(c)
+        elif o <= 0xFFFF:
+            out.append("\\u%04x" % o)
+        else:
+            out.append("\\U%08x" % o)
+    return '"' + "".join(out) + '"'
+
+
+def decode(codec, data):
+    try:
+        s = data.decode(codec)
+    except UnicodeDecodeError:
+        return None
+    return s if len(s) == 1 else None
+
+
+print("// Code generated by maketables.py from Python's codecs; DO NOT EDIT.")
+print()
+print("package codepage")
+for name, codec, overrides in PAGES:
+    single = []
+    lead = {}
+    for b in range(0x80, 0x100):
+        c = overrides.get(b) or decode(codec, bytes([b]))
+        if c is not None:
+            single.append(c)
+            continue
+        row = [decode(codec, bytes([b, t])) or "�" for t in TRAIL]
+        if any(c != "�" for c in row):
+            single.append("\x00")
+            lead[b] = row
+        else:
+            single.append("�")
+    print()
+    print("var %s = &table{" % name)
+    print("\tsingle: %s," % lit(single))
+    if lead:
+        print("\tlead: map[byte]string{")
+        for b in sorted(lead):
+            print("\t\t0x%02x: %s," % (b, lit(lead[b])))
+        print("\t},")
+    print("}")
@@ -0,0 +1,323 @@
+//go:build conformance
+
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"wintmux/internal/ipc"
+)
+
+// settle is how long to let the pane programs run before a step whose
+// output is compared.
+const settle = 500 * time.Millisecond
+
+var wintmuxBin string
+
+func TestMain(m *testing.M) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		fmt.Println("conformance: tmux not found; skipping")
+		os.Exit(0)
+	}
+	dir, err := os.MkdirTemp("", "wintmux-conformance")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	wintmuxBin = filepath.Join(dir, "wintmux")
+	if runtime.GOOS == "windows" {
+		wintmuxBin += ".exe"
+	}
+	build := exec.Command("go", "build", "-o", wintmuxBin, "wintmux/cmd/wintmux")
+	if out, err := build.CombinedOutput(); err != nil {
+		fmt.Printf("conformance: building wintmux: %v\n%s", err, out)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// step is one command run against both implementations. Exit codes are
+// always compared; stdout only when compare is set.
+type step struct {
+	args    []string
+	compare bool
+}
+
+func run(args ...string) step     { return step{args: args} }
+func compare(args ...string) step { return step{args: args, compare: true} }
+
+// result is what one step produced.
+type result struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// implementation runs commands against one isolated server. Every case
+// uses a single session named "c".
+type implementation interface {
+	name() string
+	run(args []string) result
+	close()
+}
+
+// tmuxImpl runs tmux on a private socket with no configuration file.
+type tmuxImpl struct {
+	socket string
+}
+
+func newTmux(t *testing.T) *tmuxImpl {
+	return &tmuxImpl{socket: filepath.Join(t.TempDir(), "tmux.sock")}
+}
+
+func (i *tmuxImpl) name() string { return "tmux" }
+
+func (i *tmuxImpl) run(args []string) result {
+	// wintmux sessions default to 120x40; tmux sizes detached sessions
+	// from its default-size option, so give it the same geometry.
+	if len(args) > 0 && args[0] == "new-session" {
+		args = append([]string{"new-session", "-x", "120", "-y", "40"}, args[1:]...)
+	}
+	full := append([]string{"-S", i.socket, "-f", os.DevNull}, args...)
+	return execute(exec.Command("tmux", full...), "TMUX=")
+}
+
+func (i *tmuxImpl) close() {
+	execute(exec.Command("tmux", "-S", i.socket, "kill-server"), "TMUX=")
+}
+
+// wintmuxImpl runs the freshly built wintmux with its own registry.
+type wintmuxImpl struct {
+	socket   string
+	registry string
+	pids     map[int]bool // every daemon seen, killed on close
+}
+
+func newWintmux(t *testing.T) *wintmuxImpl {
+	dir := t.TempDir()
+	return &wintmuxImpl{
+		socket:   filepath.Join(dir, "c.sock"),
+		registry: filepath.Join(dir, "registry"),
+		pids:     make(map[int]bool),
+	}
+}
+
+func (i *wintmuxImpl) name() string { return "wintmux" }
+
+func (i *wintmuxImpl) run(args []string) result {
+	full := append([]string{"-S", i.socket}, args...)
+	r := execute(exec.Command(wintmuxBin, full...), "WINTMUX_REGISTRY_DIR="+i.registry)
+	if info, err := ipc.ReadControlFile(i.socket); err == nil && info.PID > 0 {
+		i.pids[info.PID] = true
+	}
+	return r
+}
+
+// close kills the session and any daemon a step left behind, such as
+// one whose control file was overwritten.
+func (i *wintmuxImpl) close() {
+	i.run([]string{"kill-session", "-t", "c"})
+	for pid := range i.pids {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+	}
+}
+
+func execute(cmd *exec.Cmd, env string) result {
+	cmd.Env = append(os.Environ(), env)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	r := result{stdout: stdout.String(), stderr: stderr.String()}
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		r.code = exit.ExitCode()
+	case err != nil:
+		r.code = -1
+		r.stderr += err.Error()
+	}
+	return r
+}
+
+// normalize drops trailing blanks on each line and trailing empty lines,
+// which tmux and wintmux pad differently.
+func normalize(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+type conformanceCase struct {
+	name  string
+	steps []step
+	// gap describes a known difference from tmux. Such a case logs its
+	// differences and is skipped; it fails once the outputs match, so
+	// the mark gets removed with the fix.
+	gap string
+}
+
+var cases = []conformanceCase{
+	{
+		name:  "has-session without a server",
+		steps: []step{run("has-session", "-t", "c")},
+	},
+	{
+		name: "has-session after new-session",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+			run("has-session", "-t", "c"),
+		},
+	},
+	{
+		name: "kill-session",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+			run("kill-session", "-t", "c"),
+			run("has-session", "-t", "c"),
+		},
+	},
+	{
+		name: "duplicate new-session",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+		},
+		gap: "a second new-session for a live session succeeds instead of reporting a duplicate",
+	},
+	{
+		name:  "unknown command",
+		steps: []step{run("no-such-command")},
+	},
+	{
+		name: "capture-pane single line",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "printf hello; sleep 30"),
+			compare("capture-pane", "-p", "-t", "c"),
+		},
+	},
+	{
+		name: "capture-pane multiple lines",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", `printf 'one\ntwo\nthree\n'; sleep 30`),
+			compare("capture-pane", "-p", "-t", "c"),
+		},
+		gap: "the exec fallback has no tty, so a bare LF does not return to column 0",
+	},
+	{
+		name: "capture-pane history range",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "printf abc; sleep 30"),
+			compare("capture-pane", "-p", "-t", "c", "-S", "-5"),
+		},
+		gap: "-S with a negative start captures scrollback only, without the visible screen",
+	},
+	{
+		name: "send-keys with Enter",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "cat"),
+			run("send-keys", "-t", "c", "hello", "Enter"),
+			compare("capture-pane", "-p", "-t", "c"),
+		},
+		gap: "the exec fallback has no tty, so input is not echoed and Enter (CR) ends no line",
+	},
+	{
+		name: "display-message session name",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+			compare("display-message", "-p", "-t", "c", "#{session_name}"),
+		},
+	},
+	{
+		name: "display-message cursor",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "printf abc; sleep 30"),
+			compare("display-message", "-p", "-t", "c", "#{cursor_x},#{cursor_y}"),
+		},
+	},
+	{
+		name: "display-message pane size",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+			compare("display-message", "-p", "-t", "c", "#{pane_width}x#{pane_height}"),
+		},
+	},
+	{
+		name: "display-message window and pane index",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+			compare("display-message", "-p", "-t", "c", "#{window_index}.#{pane_index}"),
+		},
+		gap: "window_index and pane_index are not defined",
+	},
+	{
+		name: "set-option history-limit",
+		steps: []step{
+			run("new-session", "-d", "-s", "c", "sleep 30"),
+			run("set-option", "-t", "c", "history-limit", "500"),
+		},
+	},
+}
+
+func TestConformance(t *testing.T) {
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			diffs := runCase(t, tc)
+			switch {
+			case len(diffs) > 0 && tc.gap == "":
+				t.Errorf("differs from tmux:\n%s", strings.Join(diffs, "\n"))
+			case len(diffs) > 0:
+				t.Skipf("known gap: %s\n%s", tc.gap, strings.Join(diffs, "\n"))
+			case tc.gap != "":
+				t.Errorf("matches tmux now; remove the known gap %q", tc.gap)
+			}
+		})
+	}
+}
+
+// runCase runs the steps against both implementations and describes
+// every step whose results differ.
+func runCase(t *testing.T, tc conformanceCase) []string {
+	impls := []implementation{newTmux(t), newWintmux(t)}
+	for _, impl := range impls {
+		defer impl.close()
+	}
+
+	var diffs []string
+	for n, s := range tc.steps {
+		if s.compare {
+			time.Sleep(settle)
+		}
+		want := impls[0].run(s.args)
+		got := impls[1].run(s.args)
+		label := fmt.Sprintf("step %d (%s)", n+1, strings.Join(s.args, " "))
+		if want.code != got.code {
+			diffs = append(diffs, fmt.Sprintf("%s: exit code %s %d, %s %d\n  %s stderr: %q\n  %s stderr: %q",
+				label, impls[0].name(), want.code, impls[1].name(), got.code,
+				impls[0].name(), want.stderr, impls[1].name(), got.stderr))
+		}
+		if s.compare && normalize(want.stdout) != normalize(got.stdout) {
+			diffs = append(diffs, fmt.Sprintf("%s: output differs\n  %s: %q\n  %s: %q",
+				label, impls[0].name(), normalize(want.stdout), impls[1].name(), normalize(got.stdout)))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,60 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2026, 3, 4, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"* * * * *", "2026-03-04 10:18"},
+		{"*/15 * * * *", "2026-03-04 10:30"},
+		{"0 9-17/4 * * *", "2026-03-04 13:00"},
+		{"30 8 * * mon-fri", "2026-03-05 08:30"},
+		{"0 0 * * 0", "2026-03-08 00:00"},
+		{"0 0 * * 7", "2026-03-08 00:00"},
+		{"@daily", "2026-03-05 00:00"},
+		{"@monthly", "2026-04-01 00:00"},
+		{"0 12 1 jan *", "2027-01-01 12:00"},
+		{"5,10 10 4 3 *", "2027-03-04 10:05"},
+		// Both day fields restricted: either matches.
+		{"0 0 15 * fri", "2026-03-06 00:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(from).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("%q: Next = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Next = %v, want zero", next)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "x * * * *", "@often",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"wintmux/internal/ipc"
+)
+
+// accessLevel is a client's standing permission, set with server-access.
+// Unlike lock-client it persists until changed. Like a lock it is keyed
+// by the name the client sends, so it restrains cooperating clients only.
+type accessLevel int
+
+const (
+	accessWrite accessLevel = iota // default: every action allowed
+	accessReadOnly
+	accessDeny
+)
+
+func (a accessLevel) String() string {
+	switch a {
+	case accessReadOnly:
+		return "read-only"
+	case accessDeny:
+		return "deny"
+	default:
+		return "write"
+	}
+}
+
+// readOnlyActions are the only requests a read-only client may send:
+// queries that neither write to the pane nor change session state.
+var readOnlyActions = map[ipc.Action]bool{
+	ipc.ActionCapture:        true,
+	ipc.ActionCaptureAll:     true,
+	ipc.ActionHasSession:     true,
+	ipc.ActionDisplay:        true,
+	ipc.ActionWaitStable:     true,
+	ipc.ActionWaitPrompt:     true,
+	ipc.ActionListClients:    true,
+	ipc.ActionListProcesses:  true,
+	ipc.ActionListCommands:   true,
+	ipc.ActionListLinks:      true,
+	ipc.ActionShowExits:      true,
+	ipc.ActionInputHistory:   true,
+	ipc.ActionDiffCheckpoint: true,
+	ipc.ActionWatchList:      true,
+	ipc.ActionScheduleList:   true,
+	ipc.ActionRedactList:     true,
+	ipc.ActionPipeList:       true,
+	ipc.ActionWaitEvent:      true,
+	ipc.ActionPing:           true,
+	ipc.ActionAttach:         true, // input from a read-only client is dropped
+	ipc.ActionBridge:         true,
+}
+
+// checkAccessLocked applies the server-access policy. Caller holds r.mu.
+func (r *clientRegistry) checkAccessLocked(name string, action ipc.Action) error {
+	switch r.access[name] {
+	case accessDeny:
+		return fmt.Errorf("access denied for client %s", name)
+	case accessReadOnly:
+		if !readOnlyActions[action] {
+			return fmt.Errorf("client %s is read-only", name)
+		}
+	}
+	return nil
+}
+
+// handleServerAccess changes or lists per-client access. Option holds the
+// mode: "add"/"write", "read-only", "deny" or "list".
+func (d *Daemon) handleServerAccess(req ipc.Request) ipc.Response {
+	r := d.clients
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Option == "list" {
+		names := make([]string, 0, len(r.access))
+		for name := range r.access {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines := make([]string, 0, len(names))
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("%s (%s)", name, r.access[name]))
+		}
+		return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+	}
+
+	name := req.TargetClient
+	if name == "" {
+		return ipc.Response{OK: false, Error: "no client specified"}
+	}
+	if name == req.Client && req.Option != "write" && req.Option != "add" {
+		return ipc.Response{OK: false, Error: "cannot restrict access for the calling client"}
+	}
+	switch req.Option {
+	case "add", "write":
+		r.access[name] = accessWrite
+	case "read-only":
+		r.access[name] = accessReadOnly
+	case "deny":
+		r.access[name] = accessDeny
+	default:
+		return ipc.Response{OK: false, Error: fmt.Sprintf("unknown access mode: %s", req.Option)}
+	}
+	return ipc.Response{OK: true}
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"wintmux/internal/units"
+)
+
+// checkUsage acts on a resource sample: it kills the pane process when
+// it is over kill-on-memory, and raises a "resource" alert when usage
+// crosses alert-cpu or alert-memory. An alert is raised once per
+// crossing; usage has to fall back under the threshold before the next.
+func (d *Daemon) checkUsage(u *paneUsage) {
+	memory := units.FormatSize(u.memory)
+	if limit, _ := parseLimitSize(d.option("kill-on-memory")); limit > 0 && u.memory > limit {
+		if d.childExited() {
+			return
+		}
+		log.Printf("daemon: pane pid=%d uses %s, over kill-on-memory %s; killing it", u.pid, memory, units.FormatSize(limit))
+		d.emitResource("memory", "kill", memory, units.FormatSize(limit))
+		d.term().Close()
+		return
+	}
+
+	cpu, _ := parseLimitInt(d.option("alert-cpu"), 0)
+	if d.crossed(&d.cpuAlert, cpu > 0 && u.cpuPercent >= float64(cpu)) {
+		d.raiseResource("cpu", strconv.FormatFloat(u.cpuPercent, 'f', 1, 64)+"%", strconv.Itoa(cpu)+"%")
+	}
+	limit, _ := parseLimitSize(d.option("alert-memory"))
+	if d.crossed(&d.memoryAlert, limit > 0 && u.memory >= limit) {
+		d.raiseResource("memory", memory, units.FormatSize(limit))
+	}
+}
+
+// crossed records whether usage is over a threshold and reports whether
+// it has just gone over.
+func (d *Daemon) crossed(flag *atomic.Bool, over bool) bool {
+	return flag.Swap(over) != over && over
+}
+
+// raiseResource emits a resource alert and starts alert-resource-hook.
+func (d *Daemon) raiseResource(kind, value, limit string) {
+	d.emitResource(kind, "alert", value, limit)
+	d.runResourceHook(kind, value, limit)
+}
+
+func (d *Daemon) emitResource(kind, action, value, limit string) {
+	text := fmt.Sprintf("%s %s over %s", kind, value, limit)
+	if action == "kill" {
+		text += ", killed"
+	}
+	d.events.emit("resource", text, map[string]string{
+		"resource_kind":   kind,
+		"resource_action": action,
+		"resource_value":  value,
+		"resource_limit":  limit,
+	})
+}
+
+// runResourceHook starts alert-resource-hook in the background, like
+// alert-bell-hook, unless it is unset or still running.
+func (d *Daemon) runResourceHook(kind, value, limit string) {
+	hook := d.option("alert-resource-hook")
+	if hook == "" || !d.resourceHook.CompareAndSwap(false, true) {
+		return
+	}
+	cmd := d.childCommand(d.expandCommand(hook))
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_ALERT="+kind,
+		"WINTMUX_VALUE="+value,
+		"WINTMUX_LIMIT="+limit,
+	)
+	go func() {
+		defer d.resourceHook.Store(false)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("daemon: alert-resource-hook failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}()
+}
@@ -0,0 +1,29 @@
+package daemon
+
+// noteAlternate emits an "alternate" event for each switch to or from the
+// alternate screen since the last call: a full-screen application such as
+// vim or less took over the pane, so capture-pane shows its frame, or
+// gave it back to the shell and its scrollback. A switch there and back
+// within one output chunk emits both events. Called after each output
+// chunk reaches the screen.
+func (d *Daemon) noteAlternate() {
+	n, on := d.screen.AltSwitches()
+	prev := d.altSwitches.Swap(int64(n))
+	if int64(n) == prev {
+		return
+	}
+	if (int64(n)-prev)%2 == 0 {
+		d.emitAlternate(!on)
+	}
+	d.emitAlternate(on)
+}
+
+func (d *Daemon) emitAlternate(on bool) {
+	text := "left alternate screen"
+	if on {
+		text = "entered alternate screen"
+	}
+	d.events.emit("alternate", text, map[string]string{
+		"alternate_on": flag(on),
+	})
+}
@@ -0,0 +1,373 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/vt"
+)
+
+// attachQueue is how many output chunks may wait for a slow attached
+// client before it is disconnected. The pane reader never blocks on a
+// client.
+const attachQueue = 256
+
+// attachRedrawDelay is how long the pane stays one row short when a
+// terminal attaches to a full-screen application (see redrawApplication):
+// long enough for the application to see two sizes rather than one.
+const attachRedrawDelay = 50 * time.Millisecond
+
+// attachRedactDelay is how long an unfinished line is held back from an
+// attached client while redaction rules wait for the rest of it.
+const attachRedactDelay = 20 * time.Millisecond
+
+// attachment is one attached client connection.
+type attachment struct {
+	client  string
+	source  string          // "attach", or "pipe" for a byte bridge
+	colors  *vt.ColorFilter // converts output for the client's terminal; nil passes it on
+	delta   *deltaView      // what the client's terminal shows, if it is sent screen changes
+	raw     redactStream    // redacts the output stream; used by the sending goroutine only
+	out     chan []byte
+	done    chan struct{} // closed when the attachment ends
+	once    sync.Once
+	reason  string // sent to the client when the daemon ends the attachment
+	focused bool   // the client's terminal has focus, by its focus reports; guarded by attachSet.mu
+}
+
+func (a *attachment) end(reason string) {
+	a.once.Do(func() {
+		a.reason = reason
+		close(a.done)
+	})
+}
+
+// attachSet holds every attached client.
+type attachSet struct {
+	mu   sync.Mutex
+	list map[*attachment]bool
+}
+
+func (s *attachSet) add(a *attachment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.list == nil {
+		s.list = make(map[*attachment]bool)
+	}
+	s.list[a] = true
+}
+
+func (s *attachSet) remove(a *attachment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.list, a)
+}
+
+// viewing reports whether a terminal is attached (not just a pipe or an
+// in-pane exec run), so someone sees the pane.
+func (s *attachSet) viewing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for a := range s.list {
+		if a.source == "attach" {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcast queues pane output for every attached client. A delta
+// attachment is only told that the screen changed, by a nil chunk; one
+// that is behind already has a frame coming and is not dropped.
+func (s *attachSet) broadcast(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.list) == 0 {
+		return
+	}
+	chunk := append([]byte(nil), data...)
+	for a := range s.list {
+		out := chunk
+		if a.delta != nil {
+			out = nil
+		}
+		select {
+		case a.out <- out:
+		default:
+			if a.delta != nil {
+				continue
+			}
+			log.Printf("daemon: attached client %s is not keeping up; detaching", a.client)
+			a.end("client too slow")
+			delete(s.list, a)
+		}
+	}
+}
+
+// toTerminals queues data for attached terminals but not pipes. It is
+// dropped for a client whose queue is full.
+func (s *attachSet) toTerminals(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for a := range s.list {
+		if a.source != "attach" {
+			continue
+		}
+		select {
+		case a.out <- data:
+		default:
+		}
+	}
+}
+
+// setFocus records whether a's terminal has focus and reports whether
+// any attached terminal had focus before and has it now.
+func (s *attachSet) setFocus(a *attachment, focused bool) (before, after bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before = s.focusedLocked()
+	a.focused = focused
+	return before, s.focusedLocked()
+}
+
+// focused reports whether any attached terminal has focus.
+func (s *attachSet) focused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.focusedLocked()
+}
+
+func (s *attachSet) focusedLocked() bool {
+	for a := range s.list {
+		if a.focused {
+			return true
+		}
+	}
+	return false
+}
+
+// endAll ends every attachment, telling the clients why.
+func (s *attachSet) endAll(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for a := range s.list {
+		a.end(reason)
+		delete(s.list, a)
+	}
+}
+
+// serveAttach takes over conn for an attached client. After the reply,
+// which carries a repaint of the current screen, the daemon pushes pane
+// output as events and the client sends its keyboard input as send_keys
+// requests carrying Data, which get no reply. The attachment lasts until
+// either side closes the connection.
+//
+// With req.Delta the client is sent the rows of the screen that changed
+// (see deltaView) instead of the output itself, which keeps the stream
+// small for watchers of a chatty pane.
+//
+// A bridge (wintmux pipe) is the same stream without the repaint: the
+// client relays raw pane output to its stdout and its stdin to the pane,
+// for programs that embed a session as a subprocess.
+func (d *Daemon) serveAttach(conn net.Conn, req ipc.Request) {
+	if req.Client == "" {
+		req.Client = "anonymous"
+	}
+	done := d.clients.begin(req.Client, conn.RemoteAddr().String(), req.Action)
+	defer done()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := d.clients.check(req.Client, req); err != nil {
+		ipc.WriteMessage(conn, ipc.Response{ID: req.ID, OK: false, Error: err.Error()})
+		return
+	}
+	depth := vt.ColorsTrue
+	if req.Colors != "" {
+		var err error
+		if depth, err = vt.ParseColors(req.Colors); err != nil {
+			ipc.WriteMessage(conn, ipc.Response{ID: req.ID, OK: false, Error: err.Error()})
+			return
+		}
+	}
+	d.clients.attached(req.Client, req.Width, req.Height, string(depth), 1)
+	defer d.clients.attached(req.Client, 0, 0, "", -1)
+
+	a := &attachment{client: req.Client, source: "attach", colors: vt.NewColorFilter(depth), raw: redactStream{d: d}, out: make(chan []byte, attachQueue), done: make(chan struct{})}
+	if req.Action == ipc.ActionBridge {
+		a.source = "pipe"
+	} else if req.Delta {
+		a.delta = &deltaView{}
+	}
+	write := ipc.WriteMessage
+	if req.Compress {
+		write = func(w io.Writer, v interface{}) error {
+			return ipc.WriteMessageCompressedAbove(w, v, attachCompressThreshold)
+		}
+	}
+	// Register before taking the repaint so no output falls between them.
+	d.attached.add(a)
+	defer d.attached.remove(a)
+	reply := ipc.Response{ID: req.ID, OK: true}
+	if req.Action == ipc.ActionAttach {
+		if a.delta != nil {
+			reply.Output = string(a.delta.update(d.redactLines(d.screen.CaptureLinks(0)), d.screen.Cursor(), d.screen.KeyMode()))
+		} else {
+			reply.Output = d.repaint()
+		}
+		d.clearAlerts()
+		// The terminal was just used to attach, so it has focus; it
+		// reports changes from here on if focus-events is on.
+		if d.option("focus-events") == "on" {
+			reply.Output += focusModeOn
+		}
+		d.setFocus(a, true)
+		defer d.setFocus(a, false)
+	}
+	if err := write(conn, reply); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	log.Printf("daemon: client %s attached (%s)", req.Client, a.source)
+	if req.Action == ipc.ActionAttach && d.screen.Cursor().Alternate {
+		go d.redrawApplication()
+	}
+
+	go d.readAttachInput(conn, a)
+
+	send := func(data []byte) {
+		// A client that stops reading is dropped rather than
+		// blocking this goroutine for good.
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := write(conn, ipc.Response{OK: true, Event: true, Data: data}); err != nil {
+			a.end("")
+		}
+	}
+	sendOutput := func(data []byte) {
+		if a.colors != nil {
+			data = a.colors.Filter(data)
+		}
+		if len(data) > 0 {
+			send(data)
+		}
+	}
+	var frame <-chan time.Time // the next delta frame, once the screen changed
+	var flush <-chan time.Time // lets an unfinished line held for redaction go
+	for {
+		select {
+		case data := <-a.out:
+			if data == nil {
+				if frame == nil {
+					frame = time.After(time.Until(a.delta.next))
+				}
+				continue
+			}
+			data = a.raw.write(data)
+			if len(a.raw.carry) > 0 && flush == nil {
+				flush = time.After(attachRedactDelay)
+			}
+			sendOutput(data)
+		case <-flush:
+			flush = nil
+			sendOutput(a.raw.flush())
+		case <-frame:
+			// Wait for the end of a frame the application is drawing,
+			// rather than show it half done.
+			lines, ok := d.screen.CaptureFrame(0, true)
+			if !ok {
+				frame = time.After(attachFrameInterval)
+				continue
+			}
+			frame = nil
+			a.delta.next = time.Now().Add(attachFrameInterval)
+			if data := a.delta.update(d.redactLines(lines), d.screen.Cursor(), d.screen.KeyMode()); len(data) > 0 {
+				send(data)
+			}
+		case <-a.done:
+			if a.reason != "" {
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				ipc.WriteMessage(conn, ipc.Response{OK: true, Event: true, Output: a.reason})
+			}
+			log.Printf("daemon: client %s detached", req.Client)
+			return
+		}
+	}
+}
+
+// readAttachInput forwards an attached client's keystrokes to the pane.
+// Input from a locked or read-only client is dropped.
+func (d *Daemon) readAttachInput(conn net.Conn, a *attachment) {
+	defer a.end("")
+	for {
+		var req ipc.Request
+		if err := ipc.ReadMessageDeadline(conn, &req, 0); err != nil {
+			return
+		}
+		if req.Action != ipc.ActionSendKeys || len(req.Data) == 0 {
+			continue
+		}
+		if d.clients.check(a.client, req) != nil {
+			continue
+		}
+		var err error
+		if a.source == "attach" {
+			err = d.writeTerminalInput(a, req.Data)
+		} else {
+			err = d.writeInput(a.client, a.source, "", req.Data)
+		}
+		if err != nil {
+			log.Printf("daemon: %s input: %v", a.source, err)
+		}
+	}
+}
+
+// redrawApplication has the full-screen application in the pane draw
+// its frame again for a terminal that just attached, whose repaint is
+// text only and may catch a frame half drawn. The pane is made a row
+// shorter and given its size back, which the application takes as two
+// resizes, as tmux's SIGWINCH on attach is; the pane ends at the size it
+// had. A shell at its prompt is left alone, as a resize can make it
+// print the prompt again.
+func (d *Daemon) redrawApplication() {
+	if d.rows < 2 {
+		return
+	}
+	term := d.term()
+	if err := term.Resize(d.cols, d.rows-1); err != nil {
+		return
+	}
+	time.Sleep(attachRedrawDelay)
+	if err := term.Resize(d.cols, d.rows); err != nil {
+		log.Printf("daemon: restore pane size: %v", err)
+	}
+}
+
+// repaint renders the visible screen as terminal output that clears the
+// client's screen and redraws it, cursor included. The screen keeps text
+// only, so colors return as the application redraws. The terminal is
+// also put in the keyboard protocol the application asked for before it
+// attached, so its keys arrive encoded as the application expects.
+// The session's redactions apply, as they do to captures.
+func (d *Daemon) repaint() string {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	lines := d.redactLines(d.screen.CaptureLinks(0))
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+	}
+	cur := d.screen.Cursor()
+	fmt.Fprintf(&b, "\x1b[%d;%dH", cur.Y+1, cur.X+1)
+	if !cur.Visible {
+		b.WriteString("\x1b[?25l")
+	}
+	b.WriteString(d.screen.KeyMode().Sequence())
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"wintmux/internal/screen"
+	"wintmux/internal/vt"
+)
+
+// attachFrameInterval is the shortest time between two frames sent to a
+// delta attachment. Output arriving after a quiet spell is sent at once;
+// a chatty pane costs each watcher at most one frame per interval.
+const attachFrameInterval = 50 * time.Millisecond
+
+// attachCompressThreshold is the smallest attach message worth
+// compressing for a client that accepts compression: repaints and busy
+// frames, not the echo of a keystroke.
+const attachCompressThreshold = 1024
+
+// deltaView is what the terminal of a delta attachment shows, so that it
+// can be sent only the rows of the screen that changed since the last
+// frame rather than every byte the pane wrote. Like the repaint, rows
+// are text only.
+type deltaView struct {
+	lines  []string // nil until the first frame
+	cursor screen.CursorState
+	keys   vt.KeyMode
+	next   time.Time // earliest time for the next frame
+}
+
+// update returns the output that takes the terminal from the view to the
+// given screen, and makes that the view. The first frame clears the
+// terminal and draws every row, as a repaint does.
+func (v *deltaView) update(lines []string, cur screen.CursorState, keys vt.KeyMode) []byte {
+	var b bytes.Buffer
+	if v.lines == nil || len(lines) != len(v.lines) {
+		b.WriteString("\x1b[H\x1b[2J")
+		v.lines = make([]string, len(lines))
+		v.cursor = screen.CursorState{Visible: true}
+	}
+	for i, line := range lines {
+		if line != v.lines[i] {
+			fmt.Fprintf(&b, "\x1b[%d;1H%s\x1b[K", i+1, line)
+		}
+	}
+	if b.Len() > 0 || cur.X != v.cursor.X || cur.Y != v.cursor.Y {
+		fmt.Fprintf(&b, "\x1b[%d;%dH", cur.Y+1, cur.X+1)
+	}
+	if cur.Visible != v.cursor.Visible {
+		if cur.Visible {
+			b.WriteString("\x1b[?25h")
+		} else {
+			b.WriteString("\x1b[?25l")
+		}
+	}
+	if keys != v.keys {
+		b.WriteString(vt.ResetKeyMode + keys.Sequence())
+	}
+	v.lines, v.cursor, v.keys = lines, cur, keys
+	return b.Bytes()
+}
@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"wintmux/internal/pty"
+)
+
+// cursorQuery is the cursor position report request (DSR 6) a pseudo
+// console created with inherit-cursor sends at startup. It waits for the
+// answer before passing any output through.
+var cursorQuery = []byte("\x1b[6n")
+
+// answerCursorQuery tells c's pseudo console where the cursor is on the
+// session's screen, so the new process carries on from there. This is
+// what lets a respawned pane continue below the old one's output.
+func (d *Daemon) answerCursorQuery(c *child) {
+	cur := d.screen.Cursor()
+	reply := fmt.Sprintf("\x1b[%d;%dR", cur.Y+1, cur.X+1)
+	if _, err := c.term.Write([]byte(reply)); err != nil {
+		log.Printf("daemon: answer cursor query: %v", err)
+	}
+}
+
+// conptyFlagsFromEnv returns the pseudo console flags WINTMUX_CONPTY_FLAGS
+// asks for the session's first terminal. Flags this system does not
+// support are an error, as they are for the conpty-flags option.
+func conptyFlagsFromEnv() (pty.Flags, error) {
+	v := os.Getenv("WINTMUX_CONPTY_FLAGS")
+	if v == "" {
+		return 0, nil
+	}
+	f, err := checkConptyFlags(v)
+	if err != nil {
+		return 0, fmt.Errorf("WINTMUX_CONPTY_FLAGS: %w", err)
+	}
+	return f, nil
+}
+
+// checkConptyFlags parses a conpty-flags value and checks that this
+// system supports every flag in it.
+func checkConptyFlags(v string) (pty.Flags, error) {
+	f, err := pty.ParseFlags(v)
+	if err != nil {
+		return 0, err
+	}
+	if missing := f &^ detectSystem().Supported(f); missing != 0 {
+		return 0, fmt.Errorf("not supported on this system: %s", missing)
+	}
+	return f, nil
+}
+
+// backendFromEnv applies the terminal backend WINTMUX_BACKEND asks for
+// the session's first terminal.
+func backendFromEnv() (string, error) {
+	v := os.Getenv("WINTMUX_BACKEND")
+	if v == "" {
+		return "", nil
+	}
+	if err := pty.SetBackend(v); err != nil {
+		return "", fmt.Errorf("WINTMUX_BACKEND: %w", err)
+	}
+	return v, nil
+}
+
+// defaultTerminalFromEnv returns the default-terminal WINTMUX_TERM asks
+// for the session's first process.
+func defaultTerminalFromEnv() (string, error) {
+	v := os.Getenv("WINTMUX_TERM")
+	if v == "" {
+		return "", nil
+	}
+	if err := checkTerminalName(v); err != nil {
+		return "", fmt.Errorf("WINTMUX_TERM: %w", err)
+	}
+	return v, nil
+}
+
+// checkTerminalName checks a default-terminal value: a terminfo name, or
+// none.
+func checkTerminalName(v string) error {
+	if v == "" || strings.Trim(v, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.+-_") != "" {
+		return fmt.Errorf("invalid terminal name %q", v)
+	}
+	return nil
+}
+
+// terminalEnv returns the TERM and COLORTERM a new pane process starts
+// with. The daemon's own TERM describes whatever terminal started it,
+// often none at all (a service, an orchestrator) or one with a different
+// feature set (mintty's xterm, cygwin), not the ConPTY screen the pane
+// writes to. That screen takes xterm sequences and 24-bit color, which
+// attached clients downgrade as they need.
+func (d *Daemon) terminalEnv() []string {
+	name := d.option("default-terminal")
+	if name == "none" {
+		return nil
+	}
+	return []string{"TERM=" + name, "COLORTERM=truecolor"}
+}
+
+// paneBackend names the backend running the pane's terminal.
+func (d *Daemon) paneBackend() string {
+	if name := pty.BackendName(d.term()); name != "" {
+		return name
+	}
+	return detectSystem().Backend
+}
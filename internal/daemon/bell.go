@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// noteBell raises a bell alert when the screen has seen the bell ring
+// since the last call: with monitor-bell on, it emits a "bell" event,
+// starts alert-bell-hook and, unless a terminal is attached and shows
+// the bell itself, sets the bell flag. Bells rung in one output chunk
+// make one alert. Called after each output chunk reaches the screen.
+func (d *Daemon) noteBell() {
+	n := int64(d.screen.Bells())
+	prev := d.bells.Swap(n)
+	if n == prev || d.windowOption("monitor-bell") != "on" {
+		return
+	}
+	if !d.attached.viewing() {
+		d.bellFlag.Store(true)
+	}
+	d.events.emit("bell", "bell", map[string]string{
+		"bell_count": strconv.FormatInt(n-prev, 10),
+	})
+	d.runBellHook()
+}
+
+// runBellHook starts alert-bell-hook in the background, like watch hooks,
+// unless it is unset or still running from an earlier bell. Formats in
+// the hook are expanded.
+func (d *Daemon) runBellHook() {
+	hook := d.option("alert-bell-hook")
+	if hook == "" || !d.bellHook.CompareAndSwap(false, true) {
+		return
+	}
+	cmd := d.childCommand(d.expandCommand(hook))
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_ALERT=bell",
+	)
+	go func() {
+		defer d.bellHook.Store(false)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("daemon: alert-bell-hook failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}()
+}
+
+// clearAlerts resets the bell and activity flags once a client has seen
+// the pane, as tmux does when a window with an alert is selected.
+func (d *Daemon) clearAlerts() {
+	d.bellFlag.Store(false)
+	d.activityFlag.Store(false)
+}
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"sync"
+
+	"wintmux/internal/scrollback"
+)
+
+// busKind is the kind of a busEvent.
+type busKind int
+
+const (
+	busOutput busKind = iota // pane output, once the screen and history have it
+	busStart                 // a pane process started: the first or a respawn
+	busExit                  // the pane process exited and was waited for
+	busOption                // set-option changed an option
+	busKinds
+)
+
+// busEvent is something that happened in the daemon that its features
+// react to. Only the fields of its kind are set.
+type busEvent struct {
+	kind     busKind
+	data     []byte            // busOutput: the output, transcoded to UTF-8
+	stream   scrollback.Stream // busOutput: the stream it came from, if known
+	mirrored bool              // busOutput: mirrored from another session
+	child    *child            // busStart, busExit: the run of the pane process
+	option   string            // busOption: the option's name
+	value    string            // busOption: its new value
+}
+
+// bus delivers the daemon's events to the features that react to them,
+// so a feature hooks in by subscribing instead of being called from
+// readOutput or the request handlers. Unlike eventLog, which holds the
+// events clients see, it is internal and keeps nothing.
+//
+// publish calls the handlers in the order they subscribed, in the
+// publishing goroutine, before it returns: output reaches them in order
+// and a handler may read the screen as that output left it. Handlers
+// must therefore be quick; anything slow belongs in a goroutine.
+type bus struct {
+	mu   sync.Mutex
+	subs [busKinds][]*busSub
+}
+
+type busSub struct {
+	fn func(busEvent)
+}
+
+// subscribe adds fn to the handlers of kind and returns a function that
+// removes it again.
+func (b *bus) subscribe(kind busKind, fn func(busEvent)) (cancel func()) {
+	s := &busSub{fn: fn}
+	b.mu.Lock()
+	b.subs[kind] = append(b.subs[kind], s)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subs := b.subs[kind]
+		for i, sub := range subs {
+			if sub == s {
+				b.subs[kind] = append(subs[:i:i], subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// publish calls the handlers of ev's kind. They may subscribe and cancel,
+// which takes effect from the next event.
+func (b *bus) publish(ev busEvent) {
+	b.mu.Lock()
+	subs := b.subs[ev.kind]
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.fn(ev)
+	}
+}
+
+// subscribeFeatures connects the daemon's own features to the bus. The
+// order is the order they see output in: shell marks, progress, bells,
+// activity and screen switches are noted before watches match and attached
+// clients are sent the output, and pipe sinks get it last. Option
+// changes are saved for persist-options.
+func (d *Daemon) subscribeFeatures() {
+	d.bus.subscribe(busOutput, func(busEvent) { d.noteCommand() })
+	d.bus.subscribe(busOutput, func(busEvent) { d.noteProgress() })
+	d.bus.subscribe(busOutput, func(busEvent) { d.noteBell() })
+	d.bus.subscribe(busOutput, func(busEvent) { d.noteActivity() })
+	d.bus.subscribe(busOutput, func(busEvent) { d.noteAlternate() })
+	d.bus.subscribe(busOutput, func(ev busEvent) { d.feedWatches(ev.data) })
+	d.bus.subscribe(busOutput, func(ev busEvent) { d.attached.broadcast(ev.data) })
+	d.bus.subscribe(busOutput, func(busEvent) { d.noteFocusMode() })
+	// Mirrored output is not piped, so two sessions mirroring each other
+	// cannot loop.
+	d.bus.subscribe(busOutput, func(ev busEvent) {
+		if !ev.mirrored {
+			d.writePipes(ev.data)
+		}
+	})
+	d.bus.subscribe(busExit, func(ev busEvent) { d.noteExit(ev.child) })
+	d.bus.subscribe(busOption, func(busEvent) { d.saveOptions() })
+}
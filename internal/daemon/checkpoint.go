@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/linediff"
+	"wintmux/internal/vt"
+)
+
+// maxCheckpoints bounds how many named checkpoints a session keeps.
+const maxCheckpoints = 100
+
+// checkpointContext is the number of unchanged screen lines shown around
+// each change by diff-checkpoint.
+const checkpointContext = 2
+
+// checkpoint is a named snapshot of pane state.
+type checkpoint struct {
+	time      time.Time
+	screen    []string
+	alternate bool
+	history   int // scrollback Total() when taken
+	input     int // index of the next input event when taken
+}
+
+type checkpointSet struct {
+	mu     sync.Mutex
+	byName map[string]*checkpoint
+}
+
+func (d *Daemon) takeCheckpoint() *checkpoint {
+	return &checkpoint{
+		time:      time.Now(),
+		screen:    d.screen.Capture(0),
+		alternate: d.screen.Cursor().Alternate,
+		history:   d.buffer.Total(),
+		input:     d.input.next(),
+	}
+}
+
+// handleCheckpoint creates (the default), lists or deletes checkpoints.
+// Taking a checkpoint under an existing name replaces it.
+func (d *Daemon) handleCheckpoint(req ipc.Request) ipc.Response {
+	cs := &d.checkpoints
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	switch req.Option {
+	case "list":
+		names := make([]string, 0, len(cs.byName))
+		for name := range cs.byName {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return cs.byName[names[i]].time.Before(cs.byName[names[j]].time)
+		})
+		lines := make([]string, 0, len(names))
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("%s %s", name, cs.byName[name].time.Format(time.RFC3339)))
+		}
+		return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+	case "delete":
+		if _, ok := cs.byName[req.Name]; !ok {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("no checkpoint: %s", req.Name)}
+		}
+		delete(cs.byName, req.Name)
+		return ipc.Response{OK: true}
+	}
+
+	if req.Name == "" {
+		return ipc.Response{OK: false, Error: "no checkpoint name specified"}
+	}
+	if cs.byName == nil {
+		cs.byName = make(map[string]*checkpoint)
+	}
+	if _, ok := cs.byName[req.Name]; !ok && len(cs.byName) >= maxCheckpoints {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("too many checkpoints (max %d)", maxCheckpoints)}
+	}
+	cs.byName[req.Name] = d.takeCheckpoint()
+	return ipc.Response{OK: true}
+}
+
+// handleDiffCheckpoint reports what happened since a checkpoint: input
+// sent (if record-input was on), lines added to the history, and a
+// unified diff of the visible screen.
+func (d *Daemon) handleDiffCheckpoint(req ipc.Request) ipc.Response {
+	d.checkpoints.mu.Lock()
+	cp := d.checkpoints.byName[req.Name]
+	d.checkpoints.mu.Unlock()
+	if cp == nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("no checkpoint: %s", req.Name)}
+	}
+	now := d.takeCheckpoint()
+
+	var out []string
+	out = append(out, fmt.Sprintf("checkpoint %s taken %s (%s ago)",
+		req.Name, cp.time.Format(time.RFC3339), now.time.Sub(cp.time).Round(time.Second)))
+
+	if evs, _ := d.input.slice(cp.input, 0); len(evs) > 0 {
+		out = append(out, fmt.Sprintf("input: %d events", len(evs)))
+		for _, ev := range evs {
+			data := fmt.Sprintf("%q", ev.data)
+			if ev.kind == "key" {
+				data = ev.name
+			}
+			out = append(out, "  "+ev.client+" "+data)
+		}
+	}
+
+	added := now.history - cp.history
+	kept := min(added, d.buffer.Count())
+	if kept < added {
+		out = append(out, fmt.Sprintf("output: %d new lines (last %d kept in history)", added, kept))
+	} else {
+		out = append(out, fmt.Sprintf("output: %d new lines", added))
+	}
+	for _, line := range d.buffer.Last(kept) {
+		out = append(out, "  "+vt.Strip(line))
+	}
+
+	if cp.alternate != now.alternate {
+		out = append(out, fmt.Sprintf("screen: alternate_on %s -> %s", flag(cp.alternate), flag(now.alternate)))
+	}
+	hunks := linediff.Unified(linediff.Diff(cp.screen, now.screen), checkpointContext)
+	if len(hunks) == 0 {
+		out = append(out, "screen: unchanged")
+	} else {
+		out = append(out, "screen:")
+		out = append(out, hunks...)
+	}
+	return ipc.Response{OK: true, Output: strings.Join(out, "\n")}
+}
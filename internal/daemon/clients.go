@@ -0,0 +1,323 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+)
+
+// clientExpiry is how long a client that has stopped sending requests
+// stays in the registry.
+const clientExpiry = time.Hour
+
+// defaultClientFormat mirrors tmux's list-clients output.
+const defaultClientFormat = "#{client_name}: #{session_name} [#{client_width}x#{client_height}] (#{client_flags}) requests=#{client_requests} last=#{client_last_command}"
+
+// client records what the daemon knows about one requester. CLI
+// invocations are short-lived connections, so identity comes from the
+// Client field of each request rather than from the connection.
+type client struct {
+	name        string
+	addr        string
+	created     time.Time
+	activity    time.Time
+	requests    int
+	lastCommand ipc.Action
+	width       int
+	height      int
+	colors      string // color depth of the attached terminal
+	conns       int    // connections currently open
+	attached    int    // attached connections currently open
+	locked      bool   // input actions rejected
+	suspended   bool   // all actions rejected
+}
+
+// clientRegistry tracks every client that has talked to the daemon.
+type clientRegistry struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	owner   string // when set, only this client may send input
+	access  map[string]accessLevel
+}
+
+// inputActions are the requests that write to the pane or start a
+// process, which could. A locked client may still observe the session
+// but not type into it.
+var inputActions = map[ipc.Action]bool{
+	ipc.ActionSendKeys:    true,
+	ipc.ActionSendKey:     true,
+	ipc.ActionSendText:    true,
+	ipc.ActionRunPS:       true,
+	ipc.ActionReplayInput: true,
+	ipc.ActionPlayKeys:    true,
+	ipc.ActionScheduleAdd: true, // scheduled commands may type later
+	ipc.ActionRespawn:     true,
+	ipc.ActionExec:        true,
+}
+
+// isInput reports whether locks apply to req: an input action, or a
+// request that sets a command the daemon runs (a pipe command, a watch
+// hook, a hook or probe option).
+func isInput(req ipc.Request) bool {
+	switch req.Action {
+	case ipc.ActionPipePane, ipc.ActionPipeAdd:
+		return req.ShellCmd != ""
+	case ipc.ActionWatchAdd:
+		return req.Hook != ""
+	case ipc.ActionSetOption:
+		return commandOptions[req.Option]
+	}
+	return inputActions[req.Action]
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{
+		clients: make(map[string]*client),
+		access:  make(map[string]accessLevel),
+	}
+}
+
+// begin records the start of a request from name and returns a function
+// to call when the connection closes.
+func (r *clientRegistry) begin(name, addr string, action ipc.Action) func() {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(now)
+
+	c := r.getLocked(name)
+	c.addr = addr
+	c.activity = now
+	c.requests++
+	c.lastCommand = action
+	c.conns++
+
+	return func() {
+		r.mu.Lock()
+		c.conns--
+		r.mu.Unlock()
+	}
+}
+
+// attached adjusts name's count of attached connections by delta and,
+// when attaching, records its terminal size and color depth.
+func (r *clientRegistry) attached(name string, width, height int, colors string, delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.getLocked(name)
+	c.attached += delta
+	if delta > 0 {
+		c.width, c.height, c.colors = width, height, colors
+	}
+}
+
+// check reports whether name may make req under the server-access
+// policy and any lock or suspension. A locked client may still send
+// unlock-client, but handleUnlockClient does not let it unlock itself.
+func (r *clientRegistry) check(name string, req ipc.Request) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkAccessLocked(name, req.Action); err != nil {
+		return err
+	}
+	c := r.clients[name]
+	if c != nil && c.suspended {
+		return fmt.Errorf("client %s is suspended", name)
+	}
+	if !isInput(req) {
+		return nil
+	}
+	if c != nil && c.locked {
+		return fmt.Errorf("client %s is locked", name)
+	}
+	if r.owner != "" && r.owner != name {
+		return fmt.Errorf("input is locked by client %s", r.owner)
+	}
+	return nil
+}
+
+// getLocked returns the entry for name, creating a placeholder so that a
+// client can be locked before it first connects.
+func (r *clientRegistry) getLocked(name string) *client {
+	c := r.clients[name]
+	if c == nil {
+		now := time.Now()
+		c = &client{name: name, created: now, activity: now}
+		r.clients[name] = c
+	}
+	return c
+}
+
+// expireLocked forgets clients idle for clientExpiry. An expired owner
+// gives up exclusive input, which only it may release.
+func (r *clientRegistry) expireLocked(now time.Time) {
+	for name, c := range r.clients {
+		if c.conns == 0 && !c.locked && !c.suspended && now.Sub(c.activity) > clientExpiry {
+			delete(r.clients, name)
+			if r.owner == name {
+				r.owner = ""
+			}
+		}
+	}
+}
+
+// snapshot returns format variables for every known client, oldest first.
+func (r *clientRegistry) snapshot() []map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(time.Now())
+
+	list := make([]*client, 0, len(r.clients))
+	for _, c := range r.clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].created.Before(list[j].created) })
+
+	out := make([]map[string]string, 0, len(list))
+	for _, c := range list {
+		v := c.vars()
+		v["client_flags"] = strings.Join(r.flagsLocked(c), ",")
+		out = append(out, v)
+	}
+	return out
+}
+
+func (c *client) flags() []string {
+	var f []string
+	if c.conns > 0 {
+		f = append(f, "active")
+	}
+	if c.attached > 0 {
+		f = append(f, "attached")
+	}
+	if c.locked {
+		f = append(f, "locked")
+	}
+	if c.suspended {
+		f = append(f, "suspended")
+	}
+	return f
+}
+
+// flagsLocked adds registry-level state to a client's own flags.
+func (r *clientRegistry) flagsLocked(c *client) []string {
+	f := c.flags()
+	switch r.access[c.name] {
+	case accessReadOnly:
+		f = append(f, "read-only")
+	case accessDeny:
+		f = append(f, "denied")
+	}
+	if r.owner == c.name {
+		f = append(f, "exclusive")
+	}
+	return f
+}
+
+func (c *client) vars() map[string]string {
+	return map[string]string{
+		"client_name":         c.name,
+		"client_addr":         c.addr,
+		"client_created":      strconv.FormatInt(c.created.Unix(), 10),
+		"client_activity":     strconv.FormatInt(c.activity.Unix(), 10),
+		"client_requests":     strconv.Itoa(c.requests),
+		"client_last_command": string(c.lastCommand),
+		"client_width":        strconv.Itoa(c.width),
+		"client_height":       strconv.Itoa(c.height),
+		"client_colors":       c.colors,
+	}
+}
+
+func (d *Daemon) handleListClients(req ipc.Request) ipc.Response {
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultClientFormat
+	}
+	session := d.formatVars()
+
+	var lines []string
+	for _, cv := range d.clients.snapshot() {
+		for k, v := range session {
+			if _, ok := cv[k]; !ok {
+				cv[k] = v
+			}
+		}
+		lines = append(lines, format.Expand(tmpl, cv))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+// handleLockClient locks input from one client, or with All takes
+// exclusive input control for the sender.
+func (d *Daemon) handleLockClient(req ipc.Request) ipc.Response {
+	r := d.clients
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.All {
+		if r.owner != "" && r.owner != req.Client {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("input already locked by client %s", r.owner)}
+		}
+		r.owner = req.Client
+		return ipc.Response{OK: true}
+	}
+	if req.TargetClient == "" {
+		return ipc.Response{OK: false, Error: "no client specified"}
+	}
+	r.getLocked(req.TargetClient).locked = true
+	return ipc.Response{OK: true}
+}
+
+// handleSuspendClient rejects every request from a client until it is
+// unlocked again.
+func (d *Daemon) handleSuspendClient(req ipc.Request) ipc.Response {
+	if req.TargetClient == "" {
+		return ipc.Response{OK: false, Error: "no client specified"}
+	}
+	if req.TargetClient == req.Client {
+		return ipc.Response{OK: false, Error: "a client cannot suspend itself"}
+	}
+	d.clients.mu.Lock()
+	d.clients.getLocked(req.TargetClient).suspended = true
+	d.clients.mu.Unlock()
+	return ipc.Response{OK: true}
+}
+
+// handleUnlockClient clears lock and suspend state for one client, or
+// with All releases exclusive input control. Only the owner may release
+// exclusive input, and a locked client may not unlock itself, or a lock
+// would hold only until its target asked for it to be lifted.
+func (d *Daemon) handleUnlockClient(req ipc.Request) ipc.Response {
+	r := d.clients
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.All {
+		if r.owner != "" && r.owner != req.Client {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("input is locked by client %s", r.owner)}
+		}
+		r.owner = ""
+		return ipc.Response{OK: true}
+	}
+	name := req.TargetClient
+	if name == "" {
+		name = req.Client
+	}
+	c := r.clients[name]
+	if name == req.Client && c != nil && (c.locked || c.suspended) {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("client %s cannot unlock itself", name)}
+	}
+	if c != nil {
+		c.locked = false
+		c.suspended = false
+	}
+	return ipc.Response{OK: true}
+}
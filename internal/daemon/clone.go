@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	"os"
+
+	"wintmux/internal/ipc"
+)
+
+// handleCloneInfo reports what the session was started with, for
+// clone-session: the startup command, the pane's current directory, the
+// daemon's environment (which every pane process inherits), the backend,
+// the --secret-env references and the options that have been set.
+// Secret values are not included; the clone's daemon fetches its own.
+func (d *Daemon) handleCloneInfo() ipc.Response {
+	s := &ipc.SessionSettings{
+		Command: d.command,
+		Dir:     d.currentPath(),
+		Env:     os.Environ(),
+		Backend: d.spec.String(),
+	}
+	for _, v := range d.secrets {
+		s.SecretEnv = append(s.SecretEnv, v.name+"="+v.ref.String())
+	}
+	s.Options = d.setOptions()
+	return ipc.Response{OK: true, Output: ipc.FormatSettings(s)}
+}
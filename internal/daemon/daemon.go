@@ -1,29 +1,42 @@
 package daemon
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
-	"strconv"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"wintmux/internal/codepage"
 	"wintmux/internal/ipc"
 	"wintmux/internal/pty"
+	"wintmux/internal/registry"
 	"wintmux/internal/screen"
 	"wintmux/internal/scrollback"
+	"wintmux/internal/vt"
 )
 
 // ControlInfo is written to the socket path file so CLI clients can
-// discover the daemon's TCP port.
+// discover the daemon's TCP port. It is first written with State
+// "starting" and no port, before the terminal is created, so a client
+// waiting on a slow start can tell it apart from a daemon that never ran.
+// If startup fails the file is rewritten with State "failed" and the
+// error, for the creating client to report and remove.
 type ControlInfo struct {
-	Port int `json:"port"`
-	PID  int `json:"pid"`
+	Port  int      `json:"port"`
+	Addrs []string `json:"addrs,omitempty"` // every listening address, in preference order
+	PID   int      `json:"pid"`
+	State string   `json:"state,omitempty"` // "starting", "ready" or "failed"
+	Error string   `json:"error,omitempty"` // why startup failed
 }
 
 // Daemon manages a single session: one ConPTY process, a scrollback
@@ -31,44 +44,151 @@ type ControlInfo struct {
 type Daemon struct {
 	socketPath   string
 	sessionName  string
-	terminal     pty.Terminal
+	id           int // number of the session's, window's and pane's IDs; see target.go
+	workdir      string
+	command      string
+	spec         pty.Spec // backend, target and options of the pane's terminal
+	secrets      []secretVar
+	childMu      sync.RWMutex
+	cur          *child // current run of the pane process; see respawn-pane
 	buffer       *scrollback.Buffer
 	screen       *screen.Screen
-	listener     net.Listener
-	pipePaneMu   sync.Mutex
-	pipePaneFile *os.File
-	done         chan struct{} // closed when child process exits
+	cols, rows   int
+	listeners    []net.Listener
+	pipeMu       sync.Mutex
+	pipes        []*pipeSink // pipe-pane and pipe-add sinks, in order added
+	pipeID       int         // last automatic pipe-add name number
+	started      time.Time
+	lastOutput   atomic.Int64 // UnixNano of the most recent terminal output
+	commandSeq   atomic.Int64 // Seq of the last finished command an event was emitted for
+	progressMu   sync.Mutex
+	progress     screen.Progress        // last OSC 9;4 progress an event was emitted for
+	bells        atomic.Int64           // screen bell count already alerted for
+	bellFlag     atomic.Bool            // a bell rang that no client has seen (window_bell_flag)
+	activityFlag atomic.Bool            // output no client has seen, with monitor-activity on
+	fixedName    atomic.Pointer[string] // window name kept while automatic-rename is off
+	marked       atomic.Bool            // the pane is the marked pane, the target {marked}
+	bellHook     atomic.Bool            // alert-bell-hook is running
+	cpuAlert     atomic.Bool            // pane CPU is over alert-cpu
+	memoryAlert  atomic.Bool            // pane memory is over alert-memory
+	resourceHook atomic.Bool            // alert-resource-hook is running
+	stuck        atomic.Bool            // the pane looks hung (pane_stuck)
+	stuckProbe   atomic.Bool            // stuck-probe is running
+	stuckHook    atomic.Bool            // alert-stuck-hook is running
+	focusMode    atomic.Bool            // the application's focus reporting mode, as last seen
+	altSwitches  atomic.Int64           // screen alternate screen switches already emitted
+	closing      atomic.Bool            // the child exited and the daemon is in its grace period
+	killed       chan struct{}          // closed by kill-session: shut down without a grace period
+	killOnce     sync.Once
+	usage        atomic.Pointer[paneUsage]
+	clients      *clientRegistry
+	optionsMu    sync.Mutex
+	options      map[string]string // current value of every session option set so far
+	windowOpts   map[string]string // current value of every window option set so far
+	saveMu       sync.Mutex        // serializes writes of the options file
+	limits       pty.Limits
+	input        inputLog
+	checkpoints  checkpointSet
+	macros       macroSet
+	watches      watchSet
+	redactions   redactSet
+	schedules    scheduleSet
+	events       eventLog
+	bus          bus
+	exits        exitLog
+	attached     attachSet
+	decoder      atomic.Pointer[codepage.Decoder] // pane-encoding; nil for UTF-8
 }
 
+// child is one run of the pane's process. respawn-pane replaces it with a
+// new one; everything else about the session (screen, history, options)
+// carries over.
+type child struct {
+	term       pty.Terminal
+	done       chan struct{} // closed when the process has exited and its output is drained
+	readerDone chan struct{} // closed when readOutput has drained the terminal
+	flags      pty.Flags     // pseudo console flags in effect for term
+	exited     atomic.Bool   // the process has exited; done follows once output is drained
+	run        int           // 0 for the first process, n for the n-th respawn
+	command    string        // the command it runs
+	started    time.Time
+}
+
+// DefaultListen is the address the daemon listens on unless told
+// otherwise: IPv4 loopback, on a port chosen by the system.
+const DefaultListen = "127.0.0.1:0"
+
 // Run is the main entry point for a daemon process. It creates the
-// terminal, starts the IPC server, and blocks until the child exits
-// and the grace period elapses.
-func Run(socketPath, sessionName, workdir, command string, cols, rows int) error {
-	term, err := pty.New(cols, rows, command, workdir, nil)
+// terminal, starts the IPC server on each of listen (DefaultListen if
+// empty), and blocks until the child exits and the grace period elapses.
+// spec chooses the terminal backend (see pty.Spec); its process fields
+// are filled in from the others. secretEnv holds NAME=REF entries, see
+// parseSecretEnv. A session with a ttl ends once it has run that long.
+func Run(socketPath, sessionName, workdir, command string, spec pty.Spec, cols, rows int, listen, secretEnv []string, ttl time.Duration) error {
+	if err := writeControlFile(socketPath, ControlInfo{PID: os.Getpid(), State: "starting"}); err != nil {
+		return fmt.Errorf("write control file: %w", err)
+	}
+	flags, err := conptyFlagsFromEnv()
 	if err != nil {
-		return fmt.Errorf("create terminal: %w", err)
+		return startupFailed(socketPath, err)
 	}
-
-	d := &Daemon{
-		socketPath:  socketPath,
-		sessionName: sessionName,
-		terminal:    term,
-		buffer:      scrollback.New(2000),
-		screen:      screen.New(cols, rows),
-		done:        make(chan struct{}),
+	pty.SetFlags(flags)
+	backend, err := backendFromEnv()
+	if err != nil {
+		return startupFailed(socketPath, err)
 	}
-
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	termName, err := defaultTerminalFromEnv()
 	if err != nil {
-		term.Close()
-		return fmt.Errorf("listen: %w", err)
+		return startupFailed(socketPath, err)
+	}
+	secrets, err := parseSecretEnv(secretEnv, spec)
+	if err != nil {
+		return startupFailed(socketPath, err)
+	}
+	d := newDaemon(socketPath, sessionName, workdir, command, cols, rows)
+	d.spec = spec
+	d.secrets = secrets
+	saved, err := d.loadOptions()
+	if err != nil {
+		return startupFailed(socketPath, fmt.Errorf("read saved options: %w", err))
+	}
+	// What the environment sets for this start wins over saved options.
+	fromEnv := map[string]bool{"default-terminal": termName != "", "conpty-flags": flags != 0, "pane-backend": backend != ""}
+	d.restoreOptions(saved, func(name string) bool { return !fromEnv[name] && !terminalOptions[name] })
+	if termName != "" {
+		d.options["default-terminal"] = termName
+	}
+	term, err := d.openTerminal(command, workdir, nil)
+	if err != nil {
+		return startupFailed(socketPath, fmt.Errorf("create terminal: %w", err))
+	}
+	if flags != 0 {
+		d.options["conpty-flags"] = flags.String()
+	}
+	if backend != "" {
+		d.options["pane-backend"] = backend
 	}
-	d.listener = listener
 
-	addr := listener.Addr().(*net.TCPAddr)
-	info := ControlInfo{Port: addr.Port, PID: os.Getpid()}
+	if len(listen) == 0 {
+		listen = []string{DefaultListen}
+	}
+	for _, addr := range listen {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			d.closeListeners()
+			term.Close()
+			return startupFailed(socketPath, fmt.Errorf("listen: %w", err))
+		}
+		d.listeners = append(d.listeners, ln)
+	}
+
+	info := ControlInfo{PID: os.Getpid(), State: "ready"}
+	for _, ln := range d.listeners {
+		info.Addrs = append(info.Addrs, ln.Addr().String())
+	}
+	info.Port = d.listeners[0].Addr().(*net.TCPAddr).Port
 	if err := writeControlFile(socketPath, info); err != nil {
-		listener.Close()
+		d.closeListeners()
 		term.Close()
 		return fmt.Errorf("write control file: %w", err)
 	}
@@ -80,32 +200,134 @@ func Run(socketPath, sessionName, workdir, command string, cols, rows int) error
 		defer lf.Close()
 	}
 
-	log.Printf("daemon: session=%s pid=%d port=%d socket=%s", sessionName, info.PID, info.Port, socketPath)
+	log.Printf("daemon: session=%s pid=%d addrs=%s socket=%s", sessionName, info.PID, strings.Join(info.Addrs, ","), socketPath)
+	d.register(info)
 
-	go d.readOutput()
-	go d.watchProcess()
+	c := d.startChild(term)
+	log.Printf("daemon: backend=%s os=%q conpty-flags=%s", d.paneBackend(), detectSystem().OS, c.flags)
+	d.restoreOptions(saved, func(name string) bool { return terminalOptions[name] })
+	go d.monitorUsage()
+	if ttl > 0 {
+		go d.expireAfter(ttl)
+	}
 
 	d.acceptConnections()
 	d.cleanup()
 	return nil
 }
 
-// readOutput continuously reads from the terminal and feeds data into
-// the scrollback buffer, the virtual screen, and optional pipe-pane file.
-func (d *Daemon) readOutput() {
+// newTerminal creates pane terminals. Tests substitute a ptytest fake.
+var newTerminal = pty.Open
+
+// openTerminal creates a terminal for the pane running command in dir,
+// with the default-terminal's TERM, the session's secrets and then env
+// added to its environment, on the session's backend.
+func (d *Daemon) openTerminal(command, dir string, env []string) (pty.Terminal, error) {
+	secrets, err := d.fetchSecrets()
+	if err != nil {
+		return nil, err
+	}
+	s := d.spec
+	s.Command, s.Dir, s.Env = command, dir, append(append(d.terminalEnv(), secrets...), env...)
+	s.Cols, s.Rows = d.cols, d.rows
+	return newTerminal(s)
+}
+
+// detectSystem reports the terminal backend's features. Tests substitute
+// one with features this system lacks.
+var detectSystem = pty.Detect
+
+// newDaemon returns a daemon with no terminal or listeners yet.
+func newDaemon(socketPath, sessionName, workdir, command string, cols, rows int) *Daemon {
+	d := &Daemon{
+		socketPath:  socketPath,
+		sessionName: sessionName,
+		id:          os.Getpid(),
+		workdir:     workdir,
+		command:     command,
+		buffer:      scrollback.New(2000),
+		screen:      screen.New(cols, rows),
+		cols:        cols,
+		rows:        rows,
+		started:     time.Now(),
+		clients:     newClientRegistry(),
+		options:     make(map[string]string),
+		windowOpts:  make(map[string]string),
+		killed:      make(chan struct{}),
+	}
+	d.subscribeFeatures()
+	return d
+}
+
+// startChild makes term the pane's current process and starts the
+// goroutines that read its output and wait for it to exit.
+func (d *Daemon) startChild(term pty.Terminal) *child {
+	c := &child{
+		term:       term,
+		done:       make(chan struct{}),
+		readerDone: make(chan struct{}),
+		started:    time.Now(),
+	}
+	if pty.BackendName(term) != "winpty" {
+		c.flags = detectSystem().Supported(pty.CurrentFlags())
+	}
+	d.childMu.Lock()
+	if d.cur != nil {
+		c.run = d.cur.run + 1
+	}
+	c.command = d.command
+	d.cur = c
+	d.childMu.Unlock()
+	d.bus.publish(busEvent{kind: busStart, child: c})
+
+	go d.readOutput(c)
+	go d.watchProcess(c)
+	return c
+}
+
+// child returns the current run of the pane process.
+func (d *Daemon) child() *child {
+	d.childMu.RLock()
+	defer d.childMu.RUnlock()
+	return d.cur
+}
+
+// term returns the current pane terminal.
+func (d *Daemon) term() pty.Terminal {
+	return d.child().term
+}
+
+// showOutput feeds pane output into the scrollback buffer, tagged with
+// the stream it came from if known, and the virtual screen, then
+// publishes it to the features that react to output.
+func (d *Daemon) showOutput(data []byte, stream scrollback.Stream, mirrored bool) {
+	d.lastOutput.Store(time.Now().UnixNano())
+	d.stuck.Store(false)
+	d.buffer.WriteStream(d.screen.WriteMain(data), stream)
+	for _, line := range d.screen.ScrolledOut() {
+		d.buffer.AppendLine(line, stream)
+	}
+	d.bus.publish(busEvent{kind: busOutput, data: data, stream: stream, mirrored: mirrored})
+}
+
+// readOutput continuously reads from the terminal and shows what it
+// reads, transcoded to UTF-8 first if pane-encoding is set.
+func (d *Daemon) readOutput(c *child) {
+	defer close(c.readerDone)
 	buf := make([]byte, 4096)
+	awaitQuery := c.flags&pty.FlagInheritCursor != 0
 	for {
-		n, err := d.terminal.Read(buf)
-		if n > 0 {
-			data := buf[:n]
-			d.buffer.Write(data)
-			d.screen.Write(data)
-
-			d.pipePaneMu.Lock()
-			if d.pipePaneFile != nil {
-				d.pipePaneFile.Write(data)
-			}
-			d.pipePaneMu.Unlock()
+		n, stream, err := readTagged(c.term, buf)
+		data := buf[:n]
+		if dec := d.decoder.Load(); dec != nil && n > 0 {
+			data = dec.Decode(data)
+		}
+		if len(data) > 0 {
+			d.showOutput(data, stream, false)
+		}
+		if awaitQuery && bytes.Contains(data, cursorQuery) {
+			awaitQuery = false
+			d.answerCursorQuery(c)
 		}
 		if err != nil {
 			if err != io.EOF {
@@ -116,50 +338,214 @@ func (d *Daemon) readOutput() {
 	}
 }
 
+// exitDrainTimeout bounds how long the daemon waits, after the child has
+// exited, for its final output to be read. Grandchildren that inherited
+// the output pipe can otherwise keep it open indefinitely.
+const exitDrainTimeout = 3 * time.Second
+
 // watchProcess waits for the child to exit, then shuts down the daemon
-// after a grace period.
-func (d *Daemon) watchProcess() {
-	d.terminal.Wait()
-	log.Printf("daemon: child exited with code %d", d.terminal.ExitCode())
-	close(d.done)
-	time.Sleep(5 * time.Second)
-	d.listener.Close()
+// after a grace period. The session is only reported gone once the final
+// output has been drained, so a capture taken after has-session fails
+// still sees the last lines the child wrote.
+//
+// With remain-on-exit on, or if the pane is respawned during the grace
+// period, the daemon keeps running, unless kill-session ended it.
+func (d *Daemon) watchProcess(c *child) {
+	c.term.Wait()
+	d.bus.publish(busEvent{kind: busExit, child: c})
+	c.exited.Store(true)
+	log.Printf("daemon: child exited with code %d", c.term.ExitCode())
+	select {
+	case <-c.readerDone:
+	case <-time.After(exitDrainTimeout):
+		log.Printf("daemon: output not drained within %v of exit", exitDrainTimeout)
+	}
+	close(c.done)
+	if d.wasKilled() {
+		d.attached.endAll("exited")
+		d.closeListeners()
+		return
+	}
+	if d.remainOnExit() {
+		return
+	}
+	d.closing.Store(true)
+	d.attached.endAll("exited")
+	select {
+	case <-time.After(5 * time.Second):
+	case <-d.killed:
+	}
+	if d.child() == c && (!d.remainOnExit() || d.wasKilled()) {
+		d.closeListeners()
+		return
+	}
+	d.closing.Store(false)
 }
 
+// wasKilled reports whether kill-session has ended the session.
+func (d *Daemon) wasKilled() bool {
+	select {
+	case <-d.killed:
+		return true
+	default:
+		return false
+	}
+}
+
+// maxConnections caps concurrently open connections, so a misbehaving
+// client cannot exhaust the daemon with idle ones.
+const maxConnections = 256
+
+// acceptConnections serves every listener until all are closed.
 func (d *Daemon) acceptConnections() {
+	slots := make(chan struct{}, maxConnections)
+	var wg sync.WaitGroup
+	for _, ln := range d.listeners {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+			d.accept(ln, slots)
+		}(ln)
+	}
+	wg.Wait()
+}
+
+func (d *Daemon) closeListeners() {
+	for _, ln := range d.listeners {
+		ln.Close()
+	}
+}
+
+func (d *Daemon) accept(ln net.Listener, slots chan struct{}) {
 	for {
-		conn, err := d.listener.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			return
 		}
-		go d.handleConnection(conn)
+		ipc.DefaultTCP.Tune(conn)
+		select {
+		case slots <- struct{}{}:
+		default:
+			log.Printf("daemon: refusing %s: %d connections open", conn.RemoteAddr(), maxConnections)
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer func() { <-slots }()
+			d.handleConnection(conn)
+		}()
 	}
 }
 
+// idleTimeout is how long a connection may sit between requests. The CLI
+// sends one request per connection; brokers keep connections open and
+// send many. An attach request hands the connection over to serveAttach.
+const idleTimeout = 2 * time.Minute
+
 func (d *Daemon) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer func() {
+		// A bug triggered by one request must not take the session down.
+		if r := recover(); r != nil {
+			log.Printf("daemon: panic serving %s: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+		}
+	}()
 
-	var req ipc.Request
-	if err := ipc.ReadMessage(conn, &req); err != nil {
-		log.Printf("daemon: read request: %v", err)
-		return
+	wait := 10 * time.Second
+	for {
+		var req ipc.Request
+		if err := ipc.ReadMessageDeadline(conn, &req, wait); err != nil {
+			if errors.Is(err, ipc.ErrBadMessage) {
+				// The frame was consumed whole, so the stream is still
+				// in step: report the error and keep the connection.
+				if !d.reject(conn, req, err) {
+					return
+				}
+				continue
+			}
+			// EOF and idle timeouts end a connection normally.
+			var ne net.Error
+			if !errors.Is(err, io.EOF) && !(errors.As(err, &ne) && ne.Timeout()) {
+				log.Printf("daemon: read request: %v", err)
+			}
+			return
+		}
+		wait = idleTimeout
+		if err := req.Validate(); err != nil {
+			if !d.reject(conn, req, err) {
+				return
+			}
+			continue
+		}
+		if req.Action == ipc.ActionAttach || req.Action == ipc.ActionBridge {
+			d.serveAttach(conn, req)
+			return
+		}
+		if !d.serveRequest(conn, req) {
+			return
+		}
 	}
+}
 
-	resp := d.dispatch(req)
-	if err := ipc.WriteMessage(conn, resp); err != nil {
+// reject answers a request that could not be decoded or failed
+// validation, and reports whether the reply was written.
+func (d *Daemon) reject(conn net.Conn, req ipc.Request, err error) bool {
+	log.Printf("daemon: bad request from %s: %v", conn.RemoteAddr(), err)
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return ipc.WriteMessage(conn, ipc.Response{ID: req.ID, OK: false, Error: "bad request: " + err.Error()}) == nil
+}
+
+// serveRequest handles one request on conn and reports whether the
+// response was written.
+func (d *Daemon) serveRequest(conn net.Conn, req ipc.Request) bool {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if req.TimeoutMs > 0 {
+		// Blocking actions may legitimately outlive the default deadline.
+		conn.SetDeadline(time.Now().Add(time.Duration(req.TimeoutMs)*time.Millisecond + 10*time.Second))
+	}
+
+	if req.Client == "" {
+		req.Client = "anonymous"
+	}
+	done := d.clients.begin(req.Client, conn.RemoteAddr().String(), req.Action)
+	defer done()
+
+	var resp ipc.Response
+	if err := d.clients.check(req.Client, req); err != nil {
+		resp = ipc.Response{OK: false, Error: err.Error()}
+	} else {
+		resp = d.dispatch(req, newProgress(conn, req))
+	}
+	resp.ID = req.ID
+	write := ipc.WriteMessage
+	if req.Compress {
+		write = ipc.WriteMessageCompressed
+	}
+	if err := write(conn, resp); err != nil {
 		log.Printf("daemon: write response: %v", err)
+		return false
 	}
+	return true
 }
 
-func (d *Daemon) dispatch(req ipc.Request) ipc.Response {
+// dispatch runs req. Long-running handlers report status through p.
+func (d *Daemon) dispatch(req ipc.Request, p *progress) ipc.Response {
+	if req.Target != "" {
+		if err := d.checkTarget(req.Target); err != nil {
+			return ipc.Response{OK: false, Error: err.Error()}
+		}
+	}
 	switch req.Action {
 	case ipc.ActionPing:
-		return ipc.Response{OK: true}
+		return ipc.Response{OK: true, Output: d.health()}
 	case ipc.ActionSendKeys:
 		return d.handleSendKeys(req)
+	case ipc.ActionSendText:
+		return d.handleSendText(req)
 	case ipc.ActionSendKey:
 		return d.handleSendKey(req)
+	case ipc.ActionRunPS:
+		return d.handleRunPS(req)
 	case ipc.ActionCapture:
 		return d.handleCapture(req)
 	case ipc.ActionHasSession:
@@ -170,6 +556,86 @@ func (d *Daemon) dispatch(req ipc.Request) ipc.Response {
 		return d.handleSetOption(req)
 	case ipc.ActionPipePane:
 		return d.handlePipePane(req)
+	case ipc.ActionDisplay:
+		return d.handleDisplay(req)
+	case ipc.ActionWaitStable:
+		return d.handleWaitStable(req, p)
+	case ipc.ActionWaitPrompt:
+		return d.handleWaitPrompt(req, p)
+	case ipc.ActionShowEnv:
+		return d.handleShowEnvironment(req)
+	case ipc.ActionCloneInfo:
+		return d.handleCloneInfo()
+	case ipc.ActionExec:
+		resp := d.handleExec(req, p)
+		resp.Output = d.redact(resp.Output)
+		return resp
+	case ipc.ActionListClients:
+		return d.handleListClients(req)
+	case ipc.ActionLockClient:
+		return d.handleLockClient(req)
+	case ipc.ActionUnlockClient:
+		return d.handleUnlockClient(req)
+	case ipc.ActionSuspendClient:
+		return d.handleSuspendClient(req)
+	case ipc.ActionServerAccess:
+		return d.handleServerAccess(req)
+	case ipc.ActionListProcesses:
+		return d.handleListProcesses(req)
+	case ipc.ActionListCommands:
+		return d.handleListCommands(req)
+	case ipc.ActionListLinks:
+		return d.handleListLinks(req)
+	case ipc.ActionRespawn:
+		return d.handleRespawn(req)
+	case ipc.ActionSelectPane:
+		return d.handleSelectPane(req)
+	case ipc.ActionShowExits:
+		return d.handleShowExits(req)
+	case ipc.ActionInputHistory:
+		return d.handleInputHistory(req)
+	case ipc.ActionRecordKeys:
+		return d.handleRecordKeys(req)
+	case ipc.ActionPlayKeys:
+		return d.handlePlayKeys(req, p)
+	case ipc.ActionReplayInput:
+		return d.handleReplayInput(req, p)
+	case ipc.ActionCheckpoint:
+		return d.handleCheckpoint(req)
+	case ipc.ActionDiffCheckpoint:
+		resp := d.handleDiffCheckpoint(req)
+		resp.Output = d.redact(resp.Output)
+		return resp
+	case ipc.ActionWatchAdd:
+		return d.handleWatchAdd(req)
+	case ipc.ActionWatchList:
+		return d.handleWatchList()
+	case ipc.ActionWatchRemove:
+		return d.handleWatchRemove(req)
+	case ipc.ActionPipeAdd:
+		return d.handlePipeAdd(req)
+	case ipc.ActionPipeList:
+		return d.handlePipeList()
+	case ipc.ActionPipeRemove:
+		return d.handlePipeRemove(req)
+	case ipc.ActionCaptureAll:
+		return d.handleCaptureAll(req)
+	case ipc.ActionMirrorOutput:
+		return d.handleMirrorOutput(req)
+	case ipc.ActionWaitEvent:
+		return d.handleWaitEvent(req, p)
+	case ipc.ActionScheduleAdd:
+		return d.handleScheduleAdd(req)
+	case ipc.ActionScheduleList:
+		return d.handleScheduleList(req)
+	case ipc.ActionScheduleRemove:
+		return d.handleScheduleRemove(req)
+	case ipc.ActionRedactAdd:
+		return d.handleRedactAdd(req)
+	case ipc.ActionRedactList:
+		return d.handleRedactList()
+	case ipc.ActionRedactRemove:
+		return d.handleRedactRemove(req)
 	default:
 		return ipc.Response{OK: false, Error: fmt.Sprintf("unknown action: %s", req.Action)}
 	}
@@ -177,131 +643,238 @@ func (d *Daemon) dispatch(req ipc.Request) ipc.Response {
 
 func (d *Daemon) handleSendKeys(req ipc.Request) ipc.Response {
 	if req.Text != "" {
-		if _, err := d.terminal.Write([]byte(req.Text)); err != nil {
+		if err := d.writeInput(req.Client, "text", "", []byte(req.Text)); err != nil {
 			return ipc.Response{OK: false, Error: err.Error()}
 		}
 	}
 	if req.SendEnter {
-		if _, err := d.terminal.Write([]byte("\r")); err != nil {
+		if err := d.writeInput(req.Client, "key", "Enter", []byte("\r")); err != nil {
 			return ipc.Response{OK: false, Error: err.Error()}
 		}
 	}
 	return ipc.Response{OK: true}
 }
 
-// keyMap translates tmux key names to the VT byte sequences expected by
-// terminal applications.
-var keyMap = map[string]string{
-	"Enter":    "\r",
-	"Escape":   "\x1b",
-	"BSpace":   "\x7f",
-	"Tab":      "\t",
-	"Space":    " ",
-	"C-c":      "\x03",
-	"C-d":      "\x04",
-	"C-z":      "\x1a",
-	"Up":       "\x1b[A",
-	"Down":     "\x1b[B",
-	"Right":    "\x1b[C",
-	"Left":     "\x1b[D",
-	"Home":     "\x1b[H",
-	"End":      "\x1b[F",
-	"DC":       "\x1b[3~",
-	"PageUp":   "\x1b[5~",
-	"PageDown": "\x1b[6~",
-}
-
+// handleSendKey sends a tmux key name, encoded for the keyboard protocol
+// the application has asked for (CSI u or modifyOtherKeys), so that
+// C-S-a or C-Enter reach an editor that can tell them apart.
 func (d *Daemon) handleSendKey(req ipc.Request) ipc.Response {
-	seq, ok := keyMap[req.Key]
-	if !ok {
-		return ipc.Response{OK: false, Error: fmt.Sprintf("unknown key: %s", req.Key)}
+	key, err := vt.ParseKey(req.Key)
+	if err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
 	}
-	if _, err := d.terminal.Write([]byte(seq)); err != nil {
+	seq := key.Encode(d.screen.KeyMode())
+	if err := d.writeInput(req.Client, "key", key.String(), seq); err != nil {
 		return ipc.Response{OK: false, Error: err.Error()}
 	}
 	return ipc.Response{OK: true}
 }
 
 func (d *Daemon) handleCapture(req ipc.Request) ipc.Response {
-	lines := req.Lines
-	if lines <= 0 {
-		lines = 50
+	captured, err := d.captureLines(req)
+	if err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
 	}
-	// Use virtual screen for capture — handles full-screen TUI apps correctly.
-	captured := d.screen.Capture(lines)
 	output := strings.Join(captured, "\n")
 	return ipc.Response{OK: true, Output: output}
 }
 
-func (d *Daemon) handleHasSession() ipc.Response {
-	select {
-	case <-d.done:
-		return ipc.Response{OK: true, Exists: false}
-	default:
-		return ipc.Response{OK: true, Exists: true}
-	}
-}
-
-func (d *Daemon) handleKillSession() ipc.Response {
-	if err := d.terminal.Close(); err != nil {
+// handleCaptureAll returns every pane's capture with its position and
+// cursor state as JSON, so one request refreshes a whole session. A
+// wintmux session has a single pane, window 0 pane 0.
+func (d *Daemon) handleCaptureAll(req ipc.Request) ipc.Response {
+	captured, err := d.captureLines(req)
+	if err != nil {
 		return ipc.Response{OK: false, Error: err.Error()}
 	}
-	return ipc.Response{OK: true}
+	cur := d.screen.Cursor()
+	pane := ipc.PaneCapture{
+		Session:       d.sessionName,
+		Socket:        d.socketPath,
+		PaneID:        d.paneID(),
+		Width:         d.cols,
+		Height:        d.rows,
+		CursorX:       cur.X,
+		CursorY:       cur.Y,
+		CursorVisible: cur.Visible,
+		Alternate:     cur.Alternate,
+		Dead:          d.childExited(),
+		Lines:         captured,
+	}
+	return ipc.Response{OK: true, Output: ipc.FormatCaptures([]ipc.PaneCapture{pane})}
 }
 
-func (d *Daemon) handleSetOption(req ipc.Request) ipc.Response {
-	switch req.Option {
-	case "history-limit":
-		n, err := strconv.Atoi(req.Value)
-		if err != nil || n <= 0 {
-			return ipc.Response{OK: false, Error: "invalid history-limit value"}
+// captureLines captures the pane as capture-pane does: the virtual
+// screen, the history filtered by a strip profile, or the output of a
+// shell command, with the session's redactions applied.
+func (d *Daemon) captureLines(req ipc.Request) ([]string, error) {
+	if req.LastCmd || req.CmdSeq != 0 {
+		n := req.CmdSeq
+		if req.LastCmd {
+			n = -1
 		}
-		d.buffer.SetCapacity(n)
-		return ipc.Response{OK: true}
-	default:
-		return ipc.Response{OK: false, Error: fmt.Sprintf("unknown option: %s", req.Option)}
+		lines, err := d.commandLines(n)
+		return d.redactLines(lines), err
 	}
+	lines := req.Lines
+	if lines <= 0 {
+		lines = 50
+	}
+	// Use virtual screen for capture — handles full-screen TUI apps correctly.
+	// A strip profile asks for the history as written instead, with only
+	// the selected escape sequences kept.
+	var captured []string
+	if req.Stream != "" {
+		var err error
+		if captured, err = d.captureStreams(req.Stream, lines, req.Strip); err != nil {
+			return nil, err
+		}
+	} else if req.Strip != "" {
+		profile, err := vt.ParseProfile(req.Strip)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range d.buffer.LastWithPartial(lines) {
+			captured = append(captured, vt.Apply(line, profile))
+		}
+	} else if req.Alternate {
+		var ok bool
+		if captured, ok = d.screen.CaptureMain(lines); !ok {
+			return nil, fmt.Errorf("no alternate screen")
+		}
+	} else if req.Frame {
+		captured = d.captureFrame(lines, req.Escapes)
+	} else if req.Escapes {
+		captured = d.screen.CaptureLinks(lines)
+	} else {
+		captured = d.screen.Capture(lines)
+	}
+	return d.redactLines(captured), nil
 }
 
-func (d *Daemon) handlePipePane(req ipc.Request) ipc.Response {
-	d.pipePaneMu.Lock()
-	defer d.pipePaneMu.Unlock()
+// Frame-coherent capture waits for the emulator to sit between frames and
+// for output to pause briefly. If the pane never settles within
+// frameSettleLimit the current state is captured anyway.
+const (
+	frameQuiet       = 50 * time.Millisecond
+	frameSettleLimit = 2 * time.Second
+)
 
-	if d.pipePaneFile != nil {
-		d.pipePaneFile.Close()
-		d.pipePaneFile = nil
+func (d *Daemon) captureFrame(lines int, links bool) []string {
+	deadline := time.Now().Add(frameSettleLimit)
+	for time.Now().Before(deadline) {
+		if d.quietFor() >= frameQuiet || d.childExited() {
+			if captured, ok := d.screen.CaptureFrame(lines, links); ok {
+				return captured
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Printf("daemon: frame capture did not settle within %v", frameSettleLimit)
+	if links {
+		return d.screen.CaptureLinks(lines)
 	}
+	return d.screen.Capture(lines)
+}
 
-	if req.ShellCmd == "" {
-		return ipc.Response{OK: true}
+// handleWaitStable blocks until the pane has produced no output for
+// req.QuietMs milliseconds, the child exits, or req.TimeoutMs elapses.
+func (d *Daemon) handleWaitStable(req ipc.Request, p *progress) ipc.Response {
+	quiet := time.Duration(req.QuietMs) * time.Millisecond
+	if quiet <= 0 {
+		quiet = 500 * time.Millisecond
+	}
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 30 * time.Second
 	}
 
-	path := extractPipePath(req.ShellCmd)
-	if path == "" {
-		return ipc.Response{OK: false, Error: "unsupported pipe-pane command (only 'cat >> path' supported)"}
+	deadline := time.Now().Add(timeout)
+	for {
+		q := d.quietFor()
+		if q >= quiet || d.childExited() {
+			return ipc.Response{OK: true}
+		}
+		if time.Now().After(deadline) {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("timed out after %v waiting for output to settle", timeout)}
+		}
+		p.report("output quiet for %v of %v", q.Round(time.Millisecond), quiet)
+		wait := quiet - q
+		if wait > 50*time.Millisecond {
+			wait = 50 * time.Millisecond
+		}
+		time.Sleep(wait)
 	}
+}
 
-	os.MkdirAll(filepath.Dir(path), 0755)
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
+// handleHasSession reports whether the session is alive. With
+// remain-on-exit on, a session whose pane has died still exists.
+func (d *Daemon) handleHasSession() ipc.Response {
+	return ipc.Response{OK: true, Exists: !d.childExited() || d.remainOnExit()}
+}
+
+// handleKillSession kills the pane process and ends the session without
+// a grace period, even with remain-on-exit on: once the pane's last
+// output is drained, the daemon closes its pipes, removes its control
+// file and exits. kill-session waits for the control file to go.
+func (d *Daemon) handleKillSession() ipc.Response {
+	d.killOnce.Do(func() { close(d.killed) })
+	if d.childExited() {
+		// A dead pane kept by remain-on-exit, or one in its grace
+		// period: watchProcess is past noticing the kill.
+		d.closeListeners()
+		return ipc.Response{OK: true}
+	}
+	// The process may exit by itself meanwhile; watchProcess then sees
+	// the kill.
+	if err := d.term().Close(); err != nil && !d.child().exited.Load() {
 		return ipc.Response{OK: false, Error: err.Error()}
 	}
-	d.pipePaneFile = f
 	return ipc.Response{OK: true}
 }
 
 func (d *Daemon) cleanup() {
-	d.pipePaneMu.Lock()
-	if d.pipePaneFile != nil {
-		d.pipePaneFile.Close()
-	}
-	d.pipePaneMu.Unlock()
+	d.closePipes()
 
-	d.terminal.Close()
+	d.term().Close()
 	os.Remove(d.socketPath)
+	if err := registry.Unregister(os.Getpid()); err != nil {
+		log.Printf("daemon: unregister: %v", err)
+	}
 	log.Printf("daemon: cleaned up session %s", d.sessionName)
 }
 
+// register adds the daemon to the per-user registry used by ls --all.
+// Failure is logged but not fatal: the session still works by -S path.
+func (d *Daemon) register(info ControlInfo) {
+	socket, err := filepath.Abs(d.socketPath)
+	if err != nil {
+		socket = d.socketPath
+	}
+	err = registry.Register(registry.Entry{
+		Session: d.sessionName,
+		Socket:  socket,
+		PID:     info.PID,
+		Port:    info.Port,
+		Workdir: d.workdir,
+		Command: d.command,
+		Started: d.started,
+	})
+	if err != nil {
+		log.Printf("daemon: register: %v", err)
+	}
+}
+
+// startupFailed records err in the control file so the client waiting
+// for the session sees the real cause, and returns it.
+func startupFailed(socketPath string, err error) error {
+	info := ControlInfo{PID: os.Getpid(), State: "failed", Error: err.Error()}
+	if werr := writeControlFile(socketPath, info); werr != nil {
+		os.Remove(socketPath)
+	}
+	return err
+}
+
 func writeControlFile(path string, info ControlInfo) error {
 	os.MkdirAll(filepath.Dir(path), 0755)
 	data, err := json.Marshal(info)
@@ -310,20 +883,3 @@ func writeControlFile(path string, info ControlInfo) error {
 	}
 	return os.WriteFile(path, data, 0644)
 }
-
-// extractPipePath parses "cat >> /path/to/file" and returns the file path.
-func extractPipePath(cmd string) string {
-	cmd = strings.TrimSpace(cmd)
-	if !strings.HasPrefix(cmd, "cat") {
-		return ""
-	}
-	cmd = strings.TrimPrefix(cmd, "cat")
-	cmd = strings.TrimSpace(cmd)
-	if !strings.HasPrefix(cmd, ">>") {
-		return ""
-	}
-	cmd = strings.TrimPrefix(cmd, ">>")
-	cmd = strings.TrimSpace(cmd)
-	cmd = strings.Trim(cmd, "'\"")
-	return cmd
-}
@@ -0,0 +1,2305 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/proc"
+	"wintmux/internal/pty"
+	"wintmux/internal/pty/ptytest"
+	"wintmux/internal/screen"
+	"wintmux/internal/secret"
+	"wintmux/internal/vt"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testDaemon returns a daemon running a fake pane terminal.
+func testDaemon(t *testing.T) (*Daemon, *ptytest.Terminal) {
+	t.Helper()
+	d := newDaemon(filepath.Join(t.TempDir(), "s.sock"), "test", t.TempDir(), "fake", 40, 5)
+	term := ptytest.New(40, 5, 1)
+	d.startChild(term)
+	t.Cleanup(func() { term.Close() })
+	return d, term
+}
+
+// serve makes d answer requests on a loopback port, as Run does.
+func serve(t *testing.T, d *Daemon) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.listeners = append(d.listeners, ln)
+	if err := writeControlFile(d.socketPath, ControlInfo{PID: os.Getpid(), State: "ready", Addrs: []string{ln.Addr().String()}}); err != nil {
+		t.Fatal(err)
+	}
+	go d.acceptConnections()
+	t.Cleanup(d.closeListeners)
+}
+
+// eventually fails the test if cond does not hold within a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func capture(d *Daemon) string {
+	return d.dispatch(ipc.Request{Action: ipc.ActionCapture}, nil).Output
+}
+
+func TestCaptureFakeOutput(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("one\r\ntwo\r\n")
+	eventually(t, "output on screen", func() bool { return strings.HasPrefix(capture(d), "one\ntwo") })
+}
+
+func TestCaptureAll(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("\x1b[?1049hmenu\x1b[2;3H")
+	var panes []ipc.PaneCapture
+	eventually(t, "alternate screen", func() bool {
+		resp := d.dispatch(ipc.Request{Action: ipc.ActionCaptureAll}, nil)
+		var err error
+		panes, err = ipc.ParseCaptures(resp.Output)
+		return err == nil && len(panes) == 1 && panes[0].Alternate && panes[0].CursorY == 1
+	})
+	p := panes[0]
+	if p.Session != "test" || p.PaneID != d.paneID() || p.Width != 40 || p.Height != 5 || p.CursorX != 2 || p.Lines[0] != "menu" {
+		t.Errorf("pane = %+v", p)
+	}
+}
+
+func TestSendKeysReachTerminal(t *testing.T) {
+	d, term := testDaemon(t)
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionSendKeys, Text: "ls", SendEnter: true}, nil)
+	if !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if got := term.Input(); got != "ls\r" {
+		t.Errorf("input = %q, want %q", got, "ls\r")
+	}
+}
+
+func TestPipePane(t *testing.T) {
+	d, term := testDaemon(t)
+	path := filepath.Join(t.TempDir(), "pane.log")
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipePane, ShellCmd: "cat >> " + path}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("logged\r\n")
+	eventually(t, "pipe-pane output", func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == "logged\r\n"
+	})
+	d.dispatch(ipc.Request{Action: ipc.ActionPipePane}, nil)
+}
+
+func TestPipePaneFormats(t *testing.T) {
+	d, term := testDaemon(t)
+	dir := t.TempDir()
+	req := ipc.Request{Action: ipc.ActionPipePane, ShellCmd: "cat >> " + filepath.Join(dir, "#{session_name}-#{window_index}.#{pane_index}.log")}
+	if resp := d.dispatch(req, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("logged\r\n")
+	eventually(t, "output in the expanded path", func() bool {
+		data, _ := os.ReadFile(filepath.Join(dir, "test-0.0.log"))
+		return string(data) == "logged\r\n"
+	})
+	d.dispatch(ipc.Request{Action: ipc.ActionPipePane}, nil)
+
+	term.Output("$(reboot)")
+	eventually(t, "cursor line", func() bool { return strings.Contains(d.screen.CursorLine(), "reboot") })
+	if got := d.expandCommand("echo #{pane_id} #{cursor_line} ##"); got != "echo "+d.paneID()+"  #" {
+		t.Errorf("expanded command = %q", got)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	d, _ := testDaemon(t)
+	d.id = 42
+	d.usage.Store(&paneUsage{foreground: "vim"})
+	if got := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{session_id} #{window_id} #{pane_id}"}, nil).Output; got != "$42 @42 %42" {
+		t.Errorf("IDs = %q", got)
+	}
+	for _, target := range []string{"test", "$42", "%42", "@42", "test:0.0", "test:@42.%42", ":0", ":.%42", "$42:vim", "0", "0.0", "vim.0", "@42.0"} {
+		if err := d.checkTarget(target); err != nil {
+			t.Errorf("target %q: %v", target, err)
+		}
+	}
+	// tmux's shorthand: name prefixes and patterns, = for an exact name,
+	// tokens and offsets, which wrap round to the one window and pane.
+	for _, target := range []string{"te", "t*", "=test:=vim", "test:v*.0", "te:^", "test:$", "test:{end}", ":+", ":-2.+1", "{next}", "-", "{top}", "top-left", ":.{bottom-right}", "$42:{previous}.{next}"} {
+		if err := d.checkTarget(target); err != nil {
+			t.Errorf("target %q: %v", target, err)
+		}
+	}
+	for target, want := range map[string]string{
+		"other":      "can't find session: other",
+		"$41":        "can't find session: $41",
+		"%0":         "can't find pane: %0",
+		"@1":         "can't find window: @1",
+		"other:0.0":  "can't find session: other",
+		"test:1":     "can't find window: 1",
+		"test:0.1":   "can't find pane: 1",
+		"test:0.%41": "can't find pane: %41",
+		"=tes:0":     "can't find session: =tes",
+		"test:!":     "can't find window: !",
+		"{last}":     "can't find window: {last}",
+		":0.{up-of}": "can't find pane: {up-of}",
+		"test:+x":    "can't find window: +x",
+		"{marked}":   "no marked target",
+	} {
+		if err := d.checkTarget(target); err == nil || err.Error() != want {
+			t.Errorf("target %q: %v, want %s", target, err, want)
+		}
+	}
+	d.marked.Store(true)
+	for _, target := range []string{"{marked}", "~"} {
+		if err := d.checkTarget(target); err != nil {
+			t.Errorf("target %q: %v", target, err)
+		}
+	}
+
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionHasSession, Target: "other"}, nil); resp.OK || resp.Exists {
+		t.Errorf("has-session on another session's name: %+v", resp)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionHasSession, Target: "%42"}, nil); !resp.Exists {
+		t.Errorf("has-session on the pane ID: %+v", resp)
+	}
+}
+
+func TestMarkPane(t *testing.T) {
+	d, _ := testDaemon(t)
+	marked := func() string {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_marked}#{pane_marked_set}#{window_flags}"}, nil).Output
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSelectPane, Target: "{marked}"}, nil); resp.Error != "no marked target" {
+		t.Errorf("{marked} before marking: %+v", resp)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSelectPane, Mark: true}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if got := marked(); got != "11M" {
+		t.Errorf("marked pane formats = %q", got)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Target: "{marked}", Format: "#{pane_id}"}, nil); resp.Output != d.paneID() {
+		t.Errorf("display-message -t {marked}: %+v", resp)
+	}
+	// Marking the marked pane clears the mark, as -M does.
+	d.dispatch(ipc.Request{Action: ipc.ActionSelectPane, Mark: true}, nil)
+	if got := marked(); got != "00" {
+		t.Errorf("after -m again = %q", got)
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionSelectPane, Mark: true}, nil)
+	d.dispatch(ipc.Request{Action: ipc.ActionSelectPane, Unmark: true}, nil)
+	if got := marked(); got != "00" {
+		t.Errorf("after -M = %q", got)
+	}
+}
+
+func TestPipePaneClean(t *testing.T) {
+	d, term := testDaemon(t)
+	path := filepath.Join(t.TempDir(), "pane.log")
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipePane, ShellCmd: "cat >> " + path, Clean: true}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("\x1b[32mok\x1b[0m\r\n10%\r")
+	term.Output("100%\r\nunfinished")
+	eventually(t, "clean lines", func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == "ok\n100%\n"
+	})
+	// Stopping the pipe writes out the unfinished line.
+	d.dispatch(ipc.Request{Action: ipc.ActionPipePane}, nil)
+	if data, _ := os.ReadFile(path); string(data) != "ok\n100%\nunfinished" {
+		t.Errorf("after stop: %q", data)
+	}
+}
+
+func TestPipePaneRotate(t *testing.T) {
+	d, term := testDaemon(t)
+	path := filepath.Join(t.TempDir(), "pane.log")
+	req := ipc.Request{Action: ipc.ActionPipePane, ShellCmd: "cat >> " + path, Clean: true, RotateSize: 10, Keep: 2}
+	if resp := d.dispatch(req, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("line1\nline2\nline3\nline4\n")
+	eventually(t, "rotation", func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == "line4\n"
+	})
+	for suffix, want := range map[string]string{".1": "line3\n", ".2": "line2\n"} {
+		if data, _ := os.ReadFile(path + suffix); string(data) != want {
+			t.Errorf("%s%s = %q, want %q", filepath.Base(path), suffix, data, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("kept more than 2 old files")
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionPipePane}, nil)
+}
+
+func TestPipePaneTimestamps(t *testing.T) {
+	stamp := `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}(Z|[+-]\d\d:\d\d) `
+	for _, clean := range []bool{false, true} {
+		d, term := testDaemon(t)
+		path := filepath.Join(t.TempDir(), "pane.log")
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipePane, ShellCmd: "cat >> " + path, Clean: clean, Timestamps: true}, nil); !resp.OK {
+			t.Fatal(resp.Error)
+		}
+		// The second line arrives split across reads; it gets one stamp.
+		term.Output("first\nsec")
+		term.OutputAfter(10*time.Millisecond, "ond\n")
+		want := regexp.MustCompile(`^` + stamp + `first\n` + stamp + `second\n$`)
+		eventually(t, "timestamped lines", func() bool {
+			data, _ := os.ReadFile(path)
+			return want.Match(data)
+		})
+		d.dispatch(ipc.Request{Action: ipc.ActionPipePane}, nil)
+	}
+}
+
+func TestPipeAddKeepsSinks(t *testing.T) {
+	d, term := testDaemon(t)
+	dir := t.TempDir()
+	raw, clean := filepath.Join(dir, "raw.log"), filepath.Join(dir, "clean.log")
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, ShellCmd: "cat >> " + raw}, nil); !resp.OK || resp.Output != "p1" {
+		t.Fatalf("pipe-add: %+v", resp)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, Name: "text", ShellCmd: "cat >> " + clean, Clean: true}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, Name: "text", Events: true}, nil); resp.OK {
+		t.Error("duplicate pipe name accepted")
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, Name: "ev", Events: true}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	// pipe-pane replaces only its own sink.
+	for i := 0; i < 2; i++ {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipePane, ShellCmd: "cat >> " + filepath.Join(dir, "pp.log")}, nil); !resp.OK {
+			t.Fatal(resp.Error)
+		}
+	}
+	list := d.dispatch(ipc.Request{Action: ipc.ActionPipeList}, nil).Output
+	if n := strings.Count(list, "\n") + 1; n != 4 || !strings.HasPrefix(list, "p1 file ") {
+		t.Errorf("pipe-list:\n%s", list)
+	}
+
+	since := d.events.last()
+	term.Output("\x1b[1mone\x1b[0m\r\n")
+	eventually(t, "both files", func() bool {
+		r, _ := os.ReadFile(raw)
+		c, _ := os.ReadFile(clean)
+		return string(r) == "\x1b[1mone\x1b[0m\r\n" && string(c) == "one\n"
+	})
+	eventually(t, "pipe event", func() bool {
+		evs, _ := d.events.after(since, "pipe")
+		return len(evs) == 1 && evs[0].text == "\x1b[1mone\x1b[0m" && evs[0].vars["pipe_name"] == "ev"
+	})
+
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeRemove, Name: "p1"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeRemove, Name: "p1"}, nil); resp.OK {
+		t.Error("removed p1 twice")
+	}
+	term.Output("two\n")
+	eventually(t, "second line", func() bool {
+		c, _ := os.ReadFile(clean)
+		return string(c) == "one\ntwo\n"
+	})
+	if r, _ := os.ReadFile(raw); strings.Contains(string(r), "two") {
+		t.Error("removed sink still written")
+	}
+}
+
+func TestPipeCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	d, term := testDaemon(t)
+	path := filepath.Join(t.TempDir(), "out")
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, ShellCmd: "tr a-z A-Z > " + path}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("shout\n")
+	eventually(t, "pane output sent", func() bool {
+		return strings.Contains(d.dispatch(ipc.Request{Action: ipc.ActionPipeList}, nil).Output, "written=6")
+	})
+	// Removing the sink closes the command's stdin, so tr finishes.
+	d.dispatch(ipc.Request{Action: ipc.ActionPipeRemove, Name: "p1"}, nil)
+	eventually(t, "command output", func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == "SHOUT\n"
+	})
+}
+
+func TestMirrorPane(t *testing.T) {
+	src, term := testDaemon(t)
+	view, _ := testDaemon(t)
+	view.sessionName = "view"
+	serve(t, view)
+
+	if resp := src.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, Name: "raw", MirrorTo: view.socketPath}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("\x1b[31mred\x1b[0m\r\n")
+	eventually(t, "raw output in the view", func() bool { return strings.HasPrefix(capture(view), "red\n") })
+	src.dispatch(ipc.Request{Action: ipc.ActionPipeRemove, Name: "raw"}, nil)
+
+	if resp := src.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, Clean: true, MirrorTo: view.socketPath}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("50%\rdone\n")
+	eventually(t, "labelled line in the view", func() bool { return strings.HasPrefix(capture(view), "red\n[test] done\n") })
+
+	// Mirrored output is not passed on to the view's own sinks.
+	path := filepath.Join(t.TempDir(), "view.log")
+	view.dispatch(ipc.Request{Action: ipc.ActionPipePane, ShellCmd: "cat >> " + path}, nil)
+	view.dispatch(ipc.Request{Action: ipc.ActionMirrorOutput, Data: []byte("x\r\n")}, nil)
+	view.dispatch(ipc.Request{Action: ipc.ActionPipePane}, nil)
+	if data, _ := os.ReadFile(path); len(data) > 0 {
+		t.Errorf("view's pipe got mirrored output %q", data)
+	}
+
+	if resp := src.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, MirrorTo: src.socketPath}, nil); resp.OK {
+		t.Error("mirroring a session into itself accepted")
+	}
+}
+
+func TestBridge(t *testing.T) {
+	d, term := testDaemon(t)
+	serve(t, d)
+	term.Output("before")
+	eventually(t, "output", func() bool { return strings.Contains(capture(d), "before") })
+
+	conn, err := ipc.Connect(d.socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionBridge, Client: "embedder"}); err != nil {
+		t.Fatal(err)
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil || !resp.OK {
+		t.Fatalf("bridge: %v %+v", err, resp)
+	}
+	if resp.Output != "" {
+		t.Errorf("bridge reply carries a repaint: %q", resp.Output)
+	}
+
+	term.Output("\x1b[1mafter")
+	var ev ipc.Response
+	if err := ipc.ReadMessage(conn, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if string(ev.Data) != "\x1b[1mafter" {
+		t.Errorf("event data = %q, want raw output", ev.Data)
+	}
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionSendKeys, Data: []byte("ls\r")}); err != nil {
+		t.Fatal(err)
+	}
+	if !term.WaitInput("ls\r", time.Second) {
+		t.Errorf("input = %q", term.Input())
+	}
+}
+
+func TestAttachColors(t *testing.T) {
+	d, term := testDaemon(t)
+	serve(t, d)
+
+	conn, err := ipc.Connect(d.socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionAttach, Client: "xterm", Width: 80, Height: 24, Colors: "256"}); err != nil {
+		t.Fatal(err)
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil || !resp.OK {
+		t.Fatalf("attach: %v %+v", err, resp)
+	}
+
+	term.Output("\x1b[1;38;2;255;0;0mred")
+	var ev ipc.Response
+	if err := ipc.ReadMessage(conn, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x1b[1;38;5;196mred"; string(ev.Data) != want {
+		t.Errorf("event data = %q, want %q", ev.Data, want)
+	}
+	if !strings.Contains(capture(d), "red") {
+		t.Error("the pane's own screen lost the output")
+	}
+	resp = d.dispatch(ipc.Request{Action: ipc.ActionListClients, Format: "#{client_name} #{client_colors}"}, nil)
+	if !strings.Contains(resp.Output, "xterm 256") {
+		t.Errorf("list-clients = %q", resp.Output)
+	}
+
+	bad, err := ipc.Connect(d.socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	ipc.WriteMessage(bad, ipc.Request{Action: ipc.ActionAttach, Colors: "88"})
+	if err := ipc.ReadMessage(bad, &resp); err != nil || resp.OK {
+		t.Errorf("attach with 88 colors = %v %+v", err, resp)
+	}
+}
+
+func TestDeltaView(t *testing.T) {
+	var v deltaView
+	cur := screen.CursorState{X: 2, Y: 1, Visible: true}
+	if got := string(v.update([]string{"top", "$ ", ""}, cur, vt.KeyMode{})); got != "\x1b[H\x1b[2J\x1b[1;1Htop\x1b[K\x1b[2;1H$ \x1b[K\x1b[2;3H" {
+		t.Errorf("first frame = %q", got)
+	}
+	if got := v.update([]string{"top", "$ ", ""}, cur, vt.KeyMode{}); len(got) != 0 {
+		t.Errorf("unchanged frame = %q", got)
+	}
+	cur.X = 4
+	if got := string(v.update([]string{"top", "$ ls", ""}, cur, vt.KeyMode{})); got != "\x1b[2;1H$ ls\x1b[K\x1b[2;5H" {
+		t.Errorf("changed row = %q", got)
+	}
+	cur.Visible = false
+	if got := string(v.update([]string{"top", "$ ls", ""}, cur, vt.KeyMode{CursorKeys: true})); got != "\x1b[?25l"+vt.ResetKeyMode+"\x1b[?1h" {
+		t.Errorf("modes = %q", got)
+	}
+	if got := string(v.update([]string{"top", "$ ls"}, cur, vt.KeyMode{CursorKeys: true})); !strings.HasPrefix(got, "\x1b[H\x1b[2J") {
+		t.Errorf("resized screen not redrawn: %q", got)
+	}
+}
+
+func TestAttachDelta(t *testing.T) {
+	d, term := testDaemon(t)
+	serve(t, d)
+	term.Output("$ ")
+	eventually(t, "prompt", func() bool { return strings.HasPrefix(capture(d), "$") })
+
+	conn, err := ipc.Connect(d.socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionAttach, Client: "watcher", Delta: true, Compress: true}); err != nil {
+		t.Fatal(err)
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil || !resp.OK || resp.Output != "\x1b[H\x1b[2J\x1b[1;1H$\x1b[K\x1b[1;3H" {
+		t.Fatalf("attach: %v %+v", err, resp)
+	}
+
+	// A burst of output arrives as a few frames holding the final rows,
+	// not as the bytes written.
+	for i := 0; i < 50; i++ {
+		term.Output(fmt.Sprintf("\rline %d", i))
+	}
+	term.Output("\r\n$ ")
+	var frames []string
+	for !strings.HasSuffix(strings.Join(frames, ""), "\x1b[2;1H$\x1b[K\x1b[2;3H") {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var ev ipc.Response
+		if err := ipc.ReadMessage(conn, &ev); err != nil {
+			t.Fatalf("after %q: %v", frames, err)
+		}
+		frames = append(frames, string(ev.Data))
+	}
+	if len(frames) > 10 {
+		t.Errorf("%d frames for one burst", len(frames))
+	}
+	if !strings.Contains(strings.Join(frames, ""), "\x1b[1;1Hline 49\x1b[K") {
+		t.Errorf("frames = %q", frames)
+	}
+}
+
+func TestAttachRestoresModes(t *testing.T) {
+	d, term := testDaemon(t)
+	serve(t, d)
+	term.Output("\x1b[?1049h\x1b[?1002h\x1b[?1006h\x1b[?2004hmenu")
+	eventually(t, "modes", func() bool { return d.screen.KeyMode().BracketedPaste })
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{mouse_any_flag}#{mouse_button_flag}#{mouse_sgr_flag}"}, nil).Output; out != "111" {
+		t.Errorf("mouse flags = %q", out)
+	}
+
+	conn, err := ipc.Connect(d.socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionAttach, Client: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil || !resp.OK {
+		t.Fatalf("attach: %v %+v", err, resp)
+	}
+	if !strings.HasSuffix(resp.Output, "\x1b[?1002h\x1b[?1006h\x1b[?2004h") {
+		t.Errorf("repaint does not restore the mouse and paste modes: %q", resp.Output)
+	}
+	// The application on the alternate screen is made to redraw, and the
+	// pane keeps its size.
+	eventually(t, "redraw resizes", func() bool { _, _, n := term.Size(); return n == 2 })
+	if cols, rows, _ := term.Size(); cols != 40 || rows != 5 {
+		t.Errorf("pane left at %dx%d", cols, rows)
+	}
+}
+
+func TestSendKeyExtended(t *testing.T) {
+	d, term := testDaemon(t)
+	send := func(key string) {
+		t.Helper()
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSendKey, Key: key}, nil); !resp.OK {
+			t.Fatalf("send-keys %s: %s", key, resp.Error)
+		}
+	}
+	send("C-S-a")
+	term.Output("\x1b[>4;2m")
+	eventually(t, "key mode", func() bool { return d.screen.KeyMode().ModifyOtherKeys == 2 })
+	if mode := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_key_mode}"}, nil).Output; mode != "Ext 2" {
+		t.Errorf("pane_key_mode = %q", mode)
+	}
+	send("C-S-a")
+	term.Output("\x1b[>1u")
+	eventually(t, "kitty mode", func() bool { return d.screen.KeyMode().Kitty == 1 })
+	send("C-S-a")
+	if want := "\x01\x1b[27;6;97~\x1b[97;6u"; !term.WaitInput(want, time.Second) {
+		t.Errorf("input = %q, want %q", term.Input(), want)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSendKey, Key: "C-Bogus"}, nil); resp.OK {
+		t.Error("unknown key accepted")
+	}
+	if repaint := d.repaint(); !strings.HasSuffix(repaint, "\x1b[>4;2m\x1b[>1u") {
+		t.Errorf("repaint does not restore the key mode: %q", repaint)
+	}
+}
+
+func TestSendKeyApplicationModes(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("\x1b[?1h\x1b=")
+	eventually(t, "application modes", func() bool { m := d.screen.KeyMode(); return m.CursorKeys && m.Keypad })
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{keypad_cursor_flag}#{keypad_flag}"}, nil).Output; out != "11" {
+		t.Errorf("flags = %q", out)
+	}
+	for _, key := range []string{"Up", "KP8", "Left"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSendKey, Key: key}, nil); !resp.OK {
+			t.Fatalf("send-keys %s: %s", key, resp.Error)
+		}
+	}
+	if want := "\x1bOA\x1bOx\x1bOD"; !term.WaitInput(want, time.Second) {
+		t.Errorf("input = %q, want %q", term.Input(), want)
+	}
+	if repaint := d.repaint(); !strings.HasSuffix(repaint, "\x1b[?1h\x1b=") {
+		t.Errorf("repaint does not restore the modes: %q", repaint)
+	}
+}
+
+// clientRequest runs req from client as serveRequest does, with the
+// client's locks and access checked first.
+func clientRequest(d *Daemon, client string, req ipc.Request) ipc.Response {
+	req.Client = client
+	defer d.clients.begin(client, "test", req.Action)()
+	if err := d.clients.check(client, req); err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	return d.dispatch(req, nil)
+}
+
+func TestUnlockClient(t *testing.T) {
+	d, _ := testDaemon(t)
+	clientRequest(d, "admin", ipc.Request{Action: ipc.ActionLockClient, TargetClient: "agent"})
+	if resp := clientRequest(d, "agent", ipc.Request{Action: ipc.ActionUnlockClient}); resp.OK {
+		t.Error("locked client unlocked itself")
+	}
+	if resp := clientRequest(d, "agent", ipc.Request{Action: ipc.ActionSendText, Text: "x"}); resp.OK {
+		t.Error("locked client sent input")
+	}
+	clientRequest(d, "admin", ipc.Request{Action: ipc.ActionSuspendClient, TargetClient: "agent"})
+	if resp := clientRequest(d, "agent", ipc.Request{Action: ipc.ActionUnlockClient, TargetClient: "agent"}); resp.OK {
+		t.Error("suspended client unlocked itself")
+	}
+	if resp := clientRequest(d, "admin", ipc.Request{Action: ipc.ActionUnlockClient, TargetClient: "agent"}); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := clientRequest(d, "agent", ipc.Request{Action: ipc.ActionSendText, Text: "x"}); !resp.OK {
+		t.Errorf("unlocked client: %s", resp.Error)
+	}
+
+	// Exclusive input is released only by its owner.
+	clientRequest(d, "admin", ipc.Request{Action: ipc.ActionLockClient, All: true})
+	if resp := clientRequest(d, "agent", ipc.Request{Action: ipc.ActionUnlockClient, All: true}); resp.Error != "input is locked by client admin" {
+		t.Errorf("another client released exclusive input: %+v", resp)
+	}
+	if resp := clientRequest(d, "agent", ipc.Request{Action: ipc.ActionSendText, Text: "x"}); resp.OK {
+		t.Error("input from another client under exclusive input")
+	}
+	if resp := clientRequest(d, "admin", ipc.Request{Action: ipc.ActionUnlockClient, All: true}); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := clientRequest(d, "agent", ipc.Request{Action: ipc.ActionSendText, Text: "x"}); !resp.OK {
+		t.Errorf("after release: %s", resp.Error)
+	}
+}
+
+func TestLockedClientCommands(t *testing.T) {
+	d, term := testDaemon(t)
+	clientRequest(d, "admin", ipc.Request{Action: ipc.ActionLockClient, TargetClient: "agent"})
+	for _, req := range []ipc.Request{
+		{Action: ipc.ActionRespawn, Kill: true, ShellCmd: "evil"},
+		{Action: ipc.ActionExec, ShellCmd: "evil"},
+		{Action: ipc.ActionPipePane, ShellCmd: "evil"},
+		{Action: ipc.ActionPipeAdd, ShellCmd: "evil"},
+		{Action: ipc.ActionWatchAdd, Pattern: "x", Hook: "evil"},
+		{Action: ipc.ActionSetOption, Option: "alert-bell-hook", Value: "evil"},
+		{Action: ipc.ActionSetOption, Option: "stuck-probe", Value: "evil"},
+	} {
+		if resp := clientRequest(d, "agent", req); resp.Error != "client agent is locked" {
+			t.Errorf("%s %+v from a locked client: %+v", req.Action, req, resp)
+		}
+	}
+	if d.term() != pty.Terminal(term) || len(d.pipes) != 0 || len(d.watches.watches) != 0 || d.option("alert-bell-hook") != "" || d.option("stuck-probe") != "" {
+		t.Error("a locked client's request took effect")
+	}
+
+	// Requests that run nothing are not input.
+	for _, req := range []ipc.Request{
+		{Action: ipc.ActionPipePane},
+		{Action: ipc.ActionWatchAdd, Pattern: "x"},
+		{Action: ipc.ActionSetOption, Option: "alert-cpu", Value: "90"},
+	} {
+		if resp := clientRequest(d, "agent", req); !resp.OK {
+			t.Errorf("%s %+v from a locked client: %s", req.Action, req, resp.Error)
+		}
+	}
+	for name := range sessionOptions {
+		if strings.HasSuffix(name, "-hook") && !commandOptions[name] {
+			t.Errorf("%s is not in commandOptions", name)
+		}
+	}
+}
+
+func TestSendText(t *testing.T) {
+	d, term := testDaemon(t)
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "record-input", Value: "on"}, nil)
+	term.Output("\x1b[>9u")
+	eventually(t, "kitty mode", func() bool { return d.screen.KeyMode().Kitty == 9 })
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSendText, Text: "你好 😀"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if !term.WaitInput("你好 😀", time.Second) {
+		t.Errorf("input = %q", term.Input())
+	}
+	for _, text := range []string{"", "ls\r", "\x1b[A", "\xff"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSendText, Text: text}, nil); resp.OK {
+			t.Errorf("send-text %q accepted", text)
+		}
+	}
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionInputHistory, Format: "#{input_kind} #{input_data}"}, nil).Output; out != `ime "你好 😀"` {
+		t.Errorf("input history = %q", out)
+	}
+}
+
+func TestRunPS(t *testing.T) {
+	d, term := testDaemon(t)
+	run := func(command string) {
+		t.Helper()
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionRunPS, ShellCmd: command}, nil); !resp.OK {
+			t.Fatal(resp.Error)
+		}
+	}
+	term.Output("PS C:\\> ")
+	run("Get-Process")
+	if !term.WaitInput("Get-Process\r", time.Second) {
+		t.Fatalf("input = %q", term.Input())
+	}
+
+	// An unfinished statement is cancelled before the command is typed.
+	term.Output("\r\n>> ")
+	eventually(t, "continuation prompt", d.atContinuation)
+	term.OutputAfter(100*time.Millisecond, "\r\nPS C:\\> ")
+	run("Get-Date")
+	if !term.WaitInput("Get-Process\r\x03Get-Date\r", time.Second) {
+		t.Fatalf("input = %q", term.Input())
+	}
+
+	script := "if ($x) {\n\t'yes'\n}"
+	run(script)
+	want := ". ([scriptblock]::Create([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('" +
+		base64.StdEncoding.EncodeToString([]byte(script)) + "'))))\r"
+	if !term.WaitInput(want, time.Second) {
+		t.Fatalf("input = %q", term.Input())
+	}
+	term.Output("\x1b[?2004h")
+	eventually(t, "bracketed paste", d.screen.BracketedPaste)
+	run(script)
+	if !term.WaitInput("\x1b[200~if ($x) {\r\t'yes'\r}\x1b[201~\r", time.Second) {
+		t.Fatalf("input = %q", term.Input())
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionRunPS}, nil); resp.OK {
+		t.Error("empty command accepted")
+	}
+}
+
+func TestMacros(t *testing.T) {
+	d, term := testDaemon(t)
+	record := func(mode, name string) ipc.Response {
+		return d.dispatch(ipc.Request{Action: ipc.ActionRecordKeys, Option: mode, Name: name}, nil)
+	}
+	if resp := record("start", "greet"); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := record("start", "other"); resp.OK {
+		t.Error("second recording started")
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionSendKeys, Text: "echo "}, nil)
+	d.dispatch(ipc.Request{Action: ipc.ActionSendText, Text: "héllo"}, nil)
+	d.dispatch(ipc.Request{Action: ipc.ActionSendKey, Key: "Enter"}, nil)
+	if !term.WaitInput("echo héllo\r", time.Second) {
+		t.Fatalf("input = %q", term.Input())
+	}
+	if resp := record("stop", ""); !resp.OK || resp.Output != "recorded greet: 3 events, 12 bytes" {
+		t.Fatalf("stop = %+v", resp)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPlayKeys, Name: "greet", Count: 2}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if !term.WaitInput("echo héllo\recho héllo\recho héllo\r", time.Second) {
+		t.Errorf("input = %q", term.Input())
+	}
+	if out := record("list", "").Output; !strings.HasPrefix(out, "greet ") || !strings.HasSuffix(out, " 3 events, 12 bytes") {
+		t.Errorf("list = %q", out)
+	}
+
+	record("start", "empty")
+	if resp := record("stop", "empty"); resp.OK {
+		t.Error("empty macro saved")
+	}
+	for _, req := range []ipc.Request{
+		{Action: ipc.ActionPlayKeys, Name: "missing"},
+		{Action: ipc.ActionPlayKeys, Name: "greet", Count: maxPlayCount + 1},
+		{Action: ipc.ActionRecordKeys, Option: "stop"},
+		{Action: ipc.ActionRecordKeys, Option: "start"},
+		{Action: ipc.ActionRecordKeys, Option: "delete", Name: "missing"},
+	} {
+		if resp := d.dispatch(req, nil); resp.OK {
+			t.Errorf("%s %q accepted", req.Action, req.Name)
+		}
+	}
+	if resp := record("delete", "greet"); !resp.OK || record("list", "").Output != "" {
+		t.Errorf("delete = %+v", resp)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	d, _ := testDaemon(t)
+	ran := make(chan string, 10)
+	run := runScheduled
+	t.Cleanup(func() { runScheduled = run })
+	runScheduled = func(d *Daemon, name string, args []string) error {
+		ran <- name + ": " + strings.Join(args, " ")
+		return nil
+	}
+	add := func(req ipc.Request) ipc.Response {
+		req.Action = ipc.ActionScheduleAdd
+		return d.dispatch(req, nil)
+	}
+	if resp := add(ipc.Request{Args: []string{"send-keys", "continue", "Enter"}, DelayMs: 10}); !resp.OK || resp.Output != "s1" {
+		t.Fatalf("add = %+v", resp)
+	}
+	if resp := add(ipc.Request{Name: "nudge", Args: []string{"display-message", "hi"}, DelayMs: 10, IntervalMs: 60000}); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := add(ipc.Request{Name: "nightly", Args: []string{"kill-session"}, Cron: "0 3 * * *"}); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case r := <-ran:
+			got[r] = true
+		case <-time.After(time.Second):
+			t.Fatalf("ran %v", got)
+		}
+	}
+	if !got["s1: send-keys continue Enter"] || !got["nudge: display-message hi"] {
+		t.Errorf("ran %v", got)
+	}
+	var list string
+	eventually(t, "run recorded", func() bool {
+		list = d.dispatch(ipc.Request{Action: ipc.ActionScheduleList, Format: "#{schedule_name} #{schedule_when} #{schedule_runs}"}, nil).Output
+		return strings.HasPrefix(list, "nudge every 1m0s 1\n")
+	})
+	if list != "nudge every 1m0s 1\nnightly cron \"0 3 * * *\" 0" {
+		t.Errorf("list = %q", list)
+	}
+	if evs, _ := d.events.after(0, "schedule"); len(evs) != 2 {
+		t.Errorf("%d schedule events", len(evs))
+	}
+
+	for _, req := range []ipc.Request{
+		{Args: []string{"ping"}},
+		{DelayMs: 10},
+		{Args: []string{"ping"}, IntervalMs: 10},
+		{Args: []string{"ping"}, Cron: "0 3 * *"},
+		{Args: []string{"ping"}, Cron: "0 3 30 2 *"},
+		{Args: []string{"ping"}, Cron: "@daily", DelayMs: 10},
+		{Name: "nudge", Args: []string{"ping"}, DelayMs: 10},
+	} {
+		if resp := add(req); resp.OK {
+			t.Errorf("schedule %+v accepted", req)
+		}
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionScheduleRemove, Name: "nudge"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionScheduleRemove, Name: "nudge"}, nil); resp.OK {
+		t.Error("removed twice")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	d, term := testDaemon(t)
+	add := func(req ipc.Request) ipc.Response {
+		req.Action = ipc.ActionRedactAdd
+		return d.dispatch(req, nil)
+	}
+	if resp := add(ipc.Request{Pattern: `sk-[a-z0-9]+`}); !resp.OK || resp.Output != "r1" {
+		t.Fatalf("redact-add = %+v", resp)
+	}
+	if resp := add(ipc.Request{Name: "pw", Pattern: `(password=)\S+`, Replace: "${1}***"}); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	path := filepath.Join(t.TempDir(), "pane.log")
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, ShellCmd: "cat >> " + path, Clean: true}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "record-input", Value: "on"}, nil)
+	d.dispatch(ipc.Request{Action: ipc.ActionSendKeys, Text: "login password=hunter2\r"}, nil)
+
+	term.Output("key sk-abc123\r\n")
+	want := "key [REDACTED]"
+	eventually(t, "redacted capture", func() bool { return strings.HasPrefix(capture(d), want) })
+	eventually(t, "redacted pipe", func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == want+"\n"
+	})
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionCapture, Strip: "text"}, nil).Output; !strings.Contains(out, want) || strings.Contains(out, "abc123") {
+		t.Errorf("history capture = %q", out)
+	}
+	term.Output("\x1b]8;;https://ci/log?key=sk-def456\x1b\\sk-def456\x1b]8;;\x1b\\\r\n")
+	eventually(t, "link", func() bool { return strings.Contains(capture(d), "\n[REDACTED]") })
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionListLinks}, nil).Output; out != "https://ci/log?key=[REDACTED] [REDACTED]" {
+		t.Errorf("list-links = %q", out)
+	}
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionInputHistory, Format: "#{input_data}"}, nil).Output; out != `"login password=***\r"` {
+		t.Errorf("input history = %q", out)
+	}
+	// The pane itself, and what replay sends, are not redacted.
+	if !term.WaitInput("login password=hunter2\r", time.Second) {
+		t.Errorf("input = %q", term.Input())
+	}
+
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionRedactList}, nil).Output; out != `r1 /sk-[a-z0-9]+/ "[REDACTED]"`+"\n"+`pw /(password=)\S+/ "${1}***"` {
+		t.Errorf("redact-list = %q", out)
+	}
+	for _, req := range []ipc.Request{
+		{},
+		{Pattern: "("},
+		{Pattern: "x*"},
+		{Name: "pw", Pattern: "x"},
+	} {
+		if resp := add(req); resp.OK {
+			t.Errorf("redact-add %+v accepted", req)
+		}
+	}
+	for _, name := range []string{"r1", "pw"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionRedactRemove, Name: name}, nil); !resp.OK {
+			t.Fatal(resp.Error)
+		}
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionRedactRemove, Name: "pw"}, nil); resp.OK {
+		t.Error("removed twice")
+	}
+	if out := capture(d); !strings.HasPrefix(out, "key sk-abc123") {
+		t.Errorf("capture after remove = %q", out)
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionPipeRemove, Name: "p1"}, nil)
+}
+
+func TestRedactStream(t *testing.T) {
+	d, term := testDaemon(t)
+	serve(t, d)
+	r := redactStream{d: d}
+	if got := r.write([]byte("key sk-ab")); string(got) != "key sk-ab" {
+		t.Errorf("without rules = %q", got)
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionRedactAdd, Pattern: `sk-[a-z0-9]+`}, nil)
+	if got := r.write([]byte("key sk-ab")); len(got) != 0 {
+		t.Errorf("unfinished line = %q", got)
+	}
+	if got := r.write([]byte("c123\r\nnext sk-x")); string(got) != "key [REDACTED]\r\n" {
+		t.Errorf("finished line = %q", got)
+	}
+	if got := r.flush(); string(got) != "next [REDACTED]" {
+		t.Errorf("flush = %q", got)
+	}
+
+	// A secret split between two reads of the pane is caught in a raw
+	// pipe, an attached terminal's repaint and its output stream.
+	term.Output("old sk-abc123\r\n")
+	eventually(t, "output", func() bool { return strings.HasPrefix(capture(d), "old [REDACTED]") })
+	path := filepath.Join(t.TempDir(), "pane.log")
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionPipeAdd, ShellCmd: "cat >> " + path}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	conn, err := ipc.Connect(d.socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionAttach, Client: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(conn, &resp); err != nil || !resp.OK {
+		t.Fatalf("attach: %v %+v", err, resp)
+	}
+	if !strings.Contains(resp.Output, "old [REDACTED]") || strings.Contains(resp.Output, "abc123") {
+		t.Errorf("repaint = %q", resp.Output)
+	}
+
+	term.Output("key sk-de")
+	time.Sleep(5 * time.Millisecond)
+	term.Output("f456\r\n$ ")
+	var stream string
+	for !strings.HasSuffix(stream, "$ ") {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var ev ipc.Response
+		if err := ipc.ReadMessage(conn, &ev); err != nil {
+			t.Fatalf("after %q: %v", stream, err)
+		}
+		stream += string(ev.Data)
+	}
+	if stream != "key [REDACTED]\r\n$ " {
+		t.Errorf("attach stream = %q", stream)
+	}
+	eventually(t, "raw pipe", func() bool {
+		data, _ := os.ReadFile(path)
+		return string(data) == "key [REDACTED]\r\n"
+	})
+	d.dispatch(ipc.Request{Action: ipc.ActionPipeRemove, Name: "p1"}, nil)
+	if data, _ := os.ReadFile(path); string(data) != "key [REDACTED]\r\n$ " {
+		t.Errorf("raw pipe after close = %q", data)
+	}
+}
+
+func TestWatchHookLimits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook uses a POSIX shell")
+	}
+	d, term := testDaemon(t)
+	path := filepath.Join(t.TempDir(), "hooks")
+	hook := "echo x >> " + path + "; sleep 0.3"
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionWatchAdd, Pattern: "ERROR", Hook: hook}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	starts := func() int {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "x")
+	}
+
+	// Matches while the hook runs start no other.
+	term.Output(strings.Repeat("ERROR\r\n", 20))
+	eventually(t, "hook", func() bool { return starts() == 1 })
+	time.Sleep(100 * time.Millisecond)
+	if n := starts(); n != 1 {
+		t.Errorf("%d hooks started for one burst, want 1", n)
+	}
+	eventually(t, "hook done", func() bool {
+		d.watches.mu.Lock()
+		defer d.watches.mu.Unlock()
+		return !d.watches.watches[0].hookRunning.Load()
+	})
+	term.Output("ERROR\r\n")
+	eventually(t, "second hook", func() bool { return starts() == 2 })
+
+	// At most maxHookStarts a second, across watches.
+	var ws watchSet
+	now := time.Now()
+	for i := 0; i < maxHookStarts; i++ {
+		if !ws.allowHook(now) {
+			t.Fatalf("hook %d refused", i+1)
+		}
+	}
+	if ws.allowHook(now.Add(500 * time.Millisecond)) {
+		t.Error("hook over the limit allowed")
+	}
+	if !ws.allowHook(now.Add(time.Second)) {
+		t.Error("hook refused in the next second")
+	}
+}
+
+func TestFocusEvents(t *testing.T) {
+	d, term := testDaemon(t)
+	serve(t, d)
+	attach := func() net.Conn {
+		t.Helper()
+		conn, err := ipc.Connect(d.socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionAttach, Client: "vim-user"}); err != nil {
+			t.Fatal(err)
+		}
+		var resp ipc.Response
+		if err := ipc.ReadMessage(conn, &resp); err != nil || !resp.OK {
+			t.Fatalf("attach: %v %+v", err, resp)
+		}
+		if on := strings.HasSuffix(resp.Output, "\x1b[?1004h"); on != (d.option("focus-events") == "on") {
+			t.Errorf("repaint %q with focus-events %s", resp.Output, d.option("focus-events"))
+		}
+		return conn
+	}
+	keys := func(conn net.Conn, data string) {
+		t.Helper()
+		if err := ipc.WriteMessage(conn, ipc.Request{Action: ipc.ActionSendKeys, Data: []byte(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// With focus-events off, reports are dropped even if the application
+	// asked for them.
+	term.Output("\x1b[?1004h")
+	eventually(t, "focus mode", d.screen.FocusEvents)
+	conn := attach()
+	keys(conn, "\x1b[Oa\x1b[I")
+	if !term.WaitInput("a", time.Second) || term.Input() != "a" {
+		t.Errorf("input = %q, want only the typed key", term.Input())
+	}
+	conn.Close()
+	eventually(t, "detach", func() bool { return !d.attached.viewing() })
+
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "focus-events", Value: "on"}, nil)
+	conn = attach()
+	keys(conn, "\x1b[Ob\x1b[I")
+	if want := "a\x1b[I\x1b[Ob\x1b[I"; !term.WaitInput(want, time.Second) {
+		t.Errorf("input = %q, want %q", term.Input(), want)
+	}
+
+	// Turning reporting off in the raw output turns it off in the client's
+	// terminal, so the daemon asks for it again.
+	term.Output("\x1b[?1004l")
+	var ev ipc.Response
+	for !strings.Contains(string(ev.Data), "\x1b[?1004h") {
+		if err := ipc.ReadMessage(conn, &ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys(conn, "\x1b[Oc")
+	if want := "a\x1b[I\x1b[Ob\x1b[Ic"; !term.WaitInput(want, time.Second) || term.Input() != want {
+		t.Errorf("input = %q after the application turned reporting off", term.Input())
+	}
+}
+
+func TestExecTemporaryPane(t *testing.T) {
+	d, term := testDaemon(t)
+	run := ptytest.New(40, 5, 2)
+	t.Cleanup(func() { run.Close() })
+	newTerminal = func(s pty.Spec) (pty.Terminal, error) {
+		if s.Command != "make test" || s.Dir != "/src" {
+			t.Errorf("opened %+v", s)
+		}
+		run.Output("\x1b[32mok\x1b[0m  pkg\r\n50%\rFAIL other\r\n\r\n")
+		run.Exit(2)
+		return run, nil
+	}
+	t.Cleanup(func() { newTerminal = pty.Open })
+
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionExec, ShellCmd: "make test", StartDir: "/src"}, nil)
+	if !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if want := "ok  pkg\nFAIL other\n"; resp.Output != want || resp.ExitCode != 2 {
+		t.Errorf("exec = %q, %d; want %q, 2", resp.Output, resp.ExitCode, want)
+	}
+	if term.Input() != "" || strings.Contains(capture(d), "pkg") {
+		t.Error("the temporary pane's command reached the pane")
+	}
+}
+
+func TestExecInPane(t *testing.T) {
+	d, term := testDaemon(t)
+	marker := regexp.MustCompile(`_b(\d+)`)
+	term.OnInput = func(term *ptytest.Terminal, data []byte) {
+		id := marker.FindSubmatch(data)[1]
+		// The shell echoes the line, then runs it.
+		term.Output("$ " + strings.TrimSuffix(string(data), "\r") + "\r\n")
+		term.Output("__wintmux_exec_b" + string(id) + "\r\n")
+		term.Output("/home/ci/venv\r\nno newline")
+		term.Output("__wintmux_exec_e" + string(id) + ":1\r\n$ ")
+	}
+
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionExec, ShellCmd: "echo $VIRTUAL_ENV", InPane: true, Shell: "posix"}, nil)
+	if !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if want := "/home/ci/venv\nno newline\n"; resp.Output != want || resp.ExitCode != 1 {
+		t.Errorf("exec = %q, %d; want %q, 1", resp.Output, resp.ExitCode, want)
+	}
+	if !strings.HasPrefix(term.Input(), `echo "__wintmux_exec""_b`) || !strings.HasSuffix(term.Input(), `:$?"`+"\r") {
+		t.Errorf("typed %q", term.Input())
+	}
+
+	// Typing into the pane is input, which a locked client may not send.
+	typed := term.Input()
+	clientRequest(d, "admin", ipc.Request{Action: ipc.ActionLockClient, TargetClient: "agent"})
+	resp = clientRequest(d, "agent", ipc.Request{Action: ipc.ActionExec, ShellCmd: "id", InPane: true, Shell: "posix"})
+	if resp.Error != "client agent is locked" || term.Input() != typed {
+		t.Errorf("exec --in-pane from a locked client: %+v, typed %q", resp, strings.TrimPrefix(term.Input(), typed))
+	}
+
+	term.OnInput = nil
+	resp = d.dispatch(ipc.Request{Action: ipc.ActionExec, ShellCmd: "sleep 9", InPane: true, Shell: "posix", TimeoutMs: 50}, nil)
+	if resp.OK || !strings.Contains(resp.Error, "timed out") {
+		t.Errorf("expected timeout, got %+v", resp)
+	}
+}
+
+func TestWaitForPrompt(t *testing.T) {
+	d, term := testDaemon(t)
+	wait := func(shell string, timeoutMs int) ipc.Response {
+		return d.dispatch(ipc.Request{Action: ipc.ActionWaitPrompt, Shell: shell, TimeoutMs: timeoutMs}, nil)
+	}
+	term.Output("building...\r\n")
+	if resp := wait("posix", 200); resp.OK {
+		t.Error("prompt found in command output")
+	}
+	term.OutputAfter(50*time.Millisecond, "done\r\nuser@host:~$ ")
+	if resp := wait("posix", 2000); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("ls")
+	eventually(t, "typed input", func() bool { return strings.Contains(capture(d), "$ ls") })
+	if resp := wait("posix", 200); resp.OK {
+		t.Error("prompt found with input typed after it")
+	}
+	term.Output("\r\nC:\\Users\\me>")
+	if resp := wait("cmd", 2000); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	term.Output("\r\nPS C:\\work> ")
+	if resp := wait("powershell", 2000); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := wait("cmd", 200); resp.OK {
+		t.Error("PowerShell prompt taken for cmd's")
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "prompt-pattern-powershell", Value: "^pwsh> $"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if resp := wait("powershell", 200); resp.OK {
+		t.Error("prompt-pattern-powershell ignored")
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "prompt-pattern-cmd", Value: "("}, nil); resp.OK {
+		t.Error("invalid pattern accepted")
+	}
+	term.Exit(0)
+	if resp := wait("posix", 2000); resp.OK || resp.Error != "pane exited" {
+		t.Errorf("after exit: %+v", resp)
+	}
+}
+
+func TestShellIntegration(t *testing.T) {
+	d, term := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, LastCmd: true}, nil); resp.OK {
+		t.Error("expected error before any command finished")
+	}
+	prompt := "\x1b]133;A\x07$ \x1b]133;B\x07"
+	term.Output(prompt + "make\r\n\x1b]133;C\x07building\r\nFAIL\r\n\x1b]133;D;2\x07" + prompt)
+	eventually(t, "command", func() bool {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{last_command}:#{last_exit_code}"}, nil).Output == "make:2"
+	})
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, LastCmd: true}, nil); resp.Output != "building\nFAIL" {
+		t.Errorf("last command output = %q", resp.Output)
+	}
+	if evs, _ := d.events.after(0, "command"); len(evs) != 1 || evs[0].vars["command_exit_code"] != "2" {
+		t.Errorf("command events = %+v", evs)
+	}
+
+	// exec --in-pane types the command alone and takes its output from
+	// the marks.
+	term.OnInput = func(term *ptytest.Terminal, data []byte) {
+		term.Output(strings.TrimSuffix(string(data), "\r") + "\r\n\x1b]133;C\x07/src\r\n\x1b]133;D;0\x07" + prompt)
+	}
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionExec, ShellCmd: "pwd", InPane: true}, nil)
+	if !resp.OK || resp.Output != "/src\n" || resp.ExitCode != 0 {
+		t.Errorf("exec = %+v", resp)
+	}
+	if !strings.HasSuffix(term.Input(), "pwd\r") || strings.Contains(term.Input(), "__wintmux_exec") {
+		t.Errorf("typed %q", term.Input())
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	d, term := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionListCommands}, nil); resp.OK {
+		t.Error("expected error from a shell without marks")
+	}
+	prompt := "\x1b]133;A\x07$ \x1b]133;B\x07"
+	term.Output(prompt + "ls\r\n\x1b]133;C\x07a\r\nb\r\n\x1b]133;D;0\x07" + prompt + "false\r\n\x1b]133;C\x07\x1b]133;D;1\x07" + prompt + "sleep 9\r\n\x1b]133;C\x07")
+	list := ipc.Request{Action: ipc.ActionListCommands, Format: "#{command_seq} #{command_line} #{command_exit_code} #{command_lines} #{command_running}"}
+	eventually(t, "commands", func() bool {
+		return d.dispatch(list, nil).Output == "1 ls 0 2 0\n2 false 1 0 0\n3 sleep 9  0 1"
+	})
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, CmdSeq: -2}, nil); resp.Output != "a\nb" {
+		t.Errorf("command -2 = %+v", resp)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, CmdSeq: 1}, nil); resp.Output != "a\nb" {
+		t.Errorf("command 1 = %+v", resp)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, CmdSeq: 3}, nil); resp.OK {
+		t.Error("expected error for the running command")
+	}
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{command_count} #{command_running}"}, nil).Output; out != "3 1" {
+		t.Errorf("formats = %q", out)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("\x1b]9;4;1;30\x07")
+	progress := ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_progress_state} #{pane_progress}"}
+	eventually(t, "progress", func() bool { return d.dispatch(progress, nil).Output == "normal 30" })
+	term.Output("\x1b]9;4;1;30\x07\x1b]9;4;2\x07")
+	eventually(t, "error", func() bool { return d.dispatch(progress, nil).Output == "error 30" })
+	evs, _ := d.events.after(0, "progress")
+	if len(evs) != 2 || evs[0].text != "30%" || evs[1].text != "error 30%" || evs[1].vars["progress_state"] != "error" {
+		t.Errorf("progress events = %+v", evs)
+	}
+	term.Exit(0)
+	eventually(t, "cleared", func() bool { return d.dispatch(progress, nil).Output == "none " })
+}
+
+func TestBell(t *testing.T) {
+	d, term := testDaemon(t)
+	flags := ipc.Request{Action: ipc.ActionDisplay, Format: "#{window_bell_flag}#{window_flags}"}
+	term.Output("\x1b]0;title\x07ready")
+	eventually(t, "output", func() bool { return strings.Contains(capture(d), "ready") })
+	if out := d.dispatch(flags, nil).Output; out != "0" {
+		t.Errorf("flag set by an OSC terminator: %q", out)
+	}
+
+	term.Output("done\a\a")
+	eventually(t, "bell", func() bool { return d.dispatch(flags, nil).Output == "1!" })
+	if evs, _ := d.events.after(0, "bell"); len(evs) != 1 || evs[0].vars["bell_count"] != "2" {
+		t.Errorf("bell events = %+v", evs)
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionSendKeys, Text: "x", Literal: true}, nil)
+	if out := d.dispatch(flags, nil).Output; out != "0" {
+		t.Errorf("flag not cleared by input: %q", out)
+	}
+
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "monitor-bell", Value: "off"}, nil)
+	term.Output("\amuted")
+	eventually(t, "output", func() bool { return strings.Contains(capture(d), "muted") })
+	if evs, _ := d.events.after(0, "bell"); len(evs) != 1 || d.dispatch(flags, nil).Output != "0" {
+		t.Errorf("bell alerted with monitor-bell off: %+v", evs)
+	}
+}
+
+func TestWindowOptions(t *testing.T) {
+	d, term := testDaemon(t)
+	set := func(name, value string, window bool) ipc.Response {
+		return d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: name, Value: value, Window: window}, nil)
+	}
+	display := func(f string) string {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: f}, nil).Output
+	}
+	if resp := set("history-limit", "100", true); resp.OK || !strings.Contains(resp.Error, "not a window option") {
+		t.Errorf("session option set with -w: %+v", resp)
+	}
+	if resp := set("synchronize-panes", "maybe", true); resp.OK {
+		t.Error("invalid synchronize-panes value accepted")
+	}
+	if resp := set("synchronize-panes", "on", false); !resp.OK || d.windowOption("synchronize-panes") != "on" {
+		t.Errorf("window option set without -w: %+v", resp)
+	}
+	if _, ok := d.options["synchronize-panes"]; ok {
+		t.Error("window option stored with the session's")
+	}
+
+	// Output is not activity until monitor-activity is on.
+	term.Output("quiet")
+	eventually(t, "output", func() bool { return strings.Contains(capture(d), "quiet") })
+	if out := display("#{window_activity_flag}#{window_flags}"); out != "0" {
+		t.Errorf("activity without monitor-activity: %q", out)
+	}
+	set("monitor-activity", "on", true)
+	term.Output(" busy")
+	eventually(t, "activity", func() bool { return display("#{window_activity_flag}#{window_flags}") == "1#" })
+	term.Output("\a again")
+	eventually(t, "bell", func() bool { return display("#{window_flags}") == "#!" })
+	if evs, _ := d.events.after(0, "activity"); len(evs) != 1 {
+		t.Errorf("activity events = %+v", evs)
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionSendKeys, Text: "x", Literal: true}, nil)
+	if out := display("#{window_activity_flag}#{window_flags}"); out != "0" {
+		t.Errorf("alerts not cleared by input: %q", out)
+	}
+
+	// The window is named after the foreground program until
+	// automatic-rename is turned off.
+	if got := display("#{window_name}"); got != "fake" {
+		t.Errorf("window_name before a sample = %q", got)
+	}
+	d.usage.Store(&paneUsage{foreground: "vim.exe"})
+	if got := display("#{window_name}"); got != "vim" {
+		t.Errorf("window_name = %q, want vim", got)
+	}
+	set("automatic-rename", "off", true)
+	d.usage.Store(&paneUsage{foreground: "bash"})
+	if got := display("#{window_name}"); got != "vim" {
+		t.Errorf("window_name with automatic-rename off = %q, want vim", got)
+	}
+	set("automatic-rename", "on", false)
+	if got := display("#{window_name}"); got != "bash" {
+		t.Errorf("window_name with automatic-rename on again = %q, want bash", got)
+	}
+}
+
+func TestAlternateEvents(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("$ vim\r\n\x1b[?1049hediting")
+	eventually(t, "alternate screen", func() bool {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{alternate_on}"}, nil).Output == "1"
+	})
+	// :!ls leaves the alternate screen and comes back in one chunk.
+	term.Output("\x1b[?1049lfiles\x1b[?1049h")
+	term.Output("\x1b[?1049l$ ")
+	eventually(t, "events", func() bool { evs, _ := d.events.after(0, "alternate"); return len(evs) == 4 })
+	evs, _ := d.events.after(0, "alternate")
+	var got []string
+	for _, ev := range evs {
+		got = append(got, ev.vars["alternate_on"])
+	}
+	if strings.Join(got, "") != "1010" || evs[0].text != "entered alternate screen" {
+		t.Errorf("events = %+v", evs)
+	}
+}
+
+func TestAlternateKeepsMainScreen(t *testing.T) {
+	d, term := testDaemon(t)
+	main := ipc.Request{Action: ipc.ActionCapture, Alternate: true}
+	if resp := d.dispatch(main, nil); resp.OK {
+		t.Error("capture -a without an alternate screen succeeded")
+	}
+	term.Output("$ make\r\nok\r\n$ vim\r\n\x1b[?1049h\x1b[Hsecret draft\x1b[5;1H-- INSERT --")
+	eventually(t, "vim", func() bool { return strings.Contains(capture(d), "INSERT") })
+	if resp := d.dispatch(main, nil); !resp.OK || !strings.HasPrefix(resp.Output, "$ make\nok\n$ vim") {
+		t.Errorf("capture -a = %q %s", resp.Output, resp.Error)
+	}
+	term.Output("\x1b[?1049l$ ")
+	eventually(t, "shell", func() bool { return strings.HasPrefix(capture(d), "$ make") })
+	if cur := d.screen.Cursor(); cur.Y != 3 || cur.X != 2 {
+		t.Errorf("cursor after vim = %+v", cur)
+	}
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, Strip: "text", Lines: 10}, nil)
+	if resp.Output != "$ make\nok\n$ vim\n$ " {
+		t.Errorf("history = %q", resp.Output)
+	}
+}
+
+func TestCaptureTabsOption(t *testing.T) {
+	d, term := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "capture-tabs", Value: "literal"}, nil); resp.OK {
+		t.Error("expected error for unknown capture-tabs")
+	}
+	term.Output("id\tname\r\n")
+	eventually(t, "output", func() bool { return strings.HasPrefix(capture(d), "id      name") })
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "capture-tabs", Value: "keep"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if got := capture(d); !strings.HasPrefix(got, "id\tname") {
+		t.Errorf("capture = %q", got)
+	}
+}
+
+func TestAmbiguousWidth(t *testing.T) {
+	d, term := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "ambiguous-width", Value: "3"}, nil); resp.OK {
+		t.Error("expected error for ambiguous-width 3")
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "ambiguous-width", Value: "2"}, nil); !resp.OK {
+		t.Fatalf("set-option: %s", resp.Error)
+	}
+	term.Output("○中x")
+	eventually(t, "cursor", func() bool {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{cursor_x}"}, nil).Output == "5"
+	})
+	if got := capture(d); !strings.HasPrefix(got, "○中x") {
+		t.Errorf("capture = %q", got)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("artifact: \x1b]8;id=a;https://ci/a.zip\x1b\\a.zip\x1b]8;;\x1b\\\r\n")
+	eventually(t, "output", func() bool { return strings.Contains(capture(d), "a.zip") })
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionListLinks, Format: "#{link_y}:#{link_x} #{link_id} #{link_url} #{link_text}"}, nil)
+	if !resp.OK || resp.Output != "0:10 a https://ci/a.zip a.zip" {
+		t.Errorf("list-links = %+v", resp)
+	}
+	resp = d.dispatch(ipc.Request{Action: ipc.ActionCapture, Escapes: true}, nil)
+	if !strings.HasPrefix(resp.Output, "artifact: \x1b]8;id=a;https://ci/a.zip\x1b\\a.zip\x1b]8;;\x1b\\\n") {
+		t.Errorf("capture -e = %q", resp.Output)
+	}
+	if out := capture(d); strings.Contains(out, "\x1b") {
+		t.Errorf("plain capture kept escapes: %q", out)
+	}
+}
+
+func TestExecShell(t *testing.T) {
+	for command, want := range map[string]string{
+		`C:\Windows\System32\cmd.exe /k`: "cmd",
+		"pwsh -NoLogo":                   "powershell",
+		`"powershell.exe"`:               "powershell",
+		"/bin/bash -l":                   "posix",
+	} {
+		if got := execShell(command); got != want {
+			t.Errorf("execShell(%q) = %s, want %s", command, got, want)
+		}
+	}
+	if line, _ := execLine("cmd", "dir", "7"); line != `echo __wintmux_exec^_b7 & dir & call echo __wintmux_exec^_e7:%^errorlevel%` {
+		t.Errorf("cmd line = %s", line)
+	}
+	if line, _ := execLine("powershell", "Get-Item nope", "7"); line != `$global:LASTEXITCODE = 0; echo ('__wintmux_exec'+'_b7'); Get-Item nope; $__wintmux_exec_c = if ($?) { 0 } elseif ($LASTEXITCODE) { $LASTEXITCODE } else { 1 }; echo ('__wintmux_exec'+'_e7:'+$__wintmux_exec_c)` {
+		t.Errorf("powershell line = %s", line)
+	}
+	if _, err := execLine("fish", "ls", "1"); err == nil {
+		t.Error("expected error for unknown shell")
+	}
+}
+
+func TestExitEndsSession(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("bye")
+	term.Exit(0)
+	eventually(t, "session to end", func() bool {
+		return !d.dispatch(ipc.Request{Action: ipc.ActionHasSession}, nil).Exists
+	})
+	if got := strings.TrimRight(capture(d), "\n"); got != "bye" {
+		t.Errorf("final capture = %q, want %q", got, "bye")
+	}
+}
+
+func TestRemainOnExitKeepsSession(t *testing.T) {
+	d, term := testDaemon(t)
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "remain-on-exit", Value: "on"}, nil)
+	term.Exit(1)
+	eventually(t, "child exit", d.childExited)
+	if !d.dispatch(ipc.Request{Action: ipc.ActionHasSession}, nil).Exists {
+		t.Error("session ended despite remain-on-exit")
+	}
+}
+
+func TestKillSessionSkipsGracePeriod(t *testing.T) {
+	listening := func(d *Daemon) bool {
+		conn, err := net.Dial("tcp", d.listeners[0].Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}
+
+	d, term := testDaemon(t)
+	serve(t, d)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionKillSession}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if !term.Closed() {
+		t.Error("pane process not killed")
+	}
+	eventually(t, "listeners closed", func() bool { return !listening(d) })
+
+	// A dead pane kept by remain-on-exit is ended too.
+	d, term = testDaemon(t)
+	serve(t, d)
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "remain-on-exit", Value: "on"}, nil)
+	term.Exit(1)
+	eventually(t, "child exit", d.childExited)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionKillSession}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	eventually(t, "listeners closed", func() bool { return !listening(d) })
+}
+
+func TestTTLEndsSession(t *testing.T) {
+	wait := ttlInterruptWait
+	ttlInterruptWait = 50 * time.Millisecond
+	t.Cleanup(func() { ttlInterruptWait = wait })
+
+	d, term := testDaemon(t)
+	serve(t, d)
+	marker := filepath.Join(t.TempDir(), "hook-ran")
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "alert-ttl-hook", Value: "echo #{session_name} > " + marker}, nil)
+	d.expireAfter(time.Millisecond)
+
+	if data, err := os.ReadFile(marker); err != nil || strings.TrimSpace(string(data)) != "test" {
+		t.Errorf("hook output = %q, %v", data, err)
+	}
+	if !strings.Contains(term.Input(), "\x03") || !term.Closed() {
+		t.Errorf("pane not interrupted and killed: input %q, closed %v", term.Input(), term.Closed())
+	}
+	if evs, _ := d.events.after(0, "ttl"); len(evs) != 1 || evs[0].vars["ttl"] != "1ms" {
+		t.Errorf("ttl events = %+v", evs)
+	}
+	eventually(t, "listeners closed", func() bool {
+		conn, err := net.Dial("tcp", d.listeners[0].Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	})
+}
+
+func TestHealth(t *testing.T) {
+	health := func(d *Daemon) string {
+		return d.dispatch(ipc.Request{Action: ipc.ActionPing}, nil).Output
+	}
+	d, term := testDaemon(t)
+	if got := health(d); got != "running" {
+		t.Errorf("health = %q", got)
+	}
+	term.Exit(0)
+	eventually(t, "draining", func() bool { return health(d) == "draining" })
+
+	d, term = testDaemon(t)
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "remain-on-exit", Value: "on"}, nil)
+	term.Exit(1)
+	eventually(t, "child-exited", func() bool { return health(d) == "child-exited" })
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{session_health}"}, nil).Output; out != "child-exited" {
+		t.Errorf("session_health = %q", out)
+	}
+}
+
+func TestRespawnStartsNewTerminal(t *testing.T) {
+	d, term := testDaemon(t)
+	next := ptytest.New(40, 5, 2)
+	t.Cleanup(func() { next.Close() })
+	newTerminal = func(s pty.Spec) (pty.Terminal, error) {
+		if s.Command != "again" {
+			t.Errorf("respawn command = %q", s.Command)
+		}
+		return next, nil
+	}
+	t.Cleanup(func() { newTerminal = pty.Open })
+
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn, Kill: true, ShellCmd: "again", StartDir: t.TempDir()}, nil)
+	if !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if !term.Closed() {
+		t.Error("old terminal not closed")
+	}
+	next.Output("second run")
+	eventually(t, "new output", func() bool { return strings.Contains(capture(d), "second run") })
+}
+
+func TestShowExits(t *testing.T) {
+	d, term := testDaemon(t)
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "remain-on-exit", Value: "on"}, nil)
+	display := func(f string) string {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: f}, nil).Output
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionShowExits}, nil); !resp.OK || resp.Output != "" {
+		t.Errorf("no exits yet: %+v", resp)
+	}
+
+	term.Exit(3)
+	eventually(t, "exit", d.childExited)
+	if got := display("#{pane_dead_status} #{pane_exit_count} #{pane_respawn_count}"); got != "3 1 0" {
+		t.Errorf("after exit = %q", got)
+	}
+
+	next := ptytest.New(40, 5, 2)
+	t.Cleanup(func() { next.Close() })
+	newTerminal = func(pty.Spec) (pty.Terminal, error) { return next, nil }
+	t.Cleanup(func() { newTerminal = pty.Open })
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn, ShellCmd: "again"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if got := display("[#{pane_dead_status}] #{pane_last_exit_status} #{pane_respawn_count}"); got != "[] 3 1" {
+		t.Errorf("after respawn = %q", got)
+	}
+	next.Exit(0)
+	eventually(t, "second exit", d.childExited)
+
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionShowExits, Format: "#{exit_seq} #{exit_respawn} #{exit_pid} #{exit_code} #{exit_command}"}, nil)
+	if !resp.OK || resp.Output != "1 0 1 3 fake\n2 1 2 0 again" {
+		t.Errorf("show-exits = %+v", resp)
+	}
+}
+
+func TestPersistOptions(t *testing.T) {
+	d, _ := testDaemon(t)
+	set := func(d *Daemon, name, value string) {
+		t.Helper()
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: name, Value: value}, nil); !resp.OK {
+			t.Fatalf("set %s: %s", name, resp.Error)
+		}
+	}
+	set(d, "history-limit", "500")
+	if _, err := os.Stat(d.optionsPath()); !os.IsNotExist(err) {
+		t.Fatalf("options saved without persist-options: %v", err)
+	}
+	set(d, "persist-options", "on")
+	set(d, "alert-bell-hook", "echo bell")
+	set(d, "update-environment", "DISPLAY")
+	saved, err := d.loadOptions()
+	if err != nil || saved["history-limit"] != "500" || saved["alert-bell-hook"] != "echo bell" {
+		t.Fatalf("saved %v, %v", saved, err)
+	}
+
+	// A daemon started again for the session takes them up, leaving out
+	// an option it does not know.
+	saved["no-such-option"] = "x"
+	restarted := newDaemon(d.socketPath, "test", t.TempDir(), "fake", 40, 5)
+	restarted.restoreOptions(saved, func(string) bool { return true })
+	for name, want := range map[string]string{"history-limit": "500", "alert-bell-hook": "echo bell", "update-environment": "DISPLAY", "persist-options": "on"} {
+		if got := restarted.option(name); got != want {
+			t.Errorf("restored %s = %q, want %q", name, got, want)
+		}
+	}
+	if _, ok := restarted.options["no-such-option"]; ok {
+		t.Error("unknown option restored")
+	}
+
+	set(d, "persist-options", "off")
+	if _, err := os.Stat(d.optionsPath()); !os.IsNotExist(err) {
+		t.Errorf("options file left after persist-options off: %v", err)
+	}
+}
+
+func TestBus(t *testing.T) {
+	d, term := testDaemon(t)
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "remain-on-exit", Value: "on"}, nil)
+	var mu sync.Mutex
+	var got []string
+	note := func(s string) {
+		mu.Lock()
+		got = append(got, s)
+		mu.Unlock()
+	}
+	seen := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(got, ",")
+	}
+	cancel := d.bus.subscribe(busOutput, func(ev busEvent) {
+		// Handlers see the screen as the output left it.
+		if !strings.Contains(capture(d), string(ev.data)) {
+			t.Errorf("output %q not on screen yet", ev.data)
+		}
+		note("output " + string(ev.data))
+	})
+	d.bus.subscribe(busOption, func(ev busEvent) { note("option " + ev.option + "=" + ev.value) })
+	d.bus.subscribe(busExit, func(ev busEvent) { note(fmt.Sprintf("exit %d", ev.child.term.ExitCode())) })
+	d.bus.subscribe(busStart, func(ev busEvent) { note(fmt.Sprintf("start %d", ev.child.run)) })
+
+	term.Output("one")
+	eventually(t, "output event", func() bool { return seen() == "output one" })
+	cancel()
+	term.Output("two")
+	eventually(t, "output", func() bool { return strings.Contains(capture(d), "onetwo") })
+
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "record-input", Value: "on"}, nil)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "remain-on-exit", Value: "maybe"}, nil); resp.OK {
+		t.Fatal("invalid option value accepted")
+	}
+	term.Exit(2)
+	eventually(t, "exit", d.childExited)
+	next := ptytest.New(40, 5, 2)
+	t.Cleanup(func() { next.Close() })
+	newTerminal = func(pty.Spec) (pty.Terminal, error) { return next, nil }
+	t.Cleanup(func() { newTerminal = pty.Open })
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if want := "output one,option record-input=on,exit 2,start 1"; seen() != want {
+		t.Errorf("events = %q, want %q", seen(), want)
+	}
+}
+
+func TestDefaultTerminal(t *testing.T) {
+	d, _ := testDaemon(t)
+	var env []string
+	newTerminal = func(s pty.Spec) (pty.Terminal, error) {
+		env = s.Env
+		next := ptytest.New(40, 5, 2)
+		t.Cleanup(func() { next.Close() })
+		return next, nil
+	}
+	t.Cleanup(func() { newTerminal = pty.Open })
+	respawn := func(extra ...string) {
+		t.Helper()
+		resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn, Kill: true, ShellCmd: "vim", Env: extra}, nil)
+		if !resp.OK {
+			t.Fatal(resp.Error)
+		}
+	}
+
+	respawn()
+	if want := "TERM=xterm-256color COLORTERM=truecolor"; strings.Join(env, " ") != want {
+		t.Errorf("env = %q, want %q", env, want)
+	}
+	respawn("TERM=vt100")
+	if want := "TERM=xterm-256color COLORTERM=truecolor TERM=vt100"; strings.Join(env, " ") != want {
+		t.Errorf("-e TERM: env = %q, want %q", env, want)
+	}
+
+	for _, v := range []string{"", "xterm 256color", "../x"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "default-terminal", Value: v}, nil); resp.OK {
+			t.Errorf("default-terminal %q accepted", v)
+		}
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "default-terminal", Value: "tmux-256color"}, nil)
+	respawn()
+	if len(env) == 0 || env[0] != "TERM=tmux-256color" {
+		t.Errorf("env = %q after set-option", env)
+	}
+	d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "default-terminal", Value: "none"}, nil)
+	respawn()
+	if len(env) != 0 {
+		t.Errorf("env = %q with default-terminal none", env)
+	}
+}
+
+func TestSecretEnv(t *testing.T) {
+	d, _ := testDaemon(t)
+	path := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(path, []byte("v1\n"), 0o600)
+	secrets, err := parseSecretEnv([]string{"TOKEN=file:" + path}, pty.Spec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.secrets = secrets
+	var env []string
+	newTerminal = func(s pty.Spec) (pty.Terminal, error) {
+		env = s.Env
+		next := ptytest.New(40, 5, 2)
+		t.Cleanup(func() { next.Close() })
+		return next, nil
+	}
+	t.Cleanup(func() { newTerminal = pty.Open })
+	respawn := func() ipc.Response {
+		return d.dispatch(ipc.Request{Action: ipc.ActionRespawn, Kill: true, Env: []string{"TOKEN=override"}}, nil)
+	}
+
+	if resp := respawn(); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if want := "TERM=xterm-256color COLORTERM=truecolor TOKEN=v1 TOKEN=override"; strings.Join(env, " ") != want {
+		t.Errorf("env = %q, want %q", env, want)
+	}
+	// Secrets are fetched again for each process, so rotation reaches it.
+	os.WriteFile(path, []byte("v2"), 0o600)
+	respawn()
+	if !strings.Contains(strings.Join(env, " "), "TOKEN=v2") {
+		t.Errorf("env after rotation = %q", env)
+	}
+	os.Remove(path)
+	if resp := respawn(); resp.OK || !strings.Contains(resp.Error, "TOKEN: secret file:") {
+		t.Errorf("missing secret: %+v", resp)
+	}
+
+	ssh, _ := pty.ParseSpec("ssh:buildbox")
+	if _, err := parseSecretEnv([]string{"TOKEN=file:" + path}, ssh); err == nil {
+		t.Error("secret env accepted for an ssh pane")
+	}
+	if _, err := parseSecretEnv([]string{"TOKEN=v1"}, pty.Spec{}); err == nil {
+		t.Error("secret value accepted as a reference")
+	}
+}
+
+func TestShowEnvironment(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skip("no process environment on", runtime.GOOS)
+	}
+	d := newDaemon(filepath.Join(t.TempDir(), "s.sock"), "test", t.TempDir(), "fake", 40, 5)
+	term := ptytest.New(40, 5, os.Getpid())
+	d.startChild(term)
+	t.Cleanup(func() { term.Close() })
+	show := func(name string, pid int) ipc.Response {
+		return d.dispatch(ipc.Request{Action: ipc.ActionShowEnv, Name: name, PID: pid}, nil)
+	}
+
+	resp := show("", 0)
+	if !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	lines := strings.Split(resp.Output, "\n")
+	if !sort.StringsAreSorted(lines) || !strings.Contains(strings.ToUpper(resp.Output), "PATH=") {
+		t.Errorf("environment = %q", resp.Output)
+	}
+	if resp := show("PATH", 0); !resp.OK || strings.Contains(resp.Output, "\n") || !strings.HasSuffix(resp.Output, "="+os.Getenv("PATH")) {
+		t.Errorf("PATH = %+v", resp)
+	}
+	d.secrets = []secretVar{{name: "PATH"}}
+	if resp := show("PATH", 0); !strings.HasSuffix(resp.Output, "=(secret)") {
+		t.Errorf("secret PATH = %+v", resp)
+	}
+	if resp := show("WINTMUX_NO_SUCH_VARIABLE", 0); resp.OK {
+		t.Error("unknown variable shown")
+	}
+	if resp := show("", 1); resp.OK || !strings.Contains(resp.Error, "not in the pane") {
+		t.Errorf("process outside the pane: %+v", resp)
+	}
+}
+
+func TestCloneInfo(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("\x1b]7;file://host/work/repo\x07$ ")
+	eventually(t, "cwd reported", func() bool { return d.screen.CurrentPath() != "" })
+	d.secrets = []secretVar{{name: "TOKEN", ref: secret.Ref{Source: secret.SourceFile, Name: "/run/token"}}}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "remain-on-exit", Value: "on"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionCloneInfo}, nil)
+	if !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	s, err := ipc.ParseSettings(resp.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Command != "fake" || s.Dir != d.screen.CurrentPath() || s.Backend != "" {
+		t.Errorf("settings = %+v", s)
+	}
+	if len(s.Env) != len(os.Environ()) {
+		t.Errorf("env has %d entries, want %d", len(s.Env), len(os.Environ()))
+	}
+	if len(s.SecretEnv) != 1 || s.SecretEnv[0] != "TOKEN=file:/run/token" {
+		t.Errorf("secret env = %q", s.SecretEnv)
+	}
+	if len(s.Options) != 1 || s.Options["remain-on-exit"] != "on" {
+		t.Errorf("options = %v", s.Options)
+	}
+}
+
+func TestResourceUsage(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skip("no process listing on", runtime.GOOS)
+	}
+	d := newDaemon(filepath.Join(t.TempDir(), "s.sock"), "test", t.TempDir(), "fake", 40, 5)
+	term := ptytest.New(40, 5, os.Getpid())
+	d.startChild(term)
+	t.Cleanup(func() { term.Close() })
+	display := func() string {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_processes} #{pane_memory} #{pane_handles} #{pane_cpu}"}, nil).Output
+	}
+
+	if out := display(); out != "   " {
+		t.Errorf("before sampling: %q", out)
+	}
+	d.sampleUsage()
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+	}
+	d.sampleUsage()
+	f := strings.Fields(display())
+	if len(f) != 4 || f[0] == "0" || f[1] == "0" || f[2] == "0" {
+		t.Fatalf("usage = %q", f)
+	}
+	if cpu, _ := strconv.ParseFloat(f[3], 64); cpu <= 0 {
+		t.Errorf("pane_cpu = %s while busy", f[3])
+	}
+	term.Close()
+	eventually(t, "pane exit", d.childExited)
+	d.sampleUsage()
+	if out := display(); out != "   " {
+		t.Errorf("after exit: %q", out)
+	}
+}
+
+func TestForegroundProcess(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	node := func(pid, depth int, started time.Time) proc.Node {
+		return proc.Node{Process: proc.Process{PID: pid, Started: started}, Depth: depth}
+	}
+	for _, c := range []struct {
+		a, b proc.Node
+		want bool
+	}{
+		// vim, started last, over a deeper job left running before it.
+		{node(30, 1, at.Add(time.Minute)), node(90, 2, at), true},
+		{node(90, 2, at), node(30, 1, at.Add(time.Minute)), false},
+		// Started together, or with no start time: deepest, then highest PID.
+		{node(30, 2, at), node(90, 1, at), true},
+		{node(90, 1, time.Time{}), node(30, 1, time.Time{}), true},
+		{node(30, 1, time.Time{}), node(90, 1, time.Time{}), false},
+	} {
+		if got := inForeground(c.a, c.b); got != c.want {
+			t.Errorf("inForeground(%+v, %+v) = %v", c.a, c.b, got)
+		}
+	}
+}
+
+func TestCurrentPathForeground(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("no sleep command to run in the foreground on", runtime.GOOS)
+	}
+	d, term := testDaemon(t)
+	term.Output("\x1b]7;file://host/work/repo\x07$ ")
+	eventually(t, "cwd reported", func() bool { return d.screen.CurrentPath() != "" })
+	path := func() string {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_current_path}"}, nil).Output
+	}
+	if got := path(); got != "/work/repo" {
+		t.Errorf("shell's report: %q", got)
+	}
+
+	// A program the shell runs in another directory takes over.
+	dir := t.TempDir()
+	cmd := exec.Command("sleep", "10")
+	cmd.Dir = dir
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill(); cmd.Wait() })
+	d.usage.Store(&paneUsage{pid: term.Pid(), foregroundPID: cmd.Process.Pid})
+	if got := path(); got != dir {
+		t.Errorf("foreground program's directory: %q, want %s", got, dir)
+	}
+}
+
+func TestResourceAlerts(t *testing.T) {
+	d, _ := testDaemon(t)
+	for opt, v := range map[string]string{"alert-cpu": "x", "alert-memory": "lots", "kill-on-memory": "-1"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: opt, Value: v}, nil); resp.OK {
+			t.Errorf("expected error for %s %s", opt, v)
+		}
+	}
+	for opt, v := range map[string]string{"alert-cpu": "150", "alert-memory": "1GB", "kill-on-memory": "4GB"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: opt, Value: v}, nil); !resp.OK {
+			t.Fatalf("set-option %s: %s", opt, resp.Error)
+		}
+	}
+
+	d.checkUsage(&paneUsage{pid: 1, cpuPercent: 200, memory: 512 << 20})
+	d.checkUsage(&paneUsage{pid: 1, cpuPercent: 180, memory: 2 << 30})
+	d.checkUsage(&paneUsage{pid: 1, cpuPercent: 10, memory: 2 << 30})
+	d.checkUsage(&paneUsage{pid: 1, cpuPercent: 160, memory: 2 << 30})
+	evs, _ := d.events.after(0, "resource")
+	var kinds []string
+	for _, ev := range evs {
+		kinds = append(kinds, ev.vars["resource_kind"]+":"+ev.vars["resource_action"])
+	}
+	if got := strings.Join(kinds, " "); got != "cpu:alert memory:alert cpu:alert" {
+		t.Errorf("alerts = %s (%+v)", got, evs)
+	}
+	if d.childExited() {
+		t.Fatal("pane killed under kill-on-memory")
+	}
+
+	d.checkUsage(&paneUsage{pid: 1, memory: 5 << 30})
+	eventually(t, "pane killed", d.childExited)
+	evs, _ = d.events.after(evs[len(evs)-1].seq, "resource")
+	if len(evs) != 1 || evs[0].vars["resource_action"] != "kill" || evs[0].vars["resource_limit"] != "4GB" {
+		t.Errorf("kill events = %+v", evs)
+	}
+}
+
+func TestStuck(t *testing.T) {
+	d, term := testDaemon(t)
+	stuck := func() string {
+		return d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_stuck}"}, nil).Output
+	}
+	set := func(opt, v string) {
+		t.Helper()
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: opt, Value: v}, nil); !resp.OK {
+			t.Fatalf("set-option %s: %s", opt, resp.Error)
+		}
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "stuck-after", Value: "soon"}, nil); resp.OK {
+		t.Error("expected error for stuck-after soon")
+	}
+	quiet := func() {
+		time.Sleep(30 * time.Millisecond)
+		d.checkStuck()
+	}
+
+	quiet()
+	if stuck() != "0" {
+		t.Error("stuck with stuck-after off")
+	}
+	set("stuck-after", "20ms")
+	d.usage.Store(&paneUsage{pid: 1, cpuPercent: 40})
+	quiet()
+	if stuck() != "0" {
+		t.Error("stuck while using some CPU")
+	}
+	d.usage.Store(&paneUsage{pid: 1, cpuPercent: 100})
+	quiet()
+	quiet()
+	if stuck() != "1" {
+		t.Fatal("not stuck while spinning without output")
+	}
+	if evs, _ := d.events.after(0, "stuck"); len(evs) != 1 || evs[0].vars["stuck_cpu"] != "100.0" {
+		t.Errorf("stuck events = %+v", evs)
+	}
+	term.Output("tick")
+	eventually(t, "output", func() bool { return stuck() == "0" })
+
+	d.usage.Store(nil)
+	set("stuck-probe", "exit 0")
+	quiet()
+	eventually(t, "probe", func() bool { return !d.stuckProbe.Load() })
+	if stuck() != "0" {
+		t.Error("stuck although the probe passed")
+	}
+	set("stuck-probe", "echo no reply&& exit 1")
+	quiet()
+	eventually(t, "probe failure", func() bool { return stuck() == "1" })
+	if evs, _ := d.events.after(0, "stuck"); len(evs) != 2 || !strings.Contains(evs[1].vars["stuck_probe"], "no reply") {
+		t.Errorf("stuck events = %+v", evs)
+	}
+
+	term.Output("\x1b]133;A\x07$ \x1b]133;B\x07")
+	eventually(t, "prompt", func() bool { return stuck() == "0" })
+	set("stuck-probe", "")
+	quiet()
+	if stuck() != "0" {
+		t.Error("stuck while waiting at a prompt")
+	}
+}
+
+func TestCaptureStreams(t *testing.T) {
+	d, term := testDaemon(t)
+	term.Output("building\n")
+	term.Stderr("warning: unused x\n")
+	term.Output("ok ")
+	term.Stderr("(1 warning)")
+	eventually(t, "output", func() bool { return strings.Contains(capture(d), "(1 warning)") })
+	d.showOutput([]byte("\nmirrored"), 0, true)
+
+	stream := func(mode string) string {
+		t.Helper()
+		resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, Lines: 10, Stream: mode}, nil)
+		if !resp.OK {
+			t.Fatalf("capture --stream %s: %s", mode, resp.Error)
+		}
+		return resp.Output
+	}
+	if got, want := stream("tag"), "1 building\n2 warning: unused x\n* ok (1 warning)\n- mirrored"; got != want {
+		t.Errorf("tag = %q, want %q", got, want)
+	}
+	if got, want := stream("stderr"), "warning: unused x\nok (1 warning)"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+	if got, want := stream("stdout"), "building\nok (1 warning)"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+}
+
+func TestConptyFlags(t *testing.T) {
+	d, term := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "conpty-flags", Value: "inherit-cursor"}, nil); resp.OK && pty.Detect().Supported(pty.FlagInheritCursor) == 0 {
+		t.Error("expected error for a flag this system does not support")
+	}
+
+	detectSystem = func() pty.System {
+		return pty.System{Backend: "conpty", Features: []pty.Feature{
+			{Name: "inherit-cursor", Flag: pty.FlagInheritCursor, Supported: true},
+		}}
+	}
+	t.Cleanup(func() {
+		detectSystem = pty.Detect
+		pty.SetFlags(0)
+	})
+	for _, v := range []string{"resize-quirk", "bogus"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "conpty-flags", Value: v}, nil); resp.OK {
+			t.Errorf("conpty-flags %s: expected error", v)
+		}
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "conpty-flags", Value: "inherit-cursor"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+
+	// The flags apply from the next terminal, whose cursor query is
+	// answered with where the old one left the cursor.
+	term.Output("hello")
+	eventually(t, "output", func() bool { return d.screen.Cursor().X == 5 })
+	next := ptytest.New(40, 5, 2)
+	t.Cleanup(func() { next.Close() })
+	newTerminal = func(s pty.Spec) (pty.Terminal, error) {
+		return next, nil
+	}
+	t.Cleanup(func() { newTerminal = pty.Open })
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn, Kill: true, StartDir: t.TempDir()}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	next.Output("\x1b[6n")
+	if !next.WaitInput("\x1b[1;6R", 2*time.Second) {
+		t.Errorf("cursor query not answered; input %q", next.Input())
+	}
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_backend} #{conpty_flags}"}, nil)
+	if resp.Output != "conpty inherit-cursor" {
+		t.Errorf("display = %q", resp.Output)
+	}
+}
+
+func TestPaneBackendOption(t *testing.T) {
+	d, _ := testDaemon(t)
+	t.Cleanup(func() { pty.SetBackend("auto") })
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "pane-backend", Value: "teletype"}, nil); resp.OK {
+		t.Error("expected error for unknown backend")
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "pane-backend", Value: "auto"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_backend}"}, nil)
+	if want := pty.Detect().Backend; resp.Output != want {
+		t.Errorf("pane_backend = %q, want %q", resp.Output, want)
+	}
+}
+
+func TestScrollRegionHistory(t *testing.T) {
+	d, term := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "scroll-region-history", Value: "some"}, nil); resp.OK {
+		t.Error("expected error for unknown scroll-region-history")
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "scroll-region-history", Value: "all"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	// A TUI log window in rows 2-4, drawn with cursor moves and scrolled
+	// with SU: no newline in the output.
+	term.Output("\x1b[?1049h\x1b[1;1Htitle\x1b[2;4r\x1b[2;1Hfirst\x1b[3;1Hsecond\x1b[2S\x1b[5;1Hstatus")
+	eventually(t, "status", func() bool { return strings.Contains(capture(d), "status") })
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionCapture, Strip: "text", Lines: 10}, nil)
+	if lines := strings.Split(resp.Output, "\n"); len(lines) != 3 || lines[0] != "first" || lines[1] != "second" {
+		t.Errorf("history = %q", resp.Output)
+	}
+}
+
+func TestChildConsoleOption(t *testing.T) {
+	d, _ := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "child-console", Value: "new"}, nil); resp.OK {
+		t.Error("expected error for unknown child-console")
+	}
+	for _, mode := range []string{"hidden", "detached", "inherit"} {
+		if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "child-console", Value: mode}, nil); !resp.OK {
+			t.Fatal(resp.Error)
+		}
+		out, err := d.childCommand("echo " + mode).Output()
+		if err != nil || strings.TrimSpace(string(out)) != mode {
+			t.Errorf("%s: output %q, %v", mode, out, err)
+		}
+	}
+}
+
+func TestRemoteRespawn(t *testing.T) {
+	d, _ := testDaemon(t)
+	d.spec = pty.Spec{Scheme: "docker", Target: "box"}
+	next := ptytest.New(40, 5, 2)
+	t.Cleanup(func() { next.Close() })
+	var got pty.Spec
+	newTerminal = func(s pty.Spec) (pty.Terminal, error) {
+		got = s
+		return next, nil
+	}
+	t.Cleanup(func() { newTerminal = pty.Open })
+
+	resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn, Kill: true, ShellCmd: "python agent.py", StartDir: "/work", Env: []string{"MODE=ci"}}, nil)
+	if !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if got.Scheme != "docker" || got.Target != "box" || got.Command != "python agent.py" || got.Dir != "/work" || strings.Join(got.Env, " ") != "TERM=xterm-256color COLORTERM=truecolor MODE=ci" {
+		t.Errorf("opened %+v", got)
+	}
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_spec}"}, nil).Output; out != "docker:box" {
+		t.Errorf("pane_spec = %q", out)
+	}
+}
+
+func TestSerialPane(t *testing.T) {
+	d, _ := testDaemon(t)
+	spec, err := pty.ParseSpec("serial:COM3:115200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.spec = spec
+	d.command = ""
+	next := ptytest.New(40, 5, 0)
+	t.Cleanup(func() { next.Close() })
+	var opened pty.Spec
+	newTerminal = func(s pty.Spec) (pty.Terminal, error) {
+		if s.Command != "" {
+			return pty.Open(s) // refused before the port is opened
+		}
+		opened = s
+		return next, nil
+	}
+	t.Cleanup(func() { newTerminal = pty.Open })
+
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn, Kill: true, ShellCmd: "cmd"}, nil); resp.OK {
+		t.Error("expected error respawning a serial pane with a command")
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionRespawn, Kill: true}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if opened.Target != "COM3:115200" {
+		t.Errorf("opened %+v", opened)
+	}
+	next.Output("U-Boot 2024.01")
+	eventually(t, "serial output", func() bool { return strings.Contains(capture(d), "U-Boot") })
+	if out := d.dispatch(ipc.Request{Action: ipc.ActionDisplay, Format: "#{pane_spec}"}, nil).Output; out != "serial:COM3:115200" {
+		t.Errorf("pane_spec = %q", out)
+	}
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionListProcesses}, nil); resp.OK {
+		t.Error("expected error listing processes of a serial pane")
+	}
+}
+
+func TestPaneEncoding(t *testing.T) {
+	d, term := testDaemon(t)
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "pane-encoding", Value: "gbk"}, nil); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	// A character split across reads is still decoded.
+	term.Output("\xc4\xe3\xba")
+	term.OutputAfter(10*time.Millisecond, "\xc3 ok")
+	eventually(t, "transcoded output", func() bool { return strings.HasPrefix(capture(d), "你好 ok") })
+
+	if resp := d.dispatch(ipc.Request{Action: ipc.ActionSetOption, Option: "pane-encoding", Value: "ebcdic"}, nil); resp.OK {
+		t.Error("expected error for unknown encoding")
+	}
+}
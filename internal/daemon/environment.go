@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/proc"
+)
+
+// handleShowEnvironment reports the environment a process of the pane
+// actually has, so a controller can check that variables it set up
+// reached the agent: the pane process, or with req.PID one of its
+// descendants. Entries are sorted NAME=value lines; with req.Name only
+// that variable. Values of --secret-env variables are shown as
+// (secret), and redaction rules apply to the rest.
+func (d *Daemon) handleShowEnvironment(req ipc.Request) ipc.Response {
+	if d.childExited() {
+		return ipc.Response{OK: false, Error: "pane process has exited"}
+	}
+	if d.spec.Remote() || d.term().Pid() == 0 {
+		return ipc.Response{OK: false, Error: "the pane's environment is not readable here (" + d.spec.Scheme + ")"}
+	}
+	pid := d.term().Pid()
+	if req.PID != 0 && req.PID != pid {
+		procs, err := proc.List()
+		if err != nil {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("list processes: %v", err)}
+		}
+		found := false
+		for _, n := range proc.Tree(procs, pid) {
+			found = found || n.PID == req.PID
+		}
+		if !found {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("process %d is not in the pane", req.PID)}
+		}
+		pid = req.PID
+	}
+	env, err := proc.Environment(pid)
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("environment of process %d: %v", pid, err)}
+	}
+
+	var lines []string
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if req.Name != "" && !sameEnvName(name, req.Name) {
+			continue
+		}
+		if d.isSecretVar(name) {
+			e = name + "=(secret)"
+		}
+		lines = append(lines, d.redact(e))
+	}
+	if req.Name != "" && len(lines) == 0 {
+		return ipc.Response{OK: false, Error: "unknown variable: " + req.Name}
+	}
+	sort.Strings(lines)
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+// sameEnvName compares variable names as the platform does: Windows
+// ignores case.
+func sameEnvName(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// isSecretVar reports whether name is set from a --secret-env secret.
+func (d *Daemon) isSecretVar(name string) bool {
+	for _, v := range d.secrets {
+		if sameEnvName(v.name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+)
+
+// eventLogLimit is the number of events kept for wait-event.
+const eventLogLimit = 1000
+
+const defaultEventFormat = "#{event_seq} #{event_time} #{event_type} #{event_text}"
+
+// event is something the daemon noticed that clients may want to react
+// to without polling, such as a watch match. vars holds type-specific
+// format variables.
+type event struct {
+	seq  int64
+	time time.Time
+	kind string
+	text string
+	vars map[string]string
+}
+
+// eventLog is a bounded, sequence-numbered list of recent events.
+// Sequence numbers start at 1 and never repeat within a daemon.
+type eventLog struct {
+	mu      sync.Mutex
+	events  []event
+	seq     int64
+	changed chan struct{} // closed and replaced on every emit
+}
+
+func (l *eventLog) emit(kind, text string, vars map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.events = append(l.events, event{seq: l.seq, time: time.Now(), kind: kind, text: text, vars: vars})
+	if over := len(l.events) - eventLogLimit; over > 0 {
+		l.events = append(l.events[:0:0], l.events[over:]...)
+	}
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+// after returns events with seq > since and, if kind is set, that type,
+// plus a channel closed by the next emit.
+func (l *eventLog) after(since int64, kind string) ([]event, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var evs []event
+	for _, ev := range l.events {
+		if ev.seq > since && (kind == "" || ev.kind == kind) {
+			evs = append(evs, ev)
+		}
+	}
+	if l.changed == nil {
+		l.changed = make(chan struct{})
+	}
+	return evs, l.changed
+}
+
+func (l *eventLog) last() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// handleWaitEvent returns the events after req.Since, waiting up to
+// req.TimeoutMs for one to arrive if there are none yet. A negative
+// Since means "from now", so only events emitted while waiting count.
+// On timeout Output holds the sequence number to resume from, so a
+// caller polling in a loop never misses events between two calls.
+func (d *Daemon) handleWaitEvent(req ipc.Request, p *progress) ipc.Response {
+	since := req.Since
+	if since < 0 {
+		since = d.events.last()
+	}
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	tick, stop := p.tick()
+	defer stop()
+	start := time.Now()
+
+	for {
+		evs, changed := d.events.after(since, req.EventType)
+		if len(evs) > 0 {
+			return ipc.Response{OK: true, Output: formatEvents(evs, req.Format)}
+		}
+		select {
+		case <-changed:
+		case <-tick:
+			p.report("waiting for an event after %d (%v elapsed)", since, time.Since(start).Round(time.Second))
+		case <-timer.C:
+			return ipc.Response{
+				OK:     false,
+				Error:  fmt.Sprintf("timed out after %v waiting for an event", timeout),
+				Output: strconv.FormatInt(since, 10),
+			}
+		}
+	}
+}
+
+func formatEvents(evs []event, tmpl string) string {
+	if tmpl == "" {
+		tmpl = defaultEventFormat
+	}
+	lines := make([]string, 0, len(evs))
+	for _, ev := range evs {
+		vars := map[string]string{
+			"event_seq":  strconv.FormatInt(ev.seq, 10),
+			"event_time": ev.time.Format(time.RFC3339Nano),
+			"event_type": ev.kind,
+			"event_text": ev.text,
+		}
+		for k, v := range ev.vars {
+			vars[k] = v
+		}
+		lines = append(lines, format.Expand(tmpl, vars))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,340 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/screen"
+	"wintmux/internal/vt"
+)
+
+// execDefaultTimeout bounds exec when the request sets no timeout.
+const execDefaultTimeout = 60 * time.Second
+
+// execMaxOutput caps the output exec keeps, so a runaway command cannot
+// produce a reply larger than a message may be.
+const execMaxOutput = 4 << 20
+
+// execSeq numbers in-pane exec runs, so each run's markers are its own.
+var execSeq atomic.Int64
+
+// handleExec runs req.ShellCmd to completion and returns its output as
+// text in Output and its exit status in ExitCode. By default the command
+// runs in a temporary pane: a terminal of its own, created like the
+// pane's (same backend, so a container or remote host session runs it
+// there too), which the pane never sees. With InPane it is typed into the
+// pane's shell between two markers instead, for state only that shell
+// has (a virtualenv, cd, variables).
+func (d *Daemon) handleExec(req ipc.Request, p *progress) ipc.Response {
+	if req.ShellCmd == "" {
+		return ipc.Response{OK: false, Error: "exec requires a command"}
+	}
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = execDefaultTimeout
+	}
+	if req.InPane {
+		return d.execInPane(req, timeout, p)
+	}
+	return d.execTemporary(req, timeout, p)
+}
+
+// execTemporary runs the command in a terminal of its own, started in
+// req.StartDir (else the pane's current path) with the environment
+// respawn-pane would give it.
+func (d *Daemon) execTemporary(req ipc.Request, timeout time.Duration, p *progress) ipc.Response {
+	term, err := d.openTerminal(req.ShellCmd, d.startDir(req.StartDir), d.respawnEnv(req))
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("exec: %v", err)}
+	}
+	defer term.Close()
+
+	var mu sync.Mutex
+	var out bytes.Buffer
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := term.Read(buf)
+			data := buf[:n]
+			if dec := d.decoder.Load(); dec != nil && n > 0 {
+				data = dec.Decode(data)
+			}
+			mu.Lock()
+			if out.Len() < execMaxOutput {
+				out.Write(data)
+			}
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	exited := make(chan struct{})
+	go func() {
+		term.Wait()
+		close(exited)
+	}()
+
+	tick, stop := p.tick()
+	defer stop()
+	deadline := time.After(timeout)
+	start := time.Now()
+	for waiting := true; waiting; {
+		select {
+		case <-exited:
+			waiting = false
+		case <-tick:
+			p.report("running for %v", time.Since(start).Round(time.Second))
+		case <-deadline:
+			term.Close()
+			mu.Lock()
+			text := execText(out.String())
+			mu.Unlock()
+			return ipc.Response{OK: false, Output: text, Error: fmt.Sprintf("timed out after %v", timeout)}
+		}
+	}
+	select {
+	case <-readerDone:
+	case <-time.After(exitDrainTimeout):
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return ipc.Response{OK: true, Output: execText(out.String()), ExitCode: term.ExitCode()}
+}
+
+// execText renders raw terminal output as text lines, as clean pipes do,
+// without the blank lines a terminal leaves at the end.
+func execText(raw string) string {
+	lines := strings.Split(raw, "\n")
+	for i, line := range lines {
+		lines[i] = vt.Clean(line)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// execInPane types the command into the pane's shell and collects its
+// output. A shell that sends shell integration marks (OSC 133) delimits
+// the command itself, so the command is typed as it is. Otherwise it goes
+// on one line between an echo of a begin marker and an echo of an end
+// marker that carries the exit status, each spelled so that the shell's
+// echo of the typed line does not contain it; req.Shell names the
+// shell's syntax, by default guessed from the pane command. Either way
+// the run ends once the shell shows its prompt again, or a little later.
+func (d *Daemon) execInPane(req ipc.Request, timeout time.Duration, p *progress) ipc.Response {
+	if d.childExited() {
+		return ipc.Response{OK: false, Error: "pane is dead"}
+	}
+	var w execWatcher
+	line := req.ShellCmd
+	shell := req.Shell
+	if shell == "" && d.screen.ShellIntegration() {
+		last, _ := d.screen.LastCommand()
+		w = &markWatcher{screen: d.screen, after: last.Seq}
+	} else {
+		if shell == "" {
+			shell = d.paneShell()
+		}
+		id := strconv.FormatInt(execSeq.Add(1), 10)
+		var err error
+		if line, err = execLine(shell, req.ShellCmd, id); err != nil {
+			return ipc.Response{OK: false, Error: err.Error()}
+		}
+		w = &execCollector{begin: execMarker + "_b" + id, end: regexp.MustCompile(execMarker + "_e" + id + `:(-?\d*)`)}
+	}
+
+	// Listen before typing, so no output falls in between.
+	tap := &attachment{client: req.Client, source: "exec", out: make(chan []byte, attachQueue), done: make(chan struct{})}
+	d.attached.add(tap)
+	defer d.attached.remove(tap)
+	if err := d.writeInput(req.Client, "text", "", []byte(line+"\r")); err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+
+	tick, stop := p.tick()
+	defer stop()
+	deadline := time.After(timeout)
+	start := time.Now()
+	for {
+		select {
+		case data := <-tap.out:
+			if code, done := w.feed(data); done {
+				// Return once the shell can take the next command.
+				d.awaitPrompt(shell, execPromptWait)
+				return ipc.Response{OK: true, Output: w.text(), ExitCode: code}
+			}
+		case <-tap.done:
+			reason := tap.reason
+			if reason == "" || reason == "exited" {
+				reason = "pane exited"
+			}
+			return ipc.Response{OK: false, Output: w.text(), Error: reason}
+		case <-tick:
+			p.report("running for %v", time.Since(start).Round(time.Second))
+		case <-deadline:
+			return ipc.Response{OK: false, Output: w.text(), Error: fmt.Sprintf("timed out after %v (the command is still running in the pane)", timeout)}
+		}
+	}
+}
+
+// execWatcher follows pane output for the end of an in-pane exec run.
+type execWatcher interface {
+	// feed takes the next output chunk and reports the exit code once
+	// the command has finished.
+	feed(data []byte) (int, bool)
+	// text returns the command's output so far.
+	text() string
+}
+
+// markWatcher waits for the screen to see a command finish after the one
+// numbered after. The screen has the chunk by the time feed is called.
+// An exit code the shell did not report counts as 0.
+type markWatcher struct {
+	screen *screen.Screen
+	after  int
+	cmd    screen.Command
+}
+
+func (w *markWatcher) feed([]byte) (int, bool) {
+	if c, ok := w.screen.RunningCommand(); ok && c.Seq > w.after {
+		w.cmd = c
+	}
+	c, ok := w.screen.LastCommand()
+	if !ok || c.Seq <= w.after {
+		return 0, false
+	}
+	w.cmd = c
+	return max(c.ExitCode, 0), true
+}
+
+func (w *markWatcher) text() string { return commandText(w.cmd) }
+
+// execMarker starts the marker lines of in-pane exec runs.
+const execMarker = "__wintmux_exec"
+
+// execShell guesses the syntax of the shell command runs: "cmd",
+// "powershell" or "posix".
+func execShell(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		if runtime.GOOS == "windows" {
+			return "cmd"
+		}
+		return "posix"
+	}
+	switch strings.ToLower(programName(fields[0])) {
+	case "cmd":
+		return "cmd"
+	case "powershell", "pwsh":
+		return "powershell"
+	case "sh", "bash", "zsh", "dash", "ksh", "ash", "busybox":
+		return "posix"
+	}
+	if runtime.GOOS == "windows" {
+		return "cmd"
+	}
+	return "posix"
+}
+
+// execLine is the line typed into a shell of the given syntax to run
+// command between the markers of run id. cmd expands %errorlevel% when
+// it reads the line, so the end marker re-expands it with call.
+// PowerShell sets $LASTEXITCODE only for native programs, so the line
+// clears it first and a failed cmdlet ($? false) reports 1.
+func execLine(shell, command, id string) (string, error) {
+	switch shell {
+	case "posix":
+		return fmt.Sprintf(`echo "%s""_b%s"; %s; echo "%s""_e%s:$?"`, execMarker, id, command, execMarker, id), nil
+	case "cmd":
+		return fmt.Sprintf(`echo %s^_b%s & %s & call echo %s^_e%s:%%^errorlevel%%`, execMarker, id, command, execMarker, id), nil
+	case "powershell":
+		return fmt.Sprintf(`$global:LASTEXITCODE = 0; echo ('%s'+'_b%s'); %s; $%s_c = if ($?) { 0 } elseif ($LASTEXITCODE) { $LASTEXITCODE } else { 1 }; echo ('%s'+'_e%s:'+$%s_c)`, execMarker, id, command, execMarker, execMarker, id, execMarker), nil
+	}
+	return "", fmt.Errorf("unknown shell %q (expected posix, cmd or powershell)", shell)
+}
+
+// execCollector picks the output of one in-pane run out of the pane's
+// output stream.
+type execCollector struct {
+	begin   string
+	end     *regexp.Regexp
+	partial string   // output since the last newline
+	started bool     // the begin marker has been seen
+	lines   []string // cleaned output lines after the begin marker
+	size    int
+}
+
+// feed takes more pane output and reports the exit code once the end
+// marker has been seen.
+func (c *execCollector) feed(data []byte) (int, bool) {
+	c.partial += string(data)
+	for {
+		i := strings.IndexByte(c.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := vt.Clean(c.partial[:i])
+		c.partial = c.partial[i+1:]
+		if code, done := c.line(line); done {
+			return code, true
+		}
+	}
+	// The end marker may close output that did not end its line.
+	if c.started {
+		if line := vt.Clean(c.partial); c.end.MatchString(line) {
+			return c.line(line)
+		}
+	}
+	if len(c.partial) > execMaxOutput {
+		c.partial = c.partial[len(c.partial)-execMaxOutput:]
+	}
+	return 0, false
+}
+
+func (c *execCollector) line(line string) (int, bool) {
+	if !c.started {
+		c.started = line == c.begin
+		return 0, false
+	}
+	if m := c.end.FindStringSubmatchIndex(line); m != nil {
+		if before := strings.TrimRight(line[:m[0]], " "); before != "" {
+			c.add(before)
+		}
+		code, _ := strconv.Atoi(line[m[2]:m[3]])
+		return code, true
+	}
+	c.add(line)
+	return 0, false
+}
+
+func (c *execCollector) add(line string) {
+	if c.size < execMaxOutput {
+		c.lines = append(c.lines, line)
+		c.size += len(line) + 1
+	}
+}
+
+// text returns the output collected so far, without the blank lines
+// around it.
+func (c *execCollector) text() string {
+	lines := c.lines
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	return execText(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+)
+
+// exitHistoryLimit is the number of pane process exits show-exits keeps;
+// older ones are discarded.
+const exitHistoryLimit = 100
+
+// paneExit is one run of the pane process that has ended.
+type paneExit struct {
+	seq     int // 1 for the first exit the session saw
+	run     int // 0 for the process the session started with, n for the n-th respawn
+	pid     int
+	code    int
+	command string
+	started time.Time
+	ended   time.Time
+}
+
+// exitLog records pane process exits, so a flaky agent's crash pattern
+// can be read back without external bookkeeping. seq counts every exit
+// ever recorded, so numbers stay valid as old ones are dropped.
+type exitLog struct {
+	mu    sync.Mutex
+	exits []paneExit
+	seq   int
+}
+
+func (l *exitLog) add(e paneExit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.seq = l.seq
+	l.exits = append(l.exits, e)
+	if over := len(l.exits) - exitHistoryLimit; over > 0 {
+		l.exits = append(l.exits[:0:0], l.exits[over:]...)
+	}
+}
+
+// list returns the kept exits, oldest first, and how many there have
+// been in all.
+func (l *exitLog) list() ([]paneExit, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]paneExit(nil), l.exits...), l.seq
+}
+
+// noteExit records the exit of c, whose process has been waited for.
+func (d *Daemon) noteExit(c *child) {
+	d.exits.add(paneExit{
+		run:     c.run,
+		pid:     c.term.Pid(),
+		code:    c.term.ExitCode(),
+		command: c.command,
+		started: c.started,
+		ended:   time.Now(),
+	})
+}
+
+// exitVars adds the exit history format variables to vars. Like tmux,
+// pane_dead_status and pane_dead_time are only set while the pane is
+// dead; the pane_last_exit ones keep describing the last exit after a
+// respawn.
+func (d *Daemon) exitVars(vars map[string]string) {
+	exits, total := d.exits.list()
+	vars["pane_respawn_count"] = strconv.Itoa(d.child().run)
+	vars["pane_exit_count"] = strconv.Itoa(total)
+	vars["pane_last_exit_status"], vars["pane_last_exit_time"] = "", ""
+	vars["pane_dead_status"], vars["pane_dead_time"] = "", ""
+	if len(exits) == 0 {
+		return
+	}
+	last := exits[len(exits)-1]
+	vars["pane_last_exit_status"] = strconv.Itoa(last.code)
+	vars["pane_last_exit_time"] = strconv.FormatInt(last.ended.Unix(), 10)
+	if d.child().exited.Load() && last.run == d.child().run {
+		vars["pane_dead_status"] = vars["pane_last_exit_status"]
+		vars["pane_dead_time"] = vars["pane_last_exit_time"]
+	}
+}
+
+// defaultExitFormat lists one exit per line with its code and run time.
+const defaultExitFormat = "#{exit_seq}: [#{exit_code}] pid #{exit_pid} ran #{exit_duration}s (respawn #{exit_respawn}) #{exit_command}"
+
+// handleShowExits reports the pane process exits kept in the history,
+// oldest first.
+func (d *Daemon) handleShowExits(req ipc.Request) ipc.Response {
+	exits, _ := d.exits.list()
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultExitFormat
+	}
+	lines := make([]string, 0, len(exits))
+	for _, e := range exits {
+		lines = append(lines, format.Expand(tmpl, map[string]string{
+			"exit_seq":      strconv.Itoa(e.seq),
+			"exit_respawn":  strconv.Itoa(e.run),
+			"exit_pid":      strconv.Itoa(e.pid),
+			"exit_code":     strconv.Itoa(e.code),
+			"exit_command":  d.redact(e.command),
+			"exit_start":    strconv.FormatInt(e.started.Unix(), 10),
+			"exit_time":     strconv.FormatInt(e.ended.Unix(), 10),
+			"exit_duration": strconv.FormatFloat(e.ended.Sub(e.started).Seconds(), 'f', 2, 64),
+		}))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"bytes"
+	"log"
+)
+
+// Focus reports: what a terminal with focus reporting (mode 1004) on
+// sends when it gains or loses focus, and what the pane's application
+// is sent in turn.
+var (
+	focusIn  = []byte("\x1b[I")
+	focusOut = []byte("\x1b[O")
+)
+
+// focusModeOn and focusModeOff ask an attached terminal to start and stop
+// reporting focus.
+const (
+	focusModeOn  = "\x1b[?1004h"
+	focusModeOff = "\x1b[?1004l"
+)
+
+// writeTerminalInput passes an attached terminal's input to the pane,
+// taking out the focus reports in it and recording its focus from each.
+// Reports never reach the pane as typed input: with focus-events off the
+// application gets none, as under tmux, even if its own mode 1004
+// request reached the terminal through the raw output.
+func (d *Daemon) writeTerminalInput(a *attachment, data []byte) error {
+	for {
+		i := indexFocusReport(data)
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			if err := d.writeInput(a.client, a.source, "", data[:i]); err != nil {
+				return err
+			}
+		}
+		d.setFocus(a, data[i+2] == 'I')
+		data = data[i+len(focusIn):]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return d.writeInput(a.client, a.source, "", data)
+}
+
+// indexFocusReport returns the index of the first focus report in data,
+// or -1.
+func indexFocusReport(data []byte) int {
+	i, o := bytes.Index(data, focusIn), bytes.Index(data, focusOut)
+	if i < 0 || (o >= 0 && o < i) {
+		return o
+	}
+	return i
+}
+
+// setFocus records whether a's terminal has focus. The session has focus
+// while any attached terminal does, so the application hears of the
+// first terminal gaining it and the last one losing it.
+func (d *Daemon) setFocus(a *attachment, focused bool) {
+	if before, after := d.attached.setFocus(a, focused); before != after {
+		d.sendFocus(after)
+	}
+}
+
+// sendFocus tells the pane's application whether the session has focus,
+// if focus-events is on and the application asked to be told.
+func (d *Daemon) sendFocus(focused bool) {
+	if d.option("focus-events") != "on" || !d.screen.FocusEvents() {
+		return
+	}
+	report := focusOut
+	if focused {
+		report = focusIn
+	}
+	if _, err := d.term().Write(report); err != nil {
+		log.Printf("daemon: focus report: %v", err)
+	}
+}
+
+// noteFocusMode follows the application turning focus reporting on and
+// off. With focus-events on, an application that turns it on is told at
+// once whether the session has focus, as tmux does, so vim's autoread
+// does not wait for the next focus change. One that turns it off has
+// turned it off in attached terminals too, through the raw output; they
+// are asked to turn it back on so the daemon keeps tracking focus.
+// Called after each output chunk is queued for attached clients.
+func (d *Daemon) noteFocusMode() {
+	on := d.screen.FocusEvents()
+	if d.focusMode.Swap(on) == on || d.option("focus-events") != "on" {
+		return
+	}
+	if on {
+		d.sendFocus(d.attached.focused())
+	} else {
+		d.attached.toTerminals([]byte(focusModeOn))
+	}
+}
@@ -0,0 +1,175 @@
+package daemon
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+	"wintmux/internal/proc"
+)
+
+// defaultDisplayFormat is used by display-message when no format is given.
+const defaultDisplayFormat = "[#{session_name}] #{cursor_x},#{cursor_y}"
+
+// formatVars returns the values of all format variables for the session.
+// Flags use tmux's "1"/"0" convention so they work in #{?flag,a,b}.
+func (d *Daemon) formatVars() map[string]string {
+	cur := d.screen.Cursor()
+	vars := map[string]string{
+		"session_name":      d.sessionName,
+		"pane_width":        strconv.Itoa(d.cols),
+		"pane_height":       strconv.Itoa(d.rows),
+		"pane_pid":          strconv.Itoa(d.term().Pid()),
+		"pane_current_path": d.currentPath(),
+		"pane_dead":         flag(d.childExited()),
+		"pane_quiet_ms":     strconv.FormatInt(d.quietFor().Milliseconds(), 10),
+		"pane_backend":      d.paneBackend(),
+		"pane_spec":         d.spec.String(),
+		"conpty_flags":      d.child().flags.String(),
+		"cursor_x":          strconv.Itoa(cur.X),
+		"cursor_y":          strconv.Itoa(cur.Y),
+		"cursor_flag":       flag(cur.Visible),
+		"cursor_line":       d.screen.CursorLine(),
+		"alternate_on":      flag(cur.Alternate),
+		"pane_key_mode":     d.screen.KeyMode().String(),
+	}
+	// A session has one window with one pane; tmux numbers them from 0.
+	vars["window_index"] = "0"
+	vars["pane_index"] = "0"
+	vars["session_id"] = d.sessionID()
+	vars["window_id"] = d.windowID()
+	vars["pane_id"] = d.paneID()
+	keys := d.screen.KeyMode()
+	vars["keypad_cursor_flag"] = flag(keys.CursorKeys)
+	vars["keypad_flag"] = flag(keys.Keypad)
+	vars["mouse_any_flag"] = flag(keys.Mouse != 0)
+	vars["mouse_standard_flag"] = flag(keys.Mouse == 1000)
+	vars["mouse_button_flag"] = flag(keys.Mouse == 1002)
+	vars["mouse_all_flag"] = flag(keys.Mouse == 1003)
+	vars["mouse_utf8_flag"] = flag(keys.MouseFormat == 1005)
+	vars["mouse_sgr_flag"] = flag(keys.MouseFormat == 1006)
+	d.commandVars(vars)
+	d.exitVars(vars)
+	d.usageVars(vars)
+	p := d.paneProgress()
+	vars["pane_progress"] = progressValue(p)
+	vars["pane_progress_state"] = progressState(p)
+	vars["pane_at_prompt"] = flag(d.atPrompt(""))
+	vars["pane_stuck"] = flag(d.stuck.Load())
+	vars["session_health"] = d.health()
+	vars["window_name"] = d.windowName()
+	vars["window_bell_flag"] = flag(d.bellFlag.Load())
+	vars["window_activity_flag"] = flag(d.activityFlag.Load())
+	// The marked pane is the session's, so a mark is always set on it.
+	vars["pane_marked"] = flag(d.marked.Load())
+	vars["pane_marked_set"] = vars["pane_marked"]
+	vars["window_marked_flag"] = vars["pane_marked"]
+	vars["window_flags"] = ""
+	if d.activityFlag.Load() {
+		vars["window_flags"] += "#"
+	}
+	if d.bellFlag.Load() {
+		vars["window_flags"] += "!"
+	}
+	if d.marked.Load() {
+		vars["window_flags"] += "M"
+	}
+	return vars
+}
+
+// outputVars are the format variables holding text a program in the
+// pane printed. They are left out of commands, where such a program
+// could use them to inject shell syntax.
+var outputVars = []string{"cursor_line", "last_command", "pane_current_path"}
+
+// expandCommand expands formats in a pipe command or path or a hook, so
+// one generic setting names per-session files and arguments, as in
+// "cat >> logs/#{session_name}-#{pane_id}.log". ## stands for #.
+func (d *Daemon) expandCommand(command string) string {
+	if !strings.Contains(command, "#") {
+		return command
+	}
+	vars := d.formatVars()
+	for _, name := range outputVars {
+		delete(vars, name)
+	}
+	return format.Expand(command, vars)
+}
+
+// quietFor reports how long the pane has produced no output. Before the
+// first output arrives it measures from daemon start.
+func (d *Daemon) quietFor() time.Duration {
+	last := d.lastOutput.Load()
+	if last == 0 {
+		return time.Since(d.started)
+	}
+	return time.Since(time.Unix(0, last))
+}
+
+// currentPath is the pane's working directory: that of a program running
+// in the foreground of the shell, else what the shell last reported via
+// OSC 7 / OSC 9;9, else the pane process's cwd, else the directory the
+// session was started in. The processes' directories are read where the
+// OS exposes them. It is the default start directory for panes created
+// without -c, like tmux's pane_current_path.
+func (d *Daemon) currentPath() string {
+	// With a remote backend the pane's process is a local client (docker,
+	// ssh) or none at all, and its directory says nothing.
+	local := !d.childExited() && !d.spec.Remote()
+	// A program the shell runs may have moved elsewhere than the shell's
+	// last report, so the foreground process comes first, as in tmux.
+	if u := d.usage.Load(); local && u != nil && u.foregroundPID != u.pid {
+		if p, err := proc.Cwd(u.foregroundPID); err == nil {
+			return p
+		}
+	}
+	if p := d.screen.CurrentPath(); p != "" {
+		return p
+	}
+	if local {
+		if p, err := proc.Cwd(d.term().Pid()); err == nil {
+			return p
+		}
+	}
+	return d.workdir
+}
+
+func (d *Daemon) childExited() bool {
+	select {
+	case <-d.child().done:
+		return true
+	default:
+		return false
+	}
+}
+
+// health is the daemon's state as list-sessions reports it: "running"
+// while the pane process runs, "draining" from its exit until the daemon
+// has read its last output and, unless it remains on exit, through the
+// grace period before it shuts down, and "child-exited" while it remains.
+func (d *Daemon) health() string {
+	switch {
+	case !d.child().exited.Load():
+		return "running"
+	case !d.childExited() || d.closing.Load():
+		return "draining"
+	}
+	return "child-exited"
+}
+
+func flag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (d *Daemon) handleDisplay(req ipc.Request) ipc.Response {
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultDisplayFormat
+	}
+	return ipc.Response{OK: true, Output: format.Expand(tmpl, d.formatVars())}
+}
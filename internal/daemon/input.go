@@ -0,0 +1,187 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+)
+
+// inputHistoryLimit is the number of input events kept while
+// record-input is on; older events are discarded.
+const inputHistoryLimit = 1000
+
+// replayMaxGap caps each pause reproduced by replay-input --timing, so a
+// recording that spans a coffee break does not replay as one.
+const replayMaxGap = 2 * time.Second
+
+const defaultInputFormat = "#{input_index} #{input_time} #{input_client} #{input_kind} #{input_data}"
+
+// inputEvent is one write to the pane made on behalf of a client.
+type inputEvent struct {
+	time   time.Time
+	client string
+	kind   string // "text", "ime", "key", or "attach"/"pipe" for raw input
+	name   string // key name for kind "key"
+	data   []byte // bytes written to the terminal
+}
+
+// inputLog records input events when the record-input option is on.
+// index counts every event ever recorded, so numbers shown by
+// show-input-history stay valid as old events are dropped.
+type inputLog struct {
+	mu     sync.Mutex
+	events []inputEvent
+	first  int // index of events[0]
+}
+
+func (l *inputLog) add(ev inputEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+	if over := len(l.events) - inputHistoryLimit; over > 0 {
+		l.events = append(l.events[:0:0], l.events[over:]...)
+		l.first += over
+	}
+}
+
+// next returns the index the next recorded event will get.
+func (l *inputLog) next() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.first + len(l.events)
+}
+
+// slice returns copies of events with index >= start, at most count of
+// them (count <= 0 means all), together with the index of the first.
+func (l *inputLog) slice(start, count int) ([]inputEvent, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if start < l.first {
+		start = l.first
+	}
+	off := start - l.first
+	if off >= len(l.events) {
+		return nil, start
+	}
+	evs := l.events[off:]
+	if count > 0 && count < len(evs) {
+		evs = evs[:count]
+	}
+	return append([]inputEvent(nil), evs...), start
+}
+
+// handleSendText writes composed text, as an input method commits it,
+// to the pane in one write. Unlike send_keys text it is checked to be
+// text: valid UTF-8 without control characters, so a stray Enter or
+// escape sequence cannot ride along with it. It is never encoded for the
+// application's keyboard protocol: with the kitty protocol reporting every
+// key as an escape code, text from an input method still arrives as
+// plain UTF-8, as it does from a terminal.
+func (d *Daemon) handleSendText(req ipc.Request) ipc.Response {
+	if err := checkComposedText(req.Text); err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	if err := d.writeInput(req.Client, "ime", "", []byte(req.Text)); err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	return ipc.Response{OK: true}
+}
+
+// checkComposedText reports why text cannot be sent as composed text.
+func checkComposedText(text string) error {
+	if text == "" {
+		return fmt.Errorf("no text to send")
+	}
+	if !utf8.ValidString(text) {
+		return fmt.Errorf("text is not valid UTF-8")
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("text contains control character %U; send keys for it", r)
+		}
+	}
+	return nil
+}
+
+// writeInput sends data to the pane and, if record-input is on, records
+// it against the requesting client.
+func (d *Daemon) writeInput(client, kind, name string, data []byte) error {
+	if _, err := d.term().Write(data); err != nil {
+		return err
+	}
+	d.clearAlerts()
+	if d.option("record-input") == "on" {
+		d.input.add(inputEvent{
+			time:   time.Now(),
+			client: client,
+			kind:   kind,
+			name:   name,
+			data:   append([]byte(nil), data...),
+		})
+	}
+	d.macros.add(inputEvent{time: time.Now(), client: client, kind: kind, name: name, data: append([]byte(nil), data...)})
+	return nil
+}
+
+func (d *Daemon) handleInputHistory(req ipc.Request) ipc.Response {
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultInputFormat
+	}
+	evs, first := d.input.slice(req.Start, req.Count)
+	lines := make([]string, 0, len(evs))
+	for i, ev := range evs {
+		data := strconv.Quote(d.redact(string(ev.data)))
+		if ev.kind == "key" {
+			data = ev.name
+		}
+		lines = append(lines, format.Expand(tmpl, map[string]string{
+			"input_index":  strconv.Itoa(first + i),
+			"input_time":   ev.time.Format(time.RFC3339Nano),
+			"input_client": ev.client,
+			"input_kind":   ev.kind,
+			"input_data":   data,
+			"input_bytes":  strconv.Itoa(len(ev.data)),
+		}))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+// handleReplayInput writes recorded events back to the pane, optionally
+// reproducing the original pauses between them. Replayed input is not
+// recorded again.
+func (d *Daemon) handleReplayInput(req ipc.Request, p *progress) ipc.Response {
+	evs, _ := d.input.slice(req.Start, req.Count)
+	if len(evs) == 0 {
+		return ipc.Response{OK: false, Error: "no recorded input to replay"}
+	}
+
+	var deadline time.Time
+	if req.TimeoutMs > 0 {
+		deadline = time.Now().Add(time.Duration(req.TimeoutMs) * time.Millisecond)
+	}
+	for i, ev := range evs {
+		if req.Timing && i > 0 {
+			gap := ev.time.Sub(evs[i-1].time)
+			if gap > replayMaxGap {
+				gap = replayMaxGap
+			}
+			time.Sleep(gap)
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("timed out after replaying %d of %d events", i, len(evs))}
+		}
+		if _, err := d.term().Write(ev.data); err != nil {
+			return ipc.Response{OK: false, Error: err.Error()}
+		}
+		p.report("replayed %d of %d events", i+1, len(evs))
+	}
+	return ipc.Response{OK: true, Output: fmt.Sprintf("replayed %d events", len(evs))}
+}
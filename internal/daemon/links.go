@@ -0,0 +1,35 @@
+package daemon
+
+import (
+	"strconv"
+	"strings"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+)
+
+// defaultLinkFormat prints each link's target and its text.
+const defaultLinkFormat = "#{link_url} #{link_text}"
+
+// handleListLinks reports the OSC 8 hyperlinks visible on the screen,
+// one per run of linked cells, in reading order. Targets and text are
+// redacted as captures are, since a URL may carry a token.
+func (d *Daemon) handleListLinks(req ipc.Request) ipc.Response {
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultLinkFormat
+	}
+	links := d.screen.Links()
+	lines := make([]string, 0, len(links))
+	for _, l := range links {
+		lines = append(lines, format.Expand(tmpl, map[string]string{
+			"link_url":    d.redact(l.URI),
+			"link_text":   d.redact(l.Text),
+			"link_id":     l.ID,
+			"link_params": d.redact(l.Params),
+			"link_x":      strconv.Itoa(l.X),
+			"link_y":      strconv.Itoa(l.Y),
+		}))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
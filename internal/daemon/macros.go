@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/ipc"
+)
+
+// maxMacros bounds how many named macros a session keeps, and
+// maxMacroEvents how much input one may hold.
+const (
+	maxMacros      = 100
+	maxMacroEvents = 10000
+)
+
+// maxPlayCount bounds play-keys -N.
+const maxPlayCount = 1000
+
+// macro is input recorded under a name by record-keys, for play-keys.
+type macro struct {
+	created   time.Time
+	events    []inputEvent
+	truncated bool // input beyond maxMacroEvents was not kept
+}
+
+// macroSet holds the session's macros and the one being recorded.
+type macroSet struct {
+	mu        sync.Mutex
+	byName    map[string]*macro
+	recording string // name of the macro being recorded; "" when none
+	rec       *macro
+}
+
+// add records an input event into the macro being recorded, if any.
+func (s *macroSet) add(ev inputEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec == nil {
+		return
+	}
+	if len(s.rec.events) == maxMacroEvents {
+		s.rec.truncated = true
+		return
+	}
+	s.rec.events = append(s.rec.events, ev)
+}
+
+// handleRecordKeys starts or stops recording a macro, or lists or deletes
+// macros, as req.Option says. Every write to the pane counts while
+// recording: keys typed by an attached client, send-keys and send-text,
+// from any client. Recording under an existing name replaces it on stop.
+func (d *Daemon) handleRecordKeys(req ipc.Request) ipc.Response {
+	s := &d.macros
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Option {
+	case "start":
+		if req.Name == "" {
+			return ipc.Response{OK: false, Error: "no macro name specified"}
+		}
+		if s.rec != nil {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("already recording %s", s.recording)}
+		}
+		if _, ok := s.byName[req.Name]; !ok && len(s.byName) >= maxMacros {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("too many macros (max %d)", maxMacros)}
+		}
+		s.recording, s.rec = req.Name, &macro{created: time.Now()}
+		return ipc.Response{OK: true}
+	case "stop":
+		if s.rec == nil {
+			return ipc.Response{OK: false, Error: "not recording"}
+		}
+		if req.Name != "" && req.Name != s.recording {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("recording %s, not %s", s.recording, req.Name)}
+		}
+		name, m := s.recording, s.rec
+		s.recording, s.rec = "", nil
+		if len(m.events) == 0 {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("nothing recorded; %s not saved", name)}
+		}
+		if s.byName == nil {
+			s.byName = make(map[string]*macro)
+		}
+		s.byName[name] = m
+		out := fmt.Sprintf("recorded %s: %s", name, m.summary())
+		if m.truncated {
+			out += fmt.Sprintf(" (input after the first %d events was not kept)", maxMacroEvents)
+		}
+		return ipc.Response{OK: true, Output: out}
+	case "list":
+		names := make([]string, 0, len(s.byName))
+		for name := range s.byName {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return s.byName[names[i]].created.Before(s.byName[names[j]].created)
+		})
+		lines := make([]string, 0, len(names)+1)
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("%s %s %s", name, s.byName[name].created.Format(time.RFC3339), s.byName[name].summary()))
+		}
+		if s.rec != nil {
+			lines = append(lines, fmt.Sprintf("%s (recording) %s", s.recording, s.rec.summary()))
+		}
+		return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+	case "delete":
+		if _, ok := s.byName[req.Name]; !ok {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("no macro: %s", req.Name)}
+		}
+		delete(s.byName, req.Name)
+		return ipc.Response{OK: true}
+	}
+	return ipc.Response{OK: false, Error: fmt.Sprintf("unknown record-keys mode %q (expected start, stop, list or delete)", req.Option)}
+}
+
+func (m *macro) summary() string {
+	n := 0
+	for _, ev := range m.events {
+		n += len(ev.data)
+	}
+	return fmt.Sprintf("%d events, %d bytes", len(m.events), n)
+}
+
+// handlePlayKeys writes a macro's input to the pane req.Count times (once
+// by default), byte for byte as recorded. With Timing the recorded pauses
+// are reproduced, each capped as for replay-input. Played input is not
+// recorded again, so a macro can be played while another is recorded
+// without nesting.
+func (d *Daemon) handlePlayKeys(req ipc.Request, p *progress) ipc.Response {
+	d.macros.mu.Lock()
+	m, ok := d.macros.byName[req.Name]
+	d.macros.mu.Unlock()
+	if !ok {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("no macro: %s", req.Name)}
+	}
+	count := req.Count
+	if count == 0 {
+		count = 1
+	}
+	if count < 0 || count > maxPlayCount {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("invalid count %d (1 to %d)", req.Count, maxPlayCount)}
+	}
+
+	var deadline time.Time
+	if req.TimeoutMs > 0 {
+		deadline = time.Now().Add(time.Duration(req.TimeoutMs) * time.Millisecond)
+	}
+	for n := 0; n < count; n++ {
+		for i, ev := range m.events {
+			if req.Timing && i > 0 {
+				time.Sleep(min(ev.time.Sub(m.events[i-1].time), replayMaxGap))
+			}
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return ipc.Response{OK: false, Error: fmt.Sprintf("timed out after playing %s %d of %d times", req.Name, n, count)}
+			}
+			if _, err := d.term().Write(ev.data); err != nil {
+				return ipc.Response{OK: false, Error: err.Error()}
+			}
+			p.report("playing %s: %d of %d times", req.Name, n+1, count)
+		}
+	}
+	return ipc.Response{OK: true}
+}
@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"wintmux/internal/ipc"
+)
+
+// maxMirrorChunk bounds how much queued output one mirror_output request
+// carries.
+const maxMirrorChunk = 64 * 1024
+
+// mirrorDest sends a sink's output to another session, which shows it in
+// its pane as if its own process had written it. Output is queued and
+// sent from a goroutine of its own over one connection, reconnecting
+// once when the target has dropped it (say, after its idle timeout). A
+// target that is gone or falls pipeQueue writes behind gets no more
+// output, and pipe-list says why.
+type mirrorDest struct {
+	socket string
+	client string
+	crlf   bool // line-based sink: end lines with CRLF for the target screen
+	queue  chan []byte
+	once   sync.Once
+	conn   net.Conn
+
+	mu     sync.Mutex
+	failed string
+}
+
+// startMirror checks that socket names another session and starts
+// sending to it.
+func (d *Daemon) startMirror(socket string, lines bool) (*mirrorDest, error) {
+	if !filepath.IsAbs(socket) {
+		return nil, fmt.Errorf("mirror target must be an absolute socket path: %s", socket)
+	}
+	if filepath.Clean(socket) == filepath.Clean(d.socketPath) {
+		return nil, fmt.Errorf("cannot mirror a session into itself")
+	}
+	m := &mirrorDest{
+		socket: socket,
+		client: "mirror:" + d.sessionName,
+		crlf:   lines,
+		queue:  make(chan []byte, pipeQueue),
+	}
+	if err := m.dial(); err != nil {
+		return nil, fmt.Errorf("mirror target: %w", err)
+	}
+	go m.run()
+	return m, nil
+}
+
+func (m *mirrorDest) dial() error {
+	conn, err := ipc.Connect(m.socket)
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+	return nil
+}
+
+func (m *mirrorDest) run() {
+	for data := range m.queue {
+		// Send whatever else is already queued along with it.
+	drain:
+		for len(data) < maxMirrorChunk {
+			select {
+			case more, ok := <-m.queue:
+				if !ok {
+					break drain
+				}
+				data = append(data, more...)
+			default:
+				break drain
+			}
+		}
+		if m.failure() != "" {
+			continue
+		}
+		if err := m.send(data); err != nil {
+			log.Printf("daemon: mirror to %s: %v", m.socket, err)
+			m.fail("target gone")
+		}
+	}
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
+
+// send delivers data, redialing once if the connection has gone stale.
+func (m *mirrorDest) send(data []byte) error {
+	err := m.exchange(data)
+	if err == nil {
+		return nil
+	}
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+	}
+	if err := m.dial(); err != nil {
+		return err
+	}
+	return m.exchange(data)
+}
+
+func (m *mirrorDest) exchange(data []byte) error {
+	if m.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	m.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	req := ipc.Request{Action: ipc.ActionMirrorOutput, Client: m.client, Data: data}
+	if err := ipc.WriteMessage(m.conn, &req); err != nil {
+		return err
+	}
+	var resp ipc.Response
+	if err := ipc.ReadMessage(m.conn, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
+}
+
+func (m *mirrorDest) write(data []byte) {
+	if m.failure() != "" {
+		return
+	}
+	if m.crlf {
+		data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	} else {
+		data = append([]byte(nil), data...)
+	}
+	select {
+	case m.queue <- data:
+	default:
+		log.Printf("daemon: mirror to %s is not keeping up; dropping its output", m.socket)
+		m.fail("too slow")
+	}
+}
+
+func (m *mirrorDest) fail(why string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failed == "" {
+		m.failed = why
+	}
+}
+
+func (m *mirrorDest) failure() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.failed
+}
+
+func (m *mirrorDest) close() {
+	m.once.Do(func() { close(m.queue) })
+}
+
+func (m *mirrorDest) describe() string {
+	s := fmt.Sprintf("mirror %q", m.socket)
+	if why := m.failure(); why != "" {
+		s += " (" + why + ")"
+	}
+	return s
+}
+
+// handleMirrorOutput shows output mirrored from another session in this
+// pane: it goes to the history, the screen, watches and attached clients
+// like the pane's own output, but not to this session's pipe sinks, so
+// two sessions mirroring each other cannot loop.
+func (d *Daemon) handleMirrorOutput(req ipc.Request) ipc.Response {
+	if len(req.Data) > 0 {
+		d.showOutput(req.Data, 0, true)
+	}
+	return ipc.Response{OK: true}
+}
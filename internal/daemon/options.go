@@ -0,0 +1,354 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"wintmux/internal/codepage"
+	"wintmux/internal/ipc"
+	"wintmux/internal/pty"
+	"wintmux/internal/screen"
+	"wintmux/internal/units"
+)
+
+// optionSetter validates and applies one option value. It is called with
+// d.optionsMu held.
+type optionSetter func(d *Daemon, value string) error
+
+// optionDefaults holds the value of options that have not been set.
+var optionDefaults = map[string]string{
+	"remain-on-exit":  "off",
+	"persist-options": "off",
+	"record-input":    "off",
+	"history-sample":  "off",
+	"pane-encoding":   "utf-8",
+	"conpty-flags":    "none",
+	"pane-backend":    "auto",
+	"focus-events":    "off",
+	"ambiguous-width": "1",
+	"stuck-after":     "off",
+	"child-console":   "inherit",
+	// Windows has no terminfo; this is the name MSYS2, Cygwin, Git for
+	// Windows and WSL ship an entry for and what ConPTY emulates.
+	"default-terminal": "xterm-256color",
+	// Same default list as tmux.
+	"update-environment": "DISPLAY KRB5CCNAME SSH_ASKPASS SSH_AUTH_SOCK SSH_AGENT_PID SSH_CONNECTION WINDOWID XAUTHORITY",
+	// The stock prompts: C:\Users\me>, PS C:\> and user@host:~$ (or #
+	// for root, % for zsh, ❯ for starship and similar themes).
+	"prompt-pattern-cmd":        `^(?:[A-Za-z]:|\\\\).*>$`,
+	"prompt-pattern-powershell": `^PS(?: .*)?>$`,
+	"prompt-pattern-posix":      `[$#%❯]$`,
+}
+
+// commandOptions are the options whose value is a command the daemon
+// runs, which client locks treat as input.
+var commandOptions = map[string]bool{
+	"alert-bell-hook":     true,
+	"alert-resource-hook": true,
+	"alert-stuck-hook":    true,
+	"alert-ttl-hook":      true,
+	"stuck-probe":         true,
+}
+
+// sessionOptions lists every option accepted by set-option.
+var sessionOptions = map[string]optionSetter{
+	"remain-on-exit": func(d *Daemon, v string) error {
+		return checkFlag(v)
+	},
+	"persist-options": func(d *Daemon, v string) error {
+		return checkFlag(v)
+	},
+	"record-input": func(d *Daemon, v string) error {
+		return checkFlag(v)
+	},
+	"focus-events": func(d *Daemon, v string) error {
+		if err := checkFlag(v); err != nil {
+			return err
+		}
+		mode := focusModeOff
+		if v == "on" {
+			mode = focusModeOn
+		}
+		d.attached.toTerminals([]byte(mode))
+		return nil
+	},
+	"alert-bell-hook": func(d *Daemon, v string) error {
+		return nil
+	},
+	"alert-resource-hook": func(d *Daemon, v string) error {
+		return nil
+	},
+	"alert-cpu": func(d *Daemon, v string) error {
+		if _, err := parseLimitInt(v, 0); err != nil {
+			return err
+		}
+		d.cpuAlert.Store(false) // alert again at the new threshold
+		return nil
+	},
+	"alert-memory": func(d *Daemon, v string) error {
+		if _, err := parseLimitSize(v); err != nil {
+			return err
+		}
+		d.memoryAlert.Store(false) // alert again at the new threshold
+		return nil
+	},
+	"stuck-after": func(d *Daemon, v string) error {
+		_, err := parseInterval(v)
+		return err
+	},
+	"stuck-probe": func(d *Daemon, v string) error {
+		return nil
+	},
+	"alert-stuck-hook": func(d *Daemon, v string) error {
+		return nil
+	},
+	"alert-ttl-hook": func(d *Daemon, v string) error {
+		return nil
+	},
+	"child-console": func(d *Daemon, v string) error {
+		return checkChildConsole(v)
+	},
+	"prompt-pattern-cmd": func(d *Daemon, v string) error {
+		return checkPromptPattern(v)
+	},
+	"prompt-pattern-powershell": func(d *Daemon, v string) error {
+		return checkPromptPattern(v)
+	},
+	"prompt-pattern-posix": func(d *Daemon, v string) error {
+		return checkPromptPattern(v)
+	},
+	"kill-on-memory": func(d *Daemon, v string) error {
+		_, err := parseLimitSize(v)
+		return err
+	},
+	"ambiguous-width": func(d *Daemon, v string) error {
+		if v != "1" && v != "2" {
+			return fmt.Errorf("invalid ambiguous-width value (expected 1 or 2)")
+		}
+		d.screen.SetAmbiguousWide(v == "2")
+		return nil
+	},
+	"capture-tabs": func(d *Daemon, v string) error {
+		if v != "expand" && v != "keep" {
+			return fmt.Errorf("invalid capture-tabs value %q (expected expand or keep)", v)
+		}
+		d.screen.SetKeepTabs(v == "keep")
+		return nil
+	},
+	"update-environment": func(d *Daemon, v string) error {
+		return nil
+	},
+	"history-limit": func(d *Daemon, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid history-limit value")
+		}
+		d.buffer.SetCapacity(n)
+		return nil
+	},
+	"history-sample": func(d *Daemon, v string) error {
+		iv, err := parseInterval(v)
+		if err != nil {
+			return err
+		}
+		d.buffer.SetSampleInterval(iv)
+		return nil
+	},
+	"scroll-region-history": func(d *Daemon, v string) error {
+		modes := map[string]screen.RegionHistory{
+			"off":  screen.RegionHistoryOff,
+			"tmux": screen.RegionHistoryTmux,
+			"all":  screen.RegionHistoryAll,
+		}
+		mode, ok := modes[v]
+		if !ok {
+			return fmt.Errorf("invalid scroll-region-history value %q (expected off, tmux or all)", v)
+		}
+		d.screen.SetRegionHistory(mode)
+		return nil
+	},
+	"pane-encoding": func(d *Daemon, v string) error {
+		dec, err := codepage.NewDecoder(v)
+		if err != nil {
+			return err
+		}
+		d.decoder.Store(dec)
+		return nil
+	},
+	"conpty-flags": func(d *Daemon, v string) error {
+		f, err := checkConptyFlags(v)
+		if err != nil {
+			return err
+		}
+		pty.SetFlags(f)
+		return nil
+	},
+	"pane-backend": func(d *Daemon, v string) error {
+		return pty.SetBackend(v)
+	},
+	"default-terminal": func(d *Daemon, v string) error {
+		return checkTerminalName(v)
+	},
+	"pane-memory-limit": func(d *Daemon, v string) error {
+		n, err := parseLimitSize(v)
+		if err != nil {
+			return err
+		}
+		l := d.limits
+		l.MemoryBytes = n
+		return d.applyLimits(l)
+	},
+	"pane-cpu-limit": func(d *Daemon, v string) error {
+		n, err := parseLimitInt(v, 100)
+		if err != nil {
+			return err
+		}
+		l := d.limits
+		l.CPUPercent = n
+		return d.applyLimits(l)
+	},
+	"pane-process-limit": func(d *Daemon, v string) error {
+		n, err := parseLimitInt(v, 0)
+		if err != nil {
+			return err
+		}
+		l := d.limits
+		l.MaxProcesses = n
+		return d.applyLimits(l)
+	},
+}
+
+func (d *Daemon) handleSetOption(req ipc.Request) ipc.Response {
+	d.optionsMu.Lock()
+	if err := d.setOptionLocked(req.Option, req.Value, req.Window); err != nil {
+		d.optionsMu.Unlock()
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	d.optionsMu.Unlock()
+	d.bus.publish(busEvent{kind: busOption, option: req.Option, value: req.Value})
+	return ipc.Response{OK: true}
+}
+
+// setOptionLocked validates and applies an option and records its value.
+// With window set only a window option is accepted (set-option -w);
+// otherwise, as in tmux, the option's name says which scope it is in.
+func (d *Daemon) setOptionLocked(name, value string, window bool) error {
+	if set, ok := windowOptions[name]; ok {
+		if err := set(d, value); err != nil {
+			return err
+		}
+		d.windowOpts[name] = value
+		return nil
+	}
+	set, ok := sessionOptions[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown option: %s", name)
+	case window:
+		return fmt.Errorf("not a window option: %s", name)
+	}
+	if err := set(d, value); err != nil {
+		return err
+	}
+	d.options[name] = value
+	return nil
+}
+
+// setOptions returns every option set so far, of either scope. Option
+// names are unique across scopes, so setOptionLocked can apply them
+// again, as clone-session and persist-options do.
+func (d *Daemon) setOptions() map[string]string {
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	options := make(map[string]string, len(d.options)+len(d.windowOpts))
+	for name, v := range d.options {
+		options[name] = v
+	}
+	for name, v := range d.windowOpts {
+		options[name] = v
+	}
+	return options
+}
+
+// option returns the current value of an option, or its default.
+func (d *Daemon) option(name string) string {
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	return d.optionLocked(name)
+}
+
+func (d *Daemon) optionLocked(name string) string {
+	if v, ok := d.options[name]; ok {
+		return v
+	}
+	return optionDefaults[name]
+}
+
+// remainOnExit reports whether the daemon should outlive its child.
+func (d *Daemon) remainOnExit() bool {
+	return d.option("remain-on-exit") == "on"
+}
+
+// checkFlag validates an on/off option value.
+func checkFlag(v string) error {
+	if v != "on" && v != "off" {
+		return fmt.Errorf("invalid value %q (expected on or off)", v)
+	}
+	return nil
+}
+
+// parseInterval parses a sampling interval: Go duration syntax, a bare
+// number of milliseconds, or "off"/"0" to disable.
+func parseInterval(v string) (time.Duration, error) {
+	if v == "off" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Millisecond, nil
+	}
+	iv, err := time.ParseDuration(v)
+	if err != nil || iv < 0 {
+		return 0, fmt.Errorf("invalid interval %q", v)
+	}
+	return iv, nil
+}
+
+// applyLimits pushes resource limits down to the terminal, if it supports
+// them, and remembers them on success.
+func (d *Daemon) applyLimits(l pty.Limits) error {
+	lim, ok := d.term().(pty.Limiter)
+	if !ok {
+		return fmt.Errorf("resource limits are not supported by this terminal backend")
+	}
+	if err := lim.SetLimits(l); err != nil {
+		return err
+	}
+	d.limits = l
+	return nil
+}
+
+// parseLimitSize parses a memory limit; "none" or "0" removes it.
+func parseLimitSize(v string) (uint64, error) {
+	if v == "none" {
+		return 0, nil
+	}
+	n, err := units.ParseSize(v)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// parseLimitInt parses a count limit; "none" or "0" removes it. max of 0
+// means unbounded.
+func parseLimitInt(v string, max int) (int, error) {
+	if v == "none" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || (max > 0 && n > max) {
+		return 0, fmt.Errorf("invalid limit value %q", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"strconv"
+
+	"wintmux/internal/screen"
+)
+
+// noteProgress emits a "progress" event when the task progress the pane
+// reports (OSC 9;4) has changed since the last call. Called after each
+// output chunk reaches the screen.
+func (d *Daemon) noteProgress() {
+	p := d.screen.Progress()
+	d.progressMu.Lock()
+	changed := p != d.progress
+	d.progress = p
+	d.progressMu.Unlock()
+	if !changed {
+		return
+	}
+	d.events.emit("progress", progressText(p), map[string]string{
+		"progress_state": progressState(p),
+		"progress_value": progressValue(p),
+	})
+}
+
+// paneProgress is the pane's reported progress; a dead pane reports none.
+func (d *Daemon) paneProgress() screen.Progress {
+	if d.childExited() {
+		return screen.Progress{}
+	}
+	return d.screen.Progress()
+}
+
+// progressState names p's state, "none" when no progress is shown.
+func progressState(p screen.Progress) string {
+	if p.State == "" {
+		return "none"
+	}
+	return p.State
+}
+
+// progressValue is p's percentage, "" when it has none.
+func progressValue(p screen.Progress) string {
+	if p.State == "" || p.State == "indeterminate" {
+		return ""
+	}
+	return strconv.Itoa(p.Value)
+}
+
+// progressText describes p for an event line: "42%", "error 42%",
+// "indeterminate" or "none".
+func progressText(p screen.Progress) string {
+	switch p.State {
+	case "", "indeterminate":
+		return progressState(p)
+	case "normal":
+		return progressValue(p) + "%"
+	}
+	return p.State + " " + progressValue(p) + "%"
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+)
+
+// optionsFileSuffix names the file, next to the control file, that
+// persist-options keeps the session's options in.
+const optionsFileSuffix = ".options"
+
+// terminalOptions apply to the pane's terminal, so a restarted daemon
+// restores them once the pane process is running.
+var terminalOptions = map[string]bool{
+	"pane-memory-limit":  true,
+	"pane-cpu-limit":     true,
+	"pane-process-limit": true,
+}
+
+func (d *Daemon) optionsPath() string {
+	return d.socketPath + optionsFileSuffix
+}
+
+// saveOptions writes every option set so far to the options file while
+// persist-options is on, and removes the file when it is off.
+func (d *Daemon) saveOptions() {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+	options := d.setOptions()
+	persist := options["persist-options"] == "on"
+
+	path := d.optionsPath()
+	if !persist {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("daemon: remove options file: %v", err)
+		}
+		return
+	}
+	data, err := json.MarshalIndent(options, "", "  ")
+	if err != nil {
+		log.Printf("daemon: save options: %v", err)
+		return
+	}
+	// Write and rename, so a daemon killed mid-write leaves the previous
+	// options rather than half of them.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		log.Printf("daemon: save options: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		log.Printf("daemon: save options: %v", err)
+	}
+}
+
+// loadOptions reads the options file a previous daemon for the session
+// saved. A missing file is no options.
+func (d *Daemon) loadOptions() (map[string]string, error) {
+	data, err := os.ReadFile(d.optionsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var options map[string]string
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, fmt.Errorf("%s: %w", d.optionsPath(), err)
+	}
+	return options, nil
+}
+
+// restoreOptions applies the saved options that want accepts, in name
+// order, as set-option would. One that this daemon no longer accepts, as
+// after an upgrade, is logged and left out.
+func (d *Daemon) restoreOptions(saved map[string]string, want func(name string) bool) {
+	names := make([]string, 0, len(saved))
+	for name := range saved {
+		if want(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	for _, name := range names {
+		if err := d.setOptionLocked(name, saved[name], false); err != nil {
+			log.Printf("daemon: not restoring option %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,522 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/units"
+	"wintmux/internal/vt"
+)
+
+// maxCleanLine bounds how much output a line-based sink holds while
+// waiting for a newline; a longer line (say, a spinner that never ends
+// its line) is written out as it stands.
+const maxCleanLine = 64 * 1024
+
+// defaultKeep is how many rotated files pipe-pane keeps when only
+// --rotate-size is given.
+const defaultKeep = 5
+
+// maxPipes bounds the sinks per session, since all pane output is
+// written to each of them.
+const maxPipes = 16
+
+// pipePaneName names the sink pipe-pane manages. pipe-pane replaces only
+// that sink, as tmux's does; sinks added with pipe-add are left alone.
+const pipePaneName = "pipe-pane"
+
+// pipeQueue is how many writes may wait for a slow command sink before
+// its output is dropped. The pane reader never blocks on a command.
+const pipeQueue = 256
+
+// stampFormat is the ISO-8601 timestamp put before each line with
+// --timestamps, followed by a space.
+const stampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// pipeDest is where a sink's formatted output goes: a file, a shell
+// command's stdin, the event log or another session's pane.
+type pipeDest interface {
+	write(p []byte)
+	close()
+	describe() string
+}
+
+// pipeSink is one destination of pane output. Raw sinks get the pane's
+// bytes as they are; clean sinks get one vt.Clean line per line of
+// output, so the file reads like the screen did. Event sinks are always
+// line-based. With timestamps, each line starts with the time its first
+// byte arrived. Lines mirrored into another pane start with prefix, the
+// source session's name, so several sessions can share one view.
+// Output passes through the session's redactions on the way, by line;
+// a raw sink holds back an unfinished line until the rest of it arrives
+// or the sink closes.
+type pipeSink struct {
+	name       string
+	dest       pipeDest
+	redact     func(string) string
+	raw        redactStream // raw: redacts the byte stream
+	clean      bool
+	lines      bool // split output into lines: clean and event sinks
+	timestamps bool
+	prefix     string
+	written    int64
+	partial    []byte    // lines: output since the last newline
+	lineAt     time.Time // lines: when partial started arriving
+	midLine    bool      // raw: the last byte written was not a newline
+}
+
+// openPipeSink creates the sink a pipe_pane or pipe_add request asks
+// for: the event log with Events, another session with MirrorTo, a file
+// for "cat >> path", and any other command run through the shell with
+// output on its stdin. Formats in the command are expanded first.
+func (d *Daemon) openPipeSink(name string, req ipc.Request) (*pipeSink, error) {
+	req.ShellCmd = d.expandCommand(req.ShellCmd)
+	path := extractPipePath(req.ShellCmd)
+	if req.RotateSize > 0 && (req.Events || req.MirrorTo != "" || path == "") {
+		return nil, fmt.Errorf("--rotate-size needs a 'cat >> path' sink")
+	}
+	s := &pipeSink{
+		name:       name,
+		redact:     d.redact,
+		raw:        redactStream{d: d},
+		clean:      req.Clean,
+		lines:      req.Clean || req.Events,
+		timestamps: req.Timestamps,
+	}
+	switch {
+	case req.Events && req.ShellCmd != "", req.MirrorTo != "" && (req.Events || req.ShellCmd != ""):
+		return nil, fmt.Errorf("a pipe has one destination")
+	case req.Events:
+		s.dest = &eventDest{d: d, name: name}
+	case req.MirrorTo != "":
+		m, err := d.startMirror(req.MirrorTo, s.lines)
+		if err != nil {
+			return nil, err
+		}
+		s.dest = m
+		if s.lines {
+			s.prefix = "[" + d.sessionName + "] "
+		}
+	case path != "":
+		f, err := openRotatingFile(path, req.RotateSize, req.Keep)
+		if err != nil {
+			return nil, err
+		}
+		s.dest = f
+	default:
+		c, err := d.startPipeCommand(name, req.ShellCmd)
+		if err != nil {
+			return nil, err
+		}
+		s.dest = c
+	}
+	return s, nil
+}
+
+func (s *pipeSink) write(data []byte) {
+	now := time.Now()
+	if !s.lines {
+		s.writeRaw(s.raw.write(data), now)
+		return
+	}
+	if len(s.partial) == 0 {
+		s.lineAt = now
+	}
+	s.partial = append(s.partial, data...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		s.writeLine(s.partial[:i], true)
+		s.partial = s.partial[i+1:]
+		s.lineAt = now
+	}
+	if len(s.partial) > maxCleanLine {
+		s.writeLine(s.partial, true)
+		s.partial = nil
+	}
+	// Keep the backing array from growing without bound.
+	s.partial = append([]byte(nil), s.partial...)
+}
+
+func (s *pipeSink) writeRaw(data []byte, now time.Time) {
+	if len(data) == 0 {
+		return
+	}
+	if s.timestamps {
+		data = s.stampLines(data, now)
+	}
+	s.emit(data)
+}
+
+// stampLines puts a timestamp before every line that starts in data.
+func (s *pipeSink) stampLines(data []byte, now time.Time) []byte {
+	stamp := now.Format(stampFormat) + " "
+	out := make([]byte, 0, len(data)+len(stamp))
+	for len(data) > 0 {
+		if !s.midLine {
+			out = append(out, stamp...)
+			s.midLine = true
+		}
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			out = append(out, data...)
+			break
+		}
+		out = append(out, data[:i+1]...)
+		data = data[i+1:]
+		s.midLine = false
+	}
+	return out
+}
+
+func (s *pipeSink) writeLine(line []byte, newline bool) {
+	text := string(line)
+	if s.clean {
+		text = vt.Clean(text)
+	} else {
+		text = strings.TrimSuffix(text, "\r")
+	}
+	text = s.redact(text)
+	if s.timestamps {
+		text = s.lineAt.Format(stampFormat) + " " + text
+	}
+	text = s.prefix + text
+	if newline {
+		text += "\n"
+	}
+	s.emit([]byte(text))
+}
+
+func (s *pipeSink) emit(data []byte) {
+	s.written += int64(len(data))
+	s.dest.write(data)
+}
+
+// close writes out any unfinished line and closes the destination.
+func (s *pipeSink) close() {
+	if s.lines && len(s.partial) > 0 {
+		s.writeLine(s.partial, false)
+	}
+	if !s.lines {
+		s.writeRaw(s.raw.flush(), time.Now())
+	}
+	s.dest.close()
+}
+
+// describe returns the sink's pipe-list line.
+func (s *pipeSink) describe() string {
+	line := s.name + " " + s.dest.describe()
+	if s.clean {
+		line += " clean"
+	}
+	if s.timestamps {
+		line += " timestamps"
+	}
+	return line + " written=" + units.FormatSize(uint64(s.written))
+}
+
+// rotatingFile appends to a file. With rotateSize set, a write that would
+// take the file past it first renames the file to path.1 (path.1 to
+// path.2, and so on, dropping the oldest beyond keep) and starts a new
+// one. Line-based sinks rotate between lines, raw sinks between reads of
+// pane output.
+type rotatingFile struct {
+	path       string
+	f          *os.File
+	size       int64
+	rotateSize int64
+	keep       int
+}
+
+func openRotatingFile(path string, rotateSize int64, keep int) (*rotatingFile, error) {
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, f: f, rotateSize: rotateSize, keep: keep}
+	if fi, err := f.Stat(); err == nil {
+		r.size = fi.Size()
+	}
+	if r.rotateSize > 0 && r.keep <= 0 {
+		r.keep = defaultKeep
+	}
+	return r, nil
+}
+
+// write appends data to the file, rotating first if it is due.
+func (r *rotatingFile) write(data []byte) {
+	if r.rotateSize > 0 && r.size > 0 && r.size+int64(len(data)) > r.rotateSize {
+		if err := r.rotate(); err != nil {
+			log.Printf("daemon: pipe-pane rotate %s: %v", r.path, err)
+		}
+	}
+	n, _ := r.f.Write(data)
+	r.size += int64(n)
+}
+
+// rotate shifts path.N to path.N+1 for each kept file, moves the current
+// file to path.1 and reopens path empty. If the current file cannot be
+// renamed (another process has it open, on Windows), output keeps going
+// to it and the next attempt is rotateSize bytes later.
+func (r *rotatingFile) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	// Windows cannot rename an open file.
+	r.f.Close()
+	renameErr := os.Rename(r.path, r.path+".1")
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.size = 0
+	return renameErr
+}
+
+func (r *rotatingFile) close() { r.f.Close() }
+
+func (r *rotatingFile) describe() string {
+	s := "file " + strconv.Quote(r.path)
+	if r.rotateSize > 0 {
+		s += fmt.Sprintf(" rotate=%s keep=%d", units.FormatSize(uint64(r.rotateSize)), r.keep)
+	}
+	return s
+}
+
+// pipeCommand feeds output to a shell command's stdin from a goroutine
+// of its own. Once the command exits or falls pipeQueue writes behind,
+// the rest of the output is dropped and pipe-list says why.
+type pipeCommand struct {
+	cmdline string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	queue   chan []byte
+	once    sync.Once
+
+	mu     sync.Mutex
+	failed string
+}
+
+// startPipeCommand runs cmdline through the shell in the session's
+// working directory, with the session and sink named in its environment.
+func (d *Daemon) startPipeCommand(name, cmdline string) (*pipeCommand, error) {
+	cmd := d.childCommand(cmdline)
+	cmd.Dir = d.workdir
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_PIPE="+name,
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start pipe command: %w", err)
+	}
+	c := &pipeCommand{cmdline: cmdline, cmd: cmd, stdin: stdin, queue: make(chan []byte, pipeQueue)}
+	go c.run()
+	return c, nil
+}
+
+func (c *pipeCommand) run() {
+	for data := range c.queue {
+		if c.failure() != "" {
+			continue
+		}
+		if _, err := c.stdin.Write(data); err != nil {
+			c.fail("exited")
+		}
+	}
+	c.stdin.Close()
+	c.cmd.Wait()
+}
+
+func (c *pipeCommand) write(data []byte) {
+	if c.failure() != "" {
+		return
+	}
+	select {
+	case c.queue <- append([]byte(nil), data...):
+	default:
+		log.Printf("daemon: pipe command %q is not keeping up; dropping its output", c.cmdline)
+		c.fail("too slow")
+	}
+}
+
+func (c *pipeCommand) fail(why string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed == "" {
+		c.failed = why
+	}
+}
+
+func (c *pipeCommand) failure() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failed
+}
+
+// close ends the command's input once the queued output is written; the
+// command is left to finish on its own.
+func (c *pipeCommand) close() {
+	c.once.Do(func() { close(c.queue) })
+}
+
+func (c *pipeCommand) describe() string {
+	s := "command " + strconv.Quote(c.cmdline)
+	if why := c.failure(); why != "" {
+		s += " (" + why + ")"
+	}
+	return s
+}
+
+// eventDest emits each line of output as a "pipe" event for wait-event.
+type eventDest struct {
+	d    *Daemon
+	name string
+}
+
+func (e *eventDest) write(p []byte) {
+	e.d.events.emit("pipe", strings.TrimSuffix(string(p), "\n"), map[string]string{"pipe_name": e.name})
+}
+
+func (e *eventDest) close() {}
+
+func (e *eventDest) describe() string { return "events" }
+
+// writePipes sends newly read output to every sink.
+func (d *Daemon) writePipes(data []byte) {
+	d.pipeMu.Lock()
+	defer d.pipeMu.Unlock()
+	for _, s := range d.pipes {
+		s.write(data)
+	}
+}
+
+// closePipes closes every sink.
+func (d *Daemon) closePipes() {
+	d.pipeMu.Lock()
+	defer d.pipeMu.Unlock()
+	for _, s := range d.pipes {
+		s.close()
+	}
+	d.pipes = nil
+}
+
+func (d *Daemon) findPipeLocked(name string) int {
+	for i, s := range d.pipes {
+		if s.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *Daemon) addPipeLocked(name string, req ipc.Request) ipc.Response {
+	if len(d.pipes) >= maxPipes {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("too many pipes (max %d)", maxPipes)}
+	}
+	s, err := d.openPipeSink(name, req)
+	if err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	d.pipes = append(d.pipes, s)
+	return ipc.Response{OK: true, Output: name}
+}
+
+func (d *Daemon) removePipeLocked(i int) {
+	d.pipes[i].close()
+	d.pipes = append(d.pipes[:i], d.pipes[i+1:]...)
+}
+
+// handlePipePane replaces the pipe-pane sink, or stops it when no command
+// is given.
+func (d *Daemon) handlePipePane(req ipc.Request) ipc.Response {
+	d.pipeMu.Lock()
+	defer d.pipeMu.Unlock()
+
+	if i := d.findPipeLocked(pipePaneName); i >= 0 {
+		d.removePipeLocked(i)
+	}
+	if req.ShellCmd == "" {
+		return ipc.Response{OK: true}
+	}
+	resp := d.addPipeLocked(pipePaneName, req)
+	resp.Output = ""
+	return resp
+}
+
+func (d *Daemon) handlePipeAdd(req ipc.Request) ipc.Response {
+	if req.ShellCmd == "" && !req.Events && req.MirrorTo == "" {
+		return ipc.Response{OK: false, Error: "no pipe command specified"}
+	}
+
+	d.pipeMu.Lock()
+	defer d.pipeMu.Unlock()
+	name := req.Name
+	if name == "" {
+		for name == "" || d.findPipeLocked(name) >= 0 {
+			d.pipeID++
+			name = "p" + strconv.Itoa(d.pipeID)
+		}
+	}
+	if d.findPipeLocked(name) >= 0 {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("pipe already exists: %s", name)}
+	}
+	return d.addPipeLocked(name, req)
+}
+
+func (d *Daemon) handlePipeList() ipc.Response {
+	d.pipeMu.Lock()
+	defer d.pipeMu.Unlock()
+	lines := make([]string, 0, len(d.pipes))
+	for _, s := range d.pipes {
+		lines = append(lines, s.describe())
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+func (d *Daemon) handlePipeRemove(req ipc.Request) ipc.Response {
+	d.pipeMu.Lock()
+	defer d.pipeMu.Unlock()
+	i := d.findPipeLocked(req.Name)
+	if i < 0 {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("no pipe: %s", req.Name)}
+	}
+	d.removePipeLocked(i)
+	return ipc.Response{OK: true}
+}
+
+// extractPipePath parses "cat >> /path/to/file" and returns the file path.
+func extractPipePath(cmd string) string {
+	cmd = strings.TrimSpace(cmd)
+	if !strings.HasPrefix(cmd, "cat") {
+		return ""
+	}
+	cmd = strings.TrimPrefix(cmd, "cat")
+	cmd = strings.TrimSpace(cmd)
+	if !strings.HasPrefix(cmd, ">>") {
+		return ""
+	}
+	cmd = strings.TrimPrefix(cmd, ">>")
+	cmd = strings.TrimSpace(cmd)
+	cmd = strings.Trim(cmd, "'\"")
+	return cmd
+}
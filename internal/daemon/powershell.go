@@ -0,0 +1,82 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/vt"
+)
+
+// psContinuation starts the line PowerShell prompts on while a statement
+// is unfinished.
+const psContinuation = ">>"
+
+// psCancelTimeout bounds the wait for a fresh prompt after cancelling an
+// unfinished statement.
+var psCancelTimeout = 2 * time.Second
+
+// handleRunPS types req.ShellCmd into a PowerShell pane and presses
+// Enter, avoiding what trips up send-keys with PSReadLine: an unfinished
+// statement at a >> prompt is cancelled first, or the command would be
+// appended to it; multi-line text and tabs (which complete) are pasted
+// in brackets when PSReadLine reads bracketed paste, and otherwise sent
+// as one line that decodes and runs them; and nothing but the text and
+// Enter is sent, so no cursor key accepts a prediction's ghost text.
+func (d *Daemon) handleRunPS(req ipc.Request) ipc.Response {
+	if req.ShellCmd == "" {
+		return ipc.Response{OK: false, Error: "run-ps requires a command"}
+	}
+	if d.childExited() {
+		return ipc.Response{OK: false, Error: "pane is dead"}
+	}
+	if _, running := d.screen.RunningCommand(); running && d.screen.ShellIntegration() {
+		return ipc.Response{OK: false, Error: "a command is running in the pane"}
+	}
+	if d.atContinuation() {
+		key, _ := vt.ParseKey("C-c")
+		if err := d.writeInput(req.Client, "key", "C-c", key.Encode(d.screen.KeyMode())); err != nil {
+			return ipc.Response{OK: false, Error: err.Error()}
+		}
+		deadline := time.Now().Add(psCancelTimeout)
+		for d.atContinuation() {
+			if time.Now().After(deadline) {
+				return ipc.Response{OK: false, Error: "pane is still at a continuation prompt"}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	if err := d.writeInput(req.Client, "text", "", []byte(psInput(req.ShellCmd, d.screen.BracketedPaste()))); err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	if err := d.writeInput(req.Client, "key", "Enter", []byte("\r")); err != nil {
+		return ipc.Response{OK: false, Error: err.Error()}
+	}
+	return ipc.Response{OK: true}
+}
+
+// atContinuation reports whether the cursor is on a PowerShell
+// continuation prompt.
+func (d *Daemon) atContinuation() bool {
+	lines := d.screen.Capture(0)
+	cur := d.screen.Cursor()
+	return cur.Y < len(lines) && strings.HasPrefix(lines[cur.Y], psContinuation)
+}
+
+// psInput is what is typed for command. A single line without tabs is
+// typed as it is. Other text is bracketed for paste, with the CRs a
+// terminal pastes for newlines, or else dot-sourced from base64, so its
+// variables and functions stay in the session as if typed.
+func psInput(command string, paste bool) string {
+	command = strings.ReplaceAll(command, "\r\n", "\n")
+	switch {
+	case !strings.ContainsAny(command, "\n\t"):
+		return command
+	case paste:
+		return "\x1b[200~" + strings.ReplaceAll(command, "\n", "\r") + "\x1b[201~"
+	}
+	return fmt.Sprintf(". ([scriptblock]::Create([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('%s'))))",
+		base64.StdEncoding.EncodeToString([]byte(command)))
+}
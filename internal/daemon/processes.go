@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+	"wintmux/internal/proc"
+)
+
+// defaultProcessFormat indents each process by its depth in the tree.
+const defaultProcessFormat = "#{process_indent}#{process_pid} #{process_name} cpu=#{process_cpu}s"
+
+// handleListProcesses reports the pane's child and all its descendants.
+func (d *Daemon) handleListProcesses(req ipc.Request) ipc.Response {
+	if d.childExited() {
+		return ipc.Response{OK: false, Error: "pane process has exited"}
+	}
+	if d.term().Pid() == 0 {
+		return ipc.Response{OK: false, Error: "the pane has no process (" + d.spec.Scheme + ")"}
+	}
+	procs, err := proc.List()
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("list processes: %v", err)}
+	}
+	nodes := proc.Tree(procs, d.term().Pid())
+	if len(nodes) == 0 {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("pane process %d not found", d.term().Pid())}
+	}
+
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultProcessFormat
+	}
+	lines := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		lines = append(lines, format.Expand(tmpl, map[string]string{
+			"process_pid":    strconv.Itoa(n.PID),
+			"process_ppid":   strconv.Itoa(n.PPID),
+			"process_name":   n.Name,
+			"process_cpu":    strconv.FormatFloat(n.CPU.Seconds(), 'f', 2, 64),
+			"process_depth":  strconv.Itoa(n.Depth),
+			"process_indent": strings.Repeat("  ", n.Depth),
+		}))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
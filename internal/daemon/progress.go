@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"wintmux/internal/ipc"
+)
+
+// progressInterval is the most often a long request reports progress.
+// Reports double as a heartbeat: a client that has seen one can treat a
+// longer silence as a hung daemon.
+const progressInterval = time.Second
+
+// progress sends status frames for a long-running request whose client
+// asked for them. A nil *progress discards reports, so handlers can
+// report unconditionally.
+type progress struct {
+	conn net.Conn
+	id   int64
+	last time.Time
+}
+
+func newProgress(conn net.Conn, req ipc.Request) *progress {
+	if !req.Progress {
+		return nil
+	}
+	return &progress{conn: conn, id: req.ID, last: time.Now()}
+}
+
+// report sends a status frame if progressInterval has passed since the
+// last one.
+func (p *progress) report(format string, args ...interface{}) {
+	if p == nil || time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+	p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	ipc.WriteMessage(p.conn, ipc.Response{ID: p.id, OK: true, Progress: true, Output: fmt.Sprintf(format, args...)})
+}
+
+// tick returns a channel that fires every progressInterval while progress
+// is wanted, for handlers that otherwise block on a select. It is nil
+// (never fires) for a nil *progress.
+func (p *progress) tick() (<-chan time.Time, func()) {
+	if p == nil {
+		return nil, func() {}
+	}
+	t := time.NewTicker(progressInterval)
+	return t.C, t.Stop
+}
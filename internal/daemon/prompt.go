@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/screen"
+)
+
+// promptQuiet is how long output must have paused on a prompt before
+// wait-for-prompt trusts it: output that goes on can end a line in $ or >.
+const promptQuiet = 100 * time.Millisecond
+
+// execPromptWait bounds how long an in-pane exec run waits, after the
+// command has finished, for the shell to draw its prompt again.
+const execPromptWait = time.Second
+
+// checkPromptPattern validates a prompt-pattern option; empty turns
+// prompt detection off for its shell.
+func checkPromptPattern(v string) error {
+	if _, err := regexp.Compile(v); err != nil {
+		return fmt.Errorf("invalid prompt pattern: %v", err)
+	}
+	return nil
+}
+
+// paneShell guesses the syntax of the pane's shell, as exec does.
+func (d *Daemon) paneShell() string {
+	d.childMu.RLock()
+	defer d.childMu.RUnlock()
+	return execShell(d.command)
+}
+
+// promptPattern returns the prompt-pattern option of shell, or nil if
+// detection is off for it.
+func (d *Daemon) promptPattern(shell string) *regexp.Regexp {
+	pattern := d.option("prompt-pattern-" + shell)
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// atPrompt reports whether the pane's shell is waiting at its prompt.
+// Shell integration marks say so when the shell sends them and no shell
+// is named; otherwise the cursor line must match the prompt-pattern
+// option of shell (by default guessed from the pane command) with
+// nothing typed after it.
+func (d *Daemon) atPrompt(shell string) bool {
+	if d.childExited() {
+		return false
+	}
+	if shell == "" && d.screen.ShellIntegration() {
+		_, running := d.screen.RunningCommand()
+		return !running
+	}
+	if shell == "" {
+		shell = d.paneShell()
+	}
+	re := d.promptPattern(shell)
+	if re == nil {
+		return false
+	}
+	lines := d.screen.Capture(0)
+	cur := d.screen.Cursor()
+	if cur.Y >= len(lines) {
+		return false
+	}
+	line := lines[cur.Y]
+	return screen.StringWidth(line) <= cur.X && re.MatchString(line)
+}
+
+// awaitPrompt waits up to limit for the shell to be at its prompt,
+// unless prompt detection is off for it.
+func (d *Daemon) awaitPrompt(shell string, limit time.Duration) {
+	if shell != "" && d.promptPattern(shell) == nil {
+		return
+	}
+	deadline := time.Now().Add(limit)
+	for !d.atPrompt(shell) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// handleWaitPrompt blocks until the pane's shell is back at its prompt,
+// with output paused for promptQuiet, or the timeout passes.
+func (d *Daemon) handleWaitPrompt(req ipc.Request, p *progress) ipc.Response {
+	timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	start := time.Now()
+	for {
+		if d.childExited() {
+			return ipc.Response{OK: false, Error: "pane exited"}
+		}
+		if d.quietFor() >= promptQuiet && d.atPrompt(req.Shell) {
+			return ipc.Response{OK: true}
+		}
+		if time.Since(start) > timeout {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("timed out after %v waiting for a prompt", timeout)}
+		}
+		p.report("no prompt for %v", time.Since(start).Round(time.Second))
+		time.Sleep(50 * time.Millisecond)
+	}
+}
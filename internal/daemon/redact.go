@@ -0,0 +1,179 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"wintmux/internal/ipc"
+)
+
+// maxRedactions bounds the redaction rules per session, since every
+// captured or piped line is matched against each of them.
+const maxRedactions = 64
+
+// defaultRedaction replaces matches of a rule without --replace.
+const defaultRedaction = "[REDACTED]"
+
+// redaction is a regular expression whose matches are masked in text
+// that leaves the daemon: captures, pipes, recorded input and events.
+type redaction struct {
+	name    string
+	re      *regexp.Regexp
+	replace string // may refer to groups as $1 or ${name}
+}
+
+// redactSet holds the session's redaction rules. Readers load rules
+// without locking, as every pipe line passes through them; changes
+// replace the slice under mu.
+type redactSet struct {
+	mu     sync.Mutex
+	rules  atomic.Pointer[[]*redaction]
+	nextID int
+}
+
+// redact masks every rule's matches in s.
+func (d *Daemon) redact(s string) string {
+	rules := d.redactions.rules.Load()
+	if rules == nil {
+		return s
+	}
+	for _, r := range *rules {
+		s = r.re.ReplaceAllString(s, r.replace)
+	}
+	return s
+}
+
+// redactLines masks matches in each line. Rules apply within a line.
+func (d *Daemon) redactLines(lines []string) []string {
+	if d.redactions.rules.Load() == nil {
+		return lines
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = d.redact(line)
+	}
+	return out
+}
+
+// redactStream redacts output that arrives in chunks, as raw pipes and
+// attached clients get it. Rules apply within a line, so the text after
+// the last newline is held back until the rest of its line arrives (up
+// to maxCleanLine bytes, beyond which a line goes out in pieces); a
+// secret split between two reads of the pane is still caught. Nothing is
+// held while the session has no rules.
+type redactStream struct {
+	d     *Daemon
+	carry []byte // the unfinished line held back
+}
+
+// write returns what of data, and of any text held back before it, can
+// go out now, redacted.
+func (r *redactStream) write(data []byte) []byte {
+	if len(r.carry) > 0 {
+		data = append(r.carry, data...)
+		r.carry = nil
+	}
+	if r.d.redactions.rules.Load() == nil {
+		return data
+	}
+	cut := bytes.LastIndexByte(data, '\n') + 1
+	if len(data)-cut > maxCleanLine {
+		cut = len(data) - maxCleanLine
+	}
+	r.carry = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return nil
+	}
+	return []byte(r.d.redact(string(data[:cut])))
+}
+
+// flush returns the text held back, redacted, for when no more of its
+// line is coming soon.
+func (r *redactStream) flush() []byte {
+	if len(r.carry) == 0 {
+		return nil
+	}
+	data := r.carry
+	r.carry = nil
+	return []byte(r.d.redact(string(data)))
+}
+
+func (d *Daemon) handleRedactAdd(req ipc.Request) ipc.Response {
+	if req.Pattern == "" {
+		return ipc.Response{OK: false, Error: "no pattern specified"}
+	}
+	re, err := regexp.Compile(req.Pattern)
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("bad pattern: %v", err)}
+	}
+	if re.MatchString("") {
+		return ipc.Response{OK: false, Error: "pattern matches empty text"}
+	}
+	replace := req.Replace
+	if replace == "" {
+		replace = defaultRedaction
+	}
+
+	rs := &d.redactions
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var rules []*redaction
+	if cur := rs.rules.Load(); cur != nil {
+		rules = *cur
+	}
+	if len(rules) >= maxRedactions {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("too many redactions (max %d)", maxRedactions)}
+	}
+	rs.nextID++
+	name := req.Name
+	if name == "" {
+		name = "r" + strconv.Itoa(rs.nextID)
+	}
+	for _, r := range rules {
+		if r.name == name {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("redaction already exists: %s", name)}
+		}
+	}
+	rules = append(rules[:len(rules):len(rules)], &redaction{name: name, re: re, replace: replace})
+	rs.rules.Store(&rules)
+	return ipc.Response{OK: true, Output: name}
+}
+
+func (d *Daemon) handleRedactList() ipc.Response {
+	rules := d.redactions.rules.Load()
+	if rules == nil {
+		return ipc.Response{OK: true}
+	}
+	lines := make([]string, 0, len(*rules))
+	for _, r := range *rules {
+		lines = append(lines, fmt.Sprintf("%s /%s/ %s", r.name, r.re, strconv.Quote(r.replace)))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+func (d *Daemon) handleRedactRemove(req ipc.Request) ipc.Response {
+	rs := &d.redactions
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	cur := rs.rules.Load()
+	if cur != nil {
+		for i, r := range *cur {
+			if r.name != req.Name {
+				continue
+			}
+			rules := append(append([]*redaction(nil), (*cur)[:i]...), (*cur)[i+1:]...)
+			if len(rules) == 0 {
+				rs.rules.Store(nil)
+			} else {
+				rs.rules.Store(&rules)
+			}
+			return ipc.Response{OK: true}
+		}
+	}
+	return ipc.Response{OK: false, Error: fmt.Sprintf("no redaction: %s", req.Name)}
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/pty"
+	"wintmux/internal/winpath"
+)
+
+// respawnKillTimeout bounds how long respawn-pane -k waits for the old
+// process to die before starting the new one.
+const respawnKillTimeout = 5 * time.Second
+
+// handleRespawn restarts the pane process, like tmux respawn-pane. The
+// new process starts in req.StartDir, else the pane's current path, and
+// gets a refreshed environment (see respawnEnv).
+func (d *Daemon) handleRespawn(req ipc.Request) ipc.Response {
+	old := d.child()
+	if !d.childExited() {
+		if !req.Kill {
+			return ipc.Response{OK: false, Error: "pane is still active (use -k to kill it)"}
+		}
+		old.term.Close()
+		select {
+		case <-old.done:
+		case <-time.After(respawnKillTimeout):
+			return ipc.Response{OK: false, Error: "timed out waiting for pane process to exit"}
+		}
+	}
+
+	d.childMu.RLock()
+	command := d.command
+	d.childMu.RUnlock()
+	if req.ShellCmd != "" {
+		command = req.ShellCmd
+	}
+	dir := d.startDir(req.StartDir)
+
+	term, err := d.openTerminal(command, dir, d.respawnEnv(req))
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("respawn: %v", err)}
+	}
+
+	d.childMu.Lock()
+	d.command = command
+	d.childMu.Unlock()
+	d.startChild(term)
+	log.Printf("daemon: respawned pane pid=%d dir=%s", term.Pid(), dir)
+
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	if d.limits != (pty.Limits{}) {
+		if err := d.applyLimits(d.limits); err != nil {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("respawned, but reapplying limits failed: %v", err)}
+		}
+	}
+	return ipc.Response{OK: true}
+}
+
+// startDir is where a respawned or exec'd process starts: dir, converted
+// to this platform's path form unless the pane is remote (a /mnt/c path
+// from a WSL orchestrator, say), else the pane's current path.
+func (d *Daemon) startDir(dir string) string {
+	if dir == "" {
+		return d.currentPath()
+	}
+	if d.spec.Remote() {
+		return dir
+	}
+	return winpath.Normalize(dir)
+}
+
+// respawnEnv builds the environment overrides for a respawned process:
+// each variable named in update-environment is copied from the
+// requesting client's environment (so rotated credentials reach the new
+// process), then -e overrides are applied on top.
+func (d *Daemon) respawnEnv(req ipc.Request) []string {
+	clientEnv := make(map[string]string, len(req.ClientEnv))
+	for _, kv := range req.ClientEnv {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			clientEnv[k] = v
+		}
+	}
+
+	var env []string
+	for _, name := range strings.Fields(d.option("update-environment")) {
+		if v, ok := clientEnv[name]; ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return append(env, req.Env...)
+}
@@ -0,0 +1,263 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/cron"
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+)
+
+// maxSchedules bounds the scheduled commands per session.
+const maxSchedules = 64
+
+// minScheduleInterval is the shortest --every, so a typo cannot start
+// a process every millisecond.
+const minScheduleInterval = time.Second
+
+// scheduleRunTimeout bounds one run of a scheduled command.
+const scheduleRunTimeout = 10 * time.Minute
+
+const defaultScheduleFormat = "#{schedule_name} #{schedule_next} #{schedule_when} #{schedule_command}"
+
+// scheduled is a wintmux command run against the session at a later
+// time: once, at a fixed interval, or when a cron schedule fires.
+type scheduled struct {
+	id    int
+	name  string
+	args  []string
+	every time.Duration
+	cron  *cron.Schedule
+	spec  string // the cron schedule as given
+	next  time.Time
+	timer *time.Timer
+
+	runs      int
+	lastError string
+	running   bool // a run has not finished; the next is skipped
+}
+
+// scheduleSet holds the session's scheduled commands.
+type scheduleSet struct {
+	mu      sync.Mutex
+	entries []*scheduled
+	nextID  int
+}
+
+// runScheduled runs args as a wintmux command against the session, with
+// the executable the daemon was started from. Tests replace it.
+var runScheduled = func(d *Daemon, name string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), scheduleRunTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, append([]string{"-S", d.socketPath}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_SCHEDULE="+name,
+		"WINTMUX_CLIENT=schedule-"+name,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *Daemon) handleScheduleAdd(req ipc.Request) ipc.Response {
+	if len(req.Args) == 0 {
+		return ipc.Response{OK: false, Error: "no command specified"}
+	}
+	e := &scheduled{args: req.Args, every: time.Duration(req.IntervalMs) * time.Millisecond, spec: req.Cron}
+	delay := time.Duration(req.DelayMs) * time.Millisecond
+	switch {
+	case req.Cron != "" && (req.IntervalMs > 0 || req.DelayMs > 0):
+		return ipc.Response{OK: false, Error: "a cron schedule cannot be combined with a delay or interval"}
+	case req.Cron != "":
+		c, err := cron.Parse(req.Cron)
+		if err != nil {
+			return ipc.Response{OK: false, Error: err.Error()}
+		}
+		e.cron = c
+		if e.next = c.Next(time.Now()); e.next.IsZero() {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("cron schedule %q never fires", req.Cron)}
+		}
+		delay = time.Until(e.next)
+	case req.IntervalMs > 0 && e.every < minScheduleInterval:
+		return ipc.Response{OK: false, Error: fmt.Sprintf("interval %v is shorter than %v", e.every, minScheduleInterval)}
+	case req.IntervalMs > 0:
+		if req.DelayMs == 0 {
+			delay = e.every
+		}
+	case req.DelayMs == 0:
+		return ipc.Response{OK: false, Error: "no delay, interval or cron schedule specified"}
+	}
+
+	ss := &d.schedules
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if len(ss.entries) >= maxSchedules {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("too many scheduled commands (max %d)", maxSchedules)}
+	}
+	ss.nextID++
+	e.id, e.name = ss.nextID, req.Name
+	if e.name == "" {
+		e.name = "s" + strconv.Itoa(e.id)
+	}
+	for _, o := range ss.entries {
+		if o.name == e.name {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("scheduled command already exists: %s", e.name)}
+		}
+	}
+	if e.cron == nil {
+		e.next = time.Now().Add(delay)
+	}
+	e.timer = time.AfterFunc(delay, func() { d.fireSchedule(e) })
+	ss.entries = append(ss.entries, e)
+	return ipc.Response{OK: true, Output: e.name}
+}
+
+// fireSchedule runs e in the background and sets its timer for the next
+// run, or drops it if it only runs once. A run still going when the next
+// is due makes that one be skipped rather than run twice at once.
+func (d *Daemon) fireSchedule(e *scheduled) {
+	ss := &d.schedules
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.index(e) < 0 {
+		return // removed while the timer fired
+	}
+	switch {
+	case e.cron != nil:
+		e.next = e.cron.Next(time.Now())
+	case e.every > 0:
+		e.next = time.Now().Add(e.every)
+	default:
+		e.next = time.Time{}
+	}
+	if e.next.IsZero() {
+		ss.remove(e)
+	} else {
+		e.timer = time.AfterFunc(time.Until(e.next), func() { d.fireSchedule(e) })
+	}
+	if e.running {
+		log.Printf("daemon: schedule %s: previous run still going, skipped", e.name)
+		return
+	}
+	e.running = true
+	go func() {
+		err := runScheduled(d, e.name, e.args)
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+			log.Printf("daemon: schedule %s failed: %v", e.name, err)
+		}
+		ss.mu.Lock()
+		e.running = false
+		e.runs++
+		e.lastError = msg
+		ss.mu.Unlock()
+		text := e.name + ": " + quoteArgs(e.args)
+		if msg != "" {
+			text += ": " + msg
+		}
+		d.events.emit("schedule", text, map[string]string{
+			"schedule_name":    e.name,
+			"schedule_command": quoteArgs(e.args),
+			"schedule_error":   msg,
+		})
+	}()
+}
+
+// index returns e's position in the set, or -1. The caller holds ss.mu.
+func (ss *scheduleSet) index(e *scheduled) int {
+	for i, o := range ss.entries {
+		if o == e {
+			return i
+		}
+	}
+	return -1
+}
+
+// remove stops e's timer and drops it from the set. The caller holds
+// ss.mu.
+func (ss *scheduleSet) remove(e *scheduled) {
+	e.timer.Stop()
+	if i := ss.index(e); i >= 0 {
+		ss.entries = append(ss.entries[:i], ss.entries[i+1:]...)
+	}
+}
+
+func (d *Daemon) handleScheduleList(req ipc.Request) ipc.Response {
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultScheduleFormat
+	}
+	ss := &d.schedules
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	lines := make([]string, 0, len(ss.entries))
+	for _, e := range ss.entries {
+		lines = append(lines, format.Expand(tmpl, map[string]string{
+			"schedule_id":      strconv.Itoa(e.id),
+			"schedule_name":    e.name,
+			"schedule_command": quoteArgs(e.args),
+			"schedule_when":    e.when(),
+			"schedule_next":    e.next.Format(time.RFC3339),
+			"schedule_runs":    strconv.Itoa(e.runs),
+			"schedule_error":   e.lastError,
+		}))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+func (d *Daemon) handleScheduleRemove(req ipc.Request) ipc.Response {
+	ss := &d.schedules
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, e := range ss.entries {
+		if e.name == req.Name {
+			ss.remove(e)
+			return ipc.Response{OK: true}
+		}
+	}
+	return ipc.Response{OK: false, Error: fmt.Sprintf("no scheduled command: %s", req.Name)}
+}
+
+// when describes how e repeats: "once", "every 10m0s" or "cron SPEC".
+func (e *scheduled) when() string {
+	switch {
+	case e.cron != nil:
+		return "cron " + strconv.Quote(e.spec)
+	case e.every > 0:
+		return "every " + e.every.String()
+	}
+	return "once"
+}
+
+// quoteArgs joins a command's arguments for display, quoting those that
+// would not read back as one word.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t'") || strconv.Quote(a) != `"`+a+`"` {
+			a = strconv.Quote(a)
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,48 @@
+package daemon
+
+import (
+	"fmt"
+
+	"wintmux/internal/pty"
+	"wintmux/internal/secret"
+)
+
+// secretVar is a pane environment variable whose value the daemon
+// fetches each time it starts a process (see new-session --secret-env),
+// so that a rotated secret reaches a respawned pane. Only the reference
+// is kept; values are neither stored nor logged.
+type secretVar struct {
+	name string
+	ref  secret.Ref
+}
+
+// parseSecretEnv parses --secret-env entries for a pane on spec's
+// backend. Remote backends pass the environment on the ssh or docker
+// command line, where a secret would be visible, so they are refused.
+func parseSecretEnv(entries []string, spec pty.Spec) ([]secretVar, error) {
+	if len(entries) > 0 && spec.Remote() {
+		return nil, fmt.Errorf("--secret-env cannot be used with a %s pane: the environment is passed on its command line", spec.Scheme)
+	}
+	vars := make([]secretVar, 0, len(entries))
+	for _, e := range entries {
+		name, ref, err := secret.ParseEnv(e)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, secretVar{name: name, ref: ref})
+	}
+	return vars, nil
+}
+
+// fetchSecrets returns the secret variables as environment entries.
+func (d *Daemon) fetchSecrets() ([]string, error) {
+	env := make([]string, 0, len(d.secrets))
+	for _, v := range d.secrets {
+		value, err := v.ref.Fetch()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", v.name, err)
+		}
+		env = append(env, v.name+"="+value)
+	}
+	return env, nil
+}
@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// childCommand returns shellCommand(cmdline) set up as child-console says.
+// Hooks, probes and pipe commands start this way; the pane does not.
+func (d *Daemon) childCommand(cmdline string) *exec.Cmd {
+	cmd := shellCommand(cmdline)
+	setChildConsole(cmd, d.option("child-console"))
+	return cmd
+}
+
+// checkChildConsole validates a child-console value. Every platform
+// accepts the Windows modes so that configuration can be shared.
+func checkChildConsole(v string) error {
+	switch v {
+	case "inherit", "hidden", "detached":
+		return nil
+	}
+	return fmt.Errorf("invalid child-console value %q (expected inherit, hidden or detached)", v)
+}
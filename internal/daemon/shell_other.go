@@ -0,0 +1,14 @@
+//go:build !windows
+
+package daemon
+
+import "os/exec"
+
+// shellCommand returns a command running cmdline through the shell used
+// for pane commands on this platform.
+func shellCommand(cmdline string) *exec.Cmd {
+	return exec.Command("bash", "-c", cmdline)
+}
+
+// setChildConsole does nothing: only Windows has consoles.
+func setChildConsole(cmd *exec.Cmd, mode string) {}
@@ -0,0 +1,39 @@
+//go:build windows
+
+package daemon
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Process creation flags for child-console.
+const (
+	_DETACHED_PROCESS = 0x00000008
+	_CREATE_NO_WINDOW = 0x08000000
+)
+
+// shellCommand returns a command running cmdline through cmd.exe. The
+// command line is passed verbatim (/S strips only the outer quotes), so
+// quoting inside cmdline behaves as it would at a cmd prompt.
+func shellCommand(cmdline string) *exec.Cmd {
+	cmd := exec.Command("cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CmdLine:    `cmd.exe /S /C "` + cmdline + `"`,
+		HideWindow: true,
+	}
+	return cmd
+}
+
+// setChildConsole gives a command from shellCommand the console mode
+// names: "inherit" shares the daemon's windowless console, "hidden"
+// creates a windowless console of its own and "detached" starts it with
+// no console at all.
+func setChildConsole(cmd *exec.Cmd, mode string) {
+	switch mode {
+	case "hidden":
+		cmd.SysProcAttr.CreationFlags |= _CREATE_NO_WINDOW
+	case "detached":
+		cmd.SysProcAttr.CreationFlags |= _DETACHED_PROCESS
+	}
+}
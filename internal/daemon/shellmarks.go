@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"wintmux/internal/format"
+	"wintmux/internal/ipc"
+	"wintmux/internal/screen"
+)
+
+// noteCommand emits a "command" event when the screen has seen a shell
+// command finish since the last call (see screen.Command). Called after
+// each output chunk reaches the screen.
+func (d *Daemon) noteCommand() {
+	c, ok := d.screen.LastCommand()
+	if !ok || int64(c.Seq) == d.commandSeq.Load() {
+		return
+	}
+	d.commandSeq.Store(int64(c.Seq))
+	line := d.redact(c.Line)
+	d.events.emit("command", line, map[string]string{
+		"command_line":      line,
+		"command_exit_code": exitCodeText(c.ExitCode),
+		"command_lines":     strconv.Itoa(len(c.Output)),
+	})
+}
+
+// exitCodeText formats an exit code reported by shell integration, ""
+// when the shell did not report one.
+func exitCodeText(code int) string {
+	if code < 0 {
+		return ""
+	}
+	return strconv.Itoa(code)
+}
+
+// commandVars adds the shell integration format variables to vars.
+func (d *Daemon) commandVars(vars map[string]string) {
+	vars["shell_integration"] = flag(d.screen.ShellIntegration())
+	_, running := d.screen.RunningCommand()
+	vars["command_running"] = flag(running)
+	vars["last_command"], vars["last_exit_code"] = "", ""
+	vars["command_count"] = strconv.Itoa(d.screen.CommandCount())
+	if last, ok := d.screen.LastCommand(); ok {
+		vars["last_command"] = last.Line
+		vars["last_exit_code"] = exitCodeText(last.ExitCode)
+	}
+}
+
+// errNoCommand is returned when the output of the last command is asked
+// for before one has finished.
+var errNoCommand = errors.New("no command has finished (the shell must send OSC 133 shell integration marks)")
+
+// commandLines returns the output of finished command n, or with n < 0
+// of the -n-th last one.
+func (d *Daemon) commandLines(n int) ([]string, error) {
+	if n > 0 {
+		c, ok := d.screen.CommandBySeq(n)
+		if !ok {
+			return nil, fmt.Errorf("command %d not found (see list-commands-history)", n)
+		}
+		return c.Output, nil
+	}
+	var done []screen.Command
+	for _, c := range d.screen.Commands() {
+		if !c.Running {
+			done = append(done, c)
+		}
+	}
+	if len(done) == 0 {
+		return nil, errNoCommand
+	}
+	if -n > len(done) {
+		return nil, fmt.Errorf("command %d not found: %d commands in the history", n, len(done))
+	}
+	return done[len(done)+n].Output, nil
+}
+
+// defaultCommandFormat lists one command per line with its exit code.
+const defaultCommandFormat = "#{command_seq}: [#{?command_running,running,#{command_exit_code}}] #{command_line} (#{command_lines} lines)"
+
+// handleListCommands reports the shell commands in the history, oldest
+// first, and the running one.
+func (d *Daemon) handleListCommands(req ipc.Request) ipc.Response {
+	cmds := d.screen.Commands()
+	if len(cmds) == 0 {
+		if !d.screen.ShellIntegration() {
+			return ipc.Response{OK: false, Error: "no commands: the shell sends no OSC 133 shell integration marks"}
+		}
+		return ipc.Response{OK: true}
+	}
+	tmpl := req.Format
+	if tmpl == "" {
+		tmpl = defaultCommandFormat
+	}
+	lines := make([]string, 0, len(cmds))
+	for _, c := range cmds {
+		end := c.Ended
+		if c.Running {
+			end = time.Now()
+		}
+		lines = append(lines, format.Expand(tmpl, map[string]string{
+			"command_seq":       strconv.Itoa(c.Seq),
+			"command_line":      d.redact(c.Line),
+			"command_exit_code": exitCodeText(c.ExitCode),
+			"command_lines":     strconv.Itoa(len(c.Output)),
+			"command_truncated": flag(c.Truncated),
+			"command_running":   flag(c.Running),
+			"command_start":     strconv.FormatInt(c.Started.Unix(), 10),
+			"command_duration":  strconv.FormatFloat(end.Sub(c.Started).Seconds(), 'f', 2, 64),
+		}))
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+// commandText renders a command's output as exec returns it.
+func commandText(c screen.Command) string {
+	if len(c.Output) == 0 {
+		return ""
+	}
+	return strings.Join(c.Output, "\n") + "\n"
+}
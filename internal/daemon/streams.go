@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"fmt"
+
+	"wintmux/internal/pty"
+	"wintmux/internal/scrollback"
+	"wintmux/internal/vt"
+)
+
+// readTagged reads pane output from term, with the stream it came from
+// when term tells stderr from stdout (see pty.StreamReader).
+func readTagged(term pty.Terminal, buf []byte) (int, scrollback.Stream, error) {
+	sr, ok := term.(pty.StreamReader)
+	if !ok {
+		n, err := term.Read(buf)
+		return n, 0, err
+	}
+	n, s, err := sr.ReadStream(buf)
+	if s == pty.Stderr {
+		return n, scrollback.Stderr, err
+	}
+	return n, scrollback.Stdout, err
+}
+
+// streamPrefix marks each line of a --stream tag capture with where its
+// bytes came from: stdout (1) or stderr (2) as the file descriptor
+// numbers, both (*), or not known (-), such as output mirrored from
+// another session.
+var streamPrefix = map[scrollback.Stream]string{
+	0:                                     "- ",
+	scrollback.Stdout:                     "1 ",
+	scrollback.Stderr:                     "2 ",
+	scrollback.Stdout | scrollback.Stderr: "* ",
+}
+
+// captureStreams captures the history for capture-pane --stream: the
+// lines holding output of one stream ("stdout" or "stderr"), or every
+// line marked with its streams ("tag"). Lines are filtered by the strip
+// profile, "text" unless one is given.
+func (d *Daemon) captureStreams(mode string, lines int, strip string) ([]string, error) {
+	var want scrollback.Stream
+	switch mode {
+	case "stdout":
+		want = scrollback.Stdout
+	case "stderr":
+		want = scrollback.Stderr
+	case "tag":
+	default:
+		return nil, fmt.Errorf("invalid stream %q (expected stdout, stderr or tag)", mode)
+	}
+	if _, ok := d.term().(pty.StreamReader); !ok {
+		return nil, fmt.Errorf("the %s backend does not tell stderr from stdout", d.paneBackend())
+	}
+	if strip == "" {
+		strip = "text"
+	}
+	profile, err := vt.ParseProfile(strip)
+	if err != nil {
+		return nil, err
+	}
+	history, tags := d.buffer.Streams(lines)
+	var captured []string
+	for i, line := range history {
+		switch {
+		case want == 0:
+			captured = append(captured, streamPrefix[tags[i]]+vt.Apply(line, profile))
+		case tags[i]&want != 0:
+			captured = append(captured, vt.Apply(line, profile))
+		}
+	}
+	return captured, nil
+}
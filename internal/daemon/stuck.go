@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A pane whose processes use less CPU than stuckIdleCPU (percent of one
+// CPU) is blocked, and one using stuckBusyCPU or more is spinning; in
+// between it is taken to be working quietly.
+const (
+	stuckIdleCPU = 1
+	stuckBusyCPU = 90
+)
+
+// stuckProbeTimeout bounds a stuck-probe run; a probe that does not
+// finish in time fails.
+var stuckProbeTimeout = 30 * time.Second
+
+// checkStuck decides whether the pane has stopped responding, with
+// stuck-after set: it has produced no output for that long, is not
+// waiting at a shell prompt and its CPU use is pegged or nil. With a
+// stuck-probe, the probe has the last word. Becoming stuck emits a
+// "stuck" event and starts alert-stuck-hook; output clears the flag.
+// Called after each usage sample.
+func (d *Daemon) checkStuck() {
+	after, _ := parseInterval(d.option("stuck-after"))
+	if after == 0 || !d.looksStuck(after) {
+		d.stuck.Store(false)
+		return
+	}
+	if d.stuck.Load() {
+		return
+	}
+	probe := d.option("stuck-probe")
+	if probe == "" {
+		d.markStuck("")
+		return
+	}
+	if !d.stuckProbe.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer d.stuckProbe.Store(false)
+		err := d.runStuckProbe(probe)
+		if err == nil || !d.looksStuck(after) {
+			return
+		}
+		d.markStuck(err.Error())
+	}()
+}
+
+// looksStuck applies the stuck heuristics for a quiet time of after.
+func (d *Daemon) looksStuck(after time.Duration) bool {
+	if d.childExited() || d.quietFor() < after {
+		return false
+	}
+	// A shell at its prompt is waiting for input, not hung.
+	if _, running := d.screen.RunningCommand(); d.screen.ShellIntegration() && !running {
+		return false
+	}
+	if u := d.usage.Load(); u != nil && u.cpuPercent >= stuckIdleCPU && u.cpuPercent < stuckBusyCPU {
+		return false
+	}
+	return true
+}
+
+// runStuckProbe runs the stuck-probe command; an error means the pane
+// failed it.
+func (d *Daemon) runStuckProbe(probe string) error {
+	cmd := d.childCommand(probe)
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_PANE_PID="+strconv.Itoa(d.term().Pid()),
+	)
+	var out strings.Builder
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.WaitDelay = time.Second // for children left holding the output pipe
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("probe: %v: %s", err, strings.TrimSpace(out.String()))
+		}
+		return nil
+	case <-time.After(stuckProbeTimeout):
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("probe timed out after %v", stuckProbeTimeout)
+	}
+}
+
+// markStuck sets the stuck flag and raises the alert, once per episode.
+func (d *Daemon) markStuck(probe string) {
+	if d.stuck.Swap(true) {
+		return
+	}
+	quiet := d.quietFor().Round(time.Second)
+	cpu := ""
+	if u := d.usage.Load(); u != nil {
+		cpu = strconv.FormatFloat(u.cpuPercent, 'f', 1, 64)
+	}
+	text := fmt.Sprintf("no output for %v", quiet)
+	if probe != "" {
+		text += ", " + probe
+	}
+	log.Printf("daemon: pane pid=%d looks stuck: %s", d.term().Pid(), text)
+	d.events.emit("stuck", text, map[string]string{
+		"stuck_quiet_ms": strconv.FormatInt(quiet.Milliseconds(), 10),
+		"stuck_cpu":      cpu,
+		"stuck_probe":    probe,
+	})
+	d.runStuckHook()
+}
+
+// runStuckHook starts alert-stuck-hook in the background, like
+// alert-bell-hook, unless it is unset or still running.
+func (d *Daemon) runStuckHook() {
+	hook := d.option("alert-stuck-hook")
+	if hook == "" || !d.stuckHook.CompareAndSwap(false, true) {
+		return
+	}
+	cmd := d.childCommand(d.expandCommand(hook))
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_ALERT=stuck",
+		"WINTMUX_PANE_PID="+strconv.Itoa(d.term().Pid()),
+	)
+	go func() {
+		defer d.stuckHook.Store(false)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("daemon: alert-stuck-hook failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}()
+}
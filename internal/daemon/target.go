@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"wintmux/internal/ipc"
+)
+
+// The session's IDs, as tmux's session_id, window_id and pane_id, are the
+// daemon's process ID after $, @ and %: no other running session has
+// the same, and they stay the same while the daemon runs, through
+// respawns, renames and anything else, so automation can keep using one
+// it has read. The window and pane are still index 0.
+
+func (d *Daemon) sessionID() string { return "$" + strconv.Itoa(d.id) }
+func (d *Daemon) windowID() string  { return "@" + strconv.Itoa(d.id) }
+func (d *Daemon) paneID() string    { return "%" + strconv.Itoa(d.id) }
+
+// checkTarget reports whether target, a request's -t, names this
+// session's pane as tmux would find it: the marked pane ({marked} or ~),
+// a pane ID (%N), a window ID (@N), or [session][:[window][.pane]] where
+// the session, window and pane are as isSession, isWindow and isPane
+// take them. Without a colon or period, target may be any of the three,
+// as tmux tries a pane, then a window, then a session.
+func (d *Daemon) checkTarget(target string) error {
+	if target == "{marked}" || target == "~" {
+		if !d.marked.Load() {
+			return errors.New("no marked target")
+		}
+		return nil
+	}
+	session, rest, ok := strings.Cut(target, ":")
+	if !ok {
+		if !strings.Contains(target, ".") {
+			return d.checkLone(target)
+		}
+		session, rest = "", target
+	}
+	if !d.isSession(session) {
+		return fmt.Errorf("can't find session: %s", session)
+	}
+	return d.checkWindowPane(rest)
+}
+
+// checkLone checks a target that is one part, reporting a failure as
+// that of the kind of part it looks like.
+func (d *Daemon) checkLone(target string) error {
+	if d.isPane(target) || d.isWindow(target) || d.isSession(target) {
+		return nil
+	}
+	switch {
+	case strings.HasPrefix(target, "%"):
+		return fmt.Errorf("can't find pane: %s", target)
+	case strings.HasPrefix(target, "@"), strings.ContainsAny(target[:1], "!+-^{"):
+		return fmt.Errorf("can't find window: %s", target)
+	}
+	return fmt.Errorf("can't find session: %s", target)
+}
+
+// checkWindowPane checks the [window][.pane] part of a target.
+func (d *Daemon) checkWindowPane(target string) error {
+	if strings.HasPrefix(target, "%") {
+		if target != d.paneID() {
+			return fmt.Errorf("can't find pane: %s", target)
+		}
+		return nil
+	}
+	window, pane, _ := strings.Cut(target, ".")
+	if !d.isWindow(window) {
+		return fmt.Errorf("can't find window: %s", window)
+	}
+	if !d.isPane(pane) {
+		return fmt.Errorf("can't find pane: %s", pane)
+	}
+	return nil
+}
+
+// isSession reports whether s names the session: empty, the current
+// session, which a session's daemon always is; its ID; or as matchName
+// finds its name.
+func (d *Daemon) isSession(s string) bool {
+	if s == "" || s == d.sessionID() {
+		return true
+	}
+	name, exact := strings.CutPrefix(s, "=")
+	return matchName(name, d.sessionName, exact)
+}
+
+// isWindow reports whether w names the session's one window: empty, the
+// current window; its index or ID; a token for the first, last, next or
+// previous window, or an offset (+N, -N), which all wrap round to it;
+// or as matchName finds its name. The last window (! or {last}) is one
+// the session has never had.
+func (d *Daemon) isWindow(w string) bool {
+	if w == "" {
+		return true
+	}
+	w, exact := strings.CutPrefix(w, "=")
+	if !exact {
+		switch w {
+		case "^", "$", "{start}", "{end}", "{next}", "{previous}":
+			return true
+		case "!", "{last}":
+			return false
+		}
+		if isOffset(w) {
+			return true
+		}
+	}
+	if w == "0" || w == d.windowID() {
+		return true
+	}
+	return matchName(w, d.windowName(), exact)
+}
+
+// isPane reports whether p names the window's one pane: empty, the
+// current pane; its index or ID; the next or previous pane, or an
+// offset, which wrap round to it; or where it lies in the window (top,
+// {bottom-left} and so on), as it fills it. The last pane (! or {last})
+// and the pane above, below or beside it ({up-of} and so on) are ones
+// the window does not have.
+func (d *Daemon) isPane(p string) bool {
+	switch p {
+	case "", "0", d.paneID(), "{next}", "{previous}":
+		return true
+	}
+	if isOffset(p) {
+		return true
+	}
+	if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+		p = p[1 : len(p)-1]
+	}
+	switch p {
+	case "top", "bottom", "left", "right",
+		"top-left", "topleft", "top-right", "topright",
+		"bottom-left", "bottomleft", "bottom-right", "bottomright":
+		return true
+	}
+	return false
+}
+
+// isOffset reports whether s is a window or pane offset: + or - and an
+// optional count.
+func isOffset(s string) bool {
+	if s == "" || (s[0] != '+' && s[0] != '-') {
+		return false
+	}
+	if len(s) == 1 {
+		return true
+	}
+	n, err := strconv.Atoi(s[1:])
+	return err == nil && n > 0 && s[1] != '+' && s[1] != '-'
+}
+
+// matchName reports whether s names name as tmux looks names up: the
+// name itself and, unless exact (s was written =name), the start of it or
+// an fnmatch pattern matching it.
+func matchName(s, name string, exact bool) bool {
+	if s == name {
+		return true
+	}
+	if exact || s == "" || name == "" {
+		return false
+	}
+	if strings.HasPrefix(name, s) {
+		return true
+	}
+	ok, err := path.Match(s, name)
+	return err == nil && ok
+}
+
+// handleSelectPane selects the pane, which as the window's one pane it
+// always is, and marks it or clears the mark. The marked pane is the
+// target {marked}; as in tmux, marking it again clears the mark. Each
+// session is its own server, so it has its own marked pane.
+func (d *Daemon) handleSelectPane(req ipc.Request) ipc.Response {
+	switch {
+	case req.Unmark:
+		d.marked.Store(false)
+	case req.Mark:
+		if !d.marked.CompareAndSwap(false, true) {
+			d.marked.Store(false)
+		}
+	}
+	return ipc.Response{OK: true}
+}
@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"wintmux/internal/vt"
+)
+
+// ttlHookTimeout bounds alert-ttl-hook. It runs before the session ends,
+// so that it can still capture the pane, and the session does not wait
+// for it longer than this.
+var ttlHookTimeout = 30 * time.Second
+
+// ttlInterruptWait is how long the pane process has to exit after the
+// Ctrl-C sent when the TTL is reached, before it is killed.
+var ttlInterruptWait = 5 * time.Second
+
+// expireAfter ends the session once it has run for ttl (new-session
+// --ttl), unless kill-session ends it first.
+func (d *Daemon) expireAfter(ttl time.Duration) {
+	select {
+	case <-time.After(time.Until(d.started.Add(ttl))):
+	case <-d.killed:
+		return
+	}
+	d.expire(ttl)
+}
+
+// expire ends a session whose TTL is up: it emits a "ttl" event, runs
+// alert-ttl-hook to completion, interrupts the pane process with Ctrl-C
+// and, if that is not enough, kills it, then shuts down as kill-session
+// does. A pane that has already died (remain-on-exit) is not
+// interrupted.
+func (d *Daemon) expire(ttl time.Duration) {
+	log.Printf("daemon: session TTL %v reached; ending the session", ttl)
+	d.events.emit("ttl", fmt.Sprintf("session TTL %v reached", ttl), map[string]string{
+		"ttl": ttl.String(),
+	})
+	d.runTTLHook()
+	if c := d.child(); !d.childExited() {
+		key, _ := vt.ParseKey("C-c")
+		c.term.Write(key.Encode(d.screen.KeyMode()))
+		select {
+		case <-c.done:
+		case <-time.After(ttlInterruptWait):
+			log.Printf("daemon: pane pid=%d still running %v after Ctrl-C; killing it", c.term.Pid(), ttlInterruptWait)
+		}
+	}
+	d.handleKillSession()
+}
+
+// runTTLHook runs alert-ttl-hook, if set, and waits up to ttlHookTimeout
+// for it.
+func (d *Daemon) runTTLHook() {
+	hook := d.option("alert-ttl-hook")
+	if hook == "" {
+		return
+	}
+	cmd := d.childCommand(d.expandCommand(hook))
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_ALERT=ttl",
+		"WINTMUX_PANE_PID="+strconv.Itoa(d.term().Pid()),
+	)
+	var out strings.Builder
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.WaitDelay = time.Second // for children left holding the output pipe
+	if err := cmd.Start(); err != nil {
+		log.Printf("daemon: alert-ttl-hook failed: %v", err)
+		return
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("daemon: alert-ttl-hook failed: %v: %s", err, strings.TrimSpace(out.String()))
+		}
+	case <-time.After(ttlHookTimeout):
+		cmd.Process.Kill()
+		<-done
+		log.Printf("daemon: alert-ttl-hook timed out after %v", ttlHookTimeout)
+	}
+}
@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"strconv"
+	"time"
+
+	"wintmux/internal/proc"
+)
+
+// usageInterval is how often the daemon samples the resources the pane's
+// processes hold. Tests sample directly.
+var usageInterval = 5 * time.Second
+
+// paneUsage is one sample of the resources held by the pane's process
+// and all its descendants.
+type paneUsage struct {
+	at            time.Time
+	pid           int           // pane process the sample is of
+	cpuTime       time.Duration // consumed by the processes alive at the sample
+	cpuPercent    float64       // of one CPU, since the previous sample
+	memory        uint64        // bytes resident
+	handles       int
+	processes     int
+	foreground    string // executable of the foreground process (see foreground); see automatic-rename
+	foregroundPID int    // and its PID; see pane_current_path
+}
+
+// inForeground reports whether a is more likely than b to be the
+// program in the foreground of the pane. There is no terminal foreground
+// process group to ask on Windows, so it is a guess: the process started
+// last wins, as the program most recently run, then the deeper one, then
+// the higher PID, for processes started together or whose start cannot
+// be read. A program that starts a helper in the background hands the
+// foreground to the helper.
+func inForeground(a, b proc.Node) bool {
+	if !a.Started.Equal(b.Started) {
+		return a.Started.After(b.Started)
+	}
+	if a.Depth != b.Depth {
+		return a.Depth > b.Depth
+	}
+	return a.PID > b.PID
+}
+
+// monitorUsage samples resource use every usageInterval for the life of
+// the daemon, checking after each sample whether the pane is stuck.
+func (d *Daemon) monitorUsage() {
+	t := time.NewTicker(usageInterval)
+	defer t.Stop()
+	for {
+		d.sampleUsage()
+		d.checkStuck()
+		<-t.C
+	}
+}
+
+// sampleUsage records the resources the pane's process tree holds. With
+// no process (a serial pane, or one that has exited) there is no sample.
+func (d *Daemon) sampleUsage() {
+	pid := d.term().Pid()
+	if pid == 0 || d.childExited() {
+		d.usage.Store(nil)
+		return
+	}
+	procs, err := proc.List()
+	if err != nil {
+		return
+	}
+	u := &paneUsage{at: time.Now(), pid: pid}
+	var fg proc.Node
+	for _, n := range proc.Tree(procs, pid) {
+		r, err := proc.ResourceUsage(n.PID)
+		if err != nil {
+			continue // exited since the listing
+		}
+		if u.processes == 0 || inForeground(n, fg) {
+			fg = n
+		}
+		u.cpuTime += r.CPU
+		u.memory += r.Memory
+		u.handles += r.Handles
+		u.processes++
+	}
+	if u.processes == 0 {
+		d.usage.Store(nil)
+		return
+	}
+	u.foregroundPID, u.foreground = fg.PID, fg.Name
+	// CPU time falls when a process exits; that interval counts as idle.
+	if prev := d.usage.Load(); prev != nil && prev.pid == pid {
+		if wall := u.at.Sub(prev.at); wall > 0 && u.cpuTime > prev.cpuTime {
+			u.cpuPercent = 100 * float64(u.cpuTime-prev.cpuTime) / float64(wall)
+		}
+	}
+	d.usage.Store(u)
+	d.checkUsage(u)
+}
+
+// usageVars adds the resource variables of the last sample to vars. They
+// are empty until the first sample, and when the pane has no process.
+func (d *Daemon) usageVars(vars map[string]string) {
+	u := d.usage.Load()
+	if u == nil {
+		for _, name := range []string{"pane_cpu", "pane_cpu_time", "pane_memory", "pane_handles", "pane_processes"} {
+			vars[name] = ""
+		}
+		return
+	}
+	vars["pane_cpu"] = strconv.FormatFloat(u.cpuPercent, 'f', 1, 64)
+	vars["pane_cpu_time"] = strconv.FormatFloat(u.cpuTime.Seconds(), 'f', 2, 64)
+	vars["pane_memory"] = strconv.FormatUint(u.memory, 10)
+	vars["pane_handles"] = strconv.Itoa(u.handles)
+	vars["pane_processes"] = strconv.Itoa(u.processes)
+}
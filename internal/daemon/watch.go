@@ -0,0 +1,245 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"wintmux/internal/ipc"
+	"wintmux/internal/vt"
+)
+
+// maxWatches bounds the number of watches per session, since every
+// output line is matched against each of them.
+const maxWatches = 64
+
+// maxHookStarts bounds how many watch hooks a session starts per second,
+// across all its watches, so a pane printing a match in a tight loop
+// cannot flood the host with processes.
+const maxHookStarts = 10
+
+// watch is a regular expression evaluated against each line of pane
+// output as it arrives.
+type watch struct {
+	id   int
+	name string
+	re   *regexp.Regexp
+	hook string // shell command run on each match; empty for events only
+	once bool   // remove after the first match
+	hits int
+	// hookRunning is shared by the copies made for matches: while the
+	// hook runs, further matches emit events but start no other.
+	hookRunning *atomic.Bool
+}
+
+// watchSet holds the session's watches and the output line being
+// assembled. An incomplete line (such as a "Password:" prompt) is
+// matched as it grows; fired remembers which watches already matched it
+// so they do not fire again when the line completes.
+type watchSet struct {
+	mu      sync.Mutex
+	watches []*watch
+	nextID  int
+	partial []byte
+	fired   map[int]bool
+
+	hookSecond time.Time // start of the second hookStarts counts in
+	hookStarts int
+}
+
+// match is one watch hit, collected under the lock and acted on after.
+type match struct {
+	w    watch
+	text string
+	line string
+}
+
+// maxWatchLine bounds the incomplete line kept for matching; output that
+// never ends a line (full-screen redraws) only keeps its tail.
+const maxWatchLine = 4096
+
+// feedWatches matches newly read output against the watches.
+func (d *Daemon) feedWatches(data []byte) {
+	ws := &d.watches
+	ws.mu.Lock()
+	if len(ws.watches) == 0 {
+		ws.partial = ws.partial[:0]
+		ws.mu.Unlock()
+		return
+	}
+	var hits []match
+	for _, c := range data {
+		switch c {
+		case '\n':
+			hits = ws.matchLocked(string(ws.partial), hits)
+			ws.partial = ws.partial[:0]
+			ws.fired = nil
+		case '\r':
+		default:
+			ws.partial = append(ws.partial, c)
+		}
+	}
+	if over := len(ws.partial) - maxWatchLine; over > 0 {
+		ws.partial = append(ws.partial[:0], ws.partial[over:]...)
+	}
+	if len(ws.partial) > 0 {
+		partialHits := ws.matchLocked(string(ws.partial), nil)
+		for _, h := range partialHits {
+			if ws.fired == nil {
+				ws.fired = make(map[int]bool)
+			}
+			ws.fired[h.w.id] = true
+		}
+		hits = append(hits, partialHits...)
+	}
+	ws.mu.Unlock()
+
+	for _, h := range hits {
+		d.fireWatch(h)
+	}
+}
+
+func (ws *watchSet) matchLocked(raw string, hits []match) []match {
+	line := vt.Strip(raw)
+	kept := ws.watches[:0]
+	for _, w := range ws.watches {
+		if ws.fired[w.id] {
+			kept = append(kept, w)
+			continue
+		}
+		loc := w.re.FindStringIndex(line)
+		if loc == nil {
+			kept = append(kept, w)
+			continue
+		}
+		w.hits++
+		hits = append(hits, match{w: *w, text: line[loc[0]:loc[1]], line: line})
+		if !w.once {
+			kept = append(kept, w)
+		}
+	}
+	for i := len(kept); i < len(ws.watches); i++ {
+		ws.watches[i] = nil
+	}
+	ws.watches = kept
+	return hits
+}
+
+// fireWatch emits a watch event and starts the watch's hook, if any,
+// unless it is still running from an earlier match or the session has
+// started maxHookStarts hooks in the last second. Hooks run in the
+// background with the match in their environment; formats in them are
+// expanded.
+// Watches match output as written; the line and match they report are
+// redacted.
+func (d *Daemon) fireWatch(h match) {
+	h.line, h.text = d.redact(h.line), d.redact(h.text)
+	d.events.emit("watch", h.w.name+": "+h.line, map[string]string{
+		"watch_id":    strconv.Itoa(h.w.id),
+		"watch_name":  h.w.name,
+		"watch_match": h.text,
+		"watch_line":  h.line,
+	})
+	if h.w.hook == "" || !h.w.hookRunning.CompareAndSwap(false, true) {
+		return
+	}
+	if !d.watches.allowHook(time.Now()) {
+		h.w.hookRunning.Store(false)
+		return
+	}
+	cmd := d.childCommand(d.expandCommand(h.w.hook))
+	cmd.Env = append(os.Environ(),
+		"WINTMUX_SESSION="+d.sessionName,
+		"WINTMUX_SOCKET="+d.socketPath,
+		"WINTMUX_WATCH="+h.w.name,
+		"WINTMUX_MATCH="+h.text,
+		"WINTMUX_LINE="+h.line,
+	)
+	go func() {
+		defer h.w.hookRunning.Store(false)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Printf("daemon: watch %s hook failed: %v: %s", h.w.name, err, strings.TrimSpace(string(out)))
+		}
+	}()
+}
+
+// allowHook counts a hook start at now and reports whether it is within
+// maxHookStarts for the current second.
+func (ws *watchSet) allowHook(now time.Time) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if now.Sub(ws.hookSecond) >= time.Second {
+		ws.hookSecond, ws.hookStarts = now, 0
+	}
+	if ws.hookStarts >= maxHookStarts {
+		return false
+	}
+	ws.hookStarts++
+	return true
+}
+
+func (d *Daemon) handleWatchAdd(req ipc.Request) ipc.Response {
+	re, err := regexp.Compile(req.Pattern)
+	if err != nil {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("bad pattern: %v", err)}
+	}
+	if req.Pattern == "" {
+		return ipc.Response{OK: false, Error: "no pattern specified"}
+	}
+
+	ws := &d.watches
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.watches) >= maxWatches {
+		return ipc.Response{OK: false, Error: fmt.Sprintf("too many watches (max %d)", maxWatches)}
+	}
+	ws.nextID++
+	name := req.Name
+	if name == "" {
+		name = "w" + strconv.Itoa(ws.nextID)
+	}
+	for _, w := range ws.watches {
+		if w.name == name {
+			return ipc.Response{OK: false, Error: fmt.Sprintf("watch already exists: %s", name)}
+		}
+	}
+	ws.watches = append(ws.watches, &watch{id: ws.nextID, name: name, re: re, hook: req.Hook, once: req.Once, hookRunning: new(atomic.Bool)})
+	return ipc.Response{OK: true, Output: name}
+}
+
+func (d *Daemon) handleWatchList() ipc.Response {
+	ws := &d.watches
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	lines := make([]string, 0, len(ws.watches))
+	for _, w := range ws.watches {
+		line := fmt.Sprintf("%s hits=%d /%s/", w.name, w.hits, w.re)
+		if w.once {
+			line += " once"
+		}
+		if w.hook != "" {
+			line += " hook=" + strconv.Quote(w.hook)
+		}
+		lines = append(lines, line)
+	}
+	return ipc.Response{OK: true, Output: strings.Join(lines, "\n")}
+}
+
+func (d *Daemon) handleWatchRemove(req ipc.Request) ipc.Response {
+	ws := &d.watches
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for i, w := range ws.watches {
+		if w.name == req.Name {
+			ws.watches = append(ws.watches[:i], ws.watches[i+1:]...)
+			return ipc.Response{OK: true}
+		}
+	}
+	return ipc.Response{OK: false, Error: fmt.Sprintf("no watch: %s", req.Name)}
+}
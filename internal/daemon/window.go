@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// windowOptionDefaults holds the value of window options that have not
+// been set, as tmux has them.
+var windowOptionDefaults = map[string]string{
+	"monitor-bell":      "on",
+	"monitor-activity":  "off",
+	"automatic-rename":  "on",
+	"synchronize-panes": "off",
+}
+
+// windowOptions lists the options of the session's window, which
+// set-option -w sets. A session has one window, so they are kept apart
+// from the session's options only by name and scope; set-option without
+// -w finds them by name.
+var windowOptions = map[string]optionSetter{
+	"monitor-bell": func(d *Daemon, v string) error {
+		if err := checkFlag(v); err != nil {
+			return err
+		}
+		if v == "off" {
+			d.bellFlag.Store(false)
+		}
+		return nil
+	},
+	"monitor-activity": func(d *Daemon, v string) error {
+		if err := checkFlag(v); err != nil {
+			return err
+		}
+		if v == "off" {
+			d.activityFlag.Store(false)
+		}
+		return nil
+	},
+	"automatic-rename": func(d *Daemon, v string) error {
+		if err := checkFlag(v); err != nil {
+			return err
+		}
+		// Turned off, the window keeps the name it has.
+		if v == "off" && d.windowOptionLocked("automatic-rename") == "on" {
+			name := d.automaticName()
+			d.fixedName.Store(&name)
+		}
+		return nil
+	},
+	// The window has one pane, so there is nothing to synchronize input
+	// with; the option is kept for tmux scripts that set it.
+	"synchronize-panes": func(d *Daemon, v string) error {
+		return checkFlag(v)
+	},
+}
+
+// windowOption returns the current value of a window option, or its
+// default.
+func (d *Daemon) windowOption(name string) string {
+	d.optionsMu.Lock()
+	defer d.optionsMu.Unlock()
+	return d.windowOptionLocked(name)
+}
+
+func (d *Daemon) windowOptionLocked(name string) string {
+	if v, ok := d.windowOpts[name]; ok {
+		return v
+	}
+	return windowOptionDefaults[name]
+}
+
+// noteActivity raises an activity alert for output no attached terminal
+// shows, with monitor-activity on: it sets the activity flag and, when
+// the flag was clear, emits an "activity" event. Called after each output
+// chunk reaches the screen.
+func (d *Daemon) noteActivity() {
+	if d.windowOption("monitor-activity") != "on" || d.attached.viewing() {
+		return
+	}
+	if d.activityFlag.CompareAndSwap(false, true) {
+		d.events.emit("activity", "activity", nil)
+	}
+}
+
+// windowName is the window's name: with automatic-rename on, that of the
+// program in the foreground of the pane; with it off, the name it had
+// when it was turned off.
+func (d *Daemon) windowName() string {
+	if d.windowOption("automatic-rename") != "on" {
+		if name := d.fixedName.Load(); name != nil {
+			return *name
+		}
+	}
+	return d.automaticName()
+}
+
+// automaticName names the window after the program the last usage sample
+// found in the foreground of the pane, or before the first sample after
+// the pane's command.
+func (d *Daemon) automaticName() string {
+	if u := d.usage.Load(); u != nil && u.foreground != "" {
+		return programName(u.foreground)
+	}
+	d.childMu.RLock()
+	command := d.command
+	d.childMu.RUnlock()
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return programName(fields[0])
+	}
+	return ""
+}
+
+// programName returns the name of the program at path, without its
+// directory, surrounding quotes or .exe extension.
+func programName(path string) string {
+	name := filepath.Base(strings.ReplaceAll(strings.Trim(path, `"`), `\`, "/"))
+	if strings.HasSuffix(strings.ToLower(name), ".exe") {
+		name = name[:len(name)-len(".exe")]
+	}
+	return name
+}
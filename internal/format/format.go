@@ -0,0 +1,102 @@
+// Package format implements the subset of tmux format strings understood
+// by wintmux: #{name} variable references, #{?name,then,else} conditionals
+// and the ## escape for a literal '#'.
+package format
+
+import "strings"
+
+// Expand replaces format references in tmpl with values from vars.
+// Unknown variables expand to the empty string, matching tmux.
+func Expand(tmpl string, vars map[string]string) string {
+	var sb strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+		if c != '#' || i+1 >= len(tmpl) {
+			sb.WriteByte(c)
+			continue
+		}
+		switch tmpl[i+1] {
+		case '#':
+			sb.WriteByte('#')
+			i++
+		case '{':
+			end := matchBrace(tmpl, i+1)
+			if end < 0 {
+				sb.WriteString(tmpl[i:])
+				return sb.String()
+			}
+			sb.WriteString(expandRef(tmpl[i+2:end], vars))
+			i = end
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// expandRef evaluates the body of a single #{...} reference.
+func expandRef(ref string, vars map[string]string) string {
+	if !strings.HasPrefix(ref, "?") {
+		return vars[ref]
+	}
+	parts := splitTop(ref[1:])
+	if len(parts) == 0 {
+		return ""
+	}
+	cond := vars[parts[0]]
+	then, els := "", ""
+	if len(parts) > 1 {
+		then = parts[1]
+	}
+	if len(parts) > 2 {
+		els = parts[2]
+	}
+	if Truthy(cond) {
+		return Expand(then, vars)
+	}
+	return Expand(els, vars)
+}
+
+// Truthy reports whether a format value counts as true in a conditional:
+// non-empty and not "0".
+func Truthy(v string) bool {
+	return v != "" && v != "0"
+}
+
+// matchBrace returns the index of the '}' closing the '{' at open,
+// honouring nested #{...} references, or -1 if it is unterminated.
+func matchBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitTop splits s on commas that are not nested inside #{...}.
+func splitTop(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
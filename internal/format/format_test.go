@@ -0,0 +1,47 @@
+package format
+
+import "testing"
+
+func TestExpandVariables(t *testing.T) {
+	vars := map[string]string{"session_name": "agent1", "cursor_x": "4", "cursor_y": "12"}
+	got := Expand("[#{session_name}] #{cursor_x},#{cursor_y}", vars)
+	if got != "[agent1] 4,12" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExpandUnknownVariable(t *testing.T) {
+	if got := Expand("a#{nope}b", nil); got != "ab" {
+		t.Errorf("got %q, want %q", got, "ab")
+	}
+}
+
+func TestExpandConditional(t *testing.T) {
+	vars := map[string]string{"alternate_on": "1", "cursor_flag": "0"}
+	if got := Expand("#{?alternate_on,alt,main}", vars); got != "alt" {
+		t.Errorf("alternate_on: got %q", got)
+	}
+	if got := Expand("#{?cursor_flag,shown,hidden}", vars); got != "hidden" {
+		t.Errorf("cursor_flag: got %q", got)
+	}
+}
+
+func TestExpandNestedConditional(t *testing.T) {
+	vars := map[string]string{"pane_dead": "0", "session_name": "s"}
+	got := Expand("#{?pane_dead,dead,live:#{session_name}}", vars)
+	if got != "live:s" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExpandEscapes(t *testing.T) {
+	if got := Expand("##{x} # #", map[string]string{"x": "y"}); got != "#{x} # #" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExpandUnterminated(t *testing.T) {
+	if got := Expand("abc #{session", nil); got != "abc #{session" {
+		t.Errorf("got %q", got)
+	}
+}
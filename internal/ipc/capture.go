@@ -0,0 +1,84 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// PaneCapture is one pane's contents and state. capture_all returns a
+// JSON array of them in Output, one per pane. When several sessions are
+// captured at once, a session that could not be reached gets an entry
+// with only Session, Socket and Error set.
+type PaneCapture struct {
+	Session       string   `json:"session"`
+	Socket        string   `json:"socket,omitempty"`
+	WindowIndex   int      `json:"window_index"`
+	PaneIndex     int      `json:"pane_index"`
+	PaneID        string   `json:"pane_id"`
+	Width         int      `json:"width"`
+	Height        int      `json:"height"`
+	CursorX       int      `json:"cursor_x"`
+	CursorY       int      `json:"cursor_y"`
+	CursorVisible bool     `json:"cursor_visible"`
+	Alternate     bool     `json:"alternate_on"`
+	Dead          bool     `json:"pane_dead"`
+	Lines         []string `json:"lines"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// ParseCaptures decodes the Output of a capture_all response.
+func ParseCaptures(output string) ([]PaneCapture, error) {
+	var panes []PaneCapture
+	if err := json.Unmarshal([]byte(output), &panes); err != nil {
+		return nil, err
+	}
+	return panes, nil
+}
+
+// FormatCaptures encodes panes as the Output of a capture_all response.
+func FormatCaptures(panes []PaneCapture) string {
+	if panes == nil {
+		panes = []PaneCapture{}
+	}
+	data, _ := json.Marshal(panes)
+	return string(data)
+}
+
+// Target names a session to capture: its name and socket path.
+type Target struct {
+	Session string
+	Socket  string
+}
+
+// GatherCaptures sends capture_all to every target at once with send and
+// returns all their panes, in target order. A session that fails to
+// answer gets an entry with Error set rather than failing the lot.
+func GatherCaptures(targets []Target, send func(socket string) (*Response, error)) []PaneCapture {
+	results := make([][]PaneCapture, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			resp, err := send(t.Socket)
+			if err == nil && !resp.OK {
+				err = errors.New(resp.Error)
+			}
+			var panes []PaneCapture
+			if err == nil {
+				panes, err = ParseCaptures(resp.Output)
+			}
+			if err != nil {
+				panes = []PaneCapture{{Session: t.Session, Socket: t.Socket, Error: err.Error()}}
+			}
+			results[i] = panes
+		}(i, t)
+	}
+	wg.Wait()
+	var all []PaneCapture
+	for _, panes := range results {
+		all = append(all, panes...)
+	}
+	return all
+}
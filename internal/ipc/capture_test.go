@@ -0,0 +1,33 @@
+package ipc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGatherCaptures(t *testing.T) {
+	targets := []Target{{"a", "/s/a"}, {"b", "/s/b"}, {"c", "/s/c"}}
+	panes := GatherCaptures(targets, func(socket string) (*Response, error) {
+		switch socket {
+		case "/s/a":
+			return &Response{OK: true, Output: FormatCaptures([]PaneCapture{{Session: "a", Lines: []string{"x"}}})}, nil
+		case "/s/b":
+			return &Response{OK: false, Error: "denied"}, nil
+		}
+		return nil, errors.New("refused")
+	})
+	if len(panes) != 3 {
+		t.Fatalf("got %d panes, want 3: %+v", len(panes), panes)
+	}
+	if panes[0].Session != "a" || panes[0].Error != "" || panes[0].Lines[0] != "x" {
+		t.Errorf("pane a = %+v", panes[0])
+	}
+	for i, want := range []string{"", "denied", "refused"} {
+		if i > 0 && (panes[i].Error != want || panes[i].Session != targets[i].Session || panes[i].Socket != targets[i].Socket) {
+			t.Errorf("pane %d = %+v, want error %q", i, panes[i], want)
+		}
+	}
+	if FormatCaptures(nil) != "[]" {
+		t.Errorf("FormatCaptures(nil) = %s", FormatCaptures(nil))
+	}
+}
@@ -2,17 +2,44 @@ package ipc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"time"
+
+	"wintmux/internal/proc"
 )
 
 // ControlInfo is written to the socket path file by the daemon so that
 // CLI clients can discover which TCP port to connect to.
 type ControlInfo struct {
-	Port int `json:"port"`
-	PID  int `json:"pid"`
+	Port  int      `json:"port"`
+	Addrs []string `json:"addrs,omitempty"` // listening addresses; older daemons omit them
+	PID   int      `json:"pid"`
+	State string   `json:"state,omitempty"` // "starting" while the daemon initializes
+	Error string   `json:"error,omitempty"` // set when State is "failed"
+}
+
+// Dial connects to the daemon described by info, trying each of its
+// listening addresses in turn. Daemons that predate Addrs listen on IPv4
+// loopback only.
+func Dial(info *ControlInfo, timeout time.Duration) (net.Conn, error) {
+	addrs := info.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(info.Port))}
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err == nil {
+			DefaultTCP.Tune(conn)
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
 }
 
 // ReadControlFile reads the daemon's control info from the socket path.
@@ -28,6 +55,9 @@ func ReadControlFile(path string) (*ControlInfo, error) {
 	return &info, nil
 }
 
+// errStarting is returned by Connect while the daemon initializes.
+var errStarting = errors.New("session is still starting")
+
 // Connect establishes a TCP connection to the daemon identified by the
 // given socket (control file) path. Returns an error if the control file
 // doesn't exist or the daemon isn't reachable.
@@ -36,34 +66,130 @@ func Connect(socketPath string) (net.Conn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("session not found: %w", err)
 	}
+	switch info.State {
+	case "starting":
+		return nil, fmt.Errorf("%w (daemon pid %d)", errStarting, info.PID)
+	case "failed":
+		return nil, fmt.Errorf("session failed to start: %s", info.Error)
+	}
 
-	addr := fmt.Sprintf("127.0.0.1:%d", info.Port)
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	conn, err := Dial(info, 5*time.Second)
 	if err != nil {
+		if info.PID > 0 && !proc.Alive(info.PID) {
+			// A control file left by a daemon that died without
+			// cleaning up; nothing will ever answer on that port.
+			return nil, fmt.Errorf("session not running: daemon (pid %d) has exited", info.PID)
+		}
 		return nil, fmt.Errorf("session not running: %w", err)
 	}
 
 	return conn, nil
 }
 
+// Ping checks once, without retrying, that the daemon on socketPath
+// answers. Startup probes use it since they do their own backoff.
+func Ping(socketPath string, timeout time.Duration) error {
+	conn, err := Connect(socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := WriteMessage(conn, &Request{Action: ActionPing, Client: ClientName()}); err != nil {
+		return err
+	}
+	var resp Response
+	if err := ReadMessage(conn, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// ClientName returns the identity this process presents to daemons.
+// WINTMUX_CLIENT overrides it; otherwise it is derived from the parent
+// process, so repeated CLI invocations from one orchestrator or shell are
+// grouped as a single client, much like tmux keys clients by tty. The
+// daemon cannot verify it, so policies keyed on it are advisory.
+func ClientName() string {
+	if name := os.Getenv("WINTMUX_CLIENT"); name != "" {
+		return name
+	}
+	return fmt.Sprintf("pid:%d", os.Getppid())
+}
+
+// CompressionEnabled reports whether clients should accept compressed
+// responses. WINTMUX_COMPRESS=off disables it, e.g. to inspect traffic.
+func CompressionEnabled() bool {
+	return os.Getenv("WINTMUX_COMPRESS") != "off"
+}
+
+// requestTimeout bounds a normal request/response exchange.
+const requestTimeout = 10 * time.Second
+
 // SendRequest connects to the daemon, sends a request, and returns the response.
 func SendRequest(socketPath string, req *Request) (*Response, error) {
-	conn, err := Connect(socketPath)
+	return SendRequestTimeout(socketPath, req, requestTimeout)
+}
+
+// SendRequestTimeout is like SendRequest but allows the exchange to take
+// up to timeout, for actions that block in the daemon (e.g. wait_stable).
+func SendRequestTimeout(socketPath string, req *Request, timeout time.Duration) (*Response, error) {
+	return SendRequestProgress(socketPath, req, timeout, nil)
+}
+
+// ProgressStall is how long SendRequestProgress waits between frames once
+// the daemon has sent a progress frame. Daemons that report progress do so
+// at least every second while working, so a longer silence means the
+// daemon is stuck, and there is no point waiting out the full timeout.
+const ProgressStall = 15 * time.Second
+
+// SendRequestProgress is like SendRequestTimeout but asks the daemon for
+// progress frames on long operations and passes each status line to
+// onProgress (which may be nil) until the final response arrives.
+func SendRequestProgress(socketPath string, req *Request, timeout time.Duration, onProgress func(string)) (*Response, error) {
+	// Only connecting is retried: once the request is written it may
+	// have taken effect, and input must never be sent twice.
+	conn, err := ConnectRetry(socketPath, DefaultRetry)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
-	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	deadline := time.Now().Add(timeout)
+	conn.SetDeadline(deadline)
+
+	if req.Client == "" {
+		req.Client = ClientName()
+	}
+	if CompressionEnabled() {
+		req.Compress = true
+	}
+	if onProgress != nil {
+		req.Progress = true
+	}
 
 	if err := WriteMessage(conn, req); err != nil {
 		return nil, fmt.Errorf("send request: %w", err)
 	}
 
-	var resp Response
-	if err := ReadMessage(conn, &resp); err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
+	for {
+		var resp Response
+		if err := ReadMessage(conn, &resp); err != nil {
+			return nil, fmt.Errorf("read response: %w", err)
+		}
+		if !resp.Progress {
+			return &resp, nil
+		}
+		if onProgress != nil {
+			onProgress(resp.Output)
+		}
+		if stall := time.Now().Add(ProgressStall); stall.Before(deadline) {
+			conn.SetReadDeadline(stall)
+		} else {
+			conn.SetReadDeadline(deadline)
+		}
 	}
-
-	return &resp, nil
 }
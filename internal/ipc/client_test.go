@@ -0,0 +1,109 @@
+package ipc
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveOnce answers one request on a fresh listener with frames, and
+// returns the control file path for it.
+func serveOnce(t *testing.T, frames ...Response) (string, <-chan Request) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	path := filepath.Join(t.TempDir(), "s.sock")
+	data, _ := json.Marshal(ControlInfo{Port: ln.Addr().(*net.TCPAddr).Port, PID: os.Getpid()})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req Request
+		if ReadMessage(conn, &req) != nil {
+			return
+		}
+		got <- req
+		for _, f := range frames {
+			WriteMessage(conn, f)
+		}
+	}()
+	return path, got
+}
+
+func TestSendRequestProgress(t *testing.T) {
+	path, got := serveOnce(t,
+		Response{OK: true, Progress: true, Output: "quiet for 100ms"},
+		Response{OK: true, Progress: true, Output: "quiet for 300ms"},
+		Response{OK: true, Output: "done"},
+	)
+	var status []string
+	resp, err := SendRequestProgress(path, &Request{Action: ActionWaitStable}, 5*time.Second, func(s string) {
+		status = append(status, s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := <-got; !req.Progress {
+		t.Error("request did not ask for progress frames")
+	}
+	if resp.Output != "done" || resp.Progress {
+		t.Errorf("unexpected final response %+v", resp)
+	}
+	if len(status) != 2 || status[1] != "quiet for 300ms" {
+		t.Errorf("unexpected progress %q", status)
+	}
+}
+
+func TestSendRequestSkipsProgress(t *testing.T) {
+	path, got := serveOnce(t,
+		Response{OK: true, Progress: true, Output: "working"},
+		Response{OK: true, Output: "done"},
+	)
+	resp, err := SendRequestTimeout(path, &Request{Action: ActionWaitStable}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := <-got; req.Progress {
+		t.Error("request asked for progress frames without a callback")
+	}
+	if resp.Output != "done" {
+		t.Errorf("expected final response, got %+v", resp)
+	}
+}
+
+func TestDialTriesEachAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	conn, err := Dial(&ControlInfo{Addrs: []string{deadAddr, ln.Addr().String()}}, time.Second)
+	if err != nil {
+		t.Fatalf("expected the second address to answer: %v", err)
+	}
+	conn.Close()
+
+	// Without Addrs, the port is on IPv4 loopback.
+	conn, err = Dial(&ControlInfo{Port: ln.Addr().(*net.TCPAddr).Port}, time.Second)
+	if err != nil {
+		t.Fatalf("dial by port: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,30 @@
+package ipc
+
+import "encoding/json"
+
+// SessionSettings is what a session was started with, as clone_info
+// returns it in Output: enough for clone-session to start another
+// session like it.
+type SessionSettings struct {
+	Command   string            `json:"command,omitempty"`    // startup command; empty for the default shell
+	Dir       string            `json:"dir,omitempty"`        // the pane's current directory
+	Env       []string          `json:"env"`                  // the daemon's environment, NAME=value
+	Backend   string            `json:"backend,omitempty"`    // terminal backend spec, as --backend takes it
+	SecretEnv []string          `json:"secret_env,omitempty"` // --secret-env entries, NAME=SOURCE:NAME
+	Options   map[string]string `json:"options,omitempty"`    // options that have been set
+}
+
+// ParseSettings decodes the Output of a clone_info response.
+func ParseSettings(output string) (*SessionSettings, error) {
+	var s SessionSettings
+	if err := json.Unmarshal([]byte(output), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// FormatSettings encodes s as the Output of a clone_info response.
+func FormatSettings(s *SessionSettings) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,104 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits on decoded messages. Every message the CLI, broker and daemon
+// exchange is a flat object, so deep nesting or oversized fields only
+// come from a broken or hostile peer.
+const (
+	maxNesting    = 16        // JSON object/array depth
+	maxShortField = 1024      // names, keys, actions, client IDs
+	maxLongField  = 64 * 1024 // patterns, formats, commands, paths, values
+	maxEnvEntries = 4096
+	maxDimension  = 10000 // attach width and height
+)
+
+// checkNesting rejects JSON nested more than maxNesting deep, before it
+// reaches the decoder.
+func checkNesting(data []byte) error {
+	depth := 0
+	inString, escaped := false, false
+	for _, b := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch b {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case b == '"':
+			inString = true
+		case b == '{' || b == '[':
+			depth++
+			if depth > maxNesting {
+				return fmt.Errorf("nesting deeper than %d", maxNesting)
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// Validate checks that a decoded request is within the field limits.
+// Servers call it before acting on a request.
+func (r *Request) Validate() error {
+	short := map[string]string{
+		"action":        string(r.Action),
+		"session":       r.Session,
+		"client":        r.Client,
+		"key":           r.Key,
+		"option":        r.Option,
+		"name":          r.Name,
+		"event_type":    r.EventType,
+		"strip":         r.Strip,
+		"stream":        r.Stream,
+		"shell":         r.Shell,
+		"colors":        r.Colors,
+		"target_client": r.TargetClient,
+		"cron":          r.Cron,
+	}
+	for field, v := range short {
+		if len(v) > maxShortField {
+			return fmt.Errorf("%s too long: %d bytes (max %d)", field, len(v), maxShortField)
+		}
+	}
+	long := map[string]string{
+		"value":     r.Value,
+		"shell_cmd": r.ShellCmd,
+		"format":    r.Format,
+		"start_dir": r.StartDir,
+		"pattern":   r.Pattern,
+		"hook":      r.Hook,
+		"replace":   r.Replace,
+		"mirror_to": r.MirrorTo,
+	}
+	for field, v := range long {
+		if len(v) > maxLongField {
+			return fmt.Errorf("%s too long: %d bytes (max %d)", field, len(v), maxLongField)
+		}
+	}
+	for field, env := range map[string][]string{"env": r.Env, "client_env": r.ClientEnv, "args": r.Args} {
+		if len(env) > maxEnvEntries {
+			return fmt.Errorf("%s has too many entries: %d (max %d)", field, len(env), maxEnvEntries)
+		}
+		for _, e := range env {
+			if len(e) > maxLongField {
+				return fmt.Errorf("%s entry too long: %d bytes (max %d)", field, len(e), maxLongField)
+			}
+		}
+	}
+	if r.Lines < 0 || r.QuietMs < 0 || r.TimeoutMs < 0 || r.Start < 0 || r.Count < 0 || r.RotateSize < 0 || r.Keep < 0 || r.DelayMs < 0 || r.IntervalMs < 0 {
+		return errors.New("negative count or duration")
+	}
+	if r.Width < 0 || r.Width > maxDimension || r.Height < 0 || r.Height > maxDimension {
+		return fmt.Errorf("terminal size %dx%d out of range", r.Width, r.Height)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package ipc
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckNesting(t *testing.T) {
+	ok := []string{
+		`{"action":"ping"}`,
+		`{"env":["A=1","B=2"]}`,
+		`{"text":"` + strings.Repeat("[{", 100) + `"}`,
+		`{"text":"\"[[[[[[[[[[[[[[[[[[[["}`,
+	}
+	for _, s := range ok {
+		if err := checkNesting([]byte(s)); err != nil {
+			t.Errorf("checkNesting(%q): %v", s, err)
+		}
+	}
+	deep := strings.Repeat("[", maxNesting+1) + strings.Repeat("]", maxNesting+1)
+	if err := checkNesting([]byte(deep)); err == nil {
+		t.Error("expected error for deep nesting")
+	}
+}
+
+func TestReadMessageBadBody(t *testing.T) {
+	for _, body := range []string{
+		`{"action":`,
+		`{"action": 5}`,
+		`{"text": ` + strings.Repeat("[", 100) + `}`,
+	} {
+		var buf bytes.Buffer
+		n := len(body)
+		buf.Write([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+		buf.WriteString(body)
+		buf.WriteString("trailing")
+
+		var req Request
+		err := ReadMessage(&buf, &req)
+		if !errors.Is(err, ErrBadMessage) {
+			t.Errorf("body %.20q: expected ErrBadMessage, got %v", body, err)
+		}
+		if buf.String() != "trailing" {
+			t.Errorf("body %.20q: frame not consumed whole, %q left", body, buf.String())
+		}
+	}
+}
+
+func TestReadMessageTruncated(t *testing.T) {
+	// A header claiming the maximum size followed by a few bytes must
+	// fail cleanly rather than as a malformed (recoverable) message.
+	n := maxMessageSize
+	r := bytes.NewReader(append([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, `{"act`...))
+	var req Request
+	err := ReadMessage(r, &req)
+	if err == nil || errors.Is(err, ErrBadMessage) {
+		t.Errorf("expected a read error, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Request{Action: ActionSendKeys, Text: strings.Repeat("x", 1<<20), Env: []string{"A=1"}, Width: 200, Height: 50}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid request rejected: %v", err)
+	}
+	bad := []Request{
+		{Action: Action(strings.Repeat("a", maxShortField+1))},
+		{Action: ActionSetOption, Option: strings.Repeat("o", maxShortField+1)},
+		{Action: ActionWatchAdd, Pattern: strings.Repeat("p", maxLongField+1)},
+		{Action: ActionRespawn, Env: make([]string, maxEnvEntries+1)},
+		{Action: ActionCapture, Lines: -1},
+		{Action: ActionWaitStable, TimeoutMs: -5},
+		{Action: ActionAttach, Width: maxDimension + 1},
+	}
+	for i, req := range bad {
+		if err := req.Validate(); err == nil {
+			t.Errorf("request %d: expected validation error", i)
+		}
+	}
+}
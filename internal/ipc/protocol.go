@@ -1,29 +1,86 @@
 package ipc
 
 import (
+	"bytes"
+	"compress/flate"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"time"
 )
 
 // Action identifies the type of IPC request sent from the CLI to the daemon.
 type Action string
 
 const (
-	ActionSendKeys    Action = "send_keys"
-	ActionSendKey     Action = "send_key"
-	ActionCapture     Action = "capture_pane"
-	ActionHasSession  Action = "has_session"
-	ActionKillSession Action = "kill_session"
-	ActionSetOption   Action = "set_option"
-	ActionPipePane    Action = "pipe_pane"
-	ActionAttach      Action = "attach"
-	ActionPing        Action = "ping"
+	ActionSendKeys       Action = "send_keys"
+	ActionSendKey        Action = "send_key"
+	ActionSendText       Action = "send_text"
+	ActionCapture        Action = "capture_pane"
+	ActionCaptureAll     Action = "capture_all"
+	ActionHasSession     Action = "has_session"
+	ActionKillSession    Action = "kill_session"
+	ActionSetOption      Action = "set_option"
+	ActionPipePane       Action = "pipe_pane"
+	ActionPipeAdd        Action = "pipe_add"
+	ActionPipeList       Action = "pipe_list"
+	ActionPipeRemove     Action = "pipe_remove"
+	ActionMirrorOutput   Action = "mirror_output"
+	ActionAttach         Action = "attach"
+	ActionBridge         Action = "bridge"
+	ActionExec           Action = "exec"
+	ActionRunPS          Action = "run_ps"
+	ActionDisplay        Action = "display_message"
+	ActionWaitStable     Action = "wait_stable"
+	ActionWaitPrompt     Action = "wait_prompt"
+	ActionListClients    Action = "list_clients"
+	ActionLockClient     Action = "lock_client"
+	ActionUnlockClient   Action = "unlock_client"
+	ActionSuspendClient  Action = "suspend_client"
+	ActionServerAccess   Action = "server_access"
+	ActionListProcesses  Action = "list_processes"
+	ActionShowEnv        Action = "show_environment"
+	ActionCloneInfo      Action = "clone_info"
+	ActionListCommands   Action = "list_commands"
+	ActionListLinks      Action = "list_links"
+	ActionRespawn        Action = "respawn_pane"
+	ActionSelectPane     Action = "select_pane"
+	ActionShowExits      Action = "show_exits"
+	ActionInputHistory   Action = "show_input_history"
+	ActionReplayInput    Action = "replay_input"
+	ActionRecordKeys     Action = "record_keys"
+	ActionPlayKeys       Action = "play_keys"
+	ActionCheckpoint     Action = "checkpoint"
+	ActionDiffCheckpoint Action = "diff_checkpoint"
+	ActionWatchAdd       Action = "watch_add"
+	ActionWatchList      Action = "watch_list"
+	ActionWatchRemove    Action = "watch_remove"
+	ActionWaitEvent      Action = "wait_event"
+	ActionScheduleAdd    Action = "schedule_add"
+	ActionScheduleList   Action = "schedule_list"
+	ActionScheduleRemove Action = "schedule_remove"
+	ActionRedactAdd      Action = "redact_add"
+	ActionRedactList     Action = "redact_list"
+	ActionRedactRemove   Action = "redact_remove"
+	ActionPing           Action = "ping"
+
+	// Handled by the broker itself rather than forwarded to a session.
+	ActionSubscribe      Action = "subscribe"
+	ActionBrokerSessions Action = "broker_sessions"
 )
 
 // Request is a JSON message sent from the CLI client to the session daemon.
+// Client identifies the sender; SendRequest fills it in when empty.
 type Request struct {
 	Action    Action `json:"action"`
+	ID        int64  `json:"id,omitempty"`      // echoed in the response
+	Session   string `json:"session,omitempty"` // broker: session name or socket path
+	Client    string `json:"client,omitempty"`
+	Target    string `json:"target,omitempty"`   // -t: the pane acted on, checked against the session's
+	Compress  bool   `json:"compress,omitempty"` // sender accepts compressed responses
+	Progress  bool   `json:"progress,omitempty"` // sender accepts progress frames
 	Text      string `json:"text,omitempty"`
 	Key       string `json:"key,omitempty"`
 	Literal   bool   `json:"literal,omitempty"`
@@ -31,66 +88,289 @@ type Request struct {
 	Lines     int    `json:"lines,omitempty"`
 	Alternate bool   `json:"alternate,omitempty"`
 	Join      bool   `json:"join,omitempty"`
+	Frame     bool   `json:"frame,omitempty"`
+	Strip     string `json:"strip,omitempty"`        // capture_pane: history strip profile
+	Stream    string `json:"stream,omitempty"`       // capture_pane: history of stdout, stderr, or tagged by stream
+	LastCmd   bool   `json:"last_command,omitempty"` // capture_pane: output of the last shell command
+	CmdSeq    int    `json:"command,omitempty"`      // capture_pane: output of shell command N (-N counts back from the last)
+	Escapes   bool   `json:"escapes,omitempty"`      // capture_pane: keep OSC 8 hyperlinks (-e)
 	Option    string `json:"option,omitempty"`
 	Value     string `json:"value,omitempty"`
+	Window    bool   `json:"window,omitempty"` // set_option: only a window option (-w)
 	ShellCmd  string `json:"shell_cmd,omitempty"`
+	Format    string `json:"format,omitempty"`
+	QuietMs   int    `json:"quiet_ms,omitempty"`
+	TimeoutMs int    `json:"timeout_ms,omitempty"`
+
+	// respawn_pane: Kill an active process first, start in StartDir, add
+	// Env overrides, and refresh update-environment from ClientEnv.
+	Kill      bool     `json:"kill,omitempty"`
+	StartDir  string   `json:"start_dir,omitempty"`
+	Env       []string `json:"env,omitempty"`
+	ClientEnv []string `json:"client_env,omitempty"`
+
+	// exec: run ShellCmd (with the respawn_pane fields above) in a
+	// temporary pane, or with InPane typed into the pane's shell, whose
+	// syntax Shell names: "posix", "cmd" or "powershell". wait_prompt
+	// matches the prompt of the shell Shell names.
+	InPane bool   `json:"in_pane,omitempty"`
+	Shell  string `json:"shell,omitempty"`
+
+	// select_pane: Mark makes the pane the marked pane, or clears the
+	// mark if it already is; Unmark clears it.
+	Mark   bool `json:"mark,omitempty"`
+	Unmark bool `json:"unmark,omitempty"`
+
+	// pipe_pane, pipe_add: write each line as cleaned text instead of raw
+	// output, prefix lines with a timestamp, and rotate the file once it
+	// would exceed RotateSize bytes, keeping Keep old files. With Events,
+	// pipe_add emits each line as a "pipe" event instead; with MirrorTo, it
+	// sends output to the session on that socket path as mirror_output
+	// requests carrying Data.
+	Clean      bool   `json:"clean,omitempty"`
+	Timestamps bool   `json:"timestamps,omitempty"`
+	RotateSize int64  `json:"rotate_size,omitempty"`
+	Keep       int    `json:"keep,omitempty"`
+	Events     bool   `json:"events,omitempty"`
+	MirrorTo   string `json:"mirror_to,omitempty"`
+
+	// Name identifies a checkpoint, watch, pipe, macro, scheduled
+	// command or redaction, or the variable show_environment shows.
+	Name string `json:"name,omitempty"`
+
+	// show_environment: the process in the pane to read, if not the
+	// pane process.
+	PID int `json:"pid,omitempty"`
+
+	// watch_add: regular expression, hook command and one-shot flag.
+	// redact_add: regular expression and the text its matches become.
+	Pattern string `json:"pattern,omitempty"`
+	Hook    string `json:"hook,omitempty"`
+	Once    bool   `json:"once,omitempty"`
+	Replace string `json:"replace,omitempty"`
+
+	// schedule_add: run the wintmux command Args against the session
+	// after DelayMs, then every IntervalMs if set, or whenever the
+	// five-field Cron schedule fires.
+	Args       []string `json:"args,omitempty"`
+	DelayMs    int64    `json:"delay_ms,omitempty"`
+	IntervalMs int64    `json:"interval_ms,omitempty"`
+	Cron       string   `json:"cron,omitempty"`
+
+	// wait_event: return events after sequence number Since (negative:
+	// only events from now on), optionally only of EventType.
+	Since     int64  `json:"since,omitempty"`
+	EventType string `json:"event_type,omitempty"`
+
+	// show_input_history / replay_input: event range and pacing.
+	// play_keys: Count is how many times to play the macro.
+	Start  int  `json:"start,omitempty"`
+	Count  int  `json:"count,omitempty"`
+	Timing bool `json:"timing,omitempty"`
+
+	// attach: the client's terminal size and color depth (truecolor,
+	// 256 or 16; empty for truecolor), whether to send the rows of the
+	// screen that changed rather than raw output, and raw input sent on
+	// an attached connection. mirror_output: output to show in the pane.
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Colors string `json:"colors,omitempty"`
+	Delta  bool   `json:"delta,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+
+	// TargetClient names the client acted on by lock/suspend actions;
+	// All applies the action to every client other than the sender.
+	TargetClient string `json:"target_client,omitempty"`
+	All          bool   `json:"all,omitempty"`
 }
 
 // Response is a JSON message sent from the session daemon back to the CLI client.
 type Response struct {
+	ID     int64  `json:"id,omitempty"`
 	OK     bool   `json:"ok"`
 	Error  string `json:"error,omitempty"`
 	Output string `json:"output,omitempty"`
 	Exists bool   `json:"exists,omitempty"`
+
+	// ExitCode is the exit status of the command an exec request ran.
+	ExitCode int `json:"exit_code,omitempty"`
+
+	// Progress marks an intermediate status frame, with the status in
+	// Output; the final response for the same request follows.
+	Progress bool `json:"progress,omitempty"`
+
+	// Set on events pushed by a broker to subscribed connections, and on
+	// output pushed to attached clients.
+	Event   bool   `json:"event,omitempty"`
+	Session string `json:"session,omitempty"`
+	Data    []byte `json:"data,omitempty"` // attach: raw pane output
 }
 
 const maxMessageSize = 10 * 1024 * 1024 // 10 MB
 
+// compressedFlag is set in the length prefix when the body is
+// DEFLATE-compressed. Readers that predate it see an oversized message,
+// so it is only sent to peers that asked for it with Request.Compress.
+const compressedFlag = 1 << 31
+
+// compressThreshold is the smallest body worth compressing.
+const compressThreshold = 64 * 1024
+
 // WriteMessage serializes v as JSON and writes it to w with a 4-byte
 // big-endian length prefix.
 func WriteMessage(w io.Writer, v interface{}) error {
+	return writeMessage(w, v, -1)
+}
+
+// WriteMessageCompressed is like WriteMessage but compresses bodies of
+// compressThreshold bytes or more, when that makes them smaller. Use it
+// only toward a peer that set Request.Compress.
+func WriteMessageCompressed(w io.Writer, v interface{}) error {
+	return writeMessage(w, v, compressThreshold)
+}
+
+// WriteMessageCompressedAbove is WriteMessageCompressed with min as the
+// smallest body worth compressing, for streams of many small messages
+// such as attach output.
+func WriteMessageCompressedAbove(w io.Writer, v interface{}, min int) error {
+	return writeMessage(w, v, min)
+}
+
+// writeMessage compresses bodies of min bytes or more; none if min < 0.
+func writeMessage(w io.Writer, v interface{}, min int) error {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
 
 	length := uint32(len(data))
-	header := [4]byte{
-		byte(length >> 24),
-		byte(length >> 16),
-		byte(length >> 8),
-		byte(length),
-	}
-
-	if _, err := w.Write(header[:]); err != nil {
-		return fmt.Errorf("write header: %w", err)
+	if min >= 0 && len(data) >= min {
+		if z, err := deflate(data); err == nil && len(z) < len(data) {
+			data = z
+			length = uint32(len(data)) | compressedFlag
+		}
 	}
-	if _, err := w.Write(data); err != nil {
-		return fmt.Errorf("write body: %w", err)
+	// One write: a header sent on its own can leave the body waiting for
+	// the peer's delayed ACK wherever Nagle's algorithm is on.
+	msg := make([]byte, 4+len(data))
+	msg[0] = byte(length >> 24)
+	msg[1] = byte(length >> 16)
+	msg[2] = byte(length >> 8)
+	msg[3] = byte(length)
+	copy(msg[4:], data)
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("write message: %w", err)
 	}
 	return nil
 }
 
+// ErrBadMessage marks a frame that was read in full but could not be
+// decoded. The connection is still in step, so a server can reply with an
+// error and carry on.
+var ErrBadMessage = errors.New("malformed message")
+
+// readChunk bounds each allocation while reading a body, so a header
+// that claims a large message costs memory only as the data arrives.
+const readChunk = 64 * 1024
+
 // ReadMessage reads a length-prefixed JSON message from r and unmarshals
 // it into v.
 func ReadMessage(r io.Reader, v interface{}) error {
+	length, compressed, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	return readBody(r, length, compressed, v)
+}
+
+// ReadMessageDeadline is ReadMessage on a connection: the message may take
+// up to idle to start (forever if idle is zero), after which the rest must
+// arrive within FrameTimeout. A peer that sends a header and then stalls
+// cannot hold the reader for the whole idle period.
+func ReadMessageDeadline(conn net.Conn, v interface{}, idle time.Duration) error {
+	var deadline time.Time
+	if idle > 0 {
+		deadline = time.Now().Add(idle)
+	}
+	conn.SetReadDeadline(deadline)
+	length, compressed, err := readHeader(conn)
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(FrameTimeout))
+	return readBody(conn, length, compressed, v)
+}
+
+// FrameTimeout is how long ReadMessageDeadline waits for a message body.
+const FrameTimeout = 10 * time.Second
+
+func readHeader(r io.Reader) (uint32, bool, error) {
 	var header [4]byte
 	if _, err := io.ReadFull(r, header[:]); err != nil {
-		return fmt.Errorf("read header: %w", err)
+		return 0, false, fmt.Errorf("read header: %w", err)
 	}
 
 	length := uint32(header[0])<<24 | uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+	compressed := length&compressedFlag != 0
+	length &^= compressedFlag
 	if length > maxMessageSize {
-		return fmt.Errorf("message too large: %d bytes (max %d)", length, maxMessageSize)
+		return 0, false, fmt.Errorf("message too large: %d bytes (max %d)", length, maxMessageSize)
 	}
+	return length, compressed, nil
+}
 
-	data := make([]byte, length)
-	if _, err := io.ReadFull(r, data); err != nil {
+func readBody(r io.Reader, length uint32, compressed bool, v interface{}) error {
+	var buf bytes.Buffer
+	buf.Grow(min(int(length), readChunk))
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return fmt.Errorf("read body: %w", err)
 	}
+	data := buf.Bytes()
+	if compressed {
+		var err error
+		if data, err = inflate(data); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadMessage, err)
+		}
+	}
 
+	if err := checkNesting(data); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadMessage, err)
+	}
 	if err := json.Unmarshal(data, v); err != nil {
-		return fmt.Errorf("unmarshal: %w", err)
+		return fmt.Errorf("%w: unmarshal: %v", ErrBadMessage, err)
 	}
 	return nil
 }
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inflate decompresses a message body, enforcing the same size limit as
+// uncompressed messages.
+func inflate(data []byte) ([]byte, error) {
+	zr := flate.NewReader(bytes.NewReader(data))
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, maxMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress body: %w", err)
+	}
+	if len(out) > maxMessageSize {
+		return nil, fmt.Errorf("message too large: over %d bytes decompressed", maxMessageSize)
+	}
+	return out, nil
+}
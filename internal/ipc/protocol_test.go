@@ -2,6 +2,7 @@ package ipc
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -89,11 +90,50 @@ func TestRoundTripAllActions(t *testing.T) {
 	actions := []Action{
 		ActionSendKeys,
 		ActionSendKey,
+		ActionSendText,
 		ActionCapture,
 		ActionHasSession,
 		ActionKillSession,
 		ActionSetOption,
 		ActionPipePane,
+		ActionPipeAdd,
+		ActionPipeList,
+		ActionPipeRemove,
+		ActionCaptureAll,
+		ActionMirrorOutput,
+		ActionDisplay,
+		ActionWaitStable,
+		ActionListClients,
+		ActionLockClient,
+		ActionUnlockClient,
+		ActionSuspendClient,
+		ActionServerAccess,
+		ActionListProcesses,
+		ActionRespawn,
+		ActionInputHistory,
+		ActionReplayInput,
+		ActionCheckpoint,
+		ActionDiffCheckpoint,
+		ActionWatchAdd,
+		ActionWatchList,
+		ActionWatchRemove,
+		ActionWaitEvent,
+		ActionScheduleAdd,
+		ActionScheduleList,
+		ActionScheduleRemove,
+		ActionRedactAdd,
+		ActionRedactList,
+		ActionRedactRemove,
+		ActionSubscribe,
+		ActionBrokerSessions,
+		ActionAttach,
+		ActionBridge,
+		ActionExec,
+		ActionListCommands,
+		ActionListLinks,
+		ActionShowExits,
+		ActionRecordKeys,
+		ActionPlayKeys,
 		ActionPing,
 	}
 
@@ -184,3 +224,121 @@ func TestCaptureRequest(t *testing.T) {
 		t.Error("expected join=true")
 	}
 }
+
+func TestRoundTripRawData(t *testing.T) {
+	// Attach carries raw terminal bytes, which need not be valid UTF-8.
+	data := []byte{0x1b, '[', 'A', 0xff, 0x00, 0xc3}
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, Response{OK: true, Event: true, Data: data}); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	var got Response
+	if err := ReadMessage(&buf, &got); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if !bytes.Equal(got.Data, data) {
+		t.Errorf("data changed in transit: %q, want %q", got.Data, data)
+	}
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	big := strings.Repeat("PS C:\\work> dir\r\n", 20000)
+
+	var buf bytes.Buffer
+	if err := WriteMessageCompressed(&buf, Response{OK: true, Output: big}); err != nil {
+		t.Fatalf("WriteMessageCompressed: %v", err)
+	}
+	if buf.Bytes()[0]&0x80 == 0 {
+		t.Fatal("expected the compressed flag in the length prefix")
+	}
+	if buf.Len() >= len(big) {
+		t.Errorf("compressed frame is %d bytes for %d bytes of output", buf.Len(), len(big))
+	}
+	var got Response
+	if err := ReadMessage(&buf, &got); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got.Output != big {
+		t.Error("output changed in transit")
+	}
+}
+
+func TestCompressedAbove(t *testing.T) {
+	var buf bytes.Buffer
+	text := strings.Repeat("$ make\r\n", 200)
+	if err := WriteMessageCompressedAbove(&buf, Response{OK: true, Output: text}, 1024); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Bytes()[0]&0x80 == 0 {
+		t.Fatal("expected the compressed flag in the length prefix")
+	}
+	var got Response
+	if err := ReadMessage(&buf, &got); err != nil || got.Output != text {
+		t.Errorf("round trip: %v %q", err, got.Output)
+	}
+	buf.Reset()
+	WriteMessageCompressedAbove(&buf, Response{OK: true, Output: text}, 64*1024)
+	if buf.Bytes()[0]&0x80 != 0 {
+		t.Error("message under the threshold was compressed")
+	}
+}
+
+func TestCompressedSmallMessageSentPlain(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMessageCompressed(&buf, Response{OK: true, Output: "short"}); err != nil {
+		t.Fatalf("WriteMessageCompressed: %v", err)
+	}
+	if buf.Bytes()[0]&0x80 != 0 {
+		t.Error("small message should not be compressed")
+	}
+}
+
+func TestCompressedSizeLimit(t *testing.T) {
+	// A small compressed body must not expand past the message limit.
+	z, err := deflate(bytes.Repeat([]byte{' '}, maxMessageSize+1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n := uint32(len(z)) | compressedFlag
+	buf.Write([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	buf.Write(z)
+	var got Response
+	if err := ReadMessage(&buf, &got); err == nil {
+		t.Error("expected an error for an oversized decompressed message")
+	}
+}
+
+func FuzzReadMessage(f *testing.F) {
+	for _, v := range []interface{}{
+		Request{Action: ActionPing},
+		Request{Action: ActionSendKeys, Text: "echo hi\r", Client: "pid:1", Env: []string{"A=1"}},
+		Request{Action: ActionAttach, Width: 80, Height: 24, Data: []byte{0x1b, 0xff}},
+		Response{OK: true, Event: true, Output: strings.Repeat("line\r\n", 20000)},
+	} {
+		var buf bytes.Buffer
+		WriteMessageCompressed(&buf, v)
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{0, 0, 0, 2, '{', '}'})
+	f.Add([]byte{0x80, 0, 0, 3, 1, 2, 3})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		var req Request
+		if err := ReadMessage(bytes.NewReader(frame), &req); err != nil {
+			return
+		}
+		// Anything accepted must validate or fail cleanly, and survive
+		// a round trip.
+		req.Validate()
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, req); err != nil {
+			t.Fatalf("re-encode: %v", err)
+		}
+		var again Request
+		if err := ReadMessage(&buf, &again); err != nil {
+			t.Fatalf("re-decode: %v", err)
+		}
+	})
+}
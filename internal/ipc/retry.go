@@ -0,0 +1,163 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy describes how an operation is retried: exponential backoff
+// from Initial up to Max, with each delay randomized by ±Jitter (a
+// fraction) so that many clients retrying at once spread out. Retrying
+// stops after Attempts calls or once Timeout has passed, whichever comes
+// first; a zero field means no limit of that kind.
+type RetryPolicy struct {
+	Attempts int
+	Timeout  time.Duration
+	Initial  time.Duration
+	Max      time.Duration
+	Jitter   float64
+}
+
+// DefaultRetry is used for connecting to daemons. It rides out a daemon
+// that is starting or respawning without delaying failures much.
+// WINTMUX_RETRIES overrides the number of attempts; 1 disables retrying.
+var DefaultRetry = RetryPolicy{
+	Attempts: retriesFromEnv(4),
+	Initial:  100 * time.Millisecond,
+	Max:      time.Second,
+	Jitter:   0.2,
+}
+
+func retriesFromEnv(def int) int {
+	if n, err := strconv.Atoi(os.Getenv("WINTMUX_RETRIES")); err == nil && n >= 1 {
+		return n
+	}
+	return def
+}
+
+// RetryError is returned when a policy gives up; Err is the last failure.
+type RetryError struct {
+	Attempts int
+	Elapsed  time.Duration
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("gave up after %d attempts in %v: %v", e.Attempts, e.Elapsed.Round(time.Millisecond), e.Err)
+}
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying; Do returns it (unwrapped)
+// at once.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Do calls op until it returns nil or a Permanent error, or the policy
+// runs out, sleeping between calls. It returns nil, the permanent error,
+// or a *RetryError.
+func (p RetryPolicy) Do(op func() error) error {
+	start := time.Now()
+	delay := p.Initial
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		elapsed := time.Since(start)
+		if (p.Attempts > 0 && attempt >= p.Attempts) || (p.Timeout > 0 && elapsed >= p.Timeout) {
+			return &RetryError{Attempts: attempt, Elapsed: elapsed, Err: err}
+		}
+
+		sleep := p.jitter(delay)
+		if p.Timeout > 0 {
+			sleep = min(sleep, p.Timeout-elapsed)
+		}
+		time.Sleep(sleep)
+		delay *= 2
+		if p.Max > 0 && delay > p.Max {
+			delay = p.Max
+		}
+	}
+}
+
+func (p RetryPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	f := 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(float64(d) * f)
+}
+
+// ErrCircuitOpen is returned without trying to connect while a socket's
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// breakerCooldown is how long a socket is failed fast after a connect
+// policy gave up on it. Long-lived callers such as the broker otherwise
+// pay the full backoff on every request to a dead session.
+const breakerCooldown = 5 * time.Second
+
+var breaker = struct {
+	sync.Mutex
+	open map[string]time.Time // socket path -> when it may be tried again
+}{open: make(map[string]time.Time)}
+
+// ConnectRetry is Connect with retries under policy for transient
+// failures: a daemon still starting, or a refused or timed-out dial while
+// the daemon process is alive. A missing control file or a dead daemon
+// fails at once. After the policy gives up, further calls for the same
+// socket fail fast with ErrCircuitOpen for a short cooldown.
+func ConnectRetry(socketPath string, policy RetryPolicy) (net.Conn, error) {
+	breaker.Lock()
+	until, open := breaker.open[socketPath]
+	breaker.Unlock()
+	if open && time.Now().Before(until) {
+		return nil, fmt.Errorf("%w for %s: daemon unreachable, retrying after %v", ErrCircuitOpen, socketPath, time.Until(until).Round(time.Millisecond))
+	}
+
+	var conn net.Conn
+	err := policy.Do(func() error {
+		c, err := Connect(socketPath)
+		if err != nil {
+			if !transient(err) {
+				return Permanent(err)
+			}
+			return err
+		}
+		conn = c
+		return nil
+	})
+
+	breaker.Lock()
+	defer breaker.Unlock()
+	var re *RetryError
+	if errors.As(err, &re) {
+		breaker.open[socketPath] = time.Now().Add(breakerCooldown)
+	} else {
+		delete(breaker.open, socketPath)
+	}
+	return conn, err
+}
+
+// transient reports whether a Connect error may clear up by itself.
+func transient(err error) bool {
+	var op *net.OpError
+	return errors.Is(err, errStarting) || errors.As(err, &op)
+}
@@ -0,0 +1,144 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryAttempts(t *testing.T) {
+	calls := 0
+	p := RetryPolicy{Attempts: 3, Initial: time.Millisecond}
+	err := p.Do(func() error {
+		calls++
+		return errors.New("refused")
+	})
+	var re *RetryError
+	if !errors.As(err, &re) || re.Attempts != 3 || calls != 3 {
+		t.Fatalf("expected RetryError after 3 calls, got %v (%d calls)", err, calls)
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	calls := 0
+	p := RetryPolicy{Attempts: 5, Initial: time.Millisecond}
+	err := p.Do(func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("refused")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success on third call, got %v after %d", err, calls)
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	calls := 0
+	want := errors.New("not found")
+	err := RetryPolicy{Attempts: 5, Initial: time.Millisecond}.Do(func() error {
+		calls++
+		return Permanent(want)
+	})
+	if err != want || calls != 1 {
+		t.Fatalf("expected the permanent error at once, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryTimeoutAndBackoff(t *testing.T) {
+	var times []time.Time
+	p := RetryPolicy{Timeout: 200 * time.Millisecond, Initial: 10 * time.Millisecond, Max: 40 * time.Millisecond}
+	start := time.Now()
+	err := p.Do(func() error {
+		times = append(times, time.Now())
+		return errors.New("refused")
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout not honoured: took %v", elapsed)
+	}
+	var re *RetryError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected RetryError, got %v", err)
+	}
+	if len(times) < 4 {
+		t.Fatalf("expected several attempts, got %d", len(times))
+	}
+	if gap := times[3].Sub(times[2]); gap < 35*time.Millisecond {
+		t.Errorf("expected backoff to reach the 40ms cap, third gap was %v", gap)
+	}
+}
+
+func writeControl(t *testing.T, path string, info ControlInfo) {
+	t.Helper()
+	data, _ := json.Marshal(info)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectRetryPermanentFailures(t *testing.T) {
+	dir := t.TempDir()
+	p := RetryPolicy{Attempts: 10, Initial: 50 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := ConnectRetry(filepath.Join(dir, "missing.sock"), p); err == nil {
+		t.Error("expected error for missing control file")
+	}
+	// A stale control file: nothing listens on the port and no such
+	// process exists.
+	stale := filepath.Join(dir, "stale.sock")
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	writeControl(t, stale, ControlInfo{Port: port, PID: 1 << 30})
+	if _, err := ConnectRetry(stale, p); err == nil {
+		t.Error("expected error for stale control file")
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("permanent failures were retried: took %v", elapsed)
+	}
+}
+
+func TestConnectRetryWaitsForStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+	writeControl(t, path, ControlInfo{PID: os.Getpid(), State: "starting"})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		writeControl(t, path, ControlInfo{Port: ln.Addr().(*net.TCPAddr).Port, PID: os.Getpid(), State: "ready"})
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	conn, err := ConnectRetry(path, RetryPolicy{Attempts: 20, Initial: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("expected to connect once the daemon was ready: %v", err)
+	}
+	conn.Close()
+}
+
+func TestConnectRetryCircuitBreaker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+	writeControl(t, path, ControlInfo{PID: os.Getpid(), State: "starting"})
+
+	p := RetryPolicy{Attempts: 2, Initial: time.Millisecond}
+	_, err := ConnectRetry(path, p)
+	var re *RetryError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected RetryError, got %v", err)
+	}
+	if _, err := ConnectRetry(path, p); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// TCPOptions are the socket settings of IPC connections. Go already
+// turns Nagle's algorithm off on TCP connections, and every message goes
+// out in a single write, so a request is never held back waiting for the
+// peer's delayed ACK; the options make that explicit and let it, and the
+// buffer sizes, be changed to compare with bench on a given machine.
+type TCPOptions struct {
+	NoDelay     bool // TCP_NODELAY: send small writes at once
+	ReadBuffer  int  // SO_RCVBUF in bytes; 0 keeps the system default
+	WriteBuffer int  // SO_SNDBUF in bytes; 0 keeps the system default
+}
+
+// DefaultTCP is applied to the connections the CLI, daemon and broker
+// make and accept. WINTMUX_TCP_NODELAY=off turns Nagle's algorithm back
+// on; WINTMUX_TCP_BUFFER sets both buffer sizes, in bytes. A daemon
+// inherits them from the client that starts it.
+var DefaultTCP = tcpOptionsFromEnv()
+
+func tcpOptionsFromEnv() TCPOptions {
+	o := TCPOptions{NoDelay: true}
+	switch os.Getenv("WINTMUX_TCP_NODELAY") {
+	case "off", "0", "false":
+		o.NoDelay = false
+	}
+	if n, err := strconv.Atoi(os.Getenv("WINTMUX_TCP_BUFFER")); err == nil && n > 0 {
+		o.ReadBuffer, o.WriteBuffer = n, n
+	}
+	return o
+}
+
+// Tune applies o to conn if it is a TCP connection.
+func (o TCPOptions) Tune(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tc.SetNoDelay(o.NoDelay); err != nil {
+		return err
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String describes o as bench reports it.
+func (o TCPOptions) String() string {
+	nodelay := "on"
+	if !o.NoDelay {
+		nodelay = "off"
+	}
+	buffers := "system default"
+	if o.ReadBuffer > 0 || o.WriteBuffer > 0 {
+		buffers = fmt.Sprintf("read %d, write %d bytes", o.ReadBuffer, o.WriteBuffer)
+	}
+	return "nodelay " + nodelay + ", buffers " + buffers
+}
@@ -0,0 +1,84 @@
+package ipc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestTCPOptionsFromEnv(t *testing.T) {
+	t.Setenv("WINTMUX_TCP_NODELAY", "")
+	t.Setenv("WINTMUX_TCP_BUFFER", "")
+	if o := tcpOptionsFromEnv(); !o.NoDelay || o.ReadBuffer != 0 || o.String() != "nodelay on, buffers system default" {
+		t.Fatalf("default: %+v (%s)", o, o)
+	}
+
+	t.Setenv("WINTMUX_TCP_NODELAY", "off")
+	t.Setenv("WINTMUX_TCP_BUFFER", "65536")
+	o := tcpOptionsFromEnv()
+	if o.NoDelay || o.ReadBuffer != 65536 || o.WriteBuffer != 65536 {
+		t.Fatalf("from env: %+v", o)
+	}
+	if o.String() != "nodelay off, buffers read 65536, write 65536 bytes" {
+		t.Fatalf("String: %q", o)
+	}
+
+	t.Setenv("WINTMUX_TCP_BUFFER", "lots")
+	if o := tcpOptionsFromEnv(); o.ReadBuffer != 0 {
+		t.Fatalf("invalid buffer size used: %+v", o)
+	}
+}
+
+func TestTune(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	o := TCPOptions{NoDelay: false, ReadBuffer: 32768, WriteBuffer: 32768}
+	if err := o.Tune(conn); err != nil {
+		t.Fatalf("Tune: %v", err)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if err := o.Tune(a); err != nil {
+		t.Fatalf("Tune on a pipe: %v", err)
+	}
+}
+
+// countingWriter counts the writes it is given.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestWriteMessageSingleWrite(t *testing.T) {
+	var w countingWriter
+	if err := WriteMessage(&w, Request{Action: ActionSendKeys, Text: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Fatalf("message written in %d writes, want 1", w.writes)
+	}
+	var req Request
+	if err := ReadMessage(&w, &req); err != nil || req.Action != ActionSendKeys {
+		t.Fatalf("read back %+v, %v", req, err)
+	}
+}
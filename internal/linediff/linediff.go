@@ -0,0 +1,159 @@
+// Package linediff computes line-oriented differences between two
+// captures of a pane.
+package linediff
+
+import "fmt"
+
+// Op is the kind of an Edit.
+type Op byte
+
+const (
+	Equal  Op = ' '
+	Delete Op = '-'
+	Insert Op = '+'
+)
+
+// Edit is one line of a diff.
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// maxCells bounds the LCS table. Beyond it the differing middle of the
+// inputs is reported as a plain delete-all/insert-all, which is still
+// correct, just not minimal.
+const maxCells = 4 << 20
+
+// Diff returns the edits turning a into b.
+func Diff(a, b []string) []Edit {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var edits []Edit
+	for _, l := range a[:pre] {
+		edits = append(edits, Edit{Equal, l})
+	}
+	edits = append(edits, middle(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		edits = append(edits, Edit{Equal, l})
+	}
+	return edits
+}
+
+// middle diffs the inputs with a longest-common-subsequence table.
+func middle(a, b []string) []Edit {
+	var edits []Edit
+	if (len(a)+1)*(len(b)+1) > maxCells {
+		for _, l := range a {
+			edits = append(edits, Edit{Delete, l})
+		}
+		for _, l := range b {
+			edits = append(edits, Edit{Insert, l})
+		}
+		return edits
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, Edit{Equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, Edit{Delete, a[i]})
+			i++
+		default:
+			edits = append(edits, Edit{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, Edit{Delete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, Edit{Insert, b[j]})
+	}
+	return edits
+}
+
+// Unified renders edits as unified-diff hunks with the given number of
+// context lines. It returns nil if there are no changes.
+func Unified(edits []Edit, context int) []string {
+	var out []string
+	aLine, bLine := 1, 1
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			i++
+			aLine++
+			bLine++
+			continue
+		}
+		// Extend the hunk while changes are closer than 2*context apart.
+		start := max(i-context, 0)
+		end := i
+		for end < len(edits) {
+			if edits[end].Op != Equal {
+				end++
+				continue
+			}
+			gap := end
+			for gap < len(edits) && edits[gap].Op == Equal {
+				gap++
+			}
+			if gap == len(edits) || gap-end > 2*context {
+				end = min(end+context, len(edits))
+				break
+			}
+			end = gap
+		}
+
+		lead := i - start
+		aStart, bStart := aLine-lead, bLine-lead
+		aCount, bCount := 0, 0
+		var body []string
+		for _, e := range edits[start:end] {
+			body = append(body, string(e.Op)+e.Line)
+			if e.Op != Insert {
+				aCount++
+			}
+			if e.Op != Delete {
+				bCount++
+			}
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", aStart, aCount, bStart, bCount))
+		out = append(out, body...)
+
+		for _, e := range edits[i:end] {
+			if e.Op != Insert {
+				aLine++
+			}
+			if e.Op != Delete {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package linediff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ops(edits []Edit) string {
+	var sb strings.Builder
+	for _, e := range edits {
+		sb.WriteByte(byte(e.Op))
+	}
+	return sb.String()
+}
+
+func TestDiff(t *testing.T) {
+	a := []string{"$ make", "building", "ok", "$"}
+	b := []string{"$ make", "building", "FAILED", "exit 2", "$"}
+	got := ops(Diff(a, b))
+	if got != "  -++ " {
+		t.Errorf("ops %q", got)
+	}
+}
+
+func TestDiffIdentical(t *testing.T) {
+	a := []string{"x", "y"}
+	if got := Unified(Diff(a, a), 3); got != nil {
+		t.Errorf("expected no hunks, got %v", got)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}
+	b := []string{"1", "two", "3", "4", "5", "6", "7", "8", "9", "10", "11"}
+	got := Unified(Diff(a, b), 1)
+	want := []string{
+		"@@ -1,3 +1,3 @@", " 1", "-2", "+two", " 3",
+		"@@ -10,1 +10,2 @@", " 10", "+11",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestUnifiedMergesCloseHunks(t *testing.T) {
+	a := []string{"a", "b", "c", "d"}
+	b := []string{"A", "b", "c", "D"}
+	got := Unified(Diff(a, b), 1)
+	if len(got) == 0 || got[0] != "@@ -1,4 +1,4 @@" {
+		t.Errorf("expected one hunk, got %q", got)
+	}
+}
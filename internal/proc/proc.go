@@ -0,0 +1,121 @@
+// Package proc enumerates operating-system processes so the daemon can
+// report the process tree running under a pane.
+package proc
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Process is a snapshot of one running process.
+type Process struct {
+	PID     int
+	PPID    int
+	Name    string
+	CPU     time.Duration // user + kernel time consumed so far
+	Started time.Time     // creation time; zero if it cannot be read
+}
+
+// Usage is the resources one process holds at a moment.
+type Usage struct {
+	CPU     time.Duration // user + kernel time consumed so far
+	Memory  uint64        // resident set (working set on Windows), in bytes
+	Handles int           // open handles; file descriptors off Windows
+}
+
+// Node is a process within a tree, with its depth below the root.
+type Node struct {
+	Process
+	Depth int
+}
+
+// List returns a snapshot of all processes visible to the caller.
+func List() ([]Process, error) {
+	return list()
+}
+
+// Cwd returns the current working directory of pid, where the platform
+// allows reading it from outside the process.
+func Cwd(pid int) (string, error) {
+	return cwd(pid)
+}
+
+// ResourceUsage returns the resources pid holds, from its process handle
+// on Windows and /proc on Linux.
+func ResourceUsage(pid int) (Usage, error) {
+	return usage(pid)
+}
+
+// Environment returns the environment of pid as NAME=value entries: on
+// Windows read from its process environment block, so variables it has
+// set since it started are included; on Linux the environment it was
+// started with. Windows' hidden per-drive directory entries (=C:=C:\)
+// are left out.
+func Environment(pid int) ([]string, error) {
+	return environment(pid)
+}
+
+// splitEnvBlock splits an environment block of NUL-terminated entries,
+// which ends at an empty entry, leaving out entries without a name.
+func splitEnvBlock(block string) []string {
+	var env []string
+	for _, e := range strings.Split(block, "\x00") {
+		if e == "" {
+			break
+		}
+		if !strings.HasPrefix(e, "=") {
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+// Alive reports whether pid is a running process. A process that has
+// exited but not yet been reaped by its parent counts as not alive.
+func Alive(pid int) bool {
+	return alive(pid)
+}
+
+// Tree returns root and all of its descendants in depth-first order,
+// children sorted by PID. If root is not in procs, Tree returns nil.
+// A process known to have started before the process now holding its
+// parent's PID outlived its parent, whose PID was reused, and is left
+// out.
+func Tree(procs []Process, root int) []Node {
+	byPID := make(map[int]Process, len(procs))
+	for _, p := range procs {
+		byPID[p.PID] = p
+	}
+	children := make(map[int][]int)
+	for _, p := range procs {
+		parent, ok := byPID[p.PPID]
+		if p.PPID == p.PID || ok && !p.Started.IsZero() && p.Started.Before(parent.Started) {
+			continue
+		}
+		children[p.PPID] = append(children[p.PPID], p.PID)
+	}
+	rp, ok := byPID[root]
+	if !ok {
+		return nil
+	}
+
+	var out []Node
+	seen := make(map[int]bool)
+	var walk func(p Process, depth int)
+	walk = func(p Process, depth int) {
+		// Windows recycles PIDs, so a stale PPID can form a cycle.
+		if seen[p.PID] {
+			return
+		}
+		seen[p.PID] = true
+		out = append(out, Node{Process: p, Depth: depth})
+		kids := children[p.PID]
+		sort.Ints(kids)
+		for _, k := range kids {
+			walk(byPID[k], depth+1)
+		}
+	}
+	walk(rp, 0)
+	return out
+}
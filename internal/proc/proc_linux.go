@@ -0,0 +1,139 @@
+//go:build linux
+
+package proc
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every mainstream Linux platform.
+const clockTicks = 100
+
+func list() ([]Process, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	boot := bootTime()
+	var procs []Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + e.Name() + "/stat")
+		if err != nil {
+			continue // process exited while we were scanning
+		}
+		if p, ok := parseStat(pid, string(data), boot); ok {
+			procs = append(procs, p)
+		}
+	}
+	return procs, nil
+}
+
+func cwd(pid int) (string, error) {
+	return os.Readlink("/proc/" + strconv.Itoa(pid) + "/cwd")
+}
+
+func usage(pid int) (Usage, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	stat, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return Usage{}, err
+	}
+	p, ok := parseStat(pid, string(stat), time.Time{})
+	if !ok {
+		return Usage{}, errors.New("malformed " + dir + "/stat")
+	}
+	u := Usage{CPU: p.CPU}
+	// statm counts pages; resident is the second field.
+	if statm, err := os.ReadFile(dir + "/statm"); err == nil {
+		if f := strings.Fields(string(statm)); len(f) > 1 {
+			pages, _ := strconv.ParseUint(f[1], 10, 64)
+			u.Memory = pages * uint64(os.Getpagesize())
+		}
+	}
+	if fds, err := os.ReadDir(dir + "/fd"); err == nil {
+		u.Handles = len(fds)
+	}
+	return u, nil
+}
+
+// parseStat extracts name, ppid, CPU time and, given the boot time, the
+// start time from /proc/<pid>/stat. The command name is parenthesised
+// and may itself contain spaces or ')'.
+func parseStat(pid int, stat string, boot time.Time) (Process, bool) {
+	lp := strings.IndexByte(stat, '(')
+	rp := strings.LastIndexByte(stat, ')')
+	if lp < 0 || rp < lp {
+		return Process{}, false
+	}
+	fields := strings.Fields(stat[rp+1:])
+	// fields[0] is state; ppid is [1], utime [11], stime [12], and
+	// starttime [19], in ticks since boot.
+	if len(fields) < 13 {
+		return Process{}, false
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	p := Process{
+		PID:  pid,
+		PPID: ppid,
+		Name: stat[lp+1 : rp],
+		CPU:  time.Duration(utime+stime) * time.Second / clockTicks,
+	}
+	if len(fields) > 19 && !boot.IsZero() {
+		start, _ := strconv.ParseInt(fields[19], 10, 64)
+		p.Started = boot.Add(time.Duration(start) * time.Second / clockTicks)
+	}
+	return p, true
+}
+
+// bootTime reads when the system booted from the btime line of
+// /proc/stat, or returns zero.
+func bootTime() time.Time {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "btime "); ok {
+			if sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return time.Unix(sec, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func alive(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesised command name, which may itself
+	// contain spaces or parentheses.
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 || i+2 >= len(stat) {
+		return false
+	}
+	state := stat[i+2]
+	return state != 'Z' && state != 'X'
+}
+
+// environment reads /proc/<pid>/environ, which holds the environment
+// the process was started with: Linux does not track later changes.
+func environment(pid int) ([]string, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/environ")
+	if err != nil {
+		return nil, err
+	}
+	return splitEnvBlock(string(data)), nil
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestParseStat(t *testing.T) {
+	stat := "1234 (my (odd) prog) S 99 1234 1234 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 1 0 100 0 0"
+	boot := time.Unix(1700000000, 0)
+	p, ok := parseStat(1234, stat, boot)
+	if !ok {
+		t.Fatal("parseStat failed")
+	}
+	if p.Name != "my (odd) prog" || p.PPID != 99 {
+		t.Errorf("got %+v", p)
+	}
+	if p.CPU != 3*time.Second {
+		t.Errorf("CPU = %v, want 3s", p.CPU)
+	}
+	if !p.Started.Equal(boot.Add(time.Second)) {
+		t.Errorf("Started = %v, want a second after boot", p.Started)
+	}
+}
+
+func TestListIncludesSelf(t *testing.T) {
+	procs, err := List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	nodes := Tree(procs, os.Getpid())
+	if len(nodes) == 0 {
+		t.Fatal("own process not found")
+	}
+	if age := time.Since(nodes[0].Started); age < 0 || age > time.Hour {
+		t.Errorf("own process started %v ago", age)
+	}
+}
+
+func TestCwdSelf(t *testing.T) {
+	want, _ := os.Getwd()
+	got, err := Cwd(os.Getpid())
+	if err != nil {
+		t.Fatalf("Cwd: %v", err)
+	}
+	if got != want {
+		t.Errorf("Cwd = %q, want %q", got, want)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	if !Alive(os.Getpid()) {
+		t.Error("own process reported dead")
+	}
+	// An exited but unreaped child is a zombie, which is not alive.
+	cmd := exec.Command("true")
+	if err := cmd.Start(); err != nil {
+		t.Skip("cannot start true:", err)
+	}
+	defer cmd.Wait()
+	deadline := time.Now().Add(2 * time.Second)
+	for Alive(cmd.Process.Pid) {
+		if time.Now().After(deadline) {
+			t.Fatal("exited child still reported alive")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestResourceUsageSelf(t *testing.T) {
+	f, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	u, err := ResourceUsage(os.Getpid())
+	if err != nil {
+		t.Fatalf("ResourceUsage: %v", err)
+	}
+	if u.Memory == 0 || u.Handles == 0 {
+		t.Errorf("usage = %+v", u)
+	}
+	if _, err := ResourceUsage(-1); err == nil {
+		t.Error("expected error for a missing process")
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	cmd.Env = []string{"WINTMUX_TEST=a=b", "PATH=" + os.Getenv("PATH")}
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer cmd.Process.Kill()
+	env, err := Environment(cmd.Process.Pid)
+	if err != nil {
+		t.Fatalf("Environment: %v", err)
+	}
+	if len(env) != 2 || env[0] != "WINTMUX_TEST=a=b" {
+		t.Errorf("env = %q", env)
+	}
+}
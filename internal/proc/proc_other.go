@@ -0,0 +1,29 @@
+//go:build !windows && !linux
+
+package proc
+
+import (
+	"errors"
+	"syscall"
+)
+
+func list() ([]Process, error) {
+	return nil, errors.New("process listing not supported on this platform")
+}
+
+func usage(pid int) (Usage, error) {
+	return Usage{}, errors.New("resource usage not available on this platform")
+}
+
+func cwd(pid int) (string, error) {
+	return "", errors.New("process cwd not available on this platform")
+}
+
+// alive cannot tell zombies apart here; signal 0 only checks existence.
+func alive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+func environment(pid int) ([]string, error) {
+	return nil, errors.New("process environment not available on this platform")
+}
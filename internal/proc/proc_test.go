@@ -0,0 +1,66 @@
+package proc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTree(t *testing.T) {
+	procs := []Process{
+		{PID: 1, PPID: 0, Name: "init"},
+		{PID: 10, PPID: 1, Name: "daemon"},
+		{PID: 20, PPID: 10, Name: "pwsh"},
+		{PID: 31, PPID: 20, Name: "node"},
+		{PID: 30, PPID: 20, Name: "git"},
+		{PID: 40, PPID: 31, Name: "rg"},
+		{PID: 50, PPID: 1, Name: "other"},
+	}
+	got := Tree(procs, 20)
+	want := []struct {
+		pid, depth int
+	}{{20, 0}, {30, 1}, {31, 1}, {40, 2}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d nodes, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].PID != w.pid || got[i].Depth != w.depth {
+			t.Errorf("node %d = pid %d depth %d, want pid %d depth %d", i, got[i].PID, got[i].Depth, w.pid, w.depth)
+		}
+	}
+}
+
+func TestTreeMissingRoot(t *testing.T) {
+	if got := Tree([]Process{{PID: 1}}, 99); got != nil {
+		t.Errorf("expected nil, got %+v", got)
+	}
+}
+
+func TestTreePIDReuse(t *testing.T) {
+	// 7 outlived its parent, whose PID 20 now belongs to a newer pwsh.
+	at := time.Unix(1700000000, 0)
+	procs := []Process{
+		{PID: 20, Name: "pwsh", Started: at},
+		{PID: 7, PPID: 20, Name: "orphan", Started: at.Add(-time.Minute)},
+		{PID: 8, PPID: 20, Name: "child", Started: at.Add(time.Minute)},
+		{PID: 9, PPID: 20, Name: "unknown"},
+	}
+	got := Tree(procs, 20)
+	if len(got) != 3 || got[1].PID != 8 || got[2].PID != 9 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestTreeCycle(t *testing.T) {
+	// A recycled PID can make two processes each other's parent.
+	procs := []Process{{PID: 5, PPID: 6}, {PID: 6, PPID: 5}}
+	if got := Tree(procs, 5); len(got) != 2 {
+		t.Errorf("expected 2 nodes, got %+v", got)
+	}
+}
+
+func TestSplitEnvBlock(t *testing.T) {
+	got := splitEnvBlock("=C:=C:\\src\x00Path=C:\\bin\x00A=\x00\x00junk\x00")
+	if len(got) != 2 || got[0] != `Path=C:\bin` || got[1] != "A=" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,260 @@
+//go:build windows
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+)
+
+const (
+	_PROCESS_QUERY_INFORMATION         = 0x0400
+	_PROCESS_VM_READ                   = 0x0010
+	_PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+	_STILL_ACTIVE                      = 259
+)
+
+var (
+	kernel32                      = syscall.NewLazyDLL("kernel32.dll")
+	procGetProcessMemoryInfo      = kernel32.NewProc("K32GetProcessMemoryInfo")
+	procGetProcessHandleCount     = kernel32.NewProc("GetProcessHandleCount")
+	procReadProcessMemory         = kernel32.NewProc("ReadProcessMemory")
+	ntdll                         = syscall.NewLazyDLL("ntdll.dll")
+	procNtQueryInformationProcess = ntdll.NewProc("NtQueryInformationProcess")
+)
+
+// processMemoryCounters mirrors PROCESS_MEMORY_COUNTERS.
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+func list() ([]Process, error) {
+	snap, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(snap)
+
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	if err := syscall.Process32First(snap, &entry); err != nil {
+		return nil, err
+	}
+
+	var procs []Process
+	for {
+		cpu, started := times(entry.ProcessID)
+		procs = append(procs, Process{
+			PID:     int(entry.ProcessID),
+			PPID:    int(entry.ParentProcessID),
+			Name:    syscall.UTF16ToString(entry.ExeFile[:]),
+			CPU:     cpu,
+			Started: started,
+		})
+		if err := syscall.Process32Next(snap, &entry); err != nil {
+			break // ERROR_NO_MORE_FILES
+		}
+	}
+	return procs, nil
+}
+
+// times returns user+kernel time for pid and when it was created, or
+// zeros if the process cannot be opened (e.g. it belongs to another user
+// or has exited).
+func times(pid uint32) (time.Duration, time.Time) {
+	h, err := syscall.OpenProcess(_PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	defer syscall.CloseHandle(h)
+
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return 0, time.Time{}
+	}
+	// FILETIME durations are in 100ns units.
+	return time.Duration(filetimeTicks(kernel)+filetimeTicks(user)) * 100, time.Unix(0, creation.Nanoseconds())
+}
+
+func usage(pid int) (Usage, error) {
+	h, err := syscall.OpenProcess(_PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return Usage{}, err
+	}
+	defer syscall.CloseHandle(h)
+
+	var u Usage
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return Usage{}, err
+	}
+	u.CPU = time.Duration(filetimeTicks(kernel)+filetimeTicks(user)) * 100
+
+	var mem processMemoryCounters
+	mem.Cb = uint32(unsafe.Sizeof(mem))
+	if r, _, e := procGetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mem)), uintptr(mem.Cb)); r == 0 {
+		return Usage{}, e
+	}
+	u.Memory = uint64(mem.WorkingSetSize)
+
+	var handles uint32
+	if r, _, e := procGetProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&handles))); r == 0 {
+		return Usage{}, e
+	}
+	u.Handles = int(handles)
+	return u, nil
+}
+
+func filetimeTicks(ft syscall.Filetime) int64 {
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
+
+// cwd reads the current directory of pid from its process parameters,
+// as environment reads its environment.
+func cwd(pid int) (string, error) {
+	h, params, err := processParameters(pid)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+
+	// CurrentDirectory.DosPath is a UNICODE_STRING: a byte length, then
+	// the buffer pointer, aligned to a pointer.
+	var length uint16
+	var buf uintptr
+	if err := readMemory(h, params+paramsCurrentDirectory, unsafe.Pointer(&length), 2); err != nil {
+		return "", err
+	}
+	if err := readPointer(h, params+paramsCurrentDirectory+ptrSize, &buf); err != nil {
+		return "", err
+	}
+	if length == 0 || length%2 != 0 {
+		return "", fmt.Errorf("unexpected current directory length %d", length)
+	}
+	path := make([]uint16, length/2)
+	if err := readMemory(h, buf, unsafe.Pointer(&path[0]), uintptr(length)); err != nil {
+		return "", err
+	}
+	// Windows keeps a trailing backslash on all but a drive's root.
+	dir := string(utf16.Decode(path))
+	if len(dir) > 3 {
+		dir = strings.TrimSuffix(dir, `\`)
+	}
+	return dir, nil
+}
+
+func alive(pid int) bool {
+	h, err := syscall.OpenProcess(_PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == _STILL_ACTIVE
+}
+
+// processBasicInformation mirrors PROCESS_BASIC_INFORMATION.
+type processBasicInformation struct {
+	ExitStatus                   uintptr
+	PebBaseAddress               uintptr
+	AffinityMask                 uintptr
+	BasePriority                 uintptr
+	UniqueProcessID              uintptr
+	InheritedFromUniqueProcessID uintptr
+}
+
+const ptrSize = unsafe.Sizeof(uintptr(0))
+
+// Offsets of PEB.ProcessParameters and of the CurrentDirectory,
+// Environment and EnvironmentSize fields of RTL_USER_PROCESS_PARAMETERS,
+// for 64-bit processes and, set by init, 32-bit ones. The structures are
+// undocumented but have kept this layout since Vista.
+var pebProcessParameters, paramsCurrentDirectory, paramsEnvironment, paramsEnvironmentSize uintptr = 0x20, 0x38, 0x80, 0x3f0
+
+func init() {
+	if ptrSize == 4 {
+		pebProcessParameters, paramsCurrentDirectory, paramsEnvironment, paramsEnvironmentSize = 0x10, 0x24, 0x48, 0x290
+	}
+}
+
+// environment reads the environment block of pid through its PEB, as
+// debuggers and Process Explorer do. The block is the process's own, so
+// it reflects SetEnvironmentVariable calls made after it started. A
+// 32-bit process under WOW64 is read through its native PEB, which may
+// miss variables it set later.
+func environment(pid int) ([]string, error) {
+	h, params, err := processParameters(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(h)
+
+	var block, size uintptr
+	if err := readPointer(h, params+paramsEnvironment, &block); err != nil {
+		return nil, err
+	}
+	if err := readPointer(h, params+paramsEnvironmentSize, &size); err != nil {
+		return nil, err
+	}
+	if size == 0 || size > 1<<20 || size%2 != 0 {
+		return nil, fmt.Errorf("unexpected environment size %d", size)
+	}
+	buf := make([]uint16, size/2)
+	if err := readMemory(h, block, unsafe.Pointer(&buf[0]), size); err != nil {
+		return nil, err
+	}
+	return splitEnvBlock(string(utf16.Decode(buf))), nil
+}
+
+// processParameters opens pid for reading its memory and returns the
+// handle, which the caller closes, and the address of its
+// RTL_USER_PROCESS_PARAMETERS, found through its PEB.
+func processParameters(pid int) (syscall.Handle, uintptr, error) {
+	h, err := syscall.OpenProcess(_PROCESS_QUERY_INFORMATION|_PROCESS_VM_READ, false, uint32(pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	var info processBasicInformation
+	if status, _, _ := procNtQueryInformationProcess.Call(uintptr(h), 0, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0); status != 0 {
+		syscall.CloseHandle(h)
+		return 0, 0, fmt.Errorf("NtQueryInformationProcess: status %#x", status)
+	}
+	var params uintptr
+	if err := readPointer(h, info.PebBaseAddress+pebProcessParameters, &params); err != nil {
+		syscall.CloseHandle(h)
+		return 0, 0, err
+	}
+	return h, params, nil
+}
+
+// readPointer reads a pointer-sized value at addr in the process h.
+func readPointer(h syscall.Handle, addr uintptr, v *uintptr) error {
+	return readMemory(h, addr, unsafe.Pointer(v), ptrSize)
+}
+
+func readMemory(h syscall.Handle, addr uintptr, buf unsafe.Pointer, size uintptr) error {
+	var n uintptr
+	if r, _, err := procReadProcessMemory.Call(uintptr(h), addr, uintptr(buf), size, uintptr(unsafe.Pointer(&n))); r == 0 {
+		return fmt.Errorf("ReadProcessMemory: %v", err)
+	}
+	if n != size {
+		return fmt.Errorf("ReadProcessMemory: read %d of %d bytes", n, size)
+	}
+	return nil
+}
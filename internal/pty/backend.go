@@ -0,0 +1,38 @@
+package pty
+
+import "sync/atomic"
+
+var backend atomic.Value // string: the backend SetBackend chose
+
+// SetBackend selects the backend New uses from now on: "auto" (or "")
+// lets New choose, anything else must be a backend available here (see
+// Detect): "conpty" or "winpty" on Windows, "exec" elsewhere.
+func SetBackend(name string) error {
+	if name == "" {
+		name = "auto"
+	}
+	if name != "auto" {
+		if err := available(name); err != nil {
+			return err
+		}
+	}
+	backend.Store(name)
+	return nil
+}
+
+// CurrentBackend returns the backend set with SetBackend.
+func CurrentBackend() string {
+	if name, ok := backend.Load().(string); ok {
+		return name
+	}
+	return "auto"
+}
+
+// BackendName returns the name of the backend running t, or "" if t does
+// not say (test fakes).
+func BackendName(t Terminal) string {
+	if b, ok := t.(interface{ Backend() string }); ok {
+		return b.Backend()
+	}
+	return ""
+}
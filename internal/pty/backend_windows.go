@@ -0,0 +1,56 @@
+//go:build windows
+
+package pty
+
+import "fmt"
+
+// The local backends: ConPTY and winpty, whichever New would pick or one
+// chosen with SetBackend, also addressable by scheme.
+func init() {
+	Register(Backend{Scheme: "conpty", Open: func(s Spec) (Terminal, error) {
+		return newConPTY(s.Cols, s.Rows, s.Command, s.Dir, s.Env)
+	}})
+	Register(Backend{Scheme: "winpty", Open: func(s Spec) (Terminal, error) {
+		return newWinPTY(s.Cols, s.Rows, s.Command, s.Dir, s.Env)
+	}})
+}
+
+// New starts command in workdir on the backend set with SetBackend. With
+// "auto" that is ConPTY where Windows has it, and winpty where it does
+// not or where the pseudo console cannot be created (some containers and
+// older LTSC builds), if winpty is installed. env entries ("KEY=VALUE")
+// are added to the inherited environment.
+func New(cols, rows int, command string, workdir string, env []string) (Terminal, error) {
+	switch CurrentBackend() {
+	case "conpty":
+		return newConPTY(cols, rows, command, workdir, env)
+	case "winpty":
+		return newWinPTY(cols, rows, command, workdir, env)
+	}
+	if procCreatePseudoConsole.Find() != nil {
+		return newWinPTY(cols, rows, command, workdir, env)
+	}
+	t, err := newConPTY(cols, rows, command, workdir, env)
+	if err == nil || loadWinpty() != nil {
+		return t, err
+	}
+	t, werr := newWinPTY(cols, rows, command, workdir, env)
+	if werr != nil {
+		return nil, fmt.Errorf("%v (winpty fallback: %v)", err, werr)
+	}
+	return t, nil
+}
+
+// available reports whether the named backend can be used.
+func available(name string) error {
+	switch name {
+	case "conpty":
+		if procCreatePseudoConsole.Find() != nil {
+			return fmt.Errorf("ConPTY needs Windows 10 1809 or later")
+		}
+		return nil
+	case "winpty":
+		return loadWinpty()
+	}
+	return fmt.Errorf("unknown backend %q (available: auto, conpty, winpty)", name)
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf16"
 	"unsafe"
 )
 
@@ -24,6 +25,7 @@ var (
 const (
 	_PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE = 0x00020016
 	_EXTENDED_STARTUPINFO_PRESENT        = 0x00080000
+	_CREATE_UNICODE_ENVIRONMENT          = 0x00000400
 )
 
 type startupInfoEx struct {
@@ -39,17 +41,27 @@ type ConPTY struct {
 	hPipeIn   syscall.Handle // write end → child stdin
 	hPipeOut  syscall.Handle // read end ← child stdout
 	process   syscall.Handle
+	pid       int
 	exited    chan struct{}
 	exitCode  uint32
 	closeOnce sync.Once
 	killed    bool
+
+	jobMu sync.Mutex
+	job   syscall.Handle // Job Object holding the tree, once limits are set
+
+	// Post-exit drain bookkeeping; only touched by the reading goroutine.
+	lastData time.Time
+	exitSeen time.Time
 }
 
 func makeCoord(cols, rows int) uintptr {
 	return uintptr(uint16(cols)) | (uintptr(uint16(rows)) << 16)
 }
 
-func New(cols, rows int, command string, workdir string, env []string) (Terminal, error) {
+// newConPTY starts command in workdir in a new pseudo console, created
+// with the flags set with SetFlags that this system supports.
+func newConPTY(cols, rows int, command string, workdir string, env []string) (Terminal, error) {
 	var ptyInRead, ptyInWrite syscall.Handle
 	var ptyOutRead, ptyOutWrite syscall.Handle
 
@@ -68,7 +80,7 @@ func New(cols, rows int, command string, workdir string, env []string) (Terminal
 		size,
 		uintptr(ptyInRead),
 		uintptr(ptyOutWrite),
-		0,
+		uintptr(Detect().Supported(CurrentFlags())),
 		uintptr(unsafe.Pointer(&hPC)),
 	)
 	if r1 != 0 {
@@ -82,7 +94,7 @@ func New(cols, rows int, command string, workdir string, env []string) (Terminal
 	syscall.CloseHandle(ptyInRead)
 	syscall.CloseHandle(ptyOutWrite)
 
-	process, err := startProcessWithPTY(hPC, command, workdir)
+	process, pid, err := startProcessWithPTY(hPC, command, workdir, env)
 	if err != nil {
 		procClosePseudoConsole.Call(hPC)
 		syscall.CloseHandle(ptyInWrite)
@@ -95,13 +107,14 @@ func New(cols, rows int, command string, workdir string, env []string) (Terminal
 		hPipeIn:  ptyInWrite,
 		hPipeOut: ptyOutRead,
 		process:  process,
+		pid:      pid,
 		exited:   make(chan struct{}),
 	}
 	go c.watchProcess()
 	return c, nil
 }
 
-func startProcessWithPTY(hPC uintptr, command string, workdir string) (syscall.Handle, error) {
+func startProcessWithPTY(hPC uintptr, command string, workdir string, env []string) (syscall.Handle, int, error) {
 	var attrListSize uintptr
 	procInitializeProcThreadAttrList.Call(0, 1, 0, uintptr(unsafe.Pointer(&attrListSize)))
 
@@ -113,7 +126,7 @@ func startProcessWithPTY(hPC uintptr, command string, workdir string) (syscall.H
 		uintptr(unsafe.Pointer(&attrListSize)),
 	)
 	if r1 == 0 {
-		return 0, fmt.Errorf("InitializeProcThreadAttributeList: %v", err)
+		return 0, 0, fmt.Errorf("InitializeProcThreadAttributeList: %v", err)
 	}
 	defer procDeleteProcThreadAttrList.Call(attrList)
 
@@ -127,7 +140,7 @@ func startProcessWithPTY(hPC uintptr, command string, workdir string) (syscall.H
 		0, 0,
 	)
 	if r1 == 0 {
-		return 0, fmt.Errorf("UpdateProcThreadAttribute: %v", err)
+		return 0, 0, fmt.Errorf("UpdateProcThreadAttribute: %v", err)
 	}
 
 	si := startupInfoEx{AttributeList: attrList}
@@ -135,30 +148,49 @@ func startProcessWithPTY(hPC uintptr, command string, workdir string) (syscall.H
 
 	cmdLine, sysErr := syscall.UTF16PtrFromString(command)
 	if sysErr != nil {
-		return 0, sysErr
+		return 0, 0, sysErr
 	}
 
 	var workdirPtr *uint16
 	if workdir != "" {
 		workdirPtr, sysErr = syscall.UTF16PtrFromString(workdir)
 		if sysErr != nil {
-			return 0, sysErr
+			return 0, 0, sysErr
 		}
 	}
 
+	flags := uint32(_EXTENDED_STARTUPINFO_PRESENT)
+	var envBlock *uint16
+	if len(env) > 0 {
+		envBlock = makeEnvBlock(MergeEnv(env))
+		flags |= _CREATE_UNICODE_ENVIRONMENT
+	}
+
 	var pi syscall.ProcessInformation
 	createErr := syscall.CreateProcess(
 		nil, cmdLine, nil, nil, false,
-		_EXTENDED_STARTUPINFO_PRESENT,
-		nil, workdirPtr,
+		flags,
+		envBlock, workdirPtr,
 		&si.StartupInfo, &pi,
 	)
 	if createErr != nil {
-		return 0, fmt.Errorf("CreateProcess: %v", createErr)
+		return 0, 0, fmt.Errorf("CreateProcess: %v", createErr)
 	}
 
 	syscall.CloseHandle(pi.Thread)
-	return pi.Process, nil
+	return pi.Process, int(pi.ProcessId), nil
+}
+
+// makeEnvBlock encodes env as a CreateProcess environment block: UTF-16
+// "KEY=VALUE" strings, each NUL-terminated, with a final extra NUL.
+func makeEnvBlock(env []string) *uint16 {
+	var block []uint16
+	for _, kv := range env {
+		block = append(block, utf16.Encode([]rune(kv))...)
+		block = append(block, 0)
+	}
+	block = append(block, 0)
+	return &block[0]
 }
 
 func (c *ConPTY) watchProcess() {
@@ -171,6 +203,15 @@ func (c *ConPTY) watchProcess() {
 
 var procPeekNamedPipe = kernel32.NewProc("PeekNamedPipe")
 
+// After the child exits ConPTY may still be flushing its last frame into
+// the output pipe, so an empty pipe does not yet mean end of output. The
+// drain keeps polling until the pipe has stayed empty for drainQuiet, or
+// drainLimit has passed since exit, whichever comes first.
+const (
+	drainQuiet = 250 * time.Millisecond
+	drainLimit = 2 * time.Second
+)
+
 // Read polls for available data with PeekNamedPipe then reads with ReadFile.
 // This avoids permanently blocking an OS thread when no data is available,
 // which can prevent ConPTY from flushing output on some Windows versions.
@@ -179,25 +220,20 @@ func (c *ConPTY) Read(buf []byte) (int, error) {
 		return 0, nil
 	}
 	for {
+		exited := false
 		select {
 		case <-c.exited:
-			// Final read: drain anything left in the pipe
-			var avail uint32
-			r1, _, _ := procPeekNamedPipe.Call(uintptr(c.hPipeOut), 0, 0, 0, uintptr(unsafe.Pointer(&avail)), 0)
-			if r1 == 0 || avail == 0 {
-				return 0, fmt.Errorf("ReadFile: %w", syscall.ERROR_BROKEN_PIPE)
-			}
-			var n uint32
-			if err := syscall.ReadFile(c.hPipeOut, buf, &n, nil); err != nil {
-				return int(n), fmt.Errorf("ReadFile: %w", err)
-			}
-			return int(n), nil
+			exited = true
 		default:
 		}
 
 		var avail uint32
 		r1, _, _ := procPeekNamedPipe.Call(uintptr(c.hPipeOut), 0, 0, 0, uintptr(unsafe.Pointer(&avail)), 0)
 		if r1 == 0 {
+			if exited {
+				// ConPTY closed its end: everything has been delivered.
+				return 0, fmt.Errorf("ReadFile: %w", syscall.ERROR_BROKEN_PIPE)
+			}
 			return 0, fmt.Errorf("PeekNamedPipe failed")
 		}
 		if avail > 0 {
@@ -205,8 +241,23 @@ func (c *ConPTY) Read(buf []byte) (int, error) {
 			if err := syscall.ReadFile(c.hPipeOut, buf, &n, nil); err != nil {
 				return int(n), fmt.Errorf("ReadFile: %w", err)
 			}
+			c.lastData = time.Now()
 			return int(n), nil
 		}
+
+		if exited {
+			now := time.Now()
+			if c.exitSeen.IsZero() {
+				c.exitSeen = now
+			}
+			idleSince := c.exitSeen
+			if c.lastData.After(idleSince) {
+				idleSince = c.lastData
+			}
+			if now.Sub(idleSince) >= drainQuiet || now.Sub(c.exitSeen) >= drainLimit {
+				return 0, fmt.Errorf("ReadFile: %w", syscall.ERROR_BROKEN_PIPE)
+			}
+		}
 		time.Sleep(10 * time.Millisecond)
 	}
 }
@@ -239,6 +290,11 @@ func (c *ConPTY) Wait() error {
 
 func (c *ConPTY) ExitCode() int { return int(c.exitCode) }
 
+// Backend reports "conpty".
+func (c *ConPTY) Backend() string { return "conpty" }
+
+func (c *ConPTY) Pid() int { return c.pid }
+
 // Close terminates the child process and releases all handles.
 // Safe to call multiple times.
 func (c *ConPTY) Close() error {
@@ -263,6 +319,14 @@ func (c *ConPTY) Close() error {
 
 		// 5. Close process handle last (after watchProcess is done with it).
 		syscall.CloseHandle(c.process)
+
+		// 6. Closing the job kills any grandchildren still inside it.
+		c.jobMu.Lock()
+		if c.job != 0 {
+			syscall.CloseHandle(c.job)
+			c.job = 0
+		}
+		c.jobMu.Unlock()
 	})
 	return nil
 }
@@ -0,0 +1,43 @@
+package pty
+
+import (
+	"os"
+	"runtime"
+	"strings"
+)
+
+// MergeEnv returns the current process environment with overrides
+// applied. Each override is "KEY=VALUE"; later entries win. Keys compare
+// case-insensitively on Windows, as the OS does.
+func MergeEnv(overrides []string) []string {
+	return mergeEnv(os.Environ(), overrides, runtime.GOOS == "windows")
+}
+
+func mergeEnv(base, overrides []string, foldCase bool) []string {
+	key := func(kv string) string {
+		k, _, _ := strings.Cut(kv, "=")
+		if foldCase {
+			return strings.ToUpper(k)
+		}
+		return k
+	}
+
+	index := make(map[string]int, len(base))
+	out := make([]string, 0, len(base)+len(overrides))
+	for _, kv := range base {
+		index[key(kv)] = len(out)
+		out = append(out, kv)
+	}
+	for _, kv := range overrides {
+		if !strings.Contains(kv, "=") {
+			continue
+		}
+		if i, ok := index[key(kv)]; ok {
+			out[i] = kv
+			continue
+		}
+		index[key(kv)] = len(out)
+		out = append(out, kv)
+	}
+	return out
+}
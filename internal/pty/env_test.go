@@ -0,0 +1,26 @@
+package pty
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	base := []string{"PATH=/bin", "TOKEN=old", "HOME=/root"}
+	got := mergeEnv(base, []string{"TOKEN=new", "EXTRA=1", "bogus"}, false)
+	want := []string{"PATH=/bin", "TOKEN=new", "HOME=/root", "EXTRA=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMergeEnvFoldCase(t *testing.T) {
+	got := mergeEnv([]string{"Path=C:\\Windows"}, []string{"PATH=C:\\tools"}, true)
+	if len(got) != 1 || got[0] != "PATH=C:\\tools" {
+		t.Errorf("got %v", got)
+	}
+	got = mergeEnv([]string{"Path=/a"}, []string{"PATH=/b"}, false)
+	if len(got) != 2 {
+		t.Errorf("expected case-sensitive keys to stay distinct, got %v", got)
+	}
+}
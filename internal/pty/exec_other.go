@@ -3,65 +3,97 @@
 package pty
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"sync"
 )
 
 // ExecTerminal uses plain exec.Cmd with pipes as a PTY stand-in.
 // This enables development and testing of daemon logic on non-Windows
 // platforms. It does not emulate a real terminal (no ANSI processing,
-// no window size), but correctly delivers stdout and accepts stdin.
+// no window size), but correctly delivers stdout and stderr, told apart
+// (see StreamReader), and accepts stdin.
 type ExecTerminal struct {
 	cmd    *exec.Cmd
 	stdin  *os.File // write end of the pipe fed to child stdin
-	stdout *os.File // read end of the pipe receiving child stdout+stderr
+	stdout *os.File // read end of the pipe receiving child stdout
+	stderr *os.File // read end of the pipe receiving child stderr
+	chunks chan chunk
+	rest   chunk // of the last chunk, not read yet
 	done   chan struct{}
 	code   int
 }
 
-// New starts command in workdir using pipes for I/O.
-// cols/rows/env are accepted for interface compatibility but not used.
+// chunk is output read from one of the child's streams.
+type chunk struct {
+	data   []byte
+	stream Stream
+}
+
+// New starts command in workdir using pipes for I/O. env entries
+// ("KEY=VALUE") are added to the inherited environment.
+// cols/rows are accepted for interface compatibility but not used.
 func New(cols, rows int, command string, workdir string, env []string) (Terminal, error) {
 	cmd := exec.Command("bash", "-c", command)
 	if workdir != "" {
 		cmd.Dir = workdir
 	}
-
-	// Create pipes manually so stdout and stderr merge into one reader.
-	outR, outW, err := os.Pipe()
-	if err != nil {
-		return nil, err
+	if len(env) > 0 {
+		cmd.Env = MergeEnv(env)
 	}
-	inR, inW, err := os.Pipe()
-	if err != nil {
-		outR.Close()
-		outW.Close()
-		return nil, err
+
+	// Create pipes manually so the child-side ends can be closed in the
+	// parent once it has started.
+	var pipes [6]*os.File // outR, outW, errR, errW, inR, inW
+	for i := 0; i < len(pipes); i += 2 {
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, f := range pipes[:i] {
+				f.Close()
+			}
+			return nil, err
+		}
+		pipes[i], pipes[i+1] = r, w
 	}
+	outR, outW, errR, errW, inR, inW := pipes[0], pipes[1], pipes[2], pipes[3], pipes[4], pipes[5]
 
 	cmd.Stdin = inR
 	cmd.Stdout = outW
-	cmd.Stderr = outW
+	cmd.Stderr = errW
 
 	if err := cmd.Start(); err != nil {
-		outR.Close()
-		outW.Close()
-		inR.Close()
-		inW.Close()
+		for _, f := range pipes {
+			f.Close()
+		}
 		return nil, err
 	}
 
 	// Close child-side ends in the parent.
 	outW.Close()
+	errW.Close()
 	inR.Close()
 
 	t := &ExecTerminal{
 		cmd:    cmd,
 		stdin:  inW,
 		stdout: outR,
+		stderr: errR,
+		chunks: make(chan chunk, 16),
 		done:   make(chan struct{}),
 	}
 
+	// Both streams feed one queue, so output keeps the order it was read in.
+	var pumps sync.WaitGroup
+	pumps.Add(2)
+	go t.pump(outR, Stdout, &pumps)
+	go t.pump(errR, Stderr, &pumps)
+	go func() {
+		pumps.Wait()
+		close(t.chunks)
+	}()
+
 	go func() {
 		_ = cmd.Wait()
 		t.code = cmd.ProcessState.ExitCode()
@@ -71,9 +103,43 @@ func New(cols, rows int, command string, workdir string, env []string) (Terminal
 	return t, nil
 }
 
-func (t *ExecTerminal) Read(buf []byte) (int, error)  { return t.stdout.Read(buf) }
+// pump queues what the child writes to one stream until it is closed.
+func (t *ExecTerminal) pump(f *os.File, s Stream, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		buf := make([]byte, 4096)
+		n, err := f.Read(buf)
+		if n > 0 {
+			t.chunks <- chunk{data: buf[:n], stream: s}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ReadStream implements StreamReader. It returns io.EOF once both
+// streams are closed.
+func (t *ExecTerminal) ReadStream(buf []byte) (int, Stream, error) {
+	if len(t.rest.data) == 0 {
+		c, ok := <-t.chunks
+		if !ok {
+			return 0, Stdout, io.EOF
+		}
+		t.rest = c
+	}
+	n := copy(buf, t.rest.data)
+	t.rest.data = t.rest.data[n:]
+	return n, t.rest.stream, nil
+}
+
+func (t *ExecTerminal) Read(buf []byte) (int, error) {
+	n, _, err := t.ReadStream(buf)
+	return n, err
+}
+
 func (t *ExecTerminal) Write(data []byte) (int, error) { return t.stdin.Write(data) }
-func (t *ExecTerminal) Resize(cols, rows int) error     { return nil }
+func (t *ExecTerminal) Resize(cols, rows int) error    { return nil }
 
 func (t *ExecTerminal) Wait() error {
 	<-t.done
@@ -82,11 +148,35 @@ func (t *ExecTerminal) Wait() error {
 
 func (t *ExecTerminal) ExitCode() int { return t.code }
 
+func (t *ExecTerminal) Pid() int { return t.cmd.Process.Pid }
+
 func (t *ExecTerminal) Close() error {
 	t.stdin.Close()
 	t.stdout.Close()
+	t.stderr.Close()
 	if t.cmd.Process != nil {
 		return t.cmd.Process.Kill()
 	}
 	return nil
 }
+
+func init() {
+	Register(Backend{Scheme: "exec", Open: func(s Spec) (Terminal, error) {
+		return New(s.Cols, s.Rows, s.Command, s.Dir, s.Env)
+	}})
+}
+
+// Backend reports "exec".
+func (t *ExecTerminal) Backend() string { return "exec" }
+
+// available reports whether the named backend can be used. Only exec
+// exists off Windows.
+func available(name string) error {
+	switch name {
+	case "exec":
+		return nil
+	case "conpty", "winpty":
+		return fmt.Errorf("backend %s is only available on Windows", name)
+	}
+	return fmt.Errorf("unknown backend %q (available: auto, exec)", name)
+}
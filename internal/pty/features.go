@@ -0,0 +1,119 @@
+package pty
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Flags selects optional pseudo console behaviour. It is applied when a
+// terminal is created, so changing it affects only later terminals.
+type Flags uint32
+
+const (
+	// FlagInheritCursor starts the pseudo console at the cursor position
+	// of the screen it takes over (PSEUDOCONSOLE_INHERIT_CURSOR). ConPTY
+	// asks for the position with a cursor position report request at
+	// startup and waits for the reply.
+	FlagInheritCursor Flags = 1 << iota
+	// FlagResizeQuirk stops ConPTY repainting the whole screen after a
+	// resize (PSEUDOCONSOLE_RESIZE_QUIRK), which otherwise duplicates
+	// lines in the captured history.
+	FlagResizeQuirk
+)
+
+// flagNames maps each flag to its option name.
+var flagNames = map[Flags]string{
+	FlagInheritCursor: "inherit-cursor",
+	FlagResizeQuirk:   "resize-quirk",
+}
+
+// ParseFlags parses a comma-separated list of flag names; "" and "none"
+// mean no flags.
+func ParseFlags(s string) (Flags, error) {
+	var f Flags
+	if s == "" || s == "none" {
+		return 0, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for flag, n := range flagNames {
+			if n == name {
+				f |= flag
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown flag %q (known: %s)", name, strings.Join(FlagNames(), ", "))
+		}
+	}
+	return f, nil
+}
+
+// FlagNames returns the names of all flags, sorted.
+func FlagNames() []string {
+	var names []string
+	for _, n := range flagNames {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the flags as ParseFlags accepts them.
+func (f Flags) String() string {
+	var names []string
+	for flag, n := range flagNames {
+		if f&flag != 0 {
+			names = append(names, n)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+var flags atomic.Uint32
+
+// SetFlags sets the flags used for terminals created from now on.
+func SetFlags(f Flags) { flags.Store(uint32(f)) }
+
+// CurrentFlags returns the flags set with SetFlags.
+func CurrentFlags() Flags { return Flags(flags.Load()) }
+
+// Feature describes an optional backend capability and whether this
+// system has it. Flag is set for features enabled with a Flags bit.
+type Feature struct {
+	Name      string
+	Flag      Flags
+	Supported bool
+	Note      string
+}
+
+// System describes the terminal backend available on this machine.
+type System struct {
+	Backend  string // "conpty" or "exec"
+	OS       string // OS name and version, e.g. "Windows 10.0.19045"
+	Features []Feature
+}
+
+// Detect reports the backend and which optional features this system
+// supports.
+func Detect() System {
+	return detect()
+}
+
+// Supported reports which of f this system supports.
+func (s System) Supported(f Flags) Flags {
+	var ok Flags
+	for _, feat := range s.Features {
+		if feat.Flag != 0 && feat.Supported {
+			ok |= feat.Flag
+		}
+	}
+	return f & ok
+}
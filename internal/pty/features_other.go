@@ -0,0 +1,19 @@
+//go:build !windows
+
+package pty
+
+import "runtime"
+
+func detect() System {
+	s := System{Backend: "exec", OS: runtime.GOOS}
+	for _, name := range []string{"conpty", "inherit-cursor", "resize-quirk", "winpty"} {
+		f := Feature{Name: name, Supported: false, Note: "Windows-only"}
+		for flag, n := range flagNames {
+			if n == name {
+				f.Flag = flag
+			}
+		}
+		s.Features = append(s.Features, f)
+	}
+	return s
+}
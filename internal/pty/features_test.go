@@ -0,0 +1,47 @@
+package pty
+
+import "testing"
+
+func TestParseFlags(t *testing.T) {
+	f, err := ParseFlags("resize-quirk, inherit-cursor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != FlagInheritCursor|FlagResizeQuirk {
+		t.Errorf("flags = %b", f)
+	}
+	if s := f.String(); s != "inherit-cursor,resize-quirk" {
+		t.Errorf("String() = %q", s)
+	}
+	for _, none := range []string{"", "none"} {
+		if f, err := ParseFlags(none); err != nil || f != 0 {
+			t.Errorf("ParseFlags(%q) = %v, %v", none, f, err)
+		}
+	}
+	if Flags(0).String() != "none" {
+		t.Errorf("zero flags = %q", Flags(0).String())
+	}
+	if _, err := ParseFlags("inherit-cursor,win32-input"); err == nil {
+		t.Error("expected error for unknown flag")
+	}
+}
+
+func TestSupported(t *testing.T) {
+	s := System{Features: []Feature{
+		{Name: "inherit-cursor", Flag: FlagInheritCursor, Supported: true},
+		{Name: "resize-quirk", Flag: FlagResizeQuirk},
+	}}
+	if got := s.Supported(FlagInheritCursor | FlagResizeQuirk); got != FlagInheritCursor {
+		t.Errorf("Supported = %v", got)
+	}
+}
+
+func TestSetBackend(t *testing.T) {
+	defer SetBackend("auto")
+	if err := SetBackend("bogus"); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+	if err := SetBackend(""); err != nil || CurrentBackend() != "auto" {
+		t.Errorf("SetBackend(\"\") = %v, backend %q", err, CurrentBackend())
+	}
+}
@@ -0,0 +1,77 @@
+//go:build windows
+
+package pty
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Windows builds that first shipped each pseudo console flag. Unknown
+// flags make CreatePseudoConsole fail on older builds, so flags are only
+// passed where they are known to be accepted.
+const (
+	buildConPTY        = 17763 // Windows 10 1809
+	buildInheritCursor = 17763
+	buildResizeQuirk   = 19041 // Windows 10 2004
+)
+
+var (
+	ntdll             = syscall.NewLazyDLL("ntdll.dll")
+	procRtlGetVersion = ntdll.NewProc("RtlGetVersion")
+)
+
+type osVersionInfo struct {
+	size         uint32
+	major, minor uint32
+	build        uint32
+	platformID   uint32
+	csdVersion   [128]uint16
+}
+
+// windowsVersion returns the OS version as RtlGetVersion reports it,
+// which unlike GetVersionEx is not shimmed for unmanifested programs.
+func windowsVersion() (major, minor, build uint32) {
+	if procRtlGetVersion.Find() != nil {
+		return 0, 0, 0
+	}
+	var v osVersionInfo
+	v.size = uint32(unsafe.Sizeof(v))
+	procRtlGetVersion.Call(uintptr(unsafe.Pointer(&v)))
+	return v.major, v.minor, v.build
+}
+
+func detect() System {
+	major, minor, build := windowsVersion()
+	s := System{
+		Backend: "conpty",
+		OS:      fmt.Sprintf("Windows %d.%d.%d", major, minor, build),
+	}
+	conpty := procCreatePseudoConsole.Find() == nil
+	winpty := Feature{Name: "winpty", Supported: true}
+	if err := loadWinpty(); err != nil {
+		winpty = Feature{Name: "winpty", Note: err.Error()}
+	}
+	switch {
+	case conpty:
+	case winpty.Supported:
+		s.Backend = "winpty"
+	default:
+		s.Backend = "none"
+	}
+	feature := func(name string, flag Flags, minBuild uint32) Feature {
+		f := Feature{Name: name, Flag: flag, Supported: conpty && build >= minBuild}
+		if !f.Supported {
+			f.Note = fmt.Sprintf("needs Windows build %d or later", minBuild)
+		}
+		return f
+	}
+	s.Features = []Feature{
+		feature("conpty", 0, buildConPTY),
+		feature("inherit-cursor", FlagInheritCursor, buildInheritCursor),
+		feature("resize-quirk", FlagResizeQuirk, buildResizeQuirk),
+		winpty,
+	}
+	return s
+}
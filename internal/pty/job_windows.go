@@ -0,0 +1,116 @@
+//go:build windows
+
+package pty
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+)
+
+const (
+	_JobObjectExtendedLimitInformation  = 9
+	_JobObjectCpuRateControlInformation = 15
+
+	_JOB_OBJECT_LIMIT_ACTIVE_PROCESS    = 0x00000008
+	_JOB_OBJECT_LIMIT_JOB_MEMORY        = 0x00000200
+	_JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE = 0x00002000
+
+	_JOB_OBJECT_CPU_RATE_CONTROL_ENABLE   = 0x1
+	_JOB_OBJECT_CPU_RATE_CONTROL_HARD_CAP = 0x4
+)
+
+type jobBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+type jobExtendedLimitInformation struct {
+	BasicLimitInformation jobBasicLimitInformation
+	IoInfo                ioCounters
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+type jobCPURateControlInformation struct {
+	ControlFlags uint32
+	CPURate      uint32 // percentage × 100
+}
+
+// SetLimits places the child in a Job Object (created on first use) and
+// applies l to it. Processes the child starts afterwards inherit the job;
+// grandchildren that already exist are not moved. The job is configured
+// to kill the whole tree when the ConPTY is closed.
+func (c *ConPTY) SetLimits(l Limits) error {
+	c.jobMu.Lock()
+	defer c.jobMu.Unlock()
+
+	if c.job == 0 {
+		h, _, err := procCreateJobObjectW.Call(0, 0)
+		if h == 0 {
+			return fmt.Errorf("CreateJobObject: %v", err)
+		}
+		r1, _, err := procAssignProcessToJobObject.Call(h, uintptr(c.process))
+		if r1 == 0 {
+			syscall.CloseHandle(syscall.Handle(h))
+			return fmt.Errorf("AssignProcessToJobObject: %v", err)
+		}
+		c.job = syscall.Handle(h)
+	}
+
+	var info jobExtendedLimitInformation
+	info.BasicLimitInformation.LimitFlags = _JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if l.MemoryBytes > 0 {
+		info.BasicLimitInformation.LimitFlags |= _JOB_OBJECT_LIMIT_JOB_MEMORY
+		info.JobMemoryLimit = uintptr(l.MemoryBytes)
+	}
+	if l.MaxProcesses > 0 {
+		info.BasicLimitInformation.LimitFlags |= _JOB_OBJECT_LIMIT_ACTIVE_PROCESS
+		info.BasicLimitInformation.ActiveProcessLimit = uint32(l.MaxProcesses)
+	}
+	r1, _, err := procSetInformationJobObject.Call(
+		uintptr(c.job), _JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info),
+	)
+	if r1 == 0 {
+		return fmt.Errorf("SetInformationJobObject(limits): %v", err)
+	}
+
+	var cpu jobCPURateControlInformation
+	if l.CPUPercent > 0 {
+		cpu.ControlFlags = _JOB_OBJECT_CPU_RATE_CONTROL_ENABLE | _JOB_OBJECT_CPU_RATE_CONTROL_HARD_CAP
+		cpu.CPURate = uint32(l.CPUPercent * 100)
+	}
+	r1, _, err = procSetInformationJobObject.Call(
+		uintptr(c.job), _JobObjectCpuRateControlInformation,
+		uintptr(unsafe.Pointer(&cpu)), unsafe.Sizeof(cpu),
+	)
+	if r1 == 0 {
+		return fmt.Errorf("SetInformationJobObject(cpu rate): %v", err)
+	}
+	return nil
+}
@@ -19,6 +19,43 @@ type Terminal interface {
 	// ExitCode returns the child process exit code. Only valid after Wait returns.
 	ExitCode() int
 
+	// Pid returns the operating-system process ID of the child.
+	Pid() int
+
 	// Close terminates the child process and releases resources.
 	Close() error
 }
+
+// Limits caps the resources available to a terminal's process tree.
+// Zero fields mean unlimited.
+type Limits struct {
+	MemoryBytes  uint64 // total committed memory across the tree
+	CPUPercent   int    // hard cap as a percentage of total machine CPU
+	MaxProcesses int    // maximum simultaneously active processes
+}
+
+// Limiter is implemented by terminals that can constrain their child
+// process tree. On Windows this is backed by a Job Object.
+type Limiter interface {
+	SetLimits(l Limits) error
+}
+
+// Stream is the output stream of the child that bytes came from.
+type Stream uint8
+
+const (
+	Stdout Stream = iota
+	Stderr
+)
+
+// StreamReader is implemented by terminals that keep the child's stderr
+// apart from its stdout. ReadStream is Read that also reports which
+// stream the bytes came from; one call returns bytes of one stream.
+// Bytes of the two streams come in the order they were read, which is
+// the order they were written unless the child wrote to both within
+// moments of each other.
+// ConPTY and winpty merge everything into one console screen and do not
+// implement it.
+type StreamReader interface {
+	ReadStream(buf []byte) (int, Stream, error)
+}
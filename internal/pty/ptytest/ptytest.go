@@ -0,0 +1,244 @@
+// Package ptytest provides a scripted in-memory pty.Terminal, so code
+// that drives a pane (capture, pipe-pane, exit handling) can be tested
+// without starting a process.
+package ptytest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"wintmux/internal/pty"
+)
+
+// ErrClosed is returned by Write after the terminal is closed.
+var ErrClosed = errors.New("ptytest: terminal closed")
+
+// chunk is one queued piece of output, delivered delay after the one
+// before it was read.
+type chunk struct {
+	delay  time.Duration
+	data   []byte
+	stream pty.Stream
+}
+
+// Terminal is a fake pty.Terminal. Output is queued with Output or
+// OutputAfter and handed to Read in order; Exit ends the fake process once
+// the queued output has been read. Everything written is recorded.
+type Terminal struct {
+	// OnInput, if set, is called with each Write, for scripted replies
+	// such as echoing input back with Output.
+	OnInput func(t *Terminal, data []byte)
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []chunk
+	pending  []byte     // rest of a chunk that did not fit the last Read
+	stream   pty.Stream // of pending
+	input    bytes.Buffer
+	cols     int
+	rows     int
+	resizes  int
+	pid      int
+	exiting  bool // Exit called; EOF once the queue drains
+	exitCode int
+	closed   bool
+	done     chan struct{} // closed when the fake process has exited
+}
+
+var (
+	_ pty.Terminal     = (*Terminal)(nil)
+	_ pty.StreamReader = (*Terminal)(nil)
+)
+
+// New returns a running fake terminal of the given size whose Pid
+// reports pid.
+func New(cols, rows, pid int) *Terminal {
+	t := &Terminal{cols: cols, rows: rows, pid: pid, done: make(chan struct{})}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// Output queues data to be read straight after the output before it.
+func (t *Terminal) Output(data string) {
+	t.OutputAfter(0, data)
+}
+
+// OutputAfter queues data to be read delay after the output before it has
+// been read.
+func (t *Terminal) OutputAfter(delay time.Duration, data string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queue = append(t.queue, chunk{delay: delay, data: []byte(data)})
+	t.cond.Broadcast()
+}
+
+// Stderr queues data written to stderr, to be read straight after the
+// output before it. Output is written to stdout.
+func (t *Terminal) Stderr(data string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queue = append(t.queue, chunk{data: []byte(data), stream: pty.Stderr})
+	t.cond.Broadcast()
+}
+
+// Exit makes the fake process exit with code once all queued output has
+// been read: Read then returns io.EOF and Wait returns.
+func (t *Terminal) Exit(code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.exiting = true
+	t.exitCode = code
+	t.cond.Broadcast()
+}
+
+// Read blocks until queued output is due, then returns it.
+func (t *Terminal) Read(buf []byte) (int, error) {
+	n, _, err := t.ReadStream(buf)
+	return n, err
+}
+
+// ReadStream is Read that also reports the stream the output was queued
+// for.
+func (t *Terminal) ReadStream(buf []byte) (int, pty.Stream, error) {
+	t.mu.Lock()
+	for {
+		if len(t.pending) > 0 {
+			n := copy(buf, t.pending)
+			t.pending = t.pending[n:]
+			t.mu.Unlock()
+			return n, t.stream, nil
+		}
+		if t.closed {
+			t.mu.Unlock()
+			return 0, pty.Stdout, io.EOF
+		}
+		if len(t.queue) > 0 {
+			c := t.queue[0]
+			t.queue = t.queue[1:]
+			if c.delay > 0 {
+				t.mu.Unlock()
+				time.Sleep(c.delay)
+				t.mu.Lock()
+			}
+			t.pending, t.stream = c.data, c.stream
+			continue
+		}
+		if t.exiting {
+			t.exitLocked()
+			t.mu.Unlock()
+			return 0, pty.Stdout, io.EOF
+		}
+		t.cond.Wait()
+	}
+}
+
+// exitLocked marks the fake process exited. t.mu must be held.
+func (t *Terminal) exitLocked() {
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+}
+
+// Write records data as input and passes it to OnInput.
+func (t *Terminal) Write(data []byte) (int, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return 0, ErrClosed
+	}
+	t.input.Write(data)
+	t.cond.Broadcast()
+	hook := t.OnInput
+	t.mu.Unlock()
+	if hook != nil {
+		hook(t, append([]byte(nil), data...))
+	}
+	return len(data), nil
+}
+
+// Input returns everything written so far.
+func (t *Terminal) Input() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.input.String()
+}
+
+// WaitInput waits up to timeout for the recorded input to contain s.
+func (t *Terminal) WaitInput(s string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if strings.Contains(t.Input(), s) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Resize records the new size.
+func (t *Terminal) Resize(cols, rows int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cols, t.rows = cols, rows
+	t.resizes++
+	return nil
+}
+
+// Size returns the current size and how many times Resize was called.
+func (t *Terminal) Size() (cols, rows, resizes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cols, t.rows, t.resizes
+}
+
+// Wait blocks until the fake process exits, by Exit or Close.
+func (t *Terminal) Wait() error {
+	<-t.done
+	return nil
+}
+
+// ExitCode returns the code given to Exit, or -1 after Close.
+func (t *Terminal) ExitCode() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.exitCode
+}
+
+// Pid returns the pid given to New.
+func (t *Terminal) Pid() int { return t.pid }
+
+// Close kills the fake process: unread output is discarded, Read returns
+// io.EOF and the exit code is -1, as for a killed process.
+func (t *Terminal) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	t.queue = nil
+	t.pending = nil
+	select {
+	case <-t.done:
+	default:
+		t.exitCode = -1
+	}
+	t.exitLocked()
+	t.cond.Broadcast()
+	return nil
+}
+
+// Closed reports whether Close was called.
+func (t *Terminal) Closed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
@@ -0,0 +1,102 @@
+package ptytest
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, term *Terminal) string {
+	t.Helper()
+	var out []byte
+	buf := make([]byte, 4)
+	for {
+		n, err := term.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return string(out)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOutputThenExit(t *testing.T) {
+	term := New(80, 24, 42)
+	term.Output("hello ")
+	term.OutputAfter(30*time.Millisecond, "world")
+	term.Exit(3)
+
+	start := time.Now()
+	if got := readAll(t, term); got != "hello world" {
+		t.Errorf("output = %q", got)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Error("delayed output arrived early")
+	}
+	term.Wait()
+	if term.ExitCode() != 3 {
+		t.Errorf("exit code = %d, want 3", term.ExitCode())
+	}
+	if term.Pid() != 42 {
+		t.Errorf("pid = %d, want 42", term.Pid())
+	}
+}
+
+func TestReadBlocksUntilOutput(t *testing.T) {
+	term := New(80, 24, 1)
+	got := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := term.Read(buf)
+		got <- string(buf[:n])
+	}()
+	select {
+	case s := <-got:
+		t.Fatalf("Read returned %q before any output", s)
+	case <-time.After(20 * time.Millisecond):
+	}
+	term.Output("x")
+	if s := <-got; s != "x" {
+		t.Errorf("Read = %q, want x", s)
+	}
+}
+
+func TestInputRecordedAndScripted(t *testing.T) {
+	term := New(80, 24, 1)
+	term.OnInput = func(t *Terminal, data []byte) { t.Output(string(data)) }
+	term.Write([]byte("ls\r"))
+	if !term.WaitInput("ls", time.Second) {
+		t.Fatal("input not recorded")
+	}
+	buf := make([]byte, 16)
+	n, _ := term.Read(buf)
+	if string(buf[:n]) != "ls\r" {
+		t.Errorf("echo = %q", buf[:n])
+	}
+}
+
+func TestCloseKills(t *testing.T) {
+	term := New(80, 24, 1)
+	term.Output("never read")
+	term.Close()
+	if got := readAll(t, term); got != "" {
+		t.Errorf("output after close = %q", got)
+	}
+	term.Wait()
+	if term.ExitCode() != -1 {
+		t.Errorf("exit code = %d, want -1", term.ExitCode())
+	}
+	if _, err := term.Write([]byte("x")); err != ErrClosed {
+		t.Errorf("Write after close = %v, want ErrClosed", err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	term := New(80, 24, 1)
+	term.Resize(100, 30)
+	if c, r, n := term.Size(); c != 100 || r != 30 || n != 1 {
+		t.Errorf("Size = %d, %d, %d", c, r, n)
+	}
+}
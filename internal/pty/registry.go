@@ -0,0 +1,148 @@
+package pty
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Spec describes a pane's terminal: the backend that creates it, what the
+// backend addresses, and the process to run. Its text form, parsed by
+// ParseSpec, is SCHEME[:TARGET][?NAME=VALUE&...], e.g.
+// ssh:ci@buildbox?port=2222 or serial:COM3?baud=115200. The zero Spec is a
+// local process on the default backend (see SetBackend).
+type Spec struct {
+	Scheme  string
+	Target  string
+	Options map[string]string
+
+	Command string
+	Dir     string
+	Env     []string // "KEY=VALUE" entries added to the environment
+	Cols    int
+	Rows    int
+}
+
+// Backend is a way of creating terminals, registered under a scheme.
+type Backend struct {
+	Scheme  string
+	Options []string           // option names Open accepts
+	Target  bool               // the spec must name a target
+	Remote  bool               // the command does not run as a process on this machine
+	Check   func(s Spec) error // optional: validates target and options
+	Open    func(s Spec) (Terminal, error)
+}
+
+var backends = map[string]Backend{}
+
+// Register makes b available under its scheme. Backends register
+// themselves from init functions.
+func Register(b Backend) {
+	if _, dup := backends[b.Scheme]; dup {
+		panic("pty: backend registered twice: " + b.Scheme)
+	}
+	backends[b.Scheme] = b
+}
+
+// Schemes returns the schemes of all registered backends, sorted.
+func Schemes() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSpec parses the text form of a spec. The scheme must be registered
+// here and the options known to it; "" is the zero Spec.
+func ParseSpec(text string) (Spec, error) {
+	var s Spec
+	if text == "" {
+		return s, nil
+	}
+	rest, query, hasQuery := strings.Cut(text, "?")
+	s.Scheme, s.Target, _ = strings.Cut(rest, ":")
+	s.Target = strings.TrimPrefix(s.Target, "//")
+	b, ok := backends[s.Scheme]
+	if !ok {
+		return s, fmt.Errorf("unknown backend %q (available: %s)", s.Scheme, strings.Join(Schemes(), ", "))
+	}
+	if b.Target && s.Target == "" {
+		return s, fmt.Errorf("%s: missing target (%s:TARGET)", s.Scheme, s.Scheme)
+	}
+	if !b.Target && s.Target != "" {
+		return s, fmt.Errorf("%s takes no target", s.Scheme)
+	}
+	if hasQuery {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return s, fmt.Errorf("%s: invalid options: %v", s.Scheme, err)
+		}
+		s.Options = make(map[string]string, len(values))
+		for name, v := range values {
+			if !contains(b.Options, name) {
+				known := "none"
+				if len(b.Options) > 0 {
+					known = strings.Join(b.Options, ", ")
+				}
+				return s, fmt.Errorf("%s: unknown option %q (known: %s)", s.Scheme, name, known)
+			}
+			s.Options[name] = v[len(v)-1]
+		}
+	}
+	if b.Check != nil {
+		if err := b.Check(s); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// String returns the text form of s, without the process fields.
+func (s Spec) String() string {
+	if s.Scheme == "" {
+		return ""
+	}
+	text := s.Scheme
+	if s.Target != "" {
+		text += ":" + s.Target
+	}
+	if len(s.Options) > 0 {
+		values := url.Values{}
+		for name, v := range s.Options {
+			values.Set(name, v)
+		}
+		text += "?" + values.Encode()
+	}
+	return text
+}
+
+// Remote reports whether s runs its command somewhere other than a
+// process on this machine, so the local process's directory and tree
+// say nothing about it.
+func (s Spec) Remote() bool {
+	return backends[s.Scheme].Remote
+}
+
+// Open creates the terminal s describes.
+func Open(s Spec) (Terminal, error) {
+	if s.Scheme == "" {
+		return New(s.Cols, s.Rows, s.Command, s.Dir, s.Env)
+	}
+	b, ok := backends[s.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", s.Scheme)
+	}
+	return b.Open(s)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package pty
+
+import "testing"
+
+func TestParseSpec(t *testing.T) {
+	for text, want := range map[string]string{
+		"":                              "",
+		"docker:build01":                "docker:build01",
+		"docker://build01?user=ci":      "docker:build01?user=ci",
+		"ssh:ci@buildbox?port=2222":     "ssh:ci@buildbox?port=2222",
+		"serial:COM3?baud=115200":       "serial:COM3?baud=115200",
+		"serial:/dev/ttyUSB0:57600:7E1": "serial:/dev/ttyUSB0:57600:7E1",
+	} {
+		s, err := ParseSpec(text)
+		if err != nil {
+			t.Errorf("ParseSpec(%q): %v", text, err)
+			continue
+		}
+		if s.String() != want {
+			t.Errorf("ParseSpec(%q) = %s, want %s", text, s, want)
+		}
+	}
+	for _, bad := range []string{"teletype:x", "docker", "ssh:box?colour=red", "serial:COM3?baud=fast", "serial:COM3?framing=9N1", "serial:"} {
+		if _, err := ParseSpec(bad); err == nil {
+			t.Errorf("ParseSpec(%q): expected error", bad)
+		}
+	}
+
+	s, _ := ParseSpec("serial:COM3:9600?baud=115200&framing=7e1")
+	if c, err := serialConfig(s); err != nil || c.String() != "COM3:115200:7E1" {
+		t.Errorf("serialConfig = %v, %v", c, err)
+	}
+	if !s.Remote() || (Spec{}).Remote() {
+		t.Error("Remote: serial should be remote, the zero Spec local")
+	}
+}
+
+func TestSSHRemoteCommand(t *testing.T) {
+	for _, tc := range []struct {
+		command, dir string
+		env          []string
+		want         string
+	}{
+		{"", "", nil, ""},
+		{"top", "", nil, "top"},
+		{"make test", "/src/my app", []string{"MODE=it's"}, `cd '/src/my app' && export MODE='it'\''s' && make test`},
+		{"", "/src", nil, `cd /src && exec "$SHELL" -l`},
+	} {
+		if got := sshRemoteCommand(tc.command, tc.dir, tc.env); got != tc.want {
+			t.Errorf("sshRemoteCommand(%q, %q, %v) = %s, want %s", tc.command, tc.dir, tc.env, got, tc.want)
+		}
+	}
+}
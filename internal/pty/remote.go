@@ -0,0 +1,150 @@
+package pty
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The docker and ssh backends run a local client (docker exec, ssh) in a
+// terminal of the default backend, and the client runs the command in a
+// container or on another machine with a TTY of its own, so the pane is
+// driven the same way as a local one.
+func init() {
+	Register(Backend{
+		Scheme:  "docker",
+		Options: []string{"cli", "user"},
+		Target:  true,
+		Remote:  true,
+		Open: func(s Spec) (Terminal, error) {
+			cli := s.Options["cli"]
+			if cli == "" {
+				cli = containerCLI()
+			}
+			if err := checkContainer(cli, s.Target); err != nil {
+				return nil, err
+			}
+			return New(s.Cols, s.Rows, containerCommand(cli, s), "", nil)
+		},
+	})
+	Register(Backend{
+		Scheme:  "ssh",
+		Options: []string{"port", "identity"},
+		Target:  true,
+		Remote:  true,
+		Open: func(s Spec) (Terminal, error) {
+			return New(s.Cols, s.Rows, sshCommand(s), "", nil)
+		},
+	})
+}
+
+// containerCLI is the docker-compatible client used to reach containers
+// unless the cli option names one. WINTMUX_CONTAINER_CLI names another
+// default, such as podman or nerdctl.
+func containerCLI() string {
+	if cli := os.Getenv("WINTMUX_CONTAINER_CLI"); cli != "" {
+		return cli
+	}
+	return "docker"
+}
+
+// containerCommand runs s's command in the container s.Target through
+// "docker exec" with a TTY, which the pane's terminal gives the client.
+// s.Dir is the working directory inside the container, and s.Env is set
+// with -e, since the client's own environment does not reach the
+// container. An empty command starts the container's shell.
+func containerCommand(cli string, s Spec) string {
+	parts := []string{quoteArg(cli), "exec", "-it"}
+	if user := s.Options["user"]; user != "" {
+		parts = append(parts, "-u", quoteArg(user))
+	}
+	if s.Dir != "" {
+		parts = append(parts, "-w", quoteArg(s.Dir))
+	}
+	for _, kv := range s.Env {
+		parts = append(parts, "-e", quoteArg(kv))
+	}
+	command := s.Command
+	if command == "" {
+		command = containerShell
+	}
+	return strings.Join(append(parts, quoteArg(s.Target), command), " ")
+}
+
+// checkContainer fails unless container exists and is running, so a
+// mistyped name is reported instead of a pane that exits at once.
+func checkContainer(cli, container string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, cli, "inspect", "-f", "{{.State.Running}}", container).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("container %s: %s", container, msg)
+	}
+	if strings.TrimSpace(string(out)) != "true" {
+		return fmt.Errorf("container %s is not running", container)
+	}
+	return nil
+}
+
+// sshClient is the OpenSSH client used for ssh panes (Windows ships
+// one). WINTMUX_SSH names another, e.g. a full path.
+func sshClient() string {
+	if ssh := os.Getenv("WINTMUX_SSH"); ssh != "" {
+		return ssh
+	}
+	return "ssh"
+}
+
+// sshCommand runs s's command on the host s.Target through ssh -tt,
+// which gives it a remote PTY; window size changes of the pane reach it
+// through the client.
+func sshCommand(s Spec) string {
+	parts := []string{quoteArg(sshClient()), "-tt"}
+	if port := s.Options["port"]; port != "" {
+		parts = append(parts, "-p", quoteArg(port))
+	}
+	if id := s.Options["identity"]; id != "" {
+		parts = append(parts, "-i", quoteArg(id))
+	}
+	parts = append(parts, "--", quoteArg(s.Target))
+	if remote := sshRemoteCommand(s.Command, s.Dir, s.Env); remote != "" {
+		parts = append(parts, quoteArg(remote))
+	}
+	return strings.Join(parts, " ")
+}
+
+// sshRemoteCommand is the command line the remote login shell runs. The
+// shell is assumed to be POSIX: dir becomes a cd and env entries are
+// exported first. An empty command starts a login shell.
+func sshRemoteCommand(command, dir string, env []string) string {
+	var remote []string
+	if dir != "" {
+		remote = append(remote, "cd "+posixQuote(dir))
+	}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		remote = append(remote, "export "+k+"="+posixQuote(v))
+	}
+	if command == "" && len(remote) > 0 {
+		command = `exec "$SHELL" -l`
+	}
+	if command != "" {
+		remote = append(remote, command)
+	}
+	return strings.Join(remote, " && ")
+}
+
+// posixQuote quotes s as one word for a POSIX shell.
+func posixQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=,@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,108 @@
+package pty
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SerialConfig describes a serial port and its line settings.
+type SerialConfig struct {
+	Port     string // COM3 on Windows, a device path such as /dev/ttyUSB0 elsewhere
+	Baud     int
+	DataBits int  // 5-8
+	Parity   byte // 'N', 'E' or 'O'
+	StopBits int  // 1 or 2
+}
+
+// The serial backend attaches a pane to a serial port instead of a
+// process. The target is PORT[:BAUD[:FRAMING]]; the baud and framing
+// options override what it gives.
+func init() {
+	Register(Backend{
+		Scheme:  "serial",
+		Options: []string{"baud", "framing"},
+		Target:  true,
+		Remote:  true,
+		Check: func(s Spec) error {
+			_, err := serialConfig(s)
+			return err
+		},
+		Open: func(s Spec) (Terminal, error) {
+			if s.Command != "" {
+				return nil, fmt.Errorf("a serial port pane runs no command")
+			}
+			c, err := serialConfig(s)
+			if err != nil {
+				return nil, err
+			}
+			return OpenSerial(c)
+		},
+	})
+}
+
+func serialConfig(s Spec) (SerialConfig, error) {
+	c, err := ParseSerial(s.Target)
+	if err != nil {
+		return c, err
+	}
+	if v, ok := s.Options["baud"]; ok {
+		if c.Baud, err = parseBaud(v); err != nil {
+			return c, err
+		}
+	}
+	if v, ok := s.Options["framing"]; ok {
+		if err := c.setFraming(v); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// ParseSerial parses PORT[:BAUD[:FRAMING]], e.g. COM3, COM3:115200 or
+// COM3:9600:7E1. Baud defaults to 9600 and framing to 8N1.
+func ParseSerial(spec string) (SerialConfig, error) {
+	c := SerialConfig{Baud: 9600, DataBits: 8, Parity: 'N', StopBits: 1}
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return c, fmt.Errorf("invalid serial port %q (expected PORT[:BAUD[:FRAMING]], e.g. COM3:115200)", spec)
+	}
+	c.Port = parts[0]
+	var err error
+	if len(parts) > 1 {
+		if c.Baud, err = parseBaud(parts[1]); err != nil {
+			return c, err
+		}
+	}
+	if len(parts) > 2 {
+		if err := c.setFraming(parts[2]); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func parseBaud(s string) (int, error) {
+	baud, err := strconv.Atoi(s)
+	if err != nil || baud <= 0 {
+		return 0, fmt.Errorf("invalid baud rate %q", s)
+	}
+	return baud, nil
+}
+
+// setFraming sets data bits, parity and stop bits from e.g. "8N1".
+func (c *SerialConfig) setFraming(s string) error {
+	f := strings.ToUpper(s)
+	if len(f) != 3 || f[0] < '5' || f[0] > '8' || !strings.ContainsRune("NEO", rune(f[1])) || (f[2] != '1' && f[2] != '2') {
+		return fmt.Errorf("invalid framing %q (expected data bits, parity and stop bits, e.g. 8N1)", s)
+	}
+	c.DataBits = int(f[0] - '0')
+	c.Parity = f[1]
+	c.StopBits = int(f[2] - '0')
+	return nil
+}
+
+// String formats c as ParseSerial accepts it.
+func (c SerialConfig) String() string {
+	return fmt.Sprintf("%s:%d:%d%c%d", c.Port, c.Baud, c.DataBits, c.Parity, c.StopBits)
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+
+package pty
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// SerialPort is a serial device used as a pane's terminal. There is no
+// process behind it: Wait returns when the port is closed or fails.
+type SerialPort struct {
+	f         *os.File
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenSerial opens the device at c.Port. Line settings are left as they
+// are; set them with stty.
+func OpenSerial(c SerialConfig) (Terminal, error) {
+	f, err := os.OpenFile(c.Port, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &SerialPort{f: f, closed: make(chan struct{})}, nil
+}
+
+// Read ends the port's "process" when the device fails, e.g. when a USB
+// adapter is unplugged.
+func (s *SerialPort) Read(buf []byte) (int, error) {
+	n, err := s.f.Read(buf)
+	if err != nil {
+		if errors.Is(err, os.ErrClosed) {
+			err = io.EOF
+		}
+		s.Close()
+	}
+	return n, err
+}
+
+func (s *SerialPort) Write(data []byte) (int, error) { return s.f.Write(data) }
+func (s *SerialPort) Resize(cols, rows int) error    { return nil }
+func (s *SerialPort) Wait() error                    { <-s.closed; return nil }
+func (s *SerialPort) ExitCode() int                  { return 0 }
+func (s *SerialPort) Pid() int                       { return 0 }
+
+// Backend reports "serial".
+func (s *SerialPort) Backend() string { return "serial" }
+
+func (s *SerialPort) Close() error {
+	s.closeOnce.Do(func() {
+		s.f.Close()
+		close(s.closed)
+	})
+	return nil
+}
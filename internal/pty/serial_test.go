@@ -0,0 +1,26 @@
+package pty
+
+import "testing"
+
+func TestParseSerial(t *testing.T) {
+	for spec, want := range map[string]string{
+		"COM3":               "COM3:9600:8N1",
+		"COM3:115200":        "COM3:115200:8N1",
+		"COM10:9600:7e2":     "COM10:9600:7E2",
+		"/dev/ttyUSB0:57600": "/dev/ttyUSB0:57600:8N1",
+	} {
+		c, err := ParseSerial(spec)
+		if err != nil {
+			t.Errorf("ParseSerial(%q): %v", spec, err)
+			continue
+		}
+		if c.String() != want {
+			t.Errorf("ParseSerial(%q) = %s, want %s", spec, c, want)
+		}
+	}
+	for _, bad := range []string{"", ":9600", "COM3:fast", "COM3:0", "COM3:9600:8X1", "COM3:9600:9N1", "COM3:9600:8N1:x"} {
+		if _, err := ParseSerial(bad); err == nil {
+			t.Errorf("ParseSerial(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+//go:build windows
+
+package pty
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetCommState    = kernel32.NewProc("GetCommState")
+	procSetCommState    = kernel32.NewProc("SetCommState")
+	procSetCommTimeouts = kernel32.NewProc("SetCommTimeouts")
+)
+
+// dcb is the Win32 DCB structure; flags holds its bit fields.
+type dcb struct {
+	length    uint32
+	baudRate  uint32
+	flags     uint32
+	reserved  uint16
+	xonLim    uint16
+	xoffLim   uint16
+	byteSize  byte
+	parity    byte
+	stopBits  byte
+	xonChar   byte
+	xoffChar  byte
+	errorChar byte
+	eofChar   byte
+	evtChar   byte
+	reserved1 uint16
+}
+
+type commTimeouts struct {
+	readInterval         uint32
+	readTotalMultiplier  uint32
+	readTotalConstant    uint32
+	writeTotalMultiplier uint32
+	writeTotalConstant   uint32
+}
+
+const (
+	_DCB_BINARY     = 0x1
+	_DCB_DTR_ENABLE = 0x1 << 4
+	_DCB_RTS_ENABLE = 0x1 << 12
+	_TWOSTOPBITS    = 2
+	_MAXDWORD       = 0xFFFFFFFF
+	serialReadPoll  = 100 // ms a read waits for the first byte
+)
+
+// SerialPort is a COM port used as a pane's terminal. There is no
+// process behind it: Wait returns when the port is closed or fails.
+type SerialPort struct {
+	h         syscall.Handle
+	closed    chan struct{}
+	closing   atomic.Bool
+	closeOnce sync.Once
+}
+
+// OpenSerial opens c.Port (COM3, or \\.\COM10 and above) with c's line
+// settings, DTR and RTS raised and no flow control.
+func OpenSerial(c SerialConfig) (Terminal, error) {
+	path := c.Port
+	if !strings.HasPrefix(path, `\\.\`) {
+		path = `\\.\` + path
+	}
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", c.Port, err)
+	}
+
+	var d dcb
+	d.length = uint32(unsafe.Sizeof(d))
+	if r1, _, err := procGetCommState.Call(uintptr(h), uintptr(unsafe.Pointer(&d))); r1 == 0 {
+		syscall.CloseHandle(h)
+		return nil, fmt.Errorf("GetCommState: %v", err)
+	}
+	d.baudRate = uint32(c.Baud)
+	d.flags = _DCB_BINARY | _DCB_DTR_ENABLE | _DCB_RTS_ENABLE
+	d.byteSize = byte(c.DataBits)
+	d.parity = map[byte]byte{'N': 0, 'O': 1, 'E': 2}[c.Parity]
+	d.stopBits = 0
+	if c.StopBits == 2 {
+		d.stopBits = _TWOSTOPBITS
+	}
+	if r1, _, err := procSetCommState.Call(uintptr(h), uintptr(unsafe.Pointer(&d))); r1 == 0 {
+		syscall.CloseHandle(h)
+		return nil, fmt.Errorf("SetCommState (%s): %v", c, err)
+	}
+
+	// Return as soon as any byte arrives, or after serialReadPoll with
+	// none, so Close never waits long on a blocked read.
+	t := commTimeouts{
+		readInterval:        _MAXDWORD,
+		readTotalMultiplier: _MAXDWORD,
+		readTotalConstant:   serialReadPoll,
+	}
+	if r1, _, err := procSetCommTimeouts.Call(uintptr(h), uintptr(unsafe.Pointer(&t))); r1 == 0 {
+		syscall.CloseHandle(h)
+		return nil, fmt.Errorf("SetCommTimeouts: %v", err)
+	}
+	return &SerialPort{h: h, closed: make(chan struct{})}, nil
+}
+
+// Read waits for data, polling so that Close is noticed, and ends the
+// port's "process" when the device fails, e.g. when a USB adapter is
+// unplugged.
+func (s *SerialPort) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	for {
+		if s.closing.Load() {
+			return 0, io.EOF
+		}
+		var n uint32
+		if err := syscall.ReadFile(s.h, buf, &n, nil); err != nil {
+			if s.closing.Load() {
+				return 0, io.EOF
+			}
+			s.Close()
+			return int(n), fmt.Errorf("ReadFile: %w", err)
+		}
+		if n > 0 {
+			return int(n), nil
+		}
+	}
+}
+
+func (s *SerialPort) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var n uint32
+	if err := syscall.WriteFile(s.h, data, &n, nil); err != nil {
+		if s.closing.Load() {
+			return int(n), errors.New("serial port closed")
+		}
+		return int(n), fmt.Errorf("WriteFile: %w", err)
+	}
+	return int(n), nil
+}
+
+func (s *SerialPort) Resize(cols, rows int) error { return nil }
+func (s *SerialPort) Wait() error                 { <-s.closed; return nil }
+func (s *SerialPort) ExitCode() int               { return 0 }
+func (s *SerialPort) Pid() int                    { return 0 }
+
+// Backend reports "serial".
+func (s *SerialPort) Backend() string { return "serial" }
+
+func (s *SerialPort) Close() error {
+	s.closeOnce.Do(func() {
+		s.closing.Store(true)
+		syscall.CloseHandle(s.h)
+		close(s.closed)
+	})
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package pty
+
+// containerShell is the shell a container pane without a command starts.
+const containerShell = "sh"
+
+// quoteArg quotes s as one word for the shell that runs commands.
+func quoteArg(s string) string {
+	return posixQuote(s)
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package pty
+
+import "syscall"
+
+// containerShell is the shell a container pane without a command starts;
+// Windows containers have cmd.exe.
+const containerShell = "cmd.exe"
+
+// quoteArg quotes s as one argument of a process command line.
+func quoteArg(s string) string {
+	return syscall.EscapeArg(s)
+}
@@ -0,0 +1,264 @@
+//go:build windows
+
+package pty
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// winpty.dll and its winpty-agent.exe are not part of Windows; they are
+// found next to wintmux.exe or on PATH.
+var (
+	winptyDLL                 = syscall.NewLazyDLL("winpty.dll")
+	procWinptyConfigNew       = winptyDLL.NewProc("winpty_config_new")
+	procWinptyConfigFree      = winptyDLL.NewProc("winpty_config_free")
+	procWinptyConfigSetSize   = winptyDLL.NewProc("winpty_config_set_initial_size")
+	procWinptyOpen            = winptyDLL.NewProc("winpty_open")
+	procWinptyConinName       = winptyDLL.NewProc("winpty_conin_name")
+	procWinptyConoutName      = winptyDLL.NewProc("winpty_conout_name")
+	procWinptySpawnConfigNew  = winptyDLL.NewProc("winpty_spawn_config_new")
+	procWinptySpawnConfigFree = winptyDLL.NewProc("winpty_spawn_config_free")
+	procWinptySpawn           = winptyDLL.NewProc("winpty_spawn")
+	procWinptySetSize         = winptyDLL.NewProc("winpty_set_size")
+	procWinptyFree            = winptyDLL.NewProc("winpty_free")
+	procWinptyErrorMsg        = winptyDLL.NewProc("winpty_error_msg")
+	procWinptyErrorFree       = winptyDLL.NewProc("winpty_error_free")
+	procGetProcessId          = kernel32.NewProc("GetProcessId")
+)
+
+const (
+	_WINPTY_FLAG_COLOR_ESCAPES       = 0x4
+	_WINPTY_SPAWN_FLAG_AUTO_SHUTDOWN = 0x1
+)
+
+// is64bit is set where a UINT64 argument takes one word; on 386 it takes
+// two, low word first.
+const is64bit = unsafe.Sizeof(uintptr(0)) == 8
+
+// loadWinpty reports whether winpty.dll can be loaded.
+func loadWinpty() error {
+	if err := winptyDLL.Load(); err != nil {
+		return fmt.Errorf("winpty.dll not found (put winpty.dll and winpty-agent.exe next to wintmux.exe or on PATH)")
+	}
+	return nil
+}
+
+// WinPTY runs a process on a hidden console owned by winpty's agent,
+// which scrapes the console and renders it as escape sequences. It serves
+// systems where ConPTY is missing or misbehaves. The agent shuts down
+// once the process has exited and its output has been delivered, which
+// closes the output pipe.
+type WinPTY struct {
+	wp        uintptr // winpty_t*
+	conin     syscall.Handle
+	conout    syscall.Handle
+	process   syscall.Handle
+	pid       int
+	exited    chan struct{}
+	exitCode  uint32
+	closeOnce sync.Once
+}
+
+// newWinPTY starts command in workdir on a new winpty console.
+func newWinPTY(cols, rows int, command string, workdir string, env []string) (Terminal, error) {
+	if err := loadWinpty(); err != nil {
+		return nil, err
+	}
+
+	var werr uintptr
+	var cfg uintptr
+	if is64bit {
+		cfg, _, _ = procWinptyConfigNew.Call(_WINPTY_FLAG_COLOR_ESCAPES, uintptr(unsafe.Pointer(&werr)))
+	} else {
+		cfg, _, _ = procWinptyConfigNew.Call(_WINPTY_FLAG_COLOR_ESCAPES, 0, uintptr(unsafe.Pointer(&werr)))
+	}
+	if cfg == 0 {
+		return nil, winptyError("winpty_config_new", werr)
+	}
+	procWinptyConfigSetSize.Call(cfg, uintptr(cols), uintptr(rows))
+	wp, _, _ := procWinptyOpen.Call(cfg, uintptr(unsafe.Pointer(&werr)))
+	procWinptyConfigFree.Call(cfg)
+	if wp == 0 {
+		return nil, winptyError("winpty_open", werr)
+	}
+
+	w := &WinPTY{wp: wp, exited: make(chan struct{})}
+	fail := func(err error) (Terminal, error) {
+		w.release()
+		return nil, err
+	}
+	var err error
+	if w.conin, err = openWinptyPipe(procWinptyConinName, syscall.GENERIC_WRITE); err != nil {
+		return fail(fmt.Errorf("open winpty input: %w", err))
+	}
+	if w.conout, err = openWinptyPipe(procWinptyConoutName, syscall.GENERIC_READ); err != nil {
+		return fail(fmt.Errorf("open winpty output: %w", err))
+	}
+
+	cmdLine, err := syscall.UTF16PtrFromString(command)
+	if err != nil {
+		return fail(err)
+	}
+	var workdirPtr, envBlock *uint16
+	if workdir != "" {
+		if workdirPtr, err = syscall.UTF16PtrFromString(workdir); err != nil {
+			return fail(err)
+		}
+	}
+	if len(env) > 0 {
+		envBlock = makeEnvBlock(MergeEnv(env))
+	}
+
+	var spawnCfg uintptr
+	if is64bit {
+		spawnCfg, _, _ = procWinptySpawnConfigNew.Call(_WINPTY_SPAWN_FLAG_AUTO_SHUTDOWN,
+			0, uintptr(unsafe.Pointer(cmdLine)), uintptr(unsafe.Pointer(workdirPtr)), uintptr(unsafe.Pointer(envBlock)),
+			uintptr(unsafe.Pointer(&werr)))
+	} else {
+		spawnCfg, _, _ = procWinptySpawnConfigNew.Call(_WINPTY_SPAWN_FLAG_AUTO_SHUTDOWN, 0,
+			0, uintptr(unsafe.Pointer(cmdLine)), uintptr(unsafe.Pointer(workdirPtr)), uintptr(unsafe.Pointer(envBlock)),
+			uintptr(unsafe.Pointer(&werr)))
+	}
+	if spawnCfg == 0 {
+		return fail(winptyError("winpty_spawn_config_new", werr))
+	}
+	var process, thread syscall.Handle
+	var createErr uint32
+	ok, _, _ := procWinptySpawn.Call(wp, spawnCfg,
+		uintptr(unsafe.Pointer(&process)), uintptr(unsafe.Pointer(&thread)),
+		uintptr(unsafe.Pointer(&createErr)), uintptr(unsafe.Pointer(&werr)))
+	procWinptySpawnConfigFree.Call(spawnCfg)
+	if ok == 0 {
+		if createErr != 0 {
+			if werr != 0 {
+				procWinptyErrorFree.Call(werr)
+			}
+			return fail(fmt.Errorf("CreateProcess: %v", syscall.Errno(createErr)))
+		}
+		return fail(winptyError("winpty_spawn", werr))
+	}
+	if thread != 0 {
+		syscall.CloseHandle(thread)
+	}
+	pid, _, _ := procGetProcessId.Call(uintptr(process))
+	w.process = process
+	w.pid = int(pid)
+	go w.watchProcess()
+	return w, nil
+}
+
+// openWinptyPipe opens the agent's named pipe whose name nameProc returns.
+func openWinptyPipe(nameProc *syscall.LazyProc, access uint32) (syscall.Handle, error) {
+	name, _, _ := nameProc.Call()
+	return syscall.CreateFile((*uint16)(*(*unsafe.Pointer)(unsafe.Pointer(&name))),
+		access, 0, nil, syscall.OPEN_EXISTING, 0, 0)
+}
+
+// winptyError turns a winpty_error_ptr_t into an error and frees it.
+func winptyError(what string, werr uintptr) error {
+	if werr == 0 {
+		return fmt.Errorf("%s failed", what)
+	}
+	defer procWinptyErrorFree.Call(werr)
+	msg, _, _ := procWinptyErrorMsg.Call(werr)
+	if msg == 0 {
+		return fmt.Errorf("%s failed", what)
+	}
+	return fmt.Errorf("%s: %s", what, wideString(msg))
+}
+
+// wideString copies the NUL-terminated UTF-16 string at p.
+func wideString(p uintptr) string {
+	ptr := *(*unsafe.Pointer)(unsafe.Pointer(&p))
+	var s []uint16
+	for i := uintptr(0); ; i += 2 {
+		c := *(*uint16)(unsafe.Add(ptr, i))
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+	return syscall.UTF16ToString(s)
+}
+
+func (w *WinPTY) watchProcess() {
+	syscall.WaitForSingleObject(w.process, syscall.INFINITE)
+	var code uint32
+	syscall.GetExitCodeProcess(w.process, &code)
+	w.exitCode = code
+	close(w.exited)
+}
+
+// Read blocks in ReadFile. The agent closes the pipe when it shuts down
+// after the process exits, so the final output is never cut short.
+func (w *WinPTY) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	var n uint32
+	if err := syscall.ReadFile(w.conout, buf, &n, nil); err != nil {
+		return int(n), fmt.Errorf("ReadFile: %w", err)
+	}
+	return int(n), nil
+}
+
+func (w *WinPTY) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var n uint32
+	if err := syscall.WriteFile(w.conin, data, &n, nil); err != nil {
+		return int(n), fmt.Errorf("WriteFile: %w", err)
+	}
+	return int(n), nil
+}
+
+func (w *WinPTY) Resize(cols, rows int) error {
+	var werr uintptr
+	ok, _, _ := procWinptySetSize.Call(w.wp, uintptr(cols), uintptr(rows), uintptr(unsafe.Pointer(&werr)))
+	if ok == 0 {
+		return winptyError("winpty_set_size", werr)
+	}
+	return nil
+}
+
+func (w *WinPTY) Wait() error {
+	<-w.exited
+	return nil
+}
+
+func (w *WinPTY) ExitCode() int { return int(w.exitCode) }
+
+func (w *WinPTY) Pid() int { return w.pid }
+
+// Backend reports "winpty".
+func (w *WinPTY) Backend() string { return "winpty" }
+
+// Close terminates the process, shuts the agent down and releases all
+// handles. Safe to call multiple times.
+func (w *WinPTY) Close() error {
+	w.closeOnce.Do(func() {
+		procTerminateProcess.Call(uintptr(w.process), 1)
+		w.release()
+		// watchProcess waits on the handle and reads the exit code from
+		// it; the process is terminated, so it finishes soon.
+		<-w.exited
+		syscall.CloseHandle(w.process)
+	})
+	return nil
+}
+
+// release frees the agent, which breaks its pipes and so ends a Read in
+// progress, then closes our ends.
+func (w *WinPTY) release() {
+	procWinptyFree.Call(w.wp)
+	if w.conin != 0 {
+		syscall.CloseHandle(w.conin)
+	}
+	if w.conout != 0 {
+		syscall.CloseHandle(w.conout)
+	}
+}
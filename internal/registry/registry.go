@@ -0,0 +1,156 @@
+// Package registry keeps a per-user list of running wintmux daemons, so
+// sessions can be found without knowing their -S socket path. Each
+// daemon owns one JSON file named after its PID in the registry
+// directory; there is no shared file to lock or corrupt.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"wintmux/internal/ipc"
+)
+
+// Entry describes one running daemon.
+type Entry struct {
+	Session string    `json:"session"`
+	Socket  string    `json:"socket"` // absolute control-file path
+	PID     int       `json:"pid"`
+	Port    int       `json:"port"`
+	Workdir string    `json:"workdir"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// probeTimeout bounds the liveness check of one entry.
+const probeTimeout = 500 * time.Millisecond
+
+// Dir returns the registry directory: $WINTMUX_REGISTRY_DIR if set,
+// otherwise wintmux/sessions under the user cache directory
+// (%LocalAppData% on Windows).
+func Dir() (string, error) {
+	if dir := os.Getenv("WINTMUX_REGISTRY_DIR"); dir != "" {
+		return dir, nil
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "wintmux", "sessions"), nil
+}
+
+// Register records e, replacing any entry with the same PID.
+func Register(e Entry) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename, so List never sees a half-written entry.
+	tmp := filepath.Join(dir, fmt.Sprintf(".%d.tmp", e.PID))
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, entryPath(dir, e.PID))
+}
+
+// Unregister removes the entry for pid, if any.
+func Unregister(pid int) error {
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(entryPath(dir, pid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns the live entries sorted by session name, removing entries
+// whose daemon has gone away (crashed or killed without cleaning up).
+func List() ([]Entry, error) {
+	live, _, err := Scan()
+	return live, err
+}
+
+// Scan is List that also returns the entries it removed, the stale ones,
+// sorted the same way.
+func Scan() (live, stale []Entry, err error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			os.Remove(path)
+			continue
+		}
+		if !alive(e) {
+			os.Remove(path)
+			stale = append(stale, e)
+			continue
+		}
+		live = append(live, e)
+	}
+	sortEntries(live)
+	sortEntries(stale)
+	return live, stale, nil
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Session != entries[j].Session {
+			return entries[i].Session < entries[j].Session
+		}
+		return entries[i].Socket < entries[j].Socket
+	})
+}
+
+// alive reports whether the daemon described by e is still serving its
+// socket: the control file must still name it and its port must accept
+// connections. PIDs are reused, so the PID alone proves nothing.
+func alive(e Entry) bool {
+	info, err := ipc.ReadControlFile(e.Socket)
+	if err != nil || info.PID != e.PID || info.Port != e.Port {
+		return false
+	}
+	conn, err := ipc.Dial(info, probeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func entryPath(dir string, pid int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.json", pid))
+}
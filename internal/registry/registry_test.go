@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"wintmux/internal/ipc"
+)
+
+// fakeDaemon listens on a port and writes a control file for it, as a
+// real daemon does.
+func fakeDaemon(t *testing.T, dir string, pid int) Entry {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	port := ln.Addr().(*net.TCPAddr).Port
+	socket := filepath.Join(dir, "s.sock")
+	data, _ := json.Marshal(ipc.ControlInfo{Port: port, PID: pid})
+	if err := os.WriteFile(socket, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Entry{Session: "agent", Socket: socket, PID: pid, Port: port}
+}
+
+func TestRegisterListUnregister(t *testing.T) {
+	t.Setenv("WINTMUX_REGISTRY_DIR", filepath.Join(t.TempDir(), "reg"))
+	e := fakeDaemon(t, t.TempDir(), 4242)
+	if err := Register(e); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	got, err := List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != 1 || got[0].Session != "agent" || got[0].PID != 4242 {
+		t.Fatalf("unexpected entries %+v", got)
+	}
+	if err := Unregister(4242); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	if got, _ := List(); len(got) != 0 {
+		t.Errorf("expected no entries, got %+v", got)
+	}
+}
+
+func TestListPrunesDeadDaemons(t *testing.T) {
+	reg := filepath.Join(t.TempDir(), "reg")
+	t.Setenv("WINTMUX_REGISTRY_DIR", reg)
+	// The control file now names a different daemon, as after a crash
+	// and a new session on the same socket path.
+	e := fakeDaemon(t, t.TempDir(), 1)
+	stale := e
+	stale.PID = 2
+	if err := Register(stale); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := List(); len(got) != 0 {
+		t.Errorf("expected stale entry to be dropped, got %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(reg, "2.json")); !os.IsNotExist(err) {
+		t.Errorf("expected stale entry file to be removed, stat err = %v", err)
+	}
+}
+
+func TestScanReportsStaleOnce(t *testing.T) {
+	t.Setenv("WINTMUX_REGISTRY_DIR", filepath.Join(t.TempDir(), "reg"))
+	e := fakeDaemon(t, t.TempDir(), 1)
+	stale := e
+	stale.PID = 2
+	if err := Register(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := Register(stale); err != nil {
+		t.Fatal(err)
+	}
+	live, dead, err := Scan()
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(live) != 1 || live[0].PID != 1 || len(dead) != 1 || dead[0].PID != 2 {
+		t.Fatalf("expected pid 1 live and pid 2 stale, got %+v and %+v", live, dead)
+	}
+	if _, dead, _ := Scan(); len(dead) != 0 {
+		t.Errorf("expected the stale entry to be reported once, got %+v", dead)
+	}
+}
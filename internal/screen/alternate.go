@@ -0,0 +1,81 @@
+package screen
+
+// setAlternate switches to or from the alternate screen for DECSET or
+// DECRST of mode 47, 1047 or 1049, as xterm does. The main screen is left
+// as it was, cursor included. 1049 also saves the cursor on entering and
+// restores it on leaving (DECSC, DECRC); 1047 and 1049 clear the
+// alternate screen, 47 keeps what it showed last time. The cursor keeps
+// its position across the switch.
+func (s *Screen) setAlternate(mode int, set bool) {
+	if set == s.inAlt {
+		return
+	}
+	s.altSwitches++
+	if set {
+		if mode == 1049 {
+			s.main.savedRow, s.main.savedCol = s.main.row, s.main.col
+		}
+		if mode != 47 {
+			s.alt = newGrid(s.cols, s.rows)
+		}
+		s.alt.row, s.alt.col = s.main.row, s.main.col
+		s.inAlt = true
+		return
+	}
+	s.inAlt = false
+	if mode == 1049 {
+		s.main.row, s.main.col = s.main.savedRow, s.main.savedCol
+	}
+}
+
+// CaptureMain is Capture of the main screen while an application has the
+// alternate screen (tmux's capture-pane -a); false when it does not.
+func (s *Screen) CaptureMain(maxLines int) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.inAlt {
+		return nil, false
+	}
+	return s.captureGrid(&s.main, maxLines, false), true
+}
+
+// WriteMain is Write that returns the part of data the main screen got:
+// output written while the alternate screen is on is left out, except
+// the escape sequences that switch to and from it. It is what belongs in
+// the history, which a full-screen application's frames would bury.
+// Escape sequences are returned whole, so a sequence split between two
+// writes is returned by the second.
+func (s *Screen) WriteMain(data []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	main := make([]byte, 0, len(data))
+	s.write(data, &main)
+	return main
+}
+
+// keepMain appends b, bytes the screen just processed, to *main if they
+// belong to the main screen. inSeq and inAlt are the parser and screen
+// state before b.
+func (s *Screen) keepMain(main *[]byte, b []byte, inSeq, inAlt bool) {
+	if main == nil {
+		return
+	}
+	switch {
+	case !inSeq && s.pState == psNorm:
+		if !s.inAlt {
+			*main = append(*main, b...)
+		}
+	case !inSeq:
+		s.seq, s.seqAlt = append(s.seq[:0], b...), inAlt
+	case s.pState != psNorm:
+		if len(s.seq) < maxOSCLen {
+			s.seq = append(s.seq, b...)
+		}
+	default:
+		s.seq = append(s.seq, b...)
+		if !s.seqAlt || !s.inAlt {
+			*main = append(*main, s.seq...)
+		}
+		s.seq = s.seq[:0]
+	}
+}
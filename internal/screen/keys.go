@@ -0,0 +1,71 @@
+package screen
+
+import (
+	"strconv"
+	"strings"
+
+	"wintmux/internal/vt"
+)
+
+// maxKeyModes bounds the kitty keyboard protocol's stack of modes; the
+// oldest entry is dropped when a push would exceed it, as kitty does.
+const maxKeyModes = 16
+
+// execKeyMode handles the keyboard protocol requests: CSI > 4 ; n m
+// (xterm modifyOtherKeys) and the kitty protocol's CSI > flags u (push),
+// CSI < n u (pop) and CSI = flags ; how u (set, add or remove flags).
+// Queries (CSI ? u) are left for the terminal in front to answer.
+func (s *Screen) execKeyMode(final, marker byte, params string) {
+	args := strings.Split(params, ";")
+	arg := func(i, def int) int {
+		if i >= len(args) || args[i] == "" {
+			return def
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			return def
+		}
+		return n
+	}
+	switch {
+	case marker == '>' && final == 'm':
+		if arg(0, 0) == 4 {
+			s.modifyOtherKeys = clamp(arg(1, 0), 0, 2)
+		}
+	case marker == '>' && final == 'u':
+		if len(s.kittyKeys) == maxKeyModes {
+			s.kittyKeys = s.kittyKeys[1:]
+		}
+		s.kittyKeys = append(s.kittyKeys, arg(0, 0))
+	case marker == '<' && final == 'u':
+		n := clamp(arg(0, 1), 0, len(s.kittyKeys))
+		s.kittyKeys = s.kittyKeys[:len(s.kittyKeys)-n]
+	case marker == '=' && final == 'u':
+		if len(s.kittyKeys) == 0 {
+			s.kittyKeys = append(s.kittyKeys, 0)
+		}
+		top := &s.kittyKeys[len(s.kittyKeys)-1]
+		switch flags := arg(0, 0); arg(1, 1) {
+		case 1:
+			*top = flags
+		case 2:
+			*top |= flags
+		case 3:
+			*top &^= flags
+		}
+	}
+}
+
+// KeyMode returns the keyboard protocol and cursor and keypad modes the
+// application has asked for, which keys sent to it are encoded for, and
+// its mouse and bracketed paste modes.
+func (s *Screen) KeyMode() vt.KeyMode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := vt.KeyMode{ModifyOtherKeys: s.modifyOtherKeys, CursorKeys: s.cursorKeys, Keypad: s.keypad,
+		Mouse: s.mouse, MouseFormat: s.mouseFormat, BracketedPaste: s.bracketedPaste}
+	if n := len(s.kittyKeys); n > 0 {
+		m.Kitty = s.kittyKeys[n-1]
+	}
+	return m
+}
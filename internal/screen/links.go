@@ -0,0 +1,171 @@
+package screen
+
+import "strings"
+
+// maxLinks is the size the hyperlink table may grow to before links no
+// longer on screen are dropped from it.
+const maxLinks = 1024
+
+// Link is an OSC 8 hyperlink visible on the screen: one run of cells the
+// application linked to URI. X and Y are the cell of its first character;
+// ID is the id= parameter, which marks runs (say, on several rows) as one
+// link.
+type Link struct {
+	URI    string
+	ID     string
+	Params string // all parameters, "key=value" pairs separated by ':'
+	X, Y   int
+	Text   string
+}
+
+// link is an entry of the hyperlink table; cells refer to it by index.
+type link struct {
+	uri, params string
+}
+
+// setLink handles the arguments of OSC 8: "<params>;<URI>" starts a
+// hyperlink for the text written after it, and an empty URI ends it.
+func (s *Screen) setLink(arg string) {
+	params, uri, ok := strings.Cut(arg, ";")
+	if !ok || uri == "" || len(arg)+2 >= maxOSCLen {
+		s.curLink = 0
+		return
+	}
+	if len(s.links) == 0 {
+		s.links = []link{{}} // index 0 is no link
+	}
+	if n := len(s.links) - 1; n > 0 && s.links[n] == (link{uri, params}) {
+		s.curLink = uint16(n)
+		return
+	}
+	if len(s.links) >= maxLinks {
+		s.compactLinks()
+	}
+	if len(s.links) >= 1<<16 {
+		s.curLink = 0
+		return
+	}
+	s.links = append(s.links, link{uri, params})
+	s.curLink = uint16(len(s.links) - 1)
+}
+
+// compactLinks drops the table entries no cell refers to any more and
+// renumbers the rest.
+func (s *Screen) compactLinks() {
+	used := make([]bool, len(s.links))
+	used[s.curLink] = true
+	for _, g := range []*gridState{&s.main, &s.alt} {
+		for _, row := range g.links {
+			for _, id := range row {
+				used[id] = true
+			}
+		}
+	}
+	renum := make([]uint16, len(s.links))
+	kept := s.links[:1]
+	for i := 1; i < len(s.links); i++ {
+		if used[i] {
+			renum[i] = uint16(len(kept))
+			kept = append(kept, s.links[i])
+		}
+	}
+	s.links = kept
+	s.curLink = renum[s.curLink]
+	for _, g := range []*gridState{&s.main, &s.alt} {
+		for _, row := range g.links {
+			for i, id := range row {
+				row[i] = renum[id]
+			}
+		}
+	}
+}
+
+// markLink records the current hyperlink, or none, for w cells at col.
+func (s *Screen) markLink(g *gridState, col, w int) {
+	row := g.links[g.row]
+	if row == nil {
+		if s.curLink == 0 {
+			return
+		}
+		row = make([]uint16, s.cols)
+		g.links[g.row] = row
+	}
+	for i := col; i < col+w && i < len(row); i++ {
+		row[i] = s.curLink
+	}
+}
+
+// linkedText renders cells as rowText does, with OSC 8 sequences around
+// the hyperlinked runs; ids holds the cells' links.
+func (s *Screen) linkedText(cells []rune, ids []uint16) string {
+	var b strings.Builder
+	var open uint16
+	for i, r := range cells {
+		if r == tabCell {
+			r = ' '
+		}
+		if r == wideTail {
+			if i == 0 || cells[i-1] == ' ' || cells[i-1] == wideTail {
+				r = ' '
+			} else {
+				continue
+			}
+		}
+		if id := ids[i]; id != open {
+			if open != 0 {
+				b.WriteString("\x1b]8;;\x1b\\")
+			}
+			if id != 0 {
+				l := s.links[id]
+				b.WriteString("\x1b]8;" + l.params + ";" + l.uri + "\x1b\\")
+			}
+			open = id
+		}
+		b.WriteRune(r)
+	}
+	if open != 0 {
+		b.WriteString("\x1b]8;;\x1b\\")
+	}
+	return b.String()
+}
+
+// Links returns the hyperlinks on the screen, in reading order.
+func (s *Screen) Links() []Link {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g := s.st()
+	var list []Link
+	for y, ids := range g.links {
+		for x := 0; x < len(ids); {
+			id := ids[x]
+			if id == 0 {
+				x++
+				continue
+			}
+			start := x
+			for x < len(ids) && ids[x] == id {
+				x++
+			}
+			l := s.links[id]
+			list = append(list, Link{
+				URI:    l.uri,
+				ID:     linkID(l.params),
+				Params: l.params,
+				X:      start,
+				Y:      y,
+				Text:   strings.TrimSpace(rowText(g.grid[y][start:x])),
+			})
+		}
+	}
+	return list
+}
+
+// linkID returns the id= parameter of OSC 8 params.
+func linkID(params string) string {
+	for _, p := range strings.Split(params, ":") {
+		if v, ok := strings.CutPrefix(p, "id="); ok {
+			return v
+		}
+	}
+	return ""
+}
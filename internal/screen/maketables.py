@@ -0,0 +1,57 @@
+#!/usr/bin/env python3
+"""Generates tables.go, the East Asian Width tables, from Python's unicodedata.
+
+Run from this directory: python3 maketables.py > tables.go && gofmt -w tables.go
+"""
+
+import sys
+import unicodedata
+
+# Marks and format characters take no cell of their own, whatever their
+# East Asian Width says; unassigned code points stay narrow.
+SKIP = {"Mn", "Me", "Cf", "Cc", "Cn"}
+
+
+def ranges(want):
+    out = []
+    for cp in range(0x110000):
+        c = chr(cp)
+        if unicodedata.category(c) in SKIP or unicodedata.east_asian_width(c) not in want:
+            continue
+        if out and out[-1][1] == cp - 1:
+            out[-1][1] = cp
+        else:
+            out.append([cp, cp])
+    return out
+
+
+def table(name, doc, want):
+    rs = ranges(want)
+    print("// %s" % doc)
+    print("var %s = &unicode.RangeTable{" % name)
+    r16 = [r for r in rs if r[1] <= 0xFFFF]
+    r32 = [r for r in rs if r[1] > 0xFFFF]
+    print("\tR16: []unicode.Range16{")
+    for lo, hi in r16:
+        print("\t\t{0x%04x, 0x%04x, 1}," % (lo, hi))
+    print("\t},")
+    if r32:
+        print("\tR32: []unicode.Range32{")
+        for lo, hi in r32:
+            print("\t\t{0x%x, 0x%x, 1}," % (lo, hi))
+        print("\t},")
+    latin = len([r for r in r16 if r[1] <= 0xFF])
+    if latin:
+        print("\tLatinOffset: %d," % latin)
+    print("}")
+    print()
+
+
+print("// Code generated by maketables.py from Python's unicodedata %s; DO NOT EDIT." % unicodedata.unidata_version)
+print()
+print("package screen")
+print()
+print('import "unicode"')
+print()
+table("wideTable", "wideTable holds the East Asian Wide and Fullwidth characters.", {"W", "F"})
+table("ambiguousTable", "ambiguousTable holds the East Asian Ambiguous characters.", {"A"})
@@ -0,0 +1,280 @@
+package screen
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCommandLines bounds the output kept for one command; the last lines
+// are kept.
+const maxCommandLines = 10000
+
+// Finished commands are kept up to maxCommands of them and, counting
+// their output, maxHistoryLines lines; the oldest go first.
+const (
+	maxCommands     = 200
+	maxHistoryLines = 50000
+)
+
+// Command is a shell command delimited by shell integration marks: OSC
+// 133 (FinalTerm, sent by shells set up for Windows Terminal, WezTerm,
+// kitty and others) or OSC 633 (VS Code's shell integration). The shell
+// sends A at the start of the prompt, B where input starts, C where
+// command output starts and D;<exit code> when the command has finished.
+type Command struct {
+	Seq       int      // 1 for the first command that started output
+	Line      string   // the command line, from OSC 633;E or the input area
+	Output    []string // lines printed between C and D, as shown
+	Truncated bool     // earlier output lines were dropped
+	ExitCode  int      // -1 if the shell did not report one
+	Running   bool     // output started and the command has not finished
+	Started   time.Time
+	Ended     time.Time // zero while running
+}
+
+// marks is the shell integration state of a Screen.
+type marks struct {
+	seen               bool      // the shell has sent a mark
+	input              bool      // inputRow and inputCol are set
+	inputRow, inputCol int       // where the command line starts (B)
+	line               string    // from 633;E, for the next command
+	cur                *Command  // the running command
+	outRow, outCol     int       // where the running command's output starts
+	history            []Command // finished commands, oldest first
+	historyLines       int       // output lines in history
+	seq                int
+}
+
+// shellMark handles the argument of an OSC 133 or 633 sequence.
+func (s *Screen) shellMark(arg string) {
+	m := &s.marks
+	g := s.st()
+	kind, rest, _ := strings.Cut(arg, ";")
+	switch kind {
+	case "A": // prompt start; a command without D ended unreported
+		m.seen = true
+		s.finishCommand(-1)
+		m.input = false
+	case "B": // input start
+		m.seen = true
+		m.input = true
+		m.inputRow, m.inputCol = g.row, g.col
+	case "E": // 633: the command line, escaped
+		m.line = unescapeMark(strings.SplitN(rest, ";", 2)[0])
+	case "C": // output start
+		m.seen = true
+		s.finishCommand(-1)
+		line := m.line
+		if line == "" && m.input {
+			line = s.inputLine()
+		}
+		m.seq++
+		m.cur = &Command{Seq: m.seq, Line: line, ExitCode: -1, Running: true, Started: time.Now()}
+		m.outRow, m.outCol = g.row, g.col
+		m.line = ""
+		m.input = false
+	case "D": // finished
+		m.seen = true
+		code := -1
+		if n, err := strconv.Atoi(strings.SplitN(rest, ";", 2)[0]); err == nil {
+			code = n
+		}
+		s.finishCommand(code)
+	}
+}
+
+// inputLine returns the text between the input mark and the cursor,
+// following a command line that wrapped onto further rows.
+func (s *Screen) inputLine() string {
+	m := &s.marks
+	g := s.st()
+	var b strings.Builder
+	for r := m.inputRow; r <= g.row && r < s.rows; r++ {
+		row := g.grid[r]
+		from, to := 0, len(row)
+		if r == m.inputRow {
+			from = min0(m.inputCol, len(row))
+		}
+		if r == g.row && g.col < to {
+			to = g.col
+		}
+		if from < to {
+			b.WriteString(rowText(row[from:to]))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// commandRow adds the cursor's row to the running command's output,
+// before a linefeed moves on from it, and keeps the input mark on its
+// row when the linefeed scrolls.
+func (s *Screen) commandRow() {
+	m := &s.marks
+	if s.inAlt {
+		return
+	}
+	g := s.st()
+	if m.input && g.row == g.scrollBottom && m.inputRow >= g.scrollTop && m.inputRow <= g.scrollBottom {
+		if m.inputRow--; m.inputRow < g.scrollTop {
+			m.inputRow, m.inputCol = g.scrollTop, 0
+		}
+	}
+	if m.cur == nil {
+		return
+	}
+	row := g.grid[g.row]
+	from := 0
+	if g.row == m.outRow {
+		from = min0(m.outCol, len(row))
+		m.outRow = -1
+	}
+	m.cur.Output = append(m.cur.Output, strings.TrimRight(rowText(row[from:]), " "))
+	if over := len(m.cur.Output) - maxCommandLines; over > 0 {
+		m.cur.Output = append(m.cur.Output[:0:0], m.cur.Output[over:]...)
+		m.cur.Truncated = true
+	}
+}
+
+// finishCommand ends the running command, if any, with code. Output
+// left of the cursor on its row belongs to it, as output without a
+// final newline.
+func (s *Screen) finishCommand(code int) {
+	m := &s.marks
+	if m.cur == nil {
+		return
+	}
+	if !s.inAlt {
+		g := s.st()
+		from := 0
+		if g.row == m.outRow {
+			from = m.outCol
+		}
+		if col := min0(g.col, s.cols); col > from {
+			m.cur.Output = append(m.cur.Output, strings.TrimRight(rowText(g.grid[g.row][from:col]), " "))
+		}
+	}
+	// Blank rows around the output are the shell's, e.g. the rest of the
+	// input row when C comes before the newline.
+	for len(m.cur.Output) > 0 && m.cur.Output[0] == "" {
+		m.cur.Output = m.cur.Output[1:]
+	}
+	for n := len(m.cur.Output); n > 0 && m.cur.Output[n-1] == ""; n-- {
+		m.cur.Output = m.cur.Output[:n-1]
+	}
+	m.cur.ExitCode = code
+	m.cur.Running = false
+	m.cur.Ended = time.Now()
+	m.history = append(m.history, *m.cur)
+	m.historyLines += len(m.cur.Output)
+	m.cur = nil
+	drop := 0
+	for n := len(m.history); n-drop > 1 && (n-drop > maxCommands || m.historyLines > maxHistoryLines); drop++ {
+		m.historyLines -= len(m.history[drop].Output)
+	}
+	if drop > 0 {
+		m.history = append(m.history[:0:0], m.history[drop:]...)
+	}
+}
+
+// unescapeMark decodes the \xHH and \\ escapes of an OSC 633 argument.
+func unescapeMark(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == '\\' {
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+			if s[i+1] == 'x' && i+3 < len(s) {
+				if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					b.WriteByte(byte(n))
+					i += 3
+					continue
+				}
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ShellIntegration reports whether the application has sent shell
+// integration marks.
+func (s *Screen) ShellIntegration() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.marks.seen
+}
+
+// LastCommand returns the last finished command, and false if none has
+// finished yet.
+func (s *Screen) LastCommand() (Command, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h := s.marks.history
+	if len(h) == 0 {
+		return Command{}, false
+	}
+	c := h[len(h)-1]
+	c.Output = append([]string(nil), c.Output...)
+	return c, true
+}
+
+// CommandBySeq returns the finished command numbered seq, and false if
+// there is none or it has been dropped from the history.
+func (s *Screen) CommandBySeq(seq int) (Command, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, c := range s.marks.history {
+		if c.Seq == seq {
+			c.Output = append([]string(nil), c.Output...)
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// Commands returns the finished commands still in the history, oldest
+// first, followed by the running one if any. The Output of finished
+// commands is shared and must not be modified.
+func (s *Screen) Commands() []Command {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Command, 0, len(s.marks.history)+1)
+	for _, c := range s.marks.history {
+		c.Output = c.Output[:len(c.Output):len(c.Output)]
+		list = append(list, c)
+	}
+	if s.marks.cur != nil {
+		c := *s.marks.cur
+		c.Output = append([]string(nil), c.Output...)
+		list = append(list, c)
+	}
+	return list
+}
+
+// RunningCommand returns the command whose output is being received, and
+// false if the shell is not running one (or sends no marks).
+func (s *Screen) RunningCommand() (Command, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.marks.cur == nil {
+		return Command{}, false
+	}
+	c := *s.marks.cur
+	c.Output = append([]string(nil), c.Output...)
+	return c, true
+}
+
+// CommandCount returns the number of commands that have started output,
+// including those dropped from the history.
+func (s *Screen) CommandCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.marks.seq
+}
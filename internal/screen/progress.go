@@ -0,0 +1,50 @@
+package screen
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Progress is the task progress an application reports with Windows
+// Terminal's OSC 9;4 sequence (ConEmu's "set progress bar"), as winget,
+// PowerShell and others emit it. State is "" when no progress is shown,
+// else "normal", "error", "indeterminate" or "paused"; Value is a
+// percentage.
+type Progress struct {
+	State string
+	Value int
+}
+
+// progressStates maps OSC 9;4 state numbers to names.
+var progressStates = [...]string{"", "normal", "error", "indeterminate", "paused"}
+
+// setProgress handles the arguments of OSC 9;4: "<state>[;<value>]". An
+// error or paused state without a value keeps the current one.
+func (s *Screen) setProgress(arg string) {
+	st, val, hasVal := strings.Cut(arg, ";")
+	n, err := strconv.Atoi(st)
+	if err != nil || n < 0 || n >= len(progressStates) {
+		return
+	}
+	p := Progress{State: progressStates[n], Value: s.progress.Value}
+	switch {
+	case p.State == "" || p.State == "indeterminate":
+		p.Value = 0
+	case hasVal && val != "":
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return
+		}
+		p.Value = clamp(v, 0, 100)
+	case p.State == "normal":
+		p.Value = 0
+	}
+	s.progress = p
+}
+
+// Progress returns the progress the application last reported.
+func (s *Screen) Progress() Progress {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.progress
+}
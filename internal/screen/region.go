@@ -0,0 +1,57 @@
+package screen
+
+import "strings"
+
+// RegionHistory selects which lines scrolled out of a scroll region
+// (DECSTBM) the screen hands over for the history. Lines scrolled off a
+// full-screen region need no help: they reach the history as written.
+type RegionHistory uint8
+
+const (
+	RegionHistoryOff  RegionHistory = iota // partial regions lose their lines
+	RegionHistoryTmux                      // as tmux: main screen, regions starting at the top row
+	RegionHistoryAll                       // any partial region, on either screen
+)
+
+// maxScrolledOut bounds the lines kept for ScrolledOut between calls; the
+// oldest are dropped first.
+const maxScrolledOut = 10000
+
+// SetRegionHistory sets which scrolled-out region lines are kept.
+func (s *Screen) SetRegionHistory(mode RegionHistory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regionHistory = mode
+	if mode == RegionHistoryOff {
+		s.scrolledOut = nil
+	}
+}
+
+// ScrolledOut returns the region lines kept since the last call, oldest
+// first, as Capture shows them.
+func (s *Screen) ScrolledOut() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := s.scrolledOut
+	s.scrolledOut = nil
+	return lines
+}
+
+// keepScrolled saves the n lines a line feed or SU is about to scroll
+// off the top of a partial scroll region, if the mode asks for them.
+func (s *Screen) keepScrolled(n int) {
+	g := s.st()
+	if s.regionHistory == RegionHistoryOff || g.scrollTop == 0 && g.scrollBottom == s.rows-1 {
+		return
+	}
+	if s.regionHistory == RegionHistoryTmux && (s.inAlt || g.scrollTop > 0) {
+		return
+	}
+	n = min(n, g.scrollBottom-g.scrollTop+1)
+	for r := g.scrollTop; r < g.scrollTop+n; r++ {
+		if len(s.scrolledOut) == maxScrolledOut {
+			s.scrolledOut = s.scrolledOut[1:]
+		}
+		s.scrolledOut = append(s.scrolledOut, strings.TrimRight(rowText(g.grid[r]), " "))
+	}
+}
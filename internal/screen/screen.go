@@ -5,6 +5,7 @@
 package screen
 
 import (
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,6 +21,30 @@ type Screen struct {
 	main  gridState
 	alt   gridState
 	inAlt bool
+	altSwitches int // times the alternate screen was entered or left
+	seq         []byte // escape sequence being parsed, for WriteMain
+	seqAlt      bool   // the alternate screen was on when seq started
+
+	cursorHidden bool // DECTCEM (mode 25) reset
+	syncUpdate   bool // inside a synchronized update (mode 2026)
+	focusEvents  bool // focus reporting (mode 1004) set
+	bracketedPaste bool // bracketed paste (mode 2004) set
+	mouse        int  // mouse tracking mode (1000, 1002 or 1003) set; 0 if none
+	mouseFormat  int  // mouse report format mode (1005, 1006 or 1015) set; 0 if none
+	cursorKeys   bool // application cursor keys (DECCKM, mode 1) set
+	keypad       bool // application keypad (DECKPAM, ESC =) set
+	modifyOtherKeys int   // xterm modifyOtherKeys level (CSI > 4 ; n m)
+	kittyKeys       []int // kitty keyboard protocol mode stack; top is current
+	cwd          string // last directory reported via OSC 7 / OSC 9;9
+	marks        marks  // shell integration (OSC 133 / 633)
+	progress     Progress // OSC 9;4
+	bells        int      // BEL characters outside escape sequences
+	ambiguousWide bool    // ambiguous-width characters take two cells
+	keepTabs      bool    // captures give back tabs (see SetKeepTabs)
+	links        []link   // OSC 8 hyperlinks cells refer to; 0 is none
+	curLink      uint16   // hyperlink of text written now
+	regionHistory RegionHistory // which region lines scrolledOut keeps
+	scrolledOut   []string      // lines scrolled out of regions, for the history
 
 	pState parserState
 	pBuf   []byte // escape sequence accumulator
@@ -28,6 +53,7 @@ type Screen struct {
 
 type gridState struct {
 	grid                    [][]rune
+	links                   [][]uint16 // hyperlink of each cell; nil rows have none
 	row, col                int
 	scrollTop, scrollBottom int
 	savedRow, savedCol      int
@@ -55,6 +81,7 @@ func New(cols, rows int) *Screen {
 func newGrid(cols, rows int) gridState {
 	g := gridState{
 		grid:         make([][]rune, rows),
+		links:        make([][]uint16, rows),
 		scrollBottom: rows - 1,
 	}
 	for i := range g.grid {
@@ -63,6 +90,24 @@ func newGrid(cols, rows int) gridState {
 	return g
 }
 
+// blankRow replaces row r with an empty one.
+func (g *gridState) blankRow(r, cols int) {
+	g.grid[r] = makeRow(cols)
+	g.links[r] = nil
+}
+
+// clearCells blanks the cells of row r from column from up to to.
+func (g *gridState) clearCells(r, from, to int) {
+	for i := from; i < to; i++ {
+		g.grid[r][i] = ' '
+	}
+	if ids := g.links[r]; ids != nil {
+		for i := from; i < to; i++ {
+			ids[i] = 0
+		}
+	}
+}
+
 func makeRow(cols int) []rune {
 	row := make([]rune, cols)
 	for j := range row {
@@ -82,6 +127,12 @@ func (s *Screen) st() *gridState {
 func (s *Screen) Write(data []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.write(data, nil)
+}
+
+// write is Write, appending to *main the bytes that went to the main
+// screen when main is not nil (see WriteMain).
+func (s *Screen) write(data []byte, main *[]byte) {
 
 	// Prepend any previously buffered incomplete UTF-8 bytes
 	if len(s.uBuf) > 0 {
@@ -93,9 +144,12 @@ func (s *Screen) Write(data []byte) {
 	for i < len(data) {
 		b := data[i]
 
+		inSeq, inAlt := s.pState != psNorm, s.inAlt
+
 		// Inside escape sequence — byte-level parsing (all ASCII)
 		if s.pState != psNorm {
 			s.feedEsc(b)
+			s.keepMain(main, data[i:i+1], inSeq, inAlt)
 			i++
 			continue
 		}
@@ -103,6 +157,7 @@ func (s *Screen) Write(data []byte) {
 		// Control characters
 		if b < 0x20 || b == 0x7f {
 			s.feedCtrl(b)
+			s.keepMain(main, data[i:i+1], inSeq, inAlt)
 			i++
 			continue
 		}
@@ -110,6 +165,7 @@ func (s *Screen) Write(data []byte) {
 		// ASCII printable
 		if b < 0x80 {
 			s.putRune(rune(b))
+			s.keepMain(main, data[i:i+1], inSeq, inAlt)
 			i++
 			continue
 		}
@@ -123,10 +179,12 @@ func (s *Screen) Write(data []byte) {
 				return
 			}
 			// Invalid byte — skip
+			s.keepMain(main, data[i:i+1], inSeq, inAlt)
 			i++
 			continue
 		}
 		s.putRune(r)
+		s.keepMain(main, data[i:i+size], inSeq, inAlt)
 		i += size
 	}
 }
@@ -136,8 +194,22 @@ func (s *Screen) Write(data []byte) {
 func (s *Screen) Capture(maxLines int) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.captureLocked(maxLines, false)
+}
 
-	g := s.st()
+// CaptureLinks is like Capture, but keeps OSC 8 hyperlinks as escape
+// sequences around their text (capture-pane -e).
+func (s *Screen) CaptureLinks(maxLines int) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.captureLocked(maxLines, true)
+}
+
+func (s *Screen) captureLocked(maxLines int, links bool) []string {
+	return s.captureGrid(s.st(), maxLines, links)
+}
+
+func (s *Screen) captureGrid(g *gridState, maxLines int, links bool) []string {
 	n := s.rows
 	if maxLines > 0 && maxLines < n {
 		n = maxLines
@@ -146,22 +218,131 @@ func (s *Screen) Capture(maxLines int) []string {
 	start := s.rows - n
 	lines := make([]string, 0, n)
 	for r := start; r < s.rows; r++ {
-		lines = append(lines, strings.TrimRight(string(g.grid[r]), " "))
+		if !links && s.keepTabs {
+			lines = append(lines, strings.TrimRight(tabText(g.grid[r]), " \t"))
+			continue
+		}
+		if !links || g.links[r] == nil {
+			lines = append(lines, strings.TrimRight(rowText(g.grid[r]), " "))
+			continue
+		}
+		cells := g.grid[r]
+		end := len(cells)
+		for end > 0 && (cells[end-1] == ' ' || cells[end-1] == tabCell) {
+			end--
+		}
+		lines = append(lines, s.linkedText(cells[:end], g.links[r][:end]))
 	}
 	return lines
 }
 
+// CursorState describes the cursor and screen mode at a point in time.
+// X and Y are zero-based, matching tmux's cursor_x/cursor_y formats.
+type CursorState struct {
+	X, Y      int
+	Visible   bool
+	Alternate bool
+}
+
+// Cursor returns the current cursor position and visibility.
+func (s *Screen) Cursor() CursorState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g := s.st()
+	col := g.col
+	if col >= s.cols {
+		col = s.cols - 1
+	}
+	return CursorState{X: col, Y: g.row, Visible: !s.cursorHidden, Alternate: s.inAlt}
+}
+
+// CursorLine returns the text of the cursor's row up to the cursor
+// column. Orchestrators use it to recognise a prompt awaiting input.
+func (s *Screen) CursorLine() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g := s.st()
+	col := g.col
+	if col > s.cols {
+		col = s.cols
+	}
+	return rowText(g.grid[g.row][:col])
+}
+
+// Cell returns the character in column x of row y of the visible screen,
+// ' ' for a blank or out of range cell. The right half of a double-width
+// character is 0.
+func (s *Screen) Cell(x, y int) rune {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g := s.st()
+	if y < 0 || y >= s.rows || x < 0 || x >= s.cols {
+		return ' '
+	}
+	switch r := g.grid[y][x]; r {
+	case wideTail:
+		return 0
+	case tabCell:
+		return ' '
+	default:
+		return r
+	}
+}
+
+// BetweenFrames reports whether the emulator is at a frame boundary: no
+// escape sequence or UTF-8 character is partially parsed and no
+// synchronized update (mode 2026) is open.
+func (s *Screen) BetweenFrames() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.betweenFramesLocked()
+}
+
+func (s *Screen) betweenFramesLocked() bool {
+	return s.pState == psNorm && len(s.uBuf) == 0 && !s.syncUpdate
+}
+
+// CaptureFrame is like Capture, or CaptureLinks with links set, but only
+// succeeds at a frame boundary. The check and the snapshot happen under
+// one lock, so no output can land in between.
+func (s *Screen) CaptureFrame(maxLines int, links bool) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.betweenFramesLocked() {
+		return nil, false
+	}
+	return s.captureLocked(maxLines, links), true
+}
+
 // --- Character output ---
 
 func (s *Screen) putRune(r rune) {
 	g := s.st()
-	if g.col >= s.cols {
-		// Auto-wrap
+	w := runeWidth(r, s.ambiguousWide)
+	if w > s.cols {
+		w = 1
+	}
+	if g.col+w > s.cols {
+		// Auto-wrap; a double-width character does not fit in the last
+		// column, which stays blank.
+		if g.col < s.cols {
+			splitWide(g.grid[g.row], g.col, 1)
+			g.clearCells(g.row, g.col, g.col+1)
+		}
 		g.col = 0
 		s.linefeed()
 	}
-	g.grid[g.row][g.col] = r
-	g.col++
+	row := g.grid[g.row]
+	splitWide(row, g.col, w)
+	row[g.col] = r
+	if w == 2 {
+		row[g.col+1] = wideTail
+	}
+	s.markLink(g, g.col, w)
+	g.col += w
 }
 
 // --- Control characters ---
@@ -181,11 +362,9 @@ func (s *Screen) feedCtrl(b byte) {
 			g.col--
 		}
 	case '\t':
-		g.col = (g.col/8 + 1) * 8
-		if g.col >= s.cols {
-			g.col = s.cols - 1
-		}
-	case '\x07': // BEL — ignore
+		s.tab()
+	case '\x07': // BEL — counted for alerts (see Bells)
+		s.bells++
 	}
 }
 
@@ -214,6 +393,12 @@ func (s *Screen) feedEsc(b byte) {
 			g.row = g.savedRow
 			g.col = g.savedCol
 			s.pState = psNorm
+		case '=': // DECKPAM — application keypad
+			s.keypad = true
+			s.pState = psNorm
+		case '>': // DECKPNM — numeric keypad
+			s.keypad = false
+			s.pState = psNorm
 		case '(', ')': // Charset designation — skip next byte
 			s.pState = psEscSkip
 		default:
@@ -221,7 +406,7 @@ func (s *Screen) feedEsc(b byte) {
 		}
 
 	case psCSI:
-		if (b >= '0' && b <= '9') || b == ';' || b == '?' {
+		if (b >= '0' && b <= '9') || b == ';' || (b >= '<' && b <= '?') {
 			s.pBuf = append(s.pBuf, b)
 			return
 		}
@@ -234,15 +419,18 @@ func (s *Screen) feedEsc(b byte) {
 	case psOSC:
 		if b == 0x07 { // BEL terminates
 			s.pState = psNorm
+			s.execOSC(string(s.pBuf))
 			s.pBuf = s.pBuf[:0]
 		} else if b == 0x1b {
 			s.pState = psOSCEsc
+		} else if len(s.pBuf) < maxOSCLen {
+			s.pBuf = append(s.pBuf, b)
 		}
-		// else: accumulate (ignored)
 
 	case psOSCEsc:
 		// ESC \ is String Terminator
 		s.pState = psNorm
+		s.execOSC(string(s.pBuf))
 		s.pBuf = s.pBuf[:0]
 
 	case psEscSkip:
@@ -250,11 +438,106 @@ func (s *Screen) feedEsc(b byte) {
 	}
 }
 
+// --- OSC command execution ---
+
+// maxOSCLen bounds the OSC payload kept for interpretation; longer
+// payloads are truncated (and so usually ignored).
+const maxOSCLen = 4096
+
+func (s *Screen) execOSC(payload string) {
+	code, arg, _ := strings.Cut(payload, ";")
+	switch code {
+	case "7": // Current working directory as a file:// URL
+		if p := parseFileURL(arg); p != "" {
+			s.cwd = p
+		}
+	case "8": // Hyperlink
+		s.setLink(arg)
+	case "133", "633": // Shell integration: prompt, input and command marks
+		s.shellMark(arg)
+	case "9":
+		// ConEmu / Windows Terminal extensions: 9;9;<path> reports the
+		// cwd, 9;4;<state>;<value> task progress.
+		sub, rest, _ := strings.Cut(arg, ";")
+		switch sub {
+		case "9":
+			if p := strings.Trim(rest, "\""); p != "" {
+				s.cwd = p
+			}
+		case "4":
+			s.setProgress(rest)
+		}
+	}
+}
+
+// parseFileURL extracts the path from an OSC 7 "file://host/path" URL.
+// Windows drive paths arrive as "/C:/dir" and lose the leading slash.
+func parseFileURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	p := u.Path
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return p
+}
+
+// Bells returns how many times the application has rung the bell: BEL
+// characters written as output, not those ending an OSC sequence.
+func (s *Screen) Bells() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bells
+}
+
+// AltSwitches returns how many times the application has entered or left
+// the alternate screen, and whether it is on it now. A reader comparing
+// counts sees a switch there and back that the flag alone would hide.
+func (s *Screen) AltSwitches() (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.altSwitches, s.inAlt
+}
+
+// FocusEvents reports whether the application has asked to be told when
+// the terminal gains and loses focus (mode 1004).
+func (s *Screen) FocusEvents() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.focusEvents
+}
+
+// BracketedPaste reports whether the application has asked for pasted
+// text to be bracketed (mode 2004), as PSReadLine and readline do.
+func (s *Screen) BracketedPaste() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bracketedPaste
+}
+
+// CurrentPath returns the working directory most recently reported by
+// the application through OSC 7 or OSC 9;9, or "" if none was reported.
+func (s *Screen) CurrentPath() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cwd
+}
+
 // --- CSI command execution ---
 
 func (s *Screen) execCSI(final byte, params string) {
 	g := s.st()
 
+	// Sequences with a < = or > marker, and CSI ? u, are keyboard
+	// protocol requests or queries (CSI > c) for the terminal in front,
+	// not the commands their final bytes otherwise mean.
+	if len(params) > 0 && (params[0] == '<' || params[0] == '=' || params[0] == '>' || params[0] == '?' && final == 'u') {
+		s.execKeyMode(final, params[0], params[1:])
+		return
+	}
+
 	switch final {
 	case 'H', 'f': // CUP — Cursor Position
 		row, col := parseTwo(params, 1, 1)
@@ -295,9 +578,7 @@ func (s *Screen) execCSI(final byte, params string) {
 
 	case 'X': // ECH — Erase Characters
 		n := parseOne(params, 1)
-		for i := 0; i < n && g.col+i < s.cols; i++ {
-			g.grid[g.row][g.col+i] = ' '
-		}
+		g.clearCells(g.row, g.col, min(g.col+n, s.cols))
 
 	case 'L': // IL — Insert Lines
 		s.insertLines(parseOne(params, 1))
@@ -312,7 +593,9 @@ func (s *Screen) execCSI(final byte, params string) {
 		s.deleteChars(parseOne(params, 1))
 
 	case 'S': // SU — Scroll Up
-		s.scrollUp(parseOne(params, 1))
+		n := parseOne(params, 1)
+		s.keepScrolled(n)
+		s.scrollUp(n)
 
 	case 'T': // SD — Scroll Down
 		s.scrollDown(parseOne(params, 1))
@@ -356,22 +639,45 @@ func (s *Screen) setPrivateMode(params string, set bool) {
 	for _, p := range strings.Split(params, ";") {
 		n, _ := strconv.Atoi(p)
 		switch n {
+		case 1: // DECCKM — application cursor keys
+			s.cursorKeys = set
+		case 25: // DECTCEM — cursor visibility
+			s.cursorHidden = !set
+		case 2026: // Synchronized output — frame begin/end
+			s.syncUpdate = set
+		case 1004: // Focus reporting — ESC[I / ESC[O on focus change
+			s.focusEvents = set
+		case 2004: // Bracketed paste — pasted text between ESC[200~ and ESC[201~
+			s.bracketedPaste = set
+		case 1000, 1002, 1003: // Mouse tracking — the last one set is in effect
+			s.mouse = setMode(s.mouse, n, set)
+		case 1005, 1006, 1015: // Mouse report format — UTF-8, SGR, urxvt
+			s.mouseFormat = setMode(s.mouseFormat, n, set)
 		case 47, 1047, 1049: // Alternate screen buffer
-			if set && !s.inAlt {
-				s.inAlt = true
-				s.alt = newGrid(s.cols, s.rows)
-			} else if !set && s.inAlt {
-				s.inAlt = false
-			}
+			s.setAlternate(n, set)
 		}
 	}
 }
 
+// setMode returns the mode in effect of a group of modes of which one is
+// in effect at a time, cur, after mode n is set or reset.
+func setMode(cur, n int, set bool) int {
+	if set {
+		return n
+	}
+	if cur == n {
+		return 0
+	}
+	return cur
+}
+
 // --- Scrolling & line operations ---
 
 func (s *Screen) linefeed() {
+	s.commandRow()
 	g := s.st()
 	if g.row == g.scrollBottom {
+		s.keepScrolled(1)
 		s.scrollUp(1)
 	} else if g.row < s.rows-1 {
 		g.row++
@@ -397,10 +703,11 @@ func (s *Screen) scrollUp(n int) {
 	// Shift lines up within scroll region
 	for r := top; r <= bottom-n; r++ {
 		g.grid[r] = g.grid[r+n]
+		g.links[r] = g.links[r+n]
 	}
 	// Fill new lines at bottom with spaces
 	for r := bottom - n + 1; r <= bottom; r++ {
-		g.grid[r] = makeRow(s.cols)
+		g.blankRow(r, s.cols)
 	}
 }
 
@@ -414,10 +721,11 @@ func (s *Screen) scrollDown(n int) {
 	// Shift lines down within scroll region
 	for r := bottom; r >= top+n; r-- {
 		g.grid[r] = g.grid[r-n]
+		g.links[r] = g.links[r-n]
 	}
 	// Fill new lines at top with spaces
 	for r := top; r < top+n; r++ {
-		g.grid[r] = makeRow(s.cols)
+		g.blankRow(r, s.cols)
 	}
 }
 
@@ -447,30 +755,30 @@ func (s *Screen) deleteLines(n int) {
 
 func (s *Screen) insertChars(n int) {
 	g := s.st()
-	row := g.grid[g.row]
+	row, ids := g.grid[g.row], g.links[g.row]
 	// Shift right from cursor
 	for i := s.cols - 1; i >= g.col+n && i >= 0; i-- {
 		row[i] = row[i-n]
+		if ids != nil {
+			ids[i] = ids[i-n]
+		}
 	}
 	// Fill inserted positions with spaces
-	for i := g.col; i < g.col+n && i < s.cols; i++ {
-		row[i] = ' '
-	}
+	g.clearCells(g.row, g.col, min(g.col+n, s.cols))
 }
 
 func (s *Screen) deleteChars(n int) {
 	g := s.st()
-	row := g.grid[g.row]
+	row, ids := g.grid[g.row], g.links[g.row]
 	// Shift left from cursor
 	for i := g.col; i < s.cols-n; i++ {
 		row[i] = row[i+n]
-	}
-	// Fill vacated positions with spaces
-	for i := s.cols - n; i < s.cols; i++ {
-		if i >= 0 {
-			row[i] = ' '
+		if ids != nil {
+			ids[i] = ids[i+n]
 		}
 	}
+	// Fill vacated positions with spaces
+	g.clearCells(g.row, max(s.cols-n, g.col), s.cols)
 }
 
 // --- Erase operations ---
@@ -479,22 +787,18 @@ func (s *Screen) eraseDisplay(mode int) {
 	g := s.st()
 	switch mode {
 	case 0: // Below (from cursor to end)
-		for i := g.col; i < s.cols; i++ {
-			g.grid[g.row][i] = ' '
-		}
+		g.clearCells(g.row, min(g.col, s.cols), s.cols)
 		for r := g.row + 1; r < s.rows; r++ {
-			g.grid[r] = makeRow(s.cols)
+			g.blankRow(r, s.cols)
 		}
 	case 1: // Above (from start to cursor)
 		for r := 0; r < g.row; r++ {
-			g.grid[r] = makeRow(s.cols)
-		}
-		for i := 0; i <= g.col && i < s.cols; i++ {
-			g.grid[g.row][i] = ' '
+			g.blankRow(r, s.cols)
 		}
+		g.clearCells(g.row, 0, min(g.col+1, s.cols))
 	case 2, 3: // Entire screen
 		for r := 0; r < s.rows; r++ {
-			g.grid[r] = makeRow(s.cols)
+			g.blankRow(r, s.cols)
 		}
 	}
 }
@@ -503,15 +807,11 @@ func (s *Screen) eraseLine(mode int) {
 	g := s.st()
 	switch mode {
 	case 0: // Right (from cursor to end)
-		for i := g.col; i < s.cols; i++ {
-			g.grid[g.row][i] = ' '
-		}
+		g.clearCells(g.row, min(g.col, s.cols), s.cols)
 	case 1: // Left (from start to cursor)
-		for i := 0; i <= g.col && i < s.cols; i++ {
-			g.grid[g.row][i] = ' '
-		}
+		g.clearCells(g.row, 0, min(g.col+1, s.cols))
 	case 2: // Entire line
-		g.grid[g.row] = makeRow(s.cols)
+		g.blankRow(g.row, s.cols)
 	}
 }
 
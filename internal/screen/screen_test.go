@@ -0,0 +1,435 @@
+package screen
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCursorState(t *testing.T) {
+	s := New(20, 5)
+	s.Write([]byte("ab\r\ncd"))
+	cur := s.Cursor()
+	if cur.X != 2 || cur.Y != 1 {
+		t.Errorf("cursor = %d,%d, want 2,1", cur.X, cur.Y)
+	}
+	if !cur.Visible || cur.Alternate {
+		t.Errorf("unexpected flags: %+v", cur)
+	}
+
+	s.Write([]byte("\x1b[?25l\x1b[?1049h"))
+	cur = s.Cursor()
+	if cur.Visible || !cur.Alternate {
+		t.Errorf("expected hidden cursor on alt screen, got %+v", cur)
+	}
+}
+
+func TestCursorLine(t *testing.T) {
+	s := New(20, 5)
+	s.Write([]byte("PS C:\\> "))
+	if got := s.CursorLine(); got != "PS C:\\> " {
+		t.Errorf("CursorLine = %q", got)
+	}
+}
+
+func TestCell(t *testing.T) {
+	s := New(20, 5)
+	s.Write([]byte("\r\n$ 日x"))
+	for _, c := range []struct {
+		x, y int
+		want rune
+	}{{0, 1, '$'}, {1, 1, ' '}, {2, 1, '日'}, {3, 1, 0}, {4, 1, 'x'}, {0, 0, ' '}, {20, 1, ' '}, {0, -1, ' '}} {
+		if got := s.Cell(c.x, c.y); got != c.want {
+			t.Errorf("Cell(%d, %d) = %q, want %q", c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestCaptureFrameWaitsForSequenceEnd(t *testing.T) {
+	s := New(20, 5)
+	s.Write([]byte("hello\x1b["))
+	if _, ok := s.CaptureFrame(5, false); ok {
+		t.Error("expected no frame while a CSI sequence is pending")
+	}
+	s.Write([]byte("2J"))
+	if _, ok := s.CaptureFrame(5, false); !ok {
+		t.Error("expected frame after sequence completed")
+	}
+}
+
+func TestCaptureFrameSynchronizedUpdate(t *testing.T) {
+	s := New(20, 5)
+	s.Write([]byte("\x1b[?2026hdrawing"))
+	if s.BetweenFrames() {
+		t.Error("expected mid-frame inside synchronized update")
+	}
+	s.Write([]byte("\x1b[?2026l"))
+	lines, ok := s.CaptureFrame(5, false)
+	if !ok {
+		t.Fatal("expected frame after synchronized update ended")
+	}
+	if lines[0] != "drawing" {
+		t.Errorf("line 0 = %q", lines[0])
+	}
+}
+
+func TestCurrentPathOSC7(t *testing.T) {
+	s := New(20, 5)
+	s.Write([]byte("\x1b]7;file://host/home/me/my%20dir\x07$ "))
+	if got := s.CurrentPath(); got != "/home/me/my dir" {
+		t.Errorf("CurrentPath = %q", got)
+	}
+	s.Write([]byte("\x1b]7;file://pc/C:/Users/me\x1b\\"))
+	if got := s.CurrentPath(); got != "C:/Users/me" {
+		t.Errorf("CurrentPath = %q", got)
+	}
+	if lines := s.Capture(1); lines[0] != "" {
+		t.Errorf("OSC leaked into screen: %q", lines[0])
+	}
+}
+
+func TestCurrentPathOSC99(t *testing.T) {
+	s := New(20, 5)
+	s.Write([]byte("\x1b]9;9;\"C:\\work\\repo\"\x07"))
+	if got := s.CurrentPath(); got != `C:\work\repo` {
+		t.Errorf("CurrentPath = %q", got)
+	}
+}
+
+func TestShellIntegrationMarks(t *testing.T) {
+	s := New(20, 4)
+	if s.ShellIntegration() {
+		t.Error("integration reported before any mark")
+	}
+	prompt := "\x1b]133;A\x07$ \x1b]133;B\x07"
+	s.Write([]byte(prompt + "ls -l\r\n\x1b]133;C\x07"))
+	if c, ok := s.RunningCommand(); !ok || c.Line != "ls -l" {
+		t.Errorf("running = %+v, %v", c, ok)
+	}
+	// More output than the screen holds, a progress redraw and a last
+	// line without a newline.
+	s.Write([]byte("a\r\nb\r\nc\r\n50%\rdone\r\nlast"))
+	s.Write([]byte("\x1b]133;D;2\x07\r\n" + prompt))
+	c, ok := s.LastCommand()
+	if !ok || c.Running || c.ExitCode != 2 || c.Line != "ls -l" {
+		t.Fatalf("last = %+v, %v", c, ok)
+	}
+	if got := strings.Join(c.Output, "|"); got != "a|b|c|done|last" {
+		t.Errorf("output = %q", got)
+	}
+	if !s.ShellIntegration() {
+		t.Error("integration not reported")
+	}
+
+	// VS Code's marks name the command line; D without a code leaves
+	// the exit code unknown.
+	s.Write([]byte(`echo x` + "\x1b]633;E;echo\\x3bx\\\\y\x07\r\n\x1b]633;C\x07x\r\n\x1b]633;D\x07"))
+	c, _ = s.LastCommand()
+	if c.Seq != 2 || c.Line != `echo;x\y` || c.ExitCode != -1 || strings.Join(c.Output, "|") != "x" {
+		t.Errorf("633 command = %+v", c)
+	}
+}
+
+func TestCommandHistory(t *testing.T) {
+	s := New(20, 4)
+	for i := 0; i < maxCommands+5; i++ {
+		s.Write([]byte("\x1b]133;A\x07$ \x1b]133;B\x07cmd" + strconv.Itoa(i) + "\r\n\x1b]133;C\x07out\r\n\x1b]133;D;0\x07"))
+	}
+	s.Write([]byte("\x1b]133;A\x07$ \x1b]133;B\x07sleep\r\n\x1b]133;C\x07"))
+	list := s.Commands()
+	if len(list) != maxCommands+1 {
+		t.Fatalf("%d commands listed", len(list))
+	}
+	if first := list[0]; first.Seq != 6 || first.Line != "cmd5" || first.Ended.IsZero() {
+		t.Errorf("oldest = %+v", first)
+	}
+	if last := list[len(list)-1]; !last.Running || last.Line != "sleep" || !last.Ended.IsZero() {
+		t.Errorf("running = %+v", last)
+	}
+	if c, ok := s.CommandBySeq(100); !ok || c.Line != "cmd99" || strings.Join(c.Output, "|") != "out" {
+		t.Errorf("command 100 = %+v, %v", c, ok)
+	}
+	if _, ok := s.CommandBySeq(5); ok {
+		t.Error("dropped command still found")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	s := New(20, 4)
+	for _, c := range []struct {
+		seq  string
+		want Progress
+	}{
+		{"\x1b]9;4;1;42\x07", Progress{"normal", 42}},
+		{"\x1b]9;4;2\x1b\\", Progress{"error", 42}},
+		{"\x1b]9;4;4;150\x07", Progress{"paused", 100}},
+		{"\x1b]9;4;3;0\x07", Progress{"indeterminate", 0}},
+		{"\x1b]9;4;9;5\x07", Progress{"indeterminate", 0}},
+		{"\x1b]9;4;0;0\x07", Progress{}},
+	} {
+		s.Write([]byte(c.seq))
+		if got := s.Progress(); got != c.want {
+			t.Errorf("after %q: %+v, want %+v", c.seq, got, c.want)
+		}
+	}
+}
+
+func TestBells(t *testing.T) {
+	s := New(20, 4)
+	s.Write([]byte("\x1b]0;title\x07\x1b]9;4;1;5\x07done\a"))
+	if n := s.Bells(); n != 1 {
+		t.Errorf("bells = %d, want 1", n)
+	}
+	s.Write([]byte("\a\a"))
+	if n := s.Bells(); n != 3 {
+		t.Errorf("bells = %d, want 3", n)
+	}
+}
+
+func TestFocusEvents(t *testing.T) {
+	s := New(20, 4)
+	if s.FocusEvents() {
+		t.Error("focus reporting on at start")
+	}
+	s.Write([]byte("\x1b[?25;1004h"))
+	if !s.FocusEvents() {
+		t.Error("mode 1004 not recorded")
+	}
+	s.Write([]byte("\x1b[?1004l"))
+	if s.FocusEvents() {
+		t.Error("mode 1004 still set after reset")
+	}
+}
+
+func TestKeyMode(t *testing.T) {
+	s := New(20, 4)
+	s.Write([]byte("\x1b[>4;2m\x1b[>1u\x1b[>cok"))
+	if m := s.KeyMode(); m.ModifyOtherKeys != 2 || m.Kitty != 1 {
+		t.Errorf("mode = %+v", m)
+	}
+	if got := s.Capture(0)[0]; got != "ok" {
+		t.Errorf("requests printed as text: %q", got)
+	}
+	s.Write([]byte("\x1b[=8;2u\x1b[>4m"))
+	if m := s.KeyMode(); m.ModifyOtherKeys != 0 || m.Kitty != 9 {
+		t.Errorf("mode after set = %+v", m)
+	}
+	s.Write([]byte("\x1b[<u\x1b[<u"))
+	if m := s.KeyMode(); m.Kitty != 0 {
+		t.Errorf("mode after pops = %+v", m)
+	}
+	s.Write([]byte("\x1b[?1h\x1b="))
+	if m := s.KeyMode(); !m.CursorKeys || !m.Keypad {
+		t.Errorf("mode after DECCKM and DECKPAM = %+v", m)
+	}
+	s.Write([]byte("\x1b[?1l\x1b>"))
+	if m := s.KeyMode(); m.CursorKeys || m.Keypad {
+		t.Errorf("mode after resets = %+v", m)
+	}
+	s.Write([]byte("\x1b[?1000h\x1b[?1003;1006h\x1b[?1000l\x1b[?2004h"))
+	if m := s.KeyMode(); m.Mouse != 1003 || m.MouseFormat != 1006 || !m.BracketedPaste {
+		t.Errorf("mouse and paste modes = %+v", m)
+	}
+	s.Write([]byte("\x1b[?1003l\x1b[?1006l"))
+	if m := s.KeyMode(); m.Mouse != 0 || m.MouseFormat != 0 {
+		t.Errorf("mouse modes after resets = %+v", m)
+	}
+}
+
+func TestWideCharacters(t *testing.T) {
+	s := New(6, 3)
+	s.Write([]byte("a中b"))
+	if cur := s.Cursor(); cur.X != 4 {
+		t.Errorf("cursor after a wide character = %d, want 4", cur.X)
+	}
+	// A wide character that does not fit wraps; the last column is left
+	// blank.
+	s.Write([]byte("c文"))
+	if got := strings.Join(s.Capture(0), "|"); got != "a中bc|文|" {
+		t.Errorf("capture = %q", got)
+	}
+	// Overwriting either half blanks the other.
+	s.Write([]byte("\x1b[1;3Hx\x1b[2;1Hy"))
+	if got := strings.Join(s.Capture(0), "|"); got != "a xbc|y|" {
+		t.Errorf("after overwrite = %q", got)
+	}
+	if line := s.CursorLine(); line != "y" {
+		t.Errorf("cursor line = %q", line)
+	}
+}
+
+func TestAmbiguousWidth(t *testing.T) {
+	for _, c := range []struct {
+		wide bool
+		x    int
+	}{{false, 3}, {true, 6}} {
+		s := New(20, 2)
+		s.SetAmbiguousWide(c.wide)
+		s.Write([]byte("±○α"))
+		if cur := s.Cursor(); cur.X != c.x {
+			t.Errorf("wide=%v: cursor = %d, want %d", c.wide, cur.X, c.x)
+		}
+		if got := s.Capture(0)[0]; got != "±○α" {
+			t.Errorf("wide=%v: capture = %q", c.wide, got)
+		}
+	}
+	if w := runeWidth('\u0301', true); w != 1 {
+		t.Errorf("combining mark counted as ambiguous: %d", w)
+	}
+	if w := runeWidth(0x2b740, false); w != 2 {
+		t.Errorf("plane 2 ideograph width = %d", w)
+	}
+	if w := StringWidth("ab\t漢±"); w != 11 {
+		t.Errorf("StringWidth = %d, want 11", w)
+	}
+}
+
+func TestHyperlinks(t *testing.T) {
+	s := New(30, 3)
+	s.Write([]byte("see \x1b]8;id=r1;https://ci/run/1\x1b\\run 1\x1b]8;;\x1b\\ and \x1b]8;;file:///tmp/a.log\x07log\x1b]8;;\x07\r\n"))
+	links := s.Links()
+	if len(links) != 2 {
+		t.Fatalf("links = %+v", links)
+	}
+	if l := links[0]; l.URI != "https://ci/run/1" || l.ID != "r1" || l.Text != "run 1" || l.X != 4 || l.Y != 0 {
+		t.Errorf("first link = %+v", l)
+	}
+	if l := links[1]; l.URI != "file:///tmp/a.log" || l.Text != "log" || l.X != 14 {
+		t.Errorf("second link = %+v", l)
+	}
+	want := "see \x1b]8;id=r1;https://ci/run/1\x1b\\run 1\x1b]8;;\x1b\\ and \x1b]8;;file:///tmp/a.log\x1b\\log\x1b]8;;\x1b\\"
+	if got := s.CaptureLinks(0)[0]; got != want {
+		t.Errorf("capture = %q", got)
+	}
+	if got := s.Capture(0)[0]; got != "see run 1 and log" {
+		t.Errorf("plain capture = %q", got)
+	}
+
+	// Overwriting drops a link, inserting a line moves them and
+	// erasing clears them.
+	s.Write([]byte("\x1b[1;15Hxxx\x1b]8;;https://x\x07y\x1b]8;;\x07\x1b[1;1H\x1b[L"))
+	links = s.Links()
+	if len(links) != 2 || links[0].Y != 1 || links[1].URI != "https://x" || links[1].X != 17 {
+		t.Fatalf("after overwrite = %+v", links)
+	}
+	s.Write([]byte("\x1b[2J"))
+	if links := s.Links(); len(links) != 0 {
+		t.Errorf("after erase = %+v", links)
+	}
+}
+
+func TestHyperlinkTableCompaction(t *testing.T) {
+	s := New(10, 2)
+	for i := 0; i < maxLinks*3; i++ {
+		s.Write([]byte("\x1b]8;;https://h/" + strconv.Itoa(i) + "\x07x\x1b]8;;\x07\r\n"))
+	}
+	if len(s.links) > maxLinks {
+		t.Errorf("table grew to %d", len(s.links))
+	}
+	links := s.Links()
+	if len(links) != 1 || links[0].URI != "https://h/"+strconv.Itoa(maxLinks*3-1) {
+		t.Errorf("links = %+v", links)
+	}
+}
+
+func TestRegionHistory(t *testing.T) {
+	// A log window in rows 1-3 above a status line, then the same window
+	// below a title row.
+	run := func(mode RegionHistory, alt bool, top int) []string {
+		s := New(10, 5)
+		s.SetRegionHistory(mode)
+		if alt {
+			s.Write([]byte("\x1b[?1049h"))
+		}
+		s.Write([]byte("\x1b[" + strconv.Itoa(top) + ";4r\x1b[4;1H"))
+		for _, line := range []string{"a", "b", "c", "d", "e"} {
+			s.Write([]byte("\r\n" + line))
+		}
+		s.Write([]byte("\x1b[2S"))
+		return s.ScrolledOut()
+	}
+	for _, c := range []struct {
+		mode RegionHistory
+		alt  bool
+		top  int
+		want string
+	}{
+		{RegionHistoryOff, false, 1, ""},
+		{RegionHistoryTmux, false, 1, "||||a|b|c"},
+		{RegionHistoryTmux, true, 1, ""},
+		{RegionHistoryTmux, false, 2, ""},
+		{RegionHistoryAll, true, 2, "|||a|b|c|d"},
+	} {
+		if got := strings.Join(run(c.mode, c.alt, c.top), "|"); got != c.want {
+			t.Errorf("mode %d alt %v top %d: %q, want %q", c.mode, c.alt, c.top, got, c.want)
+		}
+	}
+
+	s := New(10, 3)
+	s.SetRegionHistory(RegionHistoryAll)
+	s.Write([]byte("1\r\n2\r\n3\r\n4"))
+	if got := s.ScrolledOut(); len(got) != 0 {
+		t.Errorf("full-screen scroll kept %q", got)
+	}
+}
+
+func TestAlternateScreenVim(t *testing.T) {
+	s := New(20, 4)
+	main := s.WriteMain([]byte("$ ls\r\nfile\r\n$ vim"))
+	if string(main) != "$ ls\r\nfile\r\n$ vim" {
+		t.Errorf("main output = %q", main)
+	}
+	before := s.Cursor()
+
+	// vim: 1049 in, draw, move around; split across writes mid-sequence.
+	main = s.WriteMain([]byte("\r\n\x1b[?104"))
+	main = append(main, s.WriteMain([]byte("9h\x1b[H\x1b[2Jhello\x1b[4;1H:wq\x1b7"))...)
+	if string(main) != "\r\n\x1b[?1049h" {
+		t.Errorf("main output entering = %q", main)
+	}
+	if cur := s.Cursor(); !cur.Alternate || s.Capture(0)[0] != "hello" {
+		t.Fatalf("alternate screen: %+v %q", cur, s.Capture(0))
+	}
+	if lines, ok := s.CaptureMain(0); !ok || lines[0] != "$ ls" || lines[2] != "$ vim" {
+		t.Errorf("main screen under vim = %q, %v", lines, ok)
+	}
+	main = s.WriteMain([]byte("\x1b[?1049l"))
+	if string(main) != "\x1b[?1049l" {
+		t.Errorf("main output leaving = %q", main)
+	}
+	if got := strings.Join(s.Capture(0), "|"); got != "$ ls|file|$ vim|" {
+		t.Errorf("main screen after vim = %q", got)
+	}
+	if cur := s.Cursor(); cur.Alternate || cur.X != 0 || cur.Y != before.Y+1 {
+		t.Errorf("cursor after vim = %+v, before %+v", cur, before)
+	}
+	if _, ok := s.CaptureMain(0); ok {
+		t.Error("CaptureMain without an alternate screen")
+	}
+
+	// Mode 47 keeps the alternate screen's contents; 1047 clears them.
+	s.Write([]byte("\x1b[?47hkept\x1b[?47l\x1b[?47h"))
+	if !strings.Contains(strings.Join(s.Capture(0), ""), "kept") {
+		t.Errorf("mode 47 cleared the alternate screen: %q", s.Capture(0))
+	}
+	s.Write([]byte("\x1b[?47l\x1b[?1047h"))
+	if got := strings.Join(s.Capture(0), ""); got != "" {
+		t.Errorf("mode 1047 kept %q", got)
+	}
+}
+
+func TestKeepTabs(t *testing.T) {
+	s := New(30, 4)
+	s.Write([]byte("a\tb\tc\r\nname\tsize\r\n\tx\rab\r\n\tx\rover\t"))
+	want := []string{"a       b       c", "name    size", "ab      x", "over    x"}
+	if got := s.Capture(0); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expanded = %q", got)
+	}
+	s.SetKeepTabs(true)
+	// A tab partly overwritten is gone; a tab written again after the
+	// text is back.
+	want = []string{"a\tb\tc", "name\tsize", "ab      x", "over\tx"}
+	if got := s.Capture(0); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("kept = %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+package screen
+
+import "strings"
+
+// tabCell marks the first cell a tab moved the cursor over, when the
+// cells it jumped were blank, so that a capture can give the tab back
+// (see SetKeepTabs). Everything else shows it as a blank.
+const tabCell rune = '\t'
+
+// tab moves the cursor to the next tab stop: every 8 columns, and the
+// last column.
+func (s *Screen) tab() {
+	g := s.st()
+	stop := min((g.col/8+1)*8, s.cols-1)
+	if g.col < stop && stop%8 == 0 && blankCells(g.grid[g.row][g.col:stop]) {
+		g.grid[g.row][g.col] = tabCell
+	}
+	g.col = stop
+}
+
+// SetKeepTabs sets whether Capture and CaptureMain give back the tabs
+// the application wrote, where the cells they jumped are still blank, or
+// expand every tab to the spaces up to its tab stop. The default expands
+// them; CaptureLinks always does.
+func (s *Screen) SetKeepTabs(keep bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keepTabs = keep
+}
+
+// tabText is rowText of a whole row, with a tab for each tab whose cells
+// are still blank.
+func tabText(row []rune) string {
+	var b strings.Builder
+	from := 0
+	for i := 0; i < len(row); i++ {
+		if row[i] != tabCell {
+			continue
+		}
+		stop := (i/8 + 1) * 8
+		if stop > len(row) || !blankCells(row[i+1:stop]) {
+			continue
+		}
+		b.WriteString(rowText(row[from:i]))
+		b.WriteByte('\t')
+		from, i = stop, stop-1
+	}
+	b.WriteString(rowText(row[from:]))
+	return b.String()
+}
+
+func blankCells(cells []rune) bool {
+	for _, r := range cells {
+		if r != ' ' && r != tabCell {
+			return false
+		}
+	}
+	return true
+}
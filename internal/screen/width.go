@@ -0,0 +1,84 @@
+package screen
+
+import (
+	"strings"
+	"unicode"
+)
+
+// wideTail fills the cell after a double-width character. Row text skips
+// it, so captures read as the application wrote them while cell columns
+// (the cursor, erases) stay where the terminal has them.
+const wideTail rune = -1
+
+// runeWidth returns the cells r takes: 2 for East Asian wide and
+// fullwidth characters, and for ambiguous ones (Greek, Cyrillic, box
+// drawing and symbols that CJK fonts draw double) when ambiguousWide is
+// set; 1 otherwise. Planes 2 and 3 are reserved for CJK ideographs and
+// wide even where unassigned in the tables.
+func runeWidth(r rune, ambiguousWide bool) int {
+	switch {
+	case r < 0xa1:
+		return 1
+	case unicode.Is(wideTable, r), r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	case ambiguousWide && unicode.Is(ambiguousTable, r):
+		return 2
+	}
+	return 1
+}
+
+// splitWide blanks the other half of any double-width character that
+// writing w cells at col would cut in two.
+func splitWide(row []rune, col, w int) {
+	if col > 0 && col < len(row) && row[col] == wideTail {
+		row[col-1] = ' '
+	}
+	if end := col + w; end < len(row) && row[end] == wideTail {
+		row[end] = ' '
+	}
+}
+
+// rowText returns the text of cells, leaving out the filler after each
+// double-width character. A filler whose character has been overwritten
+// reads as a space, and so does a tab mark.
+func rowText(cells []rune) string {
+	var b strings.Builder
+	b.Grow(len(cells))
+	for i, r := range cells {
+		if r == tabCell {
+			r = ' '
+		}
+		if r == wideTail {
+			if i == 0 || cells[i-1] == ' ' || cells[i-1] == wideTail {
+				b.WriteByte(' ')
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SetAmbiguousWide sets whether East Asian ambiguous-width characters
+// take two cells, as they do in CJK locales and fonts, or one. It applies
+// to output from then on.
+func (s *Screen) SetAmbiguousWide(wide bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ambiguousWide = wide
+}
+
+// StringWidth returns the cells plain text s takes on one terminal line,
+// with tabs advancing to the next multiple of 8 and ambiguous-width
+// characters narrow.
+func StringWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		if r == '\t' {
+			w += 8 - w%8
+			continue
+		}
+		w += runeWidth(r, false)
+	}
+	return w
+}
@@ -0,0 +1,143 @@
+package script
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Pane is the set of operations a script needs from a session.
+type Pane interface {
+	SendText(text string) error
+	SendKey(key string) error
+	Capture() (string, error)
+	WaitStable(quiet, timeout time.Duration) error
+}
+
+// pollInterval is how often expect re-captures the pane.
+const pollInterval = 100 * time.Millisecond
+
+// Runner executes steps against a pane. Trace, if set, receives one line
+// per step as it starts.
+type Runner struct {
+	Pane  Pane
+	Trace io.Writer
+
+	timeout time.Duration
+	mark    string // pane contents before the most recent input
+}
+
+// Run executes steps in order and stops at the first failure.
+func (r *Runner) Run(steps []Step) error {
+	r.timeout = DefaultTimeout
+	for _, s := range steps {
+		if r.Trace != nil {
+			fmt.Fprintf(r.Trace, "%d: %s\n", s.Line, describe(s))
+		}
+		if err := r.step(s); err != nil {
+			return fmt.Errorf("line %d: %s: %v", s.Line, s.Op, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) step(s Step) error {
+	switch s.Op {
+	case "send", "sendline":
+		if err := r.setMark(); err != nil {
+			return err
+		}
+		if err := r.Pane.SendText(s.Text); err != nil {
+			return err
+		}
+		if s.Op == "sendline" {
+			return r.Pane.SendKey("Enter")
+		}
+	case "key":
+		if err := r.setMark(); err != nil {
+			return err
+		}
+		for _, k := range s.Keys {
+			if err := r.Pane.SendKey(k); err != nil {
+				return err
+			}
+		}
+	case "expect":
+		return r.expect(s)
+	case "timeout":
+		r.timeout = s.Dur
+	case "sleep":
+		time.Sleep(s.Dur)
+	case "wait-stable":
+		return r.Pane.WaitStable(s.Dur, r.timeout)
+	case "capture":
+		out, err := r.Pane.Capture()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(s.Text, []byte(out+"\n"), 0o644)
+	}
+	return nil
+}
+
+// setMark remembers the pane contents so the next expect only matches
+// output produced after this input.
+func (r *Runner) setMark() error {
+	out, err := r.Pane.Capture()
+	if err != nil {
+		return err
+	}
+	r.mark = out
+	return nil
+}
+
+func (r *Runner) expect(s Step) error {
+	deadline := time.Now().Add(r.timeout)
+	for {
+		out, err := r.Pane.Capture()
+		if err != nil {
+			return err
+		}
+		if s.Re.MatchString(since(r.mark, out)) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("/%s/ not seen within %v", s.Text, r.timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// since returns the part of the capture cur that follows the earlier
+// capture mark. The last line of mark is kept, since input is usually
+// echoed onto the prompt line. If mark has scrolled out of the capture
+// window, all of cur is returned.
+func since(mark, cur string) string {
+	mark = strings.TrimRight(mark, " \n")
+	if mark == "" {
+		return cur
+	}
+	head := ""
+	if i := strings.LastIndexByte(mark, '\n'); i >= 0 {
+		head = mark[:i+1]
+	}
+	if strings.HasPrefix(cur, head) {
+		return cur[len(head):]
+	}
+	return cur
+}
+
+func describe(s Step) string {
+	switch s.Op {
+	case "send", "sendline":
+		return s.Op + " " + fmt.Sprintf("%q", s.Text)
+	case "key":
+		return "key " + strings.Join(s.Keys, " ")
+	case "timeout", "sleep", "wait-stable":
+		return s.Op + " " + s.Dur.String()
+	default:
+		return s.Op + " " + s.Text
+	}
+}
@@ -0,0 +1,122 @@
+// Package script implements the transcript language run by
+// `wintmux run-script`: a line-oriented list of send, expect and capture
+// steps executed against one pane. The annotations on the right below are
+// explanation only; the language has no trailing comments.
+//
+//	# comments and blank lines are ignored
+//	timeout 30s              default deadline for later expect steps
+//	send "ls -la\r"          literal text; quoted text takes Go escapes
+//	sendline git status      text followed by Enter
+//	key Escape C-c           one or more special keys
+//	expect ^\$ $             wait until new output matches the regexp
+//	sleep 500ms
+//	wait-stable 1s           wait until output is quiet for the duration
+//	capture out/status.txt   write the pane contents to a file
+package script
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds expect and wait-stable steps until a timeout
+// step changes it.
+const DefaultTimeout = 10 * time.Second
+
+// Step is one parsed script line.
+type Step struct {
+	Line int    // 1-based source line, for error messages
+	Op   string // send, sendline, key, expect, timeout, sleep, wait-stable, capture
+	Text string // send/sendline text, capture path or expect source
+	Keys []string
+	Re   *regexp.Regexp
+	Dur  time.Duration
+}
+
+// Parse reads a script. Errors carry the offending line number.
+func Parse(r io.Reader) ([]Step, error) {
+	var steps []Step
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		op, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		step := Step{Line: n, Op: op}
+		switch op {
+		case "send", "sendline":
+			text, err := parseText(arg)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			step.Text = text
+		case "key":
+			step.Keys = strings.Fields(arg)
+			if len(step.Keys) == 0 {
+				return nil, fmt.Errorf("line %d: key requires a key name", n)
+			}
+		case "expect":
+			if arg == "" {
+				return nil, fmt.Errorf("line %d: expect requires a regular expression", n)
+			}
+			// Multi-line mode, so ^ and $ anchor to pane lines.
+			re, err := regexp.Compile("(?m)" + arg)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			step.Text, step.Re = arg, re
+		case "timeout", "sleep", "wait-stable":
+			d, err := parseDuration(arg)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			step.Dur = d
+		case "capture":
+			if arg == "" {
+				return nil, fmt.Errorf("line %d: capture requires a file name", n)
+			}
+			step.Text = arg
+		default:
+			return nil, fmt.Errorf("line %d: unknown step %q", n, op)
+		}
+		steps = append(steps, step)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// parseText returns arg unchanged, or unquoted if it is a Go string
+// literal, so scripts can write control characters as escapes.
+func parseText(arg string) (string, error) {
+	if !strings.HasPrefix(arg, `"`) {
+		return arg, nil
+	}
+	text, err := strconv.Unquote(arg)
+	if err != nil {
+		return "", fmt.Errorf("bad quoted text %s", arg)
+	}
+	return text, nil
+}
+
+// parseDuration accepts Go duration syntax or a bare number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
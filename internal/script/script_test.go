@@ -0,0 +1,113 @@
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	src := `# login flow
+timeout 5s
+sendline echo hi
+send "a\tb\r"
+key Escape C-c
+expect ^hi$
+wait-stable 500ms
+capture out.txt
+`
+	steps, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(steps) != 7 {
+		t.Fatalf("got %d steps, want 7", len(steps))
+	}
+	if steps[0].Op != "timeout" || steps[0].Dur != 5*time.Second || steps[0].Line != 2 {
+		t.Errorf("unexpected timeout step %+v", steps[0])
+	}
+	if steps[1].Text != "echo hi" {
+		t.Errorf("sendline text %q", steps[1].Text)
+	}
+	if steps[2].Text != "a\tb\r" {
+		t.Errorf("quoted text %q", steps[2].Text)
+	}
+	if len(steps[3].Keys) != 2 || steps[3].Keys[1] != "C-c" {
+		t.Errorf("keys %v", steps[3].Keys)
+	}
+	if steps[4].Re == nil || steps[4].Text != "^hi$" {
+		t.Errorf("expect %+v", steps[4])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"frobnicate",
+		"expect (",
+		"timeout soon",
+		"send \"unterminated",
+		"key",
+	} {
+		if _, err := Parse(strings.NewReader(src)); err == nil {
+			t.Errorf("%q: expected error", src)
+		} else if !strings.HasPrefix(err.Error(), "line 1:") {
+			t.Errorf("%q: error %q lacks line number", src, err)
+		}
+	}
+}
+
+// fakePane echoes sent text into its screen; "Enter" starts a new line
+// and, if reply is set, appends it as program output.
+type fakePane struct {
+	screen string
+	reply  string
+	keys   []string
+}
+
+func (p *fakePane) SendText(text string) error { p.screen += text; return nil }
+
+func (p *fakePane) SendKey(key string) error {
+	p.keys = append(p.keys, key)
+	if key == "Enter" {
+		p.screen += "\n" + p.reply + "\n$ "
+	}
+	return nil
+}
+
+func (p *fakePane) Capture() (string, error) { return p.screen, nil }
+
+func (p *fakePane) WaitStable(quiet, timeout time.Duration) error { return nil }
+
+func TestRunExpect(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "cap.txt")
+	pane := &fakePane{screen: "$ ", reply: "hello"}
+	steps, err := Parse(strings.NewReader("sendline echo hello\nexpect ^hello$\ncapture " + out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Runner{Pane: pane}).Run(steps); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "hello\n$ ") {
+		t.Errorf("capture file %q", data)
+	}
+}
+
+func TestRunExpectIgnoresEarlierOutput(t *testing.T) {
+	pane := &fakePane{screen: "ERROR old\n$ ", reply: "ok"}
+	steps, err := Parse(strings.NewReader("timeout 200ms\nsendline true\nexpect ERROR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&Runner{Pane: pane}).Run(steps)
+	if err == nil || !strings.Contains(err.Error(), "line 3: expect") {
+		t.Fatalf("expected expect timeout on line 3, got %v", err)
+	}
+}
@@ -2,6 +2,17 @@ package scrollback
 
 import (
 	"sync"
+	"time"
+)
+
+// Stream tags a line with the output streams its bytes came from, for
+// terminals that keep the child's stderr apart from its stdout. Zero
+// means not known.
+type Stream uint8
+
+const (
+	Stdout Stream = 1 << iota
+	Stderr
 )
 
 // Buffer is a thread-safe ring buffer that stores terminal output lines.
@@ -10,10 +21,19 @@ import (
 type Buffer struct {
 	mu       sync.RWMutex
 	lines    []string
+	tags     []Stream // stream tag of each line in lines
 	capacity int
 	head     int // next write position
 	count    int // number of committed lines
+	total    int // lines committed since creation, including evicted ones
 	partial  []byte
+	tag      Stream // of partial
+
+	// Sampling of carriage-return redraws; see SetSampleInterval.
+	sample     time.Duration
+	lastSample time.Time
+	pendingCR  bool
+	now        func() time.Time
 }
 
 // New creates a scrollback buffer with the given line capacity.
@@ -24,17 +44,32 @@ func New(capacity int) *Buffer {
 	}
 	return &Buffer{
 		lines:    make([]string, capacity),
+		tags:     make([]Stream, capacity),
 		capacity: capacity,
+		now:      time.Now,
 	}
 }
 
 // Write processes raw bytes from terminal output, splitting into lines
 // on newline characters and stripping carriage returns.
 func (b *Buffer) Write(data []byte) {
+	b.WriteStream(data, 0)
+}
+
+// WriteStream is Write for bytes that came from stream s; every line
+// they are part of is tagged with it (see Streams).
+func (b *Buffer) WriteStream(data []byte, s Stream) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	for _, c := range data {
+		if c != '\r' {
+			b.tag |= s
+		}
+		if b.sample > 0 {
+			b.writeSampled(c)
+			continue
+		}
 		switch c {
 		case '\n':
 			b.commitLine()
@@ -46,15 +81,77 @@ func (b *Buffer) Write(data []byte) {
 	}
 }
 
+// SetSampleInterval enables sampling of lines redrawn in place with a
+// bare carriage return, as progress bars and spinners do. Each redraw
+// replaces the line being built instead of being appended to it, and at
+// most one intermediate state per interval is committed to history; the
+// final state is committed when the line ends. Zero disables sampling.
+func (b *Buffer) SetSampleInterval(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sample = d
+	b.pendingCR = false
+}
+
+// writeSampled handles one byte while sampling is on. A '\r' is held
+// until the next byte shows whether it ends the line ("\r\n") or starts
+// a redraw.
+func (b *Buffer) writeSampled(c byte) {
+	if b.pendingCR {
+		b.pendingCR = false
+		if c != '\n' && c != '\r' {
+			b.redraw()
+		}
+	}
+	switch c {
+	case '\n':
+		b.commitLine()
+	case '\r':
+		b.pendingCR = true
+	default:
+		b.partial = append(b.partial, c)
+	}
+}
+
+// redraw discards the line being built, first committing it as a sample
+// if the interval has elapsed since the previous one.
+func (b *Buffer) redraw() {
+	if len(b.partial) == 0 {
+		return
+	}
+	if now := b.now(); now.Sub(b.lastSample) >= b.sample {
+		b.lastSample = now
+		b.commitLine()
+		return
+	}
+	b.partial = b.partial[:0]
+	b.tag = 0
+}
+
 func (b *Buffer) commitLine() {
 	line := string(b.partial)
 	b.partial = b.partial[:0]
+	b.push(line, b.tag)
+	b.tag = 0
+}
+
+// AppendLine commits a whole line from stream s, as if it had been
+// written with a newline, without touching the partial line being
+// written.
+func (b *Buffer) AppendLine(line string, s Stream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.push(line, s)
+}
 
+func (b *Buffer) push(line string, tag Stream) {
 	b.lines[b.head] = line
+	b.tags[b.head] = tag
 	b.head = (b.head + 1) % b.capacity
 	if b.count < b.capacity {
 		b.count++
 	}
+	b.total++
 }
 
 // Last returns the most recent n committed lines (excludes any partial line).
@@ -92,6 +189,27 @@ func (b *Buffer) LastWithPartial(n int) []string {
 	return result
 }
 
+// Streams returns the same lines as LastWithPartial with the stream tag
+// of each.
+func (b *Buffer) Streams(n int) ([]string, []Stream) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if n <= 0 {
+		return nil, nil
+	}
+	committed := n
+	if len(b.partial) > 0 {
+		committed = n - 1
+	}
+	lines, tags := b.getLinesLocked(committed), b.getTagsLocked(committed)
+	if len(b.partial) > 0 {
+		lines = append(lines, string(b.partial))
+		tags = append(tags, b.tag)
+	}
+	return lines, tags
+}
+
 // SetCapacity resizes the buffer. If shrinking, the oldest lines are discarded.
 func (b *Buffer) SetCapacity(n int) {
 	b.mu.Lock()
@@ -101,10 +219,11 @@ func (b *Buffer) SetCapacity(n int) {
 		return
 	}
 
-	old := b.getLinesLocked(b.count)
+	old, oldTags := b.getLinesLocked(b.count), b.getTagsLocked(b.count)
 
 	b.capacity = n
 	b.lines = make([]string, n)
+	b.tags = make([]Stream, n)
 	b.head = 0
 	b.count = 0
 
@@ -112,8 +231,9 @@ func (b *Buffer) SetCapacity(n int) {
 	if len(old) > n {
 		start = len(old) - n
 	}
-	for _, line := range old[start:] {
+	for i, line := range old[start:] {
 		b.lines[b.head] = line
+		b.tags[b.head] = oldTags[start+i]
 		b.head = (b.head + 1) % b.capacity
 		b.count++
 	}
@@ -126,6 +246,15 @@ func (b *Buffer) Count() int {
 	return b.count
 }
 
+// Total returns the number of lines committed since the buffer was
+// created. Unlike Count it keeps growing after the buffer is full, so the
+// difference between two calls is the number of lines written in between.
+func (b *Buffer) Total() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.total
+}
+
 // Capacity returns the maximum number of lines the buffer can hold.
 func (b *Buffer) Capacity() int {
 	b.mu.RLock()
@@ -148,3 +277,19 @@ func (b *Buffer) getLinesLocked(n int) []string {
 	}
 	return result
 }
+
+func (b *Buffer) getTagsLocked(n int) []Stream {
+	if n <= 0 {
+		return nil
+	}
+	if n > b.count {
+		n = b.count
+	}
+
+	result := make([]Stream, n)
+	start := (b.head - n + b.capacity) % b.capacity
+	for i := 0; i < n; i++ {
+		result[i] = b.tags[(start+i)%b.capacity]
+	}
+	return result
+}
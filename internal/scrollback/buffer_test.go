@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -269,3 +270,91 @@ func TestAgentOutputPattern(t *testing.T) {
 		t.Errorf("last line: expected done message, got %q", lines[7])
 	}
 }
+
+func TestTotalCountsEvictedLines(t *testing.T) {
+	b := New(3)
+	b.Write([]byte("a\nb\nc\nd\ne\npartial"))
+	if b.Count() != 3 {
+		t.Errorf("expected count 3, got %d", b.Count())
+	}
+	if b.Total() != 5 {
+		t.Errorf("expected total 5, got %d", b.Total())
+	}
+	b.SetCapacity(10)
+	if b.Total() != 5 {
+		t.Errorf("expected total 5 after resize, got %d", b.Total())
+	}
+}
+
+func TestSampleInterval(t *testing.T) {
+	b := New(100)
+	clock := time.Unix(0, 0)
+	b.now = func() time.Time { return clock }
+	b.SetSampleInterval(time.Second)
+
+	b.Write([]byte("start\r\n"))
+	for i := 0; i <= 30; i++ {
+		b.Write([]byte(fmt.Sprintf("\r%3d%%", i)))
+		clock = clock.Add(100 * time.Millisecond)
+	}
+	b.Write([]byte("\r\ndone\r\n"))
+
+	got := b.Last(10)
+	want := []string{"start", "  0%", " 10%", " 20%", " 30%", "done"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestSampleIntervalCRLFSplitAcrossWrites(t *testing.T) {
+	b := New(10)
+	b.SetSampleInterval(time.Second)
+	b.Write([]byte("line1\r"))
+	b.Write([]byte("\nline2\r\n"))
+	got := b.Last(2)
+	if len(got) != 2 || got[0] != "line1" || got[1] != "line2" {
+		t.Errorf("expected [line1 line2], got %q", got)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	b := New(4)
+	b.Write([]byte("banner\n"))
+	b.WriteStream([]byte("ok 1\n"), Stdout)
+	b.WriteStream([]byte("warn: x\r\n"), Stderr)
+	b.WriteStream([]byte("ok 2 "), Stdout)
+	b.WriteStream([]byte("(slow)"), Stderr)
+	lines, tags := b.Streams(10)
+	want := []Stream{0, Stdout, Stderr, Stdout | Stderr}
+	if len(lines) != 4 || lines[3] != "ok 2 (slow)" || len(tags) != 4 {
+		t.Fatalf("got %q %v", lines, tags)
+	}
+	for i := range want {
+		if tags[i] != want[i] {
+			t.Errorf("line %d (%q): tag %d, want %d", i, lines[i], tags[i], want[i])
+		}
+	}
+	b.SetCapacity(2)
+	if _, tags := b.Streams(10); len(tags) != 3 || tags[0] != Stdout || tags[1] != Stderr {
+		t.Errorf("after resize: %v", tags)
+	}
+}
+
+func TestAppendLine(t *testing.T) {
+	b := New(10)
+	b.Write([]byte("one\npart"))
+	b.AppendLine("log line", Stdout)
+	b.Write([]byte("ial\n"))
+	lines, tags := b.Streams(10)
+	if fmt.Sprint(lines) != "[one log line partial]" || tags[1] != Stdout {
+		t.Errorf("got %q %v", lines, tags)
+	}
+	if b.Total() != 3 {
+		t.Errorf("total = %d", b.Total())
+	}
+}
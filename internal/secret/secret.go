@@ -0,0 +1,141 @@
+// Package secret fetches the secrets `wintmux new-session --secret-env`
+// puts in a pane's environment. A secret is named by a reference, such
+// as cred:TARGET, and fetched by the daemon each time it starts the
+// pane's process, so only the reference appears on the daemon's command
+// line.
+package secret
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// Sources are the places a reference may name.
+const (
+	// SourceCred is a generic credential in Windows Credential Manager,
+	// named by its target, as `cmdkey /generic:TARGET /user:U /pass`
+	// stores one.
+	SourceCred = "cred"
+
+	// SourceDPAPI is a file encrypted for the current user with DPAPI:
+	// either the blob itself or the hex text PowerShell's
+	// ConvertFrom-SecureString writes.
+	SourceDPAPI = "dpapi"
+
+	// SourceFile is a plain file, such as a mounted secret; a trailing
+	// newline is dropped.
+	SourceFile = "file"
+)
+
+// Ref is a parsed secret reference, SOURCE:NAME.
+type Ref struct {
+	Source string
+	Name   string // credential target or file path
+}
+
+// Parse parses a secret reference.
+func Parse(ref string) (Ref, error) {
+	source, name, ok := strings.Cut(ref, ":")
+	if !ok || name == "" {
+		return Ref{}, fmt.Errorf("invalid secret reference %q (expected cred:TARGET, dpapi:PATH or file:PATH)", ref)
+	}
+	switch source {
+	case SourceCred, SourceDPAPI, SourceFile:
+		return Ref{Source: source, Name: name}, nil
+	}
+	return Ref{}, fmt.Errorf("unknown secret source %q (expected cred, dpapi or file)", source)
+}
+
+// ParseEnv parses a --secret-env entry, NAME=REF.
+func ParseEnv(entry string) (string, Ref, error) {
+	name, ref, ok := strings.Cut(entry, "=")
+	if !ok || name == "" {
+		return "", Ref{}, fmt.Errorf("invalid secret env entry %q (expected NAME=SOURCE:NAME)", entry)
+	}
+	r, err := Parse(ref)
+	if err != nil {
+		return "", Ref{}, err
+	}
+	return name, r, nil
+}
+
+func (r Ref) String() string {
+	return r.Source + ":" + r.Name
+}
+
+// Fetch returns the secret r refers to. Errors name the reference, never
+// the value.
+func (r Ref) Fetch() (string, error) {
+	var data []byte
+	var err error
+	wide := false // stored as UTF-16LE, whatever its bytes look like
+	switch r.Source {
+	case SourceCred:
+		data, err = credRead(r.Name)
+		wide = true
+	case SourceDPAPI:
+		data, err = os.ReadFile(r.Name)
+		if err == nil {
+			var blob []byte
+			blob, wide = protectedBlob(data)
+			data, err = unprotect(blob)
+		}
+	case SourceFile:
+		data, err = os.ReadFile(r.Name)
+		if err == nil {
+			data = bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte("\r"))
+		}
+	default:
+		err = fmt.Errorf("unknown secret source %q", r.Source)
+	}
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %v", r, err)
+	}
+	switch {
+	case r.Source == SourceFile:
+		return string(data), nil
+	case wide:
+		return decodeUTF16(data), nil
+	}
+	return decodeText(data), nil
+}
+
+// protectedBlob returns the DPAPI blob in a dpapi file and whether it
+// holds a SecureString: the hex text ConvertFrom-SecureString writes is
+// decoded, anything else is the blob.
+func protectedBlob(data []byte) ([]byte, bool) {
+	text := bytes.TrimSpace(data)
+	if blob, err := hex.DecodeString(string(text)); err == nil && len(blob) > 0 {
+		return blob, true
+	}
+	return data, false
+}
+
+// decodeText turns a secret of unknown encoding into a string: text
+// with no NUL bytes is taken as UTF-8, anything else as UTF-16LE. The
+// guess fails for UTF-16 text with no NUL byte, such as most CJK text,
+// so sources known to store UTF-16 use decodeUTF16.
+func decodeText(b []byte) string {
+	if bytes.IndexByte(b, 0) < 0 {
+		return string(b)
+	}
+	return decodeUTF16(b)
+}
+
+// decodeUTF16 decodes UTF-16LE text, as Credential Manager and
+// SecureString store it, dropping a byte order mark. A blob of odd
+// length cannot be UTF-16 and is returned as it is.
+func decodeUTF16(b []byte) string {
+	if len(b)%2 != 0 {
+		return string(b)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return strings.TrimPrefix(string(utf16.Decode(u)), "\ufeff")
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package secret
+
+import "errors"
+
+func credRead(target string) ([]byte, error) {
+	return nil, errors.New("Credential Manager is only available on Windows")
+}
+
+func unprotect(blob []byte) ([]byte, error) {
+	return nil, errors.New("DPAPI is only available on Windows")
+}
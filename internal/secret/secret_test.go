@@ -0,0 +1,82 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	name, ref, err := ParseEnv("OPENAI_API_KEY=cred:agents/openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "OPENAI_API_KEY" || ref != (Ref{Source: SourceCred, Name: "agents/openai"}) {
+		t.Errorf("got %s, %+v", name, ref)
+	}
+	if _, ref, _ := ParseEnv(`TOKEN=dpapi:C:\secrets\token.txt`); ref.Name != `C:\secrets\token.txt` {
+		t.Errorf("dpapi path = %q", ref.Name)
+	}
+	for _, entry := range []string{"TOKEN", "=cred:x", "TOKEN=cred:", "TOKEN=hunter2", "TOKEN=vault:x"} {
+		if _, _, err := ParseEnv(entry); err == nil {
+			t.Errorf("expected error for %q", entry)
+		}
+	}
+}
+
+func TestFetchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("s3cr3t\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := Ref{Source: SourceFile, Name: path}.Fetch()
+	if err != nil || v != "s3cr3t" {
+		t.Errorf("Fetch = %q, %v", v, err)
+	}
+	_, err = Ref{Source: SourceFile, Name: path + ".missing"}.Fetch()
+	if err == nil || !strings.HasPrefix(err.Error(), "secret file:") {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestFetchWindowsOnly(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Credential Manager and DPAPI are available")
+	}
+	for _, ref := range []Ref{{Source: SourceCred, Name: "x"}, {Source: SourceDPAPI, Name: os.Args[0]}} {
+		if _, err := ref.Fetch(); err == nil || !strings.Contains(err.Error(), "only available on Windows") {
+			t.Errorf("%s: %v", ref, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, c := range []struct {
+		in   []byte
+		want string
+	}{
+		{[]byte("plain"), "plain"},
+		{[]byte("p\x00w\x00"), "pw"},
+		{[]byte("\xff\xfek\x00\xe9\x00"), "ké"},
+		{[]byte{}, ""},
+	} {
+		if got := decodeText(c.in); got != c.want {
+			t.Errorf("decodeText(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+	// "中文" is 2D 4E 87 65 in UTF-16LE: no NUL byte to go on.
+	if got := decodeUTF16([]byte("\x2d\x4e\x87\x65")); got != "中文" {
+		t.Errorf("decodeUTF16 = %q", got)
+	}
+	if got := decodeUTF16([]byte("\xff\xfep\x00\xe9\x00\x2d\x4e")); got != "pé中" {
+		t.Errorf("decodeUTF16 with BOM = %q", got)
+	}
+	if got, secure := protectedBlob([]byte("01000000d08c\r\n")); string(got) != "\x01\x00\x00\x00\xd0\x8c" || !secure {
+		t.Errorf("hex blob = %q, %v", got, secure)
+	}
+	if got, secure := protectedBlob([]byte("\x01\x00\x00\x00")); string(got) != "\x01\x00\x00\x00" || secure {
+		t.Errorf("binary blob = %q, %v", got, secure)
+	}
+}
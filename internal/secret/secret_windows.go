@@ -0,0 +1,88 @@
+//go:build windows
+
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32               = syscall.NewLazyDLL("advapi32.dll")
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCredReadW          = advapi32.NewProc("CredReadW")
+	procCredFree           = advapi32.NewProc("CredFree")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+const (
+	_CRED_TYPE_GENERIC         = 1
+	_CRYPTPROTECT_UI_FORBIDDEN = 0x1
+	_ERROR_NOT_FOUND           = syscall.Errno(1168)
+)
+
+// credential mirrors CREDENTIALW.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credRead returns the blob of the generic credential target.
+func credRead(target string) ([]byte, error) {
+	name, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return nil, err
+	}
+	var c *credential
+	r, _, e := procCredReadW.Call(uintptr(unsafe.Pointer(name)), _CRED_TYPE_GENERIC, 0, uintptr(unsafe.Pointer(&c)))
+	if r == 0 {
+		if errors.Is(e, _ERROR_NOT_FOUND) {
+			return nil, fmt.Errorf("no generic credential %q in Credential Manager", target)
+		}
+		return nil, fmt.Errorf("CredRead: %v", e)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(c)))
+	if c.CredentialBlobSize == 0 {
+		return nil, fmt.Errorf("credential %q has no password", target)
+	}
+	blob := make([]byte, c.CredentialBlobSize)
+	copy(blob, unsafe.Slice(c.CredentialBlob, c.CredentialBlobSize))
+	return blob, nil
+}
+
+// dataBlob mirrors DATA_BLOB.
+type dataBlob struct {
+	Size uint32
+	Data *byte
+}
+
+// unprotect decrypts a DPAPI blob protected for the current user.
+func unprotect(blob []byte) ([]byte, error) {
+	if len(blob) == 0 {
+		return nil, errors.New("empty file")
+	}
+	in := dataBlob{Size: uint32(len(blob)), Data: &blob[0]}
+	var out dataBlob
+	r, _, e := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(&in)), 0, 0, 0, 0, _CRYPTPROTECT_UI_FORBIDDEN, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, fmt.Errorf("CryptUnprotectData: %v", e)
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.Data)))
+	plain := make([]byte, out.Size)
+	copy(plain, unsafe.Slice(out.Data, out.Size))
+	return plain, nil
+}
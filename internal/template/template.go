@@ -0,0 +1,162 @@
+// Package template loads the session templates used by
+// `wintmux new-session --template`: JSON files describing a session's
+// command, working directory, environment, options and setup commands,
+// with ${name} variables filled in when the session is created.
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Template describes how to set up a session. Every string may refer to
+// variables as ${name}.
+type Template struct {
+	// Command, StartDir and Env are used for the pane's process when
+	// new-session does not give them itself.
+	Command  string   `json:"command,omitempty"`
+	StartDir string   `json:"start_dir,omitempty"`
+	Env      []string `json:"env,omitempty"`
+
+	// SecretEnv adds NAME=SOURCE:NAME entries to new-session's
+	// --secret-env, naming secrets rather than holding them.
+	SecretEnv []string `json:"secret_env,omitempty"`
+
+	// Options are set on the new session, in name order.
+	Options map[string]string `json:"options,omitempty"`
+
+	// Commands are wintmux commands run against the session once it is
+	// up, in order, each as its arguments: ["pipe-add", "-n", "log",
+	// "${agent}.log"].
+	Commands [][]string `json:"commands,omitempty"`
+
+	// Vars holds default variable values, overridden by --var.
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+// Dir returns the template directory: $WINTMUX_TEMPLATE_DIR if set,
+// otherwise wintmux/templates under the user config directory
+// (%AppData% on Windows).
+func Dir() (string, error) {
+	if dir := os.Getenv("WINTMUX_TEMPLATE_DIR"); dir != "" {
+		return dir, nil
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "wintmux", "templates"), nil
+}
+
+// Load reads the template called name: NAME.json in the template
+// directory, or the file name refers to if it is a path.
+func Load(name string) (*Template, error) {
+	path := name
+	if !strings.ContainsAny(name, `/\`) && !strings.HasSuffix(name, ".json") {
+		dir, err := Dir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, name+".json")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no template %s (%s)", name, path)
+		}
+		return nil, err
+	}
+	var t Template
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("template %s: %v", name, err)
+	}
+	for _, args := range t.Commands {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("template %s: empty command", name)
+		}
+	}
+	return &t, nil
+}
+
+var varRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_-]*)\}`)
+
+// Expand returns a copy of t with every ${name} replaced by its value in
+// vars, falling back to t.Vars. A reference to a variable with no value
+// is an error, so a missing --var is caught before the session starts.
+// "$${" stands for a literal "${".
+func (t *Template) Expand(vars map[string]string) (*Template, error) {
+	var missing []string
+	expand := func(s string) string {
+		parts := strings.Split(s, "$${")
+		for i, p := range parts {
+			parts[i] = varRef.ReplaceAllStringFunc(p, func(ref string) string {
+				name := varRef.FindStringSubmatch(ref)[1]
+				if v, ok := vars[name]; ok {
+					return v
+				}
+				if v, ok := t.Vars[name]; ok {
+					return v
+				}
+				missing = append(missing, name)
+				return ref
+			})
+		}
+		return strings.Join(parts, "${")
+	}
+
+	out := &Template{
+		Command:  expand(t.Command),
+		StartDir: expand(t.StartDir),
+	}
+	for _, e := range t.Env {
+		out.Env = append(out.Env, expand(e))
+	}
+	for _, e := range t.SecretEnv {
+		out.SecretEnv = append(out.SecretEnv, expand(e))
+	}
+	if len(t.Options) > 0 {
+		out.Options = make(map[string]string, len(t.Options))
+		for name, v := range t.Options {
+			out.Options[name] = expand(v)
+		}
+	}
+	for _, args := range t.Commands {
+		expanded := make([]string, len(args))
+		for i, a := range args {
+			expanded[i] = expand(a)
+		}
+		out.Commands = append(out.Commands, expanded)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		names := dedupe(missing)
+		return nil, fmt.Errorf("no value for template variable %s (use --var %s=VALUE)", strings.Join(names, ", "), names[0])
+	}
+	return out, nil
+}
+
+// OptionNames returns the names of t's options in the order they are
+// set.
+func (t *Template) OptionNames() []string {
+	names := make([]string, 0, len(t.Options))
+	for name := range t.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func dedupe(sorted []string) []string {
+	out := sorted[:0]
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadExpand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("WINTMUX_TEMPLATE_DIR", dir)
+	tpl := `{
+		"command": "pwsh -NoLogo -Command ${task}",
+		"start_dir": "C:\\work\\${repo}",
+		"env": ["AGENT_ID=${agent}"],
+		"secret_env": ["API_KEY=cred:agents/${agent}"],
+		"options": {"record-input": "on", "history-limit": "${history}"},
+		"commands": [["send-keys", "echo $${HOME} ${agent}", "Enter"]],
+		"vars": {"history": "50000", "agent": "a0"}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "agent.json"), []byte(tpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load("agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loaded.Expand(map[string]string{"task": "build", "repo": "api", "agent": "a7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Template{
+		Command:   "pwsh -NoLogo -Command build",
+		StartDir:  `C:\work\api`,
+		Env:       []string{"AGENT_ID=a7"},
+		SecretEnv: []string{"API_KEY=cred:agents/a7"},
+		Options:   map[string]string{"record-input": "on", "history-limit": "50000"},
+		Commands:  [][]string{{"send-keys", "echo ${HOME} a7", "Enter"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expand = %+v, want %+v", got, want)
+	}
+	if names := strings.Join(got.OptionNames(), " "); names != "history-limit record-input" {
+		t.Errorf("OptionNames = %q", names)
+	}
+
+	if _, err := loaded.Expand(nil); err == nil || !strings.Contains(err.Error(), "repo, task") {
+		t.Errorf("missing vars: %v", err)
+	}
+	if _, err := Load(filepath.Join(dir, "agent.json")); err != nil {
+		t.Errorf("load by path: %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("WINTMUX_TEMPLATE_DIR", dir)
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"command": `), 0o644)
+	os.WriteFile(filepath.Join(dir, "empty.json"), []byte(`{"commands": [[]]}`), 0o644)
+	for _, name := range []string{"missing", "broken", "empty"} {
+		if _, err := Load(name); err == nil {
+			t.Errorf("Load(%q) succeeded", name)
+		}
+	}
+}
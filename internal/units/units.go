@@ -0,0 +1,60 @@
+// Package units parses human-friendly quantities used in option values
+// and command flags, such as "50MB" or "4GB".
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var sizeSuffixes = []struct {
+	suffix string
+	mult   uint64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"TB", 1 << 40},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseSize parses a byte count with an optional binary suffix (K, KB, M,
+// MB, G, GB, T, TB; case-insensitive). A bare number is bytes.
+func ParseSize(s string) (uint64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	mult := uint64(1)
+	for _, sf := range sizeSuffixes {
+		if strings.HasSuffix(v, sf.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, sf.suffix))
+			mult = sf.mult
+			break
+		}
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxUint64/mult {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * mult, nil
+}
+
+// FormatSize renders n using the largest suffix that divides it evenly.
+func FormatSize(n uint64) string {
+	for _, sf := range []struct {
+		suffix string
+		mult   uint64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n >= sf.mult && n%sf.mult == 0 {
+			return strconv.FormatUint(n/sf.mult, 10) + sf.suffix
+		}
+	}
+	return strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,53 @@
+package units
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"1024", 1024},
+		{"50MB", 50 << 20},
+		{"50mb", 50 << 20},
+		{"4G", 4 << 30},
+		{"4 GB", 4 << 30},
+		{"12KB", 12 << 10},
+		{"7B", 7},
+		{"16777215TB", 16777215 << 40},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil {
+			t.Errorf("ParseSize(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseSizeInvalid(t *testing.T) {
+	for _, in := range []string{"", "MB", "-5MB", "1.5GB", "ten", "20000000000GB", "16777216TB"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q): expected error", in)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[uint64]string{
+		0:          "0",
+		1000:       "1000",
+		1 << 20:    "1MB",
+		50 << 20:   "50MB",
+		4 << 30:    "4GB",
+		1536 << 10: "1536KB",
+	}
+	for in, want := range tests {
+		if got := FormatSize(in); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package vt
+
+import "strings"
+
+// Clean renders one line of terminal output (without its newline) as the
+// text a reader would see. Escape sequences are removed, except that
+// erase-in-line (CSI K) is applied first. A carriage return goes back to
+// the start of the line, so progress redraws leave only their final
+// state. A backspace at the end of the line erases the character before
+// it, as shells echo rubout; elsewhere it moves back one character. Other
+// control characters except tab are dropped, as are trailing blanks.
+func Clean(line string) string {
+	var cells []rune
+	col := 0
+	put := func(text string) {
+		for _, r := range text {
+			switch {
+			case r == '\r':
+				col = 0
+			case r == '\b':
+				if col == 0 {
+					continue
+				}
+				col--
+				if col == len(cells)-1 {
+					cells = cells[:col]
+				}
+			case r < 0x20 && r != '\t', r == 0x7f:
+			default:
+				if col < len(cells) {
+					cells[col] = r
+				} else {
+					cells = append(cells, r)
+				}
+				col++
+			}
+		}
+	}
+
+	last := 0
+	for _, m := range escapePattern.FindAllStringIndex(line, -1) {
+		put(line[last:m[0]])
+		last = m[1]
+		seq := line[m[0]:m[1]]
+		if !strings.HasPrefix(seq, "\x1b[") || !strings.HasSuffix(seq, "K") {
+			continue
+		}
+		switch seq[2 : len(seq)-1] {
+		case "", "0": // to end of line
+			if col < len(cells) {
+				cells = cells[:col]
+			}
+		case "1": // to cursor
+			for i := 0; i < col && i < len(cells); i++ {
+				cells[i] = ' '
+			}
+		case "2": // whole line
+			cells = cells[:0]
+		}
+	}
+	put(line[last:])
+	return strings.TrimRight(string(cells), " \t")
+}
@@ -0,0 +1,29 @@
+package vt
+
+import "testing"
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"colors", "\x1b[31merror\x1b[0m: failed", "error: failed"},
+		{"progress redraw", "10%\r20%\r100%", "100%"},
+		{"shorter redraw overwrites", "downloading\rdone", "doneloading"},
+		{"redraw with erase", "downloading\r\x1b[Kdone", "done"},
+		{"erase whole line", "junk\x1b[2K\rok", "ok"},
+		{"rubout", "lss\b \b -la", "ls -la"},
+		{"backspace at end", "abc\b", "ab"},
+		{"repeated backspace", "abc\b\bX", "aX"},
+		{"backspace inside line", "abc\r\bX\b\bY", "Ybc"},
+		{"crlf", "line\r", "line"},
+		{"bell and osc", "\x1b]0;title\x07ding\x07", "ding"},
+		{"trailing blanks", "text   ", "text"},
+		{"tab kept", "a\tb", "a\tb"},
+	}
+	for _, tt := range tests {
+		if got := Clean(tt.in); got != tt.want {
+			t.Errorf("%s: Clean(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package vt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Colors is how many colors a terminal shows: "truecolor" (24-bit), "256"
+// or "16".
+type Colors string
+
+const (
+	ColorsTrue Colors = "truecolor"
+	Colors256  Colors = "256"
+	Colors16   Colors = "16"
+)
+
+// ParseColors validates a color depth name; "24bit" is accepted for
+// truecolor.
+func ParseColors(s string) (Colors, error) {
+	switch c := Colors(s); c {
+	case ColorsTrue, Colors256, Colors16:
+		return c, nil
+	case "24bit":
+		return ColorsTrue, nil
+	}
+	return "", fmt.Errorf("unknown color depth %q (expected truecolor, 256 or 16)", s)
+}
+
+// maxPending bounds the unfinished escape sequence a ColorFilter holds
+// back for the next chunk; a longer one is passed on as it is.
+const maxPending = 256
+
+// ColorFilter rewrites the colors in SGR sequences (CSI ... m) of an
+// output stream for a terminal with fewer colors: 24-bit colors become
+// the nearest of the 256 or 16 colors, and with 16 colors 256-color
+// indexes do too. Underline colors (SGR 58) are dropped for 16 colors.
+// Everything else passes through unchanged. Sequences split across
+// chunks are held back until complete.
+type ColorFilter struct {
+	depth   Colors
+	pending []byte
+}
+
+// NewColorFilter returns a filter for a terminal with depth colors, or nil
+// for truecolor, which needs none.
+func NewColorFilter(depth Colors) *ColorFilter {
+	if depth == ColorsTrue || depth == "" {
+		return nil
+	}
+	return &ColorFilter{depth: depth}
+}
+
+// Filter returns data with its colors rewritten. It does not modify data,
+// and returns it as is when nothing changes.
+func (f *ColorFilter) Filter(data []byte) []byte {
+	if len(f.pending) > 0 {
+		data = append(f.pending, data...)
+		f.pending = nil
+	}
+	var out []byte // nil until something changes
+	copied := 0
+	for i := 0; i < len(data); i++ {
+		if data[i] != 0x1b {
+			continue
+		}
+		if i+1 == len(data) {
+			f.hold(data[i:])
+			return f.finish(data, out, copied, i)
+		}
+		if data[i+1] != '[' {
+			continue
+		}
+		// CSI: parameter bytes, intermediate bytes, final byte.
+		j := i + 2
+		for j < len(data) && data[j] >= 0x20 && data[j] <= 0x3f {
+			j++
+		}
+		if j == len(data) {
+			if len(data)-i <= maxPending {
+				f.hold(data[i:])
+				return f.finish(data, out, copied, i)
+			}
+			break
+		}
+		if data[j] != 'm' {
+			i = j
+			continue
+		}
+		params := string(data[i+2 : j])
+		if sgr, changed := f.rewrite(params); changed {
+			out = append(out, data[copied:i]...)
+			out = append(out, "\x1b["+sgr+"m"...)
+			copied = j + 1
+		}
+		i = j
+	}
+	return f.finish(data, out, copied, len(data))
+}
+
+func (f *ColorFilter) hold(tail []byte) {
+	f.pending = append([]byte(nil), tail...)
+}
+
+// finish returns data up to end, with the rewritten prefix out (covering
+// data up to copied) when there is one.
+func (f *ColorFilter) finish(data, out []byte, copied, end int) []byte {
+	if out == nil {
+		return data[:end]
+	}
+	return append(out, data[copied:end]...)
+}
+
+// rewrite returns the SGR parameters params with their colors converted,
+// and whether anything changed.
+func (f *ColorFilter) rewrite(params string) (string, bool) {
+	if !strings.ContainsRune(params, '8') || strings.ContainsAny(params, "<=>?") {
+		return params, false
+	}
+	tokens := strings.Split(params, ";")
+	var out []string
+	changed := false
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		kind, sub, _ := strings.Cut(tok, ":")
+		if kind != "38" && kind != "48" && kind != "58" {
+			out = append(out, tok)
+			continue
+		}
+		// The color's arguments: colon subparameters, or the following
+		// semicolon parameters.
+		var args []string
+		if sub != "" {
+			args = strings.Split(sub, ":")
+			if args[0] == "2" && len(args) == 5 {
+				args = append(args[:1], args[2:]...) // drop the color space id
+			}
+		} else if i+1 < len(tokens) {
+			n := 0
+			switch tokens[i+1] {
+			case "5":
+				n = 2
+			case "2":
+				n = 4
+			}
+			if i+n < len(tokens) {
+				args = tokens[i+1 : i+1+n]
+				i += n
+			}
+		}
+		repl, ok := f.color(kind, args)
+		if !ok {
+			out = append(out, tok)
+			if sub == "" {
+				out = append(out, args...)
+			}
+			continue
+		}
+		changed = true
+		if repl != "" {
+			out = append(out, repl)
+		}
+	}
+	return strings.Join(out, ";"), changed
+}
+
+// color converts one color of kind 38 (foreground), 48 (background) or
+// 58 (underline) given as args ("5", index or "2", r, g, b). It reports
+// false when the color needs no change or is not understood; an empty
+// replacement drops it.
+func (f *ColorFilter) color(kind string, args []string) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+	var n int
+	switch {
+	case args[0] == "2" && len(args) == 4:
+		var rgb [3]int
+		for k := range rgb {
+			v, err := strconv.Atoi(args[k+1])
+			if err != nil {
+				return "", false
+			}
+			rgb[k] = min(max(v, 0), 255)
+		}
+		if f.depth == Colors256 {
+			return kind + ";5;" + strconv.Itoa(rgbTo256(rgb[0], rgb[1], rgb[2])), true
+		}
+		n = rgbTo16(rgb[0], rgb[1], rgb[2])
+	case args[0] == "5" && len(args) == 2:
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 || v > 255 || f.depth == Colors256 {
+			return "", false
+		}
+		r, g, b := rgbOf256(v)
+		n = v
+		if v >= 16 {
+			n = rgbTo16(r, g, b)
+		}
+	default:
+		return "", false
+	}
+	switch kind {
+	case "38":
+		if n < 8 {
+			return strconv.Itoa(30 + n), true
+		}
+		return strconv.Itoa(90 + n - 8), true
+	case "48":
+		if n < 8 {
+			return strconv.Itoa(40 + n), true
+		}
+		return strconv.Itoa(100 + n - 8), true
+	}
+	return "", true
+}
+
+// palette16 holds xterm's default values of the 16 basic colors.
+var palette16 = [16][3]int{
+	{0x00, 0x00, 0x00}, {0xcd, 0x00, 0x00}, {0x00, 0xcd, 0x00}, {0xcd, 0xcd, 0x00},
+	{0x00, 0x00, 0xee}, {0xcd, 0x00, 0xcd}, {0x00, 0xcd, 0xcd}, {0xe5, 0xe5, 0xe5},
+	{0x7f, 0x7f, 0x7f}, {0xff, 0x00, 0x00}, {0x00, 0xff, 0x00}, {0xff, 0xff, 0x00},
+	{0x5c, 0x5c, 0xff}, {0xff, 0x00, 0xff}, {0x00, 0xff, 0xff}, {0xff, 0xff, 0xff},
+}
+
+// cubeLevels are the channel values of the 6x6x6 color cube (16-231).
+var cubeLevels = [6]int{0x00, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+// rgbOf256 returns the value of color n of the 256-color palette.
+func rgbOf256(n int) (int, int, int) {
+	switch {
+	case n < 16:
+		c := palette16[n]
+		return c[0], c[1], c[2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	}
+	v := 8 + 10*(n-232)
+	return v, v, v
+}
+
+// rgbTo256 returns the cube color or gray of the 256-color palette
+// nearest to r, g, b, as tmux chooses it.
+func rgbTo256(r, g, b int) int {
+	qr, qg, qb := cubeIndex(r), cubeIndex(g), cubeIndex(b)
+	cr, cg, cb := cubeLevels[qr], cubeLevels[qg], cubeLevels[qb]
+	cube := 16 + 36*qr + 6*qg + qb
+	if cr == r && cg == g && cb == b {
+		return cube
+	}
+	avg := (r + g + b) / 3
+	gi := 23
+	if avg <= 238 {
+		gi = max(avg-3, 0) / 10
+	}
+	gv := 8 + 10*gi
+	if distance(cr, cg, cb, r, g, b) <= distance(gv, gv, gv, r, g, b) {
+		return cube
+	}
+	return 232 + gi
+}
+
+func cubeIndex(v int) int {
+	switch {
+	case v < 48:
+		return 0
+	case v < 115:
+		return 1
+	}
+	return (v - 35) / 40
+}
+
+// rgbTo16 returns the basic color nearest to r, g, b.
+func rgbTo16(r, g, b int) int {
+	best, bestDist := 0, -1
+	for i, c := range palette16 {
+		if d := distance(c[0], c[1], c[2], r, g, b); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func distance(r1, g1, b1, r2, g2, b2 int) int {
+	return (r1-r2)*(r1-r2) + (g1-g2)*(g1-g2) + (b1-b2)*(b1-b2)
+}
@@ -0,0 +1,50 @@
+package vt
+
+import "testing"
+
+func TestColorFilter(t *testing.T) {
+	for _, c := range []struct {
+		depth    Colors
+		in, want string
+	}{
+		{Colors256, "\x1b[38;2;255;0;0mred\x1b[0m", "\x1b[38;5;196mred\x1b[0m"},
+		{Colors256, "\x1b[1;48;2;0;0;0;4m", "\x1b[1;48;5;16;4m"},
+		{Colors256, "\x1b[38:2::128:128:128m", "\x1b[38;5;244m"},
+		{Colors256, "\x1b[38;5;33m\x1b[31m", "\x1b[38;5;33m\x1b[31m"},
+		{Colors16, "\x1b[38;2;250;250;250;48;5;196m", "\x1b[97;101m"},
+		{Colors16, "\x1b[38;5;2m\x1b[48;5;12m", "\x1b[32m\x1b[104m"},
+		{Colors16, "\x1b[4;58;2;1;2;3m", "\x1b[4m"},
+		{Colors16, "\x1b[?25l\x1b]8;;x\x1b\\\x1b[2J", "\x1b[?25l\x1b]8;;x\x1b\\\x1b[2J"},
+	} {
+		if got := string(NewColorFilter(c.depth).Filter([]byte(c.in))); got != c.want {
+			t.Errorf("%s %q = %q, want %q", c.depth, c.in, got, c.want)
+		}
+	}
+	if NewColorFilter(ColorsTrue) != nil {
+		t.Error("truecolor needs no filter")
+	}
+}
+
+func TestColorFilterSplitSequence(t *testing.T) {
+	f := NewColorFilter(Colors256)
+	var out []byte
+	for _, chunk := range []string{"a\x1b", "[38;2;0;0", ";255mb", "\x1b[0m"} {
+		out = append(out, f.Filter([]byte(chunk))...)
+	}
+	if got := string(out); got != "a\x1b[38;5;21mb\x1b[0m" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRGBTo256(t *testing.T) {
+	for rgb, want := range map[[3]int]int{
+		{0, 0, 0}:          16,
+		{255, 255, 255}:    231,
+		{0x5f, 0x87, 0xaf}: 16 + 36*1 + 6*2 + 3,
+		{100, 100, 100}:    241,
+	} {
+		if got := rgbTo256(rgb[0], rgb[1], rgb[2]); got != want {
+			t.Errorf("rgbTo256%v = %d, want %d", rgb, got, want)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package vt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mods is a set of key modifiers, with the bit values xterm and the kitty
+// keyboard protocol encode as 1 + Mods.
+type Mods int
+
+const (
+	ModShift Mods = 1 << iota
+	ModAlt
+	ModCtrl
+)
+
+// KeyMode is how the application has asked for keys to be sent: modified
+// keys with xterm's modifyOtherKeys (CSI > 4 ; n m) or the kitty keyboard
+// protocol (CSI > flags u), cursor keys in application mode (DECCKM,
+// CSI ? 1 h) and the numeric keypad in application mode (DECKPAM, ESC =).
+// It also holds the other input the application asked the terminal for,
+// mouse reports and bracketed paste, which an attached terminal must be
+// put in as well. The zero value is the legacy encoding.
+type KeyMode struct {
+	ModifyOtherKeys int  // 0, 1 or 2
+	Kitty           int  // progressive enhancement flags; 0 when off
+	CursorKeys      bool // unmodified cursor keys as SS3 final
+	Keypad          bool // keypad keys as SS3 application codes
+	Mouse           int  // mouse tracking mode 1000, 1002 or 1003; 0 when off
+	MouseFormat     int  // mouse report format mode 1005, 1006 or 1015; 0 for X10
+	BracketedPaste  bool // pasted text between CSI 200 ~ and CSI 201 ~ (mode 2004)
+}
+
+// Kitty keyboard protocol flags acted on when encoding.
+const (
+	KittyDisambiguate = 1 // modified and Escape keys as CSI u
+	KittyAllKeys      = 8 // every key as an escape code
+)
+
+// String names the mode as tmux's #{pane_key_mode} does, adding kitty.
+// The cursor and keypad modes have format variables of their own.
+func (m KeyMode) String() string {
+	switch {
+	case m.Kitty != 0:
+		return "Kitty " + strconv.Itoa(m.Kitty)
+	case m.ModifyOtherKeys != 0:
+		return "Ext " + strconv.Itoa(m.ModifyOtherKeys)
+	}
+	return "VT10x"
+}
+
+// Sequence returns the escape sequences that put a terminal in mode m,
+// for one attaching while the application has it set; "" for legacy.
+func (m KeyMode) Sequence() string {
+	var b strings.Builder
+	if m.ModifyOtherKeys != 0 {
+		fmt.Fprintf(&b, "\x1b[>4;%dm", m.ModifyOtherKeys)
+	}
+	if m.Kitty != 0 {
+		fmt.Fprintf(&b, "\x1b[>%du", m.Kitty)
+	}
+	if m.CursorKeys {
+		b.WriteString("\x1b[?1h")
+	}
+	if m.Keypad {
+		b.WriteString("\x1b=")
+	}
+	if m.Mouse != 0 {
+		fmt.Fprintf(&b, "\x1b[?%dh", m.Mouse)
+	}
+	if m.MouseFormat != 0 {
+		fmt.Fprintf(&b, "\x1b[?%dh", m.MouseFormat)
+	}
+	if m.BracketedPaste {
+		b.WriteString("\x1b[?2004h")
+	}
+	return b.String()
+}
+
+// ResetKeyMode turns off both protocols, the application cursor and
+// keypad modes, mouse reports and bracketed paste, whatever the
+// application left set; terminals that know neither protocol ignore that
+// part.
+const ResetKeyMode = "\x1b[>4m\x1b[=0;1u\x1b[?1l\x1b>\x1b[?1000;1002;1003;1005;1006;1015;2004l"
+
+// functionKey describes a key sent as CSI num final (CSI num;mod final
+// when modified); ss3 keys are sent as SS3 final when unmodified.
+type functionKey struct {
+	num   int
+	final byte
+	ss3   bool
+}
+
+var functionKeys = map[string]functionKey{
+	"Up":       {1, 'A', false},
+	"Down":     {1, 'B', false},
+	"Right":    {1, 'C', false},
+	"Left":     {1, 'D', false},
+	"Home":     {1, 'H', false},
+	"End":      {1, 'F', false},
+	"IC":       {2, '~', false},
+	"DC":       {3, '~', false},
+	"PageUp":   {5, '~', false},
+	"PPage":    {5, '~', false},
+	"PageDown": {6, '~', false},
+	"NPage":    {6, '~', false},
+	"F1":       {1, 'P', true},
+	"F2":       {1, 'Q', true},
+	"F3":       {1, 'R', true},
+	"F4":       {1, 'S', true},
+	"F5":       {15, '~', false},
+	"F6":       {17, '~', false},
+	"F7":       {18, '~', false},
+	"F8":       {19, '~', false},
+	"F9":       {20, '~', false},
+	"F10":      {21, '~', false},
+	"F11":      {23, '~', false},
+	"F12":      {24, '~', false},
+}
+
+// keypadKeys are the numeric keypad's keys: the character each sends,
+// and the final of the SS3 sequence it sends in application keypad mode.
+var keypadKeys = map[string]struct {
+	char  rune
+	final byte
+}{
+	"KP0":     {'0', 'p'},
+	"KP1":     {'1', 'q'},
+	"KP2":     {'2', 'r'},
+	"KP3":     {'3', 's'},
+	"KP4":     {'4', 't'},
+	"KP5":     {'5', 'u'},
+	"KP6":     {'6', 'v'},
+	"KP7":     {'7', 'w'},
+	"KP8":     {'8', 'x'},
+	"KP9":     {'9', 'y'},
+	"KP.":     {'.', 'n'},
+	"KP+":     {'+', 'k'},
+	"KP-":     {'-', 'm'},
+	"KP*":     {'*', 'j'},
+	"KP/":     {'/', 'o'},
+	"KPEnter": {'\r', 'M'},
+}
+
+// textKeys are the named keys that send a character.
+var textKeys = map[string]rune{
+	"Enter":  '\r',
+	"Escape": 0x1b,
+	"BSpace": 0x7f,
+	"Tab":    '\t',
+	"Space":  ' ',
+}
+
+// Key is a key with modifiers, as named in send-keys: tmux's C-, M- and
+// S- prefixes on a key name (Enter, F5, PageUp, ...) or one character.
+type Key struct {
+	Name string
+	Mods Mods
+}
+
+// ParseKey parses a tmux key name such as C-c, M-Enter, C-S-Left, F5 or
+// KP7. BTab is S-Tab.
+func ParseKey(name string) (Key, error) {
+	var k Key
+	rest := name
+	for len(rest) > 2 && rest[1] == '-' {
+		switch rest[0] {
+		case 'C':
+			k.Mods |= ModCtrl
+		case 'M':
+			k.Mods |= ModAlt
+		case 'S':
+			k.Mods |= ModShift
+		default:
+			return Key{}, fmt.Errorf("unknown key: %s", name)
+		}
+		rest = rest[2:]
+	}
+	if rest == "BTab" {
+		rest = "Tab"
+		k.Mods |= ModShift
+	}
+	k.Name = rest
+	if _, ok := functionKeys[rest]; ok {
+		return k, nil
+	}
+	if _, ok := textKeys[rest]; ok {
+		return k, nil
+	}
+	if _, ok := keypadKeys[rest]; ok {
+		return k, nil
+	}
+	if r, size := utf8.DecodeRuneInString(rest); r != utf8.RuneError && size == len(rest) && unicode.IsPrint(r) {
+		return k, nil
+	}
+	return Key{}, fmt.Errorf("unknown key: %s", name)
+}
+
+// String formats k as tmux names keys, the form ParseKey reads back:
+// modifiers in the order C-, M-, S-, and S-Tab as BTab. Names that parse
+// to the same key format the same.
+func (k Key) String() string {
+	if k.Name == "Tab" && k.Mods == ModShift {
+		return "BTab"
+	}
+	var b strings.Builder
+	if k.Mods&ModCtrl != 0 {
+		b.WriteString("C-")
+	}
+	if k.Mods&ModAlt != 0 {
+		b.WriteString("M-")
+	}
+	if k.Mods&ModShift != 0 {
+		b.WriteString("S-")
+	}
+	return b.String() + k.Name
+}
+
+// IsKeyName reports whether send-keys should send name as a key rather
+// than as text: it names a key, and is not a single unmodified
+// character.
+func IsKeyName(name string) bool {
+	k, err := ParseKey(name)
+	if err != nil {
+		return false
+	}
+	return k.Mods != 0 || utf8.RuneCountInString(k.Name) > 1
+}
+
+// Encode returns the bytes a terminal in mode m sends for k. Function
+// keys use xterm's encoding in every mode; unmodified cursor keys and
+// keypad keys are sent as SS3 sequences when the application has set
+// their application mode, and keypad keys as characters otherwise. Modified characters use the
+// kitty protocol's CSI code;mod u or modifyOtherKeys' CSI 27;mod;code ~
+// when the application asked for them; modifyOtherKeys level 1 only for
+// those the legacy encoding loses (C-S-a, C-Enter). Otherwise they are
+// sent as control characters, with ESC for Alt, as far as that goes.
+func (k Key) Encode(m KeyMode) []byte {
+	if f, ok := functionKeys[k.Name]; ok {
+		return f.encode(k.Mods, m.CursorKeys)
+	}
+	code, ok := textKeys[k.Name]
+	if p, keypad := keypadKeys[k.Name]; keypad {
+		if m.Keypad && k.Mods == 0 {
+			return []byte{0x1b, 'O', p.final}
+		}
+		code, ok = p.char, true
+	}
+	if !ok {
+		code, _ = utf8.DecodeRuneInString(k.Name)
+	}
+	mod := strconv.Itoa(int(k.Mods) + 1)
+	legacy, exact := legacyText(code, k.Mods)
+
+	shiftedChar := k.Mods&^ModShift == 0 && unicode.IsPrint(code) && code != ' '
+	switch {
+	case m.Kitty&KittyAllKeys != 0, m.Kitty&KittyDisambiguate != 0 && (code == 0x1b || k.Mods != 0 && !shiftedChar):
+		if k.Mods == 0 {
+			return []byte(fmt.Sprintf("\x1b[%du", code))
+		}
+		return []byte(fmt.Sprintf("\x1b[%d;%su", code, mod))
+	case k.Mods == 0 || shiftedChar:
+		return legacy
+	case m.ModifyOtherKeys == 2, m.ModifyOtherKeys == 1 && !exact:
+		return []byte(fmt.Sprintf("\x1b[27;%s;%d~", mod, code))
+	}
+	return legacy
+}
+
+// encode returns the sequence for the key with mods. appCursor is DECCKM,
+// which sends the cursor keys (Up to End, the CSI 1 keys that are not
+// always SS3) as SS3 when unmodified.
+func (f functionKey) encode(mods Mods, appCursor bool) []byte {
+	switch {
+	case mods != 0 && f.final == '~':
+		return []byte(fmt.Sprintf("\x1b[%d;%d~", f.num, int(mods)+1))
+	case mods != 0:
+		return []byte(fmt.Sprintf("\x1b[1;%d%c", int(mods)+1, f.final))
+	case f.ss3, appCursor && f.num == 1:
+		return []byte{0x1b, 'O', f.final}
+	case f.final == '~':
+		return []byte(fmt.Sprintf("\x1b[%d~", f.num))
+	}
+	return []byte{0x1b, '[', f.final}
+}
+
+// legacyText encodes a modified character the way terminals without
+// either protocol do, and reports whether that kept every modifier:
+// C-S-a is sent as C-a, C-Enter as Enter.
+func legacyText(code rune, mods Mods) ([]byte, bool) {
+	exact := true
+	if mods&ModShift != 0 {
+		switch up := unicode.ToUpper(code); {
+		case code == '\t' && mods&ModCtrl == 0:
+			seq := []byte("\x1b[Z")
+			if mods&ModAlt != 0 {
+				seq = append([]byte{0x1b}, seq...)
+			}
+			return seq, true
+		case up != code && mods&ModCtrl == 0:
+			code = up
+		default:
+			exact = false
+		}
+	}
+	if mods&ModCtrl != 0 {
+		if c, ok := ctrlCode(code); ok {
+			code = c
+		} else {
+			exact = false
+		}
+	}
+	seq := []byte(string(code))
+	if mods&ModAlt != 0 {
+		seq = append([]byte{0x1b}, seq...)
+	}
+	return seq, exact
+}
+
+// ctrlCode returns the control character Ctrl turns r into, as xterm
+// sends it.
+func ctrlCode(r rune) (rune, bool) {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return r & 0x1f, true
+	case r >= '[' && r <= '_':
+		return r - '@', true
+	case r >= '3' && r <= '7':
+		return r - '3' + 0x1b, true
+	}
+	switch r {
+	case '@', ' ', '2':
+		return 0, true
+	case '/':
+		return 0x1f, true
+	case '?', '8':
+		return 0x7f, true
+	case 0x7f:
+		return 0x08, true
+	}
+	return 0, false
+}
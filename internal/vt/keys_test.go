@@ -0,0 +1,77 @@
+package vt
+
+import "testing"
+
+func TestKeyEncode(t *testing.T) {
+	legacy := KeyMode{}
+	other1 := KeyMode{ModifyOtherKeys: 1}
+	other2 := KeyMode{ModifyOtherKeys: 2}
+	kitty := KeyMode{Kitty: KittyDisambiguate}
+	for _, c := range []struct {
+		name string
+		mode KeyMode
+		want string
+	}{
+		{"Enter", legacy, "\r"},
+		{"C-c", legacy, "\x03"},
+		{"M-x", legacy, "\x1bx"},
+		{"S-a", legacy, "A"},
+		{"BTab", legacy, "\x1b[Z"},
+		{"C-Space", legacy, "\x00"},
+		{"C-S-a", legacy, "\x01"},
+		{"C-Enter", legacy, "\r"},
+		{"Up", legacy, "\x1b[A"},
+		{"C-Up", kitty, "\x1b[1;5A"},
+		{"S-F1", legacy, "\x1b[1;2P"},
+		{"F1", other2, "\x1bOP"},
+		{"M-F5", legacy, "\x1b[15;3~"},
+		{"C-a", other1, "\x01"},
+		{"C-S-a", other1, "\x1b[27;6;97~"},
+		{"C-Enter", other1, "\x1b[27;5;13~"},
+		{"C-a", other2, "\x1b[27;5;97~"},
+		{"S-a", other2, "A"},
+		{"C-S-a", kitty, "\x1b[97;6u"},
+		{"M-Enter", kitty, "\x1b[13;3u"},
+		{"Escape", kitty, "\x1b[27u"},
+		{"S-a", kitty, "A"},
+		{"Enter", kitty, "\r"},
+		{"Enter", KeyMode{Kitty: KittyDisambiguate | KittyAllKeys}, "\x1b[13u"},
+		{"Up", KeyMode{CursorKeys: true}, "\x1bOA"},
+		{"End", KeyMode{CursorKeys: true}, "\x1bOF"},
+		{"C-Up", KeyMode{CursorKeys: true}, "\x1b[1;5A"},
+		{"PageUp", KeyMode{CursorKeys: true}, "\x1b[5~"},
+		{"KP7", legacy, "7"},
+		{"KPEnter", legacy, "\r"},
+		{"KP7", KeyMode{Keypad: true}, "\x1bOw"},
+		{"KP-", KeyMode{Keypad: true}, "\x1bOm"},
+		{"KPEnter", KeyMode{Keypad: true}, "\x1bOM"},
+		{"M-KP5", KeyMode{Keypad: true}, "\x1b5"},
+	} {
+		k, err := ParseKey(c.name)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := string(k.Encode(c.mode)); got != c.want {
+			t.Errorf("%s in %s = %q, want %q", c.name, c.mode, got, c.want)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, name := range []string{"X-a", "C-", "Foo", "C-Foo", "ab"} {
+		if _, err := ParseKey(name); err == nil {
+			t.Errorf("%q accepted", name)
+		}
+	}
+	for name, want := range map[string]string{"S-C-Left": "C-S-Left", "M-C-x": "C-M-x", "S-Tab": "BTab", "BTab": "BTab", "C-BTab": "C-S-Tab", "KP7": "KP7"} {
+		k, err := ParseKey(name)
+		if err != nil || k.String() != want {
+			t.Errorf("ParseKey(%q).String() = %q, %v; want %q", name, k.String(), err, want)
+		}
+	}
+	for name, want := range map[string]bool{"C-c": true, "F5": true, "Enter": true, "a": false, "ls": false, "é": false, "M-é": true} {
+		if got := IsKeyName(name); got != want {
+			t.Errorf("IsKeyName(%q) = %v", name, got)
+		}
+	}
+}
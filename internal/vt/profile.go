@@ -0,0 +1,59 @@
+package vt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profile selects which escape sequences survive in captured output.
+type Profile string
+
+const (
+	// ProfileRaw keeps every sequence as the program wrote it.
+	ProfileRaw Profile = "raw"
+	// ProfileText removes every sequence, like Strip.
+	ProfileText Profile = "text"
+	// ProfileSGR keeps colors and text attributes (CSI ... m) and drops
+	// cursor movement, erasing, modes and OSC.
+	ProfileSGR Profile = "sgr"
+	// ProfileNoOSC drops OSC sequences (titles, cwd reports, hyperlink
+	// targets) and keeps the rest. The text of a hyperlink stays, since
+	// it sits between the OSC 8 sequences.
+	ProfileNoOSC Profile = "no-osc"
+)
+
+// ParseProfile validates a profile name.
+func ParseProfile(s string) (Profile, error) {
+	switch p := Profile(s); p {
+	case ProfileRaw, ProfileText, ProfileSGR, ProfileNoOSC:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown strip profile %q (expected raw, text, sgr or no-osc)", s)
+}
+
+// Apply filters the escape sequences in s according to p.
+func Apply(s string, p Profile) string {
+	switch p {
+	case ProfileRaw:
+		return s
+	case ProfileText:
+		return Strip(s)
+	}
+	return escapePattern.ReplaceAllStringFunc(s, func(seq string) string {
+		if keep(seq, p) {
+			return seq
+		}
+		return ""
+	})
+}
+
+func keep(seq string, p Profile) bool {
+	osc := strings.HasPrefix(seq, "\x1b]")
+	switch p {
+	case ProfileNoOSC:
+		return !osc
+	case ProfileSGR:
+		return strings.HasPrefix(seq, "\x1b[") && strings.HasSuffix(seq, "m") && !strings.Contains(seq, "?")
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package vt
+
+import "testing"
+
+const sample = "\x1b]0;title\x07\x1b[2J\x1b[H\x1b[1;31mred\x1b[0m \x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\\x1b[?25h"
+
+func TestApplyProfiles(t *testing.T) {
+	tests := []struct {
+		p    Profile
+		want string
+	}{
+		{ProfileRaw, sample},
+		{ProfileText, "red link"},
+		{ProfileSGR, "\x1b[1;31mred\x1b[0m link"},
+		{ProfileNoOSC, "\x1b[2J\x1b[H\x1b[1;31mred\x1b[0m link\x1b[?25h"},
+	}
+	for _, tt := range tests {
+		if got := Apply(sample, tt.p); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	if _, err := ParseProfile("sgr"); err != nil {
+		t.Errorf("sgr: %v", err)
+	}
+	if _, err := ParseProfile("colour"); err == nil {
+		t.Error("expected error for unknown profile")
+	}
+}
@@ -0,0 +1,75 @@
+// Package winpath converts the path forms orchestrators hand to -S and
+// -c between Windows and WSL: C:\dir, C:/dir with mixed separators,
+// drive-relative C:dir and /mnt/c/dir.
+package winpath
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Normalize returns p in this platform's form: ToWindows on Windows,
+// with a drive-relative path resolved against that drive's current
+// directory, and ToPosix elsewhere.
+func Normalize(p string) string {
+	if runtime.GOOS != "windows" {
+		return ToPosix(p)
+	}
+	p = ToWindows(p)
+	if driveRelative(p) {
+		if abs, err := filepath.Abs(p); err == nil {
+			return abs
+		}
+	}
+	return p
+}
+
+// ToWindows converts a WSL mount path (/mnt/c/dir) to C:\dir and every
+// forward slash to a backslash. Other paths keep their form.
+func ToWindows(p string) string {
+	if drive, rest, ok := mountPath(p); ok {
+		p = strings.ToUpper(drive) + ":\\" + rest
+	}
+	return strings.ReplaceAll(p, "/", "\\")
+}
+
+// ToPosix converts a Windows drive path (C:\dir, C:/dir or
+// drive-relative C:dir, taken from the drive's root) to its WSL mount,
+// /mnt/c/dir. Other paths are returned as they are: a backslash is a
+// valid file name character there.
+func ToPosix(p string) string {
+	if !hasDrive(p) {
+		return p
+	}
+	rest := strings.TrimLeft(strings.ReplaceAll(p[2:], "\\", "/"), "/")
+	mount := "/mnt/" + strings.ToLower(p[:1])
+	if rest == "" {
+		return mount
+	}
+	return mount + "/" + rest
+}
+
+// mountPath splits /mnt/<drive>/rest, a Windows drive as WSL mounts it,
+// into the drive letter and the rest.
+func mountPath(p string) (drive, rest string, ok bool) {
+	after, found := strings.CutPrefix(p, "/mnt/")
+	if !found || len(after) == 0 || !isLetter(after[0]) || len(after) > 1 && after[1] != '/' {
+		return "", "", false
+	}
+	return after[:1], strings.TrimPrefix(after[1:], "/"), true
+}
+
+func hasDrive(p string) bool {
+	return len(p) >= 2 && isLetter(p[0]) && p[1] == ':'
+}
+
+// driveRelative reports whether p names a drive but not its root, as
+// C:dir does.
+func driveRelative(p string) bool {
+	return hasDrive(p) && (len(p) == 2 || p[2] != '\\' && p[2] != '/')
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
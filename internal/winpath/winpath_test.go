@@ -0,0 +1,41 @@
+package winpath
+
+import "testing"
+
+func TestToWindows(t *testing.T) {
+	for in, want := range map[string]string{
+		"/mnt/c/Users/me/work": `C:\Users\me\work`,
+		"/mnt/d":               `D:\`,
+		"C:/repo\\src/main":    `C:\repo\src\main`,
+		"//server/share/x":     `\\server\share\x`,
+		"logs/a.sock":          `logs\a.sock`,
+		"/mnt/cdrom/x":         `\mnt\cdrom\x`,
+	} {
+		if got := ToWindows(in); got != want {
+			t.Errorf("ToWindows(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestToPosix(t *testing.T) {
+	for in, want := range map[string]string{
+		`C:\Users\me\work`: "/mnt/c/Users/me/work",
+		"D:/repo\\src":     "/mnt/d/repo/src",
+		"C:repo":           "/mnt/c/repo",
+		`E:\`:              "/mnt/e",
+		"/home/me":         "/home/me",
+		`odd\name`:         `odd\name`,
+	} {
+		if got := ToPosix(in); got != want {
+			t.Errorf("ToPosix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDriveRelative(t *testing.T) {
+	for p, want := range map[string]bool{"C:": true, "C:repo": true, `C:\repo`: false, "C:/repo": false, "repo": false} {
+		if got := driveRelative(p); got != want {
+			t.Errorf("driveRelative(%q) = %v", p, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package workspace loads the workspace files used by `wintmux up`,
+// `down` and `status`: a set of named sessions created, checked and
+// killed as a unit, such as the agents of a test matrix.
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultFile is the workspace file used when none is named.
+const DefaultFile = "wintmux.json"
+
+// maxSessions bounds the sessions one workspace may expand to.
+const maxSessions = 1000
+
+// Session describes one session of a workspace, as new-session would
+// be told to create it.
+type Session struct {
+	Name     string            `json:"name"`
+	Command  string            `json:"command,omitempty"`
+	StartDir string            `json:"start_dir,omitempty"`
+	Backend  string            `json:"backend,omitempty"`
+	Template string            `json:"template,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+
+	// Socket is the session's control file; it defaults to NAME.sock in
+	// the workspace's socket directory.
+	Socket string `json:"socket,omitempty"`
+
+	// Count makes this entry N sessions, NAME-1 to NAME-N, each with the
+	// template variable index set to its number.
+	Count int `json:"count,omitempty"`
+}
+
+// Workspace is a parsed workspace file, with Count entries expanded and
+// socket paths filled in.
+type Workspace struct {
+	// SocketDir holds the control files of sessions that do not name
+	// one; relative to the workspace file, which is also the default.
+	SocketDir string    `json:"socket_dir,omitempty"`
+	Sessions  []Session `json:"sessions"`
+}
+
+// Load reads the workspace file at path.
+func Load(path string) (*Workspace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var w Workspace
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := w.expand(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &w, nil
+}
+
+// expand checks the sessions, replaces each Count entry with its
+// sessions and fills in socket paths. Relative paths are taken from dir,
+// the workspace file's directory.
+func (w *Workspace) expand(dir string) error {
+	if len(w.Sessions) == 0 {
+		return fmt.Errorf("no sessions")
+	}
+	socketDir := dir
+	if w.SocketDir != "" {
+		socketDir = w.SocketDir
+		if !filepath.IsAbs(socketDir) {
+			socketDir = filepath.Join(dir, socketDir)
+		}
+	}
+	w.SocketDir = socketDir
+
+	var sessions []Session
+	for i, s := range w.Sessions {
+		if s.Name == "" {
+			return fmt.Errorf("session %d has no name", i+1)
+		}
+		if strings.ContainsAny(s.Name, `/\:`) {
+			return fmt.Errorf("invalid session name %q", s.Name)
+		}
+		if s.Count < 0 || s.Count > maxSessions {
+			return fmt.Errorf("session %s: invalid count %d", s.Name, s.Count)
+		}
+		if len(s.Vars) > 0 && s.Template == "" {
+			return fmt.Errorf("session %s: vars need a template", s.Name)
+		}
+		if s.Count > 0 && s.Socket != "" {
+			return fmt.Errorf("session %s: socket cannot be combined with count", s.Name)
+		}
+		copies := []Session{s}
+		if s.Count > 0 {
+			copies = copies[:0]
+			for i := 1; i <= s.Count; i++ {
+				c := s
+				c.Name = s.Name + "-" + strconv.Itoa(i)
+				c.Vars = map[string]string{"index": strconv.Itoa(i)}
+				for k, v := range s.Vars {
+					c.Vars[k] = v
+				}
+				copies = append(copies, c)
+			}
+		}
+		for _, c := range copies {
+			c.Count = 0
+			if c.Socket == "" {
+				c.Socket = filepath.Join(socketDir, c.Name+".sock")
+			} else if !filepath.IsAbs(c.Socket) {
+				c.Socket = filepath.Join(dir, c.Socket)
+			}
+			if c.StartDir != "" && !filepath.IsAbs(c.StartDir) && c.Backend == "" {
+				c.StartDir = filepath.Join(dir, c.StartDir)
+			}
+			sessions = append(sessions, c)
+		}
+		if len(sessions) > maxSessions {
+			return fmt.Errorf("more than %d sessions", maxSessions)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range sessions {
+		if seen[s.Name] {
+			return fmt.Errorf("duplicate session name %s", s.Name)
+		}
+		seen[s.Name] = true
+	}
+	w.Sessions = sessions
+	return nil
+}
+
+// Args returns the new-session arguments that create s.
+func (s Session) Args() []string {
+	args := []string{"-S", s.Socket, "new-session", "-d", "-s", s.Name}
+	if s.StartDir != "" {
+		args = append(args, "-c", s.StartDir)
+	}
+	if s.Backend != "" {
+		args = append(args, "--backend", s.Backend)
+	}
+	if s.Template != "" {
+		args = append(args, "--template", s.Template)
+		names := make([]string, 0, len(s.Vars))
+		for name := range s.Vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			args = append(args, "--var", name+"="+s.Vars[name])
+		}
+	}
+	if s.Command != "" {
+		args = append(args, "--", s.Command)
+	}
+	return args
+}
@@ -0,0 +1,70 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeWorkspace(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wintmux.json")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeWorkspace(t, `{
+		"socket_dir": "sock",
+		"sessions": [
+			{"name": "build", "command": "make watch", "start_dir": "src"},
+			{"name": "agent", "count": 2, "template": "agent", "vars": {"model": "m1"}}
+		]
+	}`)
+	dir := filepath.Dir(path)
+	w, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range w.Sessions {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, " ") != "build agent-1 agent-2" {
+		t.Fatalf("sessions = %v", names)
+	}
+	if got, want := w.Sessions[0].Args(), []string{
+		"-S", filepath.Join(dir, "sock", "build.sock"), "new-session", "-d", "-s", "build",
+		"-c", filepath.Join(dir, "src"), "--", "make watch",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("build args = %q", got)
+	}
+	if got, want := w.Sessions[2].Args(), []string{
+		"-S", filepath.Join(dir, "sock", "agent-2.sock"), "new-session", "-d", "-s", "agent-2",
+		"--template", "agent", "--var", "index=2", "--var", "model=m1",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("agent-2 args = %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, text := range []string{
+		`{"sessions": []}`,
+		`{"sessions": [{"command": "x"}]}`,
+		`{"sessions": [{"name": "a/b"}]}`,
+		`{"sessions": [{"name": "a"}, {"name": "a"}]}`,
+		`{"sessions": [{"name": "a", "count": 2}, {"name": "a-2"}]}`,
+		`{"sessions": [{"name": "a", "count": 2, "socket": "a.sock"}]}`,
+		`{"sessions": [{"name": "a", "vars": {"x": "y"}}]}`,
+		`{"sessions": [{"name": "a", "count": 1001}]}`,
+		`{"sessions": `,
+	} {
+		if _, err := Load(writeWorkspace(t, text)); err == nil {
+			t.Errorf("Load(%s) succeeded", text)
+		}
+	}
+}